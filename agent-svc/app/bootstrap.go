@@ -2,14 +2,23 @@ package app
 
 import (
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"agent-svc/app/clients"
+	"agent-svc/app/grpcapi"
 	"agent-svc/app/handlers"
+	"agent-svc/app/metrics"
+	"agent-svc/app/openapi"
 	"agent-svc/app/services"
+	"agent-svc/app/tracing"
+	"agent-svc/client"
+	"agent-svc/storage/memory"
 	"agent-svc/storage/postgres"
+	"agent-svc/storage/sqlite"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -17,6 +26,7 @@ import (
 	postgresdriver "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"google.golang.org/grpc"
 )
 
 // App represents the application
@@ -27,6 +37,12 @@ type App struct {
 	CommandService *services.CommandService
 	LogService     *services.LogService
 	Router         *gin.Engine
+
+	// GRPCServer serves the gRPC API through the same services as Router
+	GRPCServer *grpc.Server
+
+	// ShutdownTracing flushes buffered spans to the exporter
+	ShutdownTracing func(context.Context) error
 }
 
 // Bootstrap initializes the application
@@ -36,40 +52,110 @@ func Bootstrap() (*App, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	connString := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode,
-	)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.TraceExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
 
-	store, err := postgres.NewStore(connString)
+	store, schemaVersion, err := OpenStorage(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+		return nil, err
 	}
 
-	if err := runMigrations(connString); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	// A policy that fails to load at startup is fatal rather than leaving every command allowed
+	policyService := services.NewCommandPolicyService(store, cfg.CommandPolicyFile)
+	if _, err := policyService.Reload(context.Background()); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to load command policy: %w", err)
+	}
+
+	var signingKey ed25519.PrivateKey
+	if cfg.CommandSigningKey != "" {
+		signingKey, err = services.ParseCommandSigningKey(cfg.CommandSigningKey)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
 	}
 
 	jwtService := services.NewJWTService(cfg.JWTSecret, cfg.JWTExpirationSec)
-	commandService := services.NewCommandService(store)
-	logService := services.NewLogService(store)
+	commandService := services.NewCommandService(store, policyService, services.CommandServiceConfig{
+		DefaultMaxOutputBytes: cfg.DefaultMaxOutputBytes,
+		MaxOutputBytes:        cfg.MaxOutputBytes,
+		IdempotencyKeyTTL:     time.Duration(cfg.IdempotencyKeyTTLSec) * time.Second,
+		SigningKey:            signingKey,
+		SignatureTTL:          time.Duration(cfg.CommandSignatureTTLSec) * time.Second,
+	})
+	if key := commandService.SigningKey(); key != nil {
+		fmt.Printf("signing dispatched commands, public key %s\n", client.EncodeCommandSigningKey(key))
+	} else {
+		fmt.Println("command signing is disabled: set COMMAND_SIGNING_KEY so nodes can verify the commands they receive")
+	}
+	logService := services.NewLogService(store, cfg.MaxOutputBytes)
+	templateService := services.NewTemplateService(store)
+	tenantService := services.NewTenantService(store, cfg.TenantAdminOperators)
+	scheduleService := services.NewScheduleService(store, commandService)
+	workflowService := services.NewWorkflowService(store, commandService)
+	rolloutService := services.NewRolloutService(store, commandService)
+	webhookService := services.NewWebhookService(store, services.WebhookServiceConfig{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Timeout:     time.Duration(cfg.WebhookTimeoutSec) * time.Second,
+	})
+	approvalService := services.NewApprovalService(store)
+
+	workers := services.NewWorkerTracker()
+	healthService := services.NewHealthService(store, workers, services.HealthServiceConfig{
+		ExpectedSchemaVersion: schemaVersion,
+		DBTimeout:             time.Duration(cfg.ReadyDBTimeoutSec) * time.Second,
+	})
+
+	healthHandler := handlers.NewHealthHandler(healthService)
+	agentHandler := handlers.NewAgentHandler(jwtService, tenantService, store, commandService.SigningKey())
+	commandHandler := handlers.NewCommandHandler(commandService, logService, templateService, jwtService, store)
+	scheduleHandler := handlers.NewScheduleHandler(scheduleService)
+	workflowHandler := handlers.NewWorkflowHandler(workflowService)
+	rolloutHandler := handlers.NewRolloutHandler(rolloutService)
+	templateHandler := handlers.NewTemplateHandler(templateService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	approvalHandler := handlers.NewApprovalHandler(approvalService, commandService)
+	policyHandler := handlers.NewPolicyHandler(policyService, store)
+	tenantHandler := handlers.NewTenantHandler(tenantService)
+	openapiHandler := handlers.NewOpenAPIHandler(openapi.Spec())
 
-	agentHandler := handlers.NewAgentHandler(jwtService, store)
-	commandHandler := handlers.NewCommandHandler(commandService, logService, jwtService, store)
+	metrics.Registry.MustRegister(metrics.NewStateCollector(store))
+	if pgStore, ok := store.(*postgres.Store); ok {
+		metrics.Registry.MustRegister(metrics.NewPoolCollector(pgStore.PoolStat))
+	}
 
 	router := gin.Default()
+	router.Use(metrics.Middleware())
+	router.Use(tracing.Middleware())
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000", "http://127.0.0.1:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "X-Tenant-ID", "traceparent", "tracestate"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	setupRoutes(router, agentHandler, commandHandler)
+	setupRoutes(router, jwtService, tenantService, healthHandler, agentHandler, commandHandler, scheduleHandler, workflowHandler, rolloutHandler,
+		templateHandler, webhookHandler, approvalHandler, policyHandler, tenantHandler, openapiHandler, cfg.MaxRequestBodyBytes)
+
+	grpcServer := grpcapi.NewServer(commandService, logService, templateService, tenantService, jwtService, store)
 
-	go startCleanupJob(store, cfg.LogRetentionDays)
+	go startCleanupJob(workers, store, cfg.LogRetentionDays)
+	go startExpirySweeper(workers, commandService, cfg.ExpirySweepIntervalSec)
+	go startRetryScheduler(workers, commandService, cfg.RetrySchedulerIntervalSec)
+	go startScheduleRunner(workers, scheduleService, cfg.ScheduleRunnerIntervalSec)
+	go startWorkflowReconciler(workers, workflowService, cfg.WorkflowReconcileIntervalSec)
+	go startRolloutController(workers, rolloutService, cfg.RolloutControllerIntervalSec)
+	go startWebhookWorker(workers, webhookService, cfg.WebhookWorkerIntervalSec)
+	go startNodeMonitor(workers, store, commandService, time.Duration(cfg.NodeOfflineAfterSec)*time.Second, cfg.NodeMonitorIntervalSec)
+	go startPolicyReloader(workers, policyService, cfg.CommandPolicyReloadSec)
 
 	app := &App{
 		Config:         cfg,
@@ -78,11 +164,49 @@ func Bootstrap() (*App, error) {
 		CommandService: commandService,
 		LogService:     logService,
 		Router:         router,
+		GRPCServer:     grpcServer,
+
+		ShutdownTracing: shutdownTracing,
 	}
 
 	return app, nil
 }
 
+// OpenStorage creates the store selected by cfg.StorageBackend, migrated to the current schema, and returns it
+// with the migration version it should report
+func OpenStorage(cfg *Config) (clients.StorageAdapter, uint, error) {
+	factory := services.NewStorageFactory()
+
+	switch cfg.StorageBackend {
+	case StorageMemory:
+		return factory.CreateMemoryStore(), memory.SchemaVersion, nil
+
+	case StorageSQLite:
+		// The SQLite store applies its embedded migrations when it opens the database
+		store, err := factory.CreateSQLiteStore(cfg.SQLitePath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to initialize storage: %w", err)
+		}
+		return store, sqlite.SchemaVersion, nil
+	}
+
+	connString := fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode,
+	)
+
+	store, err := factory.CreatePostgresStore(connString)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	if err := runMigrations(connString); err != nil {
+		store.Close()
+		return nil, 0, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return store, postgres.SchemaVersion, nil
+}
+
 // runMigrations runs database migrations
 func runMigrations(connString string) error {
 	db, err := sql.Open("pgx", connString)
@@ -115,37 +239,331 @@ func runMigrations(connString string) error {
 }
 
 // setupRoutes configures HTTP routes
-func setupRoutes(router *gin.Engine, agentHandler *handlers.AgentHandler, commandHandler *handlers.CommandHandler) {
-	healthHandler := handlers.NewHealthHandler()
+// Operator routes take an operator token. Nodes, commands, schedules, workflows, rollouts, templates and
+// webhooks belong to a tenant, and their routes are scoped to the operator's tenant. Approval policies and the
+// command policy apply to every tenant: any operator of a tenant may read them, but changing them, like
+// managing tenants, takes a tenant admin. Request bodies of /v1 routes are limited to maxRequestBodyBytes,
+// and JSON ones are validated against the OpenAPI document, so every route needs an entry in openapi.Routes.
+func setupRoutes(
+	router *gin.Engine,
+	jwtService *services.JWTService,
+	tenantService *services.TenantService,
+	healthHandler *handlers.HealthHandler,
+	agentHandler *handlers.AgentHandler,
+	commandHandler *handlers.CommandHandler,
+	scheduleHandler *handlers.ScheduleHandler,
+	workflowHandler *handlers.WorkflowHandler,
+	rolloutHandler *handlers.RolloutHandler,
+	templateHandler *handlers.TemplateHandler,
+	webhookHandler *handlers.WebhookHandler,
+	approvalHandler *handlers.ApprovalHandler,
+	policyHandler *handlers.PolicyHandler,
+	tenantHandler *handlers.TenantHandler,
+	openapiHandler *handlers.OpenAPIHandler,
+	maxRequestBodyBytes int64,
+) {
+	scoped := handlers.TenantScope(tenantService)
+	admin := handlers.RequireTenantAdmin(tenantService)
+
 	router.GET("/health", healthHandler.Health)
 	router.GET("/ready", healthHandler.Ready)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
-	v1 := router.Group("/v1")
+	v1 := router.Group("/v1", handlers.ValidateRequestBody(openapi.Spec(), maxRequestBodyBytes))
 	{
+		v1.GET("/openapi.json", openapiHandler.Spec)
+
 		v1.POST("/agents/register", agentHandler.Register)
 		v1.POST("/agents/heartbeat", agentHandler.Heartbeat)
-		v1.GET("/agents", agentHandler.ListNodes)
-
-		v1.POST("/commands/submit", commandHandler.SubmitCommand)
-		v1.GET("/commands", commandHandler.ListCommands)
-		v1.DELETE("/commands/queued", commandHandler.DeleteQueuedCommands)
 		v1.GET("/commands/next", commandHandler.GetNextCommand)
 		v1.POST("/commands/logs", commandHandler.PushCommandLogs)
 		v1.POST("/commands/status", commandHandler.UpdateCommandStatus)
-		v1.GET("/commands/:command_id/logs", commandHandler.GetCommandLogs)
+
+		// Every other route is an operator's
+		ops := v1.Group("", handlers.AuthenticateOperator(jwtService))
+		ops.GET("/agents", scoped, agentHandler.ListNodes)
+		ops.GET("/agents/:node_id", scoped, agentHandler.GetNode)
+		ops.PATCH("/agents/:node_id", scoped, agentHandler.UpdateNode)
+
+		ops.POST("/commands/submit", scoped, commandHandler.SubmitCommand)
+		ops.GET("/commands", scoped, commandHandler.ListCommands)
+		ops.DELETE("/commands/queued", scoped, commandHandler.DeleteQueuedCommands)
+		ops.GET("/commands/:command_id", scoped, commandHandler.GetCommand)
+		ops.GET("/commands/:command_id/logs", scoped, commandHandler.GetCommandLogs)
+		ops.GET("/commands/:command_id/history", scoped, commandHandler.GetCommandHistory)
+		ops.POST("/commands/:command_id/cancel", scoped, commandHandler.CancelCommand)
+		ops.GET("/commands/:command_id/approval", scoped, approvalHandler.GetCommandApproval)
+		ops.POST("/commands/:command_id/approve", scoped, approvalHandler.ApproveCommand)
+		ops.POST("/commands/:command_id/reject", scoped, approvalHandler.RejectCommand)
+		ops.GET("/approvals", scoped, approvalHandler.ListPendingApprovals)
+
+		ops.POST("/schedules", scoped, scheduleHandler.CreateSchedule)
+		ops.GET("/schedules", scoped, scheduleHandler.ListSchedules)
+		ops.GET("/schedules/:schedule_id", scoped, scheduleHandler.GetSchedule)
+		ops.DELETE("/schedules/:schedule_id", scoped, scheduleHandler.DeleteSchedule)
+		ops.POST("/schedules/:schedule_id/pause", scoped, scheduleHandler.PauseSchedule)
+		ops.POST("/schedules/:schedule_id/resume", scoped, scheduleHandler.ResumeSchedule)
+		ops.POST("/schedules/:schedule_id/run", scoped, scheduleHandler.RunSchedule)
+		ops.GET("/schedules/:schedule_id/runs", scoped, scheduleHandler.ListScheduleRuns)
+
+		ops.POST("/workflows", scoped, workflowHandler.CreateWorkflow)
+		ops.GET("/workflows", scoped, workflowHandler.ListWorkflows)
+		ops.GET("/workflows/:workflow_id", scoped, workflowHandler.GetWorkflow)
+
+		ops.POST("/rollouts", scoped, rolloutHandler.CreateRollout)
+		ops.GET("/rollouts", scoped, rolloutHandler.ListRollouts)
+		ops.GET("/rollouts/:rollout_id", scoped, rolloutHandler.GetRollout)
+		ops.POST("/rollouts/:rollout_id/pause", scoped, rolloutHandler.PauseRollout)
+		ops.POST("/rollouts/:rollout_id/resume", scoped, rolloutHandler.ResumeRollout)
+		ops.POST("/rollouts/:rollout_id/abort", scoped, rolloutHandler.AbortRollout)
+
+		ops.POST("/templates", scoped, templateHandler.CreateTemplate)
+		ops.GET("/templates", scoped, templateHandler.ListTemplates)
+		ops.GET("/templates/:name", scoped, templateHandler.GetTemplate)
+		ops.GET("/templates/:name/versions", scoped, templateHandler.ListTemplateVersions)
+
+		ops.POST("/webhooks", scoped, webhookHandler.CreateWebhook)
+		ops.GET("/webhooks", scoped, webhookHandler.ListWebhooks)
+		ops.GET("/webhooks/:webhook_id", scoped, webhookHandler.GetWebhook)
+		ops.DELETE("/webhooks/:webhook_id", scoped, webhookHandler.DeleteWebhook)
+		ops.POST("/webhooks/:webhook_id/enable", scoped, webhookHandler.EnableWebhook)
+		ops.POST("/webhooks/:webhook_id/disable", scoped, webhookHandler.DisableWebhook)
+		ops.GET("/webhooks/:webhook_id/deliveries", scoped, webhookHandler.ListWebhookDeliveries)
+		ops.POST("/webhooks/:webhook_id/deliveries/:delivery_id/retry", scoped, webhookHandler.RetryWebhookDelivery)
+
+		ops.POST("/approval-policies", admin, approvalHandler.CreatePolicy)
+		ops.GET("/approval-policies", scoped, approvalHandler.ListPolicies)
+		ops.GET("/approval-policies/:policy_id", scoped, approvalHandler.GetPolicy)
+		ops.DELETE("/approval-policies/:policy_id", admin, approvalHandler.DeletePolicy)
+
+		ops.GET("/policies", scoped, policyHandler.GetPolicy)
+		ops.PUT("/policies", admin, policyHandler.UpdatePolicy)
+		ops.POST("/policies/evaluate", scoped, policyHandler.EvaluatePolicy)
+
+		ops.POST("/tenants", admin, tenantHandler.CreateTenant)
+		ops.GET("/tenants", admin, tenantHandler.ListTenants)
+		ops.GET("/tenants/:tenant_id", admin, tenantHandler.GetTenant)
+		ops.PATCH("/tenants/:tenant_id", admin, tenantHandler.UpdateTenant)
+		ops.POST("/tenants/:tenant_id/enrollment-key", admin, tenantHandler.RotateEnrollmentKey)
+		ops.GET("/tenants/:tenant_id/operators", admin, tenantHandler.ListTenantOperators)
+		ops.POST("/tenants/:tenant_id/operators", admin, tenantHandler.AddTenantOperator)
+		ops.DELETE("/tenants/:tenant_id/operators/:operator_id", admin, tenantHandler.RemoveTenantOperator)
 	}
 }
 
+// Background worker names reported by the readiness check
+const (
+	workerCleanup            = "cleanup"
+	workerExpirySweeper      = "expiry_sweeper"
+	workerRetryScheduler     = "retry_scheduler"
+	workerScheduleRunner     = "schedule_runner"
+	workerWorkflowReconciler = "workflow_reconciler"
+	workerRolloutController  = "rollout_controller"
+	workerWebhookWorker      = "webhook_worker"
+	workerNodeMonitor        = "node_monitor"
+	workerPolicyReloader     = "policy_reloader"
+)
+
 // startCleanupJob runs periodic cleanup
-func startCleanupJob(storage clients.StorageAdapter, retentionDays int) {
-	ticker := time.NewTicker(24 * time.Hour)
+func startCleanupJob(workers *services.WorkerTracker, storage clients.StorageAdapter, retentionDays int) {
+	interval := 24 * time.Hour
+	workers.Register(workerCleanup, interval)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		var errs []error
 		if err := storage.CleanupOldLogs(ctx, retentionDays); err != nil {
 			fmt.Printf("cleanup job failed: %v\n", err)
+			errs = append(errs, err)
+		}
+		if err := storage.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+			fmt.Printf("idempotency key cleanup failed: %v\n", err)
+			errs = append(errs, err)
+		}
+		if err := storage.DeleteOldWebhookEvents(ctx, retentionDays); err != nil {
+			fmt.Printf("webhook event cleanup failed: %v\n", err)
+			errs = append(errs, err)
+		}
+		workers.Report(workerCleanup, errors.Join(errs...))
+		cancel()
+	}
+}
+
+// startExpirySweeper periodically moves queued commands past their deadline and commands whose approval
+// request nobody decided on in time to expired
+func startExpirySweeper(workers *services.WorkerTracker, commandService *services.CommandService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerExpirySweeper, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var errs []error
+		count, err := commandService.ExpireQueuedCommands(ctx)
+		if err != nil {
+			fmt.Printf("expiry sweeper failed: %v\n", err)
+			errs = append(errs, err)
+		} else if count > 0 {
+			fmt.Printf("expiry sweeper expired %d queued commands\n", count)
+		}
+		count, err = commandService.ExpireApprovalRequests(ctx)
+		if err != nil {
+			fmt.Printf("approval expiry failed: %v\n", err)
+			errs = append(errs, err)
+		} else if count > 0 {
+			fmt.Printf("expiry sweeper expired %d approval requests\n", count)
+		}
+		workers.Report(workerExpirySweeper, errors.Join(errs...))
+		cancel()
+	}
+}
+
+// startRetryScheduler periodically queues the next attempt of failed commands whose retry is due
+func startRetryScheduler(workers *services.WorkerTracker, commandService *services.CommandService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerRetryScheduler, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		count, err := commandService.CreateRetryAttempts(ctx)
+		if err != nil {
+			fmt.Printf("retry scheduler failed: %v\n", err)
+		} else if count > 0 {
+			fmt.Printf("retry scheduler queued %d retry attempts\n", count)
+		}
+		workers.Report(workerRetryScheduler, err)
+		cancel()
+	}
+}
+
+// startScheduleRunner periodically fires schedules whose next fire time has passed
+func startScheduleRunner(workers *services.WorkerTracker, scheduleService *services.ScheduleService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerScheduleRunner, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		_, err := scheduleService.RunDueSchedules(ctx)
+		if err != nil {
+			fmt.Printf("schedule runner failed: %v\n", err)
+		}
+		workers.Report(workerScheduleRunner, err)
+		cancel()
+	}
+}
+
+// startWorkflowReconciler periodically advances running workflows, covering command outcomes reported
+// while agent-svc was restarting and steps whose engine stopped mid-way
+func startWorkflowReconciler(workers *services.WorkerTracker, workflowService *services.WorkflowService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerWorkflowReconciler, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		err := workflowService.ReconcileWorkflows(ctx)
+		if err != nil {
+			fmt.Printf("workflow reconciler failed: %v\n", err)
+		}
+		workers.Report(workerWorkflowReconciler, err)
+		cancel()
+	}
+}
+
+// startRolloutController periodically advances running and paused rollouts, starting batches whose
+// pause has passed
+func startRolloutController(workers *services.WorkerTracker, rolloutService *services.RolloutService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerRolloutController, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		err := rolloutService.ReconcileRollouts(ctx)
+		if err != nil {
+			fmt.Printf("rollout controller failed: %v\n", err)
+		}
+		workers.Report(workerRolloutController, err)
+		cancel()
+	}
+}
+
+// startWebhookWorker periodically fans out outbox events to webhook subscriptions and sends due deliveries
+func startWebhookWorker(workers *services.WorkerTracker, webhookService *services.WebhookService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerWebhookWorker, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		_, err := webhookService.DeliverWebhooks(ctx)
+		if err != nil {
+			fmt.Printf("webhook worker failed: %v\n", err)
+		}
+		workers.Report(workerWebhookWorker, err)
+		cancel()
+	}
+}
+
+// startNodeMonitor periodically reports nodes that stopped sending heartbeats as offline and finishes the
+// commands they were running as lost
+func startNodeMonitor(workers *services.WorkerTracker, storage clients.StorageAdapter, commandService *services.CommandService, offlineAfter time.Duration, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerNodeMonitor, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var errs []error
+		count, err := storage.MarkOfflineNodes(ctx, offlineAfter)
+		if err != nil {
+			fmt.Printf("node monitor failed: %v\n", err)
+			errs = append(errs, err)
+		} else if count > 0 {
+			fmt.Printf("node monitor reported %d nodes offline\n", count)
+		}
+		count, err = commandService.MarkLostCommands(ctx, offlineAfter)
+		if err != nil {
+			fmt.Printf("lost command reaper failed: %v\n", err)
+			errs = append(errs, err)
+		}
+		if count > 0 {
+			fmt.Printf("node monitor marked %d commands lost\n", count)
+		}
+		workers.Report(workerNodeMonitor, errors.Join(errs...))
+		cancel()
+	}
+}
+
+// startPolicyReloader periodically reloads the command policy, picking up edits of the policy file or
+// changes made through another replica
+func startPolicyReloader(workers *services.WorkerTracker, policyService *services.CommandPolicyService, intervalSec int) {
+	interval := time.Duration(intervalSec) * time.Second
+	workers.Register(workerPolicyReloader, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		changed, err := policyService.Reload(ctx)
+		if err != nil {
+			fmt.Printf("policy reloader failed: %v\n", err)
+		} else if changed {
+			p := policyService.Policy()
+			fmt.Printf("policy reloader loaded command policy version %d from %s with %d rules\n", p.Version, p.Source, len(p.Rules))
 		}
+		workers.Report(workerPolicyReloader, err)
 		cancel()
 	}
 }
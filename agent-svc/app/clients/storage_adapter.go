@@ -2,6 +2,7 @@ package clients
 
 import (
 	"context"
+	"time"
 
 	"agent-svc/app/domains"
 
@@ -9,19 +10,114 @@ import (
 )
 
 // StorageAdapter defines the interface for storage operations
+// It is implemented by the Postgres, SQLite and in-memory stores; storage/conformance checks that they behave alike.
+// Methods taking a tenantID scope their result to the nodes of that tenant, or to the schedules, workflows,
+// rollouts and webhooks it owns; an empty tenantID means every tenant. Templates are named within a tenant, so
+// GetCommandTemplate and ListCommandTemplateVersions need one.
 type StorageAdapter interface {
-	RegisterNode(ctx context.Context, nodeID string, attrs map[string]interface{}) error
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+	Close()
+
+	RegisterNode(ctx context.Context, tenantID, nodeID string, attrs map[string]interface{}) error
 	UpdateNodeLastSeen(ctx context.Context, nodeID string) error
 	GetNode(ctx context.Context, nodeID string) (*domains.Node, error)
-	CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}) (uuid.UUID, error)
+	UpdateNodeLabels(ctx context.Context, nodeID string, set map[string]string, remove []string) (bool, error)
+	SetNodeDisabled(ctx context.Context, nodeID string, disabled bool) (bool, error)
+	SetNodePolicyHash(ctx context.Context, nodeID, hash string) (bool, error)
+	CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}, opts domains.CommandOptions) (uuid.UUID, error)
+	GetIdempotencyKey(ctx context.Context, operatorID, nodeID, key string) (*domains.IdempotencyKey, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context) error
 	GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error)
-	UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string) error
+	UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string, source string) error
+	GetCommandStatusHistory(ctx context.Context, commandID uuid.UUID) ([]domains.CommandStatusChange, error)
+	ExpireQueuedCommands(ctx context.Context) (int, error)
+	ListLostCommands(ctx context.Context, lostAfter time.Duration, limit int) ([]*domains.NodeCommand, error)
+	UpdateCommandOutput(ctx context.Context, commandID uuid.UUID, output domains.CommandOutput) error
+	MarkCommandOutputTruncated(ctx context.Context, commandID uuid.UUID) error
 	GetCommandByID(ctx context.Context, commandID uuid.UUID) (*domains.NodeCommand, error)
+	ScheduleCommandRetry(ctx context.Context, commandID uuid.UUID, at time.Time) error
+	CreateRetryAttempts(ctx context.Context, limit int) (int, error)
+	GetCommandAttempts(ctx context.Context, firstCommandID uuid.UUID) ([]*domains.NodeCommand, error)
 	InsertLogChunks(ctx context.Context, commandID uuid.UUID, chunks []domains.CommandLog) ([]int64, error)
-	GetCommandLogs(ctx context.Context, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error)
+	GetCommandLogSize(ctx context.Context, commandID uuid.UUID) (int64, error)
+	GetCommandLogs(ctx context.Context, tenantID string, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error)
 	UpdateAgentMetadata(ctx context.Context, nodeID string, metadata *domains.AgentMetadata) error
 	CleanupOldLogs(ctx context.Context, retentionDays int) error
-	DeleteQueuedCommands(ctx context.Context, nodeID *string) (int, error)
-	ListNodes(ctx context.Context) ([]domains.Node, error)
-	ListCommands(ctx context.Context, nodeID *string, limit int) ([]domains.NodeCommand, error)
+	DeleteQueuedCommands(ctx context.Context, tenantID string, nodeID *string) (int, error)
+	ListNodes(ctx context.Context, tenantID string) ([]domains.Node, error)
+	ListCommands(ctx context.Context, tenantID string, nodeID *string, limit int) ([]domains.NodeCommand, error)
+	CountCommandsByStatus(ctx context.Context) ([]domains.CommandCount, error)
+
+	CreateSchedule(ctx context.Context, sched *domains.Schedule) error
+	GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*domains.Schedule, error)
+	ListSchedules(ctx context.Context, tenantID string) ([]*domains.Schedule, error)
+	ListDueSchedules(ctx context.Context, now time.Time, limit int) ([]*domains.Schedule, error)
+	AdvanceSchedule(ctx context.Context, scheduleID uuid.UUID, expected, next time.Time) (bool, error)
+	SetSchedulePaused(ctx context.Context, scheduleID uuid.UUID, paused bool, nextRunAt *time.Time) (bool, error)
+	DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) (bool, error)
+	InsertScheduleRun(ctx context.Context, run *domains.ScheduleRun) error
+	ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]domains.ScheduleRun, error)
+
+	CreateWorkflow(ctx context.Context, wf *domains.Workflow) error
+	GetWorkflow(ctx context.Context, workflowID uuid.UUID) (*domains.Workflow, error)
+	ListWorkflows(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Workflow, error)
+	FinishWorkflow(ctx context.Context, workflowID uuid.UUID, status string) (bool, error)
+	ListWorkflowSteps(ctx context.Context, workflowID uuid.UUID) ([]domains.WorkflowStepState, error)
+	TransitionWorkflowStep(ctx context.Context, workflowID uuid.UUID, stepID, from, to string, errorMsg *string) (bool, error)
+	SetWorkflowStepCommands(ctx context.Context, workflowID uuid.UUID, stepID string, commandIDs []uuid.UUID) error
+	GetWorkflowStepOutcome(ctx context.Context, workflowID uuid.UUID, stepID string) (domains.CommandOutcome, error)
+
+	CreateRollout(ctx context.Context, r *domains.Rollout, batches []domains.RolloutBatch) error
+	GetRollout(ctx context.Context, rolloutID uuid.UUID) (*domains.Rollout, error)
+	ListRollouts(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Rollout, error)
+	TransitionRollout(ctx context.Context, rolloutID uuid.UUID, from []string, to string, errorMsg *string) (bool, error)
+	SetRolloutNextBatchAt(ctx context.Context, rolloutID uuid.UUID, at time.Time) error
+	ListRolloutBatches(ctx context.Context, rolloutID uuid.UUID) ([]domains.RolloutBatch, error)
+	StartRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (bool, error)
+	SetRolloutBatchCommands(ctx context.Context, rolloutID uuid.UUID, batchIndex int, commandIDs []uuid.UUID, errorMsg *string) error
+	FinishRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int, status string, succeededCount int) (bool, error)
+	GetRolloutBatchOutcome(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (domains.CommandOutcome, error)
+	CancelRolloutCommands(ctx context.Context, rolloutID uuid.UUID) (int, error)
+
+	CreateCommandTemplate(ctx context.Context, t *domains.CommandTemplate) error
+	GetCommandTemplate(ctx context.Context, tenantID, name string, version *int) (*domains.CommandTemplate, error)
+	ListCommandTemplates(ctx context.Context, tenantID string) ([]*domains.CommandTemplate, error)
+	ListCommandTemplateVersions(ctx context.Context, tenantID, name string) ([]*domains.CommandTemplate, error)
+
+	CreateApprovalPolicy(ctx context.Context, p *domains.ApprovalPolicy) error
+	GetApprovalPolicy(ctx context.Context, policyID uuid.UUID) (*domains.ApprovalPolicy, error)
+	ListApprovalPolicies(ctx context.Context) ([]*domains.ApprovalPolicy, error)
+	DeleteApprovalPolicy(ctx context.Context, policyID uuid.UUID) (bool, error)
+	GetCommandApproval(ctx context.Context, commandID uuid.UUID) (*domains.CommandApproval, error)
+	ListPendingApprovals(ctx context.Context, tenantID string, limit int) ([]*domains.CommandApproval, error)
+	DecideCommandApproval(ctx context.Context, commandID uuid.UUID, decision, operatorID string, comment *string) (bool, error)
+	ExpireCommandApprovals(ctx context.Context) (int, error)
+
+	CreateCommandPolicy(ctx context.Context, p *domains.CommandPolicy) error
+	GetCommandPolicy(ctx context.Context) (*domains.CommandPolicy, error)
+
+	CreateWebhook(ctx context.Context, w *domains.WebhookSubscription) error
+	GetWebhook(ctx context.Context, webhookID uuid.UUID) (*domains.WebhookSubscription, error)
+	ListWebhooks(ctx context.Context, tenantID string) ([]*domains.WebhookSubscription, error)
+	SetWebhookEnabled(ctx context.Context, webhookID uuid.UUID, enabled bool) (bool, error)
+	DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (bool, error)
+	FanOutWebhookEvents(ctx context.Context, limit int) (int, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domains.WebhookDeliveryJob, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID uuid.UUID, attempt int, status string, responseCode *int, errorMsg *string, nextAttemptAt *time.Time) error
+	GetWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (*domains.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status *string, limit int) ([]*domains.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (bool, error)
+	DeleteOldWebhookEvents(ctx context.Context, retentionDays int) error
+	MarkOfflineNodes(ctx context.Context, offlineAfter time.Duration) (int, error)
+
+	CreateTenant(ctx context.Context, t *domains.Tenant) error
+	GetTenant(ctx context.Context, tenantID string) (*domains.Tenant, error)
+	ListTenants(ctx context.Context) ([]*domains.Tenant, error)
+	UpdateTenant(ctx context.Context, t *domains.Tenant) (bool, error)
+	GetTenantUsage(ctx context.Context, tenantID string) (domains.TenantUsage, error)
+	AddTenantOperator(ctx context.Context, tenantID, operatorID string) error
+	RemoveTenantOperator(ctx context.Context, tenantID, operatorID string) (bool, error)
+	ListTenantOperators(ctx context.Context, tenantID string) ([]string, error)
+	ListOperatorTenants(ctx context.Context, operatorID string) ([]string, error)
 }
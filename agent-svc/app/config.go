@@ -1,14 +1,30 @@
 package app
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+)
+
+// Storage backends selectable with STORAGE_BACKEND
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
 )
 
 // Config holds application configuration
 type Config struct {
 	ServerPort       string
+	GRPCPort         string // gRPC API, served alongside the HTTP API
 	JWTSecret        string
 	JWTExpirationSec int64
+
+	// StorageBackend is postgres, sqlite or memory; the DB_* settings apply to postgres and SQLitePath to sqlite
+	StorageBackend string
+	SQLitePath     string
+
 	DBHost           string
 	DBPort           string
 	DBUser           string
@@ -16,14 +32,64 @@ type Config struct {
 	DBName           string
 	DBSSLMode        string
 	LogRetentionDays int
+
+	// Output limits for RunCommand; the default applies when the payload omits max_output_bytes
+	DefaultMaxOutputBytes int64
+	MaxOutputBytes        int64
+
+	// MaxRequestBodyBytes bounds the request bodies of the HTTP API
+	MaxRequestBodyBytes int64
+
+	ExpirySweepIntervalSec int
+
+	IdempotencyKeyTTLSec int
+
+	RetrySchedulerIntervalSec int
+	ScheduleRunnerIntervalSec int
+
+	WorkflowReconcileIntervalSec int
+	RolloutControllerIntervalSec int
+
+	WebhookWorkerIntervalSec int
+	WebhookMaxAttempts       int
+	WebhookTimeoutSec        int
+
+	// A node is reported offline when it hasn't been seen for NodeOfflineAfterSec
+	NodeOfflineAfterSec    int
+	NodeMonitorIntervalSec int
+
+	// ReadyDBTimeoutSec bounds the database checks of /ready
+	ReadyDBTimeoutSec int
+
+	// TraceExporter is none, stdout or otlp; OTLPEndpoint is the OTLP/HTTP collector URL
+	TraceExporter string
+	OTLPEndpoint  string
+
+	// TenantAdminOperators may act in every tenant and manage tenants
+	TenantAdminOperators []string
+
+	// CommandPolicyFile is the command policy in YAML or JSON; empty keeps the policy in the database, managed
+	// through the API. Either is reloaded every CommandPolicyReloadSec.
+	CommandPolicyFile      string
+	CommandPolicyReloadSec int
+
+	// CommandSigningKey is the base64 Ed25519 seed dispatched commands are signed with; empty leaves them
+	// unsigned. Signatures are accepted by nodes for CommandSignatureTTLSec after dispatch.
+	CommandSigningKey      string
+	CommandSignatureTTLSec int
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
 		ServerPort:       getEnv("SERVER_PORT", "8080"),
+		GRPCPort:         getEnv("GRPC_PORT", "9090"),
 		JWTSecret:        getEnv("JWT_SIGNING_SECRET", "change-me-in-production"),
 		JWTExpirationSec: 86400, // 24 hours
+
+		StorageBackend: getEnv("STORAGE_BACKEND", StoragePostgres),
+		SQLitePath:     getEnv("SQLITE_PATH", "agent-svc.db"),
+
 		DBHost:           getEnv("DB_HOST", "localhost"),
 		DBPort:           getEnv("DB_PORT", "5432"),
 		DBUser:           getEnv("DB_USER", "postgres"),
@@ -31,6 +97,111 @@ func LoadConfig() (*Config, error) {
 		DBName:           getEnv("DB_NAME", "agentdb"),
 		DBSSLMode:        getEnv("DB_SSL_MODE", "disable"),
 		LogRetentionDays: 7,
+
+		DefaultMaxOutputBytes: getEnvInt64("DEFAULT_MAX_OUTPUT_BYTES", 1<<20), // 1 MiB
+		MaxOutputBytes:        getEnvInt64("MAX_OUTPUT_BYTES", 16<<20),        // 16 MiB
+
+		MaxRequestBodyBytes: getEnvInt64("MAX_REQUEST_BODY_BYTES", 4<<20), // 4 MiB
+
+		ExpirySweepIntervalSec: getEnvInt("EXPIRY_SWEEP_INTERVAL_SEC", 30),
+
+		IdempotencyKeyTTLSec: getEnvInt("IDEMPOTENCY_KEY_TTL_SEC", 86400), // 24 hours
+
+		RetrySchedulerIntervalSec: getEnvInt("RETRY_SCHEDULER_INTERVAL_SEC", 5),
+		ScheduleRunnerIntervalSec: getEnvInt("SCHEDULE_RUNNER_INTERVAL_SEC", 5),
+
+		WorkflowReconcileIntervalSec: getEnvInt("WORKFLOW_RECONCILE_INTERVAL_SEC", 10),
+		RolloutControllerIntervalSec: getEnvInt("ROLLOUT_CONTROLLER_INTERVAL_SEC", 5),
+
+		WebhookWorkerIntervalSec: getEnvInt("WEBHOOK_WORKER_INTERVAL_SEC", 2),
+		WebhookMaxAttempts:       getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeoutSec:        getEnvInt("WEBHOOK_TIMEOUT_SEC", 10),
+
+		NodeOfflineAfterSec:    getEnvInt("NODE_OFFLINE_AFTER_SEC", 120),
+		NodeMonitorIntervalSec: getEnvInt("NODE_MONITOR_INTERVAL_SEC", 30),
+
+		ReadyDBTimeoutSec: getEnvInt("READY_DB_TIMEOUT_SEC", 2),
+
+		TraceExporter: getEnv("TRACE_EXPORTER", "none"),
+		OTLPEndpoint:  getEnv("OTLP_ENDPOINT", "http://localhost:4318"),
+
+		TenantAdminOperators: getEnvList("TENANT_ADMIN_OPERATORS"),
+
+		CommandPolicyFile:      getEnv("COMMAND_POLICY_FILE", ""),
+		CommandPolicyReloadSec: getEnvInt("COMMAND_POLICY_RELOAD_SEC", 10),
+
+		CommandSigningKey:      getEnv("COMMAND_SIGNING_KEY", ""),
+		CommandSignatureTTLSec: getEnvInt("COMMAND_SIGNATURE_TTL_SEC", 300),
+	}
+
+	switch cfg.StorageBackend {
+	case StoragePostgres, StorageSQLite, StorageMemory:
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q: must be postgres, sqlite or memory", cfg.StorageBackend)
+	}
+
+	if cfg.DefaultMaxOutputBytes > cfg.MaxOutputBytes {
+		cfg.DefaultMaxOutputBytes = cfg.MaxOutputBytes
+	}
+
+	if cfg.MaxRequestBodyBytes <= 0 {
+		cfg.MaxRequestBodyBytes = 4 << 20
+	}
+
+	if cfg.ExpirySweepIntervalSec <= 0 {
+		cfg.ExpirySweepIntervalSec = 30
+	}
+
+	if cfg.IdempotencyKeyTTLSec <= 0 {
+		cfg.IdempotencyKeyTTLSec = 86400
+	}
+
+	if cfg.RetrySchedulerIntervalSec <= 0 {
+		cfg.RetrySchedulerIntervalSec = 5
+	}
+
+	if cfg.ScheduleRunnerIntervalSec <= 0 {
+		cfg.ScheduleRunnerIntervalSec = 5
+	}
+
+	if cfg.WorkflowReconcileIntervalSec <= 0 {
+		cfg.WorkflowReconcileIntervalSec = 10
+	}
+
+	if cfg.RolloutControllerIntervalSec <= 0 {
+		cfg.RolloutControllerIntervalSec = 5
+	}
+
+	if cfg.WebhookWorkerIntervalSec <= 0 {
+		cfg.WebhookWorkerIntervalSec = 2
+	}
+
+	if cfg.WebhookMaxAttempts <= 0 {
+		cfg.WebhookMaxAttempts = 8
+	}
+
+	if cfg.WebhookTimeoutSec <= 0 {
+		cfg.WebhookTimeoutSec = 10
+	}
+
+	if cfg.NodeOfflineAfterSec <= 0 {
+		cfg.NodeOfflineAfterSec = 120
+	}
+
+	if cfg.NodeMonitorIntervalSec <= 0 {
+		cfg.NodeMonitorIntervalSec = 30
+	}
+
+	if cfg.ReadyDBTimeoutSec <= 0 {
+		cfg.ReadyDBTimeoutSec = 2
+	}
+
+	if cfg.CommandPolicyReloadSec <= 0 {
+		cfg.CommandPolicyReloadSec = 10
+	}
+
+	if cfg.CommandSignatureTTLSec <= 0 {
+		cfg.CommandSignatureTTLSec = 300
 	}
 
 	return cfg, nil
@@ -42,3 +213,31 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if v, err := strconv.Atoi(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -2,11 +2,38 @@ package domains
 
 import "time"
 
+// Node health states
+const (
+	NodeHealthy   = "healthy"
+	NodeUnhealthy = "unhealthy" // no recent heartbeat
+	NodeDisabled  = "disabled"
+)
+
+// nodeHealthyWithin is how recently a node must have been seen to be healthy
+const nodeHealthyWithin = 30 * time.Second
+
 // Node represents a registered node
+// Attrs are reported by the node when it registers; labels are set by operators and kept across registrations.
 type Node struct {
 	ID         int64                  `db:"id"`
 	NodeID     string                 `db:"node_id"`
+	TenantID   string                 `db:"tenant_id"`
 	Attrs      map[string]interface{} `db:"attrs"`
+	Labels     map[string]string      `db:"labels"`
 	LastSeenAt time.Time              `db:"last_seen_at"`
 	Disabled   bool                   `db:"disabled"`
+
+	// LocalPolicyHash identifies the local policy file the node last reported with a heartbeat, "" for none
+	LocalPolicyHash string `db:"local_policy_hash"`
+}
+
+// HealthState returns whether the node is healthy, unhealthy or disabled at now
+func (n *Node) HealthState(now time.Time) string {
+	if n.Disabled {
+		return NodeDisabled
+	}
+	if now.Sub(n.LastSeenAt) < nodeHealthyWithin {
+		return NodeHealthy
+	}
+	return NodeUnhealthy
 }
@@ -0,0 +1,122 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidApprovalPolicy is returned when an approval policy definition is rejected
+var ErrInvalidApprovalPolicy = errors.New("invalid approval policy")
+
+// ErrApprovalNotPending is returned when deciding on a command that is not pending approval
+var ErrApprovalNotPending = errors.New("command is not pending approval")
+
+// ErrApproverRequired is returned when an operator who can't be identified decides on a command
+var ErrApproverRequired = errors.New("approving a command requires an identified operator")
+
+// ErrRequesterRequired is returned when a submission that requires approval names no operator, so an approver
+// couldn't be told apart from the submitter
+var ErrRequesterRequired = errors.New("a command that requires approval must be submitted by an identified operator")
+
+// ErrSelfApproval is returned when an operator decides on a command they submitted themselves
+var ErrSelfApproval = errors.New("commands must be approved by an operator other than the submitter")
+
+// Approval decisions; an approval request that nobody decided on in time is expired by the system
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// Approval request timeouts: an hour unless the policy sets one, at most a week
+const (
+	DefaultApprovalTimeoutSec = 3600
+	MaxApprovalTimeoutSec     = 7 * 24 * 3600
+)
+
+// ApprovalPolicy makes matching submissions wait in pending_approval until a second operator approves them
+// A submission matches when every criterion the policy sets matches; a policy setting none matches every command.
+type ApprovalPolicy struct {
+	ID             int64             `db:"id"`
+	PolicyID       uuid.UUID         `db:"policy_id"`
+	Name           string            `db:"name"`
+	Description    string            `db:"description"`
+	CommandType    string            `db:"command_type"`    // empty matches every command type
+	PayloadPattern string            `db:"payload_pattern"` // regular expression matched against every string in the payload
+	NodeLabels     map[string]string `db:"node_labels"`     // labels the target node must have, all of them
+	TimeoutSec     int               `db:"timeout_sec"`     // approval requests expire after this long
+	CreatedAt      time.Time         `db:"created_at"`
+}
+
+// Validate checks the pattern and timeout, filling in the default timeout
+func (p *ApprovalPolicy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidApprovalPolicy)
+	}
+	if p.PayloadPattern != "" {
+		if _, err := regexp.Compile(p.PayloadPattern); err != nil {
+			return fmt.Errorf("%w: invalid payload_pattern: %v", ErrInvalidApprovalPolicy, err)
+		}
+	}
+	if p.TimeoutSec == 0 {
+		p.TimeoutSec = DefaultApprovalTimeoutSec
+	}
+	if p.TimeoutSec < 0 || p.TimeoutSec > MaxApprovalTimeoutSec {
+		return fmt.Errorf("%w: timeout_sec must be between 1 and %d", ErrInvalidApprovalPolicy, MaxApprovalTimeoutSec)
+	}
+	return nil
+}
+
+// Matches reports whether a submission of a command to a node needs approval under the policy
+// Only labels count for node_labels, not attrs: labels are set by operators, attrs by the node itself.
+func (p *ApprovalPolicy) Matches(commandType string, payload map[string]interface{}, node *Node) bool {
+	if p.CommandType != "" && p.CommandType != commandType {
+		return false
+	}
+	for key, want := range p.NodeLabels {
+		if node.Labels[key] != want {
+			return false
+		}
+	}
+	if p.PayloadPattern == "" {
+		return true
+	}
+
+	pattern, err := regexp.Compile(p.PayloadPattern)
+	if err != nil {
+		// Validate rejects such policies; match rather than let a broken policy wave commands through
+		return true
+	}
+	matched := false
+	walkStrings(payload, func(s string) {
+		matched = matched || pattern.MatchString(s)
+	})
+	return matched
+}
+
+// ApprovalRequest is set on CommandOptions to create a command pending approval instead of queued
+type ApprovalRequest struct {
+	PolicyID   uuid.UUID
+	PolicyName string
+	ExpiresAt  time.Time
+}
+
+// CommandApproval is the approval request of a command submitted under an approval policy
+type CommandApproval struct {
+	CommandID   uuid.UUID  `db:"command_id"`
+	NodeID      string     `db:"node_id"`      // of the command
+	CommandType string     `db:"command_type"` // of the command
+	PolicyID    uuid.UUID  `db:"policy_id"`
+	PolicyName  string     `db:"policy_name"` // kept so the request still explains itself after the policy is deleted
+	RequestedBy string     `db:"requested_by"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	Decision    *string    `db:"decision"` // approved|rejected|expired, nil while pending
+	DecidedBy   *string    `db:"decided_by"`
+	Comment     *string    `db:"comment"`
+	DecidedAt   *time.Time `db:"decided_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
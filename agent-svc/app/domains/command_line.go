@@ -0,0 +1,11 @@
+package domains
+
+import "agent-svc/client"
+
+// SplitCommandLine splits a shell command line into its simple commands, as command_glob and command_regex see them
+// The splitter is client.SplitCommandLine, so node-agent checks its local command patterns against the same
+// commands the command policy does. A command line it can't split reliably is an error wrapping
+// client.ErrAmbiguousCommandLine.
+func SplitCommandLine(line string) ([][]string, error) {
+	return client.SplitCommandLine(line)
+}
@@ -0,0 +1,126 @@
+package domains
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"agent-svc/client"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want [][]string
+	}{
+		{name: "simple command", line: "ls -la /tmp", want: [][]string{{"ls", "-la", "/tmp"}}},
+		{name: "empty line", line: "  ", want: nil},
+		{name: "separators", line: "a; b && c || d | e & f\ng",
+			want: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}, {"g"}}},
+		{name: "subshell", line: "(cd /tmp; rm x)", want: [][]string{{"cd", "/tmp"}, {"rm", "x"}}},
+		{name: "comment", line: "echo hi # rm -rf /", want: [][]string{{"echo", "hi"}}},
+		{name: "hash inside a word", line: "echo a#b", want: [][]string{{"echo", "a#b"}}},
+		{name: "redirections", line: "echo hi >> /tmp/log 2>&1", want: [][]string{{"echo", "hi", ">>", "/tmp/log", "2", ">&", "1"}}},
+
+		{name: "single quotes", line: `echo 'a; rm -rf /'`, want: [][]string{{"echo", "a; rm -rf /"}}},
+		{name: "double quotes", line: `echo "a  b" "c\"d" "\$HOME"`, want: [][]string{{"echo", "a  b", `c"d`, "$HOME"}}},
+		{name: "backslash escapes", line: `echo a\ b \;`, want: [][]string{{"echo", "a b", ";"}}},
+		{name: "line continuation", line: "rm \\\n-rf /", want: [][]string{{"rm", "-rf", "/"}}},
+		{name: "quoted executable", line: `'r'"m" -rf /`, want: [][]string{{"rm", "-rf", "/"}}},
+		{name: "expansions kept", line: "echo $HOME ${USER}", want: [][]string{{"echo", "$HOME", "${USER}"}}},
+
+		{name: "executable path", line: "/bin/rm -rf /", want: [][]string{{"rm", "-rf", "/"}}},
+		{name: "assignments", line: "FOO=1 BAR=2 ./deploy.sh", want: [][]string{{"deploy.sh"}}},
+		{name: "only assignments", line: "FOO=1", want: nil},
+
+		{name: "command substitution", line: "echo $(rm -rf /)",
+			want: [][]string{{"rm", "-rf", "/"}, {"echo", "$(rm -rf /)"}}},
+		{name: "substitution in double quotes", line: `echo "x $(id) y"`,
+			want: [][]string{{"id"}, {"echo", "x $(id) y"}}},
+		{name: "backticks", line: "echo `rm -rf /`", want: [][]string{{"rm", "-rf", "/"}, {"echo", "`rm -rf /`"}}},
+		{name: "backticks in double quotes", line: "echo \"`id`\"", want: [][]string{{"id"}, {"echo", "`id`"}}},
+
+		{name: "sh -c", line: `sh -c "rm -rf /; echo done"`,
+			want: [][]string{{"sh", "-c", "rm -rf /; echo done"}, {"rm", "-rf", "/"}, {"echo", "done"}}},
+		{name: "bash -ec", line: `/bin/bash -ec 'rm x'`, want: [][]string{{"bash", "-ec", "rm x"}, {"rm", "x"}}},
+		{name: "shell without -c", line: "bash script.sh", want: [][]string{{"bash", "script.sh"}}},
+		{name: "eval", line: `eval "rm -rf" /`, want: [][]string{{"eval", "rm -rf", "/"}, {"rm", "-rf", "/"}}},
+
+		{name: "sudo", line: "sudo -u root rm -rf /", want: [][]string{{"sudo", "-u", "root", "rm", "-rf", "/"}, {"rm", "-rf", "/"}}},
+		{name: "env with assignments", line: "env -i FOO=1 /usr/bin/rm x", want: [][]string{{"env", "-i", "FOO=1", "/usr/bin/rm", "x"}, {"rm", "x"}}},
+		{name: "timeout operand", line: "timeout -s KILL 10 rm x", want: [][]string{{"timeout", "-s", "KILL", "10", "rm", "x"}, {"rm", "x"}}},
+		{name: "nested wrappers", line: "sudo nice -n 5 rm x",
+			want: [][]string{{"sudo", "nice", "-n", "5", "rm", "x"}, {"nice", "-n", "5", "rm", "x"}, {"rm", "x"}}},
+		{name: "wrapper of a shell", line: `sudo sh -c 'rm x'`,
+			want: [][]string{{"sudo", "sh", "-c", "rm x"}, {"sh", "-c", "rm x"}, {"rm", "x"}}},
+		{name: "wrapper without a command", line: "nohup", want: [][]string{{"nohup"}}},
+
+		{name: "if", line: "if rm -rf /; then :; fi", want: [][]string{{"rm", "-rf", "/"}, {":"}}},
+		{name: "elif and else", line: "if test -f a; then rm a; elif true; then rm b; else ! rm c; fi",
+			want: [][]string{{"test", "-f", "a"}, {"rm", "a"}, {"true"}, {"rm", "b"}, {"rm", "c"}}},
+		{name: "while", line: "while sleep 1; do rm x; done", want: [][]string{{"sleep", "1"}, {"rm", "x"}}},
+		{name: "until", line: "until ping -c1 db; do sleep 1; done", want: [][]string{{"ping", "-c1", "db"}, {"sleep", "1"}}},
+		{name: "for", line: "for f in /tmp/*; do rm $f; done", want: [][]string{{"rm", "$f"}}},
+		{name: "case", line: "case $1 in start) systemctl start x;; *) rm y;; esac",
+			want: [][]string{{"systemctl", "start", "x"}, {"rm", "y"}}},
+		{name: "case pattern alternatives", line: "case $1 in a|b) rm x;; esac", want: [][]string{{"rm", "x"}}},
+		{name: "case pattern substitution", line: "case x in $(rm y)) :;; esac", want: [][]string{{"rm", "y"}, {":"}}},
+		{name: "case pattern on the next line", line: "case $1 in\nstart) rm x;;\nesac", want: [][]string{{"rm", "x"}}},
+		{name: "nested case", line: "case a in a) case b in b) rm x;; esac;; *) rm y;; esac",
+			want: [][]string{{"rm", "x"}, {"rm", "y"}}},
+		{name: "group", line: "{ rm x; }", want: [][]string{{"rm", "x"}}},
+		{name: "negation", line: "! rm x", want: [][]string{{"rm", "x"}}},
+		{name: "function", line: "function f { rm x; }; f", want: [][]string{{"rm", "x"}, {"f"}}},
+		{name: "function with parentheses", line: "f() { rm x; }", want: [][]string{{"f"}, {"rm", "x"}}},
+		{name: "reserved word then assignment", line: "if FOO=1 /bin/rm x; then :; fi", want: [][]string{{"rm", "x"}, {":"}}},
+		{name: "reserved word as an argument", line: "echo if then fi", want: [][]string{{"echo", "if", "then", "fi"}}},
+		{name: "here-string", line: "cat <<< 'rm x'", want: [][]string{{"cat", "<<<", "rm x"}}},
+		{name: "paren in quotes", line: `echo ')' "(" ; rm x`, want: [][]string{{"echo", ")", "("}, {"rm", "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitCommandLine(tt.line)
+			if err != nil {
+				t.Fatalf("SplitCommandLine(%q) error = %v", tt.line, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommandLineAmbiguous(t *testing.T) {
+	deep := "rm x"
+	for i := 0; i < 20; i++ {
+		deep = "eval " + deep
+	}
+
+	for _, line := range []string{
+		"echo $(echo ')'); rm -rf /",
+		`echo $(echo ")"); rm -rf /`,
+		`echo "$(echo ')')"; rm -rf /`,
+		`echo $(echo \)); rm -rf /`,
+		"echo $(echo '#'); rm -rf /",
+		"echo $(echo # )\n); rm -rf /",
+		"echo `echo '`'`; rm -rf /",
+		"echo $(cat $(ls))",
+		"echo $(echo `id`)",
+		"echo $(case x in x) echo;; esac); rm -rf /",
+		"cat <<EOF\nrm -rf /\nEOF",
+		"cat <<-EOF\n\trm -rf /\n\tEOF",
+		"echo $(rm x",
+		"echo `rm x",
+		"echo 'rm x",
+		`echo "rm x`,
+		"echo x ) rm -rf /",
+		deep,
+	} {
+		t.Run(line, func(t *testing.T) {
+			if got, err := SplitCommandLine(line); !errors.Is(err, client.ErrAmbiguousCommandLine) {
+				t.Errorf("SplitCommandLine(%q) = %q, %v, want ErrAmbiguousCommandLine", line, got, err)
+			}
+		})
+	}
+}
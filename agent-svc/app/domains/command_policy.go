@@ -0,0 +1,351 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCommandPolicy is returned when a command policy definition is rejected
+var ErrInvalidCommandPolicy = errors.New("invalid command policy")
+
+// ErrCommandDenied is returned when the command policy denies a submission
+var ErrCommandDenied = errors.New("command denied by policy")
+
+// Command policy actions
+const (
+	PolicyAllow           = "allow"
+	PolicyDeny            = "deny"
+	PolicyRequireApproval = "require_approval"
+)
+
+// Where the command policy in effect was loaded from
+const (
+	PolicySourceNone = "none" // no policy was ever set; every command is allowed
+	PolicySourceAPI  = "api"
+	PolicySourceFile = "file"
+)
+
+// policyDays maps the day names of time windows to weekdays
+var policyDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// CommandPolicy decides on every submission whether it is allowed, denied or needs approval
+// Rules are evaluated in order and the first one matching the submission decides; DefaultAction decides
+// submissions no rule matches. Every change through the API stores a new version.
+type CommandPolicy struct {
+	ID            int64        `db:"id" json:"-" yaml:"-"`
+	PolicyID      uuid.UUID    `db:"policy_id" json:"-" yaml:"-"`
+	Version       int          `db:"version" json:"-" yaml:"-"` // 0 for a policy loaded from a file
+	DefaultAction string       `db:"default_action" json:"default_action" yaml:"default_action"`
+	Rules         []PolicyRule `db:"rules" json:"rules" yaml:"rules"`
+	Source        string       `db:"-" json:"-" yaml:"-"`
+	CreatedBy     string       `db:"created_by" json:"-" yaml:"-"`
+	CreatedAt     time.Time    `db:"created_at" json:"-" yaml:"-"`
+}
+
+// PolicyRule matches submissions on every criterion it sets; a rule setting none matches every submission
+// The command pattern of a deny or require_approval rule matches a command line if any of its commands matches,
+// that of an allow rule only if all of them do, so allowing "echo *" doesn't allow "echo hi; rm -rf /".
+type PolicyRule struct {
+	Name               string            `json:"name" yaml:"name"`
+	Action             string            `json:"action" yaml:"action"`
+	Reason             string            `json:"reason,omitempty" yaml:"reason,omitempty"`                             // told to the submitter of a denied command
+	CommandTypes       []string          `json:"command_types,omitempty" yaml:"command_types,omitempty"`               // any of them
+	CommandGlob        string            `json:"command_glob,omitempty" yaml:"command_glob,omitempty"`                 // matched against the commands of the command line
+	CommandRegex       string            `json:"command_regex,omitempty" yaml:"command_regex,omitempty"`               // searched in the commands of the command line
+	NodeLabels         map[string]string `json:"node_labels,omitempty" yaml:"node_labels,omitempty"`                   // all of them
+	OperatorRoles      []string          `json:"operator_roles,omitempty" yaml:"operator_roles,omitempty"`             // any of them
+	TimeWindow         *PolicyTimeWindow `json:"time_window,omitempty" yaml:"time_window,omitempty"`                   // when submitted
+	ApprovalTimeoutSec int               `json:"approval_timeout_sec,omitempty" yaml:"approval_timeout_sec,omitempty"` // for require_approval
+
+	commandPattern *regexp.Regexp
+	location       *time.Location
+}
+
+// PolicyTimeWindow is a time of day range on some days of the week
+// A window ending before it starts wraps past midnight and belongs to the day it starts on.
+type PolicyTimeWindow struct {
+	Days     []string `json:"days,omitempty" yaml:"days,omitempty"`         // mon, tue, ...; every day if empty
+	Start    string   `json:"start" yaml:"start"`                           // HH:MM, inclusive
+	End      string   `json:"end" yaml:"end"`                               // HH:MM, exclusive; equal to start for the whole day
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone,omitempty"` // IANA name, UTC if empty
+}
+
+// PolicyInput is a submission as the command policy sees it
+type PolicyInput struct {
+	CommandType   string
+	Payload       map[string]interface{}
+	NodeLabels    map[string]string
+	OperatorRoles []string
+	Time          time.Time
+}
+
+// PolicyDecision is the outcome of evaluating a submission against the command policy
+type PolicyDecision struct {
+	Action    string
+	Rule      string // name of the rule that decided, "" for the default action
+	RuleIndex int    // index of that rule, -1 for the default action
+	Reason    string
+	Commands  []string       // the commands the command line was split into, as command_glob and command_regex see them
+	Trace     []PolicyResult // every rule evaluated, in order
+}
+
+// PolicyResult explains how a rule was evaluated; Mismatch names the first criterion that didn't match
+type PolicyResult struct {
+	Rule     string
+	Matched  bool
+	Mismatch string
+}
+
+// Validate checks the actions, patterns and time windows of the policy and prepares it for Evaluate
+func (p *CommandPolicy) Validate() error {
+	if p.DefaultAction == "" {
+		p.DefaultAction = PolicyAllow
+	}
+	if p.DefaultAction != PolicyAllow && p.DefaultAction != PolicyDeny {
+		return fmt.Errorf("%w: default_action must be allow or deny", ErrInvalidCommandPolicy)
+	}
+
+	names := make(map[string]bool, len(p.Rules))
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("%w: rule %d has no name", ErrInvalidCommandPolicy, i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("%w: duplicate rule name %q", ErrInvalidCommandPolicy, rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", ErrInvalidCommandPolicy, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a rule and compiles its patterns
+func (r *PolicyRule) validate() error {
+	switch r.Action {
+	case PolicyAllow, PolicyDeny, PolicyRequireApproval:
+	default:
+		return fmt.Errorf("action must be allow, deny or require_approval")
+	}
+	if r.CommandGlob != "" && r.CommandRegex != "" {
+		return fmt.Errorf("command_glob and command_regex are mutually exclusive")
+	}
+
+	var err error
+	r.commandPattern = nil
+	switch {
+	case r.CommandGlob != "":
+		r.commandPattern, err = regexp.Compile(globPattern(r.CommandGlob))
+	case r.CommandRegex != "":
+		r.commandPattern, err = regexp.Compile(r.CommandRegex)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid command pattern: %v", err)
+	}
+
+	if r.ApprovalTimeoutSec < 0 || r.ApprovalTimeoutSec > MaxApprovalTimeoutSec {
+		return fmt.Errorf("approval_timeout_sec must be between 0 and %d", MaxApprovalTimeoutSec)
+	}
+
+	r.location = nil
+	if w := r.TimeWindow; w != nil {
+		for _, day := range w.Days {
+			if _, ok := policyDays[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("unknown day %q in time_window", day)
+			}
+		}
+		if _, err := minuteOfDay(w.Start); err != nil {
+			return fmt.Errorf("invalid time_window start: %v", err)
+		}
+		if _, err := minuteOfDay(w.End); err != nil {
+			return fmt.Errorf("invalid time_window end: %v", err)
+		}
+		timezone := w.Timezone
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		if r.location, err = time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("invalid time_window timezone: %v", err)
+		}
+	}
+	return nil
+}
+
+// Evaluate decides on a submission; the policy must have been validated
+// A command line that can't be split reliably is denied without evaluating the rules if any rule has a command
+// pattern, since a command hidden in it could get past a deny rule or satisfy an allow rule.
+func (p *CommandPolicy) Evaluate(in PolicyInput) PolicyDecision {
+	decision := PolicyDecision{Action: p.DefaultAction, RuleIndex: -1}
+	if cmd, ok := in.Payload["cmd"].(string); ok && in.CommandType == "RunCommand" {
+		commands, err := SplitCommandLine(cmd)
+		if err != nil && p.matchesCommands() {
+			decision.Action, decision.Reason = PolicyDeny, err.Error()
+			return decision
+		}
+		for _, words := range commands {
+			decision.Commands = append(decision.Commands, strings.Join(words, " "))
+		}
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		mismatch := rule.mismatch(in, decision.Commands)
+		decision.Trace = append(decision.Trace, PolicyResult{Rule: rule.Name, Matched: mismatch == "", Mismatch: mismatch})
+		if mismatch == "" {
+			decision.Action, decision.Rule, decision.RuleIndex, decision.Reason = rule.Action, rule.Name, i, rule.Reason
+			return decision
+		}
+	}
+	return decision
+}
+
+// matchesCommands reports whether any rule has a command pattern, so that decisions depend on the commands
+func (p *CommandPolicy) matchesCommands() bool {
+	for i := range p.Rules {
+		if p.Rules[i].commandPattern != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalTimeout returns how long an approval request made under the rule waits for a decision
+func (r *PolicyRule) ApprovalTimeout() time.Duration {
+	if r.ApprovalTimeoutSec == 0 {
+		return DefaultApprovalTimeoutSec * time.Second
+	}
+	return time.Duration(r.ApprovalTimeoutSec) * time.Second
+}
+
+// mismatch returns the first criterion of the rule the submission doesn't match, or "" if it matches them all
+func (r *PolicyRule) mismatch(in PolicyInput, commands []string) string {
+	if len(r.CommandTypes) > 0 && !containsString(r.CommandTypes, in.CommandType) {
+		return "command_types"
+	}
+	if r.commandPattern != nil {
+		matched := 0
+		for _, cmd := range commands {
+			if r.commandPattern.MatchString(cmd) {
+				matched++
+			}
+		}
+		if matched == 0 || r.Action == PolicyAllow && matched < len(commands) {
+			if r.CommandGlob != "" {
+				return "command_glob"
+			}
+			return "command_regex"
+		}
+	}
+	for key, want := range r.NodeLabels {
+		if in.NodeLabels[key] != want {
+			return "node_labels"
+		}
+	}
+	if len(r.OperatorRoles) > 0 {
+		matched := false
+		for _, role := range in.OperatorRoles {
+			matched = matched || containsString(r.OperatorRoles, role)
+		}
+		if !matched {
+			return "operator_roles"
+		}
+	}
+	if r.TimeWindow != nil && !r.TimeWindow.contains(in.Time.In(r.location)) {
+		return "time_window"
+	}
+	return ""
+}
+
+// contains reports whether t, in the window's time zone, falls within the window
+func (w *PolicyTimeWindow) contains(t time.Time) bool {
+	start, _ := minuteOfDay(w.Start)
+	end, _ := minuteOfDay(w.End)
+	minute := t.Hour()*60 + t.Minute()
+
+	day := t.Weekday()
+	switch {
+	case start == end:
+	case start < end:
+		if minute < start || minute >= end {
+			return false
+		}
+	default:
+		if minute < start && minute >= end {
+			return false
+		}
+		if minute < end {
+			// The early hours of a window that started the day before
+			day = (day + 6) % 7
+		}
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if policyDays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// minuteOfDay parses HH:MM into minutes since midnight
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// globPattern converts a glob, where * matches any run of characters, spaces and slashes included, and ?
+// any one character, to an anchored regular expression
+func globPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
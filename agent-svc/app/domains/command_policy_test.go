@@ -0,0 +1,253 @@
+package domains
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// runInput is a RunCommand submission of cmd at noon on Wednesday, 2024-01-03, UTC
+func runInput(cmd string) PolicyInput {
+	return PolicyInput{
+		CommandType: "RunCommand",
+		Payload:     map[string]interface{}{"cmd": cmd},
+		Time:        time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCommandPolicyEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       PolicyRule
+		input      PolicyInput
+		defaultAct string
+		want       string
+		mismatch   string
+	}{
+		{name: "rule without criteria matches", rule: PolicyRule{Action: PolicyDeny}, input: runInput("ls"), want: PolicyDeny},
+		{name: "default action when nothing matches", rule: PolicyRule{Action: PolicyAllow, CommandGlob: "ls*"},
+			input: runInput("rm x"), defaultAct: PolicyDeny, want: PolicyDeny, mismatch: "command_glob"},
+
+		{name: "command type matches", rule: PolicyRule{Action: PolicyDeny, CommandTypes: []string{"UpdateAgent", "RunCommand"}},
+			input: runInput("ls"), want: PolicyDeny},
+		{name: "command type mismatches", rule: PolicyRule{Action: PolicyDeny, CommandTypes: []string{"UpdateAgent"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "command_types"},
+
+		{name: "glob matches a command", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("echo hi; rm -rf /"), want: PolicyDeny},
+		{name: "glob matches the whole command", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm"},
+			input: runInput("rm -rf /"), want: PolicyAllow, mismatch: "command_glob"},
+		{name: "glob ? and classes", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "systemctl [!x]to? nginx"},
+			input: runInput("systemctl stop nginx"), want: PolicyDeny},
+		{name: "glob class negation mismatches", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "systemctl [!s]*"},
+			input: runInput("systemctl status nginx"), want: PolicyAllow, mismatch: "command_glob"},
+		{name: "glob sees the executable's base name", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("/bin/rm -rf /"), want: PolicyDeny},
+		{name: "glob sees through quoting", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput(`'r'm -rf /`), want: PolicyDeny},
+		{name: "glob sees command substitutions", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("echo $(rm -rf /)"), want: PolicyDeny},
+		{name: "glob sees backticks", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("echo `rm -rf /`"), want: PolicyDeny},
+		{name: "glob sees sh -c scripts", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput(`sh -c "rm -rf /"`), want: PolicyDeny},
+		{name: "glob sees eval", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput(`eval 'rm -rf /'`), want: PolicyDeny},
+		{name: "glob sees wrapped commands", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("sudo -u root timeout 5 rm -rf /"), want: PolicyDeny},
+		{name: "glob sees through reserved words", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("if rm -rf /; then :; fi"), want: PolicyDeny},
+		{name: "glob sees loop bodies", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput("while true; do rm -rf /; done"), want: PolicyDeny},
+		{name: "glob ignores quoted text", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "rm *"},
+			input: runInput(`echo 'rm -rf /'`), want: PolicyAllow, mismatch: "command_glob"},
+		{name: "glob only applies to RunCommand", rule: PolicyRule{Action: PolicyDeny, CommandGlob: "*"},
+			input: PolicyInput{CommandType: "UpdateAgent", Payload: map[string]interface{}{"cmd": "rm"}}, want: PolicyAllow, mismatch: "command_glob"},
+
+		{name: "regex is searched", rule: PolicyRule{Action: PolicyDeny, CommandRegex: `--no-preserve-root`},
+			input: runInput("rm -rf --no-preserve-root /"), want: PolicyDeny},
+		{name: "regex mismatches", rule: PolicyRule{Action: PolicyDeny, CommandRegex: `^shutdown\b`},
+			input: runInput("echo shutdown"), want: PolicyAllow, mismatch: "command_regex"},
+
+		{name: "allow needs every command to match", rule: PolicyRule{Action: PolicyAllow, CommandGlob: "echo *"},
+			input: runInput("echo hi; rm -rf /"), defaultAct: PolicyDeny, want: PolicyDeny, mismatch: "command_glob"},
+		{name: "allow matches when every command does", rule: PolicyRule{Action: PolicyAllow, CommandGlob: "echo *"},
+			input: runInput("echo a && echo b"), defaultAct: PolicyDeny, want: PolicyAllow},
+		{name: "allow needs nested commands to match", rule: PolicyRule{Action: PolicyAllow, CommandGlob: "echo *"},
+			input: runInput("echo $(rm -rf /)"), defaultAct: PolicyDeny, want: PolicyDeny, mismatch: "command_glob"},
+		{name: "allow regex needs every command to match", rule: PolicyRule{Action: PolicyAllow, CommandRegex: `^(ls|cat)\b`},
+			input: runInput("ls | cat | sh"), defaultAct: PolicyDeny, want: PolicyDeny, mismatch: "command_regex"},
+		{name: "require approval needs one command to match", rule: PolicyRule{Action: PolicyRequireApproval, CommandGlob: "reboot*"},
+			input: runInput("sync; reboot"), want: PolicyRequireApproval},
+
+		{name: "node labels all match", rule: PolicyRule{Action: PolicyDeny, NodeLabels: map[string]string{"env": "prod", "tier": "db"}},
+			input: withLabels(runInput("ls"), map[string]string{"env": "prod", "tier": "db", "zone": "a"}), want: PolicyDeny},
+		{name: "node labels one differs", rule: PolicyRule{Action: PolicyDeny, NodeLabels: map[string]string{"env": "prod", "tier": "db"}},
+			input: withLabels(runInput("ls"), map[string]string{"env": "prod", "tier": "web"}), want: PolicyAllow, mismatch: "node_labels"},
+		{name: "node labels missing", rule: PolicyRule{Action: PolicyDeny, NodeLabels: map[string]string{"env": "prod"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "node_labels"},
+
+		{name: "operator roles any matches", rule: PolicyRule{Action: PolicyDeny, OperatorRoles: []string{"dev", "intern"}},
+			input: withRoles(runInput("ls"), "sre", "intern"), want: PolicyDeny},
+		{name: "operator roles none match", rule: PolicyRule{Action: PolicyDeny, OperatorRoles: []string{"intern"}},
+			input: withRoles(runInput("ls"), "sre"), want: PolicyAllow, mismatch: "operator_roles"},
+		{name: "operator roles without roles", rule: PolicyRule{Action: PolicyDeny, OperatorRoles: []string{"intern"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "operator_roles"},
+
+		{name: "time window contains", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Start: "09:00", End: "17:00"}},
+			input: runInput("ls"), want: PolicyDeny},
+		{name: "time window end is exclusive", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Start: "09:00", End: "12:00"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "time_window"},
+		{name: "time window start is inclusive", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Start: "12:00", End: "13:00"}},
+			input: runInput("ls"), want: PolicyDeny},
+		{name: "time window whole day", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Days: []string{"Wed"}, Start: "00:00", End: "00:00"}},
+			input: runInput("ls"), want: PolicyDeny},
+		{name: "time window other day", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "time_window"},
+		{name: "time window in its time zone", rule: PolicyRule{Action: PolicyDeny,
+			TimeWindow: &PolicyTimeWindow{Start: "09:00", End: "17:00", Timezone: "Asia/Tokyo"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "time_window"},
+		{name: "time window past midnight", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Days: []string{"tue"}, Start: "22:00", End: "02:00"}},
+			input: at(runInput("ls"), time.Date(2024, 1, 3, 1, 30, 0, 0, time.UTC)), want: PolicyDeny},
+		{name: "time window past midnight belongs to its first day", rule: PolicyRule{Action: PolicyDeny,
+			TimeWindow: &PolicyTimeWindow{Days: []string{"wed"}, Start: "22:00", End: "02:00"}},
+			input: at(runInput("ls"), time.Date(2024, 1, 3, 1, 30, 0, 0, time.UTC)), want: PolicyAllow, mismatch: "time_window"},
+		{name: "time window past midnight outside", rule: PolicyRule{Action: PolicyDeny, TimeWindow: &PolicyTimeWindow{Start: "22:00", End: "02:00"}},
+			input: runInput("ls"), want: PolicyAllow, mismatch: "time_window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = "rule"
+			p := &CommandPolicy{DefaultAction: tt.defaultAct, Rules: []PolicyRule{tt.rule}}
+			if err := p.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			decision := p.Evaluate(tt.input)
+			if decision.Action != tt.want {
+				t.Errorf("Evaluate() action = %s, want %s (commands %q)", decision.Action, tt.want, decision.Commands)
+			}
+			if len(decision.Trace) != 1 || decision.Trace[0].Mismatch != tt.mismatch {
+				t.Errorf("Evaluate() trace = %+v, want mismatch %q", decision.Trace, tt.mismatch)
+			}
+			if wantRule := tt.mismatch == ""; (decision.RuleIndex == 0) != wantRule {
+				t.Errorf("Evaluate() rule index = %d", decision.RuleIndex)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyFirstMatchingRuleDecides(t *testing.T) {
+	p := &CommandPolicy{DefaultAction: PolicyDeny, Rules: []PolicyRule{
+		{Name: "no-rm", Action: PolicyDeny, CommandGlob: "rm *", Reason: "use the cleanup job"},
+		{Name: "sre", Action: PolicyAllow, OperatorRoles: []string{"sre"}},
+		{Name: "reboot", Action: PolicyRequireApproval, CommandGlob: "reboot"},
+	}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		input  PolicyInput
+		action string
+		rule   string
+	}{
+		{input: withRoles(runInput("rm -rf /tmp/x"), "sre"), action: PolicyDeny, rule: "no-rm"},
+		{input: withRoles(runInput("reboot"), "sre"), action: PolicyAllow, rule: "sre"},
+		{input: runInput("reboot"), action: PolicyRequireApproval, rule: "reboot"},
+		{input: runInput("ls"), action: PolicyDeny, rule: ""},
+	}
+	for _, tt := range tests {
+		decision := p.Evaluate(tt.input)
+		if decision.Action != tt.action || decision.Rule != tt.rule {
+			t.Errorf("Evaluate(%v) = %s by %q, want %s by %q", tt.input.Payload["cmd"], decision.Action, decision.Rule, tt.action, tt.rule)
+		}
+	}
+	if decision := p.Evaluate(runInput("rm x")); decision.Reason != "use the cleanup job" || len(decision.Trace) != 1 {
+		t.Errorf("deny decision = %+v", decision)
+	}
+}
+
+func TestCommandPolicyDeniesAmbiguousCommandLines(t *testing.T) {
+	policies := map[string]*CommandPolicy{
+		"deny rule":  {Rules: []PolicyRule{{Name: "no-rm", Action: PolicyDeny, CommandGlob: "rm *"}}},
+		"allow rule": {DefaultAction: PolicyDeny, Rules: []PolicyRule{{Name: "echo", Action: PolicyAllow, CommandGlob: "echo *"}}},
+	}
+	lines := []string{
+		"echo $(echo ')'); rm -rf /",
+		`echo "$(echo ')')"; rm -rf /`,
+		"echo `echo '`'`; rm -rf /",
+		"echo $(case x in x) echo;; esac); rm -rf /",
+		"echo $(echo $(echo ')')); rm -rf /",
+		"echo <<EOF\nrm -rf /\nEOF",
+		"echo 'x; rm -rf /",
+	}
+	for name, p := range policies {
+		if err := p.Validate(); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		for _, line := range lines {
+			decision := p.Evaluate(runInput(line))
+			if decision.Action != PolicyDeny || decision.Rule != "" || decision.Reason == "" {
+				t.Errorf("%s: Evaluate(%q) = %s by %q (%q), want deny as ambiguous", name, line, decision.Action, decision.Rule, decision.Reason)
+			}
+		}
+	}
+
+	// Without command patterns the commands don't matter, so an ambiguous line is decided as usual
+	p := &CommandPolicy{Rules: []PolicyRule{{Name: "sre", Action: PolicyRequireApproval, OperatorRoles: []string{"sre"}}}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if decision := p.Evaluate(withRoles(runInput(lines[0]), "sre")); decision.Action != PolicyRequireApproval {
+		t.Errorf("Evaluate() without command patterns = %s, want %s", decision.Action, PolicyRequireApproval)
+	}
+}
+
+func TestCommandPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CommandPolicy
+		ok     bool
+	}{
+		{name: "empty policy", policy: CommandPolicy{}, ok: true},
+		{name: "default action", policy: CommandPolicy{DefaultAction: PolicyRequireApproval}},
+		{name: "unnamed rule", policy: CommandPolicy{Rules: []PolicyRule{{Action: PolicyDeny}}}},
+		{name: "duplicate rule", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny}, {Name: "a", Action: PolicyAllow}}}},
+		{name: "unknown action", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: "block"}}}},
+		{name: "glob and regex", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny, CommandGlob: "rm*", CommandRegex: "rm"}}}},
+		{name: "invalid regex", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny, CommandRegex: "("}}}},
+		{name: "approval timeout", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyRequireApproval, ApprovalTimeoutSec: -1}}}},
+		{name: "unknown day", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny,
+			TimeWindow: &PolicyTimeWindow{Days: []string{"someday"}, Start: "00:00", End: "00:00"}}}}},
+		{name: "invalid start", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny,
+			TimeWindow: &PolicyTimeWindow{Start: "9am", End: "17:00"}}}}},
+		{name: "invalid time zone", policy: CommandPolicy{Rules: []PolicyRule{{Name: "a", Action: PolicyDeny,
+			TimeWindow: &PolicyTimeWindow{Start: "09:00", End: "17:00", Timezone: "Mars/Olympus"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate() = %v, want ok %t", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCommandPolicy) {
+				t.Errorf("Validate() = %v, want ErrInvalidCommandPolicy", err)
+			}
+		})
+	}
+}
+
+func withLabels(in PolicyInput, labels map[string]string) PolicyInput {
+	in.NodeLabels = labels
+	return in
+}
+
+func withRoles(in PolicyInput, roles ...string) PolicyInput {
+	in.OperatorRoles = roles
+	return in
+}
+
+func at(in PolicyInput, t time.Time) PolicyInput {
+	in.Time = t
+	return in
+}
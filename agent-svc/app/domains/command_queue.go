@@ -8,14 +8,102 @@ import (
 
 // NodeCommand represents a command in the queue
 type NodeCommand struct {
-	ID          int64                  `db:"id"`
-	CommandID   uuid.UUID              `db:"command_id"`
-	NodeID      string                 `db:"node_id"`
-	CommandType string                 `db:"command_type"`
-	Payload     map[string]interface{} `db:"payload"`
-	Status      string                 `db:"status"`
-	CreatedAt   time.Time              `db:"created_at"`
-	UpdatedAt   time.Time              `db:"updated_at"`
-	ExitCode    *int                   `db:"exit_code"`
-	ErrorMsg    *string                `db:"error_msg"`
+	ID              int64                  `db:"id"`
+	CommandID       uuid.UUID              `db:"command_id"`
+	NodeID          string                 `db:"node_id"`
+	CommandType     string                 `db:"command_type"`
+	Payload         map[string]interface{} `db:"payload"`
+	Status          string                 `db:"status"`
+	CreatedAt       time.Time              `db:"created_at"`
+	UpdatedAt       time.Time              `db:"updated_at"`
+	ExitCode        *int                   `db:"exit_code"`
+	ErrorMsg        *string                `db:"error_msg"`
+	OutputBytes     *int64                 `db:"output_bytes"`
+	OutputTruncated bool                   `db:"output_truncated"`
+	DispatchedAt    *time.Time             `db:"dispatched_at"`
+	StartedAt       *time.Time             `db:"started_at"`
+	FinishedAt      *time.Time             `db:"finished_at"`
+	ExpiresAt       *time.Time             `db:"expires_at"`
+	Priority        int                    `db:"priority"`
+	ParentCommandID *uuid.UUID             `db:"parent_command_id"` // first attempt, nil on the first attempt itself
+	Attempt         int                    `db:"attempt"`
+	RetryPolicy     *RetryPolicy           `db:"retry_policy"`
+	NextRetryAt     *time.Time             `db:"next_retry_at"` // set while the next attempt is scheduled
+	WorkflowID      *uuid.UUID             `db:"workflow_id"`
+	WorkflowStepID  *string                `db:"workflow_step_id"`
+	RolloutID       *uuid.UUID             `db:"rollout_id"`
+	RolloutBatch    *int                   `db:"rollout_batch"`
+	TemplateName    *string                `db:"template_name"` // template and version the payload was rendered from
+	TemplateVersion *int                   `db:"template_version"`
+	TraceContext    map[string]string      `db:"trace_context"` // W3C trace context of the submission, nil if untraced
+
+	// Set when the command is dispatched with command signing enabled; not stored
+	Signature          string    `db:"-"`
+	SignatureExpiresAt time.Time `db:"-"`
+}
+
+// FirstAttemptID returns the command ID of the first attempt of the command
+func (c *NodeCommand) FirstAttemptID() uuid.UUID {
+	if c.ParentCommandID != nil {
+		return *c.ParentCommandID
+	}
+	return c.CommandID
+}
+
+// Command priorities; queued commands are dispatched highest priority first, then oldest first
+const (
+	PriorityMin     = 0
+	PriorityDefault = 5
+	PriorityMax     = 9
+)
+
+// CommandOutput carries the output statistics reported by a node when a command finishes
+type CommandOutput struct {
+	TotalBytes int64
+	Truncated  bool
+}
+
+// CommandOptions holds the optional submission settings of a command
+type CommandOptions struct {
+	ExpiresAt        *time.Time // command expires if not dispatched by this time
+	DeliverWithinSec int        // alternative to ExpiresAt, relative to submission
+	Priority         *int       // PriorityMin..PriorityMax, PriorityDefault if nil
+	RetryPolicy      *RetryPolicy
+	WorkflowID       *uuid.UUID // workflow run and step the command belongs to
+	WorkflowStepID   string
+	RolloutID        *uuid.UUID // rollout and batch the command belongs to
+	RolloutBatch     int
+	TemplateName     string // template and version the payload was rendered from
+	TemplateVersion  int
+	TraceContext     map[string]string // W3C trace context of the submission; set by CommandService
+
+	// Approval creates the command pending approval under a policy; set by CommandService
+	Approval *ApprovalRequest
+
+	// OperatorRoles are the roles of the operator submitting, matched by the command policy; not stored
+	OperatorRoles []string
+
+	// TenantID restricts the submission to the nodes of a tenant; empty allows every node
+	TenantID string
+
+	// Idempotency key scoped to the operator and node; set by CommandService before storage
+	OperatorID           string
+	IdempotencyKey       string
+	RequestHash          string
+	IdempotencyExpiresAt time.Time
+}
+
+// CommandOutcome summarizes a group of commands, such as a workflow step or a rollout batch, taking the
+// latest attempt of each
+type CommandOutcome struct {
+	Commands  int // commands submitted
+	Finished  int // commands in a terminal status with no retry scheduled
+	Succeeded int // finished commands whose status is success
+}
+
+// CommandCount is the number of commands of a type in a status
+type CommandCount struct {
+	CommandType string
+	Status      string
+	Count       int64
 }
@@ -0,0 +1,85 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Command statuses
+const (
+	StatusPendingApproval = "pending_approval" // submitted under an approval policy, never dispatched until approved
+	StatusQueued          = "queued"
+	StatusRunning         = "running"
+	StatusSuccess         = "success"
+	StatusFailed          = "failed"
+	StatusTimeout         = "timeout"
+	StatusCancelled       = "cancelled"
+	StatusLost            = "lost"
+	StatusExpired         = "expired"
+	StatusRejected        = "rejected"
+)
+
+// Status transition sources recorded in command_status_history
+const (
+	SourceSubmit   = "submit"   // command created by an operator
+	SourceDispatch = "dispatch" // command handed to a node by GetNextCommand
+	SourceAgent    = "agent"    // status reported by node-agent
+	SourceOperator = "operator" // status changed through the operator API
+	SourceSystem   = "system"   // status changed by a background job
+)
+
+// ErrInvalidStatusTransition is returned when a status change is not allowed by the state machine
+var ErrInvalidStatusTransition = errors.New("invalid status transition")
+
+// ErrCommandNotCancellable is returned when an operator cancels a command that has left the queue
+var ErrCommandNotCancellable = errors.New("only queued commands and commands pending approval can be cancelled")
+
+// statusTransitions lists the statuses each status may move to; terminal statuses have none.
+// running -> running is allowed so the agent can confirm a dispatched command has started, and
+// running -> expired so it can refuse a command whose deadline passed in its local queue.
+// pending_approval moves to queued when approved and to rejected or expired when rejected or left undecided.
+var statusTransitions = map[string][]string{
+	StatusPendingApproval: {StatusQueued, StatusRejected, StatusExpired, StatusCancelled},
+	StatusQueued:          {StatusRunning, StatusCancelled, StatusExpired, StatusRejected},
+	StatusRunning:         {StatusRunning, StatusSuccess, StatusFailed, StatusTimeout, StatusCancelled, StatusLost, StatusExpired, StatusRejected},
+	StatusSuccess:         {},
+	StatusFailed:          {},
+	StatusTimeout:         {},
+	StatusCancelled:       {},
+	StatusLost:            {},
+	StatusExpired:         {},
+	StatusRejected:        {},
+}
+
+// IsKnownStatus reports whether status is part of the state machine
+func IsKnownStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// IsTerminalStatus reports whether a command in this status can no longer change
+func IsTerminalStatus(status string) bool {
+	next, ok := statusTransitions[status]
+	return ok && len(next) == 0
+}
+
+// ValidateStatusTransition returns ErrInvalidStatusTransition if from -> to is not allowed
+func ValidateStatusTransition(from, to string) error {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, from, to)
+}
+
+// CommandStatusChange represents a row in command_status_history
+type CommandStatusChange struct {
+	ID         int64     `db:"id"`
+	CommandID  string    `db:"command_id"`
+	FromStatus *string   `db:"from_status"`
+	ToStatus   string    `db:"to_status"`
+	Source     string    `db:"source"`
+	CreatedAt  time.Time `db:"created_at"`
+}
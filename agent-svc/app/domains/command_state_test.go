@@ -0,0 +1,89 @@
+package domains
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateStatusTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{StatusPendingApproval, StatusQueued, true},
+		{StatusPendingApproval, StatusRejected, true},
+		{StatusPendingApproval, StatusExpired, true},
+		{StatusPendingApproval, StatusCancelled, true},
+		{StatusPendingApproval, StatusRunning, false},
+		{StatusPendingApproval, StatusSuccess, false},
+
+		{StatusQueued, StatusRunning, true},
+		{StatusQueued, StatusCancelled, true},
+		{StatusQueued, StatusExpired, true},
+		{StatusQueued, StatusRejected, true},
+		{StatusQueued, StatusTimeout, false},
+		{StatusQueued, StatusSuccess, false},
+		{StatusQueued, StatusLost, false},
+		{StatusQueued, StatusPendingApproval, false},
+
+		{StatusRunning, StatusRunning, true},
+		{StatusRunning, StatusSuccess, true},
+		{StatusRunning, StatusFailed, true},
+		{StatusRunning, StatusTimeout, true},
+		{StatusRunning, StatusCancelled, true},
+		{StatusRunning, StatusLost, true},
+		{StatusRunning, StatusExpired, true},
+		{StatusRunning, StatusRejected, true},
+		{StatusRunning, StatusQueued, false},
+
+		{StatusSuccess, StatusRunning, false},
+		{StatusFailed, StatusQueued, false},
+		{StatusTimeout, StatusSuccess, false},
+		{StatusCancelled, StatusQueued, false},
+		{StatusLost, StatusSuccess, false},
+		{StatusLost, StatusRunning, false},
+		{StatusExpired, StatusQueued, false},
+		{StatusRejected, StatusRunning, false},
+
+		{"unknown", StatusRunning, false},
+		{StatusRunning, "unknown", false},
+	}
+	for _, tt := range tests {
+		err := ValidateStatusTransition(tt.from, tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("%s -> %s: unexpected error %v", tt.from, tt.to, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("%s -> %s: error = %v, want ErrInvalidStatusTransition", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestTerminalStatuses(t *testing.T) {
+	terminal := map[string]bool{
+		StatusPendingApproval: false,
+		StatusQueued:          false,
+		StatusRunning:         false,
+		StatusSuccess:         true,
+		StatusFailed:          true,
+		StatusTimeout:         true,
+		StatusCancelled:       true,
+		StatusLost:            true,
+		StatusExpired:         true,
+		StatusRejected:        true,
+	}
+	for status, want := range terminal {
+		if !IsKnownStatus(status) {
+			t.Errorf("IsKnownStatus(%s) = false", status)
+		}
+		if got := IsTerminalStatus(status); got != want {
+			t.Errorf("IsTerminalStatus(%s) = %t, want %t", status, got, want)
+		}
+	}
+	if len(terminal) != len(statusTransitions) {
+		t.Errorf("statusTransitions has %d statuses, the test covers %d", len(statusTransitions), len(terminal))
+	}
+	if IsKnownStatus("unknown") || IsTerminalStatus("unknown") {
+		t.Error("unknown status reported as known or terminal")
+	}
+}
@@ -0,0 +1,269 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTemplate is returned when a command template definition is rejected
+var ErrInvalidTemplate = errors.New("invalid command template")
+
+// ErrInvalidTemplateParams is returned when the params given for a template are rejected
+var ErrInvalidTemplateParams = errors.New("invalid template params")
+
+// Template parameter types
+const (
+	ParamString  = "string"
+	ParamInteger = "integer"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+)
+
+// placeholderPattern matches {{param}} placeholders, allowing spaces inside the braces
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// paramNamePattern restricts parameter names to identifiers
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// shellFields lists, per command type, the payload fields node-agent runs through a shell;
+// placeholders in them are shell-quoted
+var shellFields = map[string][]string{
+	"RunCommand": {"cmd"},
+}
+
+// CommandTemplate is a versioned command with {{param}} placeholders in its payload
+type CommandTemplate struct {
+	ID          int64                  `db:"id"`
+	TenantID    string                 `db:"tenant_id"`
+	Name        string                 `db:"name"`
+	Version     int                    `db:"version"`
+	Description string                 `db:"description"`
+	CommandType string                 `db:"command_type"`
+	Payload     map[string]interface{} `db:"payload"`
+	Params      []TemplateParam        `db:"params"`
+	CreatedAt   time.Time              `db:"created_at"`
+}
+
+// TemplateParam describes one parameter of a command template
+type TemplateParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"` // string|integer|number|boolean
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"` // regular expression a string value must match in full
+	Enum        []string    `json:"enum,omitempty"`    // allowed values of a string
+	Min         *float64    `json:"min,omitempty"`     // bounds of a number, or of the length of a string
+	Max         *float64    `json:"max,omitempty"`
+}
+
+// Validate checks the parameter schema and that every placeholder in the payload refers to a parameter
+func (t *CommandTemplate) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTemplate)
+	}
+
+	params := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		if !paramNamePattern.MatchString(p.Name) {
+			return fmt.Errorf("%w: parameter name %q must be an identifier", ErrInvalidTemplate, p.Name)
+		}
+		if params[p.Name] {
+			return fmt.Errorf("%w: duplicate parameter %q", ErrInvalidTemplate, p.Name)
+		}
+		params[p.Name] = true
+
+		switch p.Type {
+		case ParamString:
+		case ParamInteger, ParamNumber, ParamBoolean:
+			if p.Pattern != "" || len(p.Enum) > 0 {
+				return fmt.Errorf("%w: parameter %q: pattern and enum only apply to strings", ErrInvalidTemplate, p.Name)
+			}
+		default:
+			return fmt.Errorf("%w: parameter %q has unknown type %q", ErrInvalidTemplate, p.Name, p.Type)
+		}
+		if p.Type == ParamBoolean && (p.Min != nil || p.Max != nil) {
+			return fmt.Errorf("%w: parameter %q: min and max do not apply to booleans", ErrInvalidTemplate, p.Name)
+		}
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return fmt.Errorf("%w: parameter %q has an invalid pattern: %v", ErrInvalidTemplate, p.Name, err)
+			}
+		}
+		for _, v := range p.Enum {
+			if strings.ContainsAny(v, " ,|") {
+				return fmt.Errorf("%w: parameter %q: enum values must not contain spaces, commas or pipes", ErrInvalidTemplate, p.Name)
+			}
+		}
+	}
+
+	var missing []string
+	walkStrings(t.Payload, func(s string) {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			if !params[m[1]] {
+				missing = append(missing, m[1])
+			}
+		}
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: placeholders without a parameter: %s", ErrInvalidTemplate, strings.Join(missing, ", "))
+	}
+
+	for _, field := range shellFields[t.CommandType] {
+		var err error
+		walkStrings(t.Payload[field], func(s string) {
+			if err == nil {
+				err = checkShellPlaceholders(s)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, field, err)
+		}
+	}
+	return nil
+}
+
+// checkShellPlaceholders rejects placeholders in a shell command line where a single-quoted value isn't a
+// single literal word: inside quotes or backticks, after a backslash or a $, or in a here-document. Inside
+// double quotes, for instance, the single quotes are literal, so "{{msg}}" with msg=$(id) would still run id,
+// and after a $ they would start a $'...' string, in which a backslash in the value escapes the closing quote.
+func checkShellPlaceholders(s string) error {
+	matches := placeholderPattern.FindAllStringIndex(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	contexts := shellContexts(s)
+	for _, m := range matches {
+		if context := contexts[m[0]]; context != "" {
+			return fmt.Errorf("placeholder %s is %s; placeholders are quoted as words of their own and must stand outside quotes", s[m[0]:m[1]], context)
+		}
+	}
+	return nil
+}
+
+// shellContexts describes, for each byte of a shell command line, what quotes it: "" for nothing
+// A here-document is assumed to start at the line after the first <<, and to run to the end.
+func shellContexts(s string) []string {
+	contexts := make([]string, len(s))
+	var quote byte
+	hereDoc, inHereDoc := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inHereDoc:
+			contexts[i] = "in a here-document"
+		case quote == '\'':
+			contexts[i] = "inside single quotes"
+			if c == '\'' {
+				quote = 0
+			}
+		case quote == '"' || quote == '`':
+			contexts[i] = "inside double quotes"
+			if quote == '`' {
+				contexts[i] = "inside backticks"
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				contexts[i] = contexts[i-1]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\\':
+			if i+1 < len(s) {
+				i++
+				contexts[i] = "escaped by a backslash"
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '$':
+			if i+1 < len(s) {
+				contexts[i+1] = "after a $"
+			}
+		case strings.HasPrefix(s[i:], "<<<"):
+			i += 2
+		case strings.HasPrefix(s[i:], "<<"):
+			hereDoc = true
+			i++
+		case c == '\n' && hereDoc:
+			inHereDoc = true
+		}
+	}
+	return contexts
+}
+
+// Render replaces the placeholders in the template payload with validated param values
+// A string that is exactly one placeholder takes the value with its type, so "{{timeout}}" can fill a number.
+// Placeholders inside a larger string are replaced with the value's text; in fields run by a shell, such
+// as RunCommand's cmd, every substituted value is shell-quoted so it is always a single word. Validate
+// ensures placeholders in those fields stand where quoting does that.
+func (t *CommandTemplate) Render(params map[string]interface{}) map[string]interface{} {
+	shell := make(map[string]bool)
+	for _, field := range shellFields[t.CommandType] {
+		shell[field] = true
+	}
+
+	payload := make(map[string]interface{}, len(t.Payload))
+	for key, value := range t.Payload {
+		payload[key] = renderValue(value, params, shell[key])
+	}
+	return payload
+}
+
+// renderValue renders placeholders in a payload value, recursing into maps and lists
+func renderValue(value interface{}, params map[string]interface{}, shell bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		if m := placeholderPattern.FindStringSubmatch(v); !shell && m != nil && m[0] == v {
+			return params[m[1]]
+		}
+		return placeholderPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			text := ""
+			if params[name] != nil {
+				text = fmt.Sprint(params[name])
+			}
+			if shell {
+				return ShellQuote(text)
+			}
+			return text
+		})
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered[key] = renderValue(item, params, shell)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered[i] = renderValue(item, params, shell)
+		}
+		return rendered
+	default:
+		return value
+	}
+}
+
+// walkStrings calls fn for every string in a payload value
+func walkStrings(value interface{}, fn func(string)) {
+	switch v := value.(type) {
+	case string:
+		fn(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	}
+}
+
+// ShellQuote quotes s as a single POSIX shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
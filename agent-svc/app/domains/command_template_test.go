@@ -0,0 +1,115 @@
+package domains
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func runTemplate(cmd string) *CommandTemplate {
+	return &CommandTemplate{
+		Name:        "t",
+		CommandType: "RunCommand",
+		Payload:     map[string]interface{}{"cmd": cmd},
+		Params:      []TemplateParam{{Name: "msg", Type: ParamString}, {Name: "n", Type: ParamInteger}},
+	}
+}
+
+func TestValidateShellPlaceholders(t *testing.T) {
+	tests := []struct {
+		cmd string
+		ok  bool
+	}{
+		{`echo {{msg}}`, true},
+		{`echo {{ msg }} {{n}}`, true},
+		{`echo prefix-{{msg}}-suffix`, true},
+		{`echo $(cat {{msg}})`, true},
+		{`systemctl restart {{msg}} && echo done`, true},
+		{`echo "quoted" {{msg}} 'also quoted'`, true},
+		{`echo "escaped \" quote" {{msg}}`, true},
+		{`cat <<<{{msg}}`, true},
+		{`echo {{msg}} # {{n}} in a comment`, true},
+
+		{`echo "{{msg}}"`, false},
+		{`echo "a {{msg}} b"`, false},
+		{`echo '{{msg}}'`, false},
+		{"echo `{{msg}}`", false},
+		{`echo "$(cat {{msg}})"`, false},
+		{`echo \{{msg}}`, false},
+		{`echo ${{msg}}`, false},
+		{"cat <<EOF\n{{msg}}\nEOF", false},
+	}
+	for _, tt := range tests {
+		err := runTemplate(tt.cmd).Validate()
+		if tt.ok && err != nil {
+			t.Errorf("Validate(%q) = %v, want nil", tt.cmd, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("Validate(%q) = %v, want ErrInvalidTemplate", tt.cmd, err)
+		}
+	}
+}
+
+func TestValidateShellPlaceholdersOnlyInShellFields(t *testing.T) {
+	tmpl := &CommandTemplate{
+		Name:        "t",
+		CommandType: "RunCommand",
+		Payload:     map[string]interface{}{"cmd": "true", "working_dir": `"{{msg}}"`},
+		Params:      []TemplateParam{{Name: "msg", Type: ParamString}},
+	}
+	if err := tmpl.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want placeholders in quotes allowed outside cmd", err)
+	}
+}
+
+func TestRenderQuotesShellValues(t *testing.T) {
+	tmpl := runTemplate(`echo {{msg}} {{n}}`)
+	payload := tmpl.Render(map[string]interface{}{"msg": "it's $(id); rm -rf /", "n": 3})
+	want := `echo 'it'\''s $(id); rm -rf /' '3'`
+	if payload["cmd"] != want {
+		t.Errorf("cmd = %q, want %q", payload["cmd"], want)
+	}
+
+	// Outside shell fields a whole-string placeholder keeps the value's type
+	tmpl.Payload["timeout_sec"] = "{{n}}"
+	payload = tmpl.Render(map[string]interface{}{"msg": "x", "n": 30})
+	if payload["timeout_sec"] != 30 {
+		t.Errorf("timeout_sec = %#v, want 30", payload["timeout_sec"])
+	}
+}
+
+// TestRenderShellInjection runs rendered commands through sh, as node-agent does, with values that try to
+// break out of their quoting
+func TestRenderShellInjection(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	values := []string{`$(echo INJECTED)`, "`echo INJECTED`", `'; echo INJECTED; '`, `x\`, `a b "c"`, "line\necho INJECTED"}
+	for _, cmd := range []string{`printf '%s\n' {{msg}}`, `printf '%s\n' pre{{msg}}post`} {
+		tmpl := runTemplate(cmd)
+		if err := tmpl.Validate(); err != nil {
+			t.Fatalf("Validate(%q) = %v", cmd, err)
+		}
+		for _, value := range values {
+			rendered := tmpl.Render(map[string]interface{}{"msg": value})["cmd"].(string)
+			out, err := exec.Command("sh", "-c", rendered).Output()
+			if err != nil {
+				t.Fatalf("sh -c %q: %v", rendered, err)
+			}
+			got := strings.TrimSuffix(string(out), "\n")
+			want := value
+			if strings.Contains(cmd, "pre{{msg}}post") {
+				want = "pre" + value + "post"
+			}
+			if got != want {
+				t.Errorf("sh -c %q printed %q, want %q", rendered, got, want)
+			}
+		}
+	}
+
+	// The injection a quoted placeholder allowed is now refused at validation
+	if err := runTemplate(`echo "{{msg}}"`).Validate(); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf(`Validate(echo "{{msg}}") = %v, want ErrInvalidTemplate`, err)
+	}
+}
@@ -0,0 +1,26 @@
+package domains
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateIdempotencyKey is returned by storage when an unexpired key already exists
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already exists")
+
+// ErrIdempotencyKeyMismatch is returned when a key is reused with a different request
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key was already used with a different request")
+
+// IdempotencyKey represents a stored command submission key
+type IdempotencyKey struct {
+	ID          int64     `db:"id"`
+	OperatorID  string    `db:"operator_id"`
+	NodeID      string    `db:"node_id"`
+	Key         string    `db:"idem_key"`
+	RequestHash string    `db:"request_hash"`
+	CommandID   uuid.UUID `db:"command_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
@@ -0,0 +1,30 @@
+package domains
+
+import "fmt"
+
+// NodeSelector matches nodes whose labels or attrs contain all of the given key/value pairs
+type NodeSelector map[string]string
+
+// MatchesNode reports whether a node's labels and attrs satisfy the selector
+// A label takes precedence over an attr of the same key. Attribute values are compared in their string form,
+// so {"cpu_cores": "4"} matches a numeric 4.
+func (s NodeSelector) MatchesNode(n *Node) bool {
+	for key, want := range s {
+		if label, ok := n.Labels[key]; ok {
+			if label != want {
+				return false
+			}
+			continue
+		}
+		if got, ok := n.Attrs[key]; !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// CommandTarget identifies the nodes a command is sent to: a single node or every node matching a selector
+type CommandTarget struct {
+	NodeID   string
+	Selector NodeSelector
+}
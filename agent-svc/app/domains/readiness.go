@@ -0,0 +1,63 @@
+package domains
+
+import "time"
+
+// Readiness component states
+const (
+	ComponentOK       = "ok"
+	ComponentDegraded = "degraded" // reported, but the replica still takes traffic
+	ComponentFailed   = "failed"   // the replica is not ready
+)
+
+// Background worker states
+const (
+	WorkerOK      = "ok"
+	WorkerFailing = "failing" // the last run returned an error
+	WorkerStalled = "stalled" // no run for more than twice its interval
+)
+
+// Readiness is the result of a readiness check
+// The replica is ready when the database and the migrations are ok; workers are reported only.
+type Readiness struct {
+	Ready      bool
+	CheckedAt  time.Time
+	Database   DatabaseHealth
+	Migrations MigrationHealth
+	Workers    []WorkerHealth
+}
+
+// DatabaseHealth reports whether the database answered a ping in time
+type DatabaseHealth struct {
+	Status  string
+	Latency time.Duration
+	Error   string
+}
+
+// MigrationHealth compares the applied migration version with the one this build expects
+type MigrationHealth struct {
+	Status          string
+	Version         uint
+	ExpectedVersion uint
+	Dirty           bool // a migration failed part way and needs manual repair
+	Error           string
+}
+
+// WorkerHealth reports the last run of a background worker
+type WorkerHealth struct {
+	Name          string
+	Status        string
+	Interval      time.Duration
+	LastRunAt     *time.Time
+	LastSuccessAt *time.Time
+	LastError     string
+}
+
+// WorkersStatus returns ComponentOK if every worker is ok, ComponentDegraded otherwise
+func (r *Readiness) WorkersStatus() string {
+	for _, w := range r.Workers {
+		if w.Status != WorkerOK {
+			return ComponentDegraded
+		}
+	}
+	return ComponentOK
+}
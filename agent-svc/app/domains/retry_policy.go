@@ -0,0 +1,104 @@
+package domains
+
+import (
+	"time"
+)
+
+// Retry policy defaults
+const (
+	DefaultRetryBackoffSec    = 10
+	DefaultRetryMultiplier    = 2.0
+	DefaultRetryMaxBackoffSec = 600
+)
+
+// OverallStatusRetrying is the overall outcome of a command while its next attempt is scheduled
+const OverallStatusRetrying = "retrying"
+
+// DefaultRetryOnStatuses are the statuses retried when a policy doesn't list any
+var DefaultRetryOnStatuses = []string{StatusFailed, StatusTimeout, StatusLost}
+
+// RetryPolicy describes how failed attempts of a command are retried by agent-svc
+// It is stored as JSON on every attempt of the command.
+type RetryPolicy struct {
+	MaxAttempts       int      `json:"max_attempts"`                  // total attempts, including the first
+	BackoffSec        int      `json:"backoff_sec"`                   // delay before the second attempt
+	BackoffMultiplier float64  `json:"backoff_multiplier"`            // delay growth per attempt
+	MaxBackoffSec     int      `json:"max_backoff_sec"`               // upper bound for the delay
+	RetryOnStatuses   []string `json:"retry_on_statuses"`             // terminal statuses that are retried
+	RetryOnExitCodes  []int    `json:"retry_on_exit_codes,omitempty"` // if set, failed attempts are only retried for these exit codes
+}
+
+// ApplyDefaults fills in unset fields with the defaults
+func (p *RetryPolicy) ApplyDefaults() {
+	if p.BackoffSec <= 0 {
+		p.BackoffSec = DefaultRetryBackoffSec
+	}
+	if p.BackoffMultiplier < 1 {
+		p.BackoffMultiplier = DefaultRetryMultiplier
+	}
+	if p.MaxBackoffSec <= 0 {
+		p.MaxBackoffSec = DefaultRetryMaxBackoffSec
+	}
+	if len(p.RetryOnStatuses) == 0 {
+		p.RetryOnStatuses = append([]string(nil), DefaultRetryOnStatuses...)
+	}
+}
+
+// ShouldRetry reports whether an attempt that finished with status and exitCode gets another attempt
+func (p *RetryPolicy) ShouldRetry(attempt int, status string, exitCode *int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	retryable := false
+	for _, s := range p.RetryOnStatuses {
+		if s == status {
+			retryable = true
+			break
+		}
+	}
+	if !retryable {
+		return false
+	}
+
+	if status != StatusFailed || len(p.RetryOnExitCodes) == 0 {
+		return true
+	}
+	if exitCode == nil {
+		return false
+	}
+	for _, code := range p.RetryOnExitCodes {
+		if code == *exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before the attempt following the given one
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.BackoffSec)
+	for i := 1; i < attempt; i++ {
+		delay *= p.BackoffMultiplier
+		if delay >= float64(p.MaxBackoffSec) {
+			break
+		}
+	}
+	if delay > float64(p.MaxBackoffSec) {
+		delay = float64(p.MaxBackoffSec)
+	}
+	return time.Duration(delay * float64(time.Second))
+}
+
+// OverallStatus returns the outcome of a command across its attempts, ordered by attempt
+// It is the status of the latest attempt, or OverallStatusRetrying while another attempt is scheduled.
+func OverallStatus(attempts []*NodeCommand) string {
+	if len(attempts) == 0 {
+		return ""
+	}
+	last := attempts[len(attempts)-1]
+	if last.NextRetryAt != nil {
+		return OverallStatusRetrying
+	}
+	return last.Status
+}
@@ -0,0 +1,170 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidRollout is returned when a rollout definition is rejected
+var ErrInvalidRollout = errors.New("invalid rollout")
+
+// ErrRolloutState is returned when a rollout cannot be paused, resumed or aborted in its current status
+var ErrRolloutState = errors.New("rollout cannot be changed in its current status")
+
+// Rollout statuses
+const (
+	RolloutRunning   = "running"   // batches are started as earlier ones finish
+	RolloutPaused    = "paused"    // paused by an operator; the running batch still finishes
+	RolloutHalted    = "halted"    // stopped by the canary or the failure threshold; can be resumed
+	RolloutAborted   = "aborted"   // stopped by an operator; queued commands were cancelled
+	RolloutCompleted = "completed" // every batch ran
+)
+
+// Rollout batch statuses
+const (
+	BatchPending   = "pending"
+	BatchRunning   = "running"
+	BatchSucceeded = "succeeded" // every target node ran the command successfully
+	BatchFailed    = "failed"    // a command did not succeed or could not be submitted
+)
+
+// RolloutStrategy controls how a rollout is split into batches and when it stops
+type RolloutStrategy struct {
+	BatchSize              int      `json:"batch_size,omitempty"`    // nodes per batch, or
+	BatchPercent           int      `json:"batch_percent,omitempty"` // percentage of the non-canary nodes per batch
+	PauseBetweenBatchesSec int      `json:"pause_between_batches_sec,omitempty"`
+	CanaryNodeIDs          []string `json:"canary_node_ids,omitempty"` // nodes of the canary batch, or
+	CanarySize             int      `json:"canary_size,omitempty"`     // number of nodes in the canary batch
+	MaxFailurePercent      int      `json:"max_failure_percent"`       // halt when more of the finished nodes failed
+}
+
+// Validate checks that the strategy sets one batch size and at most one canary definition
+func (s *RolloutStrategy) Validate() error {
+	if (s.BatchSize > 0) == (s.BatchPercent > 0) {
+		return fmt.Errorf("%w: exactly one of batch_size and batch_percent must be set", ErrInvalidRollout)
+	}
+	if s.BatchSize < 0 || s.BatchPercent < 0 || s.BatchPercent > 100 {
+		return fmt.Errorf("%w: batch_size must be positive and batch_percent between 1 and 100", ErrInvalidRollout)
+	}
+	if len(s.CanaryNodeIDs) > 0 && s.CanarySize > 0 {
+		return fmt.Errorf("%w: at most one of canary_node_ids and canary_size may be set", ErrInvalidRollout)
+	}
+	if s.CanarySize < 0 || s.PauseBetweenBatchesSec < 0 {
+		return fmt.Errorf("%w: canary_size and pause_between_batches_sec must not be negative", ErrInvalidRollout)
+	}
+	if s.MaxFailurePercent < 0 || s.MaxFailurePercent > 100 {
+		return fmt.Errorf("%w: max_failure_percent must be between 0 and 100", ErrInvalidRollout)
+	}
+	return nil
+}
+
+// PlanBatches splits the target nodes into batches, the canary batch first
+// Nodes are ordered by ID so the plan does not depend on the order nodes were resolved in.
+func (s *RolloutStrategy) PlanBatches(nodeIDs []string) ([]RolloutBatch, error) {
+	nodes := append([]string(nil), nodeIDs...)
+	sort.Strings(nodes)
+
+	var batches []RolloutBatch
+	var canary []string
+	switch {
+	case len(s.CanaryNodeIDs) > 0:
+		targets := make(map[string]bool, len(nodes))
+		for _, nodeID := range nodes {
+			targets[nodeID] = true
+		}
+		inCanary := make(map[string]bool, len(s.CanaryNodeIDs))
+		for _, nodeID := range s.CanaryNodeIDs {
+			if !targets[nodeID] {
+				return nil, fmt.Errorf("%w: canary node %s is not a target of the rollout", ErrInvalidRollout, nodeID)
+			}
+			if !inCanary[nodeID] {
+				inCanary[nodeID] = true
+				canary = append(canary, nodeID)
+			}
+		}
+		rest := nodes[:0]
+		for _, nodeID := range nodes {
+			if !inCanary[nodeID] {
+				rest = append(rest, nodeID)
+			}
+		}
+		nodes = rest
+	case s.CanarySize > 0:
+		n := s.CanarySize
+		if n > len(nodes) {
+			n = len(nodes)
+		}
+		canary, nodes = nodes[:n], nodes[n:]
+	}
+	if len(canary) > 0 {
+		batches = append(batches, RolloutBatch{Canary: true, NodeIDs: canary})
+	}
+
+	size := s.BatchSize
+	if s.BatchPercent > 0 {
+		size = (len(nodes)*s.BatchPercent + 99) / 100
+		if size < 1 {
+			size = 1
+		}
+	}
+	for start := 0; start < len(nodes); start += size {
+		end := start + size
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		batches = append(batches, RolloutBatch{NodeIDs: nodes[start:end]})
+	}
+
+	for i := range batches {
+		batches[i].BatchIndex = i
+		batches[i].Status = BatchPending
+	}
+	return batches, nil
+}
+
+// ExceedsFailureThreshold reports whether failed out of finished nodes is above the allowed failure rate
+func (s *RolloutStrategy) ExceedsFailureThreshold(failed, finished int) bool {
+	return finished > 0 && failed*100 > s.MaxFailurePercent*finished
+}
+
+// Rollout represents a command rolled out to a set of nodes in batches
+type Rollout struct {
+	ID          int64                  `db:"id"`
+	RolloutID   uuid.UUID              `db:"rollout_id"`
+	TenantID    string                 `db:"tenant_id"`
+	Name        string                 `db:"name"`
+	CommandType string                 `db:"command_type"`
+	Payload     map[string]interface{} `db:"payload"`
+	Priority    int                    `db:"priority"`
+	Strategy    RolloutStrategy        `db:"strategy"`
+	Status      string                 `db:"status"`
+	NextBatchAt *time.Time             `db:"next_batch_at"` // earliest start of the next batch; nil means now
+	ErrorMsg    *string                `db:"error_msg"`     // why the rollout halted
+	CreatedAt   time.Time              `db:"created_at"`
+	UpdatedAt   time.Time              `db:"updated_at"`
+	FinishedAt  *time.Time             `db:"finished_at"`
+}
+
+// RolloutBatch represents one wave of a rollout
+type RolloutBatch struct {
+	ID             int64       `db:"id"`
+	RolloutID      uuid.UUID   `db:"rollout_id"`
+	BatchIndex     int         `db:"batch_index"`
+	Canary         bool        `db:"canary"`
+	NodeIDs        []string    `db:"node_ids"`
+	CommandIDs     []uuid.UUID `db:"command_ids"`
+	Status         string      `db:"status"`
+	SucceededCount int         `db:"succeeded_count"` // nodes whose command succeeded, set when the batch finishes
+	ErrorMsg       *string     `db:"error_msg"`
+	StartedAt      *time.Time  `db:"started_at"`
+	FinishedAt     *time.Time  `db:"finished_at"`
+}
+
+// IsBatchFinished reports whether a batch status is final
+func IsBatchFinished(status string) bool {
+	return status == BatchSucceeded || status == BatchFailed
+}
@@ -0,0 +1,107 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// ErrInvalidSchedule is returned when a schedule definition is rejected
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Misfire policies decide what happens to a fire time that was missed by more than the grace period,
+// for example because agent-svc was down
+const (
+	MisfireRunOnce = "run_once" // run once now for all missed fire times
+	MisfireSkip    = "skip"     // skip missed fire times and wait for the next one
+)
+
+// Schedule run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Schedule run statuses
+const (
+	ScheduleRunCreated = "created" // commands created for every target
+	ScheduleRunPartial = "partial" // commands created for some targets
+	ScheduleRunFailed  = "failed"  // no command created
+	ScheduleRunSkipped = "skipped" // missed fire time skipped by the misfire policy
+)
+
+// Schedule represents a recurring command
+type Schedule struct {
+	ID              int64                  `db:"id"`
+	ScheduleID      uuid.UUID              `db:"schedule_id"`
+	TenantID        string                 `db:"tenant_id"`
+	Name            string                 `db:"name"`
+	CronExpr        string                 `db:"cron_expr"`
+	Timezone        string                 `db:"timezone"`
+	NodeID          *string                `db:"node_id"`
+	Selector        NodeSelector           `db:"selector"`
+	CommandType     string                 `db:"command_type"`
+	Payload         map[string]interface{} `db:"payload"`
+	Priority        int                    `db:"priority"`
+	MisfirePolicy   string                 `db:"misfire_policy"`
+	MisfireGraceSec int                    `db:"misfire_grace_sec"`
+	Paused          bool                   `db:"paused"`
+	NextRunAt       *time.Time             `db:"next_run_at"` // nil while paused
+	LastRunAt       *time.Time             `db:"last_run_at"`
+	CreatedAt       time.Time              `db:"created_at"`
+	UpdatedAt       time.Time              `db:"updated_at"`
+}
+
+// Target returns the nodes the schedule sends its commands to
+func (s *Schedule) Target() CommandTarget {
+	target := CommandTarget{Selector: s.Selector}
+	if s.NodeID != nil {
+		target.NodeID = *s.NodeID
+	}
+	return target
+}
+
+// NextRun returns the first fire time of the schedule after t
+func (s *Schedule) NextRun(t time.Time) (time.Time, error) {
+	return NextCronTime(s.CronExpr, s.Timezone, t)
+}
+
+// Misfired reports whether a fire time was missed by more than the grace period
+func (s *Schedule) Misfired(scheduledFor, now time.Time) bool {
+	return now.Sub(scheduledFor) > time.Duration(s.MisfireGraceSec)*time.Second
+}
+
+// NextCronTime returns the first time after t matching a standard 5-field cron expression
+// (or a descriptor such as @daily) evaluated in the given IANA timezone
+func NextCronTime(expr, timezone string, t time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never fires", expr)
+	}
+	return next, nil
+}
+
+// ScheduleRun records one firing of a schedule and the commands it produced
+type ScheduleRun struct {
+	ID           int64       `db:"id"`
+	ScheduleID   uuid.UUID   `db:"schedule_id"`
+	ScheduledFor time.Time   `db:"scheduled_for"`
+	Trigger      string      `db:"trigger"`
+	Status       string      `db:"status"`
+	CommandIDs   []uuid.UUID `db:"command_ids"`
+	ErrorMsg     *string     `db:"error_msg"`
+	CreatedAt    time.Time   `db:"created_at"`
+}
@@ -0,0 +1,98 @@
+package domains
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultTenantID is the tenant of nodes enrolled without one and of operators who belong to no tenant
+// It needs no enrollment key, so single-team deployments work without setting up tenants.
+const DefaultTenantID = "default"
+
+// ErrInvalidTenant is returned when a tenant definition is rejected
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// ErrTenantExists is returned by storage when a tenant ID is already taken
+var ErrTenantExists = errors.New("tenant already exists")
+
+// ErrTenantQuotaExceeded is returned by storage when a node or command would exceed a tenant quota
+var ErrTenantQuotaExceeded = errors.New("tenant quota exceeded")
+
+// ErrNodeInOtherTenant is returned by storage when a node ID is already enrolled in another tenant
+var ErrNodeInOtherTenant = errors.New("node is enrolled in another tenant")
+
+// ErrTenantAccessDenied is returned when an operator acts in a tenant they don't belong to
+var ErrTenantAccessDenied = errors.New("operator does not belong to the tenant")
+
+// ErrTenantRequired is returned when an operator who may act in every tenant creates or names a tenant-owned
+// resource without choosing a tenant
+var ErrTenantRequired = errors.New("a tenant is required; set X-Tenant-ID")
+
+// ErrEnrollmentDenied is returned when a node names an unknown tenant or a wrong enrollment key
+var ErrEnrollmentDenied = errors.New("enrollment denied")
+
+// tenantIDPattern restricts tenant IDs to lowercase slugs
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant is an organization whose operators see and command only its own nodes
+type Tenant struct {
+	ID       int64  `db:"id"`
+	TenantID string `db:"tenant_id"`
+	Name     string `db:"name"`
+	// EnrollmentKeyHash is the SHA-256 of the key nodes present to enroll; empty means no key is needed
+	EnrollmentKeyHash string `db:"enrollment_key_hash"`
+	// Quotas; zero means unlimited. Commands count first attempts submitted in the last 24 hours.
+	MaxNodes          int       `db:"max_nodes"`
+	MaxCommandsPerDay int       `db:"max_commands_per_day"`
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
+}
+
+// TenantUsage is what a tenant currently counts against its quotas
+type TenantUsage struct {
+	Nodes          int
+	CommandsPerDay int
+}
+
+// Validate checks the tenant ID and quotas
+func (t *Tenant) Validate() error {
+	if !tenantIDPattern.MatchString(t.TenantID) {
+		return fmt.Errorf("%w: tenant_id must be a lowercase slug of at most 63 characters", ErrInvalidTenant)
+	}
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTenant)
+	}
+	if t.MaxNodes < 0 || t.MaxCommandsPerDay < 0 {
+		return fmt.Errorf("%w: quotas must not be negative", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// CheckEnrollmentKey reports whether key may enroll nodes into the tenant
+func (t *Tenant) CheckEnrollmentKey(key string) bool {
+	if t.EnrollmentKeyHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(HashEnrollmentKey(key)), []byte(t.EnrollmentKeyHash)) == 1
+}
+
+// HashEnrollmentKey returns the stored form of an enrollment key
+func HashEnrollmentKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NodeQuotaError returns the error for a tenant that has reached its node quota
+func NodeQuotaError(tenantID string, maxNodes int) error {
+	return fmt.Errorf("%w: tenant %s may enroll at most %d nodes", ErrTenantQuotaExceeded, tenantID, maxNodes)
+}
+
+// CommandQuotaError returns the error for a tenant that has reached its daily command quota
+func CommandQuotaError(tenantID string, maxCommands int) error {
+	return fmt.Errorf("%w: tenant %s may submit at most %d commands per day", ErrTenantQuotaExceeded, tenantID, maxCommands)
+}
@@ -0,0 +1,127 @@
+package domains
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidWebhook is returned when a webhook subscription is rejected
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// ErrWebhookDeliveryState is returned when redelivering a delivery that is not dead
+var ErrWebhookDeliveryState = errors.New("webhook delivery is not dead")
+
+// Webhook event types
+// Command events are named command.<status> after the terminal status the command reached.
+const (
+	EventCommandPrefix = "command."
+	EventNodeOffline   = "node.offline" // no heartbeat within the offline threshold
+	EventNodeOnline    = "node.online"  // heartbeat from a node that was offline
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // every attempt failed; retried only on request
+)
+
+// Webhook delivery backoff: 10s, 20s, 40s, ... capped at an hour
+const (
+	webhookBackoffBase = 10 * time.Second
+	webhookBackoffMax  = time.Hour
+)
+
+// CommandEventType returns the event type emitted when a command reaches a terminal status
+func CommandEventType(status string) string {
+	return EventCommandPrefix + status
+}
+
+// IsValidEventFilter reports whether a subscription filter names a known event type or a wildcard:
+// "*" for every event, "command.*" or "node.*" for a family
+func IsValidEventFilter(filter string) bool {
+	switch filter {
+	case "*", "command.*", "node.*", EventNodeOffline, EventNodeOnline:
+		return true
+	}
+	status := strings.TrimPrefix(filter, EventCommandPrefix)
+	return status != filter && IsTerminalStatus(status)
+}
+
+// MatchesEventType reports whether any of a subscription's filters matches an event type
+func MatchesEventType(filters []string, eventType string) bool {
+	family := eventType
+	if i := strings.Index(eventType, "."); i >= 0 {
+		family = eventType[:i]
+	}
+	for _, filter := range filters {
+		if filter == "*" || filter == eventType || filter == family+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookBackoff returns the delay before the next delivery attempt after attempt failed attempts
+func WebhookBackoff(attempt int) time.Duration {
+	delay := webhookBackoffBase
+	for i := 1; i < attempt && delay < webhookBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookBackoffMax {
+		delay = webhookBackoffMax
+	}
+	return delay
+}
+
+// WebhookSubscription represents an endpoint notified of events
+type WebhookSubscription struct {
+	ID         int64     `db:"id"`
+	WebhookID  uuid.UUID `db:"webhook_id"`
+	TenantID   string    `db:"tenant_id"` // receives only the events of the tenant's nodes
+	URL        string    `db:"url"`
+	EventTypes []string  `db:"event_types"` // filters, see MatchesEventType
+	Secret     string    `db:"secret"`      // HMAC-SHA256 signing key
+	Enabled    bool      `db:"enabled"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+// WebhookEvent represents an event in the webhook outbox
+type WebhookEvent struct {
+	ID        int64                  `db:"id"`
+	EventID   uuid.UUID              `db:"event_id"`
+	TenantID  string                 `db:"tenant_id"` // tenant of the node the event is about
+	EventType string                 `db:"event_type"`
+	Payload   map[string]interface{} `db:"payload"`
+	CreatedAt time.Time              `db:"created_at"`
+}
+
+// WebhookDelivery represents the delivery of one event to one subscription
+type WebhookDelivery struct {
+	ID               int64      `db:"id"`
+	DeliveryID       uuid.UUID  `db:"delivery_id"`
+	WebhookID        uuid.UUID  `db:"webhook_id"`
+	EventID          uuid.UUID  `db:"event_id"`
+	EventType        string     `db:"event_type"`
+	Status           string     `db:"status"`
+	Attempts         int        `db:"attempts"`
+	NextAttemptAt    *time.Time `db:"next_attempt_at"` // nil once delivered or dead
+	LastResponseCode *int       `db:"last_response_code"`
+	LastError        *string    `db:"last_error"`
+	DeliveredAt      *time.Time `db:"delivered_at"`
+	CreatedAt        time.Time  `db:"created_at"`
+	UpdatedAt        time.Time  `db:"updated_at"`
+}
+
+// WebhookDeliveryJob is a claimed delivery with everything needed to send it
+type WebhookDeliveryJob struct {
+	DeliveryID uuid.UUID
+	Attempt    int // the attempt about to be made, starting at 1
+	URL        string
+	Secret     string
+	Event      WebhookEvent
+}
@@ -0,0 +1,204 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidWorkflow is returned when a workflow definition is rejected
+var ErrInvalidWorkflow = errors.New("invalid workflow")
+
+// Workflow statuses
+const (
+	WorkflowRunning   = "running"
+	WorkflowSucceeded = "succeeded"
+	WorkflowFailed    = "failed"
+)
+
+// Workflow step statuses
+const (
+	StepPending   = "pending"   // waiting for its predecessors
+	StepRunning   = "running"   // commands submitted
+	StepSucceeded = "succeeded" // every command succeeded
+	StepFailed    = "failed"    // a command did not succeed, or none could be submitted
+	StepSkipped   = "skipped"   // predecessors finished without taking an edge to the step
+)
+
+// WorkflowDefinition is a DAG of command steps connected by on_success and on_failure edges
+// Steps without incoming edges start when the workflow starts. A step starts once all steps with an edge
+// to it have finished, if at least one of those edges was taken; otherwise it is skipped.
+type WorkflowDefinition struct {
+	Name  string         `json:"name" yaml:"name"`
+	Steps []WorkflowStep `json:"steps" yaml:"steps"`
+}
+
+// WorkflowStep is a command sent to a node or to every node matching a selector
+type WorkflowStep struct {
+	ID          string                 `json:"id" yaml:"id"`
+	NodeID      string                 `json:"node_id,omitempty" yaml:"node_id,omitempty"`
+	Selector    NodeSelector           `json:"selector,omitempty" yaml:"selector,omitempty"`
+	CommandType string                 `json:"command_type" yaml:"command_type"`
+	Payload     map[string]interface{} `json:"payload" yaml:"payload"`
+	OnSuccess   []string               `json:"on_success,omitempty" yaml:"on_success,omitempty"`
+	OnFailure   []string               `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
+}
+
+// Target returns the nodes the step sends its command to
+func (s *WorkflowStep) Target() CommandTarget {
+	return CommandTarget{NodeID: s.NodeID, Selector: s.Selector}
+}
+
+// Validate checks that step IDs are unique, edges point to existing steps and the graph has no cycles
+func (d *WorkflowDefinition) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidWorkflow)
+	}
+	if len(d.Steps) == 0 {
+		return fmt.Errorf("%w: at least one step is required", ErrInvalidWorkflow)
+	}
+
+	steps := make(map[string]*WorkflowStep, len(d.Steps))
+	for i := range d.Steps {
+		step := &d.Steps[i]
+		if step.ID == "" {
+			return fmt.Errorf("%w: step %d has no id", ErrInvalidWorkflow, i)
+		}
+		if _, exists := steps[step.ID]; exists {
+			return fmt.Errorf("%w: duplicate step id %q", ErrInvalidWorkflow, step.ID)
+		}
+		if (step.NodeID == "") == (len(step.Selector) == 0) {
+			return fmt.Errorf("%w: step %q must set exactly one of node_id and selector", ErrInvalidWorkflow, step.ID)
+		}
+		if step.CommandType == "" {
+			return fmt.Errorf("%w: step %q has no command_type", ErrInvalidWorkflow, step.ID)
+		}
+		steps[step.ID] = step
+	}
+
+	for _, step := range d.Steps {
+		for _, next := range append(append([]string(nil), step.OnSuccess...), step.OnFailure...) {
+			if _, exists := steps[next]; !exists {
+				return fmt.Errorf("%w: step %q points to unknown step %q", ErrInvalidWorkflow, step.ID, next)
+			}
+		}
+	}
+
+	// Depth-first search for back edges
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("%w: steps form a cycle through %q", ErrInvalidWorkflow, id)
+		case done:
+			return nil
+		}
+		state[id] = visiting
+		for _, next := range d.Successors(id) {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		return nil
+	}
+	for _, step := range d.Steps {
+		if err := visit(step.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step returns the step with the given ID, or nil
+func (d *WorkflowDefinition) Step(id string) *WorkflowStep {
+	for i := range d.Steps {
+		if d.Steps[i].ID == id {
+			return &d.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Successors returns the IDs of the steps a step has an edge to
+func (d *WorkflowDefinition) Successors(id string) []string {
+	step := d.Step(id)
+	if step == nil {
+		return nil
+	}
+	return append(append([]string(nil), step.OnSuccess...), step.OnFailure...)
+}
+
+// Predecessors returns the IDs of the steps with an edge to a step
+func (d *WorkflowDefinition) Predecessors(id string) []string {
+	var preds []string
+	for _, step := range d.Steps {
+		for _, next := range d.Successors(step.ID) {
+			if next == id {
+				preds = append(preds, step.ID)
+				break
+			}
+		}
+	}
+	return preds
+}
+
+// Triggers reports whether a finished step takes an edge to next given its status
+func (d *WorkflowDefinition) Triggers(id, status, next string) bool {
+	step := d.Step(id)
+	if step == nil {
+		return false
+	}
+
+	var edges []string
+	switch status {
+	case StepSucceeded:
+		edges = step.OnSuccess
+	case StepFailed:
+		edges = step.OnFailure
+	}
+	for _, edge := range edges {
+		if edge == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStepFinished reports whether a step status is final
+func IsStepFinished(status string) bool {
+	return status == StepSucceeded || status == StepFailed || status == StepSkipped
+}
+
+// Workflow represents a persisted workflow run
+type Workflow struct {
+	ID         int64              `db:"id"`
+	WorkflowID uuid.UUID          `db:"workflow_id"`
+	TenantID   string             `db:"tenant_id"`
+	Name       string             `db:"name"`
+	Definition WorkflowDefinition `db:"definition"`
+	Status     string             `db:"status"`
+	CreatedAt  time.Time          `db:"created_at"`
+	UpdatedAt  time.Time          `db:"updated_at"`
+	FinishedAt *time.Time         `db:"finished_at"`
+}
+
+// WorkflowStepState represents the progress of one step of a workflow run
+type WorkflowStepState struct {
+	ID         int64       `db:"id"`
+	WorkflowID uuid.UUID   `db:"workflow_id"`
+	StepID     string      `db:"step_id"`
+	Status     string      `db:"status"`
+	CommandIDs []uuid.UUID `db:"command_ids"`
+	ErrorMsg   *string     `db:"error_msg"`
+	StartedAt  *time.Time  `db:"started_at"`
+	FinishedAt *time.Time  `db:"finished_at"`
+}
@@ -0,0 +1,240 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/metrics"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+	"agent-svc/rpc"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// agentServer implements AgentService, the node routes of CommandHandler and AgentHandler over one stream
+type agentServer struct {
+	rpc.UnimplementedAgentServiceServer
+	commandService *services.CommandService
+	logService     *services.LogService
+	storage        clients.StorageAdapter
+}
+
+// AgentSession answers the requests of a node until it closes the stream or its token expires
+// Requests are handled concurrently so that a long poll doesn't hold up heartbeats and log pushes.
+func (s *agentServer) AgentSession(stream rpc.AgentService_AgentSessionServer) error {
+	claims := getClaims(stream.Context())
+	if claims == nil {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var sendMu sync.Mutex
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			wg.Wait()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if claims.ExpiresAt != nil && time.Now().After(claims.ExpiresAt.Time) {
+			return status.Error(codes.Unauthenticated, "token expired")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := s.handle(ctx, claims.NodeID, msg)
+			reply.RequestId = msg.RequestId
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			// A failed send means the stream is gone, which ends the Recv loop too
+			_ = stream.Send(reply)
+		}()
+	}
+}
+
+// handle answers one request of a node
+func (s *agentServer) handle(ctx context.Context, nodeID string, msg *rpc.AgentMessage) *rpc.ServerMessage {
+	switch body := msg.Body.(type) {
+	case *rpc.AgentMessage_Heartbeat:
+		return s.heartbeat(ctx, nodeID, body.Heartbeat)
+	case *rpc.AgentMessage_PollCommands:
+		return s.pollCommands(ctx, nodeID, body.PollCommands)
+	case *rpc.AgentMessage_PushLogs:
+		return s.pushLogs(ctx, nodeID, body.PushLogs)
+	case *rpc.AgentMessage_UpdateStatus:
+		return s.updateStatus(ctx, nodeID, body.UpdateStatus)
+	default:
+		return errorReply(codes.InvalidArgument, "empty request")
+	}
+}
+
+// heartbeat marks the node as seen, like POST /v1/agents/heartbeat
+func (s *agentServer) heartbeat(ctx context.Context, nodeID string, req *rpc.Heartbeat) *rpc.ServerMessage {
+	node, err := s.storage.GetNode(ctx, nodeID)
+	if err != nil {
+		return errorReply(codes.Internal, "failed to check node")
+	}
+	if node == nil {
+		return errorReply(codes.NotFound, "node not found")
+	}
+
+	if err := s.storage.UpdateNodeLastSeen(ctx, nodeID); err != nil {
+		return errorReply(codes.Internal, "failed to update heartbeat")
+	}
+	if req.GetPolicyHash() != node.LocalPolicyHash {
+		if _, err := s.storage.SetNodePolicyHash(ctx, nodeID, req.GetPolicyHash()); err != nil {
+			return errorReply(codes.Internal, "failed to update heartbeat")
+		}
+	}
+	return ackReply()
+}
+
+// pollCommands waits for queued commands and dispatches them, like GET /v1/commands/next
+func (s *agentServer) pollCommands(ctx context.Context, nodeID string, req *rpc.PollCommands) *rpc.ServerMessage {
+	waitSeconds := 30
+	if req.WaitSec > 0 && req.WaitSec <= 60 {
+		waitSeconds = int(req.WaitSec)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(waitSeconds)*time.Second)
+	defer cancel()
+
+	metrics.LongPollsOpen.Inc()
+	defer metrics.LongPollsOpen.Dec()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &rpc.ServerMessage{Body: &rpc.ServerMessage_Commands{Commands: &rpc.Commands{}}}
+		case <-ticker.C:
+			cmds, err := s.commandService.GetNextCommand(ctx, nodeID)
+			if err != nil {
+				return errorReply(codes.Internal, "failed to get command")
+			}
+			if len(cmds) == 0 {
+				continue
+			}
+			commands := &rpc.Commands{Commands: make([]*rpc.DispatchedCommand, len(cmds))}
+			for i, cmd := range cmds {
+				dispatched, err := toDispatchedCommand(cmd)
+				if err != nil {
+					return errorReply(codes.Internal, err.Error())
+				}
+				commands.Commands[i] = dispatched
+			}
+			return &rpc.ServerMessage{Body: &rpc.ServerMessage_Commands{Commands: commands}}
+		}
+	}
+}
+
+// pushLogs stores output chunks of a command, like POST /v1/commands/logs
+func (s *agentServer) pushLogs(ctx context.Context, nodeID string, req *rpc.PushLogs) *rpc.ServerMessage {
+	push := dto.PushCommandLogsRequest{CommandID: req.CommandId, Chunks: make([]dto.LogChunkRequest, len(req.Chunks))}
+	for i, chunk := range req.Chunks {
+		push.Chunks[i] = dto.LogChunkRequest{
+			ChunkIndex: chunk.ChunkIndex,
+			Stream:     chunk.Stream,
+			Data:       chunk.Data,
+			IsFinal:    chunk.IsFinal,
+		}
+	}
+	if err := utils.ValidateStruct(&push); err != nil {
+		return errorReply(codes.InvalidArgument, err.Error())
+	}
+
+	commandID, err := uuid.Parse(req.CommandId)
+	if err != nil {
+		return errorReply(codes.InvalidArgument, "invalid command_id")
+	}
+
+	chunks := make([]domains.CommandLog, len(push.Chunks))
+	for i, chunk := range push.Chunks {
+		chunks[i] = domains.CommandLog{
+			CommandID:  req.CommandId,
+			ChunkIndex: chunk.ChunkIndex,
+			Stream:     chunk.Stream,
+			Data:       chunk.Data,
+			Encoding:   "utf-8",
+			IsFinal:    chunk.IsFinal,
+		}
+	}
+
+	ackedChunkIndexes, err := s.logService.PushCommandLogs(ctx, commandID, nodeID, chunks)
+	if err != nil {
+		return errorReply(codes.InvalidArgument, err.Error())
+	}
+	return &rpc.ServerMessage{Body: &rpc.ServerMessage_LogAck{LogAck: &rpc.LogAck{AckedChunkIndexes: ackedChunkIndexes}}}
+}
+
+// updateStatus reports a status of a command, like POST /v1/commands/status
+func (s *agentServer) updateStatus(ctx context.Context, nodeID string, req *rpc.UpdateStatus) *rpc.ServerMessage {
+	update := dto.CommandStatusRequest{
+		CommandID:       req.CommandId,
+		Status:          req.Status,
+		ErrorMsg:        req.ErrorMsg,
+		OutputBytes:     req.OutputBytes,
+		OutputTruncated: req.OutputTruncated,
+	}
+	if req.ExitCode != nil {
+		exitCode := int(*req.ExitCode)
+		update.ExitCode = &exitCode
+	}
+	if err := utils.ValidateStruct(&update); err != nil {
+		return errorReply(codes.InvalidArgument, err.Error())
+	}
+
+	commandID, err := uuid.Parse(req.CommandId)
+	if err != nil {
+		return errorReply(codes.InvalidArgument, "invalid command_id")
+	}
+
+	var errorMsg *string
+	if update.ErrorMsg != "" {
+		errorMsg = &update.ErrorMsg
+	}
+
+	var output *domains.CommandOutput
+	if update.OutputBytes != nil {
+		output = &domains.CommandOutput{
+			TotalBytes: *update.OutputBytes,
+			Truncated:  update.OutputTruncated,
+		}
+	}
+
+	if err := s.commandService.UpdateCommandStatus(ctx, commandID, nodeID, update.Status, update.ExitCode, errorMsg, output); err != nil {
+		if errors.Is(err, domains.ErrInvalidStatusTransition) {
+			return errorReply(codes.FailedPrecondition, err.Error())
+		}
+		return errorReply(codes.InvalidArgument, err.Error())
+	}
+	return ackReply()
+}
+
+func ackReply() *rpc.ServerMessage {
+	return &rpc.ServerMessage{Body: &rpc.ServerMessage_Ack{Ack: &rpc.Ack{}}}
+}
+
+func errorReply(code codes.Code, message string) *rpc.ServerMessage {
+	return &rpc.ServerMessage{Body: &rpc.ServerMessage_Error{Error: &rpc.Error{Code: int32(code), Message: message}}}
+}
@@ -0,0 +1,131 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/rpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type contextKey int
+
+const (
+	tenantContextKey contextKey = iota
+	operatorContextKey
+	rolesContextKey
+	claimsContextKey
+)
+
+// authenticator authenticates calls like the HTTP routes do
+// AgentService calls need a node token, as the node routes do; CommandService and LogService calls need an
+// operator token and are scoped to the operator's tenant, as TenantScope scopes operator routes. Other
+// services, such as reflection, need no credentials.
+type authenticator struct {
+	tenantService *services.TenantService
+	jwtService    *services.JWTService
+}
+
+func (a *authenticator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate returns ctx with the node's claims or the operator and tenant of the call to fullMethod
+func (a *authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	creds := services.ReadCredentials(func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	})
+
+	switch {
+	case inService(fullMethod, rpc.AgentService_ServiceDesc):
+		claims, err := a.jwtService.AuthenticateNode(creds)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return context.WithValue(ctx, claimsContextKey, claims), nil
+
+	case inService(fullMethod, rpc.CommandService_ServiceDesc), inService(fullMethod, rpc.LogService_ServiceDesc):
+		claims, err := a.jwtService.AuthenticateOperator(creds)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid operator token")
+		}
+		tenantID, err := a.tenantService.ResolveTenant(ctx, claims.Operator, creds.TenantID)
+		if err != nil {
+			switch {
+			case errors.Is(err, domains.ErrTenantAccessDenied):
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			case errors.Is(err, domains.ErrInvalidTenant):
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			default:
+				return nil, status.Error(codes.Internal, "failed to resolve tenant")
+			}
+		}
+		ctx = context.WithValue(ctx, operatorContextKey, claims.Operator)
+		ctx = context.WithValue(ctx, rolesContextKey, claims.Roles)
+		return context.WithValue(ctx, tenantContextKey, tenantID), nil
+	}
+
+	return ctx, nil
+}
+
+// inService reports whether fullMethod, as in "/agentsvc.v1.AgentService/AgentSession", belongs to desc
+func inService(fullMethod string, desc grpc.ServiceDesc) bool {
+	return strings.HasPrefix(fullMethod, "/"+desc.ServiceName+"/")
+}
+
+// authenticatedStream is a server stream whose context carries what authenticate added
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// getTenantID returns the tenant an operator call is scoped to
+func getTenantID(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantContextKey).(string)
+	return tenantID
+}
+
+// getOperatorID returns the operator making an operator call
+func getOperatorID(ctx context.Context) string {
+	operatorID, _ := ctx.Value(operatorContextKey).(string)
+	return operatorID
+}
+
+// getOperatorRoles returns the roles of the operator making an operator call
+func getOperatorRoles(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesContextKey).([]string)
+	return roles
+}
+
+// getClaims returns the token claims of the node making an AgentService call
+func getClaims(ctx context.Context) *services.Claims {
+	claims, _ := ctx.Value(claimsContextKey).(*services.Claims)
+	return claims
+}
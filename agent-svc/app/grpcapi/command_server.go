@@ -0,0 +1,196 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/rpc"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// commandServer implements CommandService like CommandHandler implements the /v1/commands routes
+type commandServer struct {
+	rpc.UnimplementedCommandServiceServer
+	commandService  *services.CommandService
+	templateService *services.TemplateService
+}
+
+// SubmitCommand submits a command, validated like a submission to POST /v1/commands/submit
+func (s *commandServer) SubmitCommand(ctx context.Context, in *rpc.SubmitCommandRequest) (*rpc.SubmitCommandResponse, error) {
+	req := toSubmitRequest(in)
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	opts := domains.CommandOptions{
+		ExpiresAt:        req.ExpiresAt,
+		DeliverWithinSec: req.DeliverWithinSec,
+		Priority:         req.Priority,
+		RetryPolicy:      fromRetryPolicy(req.RetryPolicy),
+		OperatorID:       getOperatorID(ctx),
+		OperatorRoles:    getOperatorRoles(ctx),
+		IdempotencyKey:   req.IdempotencyKey,
+		TenantID:         getTenantID(ctx),
+	}
+
+	if req.Template != "" {
+		if req.CommandType != "" || req.Payload != nil {
+			return nil, status.Error(codes.InvalidArgument, "command_type and payload must be omitted when template is set")
+		}
+		t, payload, err := s.templateService.Render(ctx, opts.TenantID, req.Template, req.TemplateVersion, req.Params)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		req.CommandType, req.Payload = t.CommandType, payload
+		opts.TemplateName, opts.TemplateVersion = t.Name, t.Version
+	} else if req.Params != nil || req.TemplateVersion != nil {
+		return nil, status.Error(codes.InvalidArgument, "params and template_version require template")
+	}
+
+	commandID, replayed, err := s.commandService.SubmitCommand(ctx, req.CommandType, req.NodeID, req.Payload, opts)
+	if errors.Is(err, domains.ErrIdempotencyKeyMismatch) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, domains.ErrTenantQuotaExceeded) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, domains.ErrCommandDenied) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &rpc.SubmitCommandResponse{CommandId: commandID.String(), Replayed: replayed}, nil
+}
+
+// ListCommands lists the most recent commands of the tenant
+func (s *commandServer) ListCommands(ctx context.Context, req *rpc.ListCommandsRequest) (*rpc.ListCommandsResponse, error) {
+	var nodeID *string
+	if req.NodeId != "" {
+		nodeID = &req.NodeId
+	}
+
+	limit := 50
+	if req.Limit > 0 && req.Limit <= 100 {
+		limit = int(req.Limit)
+	}
+
+	commands, err := s.commandService.ListCommands(ctx, getTenantID(ctx), nodeID, limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list commands")
+	}
+
+	resp := &rpc.ListCommandsResponse{Commands: make([]*rpc.Command, len(commands))}
+	for i := range commands {
+		cmd, err := toCommand(&commands[i])
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Commands[i] = cmd
+	}
+	return resp, nil
+}
+
+// GetCommand retrieves a command with all of its attempts
+func (s *commandServer) GetCommand(ctx context.Context, req *rpc.GetCommandRequest) (*rpc.GetCommandResponse, error) {
+	commandID, err := parseCommandID(req.CommandId)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, attempts, err := s.commandService.GetCommandAttempts(ctx, getTenantID(ctx), commandID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get command")
+	}
+	if cmd == nil {
+		return nil, status.Error(codes.NotFound, "command not found")
+	}
+
+	resp := &rpc.GetCommandResponse{OverallStatus: domains.OverallStatus(attempts)}
+	if resp.Command, err = toCommand(cmd); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if resp.Attempts, err = toCommands(attempts); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// GetCommandHistory retrieves the status transitions of a command
+func (s *commandServer) GetCommandHistory(ctx context.Context, req *rpc.GetCommandHistoryRequest) (*rpc.GetCommandHistoryResponse, error) {
+	commandID, err := parseCommandID(req.CommandId)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.commandService.GetCommandStatusHistory(ctx, getTenantID(ctx), commandID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get command history")
+	}
+
+	resp := &rpc.GetCommandHistoryResponse{History: make([]*rpc.StatusChange, len(history))}
+	for i, change := range history {
+		resp.History[i] = &rpc.StatusChange{
+			FromStatus: change.FromStatus,
+			ToStatus:   change.ToStatus,
+			Source:     change.Source,
+			CreatedAt:  toTimestamp(&change.CreatedAt),
+		}
+	}
+	return resp, nil
+}
+
+// CancelCommand cancels a queued command
+func (s *commandServer) CancelCommand(ctx context.Context, req *rpc.CancelCommandRequest) (*rpc.Command, error) {
+	commandID, err := parseCommandID(req.CommandId)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := s.commandService.CancelCommand(ctx, getTenantID(ctx), commandID, getOperatorID(ctx))
+	if err != nil {
+		if errors.Is(err, domains.ErrCommandNotCancellable) || errors.Is(err, domains.ErrInvalidStatusTransition) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to cancel command")
+	}
+	if cmd == nil {
+		return nil, status.Error(codes.NotFound, "command not found")
+	}
+
+	resp, err := toCommand(cmd)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// DeleteQueuedCommands deletes the queued commands of a node, or of the whole tenant
+func (s *commandServer) DeleteQueuedCommands(ctx context.Context, req *rpc.DeleteQueuedCommandsRequest) (*rpc.DeleteQueuedCommandsResponse, error) {
+	var nodeID *string
+	if req.NodeId != "" {
+		nodeID = &req.NodeId
+	}
+
+	count, err := s.commandService.DeleteQueuedCommands(ctx, getTenantID(ctx), nodeID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &rpc.DeleteQueuedCommandsResponse{DeletedCount: int32(count)}, nil
+}
+
+// parseCommandID parses the command ID of a request, failing with INVALID_ARGUMENT
+func parseCommandID(commandID string) (uuid.UUID, error) {
+	id, err := uuid.Parse(commandID)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, "invalid command_id")
+	}
+	return id, nil
+}
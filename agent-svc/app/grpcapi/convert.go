@@ -0,0 +1,200 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/client/dto"
+	"agent-svc/rpc"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// toStruct converts a JSON object, such as a command payload, to a protobuf Struct
+// It goes through JSON rather than structpb.NewStruct, which rejects values a JSON encoder accepts, such as
+// typed slices in rendered template payloads.
+func toStruct(m map[string]interface{}) (*structpb.Struct, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := s.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// fromStruct converts a protobuf Struct to a JSON object, or nil if s is unset
+func fromStruct(s *structpb.Struct) map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+// toTimestamp converts an optional time to a protobuf Timestamp
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// toCommand converts a command to its gRPC representation, the counterpart of dto.CommandDetailResponse
+func toCommand(cmd *domains.NodeCommand) (*rpc.Command, error) {
+	payload, err := toStruct(cmd.Payload)
+	if err != nil {
+		return nil, err
+	}
+	resp := &rpc.Command{
+		CommandId:       cmd.CommandID.String(),
+		NodeId:          cmd.NodeID,
+		CommandType:     cmd.CommandType,
+		Payload:         payload,
+		Status:          cmd.Status,
+		ErrorMsg:        cmd.ErrorMsg,
+		OutputBytes:     cmd.OutputBytes,
+		OutputTruncated: cmd.OutputTruncated,
+		CreatedAt:       timestamppb.New(cmd.CreatedAt),
+		UpdatedAt:       timestamppb.New(cmd.UpdatedAt),
+		DispatchedAt:    toTimestamp(cmd.DispatchedAt),
+		StartedAt:       toTimestamp(cmd.StartedAt),
+		FinishedAt:      toTimestamp(cmd.FinishedAt),
+		ExpiresAt:       toTimestamp(cmd.ExpiresAt),
+		Priority:        int32(cmd.Priority),
+		Attempt:         int32(cmd.Attempt),
+		RetryPolicy:     toRetryPolicy(cmd.RetryPolicy),
+		NextRetryAt:     toTimestamp(cmd.NextRetryAt),
+	}
+	if cmd.ExitCode != nil {
+		exitCode := int32(*cmd.ExitCode)
+		resp.ExitCode = &exitCode
+	}
+	if cmd.ParentCommandID != nil {
+		resp.ParentCommandId = cmd.ParentCommandID.String()
+	}
+	if cmd.TemplateName != nil && cmd.TemplateVersion != nil {
+		resp.Template = &rpc.TemplateRef{Name: *cmd.TemplateName, Version: int32(*cmd.TemplateVersion)}
+	}
+	return resp, nil
+}
+
+// toCommands converts commands to their gRPC representation
+func toCommands(cmds []*domains.NodeCommand) ([]*rpc.Command, error) {
+	resp := make([]*rpc.Command, len(cmds))
+	for i, cmd := range cmds {
+		c, err := toCommand(cmd)
+		if err != nil {
+			return nil, err
+		}
+		resp[i] = c
+	}
+	return resp, nil
+}
+
+// toDispatchedCommand converts a command dispatched to a node, the counterpart of dto.CommandResponse
+func toDispatchedCommand(cmd *domains.NodeCommand) (*rpc.DispatchedCommand, error) {
+	payload, err := toStruct(cmd.Payload)
+	if err != nil {
+		return nil, err
+	}
+	dispatched := &rpc.DispatchedCommand{
+		CommandId:    cmd.CommandID.String(),
+		CommandType:  cmd.CommandType,
+		Payload:      payload,
+		ExpiresAt:    toTimestamp(cmd.ExpiresAt),
+		Priority:     int32(cmd.Priority),
+		TraceContext: cmd.TraceContext,
+	}
+	if cmd.Signature != "" {
+		dispatched.Signature = cmd.Signature
+		dispatched.SignatureExpiresAt = toTimestamp(&cmd.SignatureExpiresAt)
+	}
+	return dispatched, nil
+}
+
+func toRetryPolicy(p *domains.RetryPolicy) *rpc.RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	resp := &rpc.RetryPolicy{
+		MaxAttempts:       int32(p.MaxAttempts),
+		BackoffSec:        int32(p.BackoffSec),
+		BackoffMultiplier: p.BackoffMultiplier,
+		MaxBackoffSec:     int32(p.MaxBackoffSec),
+		RetryOnStatuses:   p.RetryOnStatuses,
+	}
+	for _, exitCode := range p.RetryOnExitCodes {
+		resp.RetryOnExitCodes = append(resp.RetryOnExitCodes, int32(exitCode))
+	}
+	return resp
+}
+
+// toSubmitRequest converts a submission to the HTTP API's request, so both are validated the same way
+func toSubmitRequest(req *rpc.SubmitCommandRequest) *dto.SubmitCommandRequest {
+	submit := &dto.SubmitCommandRequest{
+		CommandType:      req.CommandType,
+		NodeID:           req.NodeId,
+		Payload:          fromStruct(req.Payload),
+		Template:         req.Template,
+		Params:           fromStruct(req.Params),
+		DeliverWithinSec: int(req.DeliverWithinSec),
+		IdempotencyKey:   req.IdempotencyKey,
+	}
+	if req.TemplateVersion != nil {
+		version := int(*req.TemplateVersion)
+		submit.TemplateVersion = &version
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.AsTime()
+		submit.ExpiresAt = &expiresAt
+	}
+	if req.Priority != nil {
+		priority := int(*req.Priority)
+		submit.Priority = &priority
+	}
+	if p := req.RetryPolicy; p != nil {
+		submit.RetryPolicy = &dto.RetryPolicy{
+			MaxAttempts:       int(p.MaxAttempts),
+			BackoffSec:        int(p.BackoffSec),
+			BackoffMultiplier: p.BackoffMultiplier,
+			MaxBackoffSec:     int(p.MaxBackoffSec),
+			RetryOnStatuses:   p.RetryOnStatuses,
+		}
+		for _, exitCode := range p.RetryOnExitCodes {
+			submit.RetryPolicy.RetryOnExitCodes = append(submit.RetryPolicy.RetryOnExitCodes, int(exitCode))
+		}
+	}
+	return submit
+}
+
+// toLogChunk converts a stored log chunk to its gRPC representation
+func toLogChunk(log *domains.CommandLog) *rpc.LogChunk {
+	return &rpc.LogChunk{
+		ChunkIndex: log.ChunkIndex,
+		Stream:     log.Stream,
+		Data:       log.Data,
+		IsFinal:    log.IsFinal,
+	}
+}
+
+// fromRetryPolicy converts a validated retry policy of a submission
+func fromRetryPolicy(p *dto.RetryPolicy) *domains.RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	return &domains.RetryPolicy{
+		MaxAttempts:       p.MaxAttempts,
+		BackoffSec:        p.BackoffSec,
+		BackoffMultiplier: p.BackoffMultiplier,
+		MaxBackoffSec:     p.MaxBackoffSec,
+		RetryOnStatuses:   p.RetryOnStatuses,
+		RetryOnExitCodes:  p.RetryOnExitCodes,
+	}
+}
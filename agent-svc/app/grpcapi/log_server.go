@@ -0,0 +1,120 @@
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/rpc"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchPollInterval is how often WatchLogs checks for new chunks
+const watchPollInterval = 500 * time.Millisecond
+
+// logServer implements LogService
+type logServer struct {
+	rpc.UnimplementedLogServiceServer
+	commandService *services.CommandService
+	logService     *services.LogService
+}
+
+// GetCommandLogs retrieves the log chunks of a command stored so far
+func (s *logServer) GetCommandLogs(ctx context.Context, req *rpc.GetCommandLogsRequest) (*rpc.GetCommandLogsResponse, error) {
+	commandID, err := parseCommandID(req.CommandId)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := s.logService.GetCommandLogs(ctx, getTenantID(ctx), commandID, req.FromChunkIndex)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &rpc.GetCommandLogsResponse{Logs: make([]*rpc.LogChunk, len(logs))}
+	for i := range logs {
+		resp.Logs[i] = toLogChunk(&logs[i])
+	}
+	return resp, nil
+}
+
+// WatchLogs streams the log chunks of a command until it finished
+// It polls the stored chunks the way the Go client's StreamCommandLogs polls the HTTP API: from the highest
+// chunk index sent so far, since stdout and stderr chunks share indexes, and once more after the command
+// reached a terminal status for chunks pushed just before it.
+func (s *logServer) WatchLogs(req *rpc.WatchLogsRequest, stream rpc.LogService_WatchLogsServer) error {
+	ctx := stream.Context()
+	commandID, err := parseCommandID(req.CommandId)
+	if err != nil {
+		return err
+	}
+	tenantID := getTenantID(ctx)
+
+	cmd, err := s.getCommand(ctx, tenantID, commandID)
+	if err != nil {
+		return err
+	}
+
+	from := req.FromChunkIndex
+	sent := map[string]bool{} // streams sent at chunk index from
+	finished := false
+	for {
+		logs, err := s.logService.GetCommandLogs(ctx, tenantID, commandID, from)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		fresh := 0
+		for i := range logs {
+			log := &logs[i]
+			if from != nil && (log.ChunkIndex < *from || log.ChunkIndex == *from && sent[log.Stream]) {
+				continue
+			}
+			if err := stream.Send(toLogChunk(log)); err != nil {
+				return err
+			}
+			fresh++
+			if from == nil || log.ChunkIndex > *from {
+				chunkIndex := log.ChunkIndex
+				from, sent = &chunkIndex, map[string]bool{}
+			}
+			sent[log.Stream] = true
+		}
+		if fresh > 0 {
+			continue
+		}
+		if finished {
+			return nil
+		}
+
+		if domains.IsTerminalStatus(cmd.Status) {
+			finished = true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(watchPollInterval):
+		}
+
+		if cmd, err = s.getCommand(ctx, tenantID, commandID); err != nil {
+			return err
+		}
+	}
+}
+
+// getCommand returns a command of the tenant, failing with NOT_FOUND if there is none
+func (s *logServer) getCommand(ctx context.Context, tenantID string, commandID uuid.UUID) (*domains.NodeCommand, error) {
+	cmd, _, err := s.commandService.GetCommandAttempts(ctx, tenantID, commandID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get command")
+	}
+	if cmd == nil {
+		return nil, status.Error(codes.NotFound, "command not found")
+	}
+	return cmd, nil
+}
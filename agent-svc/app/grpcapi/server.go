@@ -0,0 +1,46 @@
+// Package grpcapi serves the gRPC API defined in agent-svc/rpc
+// It exposes the same operations as the HTTP handlers through the same services, and authenticates requests
+// with the same credentials; see services.Credentials.
+package grpcapi
+
+import (
+	"agent-svc/app/clients"
+	"agent-svc/app/services"
+	"agent-svc/rpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// NewServer creates the gRPC server with the command, log and agent services registered
+func NewServer(
+	commandService *services.CommandService,
+	logService *services.LogService,
+	templateService *services.TemplateService,
+	tenantService *services.TenantService,
+	jwtService *services.JWTService,
+	storage clients.StorageAdapter,
+) *grpc.Server {
+	auth := &authenticator{tenantService: tenantService, jwtService: jwtService}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.unary),
+		grpc.ChainStreamInterceptor(auth.stream),
+	)
+
+	rpc.RegisterCommandServiceServer(server, &commandServer{
+		commandService:  commandService,
+		templateService: templateService,
+	})
+	rpc.RegisterLogServiceServer(server, &logServer{
+		commandService: commandService,
+		logService:     logService,
+	})
+	rpc.RegisterAgentServiceServer(server, &agentServer{
+		commandService: commandService,
+		logService:     logService,
+		storage:        storage,
+	})
+	reflection.Register(server)
+
+	return server
+}
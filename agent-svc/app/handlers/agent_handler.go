@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"net/http"
 	"time"
 
 	"agent-svc/app/clients"
-	"agent-svc/app/dto"
+	"agent-svc/app/domains"
 	"agent-svc/app/services"
 	"agent-svc/app/utils"
+	"agent-svc/client"
+	"agent-svc/client/dto"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,21 +29,50 @@ func respondError(c *gin.Context, status int, message string, details map[string
 	})
 }
 
+// credentials returns the credentials a request authenticates with, read the same way as by the gRPC API
+func credentials(c *gin.Context) services.Credentials {
+	return services.ReadCredentials(c.GetHeader)
+}
+
+// getOperatorID returns the operator making the request, as authenticated by AuthenticateOperator
+func getOperatorID(c *gin.Context) string {
+	if claims := getOperatorClaims(c); claims != nil {
+		return claims.Operator
+	}
+	return ""
+}
+
+// getOperatorRoles returns the roles of the operator making the request, as authenticated by AuthenticateOperator
+func getOperatorRoles(c *gin.Context) []string {
+	if claims := getOperatorClaims(c); claims != nil {
+		return claims.Roles
+	}
+	return nil
+}
+
 // AgentHandler handles agent-related endpoints
 type AgentHandler struct {
-	jwtService *services.JWTService
-	storage    clients.StorageAdapter
+	jwtService    *services.JWTService
+	tenantService *services.TenantService
+	storage       clients.StorageAdapter
+	signingKey    ed25519.PublicKey
 }
 
 // NewAgentHandler creates a new agent handler
-func NewAgentHandler(jwtService *services.JWTService, storage clients.StorageAdapter) *AgentHandler {
+// signingKey is the public key of the command signing key, returned to registering nodes to pin; nil if
+// commands aren't signed.
+func NewAgentHandler(jwtService *services.JWTService, tenantService *services.TenantService, storage clients.StorageAdapter, signingKey ed25519.PublicKey) *AgentHandler {
 	return &AgentHandler{
-		jwtService: jwtService,
-		storage:    storage,
+		jwtService:    jwtService,
+		tenantService: tenantService,
+		storage:       storage,
+		signingKey:    signingKey,
 	}
 }
 
 // Register handles node registration
+// A node enrolls into the tenant it names, presenting the tenant's enrollment key, and its token carries
+// the tenant as a claim.
 func (h *AgentHandler) Register(c *gin.Context) {
 	var req dto.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -59,26 +92,49 @@ func (h *AgentHandler) Register(c *gin.Context) {
 		attrs = make(map[string]interface{})
 	}
 
-	if err := h.storage.RegisterNode(ctx, req.NodeID, attrs); err != nil {
-		respondError(c, http.StatusInternalServerError, "failed to register node", nil)
+	tenantID, err := h.tenantService.EnrollNode(ctx, req.TenantID, req.EnrollmentKey, req.NodeID, attrs)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrEnrollmentDenied):
+			respondError(c, http.StatusForbidden, err.Error(), nil)
+		case errors.Is(err, domains.ErrNodeInOtherTenant):
+			respondError(c, http.StatusConflict, err.Error(), nil)
+		case errors.Is(err, domains.ErrTenantQuotaExceeded):
+			respondError(c, http.StatusTooManyRequests, err.Error(), nil)
+		default:
+			respondError(c, http.StatusInternalServerError, "failed to register node", nil)
+		}
 		return
 	}
 
-	token, err := h.jwtService.GenerateToken(req.NodeID)
+	token, err := h.jwtService.GenerateToken(req.NodeID, tenantID)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "failed to generate token", nil)
 		return
 	}
 
-	respondJSON(c, http.StatusOK, dto.RegisterResponse{
+	resp := dto.RegisterResponse{
 		Token:     token,
 		NodeID:    req.NodeID,
+		TenantID:  tenantID,
 		ExpiresIn: 86400,
-	})
+	}
+	if h.signingKey != nil {
+		resp.CommandSigningKey = client.EncodeCommandSigningKey(h.signingKey)
+	}
+	respondJSON(c, http.StatusOK, resp)
 }
 
 // Heartbeat handles node heartbeat
+// The node_id of the request must be that of the node's token, so a node can't mark another seen or report its
+// local policy hash.
 func (h *AgentHandler) Heartbeat(c *gin.Context) {
+	claims, err := h.jwtService.AuthenticateNode(credentials(c))
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "invalid token", nil)
+		return
+	}
+
 	var req dto.HeartbeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "invalid request body", nil)
@@ -90,6 +146,11 @@ func (h *AgentHandler) Heartbeat(c *gin.Context) {
 		return
 	}
 
+	if req.NodeID != claims.NodeID {
+		respondError(c, http.StatusForbidden, "node_id does not match the token", nil)
+		return
+	}
+
 	ctx := c.Request.Context()
 
 	// Check if node exists first
@@ -108,32 +169,136 @@ func (h *AgentHandler) Heartbeat(c *gin.Context) {
 		return
 	}
 
+	if req.PolicyHash != node.LocalPolicyHash {
+		if _, err := h.storage.SetNodePolicyHash(ctx, req.NodeID, req.PolicyHash); err != nil {
+			respondError(c, http.StatusInternalServerError, "failed to update heartbeat", nil)
+			return
+		}
+	}
+
 	respondJSON(c, http.StatusOK, dto.HeartbeatResponse{OK: true})
 }
 
-// ListNodes handles listing all registered nodes
+// ListNodes handles listing the nodes of the operator's tenant
 func (h *AgentHandler) ListNodes(c *gin.Context) {
 	ctx := c.Request.Context()
-	nodes, err := h.storage.ListNodes(ctx)
+	nodes, err := h.storage.ListNodes(ctx, getTenantID(c))
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "failed to list nodes", nil)
 		return
 	}
 
-	// Convert to response format with health status
 	nodeResponses := make([]dto.NodeResponse, len(nodes))
 	now := time.Now()
-	for i, node := range nodes {
-		// Node is healthy if last_seen_at is within last 2 minutes
-		isHealthy := !node.Disabled && now.Sub(node.LastSeenAt) < 30*time.Second
-		nodeResponses[i] = dto.NodeResponse{
-			NodeID:     node.NodeID,
-			Attrs:      node.Attrs,
-			LastSeenAt: node.LastSeenAt.Format(time.RFC3339),
-			Disabled:   node.Disabled,
-			IsHealthy:  isHealthy,
-		}
+	for i := range nodes {
+		nodeResponses[i] = toNodeResponse(&nodes[i], now)
 	}
 
 	respondJSON(c, http.StatusOK, dto.ListNodesResponse{Nodes: nodeResponses})
 }
+
+// GetNode handles retrieving a node of the operator's tenant
+func (h *AgentHandler) GetNode(c *gin.Context) {
+	node := h.tenantNode(c)
+	if node == nil {
+		return
+	}
+	respondJSON(c, http.StatusOK, toNodeResponse(node, time.Now()))
+}
+
+// UpdateNode handles setting and removing labels of a node and disabling or enabling it
+func (h *AgentHandler) UpdateNode(c *gin.Context) {
+	var req dto.UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	set := make(map[string]string)
+	var remove []string
+	for key, value := range req.Labels {
+		if key == "" {
+			respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"labels": "label keys must not be empty"})
+			return
+		}
+		if value == nil {
+			remove = append(remove, key)
+		} else {
+			set[key] = *value
+		}
+	}
+
+	node := h.tenantNode(c)
+	if node == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(req.Labels) > 0 {
+		if _, err := h.storage.UpdateNodeLabels(ctx, node.NodeID, set, remove); err != nil {
+			respondError(c, http.StatusInternalServerError, "failed to update node", nil)
+			return
+		}
+	}
+	if req.Disabled != nil {
+		if _, err := h.storage.SetNodeDisabled(ctx, node.NodeID, *req.Disabled); err != nil {
+			respondError(c, http.StatusInternalServerError, "failed to update node", nil)
+			return
+		}
+	}
+
+	h.respondNode(c, node.NodeID)
+}
+
+// tenantNode loads the node named by the node_id path parameter, responding with 404 if it doesn't exist
+// or belongs to another tenant than the operator's
+func (h *AgentHandler) tenantNode(c *gin.Context) *domains.Node {
+	node, err := h.storage.GetNode(c.Request.Context(), c.Param("node_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get node", nil)
+		return nil
+	}
+	if tenantID := getTenantID(c); node == nil || tenantID != "" && node.TenantID != tenantID {
+		respondError(c, http.StatusNotFound, "node not found", nil)
+		return nil
+	}
+	return node
+}
+
+// respondNode responds with the current state of a node
+func (h *AgentHandler) respondNode(c *gin.Context, nodeID string) {
+	node, err := h.storage.GetNode(c.Request.Context(), nodeID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get node", nil)
+		return
+	}
+	if node == nil {
+		respondError(c, http.StatusNotFound, "node not found", nil)
+		return
+	}
+	respondJSON(c, http.StatusOK, toNodeResponse(node, time.Now()))
+}
+
+// toNodeResponse converts a node to its API form, with its health at now
+func toNodeResponse(node *domains.Node, now time.Time) dto.NodeResponse {
+	labels := node.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return dto.NodeResponse{
+		NodeID:     node.NodeID,
+		TenantID:   node.TenantID,
+		Attrs:      node.Attrs,
+		Labels:     labels,
+		LastSeenAt: node.LastSeenAt.Format(time.RFC3339),
+		Disabled:   node.Disabled,
+		IsHealthy:  node.HealthState(now) == domains.NodeHealthy,
+
+		LocalPolicyHash: node.LocalPolicyHash,
+	}
+}
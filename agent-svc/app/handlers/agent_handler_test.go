@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/storage/memory"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHeartbeatNodeMustMatchToken(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	ctx := context.Background()
+	store := memory.NewStore()
+	for _, nodeID := range []string{"node-1", "node-2"} {
+		if err := store.RegisterNode(ctx, domains.DefaultTenantID, nodeID, nil); err != nil {
+			t.Fatalf("RegisterNode: %v", err)
+		}
+	}
+	jwtService := services.NewJWTService("secret", 3600)
+	token, err := jwtService.GenerateToken("node-1", domains.DefaultTenantID)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	operatorToken, err := jwtService.GenerateOperatorToken("alice", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateOperatorToken: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/v1/agents/heartbeat", NewAgentHandler(jwtService, nil, store, nil).Heartbeat)
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{name: "own node", token: token, body: `{"node_id":"node-1","policy_hash":"sha256:own"}`, want: http.StatusOK},
+		{name: "other node", token: token, body: `{"node_id":"node-2","policy_hash":"sha256:forged"}`, want: http.StatusForbidden},
+		{name: "no token", body: `{"node_id":"node-2","policy_hash":"sha256:forged"}`, want: http.StatusUnauthorized},
+		{name: "operator token", token: operatorToken, body: `{"node_id":"node-2","policy_hash":"sha256:forged"}`, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/agents/heartbeat", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	node, err := store.GetNode(ctx, "node-2")
+	if err != nil || node == nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	if node.LocalPolicyHash != "" {
+		t.Errorf("node-2 local policy hash = %q, set by another node's heartbeat", node.LocalPolicyHash)
+	}
+	if node, _ := store.GetNode(ctx, "node-1"); node.LocalPolicyHash != "sha256:own" {
+		t.Errorf("node-1 local policy hash = %q, want sha256:own", node.LocalPolicyHash)
+	}
+}
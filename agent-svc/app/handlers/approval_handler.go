@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ApprovalHandler handles approval policy and command approval endpoints
+type ApprovalHandler struct {
+	approvalService *services.ApprovalService
+	commandService  *services.CommandService
+}
+
+// NewApprovalHandler creates a new approval handler
+func NewApprovalHandler(approvalService *services.ApprovalService, commandService *services.CommandService) *ApprovalHandler {
+	return &ApprovalHandler{
+		approvalService: approvalService,
+		commandService:  commandService,
+	}
+}
+
+// CreatePolicy handles approval policy creation
+func (h *ApprovalHandler) CreatePolicy(c *gin.Context) {
+	var req dto.CreateApprovalPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	p := &domains.ApprovalPolicy{
+		Name:           req.Name,
+		Description:    req.Description,
+		CommandType:    req.CommandType,
+		PayloadPattern: req.PayloadPattern,
+		NodeLabels:     req.NodeLabels,
+		TimeoutSec:     req.TimeoutSec,
+	}
+	if err := h.approvalService.CreatePolicy(c.Request.Context(), p); err != nil {
+		if errors.Is(err, domains.ErrInvalidApprovalPolicy) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create approval policy", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusCreated, toApprovalPolicyResponse(p))
+}
+
+// ListPolicies handles listing approval policies
+func (h *ApprovalHandler) ListPolicies(c *gin.Context) {
+	policies, err := h.approvalService.ListPolicies(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list approval policies", nil)
+		return
+	}
+
+	resp := dto.ListApprovalPoliciesResponse{Policies: make([]dto.ApprovalPolicyResponse, len(policies))}
+	for i, p := range policies {
+		resp.Policies[i] = toApprovalPolicyResponse(p)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetPolicy handles fetching an approval policy
+func (h *ApprovalHandler) GetPolicy(c *gin.Context) {
+	policyID, ok := parsePolicyID(c)
+	if !ok {
+		return
+	}
+
+	p, err := h.approvalService.GetPolicy(c.Request.Context(), policyID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get approval policy", nil)
+		return
+	}
+	if p == nil {
+		respondError(c, http.StatusNotFound, "approval policy not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toApprovalPolicyResponse(p))
+}
+
+// DeletePolicy handles approval policy deletion
+func (h *ApprovalHandler) DeletePolicy(c *gin.Context) {
+	policyID, ok := parsePolicyID(c)
+	if !ok {
+		return
+	}
+
+	found, err := h.approvalService.DeletePolicy(c.Request.Context(), policyID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to delete approval policy", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "approval policy not found", nil)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListPendingApprovals handles listing the commands waiting for an approval decision
+func (h *ApprovalHandler) ListPendingApprovals(c *gin.Context) {
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	approvals, err := h.commandService.ListPendingApprovals(c.Request.Context(), getTenantID(c), limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list approval requests", nil)
+		return
+	}
+
+	resp := dto.ListApprovalsResponse{Approvals: make([]dto.CommandApprovalResponse, len(approvals))}
+	for i, a := range approvals {
+		resp.Approvals[i] = toCommandApprovalResponse(a)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetCommandApproval handles fetching the approval request of a command
+func (h *ApprovalHandler) GetCommandApproval(c *gin.Context) {
+	commandID, err := uuid.Parse(c.Param("command_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid command_id", nil)
+		return
+	}
+
+	a, err := h.commandService.GetCommandApproval(c.Request.Context(), getTenantID(c), commandID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get approval request", nil)
+		return
+	}
+	if a == nil {
+		respondError(c, http.StatusNotFound, "approval request not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toCommandApprovalResponse(a))
+}
+
+// ApproveCommand handles approving a command pending approval, which queues it for dispatch
+func (h *ApprovalHandler) ApproveCommand(c *gin.Context) {
+	h.decide(c, true)
+}
+
+// RejectCommand handles rejecting a command pending approval
+func (h *ApprovalHandler) RejectCommand(c *gin.Context) {
+	h.decide(c, false)
+}
+
+// decide records the calling operator's decision on a command pending approval
+func (h *ApprovalHandler) decide(c *gin.Context, approve bool) {
+	commandID, err := uuid.Parse(c.Param("command_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid command_id", nil)
+		return
+	}
+
+	// The body is optional; it only carries a comment
+	var req dto.ApprovalDecisionRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+		if err := utils.ValidateStruct(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	cmd, err := h.commandService.DecideApproval(c.Request.Context(), getTenantID(c), commandID, approve, getOperatorID(c), req.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrApprovalNotPending):
+			respondError(c, http.StatusConflict, err.Error(), nil)
+		case errors.Is(err, domains.ErrSelfApproval), errors.Is(err, domains.ErrApproverRequired):
+			respondError(c, http.StatusForbidden, err.Error(), nil)
+		default:
+			respondError(c, http.StatusInternalServerError, "failed to record approval decision", nil)
+		}
+		return
+	}
+	if cmd == nil {
+		respondError(c, http.StatusNotFound, "command not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toCommandDetailResponse(cmd))
+}
+
+// parsePolicyID parses the policy_id path parameter, responding with 400 if it isn't a UUID
+func parsePolicyID(c *gin.Context) (uuid.UUID, bool) {
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid policy_id", nil)
+		return uuid.Nil, false
+	}
+	return policyID, true
+}
+
+// toApprovalPolicyResponse converts an approval policy to its API representation
+func toApprovalPolicyResponse(p *domains.ApprovalPolicy) dto.ApprovalPolicyResponse {
+	return dto.ApprovalPolicyResponse{
+		PolicyID:       p.PolicyID.String(),
+		Name:           p.Name,
+		Description:    p.Description,
+		CommandType:    p.CommandType,
+		PayloadPattern: p.PayloadPattern,
+		NodeLabels:     p.NodeLabels,
+		TimeoutSec:     p.TimeoutSec,
+		CreatedAt:      p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// toCommandApprovalResponse converts an approval request to its API representation
+func toCommandApprovalResponse(a *domains.CommandApproval) dto.CommandApprovalResponse {
+	return dto.CommandApprovalResponse{
+		CommandID:   a.CommandID.String(),
+		NodeID:      a.NodeID,
+		CommandType: a.CommandType,
+		PolicyID:    a.PolicyID.String(),
+		PolicyName:  a.PolicyName,
+		RequestedBy: a.RequestedBy,
+		ExpiresAt:   a.ExpiresAt.Format(time.RFC3339),
+		Decision:    a.Decision,
+		DecidedBy:   a.DecidedBy,
+		Comment:     a.Comment,
+		DecidedAt:   formatTime(a.DecidedAt),
+		CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -2,15 +2,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"agent-svc/app/clients"
 	"agent-svc/app/domains"
-	"agent-svc/app/dto"
+	"agent-svc/app/metrics"
 	"agent-svc/app/services"
 	"agent-svc/app/utils"
+	"agent-svc/client/dto"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -18,24 +20,27 @@ import (
 
 // CommandHandler handles command-related endpoints
 type CommandHandler struct {
-	commandService *services.CommandService
-	logService     *services.LogService
-	jwtService     *services.JWTService
-	storage        clients.StorageAdapter
+	commandService  *services.CommandService
+	logService      *services.LogService
+	templateService *services.TemplateService
+	jwtService      *services.JWTService
+	storage         clients.StorageAdapter
 }
 
 // NewCommandHandler creates a new command handler
 func NewCommandHandler(
 	commandService *services.CommandService,
 	logService *services.LogService,
+	templateService *services.TemplateService,
 	jwtService *services.JWTService,
 	storage clients.StorageAdapter,
 ) *CommandHandler {
 	return &CommandHandler{
-		commandService: commandService,
-		logService:     logService,
-		jwtService:     jwtService,
-		storage:        storage,
+		commandService:  commandService,
+		logService:      logService,
+		templateService: templateService,
+		jwtService:      jwtService,
+		storage:         storage,
 	}
 }
 
@@ -52,15 +57,72 @@ func (h *CommandHandler) SubmitCommand(c *gin.Context) {
 		return
 	}
 
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if req.IdempotencyKey != "" {
+		if idempotencyKey != "" && idempotencyKey != req.IdempotencyKey {
+			respondError(c, http.StatusBadRequest, "Idempotency-Key header and idempotency_key field differ", nil)
+			return
+		}
+		idempotencyKey = req.IdempotencyKey
+	}
+	if len(idempotencyKey) > 255 {
+		respondError(c, http.StatusBadRequest, "idempotency key must be at most 255 characters", nil)
+		return
+	}
+
 	ctx := c.Request.Context()
-	commandID, err := h.commandService.SubmitCommand(ctx, req.CommandType, req.NodeID, req.Payload)
+	opts := domains.CommandOptions{
+		ExpiresAt:        req.ExpiresAt,
+		DeliverWithinSec: req.DeliverWithinSec,
+		Priority:         req.Priority,
+		RetryPolicy:      toRetryPolicy(req.RetryPolicy),
+		OperatorID:       getOperatorID(c),
+		OperatorRoles:    getOperatorRoles(c),
+		IdempotencyKey:   idempotencyKey,
+		TenantID:         getTenantID(c),
+	}
+
+	if req.Template != "" {
+		if req.CommandType != "" || req.Payload != nil {
+			respondError(c, http.StatusBadRequest, "command_type and payload must be omitted when template is set", nil)
+			return
+		}
+		t, payload, err := h.templateService.Render(ctx, opts.TenantID, req.Template, req.TemplateVersion, req.Params)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		req.CommandType, req.Payload = t.CommandType, payload
+		opts.TemplateName, opts.TemplateVersion = t.Name, t.Version
+	} else if req.Params != nil || req.TemplateVersion != nil {
+		respondError(c, http.StatusBadRequest, "params and template_version require template", nil)
+		return
+	}
+	commandID, replayed, err := h.commandService.SubmitCommand(ctx, req.CommandType, req.NodeID, req.Payload, opts)
+	if errors.Is(err, domains.ErrIdempotencyKeyMismatch) {
+		respondError(c, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	if errors.Is(err, domains.ErrTenantQuotaExceeded) {
+		respondError(c, http.StatusTooManyRequests, err.Error(), nil)
+		return
+	}
+	if errors.Is(err, domains.ErrCommandDenied) {
+		respondError(c, http.StatusForbidden, err.Error(), nil)
+		return
+	}
 	if err != nil {
 		respondError(c, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
-	respondJSON(c, http.StatusCreated, dto.SubmitCommandResponse{
+	status := http.StatusCreated
+	if replayed {
+		status = http.StatusOK
+	}
+	respondJSON(c, status, dto.SubmitCommandResponse{
 		CommandID: commandID.String(),
+		Replayed:  replayed,
 	})
 }
 
@@ -82,6 +144,9 @@ func (h *CommandHandler) GetNextCommand(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(waitSeconds)*time.Second)
 	defer cancel()
 
+	metrics.LongPollsOpen.Inc()
+	defer metrics.LongPollsOpen.Dec()
+
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
@@ -100,9 +165,16 @@ func (h *CommandHandler) GetNextCommand(c *gin.Context) {
 				commandResponses := make([]dto.CommandResponse, len(cmds))
 				for i, cmd := range cmds {
 					commandResponses[i] = dto.CommandResponse{
-						CommandID:   cmd.CommandID.String(),
-						CommandType: cmd.CommandType,
-						Payload:     cmd.Payload,
+						CommandID:    cmd.CommandID.String(),
+						CommandType:  cmd.CommandType,
+						Payload:      cmd.Payload,
+						ExpiresAt:    formatTime(cmd.ExpiresAt),
+						Priority:     cmd.Priority,
+						TraceContext: cmd.TraceContext,
+					}
+					if cmd.Signature != "" {
+						commandResponses[i].Signature = cmd.Signature
+						commandResponses[i].SignatureExpiresAt = formatTime(&cmd.SignatureExpiresAt)
 					}
 				}
 				respondJSON(c, http.StatusOK, dto.CommandsResponse{
@@ -194,7 +266,19 @@ func (h *CommandHandler) UpdateCommandStatus(c *gin.Context) {
 		errorMsg = &req.ErrorMsg
 	}
 
-	if err := h.commandService.UpdateCommandStatus(ctx, commandID, nodeID, req.Status, req.ExitCode, errorMsg); err != nil {
+	var output *domains.CommandOutput
+	if req.OutputBytes != nil {
+		output = &domains.CommandOutput{
+			TotalBytes: *req.OutputBytes,
+			Truncated:  req.OutputTruncated,
+		}
+	}
+
+	if err := h.commandService.UpdateCommandStatus(ctx, commandID, nodeID, req.Status, req.ExitCode, errorMsg, output); err != nil {
+		if errors.Is(err, domains.ErrInvalidStatusTransition) {
+			respondError(c, http.StatusConflict, err.Error(), nil)
+			return
+		}
 		respondError(c, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
@@ -224,7 +308,7 @@ func (h *CommandHandler) GetCommandLogs(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
-	logs, err := h.logService.GetCommandLogs(ctx, commandID, afterChunkIndex)
+	logs, err := h.logService.GetCommandLogs(ctx, getTenantID(c), commandID, afterChunkIndex)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, err.Error(), nil)
 		return
@@ -246,20 +330,101 @@ func (h *CommandHandler) GetCommandLogs(c *gin.Context) {
 	})
 }
 
-// getNodeIDFromToken extracts node ID from JWT token
-func (h *CommandHandler) getNodeIDFromToken(c *gin.Context) string {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-		return ""
+// GetCommandHistory handles fetching the status history of a command
+func (h *CommandHandler) GetCommandHistory(c *gin.Context) {
+	commandIDStr := c.Param("command_id")
+	commandID, err := uuid.Parse(commandIDStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid command_id", nil)
+		return
 	}
 
-	token := authHeader[7:]
-	nodeID, err := h.jwtService.ValidateToken(token)
+	ctx := c.Request.Context()
+	history, err := h.commandService.GetCommandStatusHistory(ctx, getTenantID(c), commandID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get command history", nil)
+		return
+	}
+
+	changes := make([]dto.StatusChangeResponse, len(history))
+	for i, change := range history {
+		changes[i] = dto.StatusChangeResponse{
+			FromStatus: change.FromStatus,
+			ToStatus:   change.ToStatus,
+			Source:     change.Source,
+			CreatedAt:  change.CreatedAt.Format(time.RFC3339Nano),
+		}
+	}
+
+	respondJSON(c, http.StatusOK, dto.CommandHistoryResponse{
+		CommandID: commandIDStr,
+		History:   changes,
+	})
+}
+
+// GetCommand handles fetching a command with all of its attempts
+func (h *CommandHandler) GetCommand(c *gin.Context) {
+	commandID, err := uuid.Parse(c.Param("command_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid command_id", nil)
+		return
+	}
+
+	cmd, attempts, err := h.commandService.GetCommandAttempts(c.Request.Context(), getTenantID(c), commandID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get command", nil)
+		return
+	}
+	if cmd == nil {
+		respondError(c, http.StatusNotFound, "command not found", nil)
+		return
+	}
+
+	resp := dto.CommandAttemptsResponse{
+		CommandDetailResponse: toCommandDetailResponse(cmd),
+		OverallStatus:         domains.OverallStatus(attempts),
+		Attempts:              make([]dto.CommandDetailResponse, len(attempts)),
+	}
+	for i, attempt := range attempts {
+		resp.Attempts[i] = toCommandDetailResponse(attempt)
+	}
+
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// CancelCommand handles cancelling a queued command
+func (h *CommandHandler) CancelCommand(c *gin.Context) {
+	commandID, err := uuid.Parse(c.Param("command_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid command_id", nil)
+		return
+	}
+
+	cmd, err := h.commandService.CancelCommand(c.Request.Context(), getTenantID(c), commandID, getOperatorID(c))
+	if err != nil {
+		if errors.Is(err, domains.ErrCommandNotCancellable) || errors.Is(err, domains.ErrInvalidStatusTransition) {
+			respondError(c, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to cancel command", nil)
+		return
+	}
+	if cmd == nil {
+		respondError(c, http.StatusNotFound, "command not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toCommandDetailResponse(cmd))
+}
+
+// getNodeIDFromToken extracts node ID from JWT token
+func (h *CommandHandler) getNodeIDFromToken(c *gin.Context) string {
+	claims, err := h.jwtService.AuthenticateNode(credentials(c))
 	if err != nil {
 		return ""
 	}
 
-	return nodeID
+	return claims.NodeID
 }
 
 // ListCommands handles listing commands (optionally filtered by node_id)
@@ -277,25 +442,15 @@ func (h *CommandHandler) ListCommands(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
-	commands, err := h.storage.ListCommands(ctx, nodeID, limit)
+	commands, err := h.commandService.ListCommands(ctx, getTenantID(c), nodeID, limit)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "failed to list commands", nil)
 		return
 	}
 
 	commandResponses := make([]dto.CommandDetailResponse, len(commands))
-	for i, cmd := range commands {
-		commandResponses[i] = dto.CommandDetailResponse{
-			CommandID:   cmd.CommandID.String(),
-			NodeID:      cmd.NodeID,
-			CommandType: cmd.CommandType,
-			Payload:     cmd.Payload,
-			Status:      cmd.Status,
-			ExitCode:    cmd.ExitCode,
-			ErrorMsg:    cmd.ErrorMsg,
-			CreatedAt:   cmd.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   cmd.UpdatedAt.Format(time.RFC3339),
-		}
+	for i := range commands {
+		commandResponses[i] = toCommandDetailResponse(&commands[i])
 	}
 
 	respondJSON(c, http.StatusOK, dto.ListCommandsResponse{Commands: commandResponses})
@@ -309,7 +464,7 @@ func (h *CommandHandler) DeleteQueuedCommands(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
-	count, err := h.commandService.DeleteQueuedCommands(ctx, nodeID)
+	count, err := h.commandService.DeleteQueuedCommands(ctx, getTenantID(c), nodeID)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, err.Error(), nil)
 		return
@@ -319,3 +474,91 @@ func (h *CommandHandler) DeleteQueuedCommands(c *gin.Context) {
 		DeletedCount: count,
 	})
 }
+
+// toCommandDetailResponse converts a command to its API representation, including timing
+func toCommandDetailResponse(cmd *domains.NodeCommand) dto.CommandDetailResponse {
+	resp := dto.CommandDetailResponse{
+		CommandID:       cmd.CommandID.String(),
+		NodeID:          cmd.NodeID,
+		CommandType:     cmd.CommandType,
+		Payload:         cmd.Payload,
+		Status:          cmd.Status,
+		ExitCode:        cmd.ExitCode,
+		ErrorMsg:        cmd.ErrorMsg,
+		OutputBytes:     cmd.OutputBytes,
+		OutputTruncated: cmd.OutputTruncated,
+		CreatedAt:       cmd.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       cmd.UpdatedAt.Format(time.RFC3339),
+		DispatchedAt:    formatTime(cmd.DispatchedAt),
+		StartedAt:       formatTime(cmd.StartedAt),
+		FinishedAt:      formatTime(cmd.FinishedAt),
+		ExpiresAt:       formatTime(cmd.ExpiresAt),
+		Priority:        cmd.Priority,
+		Attempt:         cmd.Attempt,
+		NextRetryAt:     formatTime(cmd.NextRetryAt),
+	}
+
+	if cmd.ParentCommandID != nil {
+		parentCommandID := cmd.ParentCommandID.String()
+		resp.ParentCommandID = &parentCommandID
+	}
+
+	if cmd.TemplateName != nil && cmd.TemplateVersion != nil {
+		resp.Template = &dto.TemplateRef{Name: *cmd.TemplateName, Version: *cmd.TemplateVersion}
+	}
+
+	if p := cmd.RetryPolicy; p != nil {
+		resp.RetryPolicy = &dto.RetryPolicy{
+			MaxAttempts:       p.MaxAttempts,
+			BackoffSec:        p.BackoffSec,
+			BackoffMultiplier: p.BackoffMultiplier,
+			MaxBackoffSec:     p.MaxBackoffSec,
+			RetryOnStatuses:   p.RetryOnStatuses,
+			RetryOnExitCodes:  p.RetryOnExitCodes,
+		}
+	}
+
+	if cmd.DispatchedAt != nil {
+		resp.QueueWaitMs = durationMs(cmd.CreatedAt, *cmd.DispatchedAt)
+	}
+
+	startedAt := cmd.StartedAt
+	if startedAt == nil {
+		startedAt = cmd.DispatchedAt
+	}
+	if startedAt != nil && cmd.FinishedAt != nil {
+		resp.ExecutionMs = durationMs(*startedAt, *cmd.FinishedAt)
+	}
+
+	return resp
+}
+
+// formatTime formats an optional timestamp as RFC3339
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+// durationMs returns the milliseconds between two timestamps
+func durationMs(from, to time.Time) *int64 {
+	ms := to.Sub(from).Milliseconds()
+	return &ms
+}
+
+// toRetryPolicy converts a requested retry policy to its domain form
+func toRetryPolicy(p *dto.RetryPolicy) *domains.RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	return &domains.RetryPolicy{
+		MaxAttempts:       p.MaxAttempts,
+		BackoffSec:        p.BackoffSec,
+		BackoffMultiplier: p.BackoffMultiplier,
+		MaxBackoffSec:     p.MaxBackoffSec,
+		RetryOnStatuses:   p.RetryOnStatuses,
+		RetryOnExitCodes:  p.RetryOnExitCodes,
+	}
+}
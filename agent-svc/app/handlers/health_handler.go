@@ -2,28 +2,74 @@ package handlers
 
 import (
 	"net/http"
+	"time"
+
+	"agent-svc/app/services"
+	"agent-svc/client/dto"
 
 	"github.com/gin-gonic/gin"
 )
 
 // HealthHandler handles health check endpoints
-type HealthHandler struct{}
+type HealthHandler struct {
+	healthService *services.HealthService
+}
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+func NewHealthHandler(healthService *services.HealthService) *HealthHandler {
+	return &HealthHandler{
+		healthService: healthService,
+	}
 }
 
-// Health handles health check
+// Health handles the liveness check; it doesn't touch the database
 func (h *HealthHandler) Health(c *gin.Context) {
 	respondJSON(c, http.StatusOK, map[string]string{
 		"status": "healthy",
 	})
 }
 
-// Ready handles readiness check
+// Ready handles the readiness check, responding with 503 if the replica should not take traffic
 func (h *HealthHandler) Ready(c *gin.Context) {
-	respondJSON(c, http.StatusOK, map[string]string{
-		"status": "ready",
-	})
+	r := h.healthService.Ready(c.Request.Context())
+
+	resp := dto.ReadinessResponse{
+		Status:    "ready",
+		CheckedAt: r.CheckedAt.Format(time.RFC3339),
+		Components: dto.ReadinessComponents{
+			Database: dto.DatabaseHealthResponse{
+				Status:    r.Database.Status,
+				LatencyMs: r.Database.Latency.Milliseconds(),
+				Error:     r.Database.Error,
+			},
+			Migrations: dto.MigrationHealthResponse{
+				Status:          r.Migrations.Status,
+				Version:         r.Migrations.Version,
+				ExpectedVersion: r.Migrations.ExpectedVersion,
+				Dirty:           r.Migrations.Dirty,
+				Error:           r.Migrations.Error,
+			},
+			Workers: dto.WorkersHealthResponse{
+				Status:  r.WorkersStatus(),
+				Workers: make([]dto.WorkerHealthResponse, len(r.Workers)),
+			},
+		},
+	}
+	for i, w := range r.Workers {
+		resp.Components.Workers.Workers[i] = dto.WorkerHealthResponse{
+			Name:          w.Name,
+			Status:        w.Status,
+			IntervalSec:   int64(w.Interval / time.Second),
+			LastRunAt:     formatTime(w.LastRunAt),
+			LastSuccessAt: formatTime(w.LastSuccessAt),
+			LastError:     w.LastError,
+		}
+	}
+
+	status := http.StatusOK
+	if !r.Ready {
+		resp.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+	}
+	respondJSON(c, status, resp)
 }
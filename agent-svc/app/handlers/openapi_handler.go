@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"agent-svc/app/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIHandler serves the OpenAPI document of the API
+type OpenAPIHandler struct {
+	spec *openapi.Document
+}
+
+// NewOpenAPIHandler creates a new OpenAPI handler
+func NewOpenAPIHandler(spec *openapi.Document) *OpenAPIHandler {
+	return &OpenAPIHandler{
+		spec: spec,
+	}
+}
+
+// Spec handles retrieving the OpenAPI document
+func (h *OpenAPIHandler) Spec(c *gin.Context) {
+	respondJSON(c, http.StatusOK, h.spec)
+}
+
+// ValidateRequestBody returns middleware validating JSON request bodies against the request schema of
+// their route in spec, responding with 400 and the violations keyed by field before the handler runs
+// Bodies of routes that take one are limited to maxBodyBytes and rejected with 413 beyond it. YAML bodies
+// and bodies that don't parse are left to the handler.
+func ValidateRequestBody(spec *openapi.Document, maxBodyBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := spec.Operation(c.Request.Method, c.FullPath())
+		if op == nil || op.RequestBody == nil || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBodyBytes), nil)
+			} else {
+				respondError(c, http.StatusBadRequest, "invalid request body", nil)
+			}
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+		if isYAMLContent(c.ContentType()) {
+			c.Next()
+			return
+		}
+
+		var body interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			c.Next()
+			return
+		}
+		if violations := spec.ValidateBody(op, body); violations != nil {
+			respondError(c, http.StatusBadRequest, "validation failed", violations)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isYAMLContent reports whether a request body of this content type is YAML, which only workflows and the
+// command policy accept
+func isYAMLContent(contentType string) bool {
+	switch contentType {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PolicyHandler handles command policy endpoints
+type PolicyHandler struct {
+	policyService *services.CommandPolicyService
+	storage       clients.StorageAdapter
+}
+
+// NewPolicyHandler creates a new policy handler
+func NewPolicyHandler(policyService *services.CommandPolicyService, storage clients.StorageAdapter) *PolicyHandler {
+	return &PolicyHandler{
+		policyService: policyService,
+		storage:       storage,
+	}
+}
+
+// GetPolicy handles fetching the command policy in effect
+func (h *PolicyHandler) GetPolicy(c *gin.Context) {
+	respondJSON(c, http.StatusOK, toCommandPolicyResponse(h.policyService.Policy()))
+}
+
+// UpdatePolicy handles replacing the command policy with a JSON or YAML document
+// The document is parsed like a policy file, rejecting unknown fields, so a misspelt criterion can't widen a rule.
+func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	p, err := services.ParseCommandPolicy(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.policyService.UpdatePolicy(c.Request.Context(), p, getOperatorID(c)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrCommandPolicyFromFile):
+			respondError(c, http.StatusConflict, err.Error(), nil)
+		case errors.Is(err, domains.ErrInvalidCommandPolicy):
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+		default:
+			respondError(c, http.StatusInternalServerError, "failed to update command policy", nil)
+		}
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toCommandPolicyResponse(h.policyService.Policy()))
+}
+
+// EvaluatePolicy handles a dry run of a submission against the command policy, explaining which rule decides
+func (h *PolicyHandler) EvaluatePolicy(c *gin.Context) {
+	var req dto.EvaluatePolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	in := domains.PolicyInput{
+		CommandType:   req.CommandType,
+		Payload:       req.Payload,
+		NodeLabels:    req.NodeLabels,
+		OperatorRoles: req.OperatorRoles,
+		Time:          time.Now(),
+	}
+	if in.OperatorRoles == nil {
+		in.OperatorRoles = getOperatorRoles(c)
+	}
+	if req.NodeID != "" {
+		if req.NodeLabels != nil {
+			respondError(c, http.StatusBadRequest, "node_id and node_labels are mutually exclusive", nil)
+			return
+		}
+		node, err := h.storage.GetNode(c.Request.Context(), req.NodeID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "failed to get node", nil)
+			return
+		}
+		if tenantID := getTenantID(c); node == nil || tenantID != "" && node.TenantID != tenantID {
+			respondError(c, http.StatusNotFound, "node not found", nil)
+			return
+		}
+		in.NodeLabels = node.Labels
+	}
+	if req.OperatorRoles == nil {
+		in.OperatorRoles = getOperatorRoles(c)
+	}
+	if req.Time != nil {
+		in.Time = *req.Time
+	}
+
+	decision, p := h.policyService.Evaluate(in)
+	resp := dto.EvaluatePolicyResponse{
+		Action:        decision.Action,
+		Rule:          decision.Rule,
+		RuleIndex:     decision.RuleIndex,
+		Reason:        decision.Reason,
+		Commands:      decision.Commands,
+		Trace:         make([]dto.PolicyRuleOutcome, len(decision.Trace)),
+		PolicyVersion: p.Version,
+		PolicySource:  p.Source,
+	}
+	for i, result := range decision.Trace {
+		resp.Trace[i] = dto.PolicyRuleOutcome{Rule: result.Rule, Matched: result.Matched, Mismatch: result.Mismatch}
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// toCommandPolicyResponse converts the command policy to its API representation
+func toCommandPolicyResponse(p *domains.CommandPolicy) dto.CommandPolicyResponse {
+	resp := dto.CommandPolicyResponse{
+		Version:       p.Version,
+		Source:        p.Source,
+		DefaultAction: p.DefaultAction,
+		Rules:         make([]dto.PolicyRuleRequest, len(p.Rules)),
+		CreatedBy:     p.CreatedBy,
+	}
+	if p.PolicyID != uuid.Nil {
+		resp.PolicyID = p.PolicyID.String()
+	}
+	if !p.CreatedAt.IsZero() {
+		resp.CreatedAt = formatTime(&p.CreatedAt)
+	}
+	for i, rule := range p.Rules {
+		resp.Rules[i] = dto.PolicyRuleRequest{
+			Name:               rule.Name,
+			Action:             rule.Action,
+			Reason:             rule.Reason,
+			CommandTypes:       rule.CommandTypes,
+			CommandGlob:        rule.CommandGlob,
+			CommandRegex:       rule.CommandRegex,
+			NodeLabels:         rule.NodeLabels,
+			OperatorRoles:      rule.OperatorRoles,
+			ApprovalTimeoutSec: rule.ApprovalTimeoutSec,
+		}
+		if w := rule.TimeWindow; w != nil {
+			resp.Rules[i].TimeWindow = &dto.PolicyTimeWindow{Days: w.Days, Start: w.Start, End: w.End, Timezone: w.Timezone}
+		}
+	}
+	return resp
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RolloutHandler handles rollout-related endpoints
+type RolloutHandler struct {
+	rolloutService *services.RolloutService
+}
+
+// NewRolloutHandler creates a new rollout handler
+func NewRolloutHandler(rolloutService *services.RolloutService) *RolloutHandler {
+	return &RolloutHandler{
+		rolloutService: rolloutService,
+	}
+}
+
+// CreateRollout handles starting a rollout
+func (h *RolloutHandler) CreateRollout(c *gin.Context) {
+	var req dto.CreateRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+	if len(req.NodeIDs) > 0 && len(req.Selector) > 0 {
+		respondError(c, http.StatusBadRequest, "at most one of node_ids and selector may be set", nil)
+		return
+	}
+
+	r := &domains.Rollout{
+		TenantID:    getTenantID(c),
+		Name:        req.Name,
+		CommandType: req.CommandType,
+		Payload:     req.Payload,
+		Priority:    domains.PriorityDefault,
+		Strategy: domains.RolloutStrategy{
+			BatchSize:              req.Strategy.BatchSize,
+			BatchPercent:           req.Strategy.BatchPercent,
+			PauseBetweenBatchesSec: req.Strategy.PauseBetweenBatchesSec,
+			CanaryNodeIDs:          req.Strategy.CanaryNodeIDs,
+			CanarySize:             req.Strategy.CanarySize,
+			MaxFailurePercent:      req.Strategy.MaxFailurePercent,
+		},
+	}
+	if req.Priority != nil {
+		r.Priority = *req.Priority
+	}
+
+	ctx := c.Request.Context()
+	if err := h.rolloutService.CreateRollout(ctx, r, req.NodeIDs, domains.NodeSelector(req.Selector)); err != nil {
+		if errors.Is(err, domains.ErrInvalidRollout) || errors.Is(err, domains.ErrTenantRequired) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create rollout", nil)
+		return
+	}
+
+	h.respondRollout(c, http.StatusCreated, r.RolloutID)
+}
+
+// ListRollouts handles listing the rollouts of the operator's tenant
+func (h *RolloutHandler) ListRollouts(c *gin.Context) {
+	var status *string
+	if s := c.Query("status"); s != "" {
+		status = &s
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	rollouts, err := h.rolloutService.ListRollouts(c.Request.Context(), getTenantID(c), status, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list rollouts", nil)
+		return
+	}
+
+	resp := dto.ListRolloutsResponse{Rollouts: make([]dto.RolloutResponse, len(rollouts))}
+	for i, r := range rollouts {
+		resp.Rollouts[i] = toRolloutResponse(r, nil)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetRollout handles fetching a rollout with per-batch progress
+func (h *RolloutHandler) GetRollout(c *gin.Context) {
+	rolloutID, ok := parseRolloutID(c)
+	if !ok {
+		return
+	}
+
+	h.respondRollout(c, http.StatusOK, rolloutID)
+}
+
+// PauseRollout handles pausing a running rollout
+func (h *RolloutHandler) PauseRollout(c *gin.Context) {
+	h.changeRollout(c, "pause", h.rolloutService.PauseRollout)
+}
+
+// ResumeRollout handles resuming a paused or halted rollout
+func (h *RolloutHandler) ResumeRollout(c *gin.Context) {
+	h.changeRollout(c, "resume", h.rolloutService.ResumeRollout)
+}
+
+// AbortRollout handles aborting a rollout
+func (h *RolloutHandler) AbortRollout(c *gin.Context) {
+	h.changeRollout(c, "abort", h.rolloutService.AbortRollout)
+}
+
+// changeRollout applies an operator action to a rollout and responds with its new state
+func (h *RolloutHandler) changeRollout(c *gin.Context, action string, change func(ctx context.Context, tenantID string, rolloutID uuid.UUID) (bool, error)) {
+	rolloutID, ok := parseRolloutID(c)
+	if !ok {
+		return
+	}
+
+	found, err := change(c.Request.Context(), getTenantID(c), rolloutID)
+	if errors.Is(err, domains.ErrRolloutState) {
+		respondError(c, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to "+action+" rollout", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "rollout not found", nil)
+		return
+	}
+
+	h.respondRollout(c, http.StatusOK, rolloutID)
+}
+
+// respondRollout responds with the current state of a rollout
+func (h *RolloutHandler) respondRollout(c *gin.Context, status int, rolloutID uuid.UUID) {
+	r, batches, err := h.rolloutService.GetRollout(c.Request.Context(), getTenantID(c), rolloutID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get rollout", nil)
+		return
+	}
+	if r == nil {
+		respondError(c, http.StatusNotFound, "rollout not found", nil)
+		return
+	}
+
+	respondJSON(c, status, toRolloutResponse(r, batches))
+}
+
+// parseRolloutID parses the rollout_id path parameter, responding with 400 if it is invalid
+func parseRolloutID(c *gin.Context) (uuid.UUID, bool) {
+	rolloutID, err := uuid.Parse(c.Param("rollout_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid rollout_id", nil)
+		return uuid.Nil, false
+	}
+	return rolloutID, true
+}
+
+// toRolloutResponse converts a rollout and its batches to their API representation
+func toRolloutResponse(r *domains.Rollout, batches []domains.RolloutBatch) dto.RolloutResponse {
+	resp := dto.RolloutResponse{
+		RolloutID:   r.RolloutID.String(),
+		TenantID:    r.TenantID,
+		Name:        r.Name,
+		CommandType: r.CommandType,
+		Payload:     r.Payload,
+		Priority:    r.Priority,
+		Strategy: dto.RolloutStrategy{
+			BatchSize:              r.Strategy.BatchSize,
+			BatchPercent:           r.Strategy.BatchPercent,
+			PauseBetweenBatchesSec: r.Strategy.PauseBetweenBatchesSec,
+			CanaryNodeIDs:          r.Strategy.CanaryNodeIDs,
+			CanarySize:             r.Strategy.CanarySize,
+			MaxFailurePercent:      r.Strategy.MaxFailurePercent,
+		},
+		Status:     r.Status,
+		ErrorMsg:   r.ErrorMsg,
+		CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  r.UpdatedAt.Format(time.RFC3339),
+		FinishedAt: formatTime(r.FinishedAt),
+	}
+	if r.Status == domains.RolloutRunning {
+		resp.NextBatchAt = formatTime(r.NextBatchAt)
+	}
+
+	for _, batch := range batches {
+		commandIDs := make([]string, len(batch.CommandIDs))
+		for i, commandID := range batch.CommandIDs {
+			commandIDs[i] = commandID.String()
+		}
+
+		resp.Batches = append(resp.Batches, dto.RolloutBatchResponse{
+			BatchIndex:     batch.BatchIndex,
+			Canary:         batch.Canary,
+			Status:         batch.Status,
+			NodeIDs:        batch.NodeIDs,
+			CommandIDs:     commandIDs,
+			SucceededCount: batch.SucceededCount,
+			ErrorMsg:       batch.ErrorMsg,
+			StartedAt:      formatTime(batch.StartedAt),
+			FinishedAt:     formatTime(batch.FinishedAt),
+		})
+	}
+	return resp
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ScheduleHandler handles schedule-related endpoints
+type ScheduleHandler struct {
+	scheduleService *services.ScheduleService
+}
+
+// NewScheduleHandler creates a new schedule handler
+func NewScheduleHandler(scheduleService *services.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleService: scheduleService,
+	}
+}
+
+// CreateSchedule handles schedule creation
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	var req dto.CreateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	sched := &domains.Schedule{
+		TenantID:        getTenantID(c),
+		Name:            req.Name,
+		CronExpr:        req.Cron,
+		Timezone:        req.Timezone,
+		NodeID:          req.NodeID,
+		CommandType:     req.CommandType,
+		Payload:         req.Payload,
+		Priority:        domains.PriorityDefault,
+		MisfirePolicy:   req.MisfirePolicy,
+		MisfireGraceSec: 300,
+		Paused:          req.Paused,
+	}
+	if len(req.Selector) > 0 {
+		sched.Selector = domains.NodeSelector(req.Selector)
+	}
+	if req.Priority != nil {
+		sched.Priority = *req.Priority
+	}
+	if req.MisfireGraceSec != nil {
+		sched.MisfireGraceSec = *req.MisfireGraceSec
+	}
+
+	if err := h.scheduleService.CreateSchedule(c.Request.Context(), sched); err != nil {
+		if errors.Is(err, domains.ErrInvalidSchedule) || errors.Is(err, domains.ErrTenantRequired) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create schedule", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusCreated, toScheduleResponse(sched))
+}
+
+// ListSchedules handles listing the schedules of the operator's tenant
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	schedules, err := h.scheduleService.ListSchedules(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list schedules", nil)
+		return
+	}
+
+	resp := dto.ListSchedulesResponse{Schedules: make([]dto.ScheduleResponse, len(schedules))}
+	for i, sched := range schedules {
+		resp.Schedules[i] = toScheduleResponse(sched)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetSchedule handles fetching a schedule
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	sched, err := h.scheduleService.GetSchedule(c.Request.Context(), getTenantID(c), scheduleID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get schedule", nil)
+		return
+	}
+	if sched == nil {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toScheduleResponse(sched))
+}
+
+// DeleteSchedule handles schedule deletion
+func (h *ScheduleHandler) DeleteSchedule(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	found, err := h.scheduleService.DeleteSchedule(c.Request.Context(), getTenantID(c), scheduleID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to delete schedule", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PauseSchedule handles pausing a schedule
+func (h *ScheduleHandler) PauseSchedule(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	found, err := h.scheduleService.PauseSchedule(ctx, getTenantID(c), scheduleID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to pause schedule", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	h.respondSchedule(c, scheduleID)
+}
+
+// ResumeSchedule handles resuming a paused schedule
+func (h *ScheduleHandler) ResumeSchedule(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	sched, err := h.scheduleService.ResumeSchedule(c.Request.Context(), getTenantID(c), scheduleID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to resume schedule", nil)
+		return
+	}
+	if sched == nil {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toScheduleResponse(sched))
+}
+
+// RunSchedule handles firing a schedule immediately
+func (h *ScheduleHandler) RunSchedule(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	run, err := h.scheduleService.RunScheduleNow(c.Request.Context(), getTenantID(c), scheduleID)
+	if err != nil && run == nil {
+		respondError(c, http.StatusInternalServerError, "failed to run schedule", nil)
+		return
+	}
+	if run == nil {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusCreated, toScheduleRunResponse(run))
+}
+
+// ListScheduleRuns handles fetching the run history of a schedule
+func (h *ScheduleHandler) ListScheduleRuns(c *gin.Context) {
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	ctx := c.Request.Context()
+	sched, err := h.scheduleService.GetSchedule(ctx, getTenantID(c), scheduleID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get schedule", nil)
+		return
+	}
+	if sched == nil {
+		respondError(c, http.StatusNotFound, "schedule not found", nil)
+		return
+	}
+
+	runs, err := h.scheduleService.ListScheduleRuns(ctx, scheduleID, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list schedule runs", nil)
+		return
+	}
+
+	resp := dto.ScheduleRunsResponse{
+		ScheduleID: scheduleID.String(),
+		Runs:       make([]dto.ScheduleRunResponse, len(runs)),
+	}
+	for i := range runs {
+		resp.Runs[i] = toScheduleRunResponse(&runs[i])
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// respondSchedule responds with the current state of a schedule
+func (h *ScheduleHandler) respondSchedule(c *gin.Context, scheduleID uuid.UUID) {
+	sched, err := h.scheduleService.GetSchedule(c.Request.Context(), getTenantID(c), scheduleID)
+	if err != nil || sched == nil {
+		respondError(c, http.StatusInternalServerError, "failed to get schedule", nil)
+		return
+	}
+	respondJSON(c, http.StatusOK, toScheduleResponse(sched))
+}
+
+// parseScheduleID parses the schedule_id path parameter, responding with 400 if it is invalid
+func parseScheduleID(c *gin.Context) (uuid.UUID, bool) {
+	scheduleID, err := uuid.Parse(c.Param("schedule_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid schedule_id", nil)
+		return uuid.Nil, false
+	}
+	return scheduleID, true
+}
+
+// toScheduleResponse converts a schedule to its API representation
+func toScheduleResponse(sched *domains.Schedule) dto.ScheduleResponse {
+	return dto.ScheduleResponse{
+		ScheduleID:      sched.ScheduleID.String(),
+		TenantID:        sched.TenantID,
+		Name:            sched.Name,
+		Cron:            sched.CronExpr,
+		Timezone:        sched.Timezone,
+		NodeID:          sched.NodeID,
+		Selector:        sched.Selector,
+		CommandType:     sched.CommandType,
+		Payload:         sched.Payload,
+		Priority:        sched.Priority,
+		MisfirePolicy:   sched.MisfirePolicy,
+		MisfireGraceSec: sched.MisfireGraceSec,
+		Paused:          sched.Paused,
+		NextRunAt:       formatTime(sched.NextRunAt),
+		LastRunAt:       formatTime(sched.LastRunAt),
+		CreatedAt:       sched.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       sched.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// toScheduleRunResponse converts a schedule run to its API representation
+func toScheduleRunResponse(run *domains.ScheduleRun) dto.ScheduleRunResponse {
+	commandIDs := make([]string, len(run.CommandIDs))
+	for i, commandID := range run.CommandIDs {
+		commandIDs[i] = commandID.String()
+	}
+
+	return dto.ScheduleRunResponse{
+		ScheduledFor: run.ScheduledFor.Format(time.RFC3339),
+		Trigger:      run.Trigger,
+		Status:       run.Status,
+		CommandIDs:   commandIDs,
+		ErrorMsg:     run.ErrorMsg,
+		CreatedAt:    run.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TemplateHandler handles command template endpoints
+type TemplateHandler struct {
+	templateService *services.TemplateService
+}
+
+// NewTemplateHandler creates a new template handler
+func NewTemplateHandler(templateService *services.TemplateService) *TemplateHandler {
+	return &TemplateHandler{
+		templateService: templateService,
+	}
+}
+
+// CreateTemplate handles creating a template or a new version of an existing one
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	var req dto.CreateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	t := &domains.CommandTemplate{
+		TenantID:    getTenantID(c),
+		Name:        req.Name,
+		Description: req.Description,
+		CommandType: req.CommandType,
+		Payload:     req.Payload,
+		Params:      make([]domains.TemplateParam, len(req.Params)),
+	}
+	for i, p := range req.Params {
+		t.Params[i] = domains.TemplateParam{
+			Name:        p.Name,
+			Type:        p.Type,
+			Description: p.Description,
+			Required:    p.Required,
+			Default:     p.Default,
+			Pattern:     p.Pattern,
+			Enum:        p.Enum,
+			Min:         p.Min,
+			Max:         p.Max,
+		}
+	}
+
+	if err := h.templateService.CreateTemplate(c.Request.Context(), t); err != nil {
+		if errors.Is(err, domains.ErrInvalidTemplate) || errors.Is(err, domains.ErrTenantRequired) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create template", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusCreated, toTemplateResponse(t))
+}
+
+// ListTemplates handles listing the latest version of every template of the operator's tenant
+func (h *TemplateHandler) ListTemplates(c *gin.Context) {
+	templates, err := h.templateService.ListTemplates(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list templates", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toListTemplatesResponse(templates))
+}
+
+// GetTemplate handles fetching a template, optionally at a given version
+func (h *TemplateHandler) GetTemplate(c *gin.Context) {
+	var version *int
+	if versionStr := c.Query("version"); versionStr != "" {
+		v, err := strconv.Atoi(versionStr)
+		if err != nil || v < 1 {
+			respondError(c, http.StatusBadRequest, "invalid version", nil)
+			return
+		}
+		version = &v
+	}
+
+	t, err := h.templateService.GetTemplate(c.Request.Context(), getTenantID(c), c.Param("name"), version)
+	if errors.Is(err, domains.ErrTenantRequired) {
+		respondError(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get template", nil)
+		return
+	}
+	if t == nil {
+		respondError(c, http.StatusNotFound, "template not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toTemplateResponse(t))
+}
+
+// ListTemplateVersions handles listing all versions of a template
+func (h *TemplateHandler) ListTemplateVersions(c *gin.Context) {
+	templates, err := h.templateService.ListTemplateVersions(c.Request.Context(), getTenantID(c), c.Param("name"))
+	if errors.Is(err, domains.ErrTenantRequired) {
+		respondError(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list template versions", nil)
+		return
+	}
+	if len(templates) == 0 {
+		respondError(c, http.StatusNotFound, "template not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toListTemplatesResponse(templates))
+}
+
+// toListTemplatesResponse converts templates to their API representation
+func toListTemplatesResponse(templates []*domains.CommandTemplate) dto.ListTemplatesResponse {
+	resp := dto.ListTemplatesResponse{Templates: make([]dto.TemplateResponse, len(templates))}
+	for i, t := range templates {
+		resp.Templates[i] = toTemplateResponse(t)
+	}
+	return resp
+}
+
+// toTemplateResponse converts a template to its API representation
+func toTemplateResponse(t *domains.CommandTemplate) dto.TemplateResponse {
+	resp := dto.TemplateResponse{
+		TenantID:    t.TenantID,
+		Name:        t.Name,
+		Version:     t.Version,
+		Description: t.Description,
+		CommandType: t.CommandType,
+		Payload:     t.Payload,
+		Params:      make([]dto.TemplateParam, len(t.Params)),
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+	}
+	for i, p := range t.Params {
+		resp.Params[i] = dto.TemplateParam{
+			Name:        p.Name,
+			Type:        p.Type,
+			Description: p.Description,
+			Required:    p.Required,
+			Default:     p.Default,
+			Pattern:     p.Pattern,
+			Enum:        p.Enum,
+			Min:         p.Min,
+			Max:         p.Max,
+		}
+	}
+	return resp
+}
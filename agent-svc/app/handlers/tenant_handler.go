@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin context keys the operator middleware stores what it resolved under
+const (
+	tenantContextKey   = "tenant_id"
+	operatorContextKey = "operator_claims"
+)
+
+// AuthenticateOperator returns middleware rejecting requests without a valid operator token
+// The claims of the token identify the operator to the rest of the chain; see getOperatorID.
+func AuthenticateOperator(jwtService *services.JWTService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := jwtService.AuthenticateOperator(credentials(c))
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, "invalid operator token", nil)
+			c.Abort()
+			return
+		}
+		c.Set(operatorContextKey, claims)
+		c.Next()
+	}
+}
+
+// getOperatorClaims returns the claims AuthenticateOperator validated, or nil outside operator routes
+func getOperatorClaims(c *gin.Context) *services.Claims {
+	claims, _ := c.Value(operatorContextKey).(*services.Claims)
+	return claims
+}
+
+// TenantScope returns middleware resolving the tenant an operator request acts in
+// The tenant is requested with the X-Tenant-ID header; see TenantService.ResolveTenant for the default.
+// It runs after AuthenticateOperator.
+func TenantScope(tenantService *services.TenantService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, err := tenantService.ResolveTenant(c.Request.Context(), getOperatorID(c), credentials(c).TenantID)
+		if err != nil {
+			switch {
+			case errors.Is(err, domains.ErrTenantAccessDenied):
+				respondError(c, http.StatusForbidden, err.Error(), nil)
+			case errors.Is(err, domains.ErrInvalidTenant):
+				respondError(c, http.StatusBadRequest, err.Error(), nil)
+			default:
+				respondError(c, http.StatusInternalServerError, "failed to resolve tenant", nil)
+			}
+			c.Abort()
+			return
+		}
+		c.Set(tenantContextKey, tenantID)
+		c.Next()
+	}
+}
+
+// RequireTenantAdmin returns middleware rejecting operators who aren't tenant admins
+// It runs after AuthenticateOperator.
+func RequireTenantAdmin(tenantService *services.TenantService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantService.IsAdmin(getOperatorID(c)) {
+			respondError(c, http.StatusForbidden, "tenant admin required", nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getTenantID returns the tenant resolved by TenantScope; "" stands for every tenant
+func getTenantID(c *gin.Context) string {
+	return c.GetString(tenantContextKey)
+}
+
+// TenantHandler handles tenant management endpoints
+type TenantHandler struct {
+	tenantService *services.TenantService
+}
+
+// NewTenantHandler creates a new tenant handler
+func NewTenantHandler(tenantService *services.TenantService) *TenantHandler {
+	return &TenantHandler{
+		tenantService: tenantService,
+	}
+}
+
+// CreateTenant handles tenant creation
+func (h *TenantHandler) CreateTenant(c *gin.Context) {
+	var req dto.CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	t := &domains.Tenant{
+		TenantID:          req.TenantID,
+		Name:              req.Name,
+		MaxNodes:          req.MaxNodes,
+		MaxCommandsPerDay: req.MaxCommandsPerDay,
+	}
+	key, err := h.tenantService.CreateTenant(c.Request.Context(), t)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInvalidTenant):
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+		case errors.Is(err, domains.ErrTenantExists):
+			respondError(c, http.StatusConflict, "tenant already exists", nil)
+		default:
+			respondError(c, http.StatusInternalServerError, "failed to create tenant", nil)
+		}
+		return
+	}
+
+	resp := toTenantResponse(t, nil)
+	resp.EnrollmentKey = key
+	respondJSON(c, http.StatusCreated, resp)
+}
+
+// ListTenants handles listing tenants
+func (h *TenantHandler) ListTenants(c *gin.Context) {
+	tenants, err := h.tenantService.ListTenants(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list tenants", nil)
+		return
+	}
+
+	resp := dto.ListTenantsResponse{Tenants: make([]dto.TenantResponse, len(tenants))}
+	for i, t := range tenants {
+		resp.Tenants[i] = toTenantResponse(t, nil)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetTenant handles fetching a tenant with its quota usage
+func (h *TenantHandler) GetTenant(c *gin.Context) {
+	t, usage, err := h.tenantService.GetTenant(c.Request.Context(), c.Param("tenant_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get tenant", nil)
+		return
+	}
+	if t == nil {
+		respondError(c, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toTenantResponse(t, usage))
+}
+
+// UpdateTenant handles changing the name and quotas of a tenant
+func (h *TenantHandler) UpdateTenant(c *gin.Context) {
+	var req dto.UpdateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	t, err := h.tenantService.UpdateTenant(c.Request.Context(), c.Param("tenant_id"), req.Name, req.MaxNodes, req.MaxCommandsPerDay)
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidTenant) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to update tenant", nil)
+		return
+	}
+	if t == nil {
+		respondError(c, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toTenantResponse(t, nil))
+}
+
+// RotateEnrollmentKey handles replacing the enrollment key of a tenant
+func (h *TenantHandler) RotateEnrollmentKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := c.Param("tenant_id")
+
+	key, err := h.tenantService.RotateEnrollmentKey(ctx, tenantID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to rotate enrollment key", nil)
+		return
+	}
+	if key == "" {
+		respondError(c, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	t, usage, err := h.tenantService.GetTenant(ctx, tenantID)
+	if err != nil || t == nil {
+		respondError(c, http.StatusInternalServerError, "failed to get tenant", nil)
+		return
+	}
+	resp := toTenantResponse(t, usage)
+	resp.EnrollmentKey = key
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// ListTenantOperators handles listing the operators of a tenant
+func (h *TenantHandler) ListTenantOperators(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := c.Param("tenant_id")
+
+	t, _, err := h.tenantService.GetTenant(ctx, tenantID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get tenant", nil)
+		return
+	}
+	if t == nil {
+		respondError(c, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	operators, err := h.tenantService.ListOperators(ctx, tenantID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list tenant operators", nil)
+		return
+	}
+	if operators == nil {
+		operators = []string{}
+	}
+	respondJSON(c, http.StatusOK, dto.TenantOperatorsResponse{TenantID: tenantID, Operators: operators})
+}
+
+// AddTenantOperator handles making an operator a member of a tenant
+func (h *TenantHandler) AddTenantOperator(c *gin.Context) {
+	var req dto.AddTenantOperatorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	found, err := h.tenantService.AddOperator(c.Request.Context(), c.Param("tenant_id"), req.OperatorID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to add tenant operator", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveTenantOperator handles removing an operator from a tenant
+func (h *TenantHandler) RemoveTenantOperator(c *gin.Context) {
+	found, err := h.tenantService.RemoveOperator(c.Request.Context(), c.Param("tenant_id"), c.Param("operator_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to remove tenant operator", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "tenant operator not found", nil)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// toTenantResponse converts a tenant to its API representation, without its enrollment key
+func toTenantResponse(t *domains.Tenant, usage *domains.TenantUsage) dto.TenantResponse {
+	resp := dto.TenantResponse{
+		TenantID:          t.TenantID,
+		Name:              t.Name,
+		MaxNodes:          t.MaxNodes,
+		MaxCommandsPerDay: t.MaxCommandsPerDay,
+		CreatedAt:         t.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         t.UpdatedAt.Format(time.RFC3339),
+	}
+	if usage != nil {
+		resp.Usage = &dto.TenantUsageResponse{Nodes: usage.Nodes, CommandsPerDay: usage.CommandsPerDay}
+	}
+	return resp
+}
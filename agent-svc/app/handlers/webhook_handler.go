@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// WebhookHandler handles webhook subscription endpoints
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+// CreateWebhook handles webhook subscription creation
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req dto.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	w := &domains.WebhookSubscription{
+		TenantID:   getTenantID(c),
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+		Enabled:    true,
+	}
+	if req.Enabled != nil {
+		w.Enabled = *req.Enabled
+	}
+
+	if err := h.webhookService.CreateWebhook(c.Request.Context(), w); err != nil {
+		if errors.Is(err, domains.ErrInvalidWebhook) || errors.Is(err, domains.ErrTenantRequired) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create webhook", nil)
+		return
+	}
+
+	resp := toWebhookResponse(w)
+	resp.Secret = w.Secret
+	respondJSON(c, http.StatusCreated, resp)
+}
+
+// ListWebhooks handles listing the webhook subscriptions of the operator's tenant
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.webhookService.ListWebhooks(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list webhooks", nil)
+		return
+	}
+
+	resp := dto.ListWebhooksResponse{Webhooks: make([]dto.WebhookResponse, len(webhooks))}
+	for i, w := range webhooks {
+		resp.Webhooks[i] = toWebhookResponse(w)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetWebhook handles fetching a webhook subscription
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhookID, ok := parseWebhookID(c)
+	if !ok {
+		return
+	}
+
+	w, err := h.webhookService.GetWebhook(c.Request.Context(), getTenantID(c), webhookID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get webhook", nil)
+		return
+	}
+	if w == nil {
+		respondError(c, http.StatusNotFound, "webhook not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toWebhookResponse(w))
+}
+
+// DeleteWebhook handles webhook subscription deletion
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhookID, ok := parseWebhookID(c)
+	if !ok {
+		return
+	}
+
+	found, err := h.webhookService.DeleteWebhook(c.Request.Context(), getTenantID(c), webhookID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to delete webhook", nil)
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "webhook not found", nil)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// EnableWebhook handles enabling a webhook subscription
+func (h *WebhookHandler) EnableWebhook(c *gin.Context) {
+	h.setWebhookEnabled(c, true)
+}
+
+// DisableWebhook handles disabling a webhook subscription
+func (h *WebhookHandler) DisableWebhook(c *gin.Context) {
+	h.setWebhookEnabled(c, false)
+}
+
+// setWebhookEnabled enables or disables a webhook subscription and responds with its new state
+func (h *WebhookHandler) setWebhookEnabled(c *gin.Context, enabled bool) {
+	webhookID, ok := parseWebhookID(c)
+	if !ok {
+		return
+	}
+
+	w, err := h.webhookService.SetWebhookEnabled(c.Request.Context(), getTenantID(c), webhookID, enabled)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to update webhook", nil)
+		return
+	}
+	if w == nil {
+		respondError(c, http.StatusNotFound, "webhook not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toWebhookResponse(w))
+}
+
+// ListWebhookDeliveries handles fetching the delivery log of a webhook subscription
+func (h *WebhookHandler) ListWebhookDeliveries(c *gin.Context) {
+	webhookID, ok := parseWebhookID(c)
+	if !ok {
+		return
+	}
+
+	var status *string
+	if s := c.Query("status"); s != "" {
+		status = &s
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	ctx := c.Request.Context()
+	w, err := h.webhookService.GetWebhook(ctx, getTenantID(c), webhookID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get webhook", nil)
+		return
+	}
+	if w == nil {
+		respondError(c, http.StatusNotFound, "webhook not found", nil)
+		return
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(ctx, webhookID, status, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list webhook deliveries", nil)
+		return
+	}
+
+	resp := dto.WebhookDeliveriesResponse{
+		WebhookID:  webhookID.String(),
+		Deliveries: make([]dto.WebhookDeliveryResponse, len(deliveries)),
+	}
+	for i, d := range deliveries {
+		resp.Deliveries[i] = toWebhookDeliveryResponse(d)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// RetryWebhookDelivery handles redelivering a dead-lettered delivery
+func (h *WebhookHandler) RetryWebhookDelivery(c *gin.Context) {
+	webhookID, ok := parseWebhookID(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(c.Param("delivery_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid delivery_id", nil)
+		return
+	}
+
+	d, err := h.webhookService.RetryDelivery(c.Request.Context(), getTenantID(c), webhookID, deliveryID)
+	if err != nil {
+		if errors.Is(err, domains.ErrWebhookDeliveryState) {
+			respondError(c, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to retry webhook delivery", nil)
+		return
+	}
+	if d == nil {
+		respondError(c, http.StatusNotFound, "webhook delivery not found", nil)
+		return
+	}
+
+	respondJSON(c, http.StatusOK, toWebhookDeliveryResponse(d))
+}
+
+// parseWebhookID parses the webhook_id path parameter, responding with 400 if it is invalid
+func parseWebhookID(c *gin.Context) (uuid.UUID, bool) {
+	webhookID, err := uuid.Parse(c.Param("webhook_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid webhook_id", nil)
+		return uuid.Nil, false
+	}
+	return webhookID, true
+}
+
+// toWebhookResponse converts a webhook subscription to its API representation, without its secret
+func toWebhookResponse(w *domains.WebhookSubscription) dto.WebhookResponse {
+	return dto.WebhookResponse{
+		WebhookID:  w.WebhookID.String(),
+		TenantID:   w.TenantID,
+		URL:        w.URL,
+		EventTypes: w.EventTypes,
+		Enabled:    w.Enabled,
+		CreatedAt:  w.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  w.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// toWebhookDeliveryResponse converts a webhook delivery to its API representation
+func toWebhookDeliveryResponse(d *domains.WebhookDelivery) dto.WebhookDeliveryResponse {
+	return dto.WebhookDeliveryResponse{
+		DeliveryID:       d.DeliveryID.String(),
+		EventID:          d.EventID.String(),
+		EventType:        d.EventType,
+		Status:           d.Status,
+		Attempts:         d.Attempts,
+		NextAttemptAt:    formatTime(d.NextAttemptAt),
+		LastResponseCode: d.LastResponseCode,
+		LastError:        d.LastError,
+		DeliveredAt:      formatTime(d.DeliveredAt),
+		CreatedAt:        d.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:        d.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/app/services"
+	"agent-svc/app/utils"
+	"agent-svc/client/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// WorkflowHandler handles workflow-related endpoints
+type WorkflowHandler struct {
+	workflowService *services.WorkflowService
+}
+
+// NewWorkflowHandler creates a new workflow handler
+func NewWorkflowHandler(workflowService *services.WorkflowService) *WorkflowHandler {
+	return &WorkflowHandler{
+		workflowService: workflowService,
+	}
+}
+
+// CreateWorkflow handles starting a workflow from a JSON or YAML definition
+func (h *WorkflowHandler) CreateWorkflow(c *gin.Context) {
+	var req dto.CreateWorkflowRequest
+	var err error
+	switch c.ContentType() {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		err = c.ShouldBindYAML(&req)
+	default:
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "validation failed", map[string]string{"error": err.Error()})
+		return
+	}
+
+	def := domains.WorkflowDefinition{
+		Name:  req.Name,
+		Steps: make([]domains.WorkflowStep, len(req.Steps)),
+	}
+	for i, step := range req.Steps {
+		def.Steps[i] = domains.WorkflowStep{
+			ID:          step.ID,
+			NodeID:      step.NodeID,
+			CommandType: step.CommandType,
+			Payload:     step.Payload,
+			OnSuccess:   step.OnSuccess,
+			OnFailure:   step.OnFailure,
+		}
+		if len(step.Selector) > 0 {
+			def.Steps[i].Selector = domains.NodeSelector(step.Selector)
+		}
+	}
+
+	ctx := c.Request.Context()
+	wf, err := h.workflowService.StartWorkflow(ctx, getTenantID(c), def)
+	if err != nil && wf == nil {
+		if errors.Is(err, domains.ErrInvalidWorkflow) || errors.Is(err, domains.ErrTenantRequired) {
+			respondError(c, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "failed to create workflow", nil)
+		return
+	}
+
+	// The workflow is persisted even if starting its entry steps failed; the reconciler picks it up
+	h.respondWorkflow(c, http.StatusCreated, wf.WorkflowID)
+}
+
+// ListWorkflows handles listing the workflow runs of the operator's tenant
+func (h *WorkflowHandler) ListWorkflows(c *gin.Context) {
+	var status *string
+	if s := c.Query("status"); s != "" {
+		status = &s
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	workflows, err := h.workflowService.ListWorkflows(c.Request.Context(), getTenantID(c), status, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to list workflows", nil)
+		return
+	}
+
+	resp := dto.ListWorkflowsResponse{Workflows: make([]dto.WorkflowResponse, len(workflows))}
+	for i, wf := range workflows {
+		resp.Workflows[i] = toWorkflowResponse(wf, nil)
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
+
+// GetWorkflow handles fetching a workflow run with per-step progress
+func (h *WorkflowHandler) GetWorkflow(c *gin.Context) {
+	workflowID, err := uuid.Parse(c.Param("workflow_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid workflow_id", nil)
+		return
+	}
+
+	h.respondWorkflow(c, http.StatusOK, workflowID)
+}
+
+// respondWorkflow responds with the current state of a workflow run
+func (h *WorkflowHandler) respondWorkflow(c *gin.Context, status int, workflowID uuid.UUID) {
+	wf, steps, err := h.workflowService.GetWorkflow(c.Request.Context(), getTenantID(c), workflowID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to get workflow", nil)
+		return
+	}
+	if wf == nil {
+		respondError(c, http.StatusNotFound, "workflow not found", nil)
+		return
+	}
+
+	respondJSON(c, status, toWorkflowResponse(wf, steps))
+}
+
+// toWorkflowResponse converts a workflow run and its step states to their API representation
+func toWorkflowResponse(wf *domains.Workflow, steps []domains.WorkflowStepState) dto.WorkflowResponse {
+	resp := dto.WorkflowResponse{
+		WorkflowID: wf.WorkflowID.String(),
+		TenantID:   wf.TenantID,
+		Name:       wf.Name,
+		Status:     wf.Status,
+		CreatedAt:  wf.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  wf.UpdatedAt.Format(time.RFC3339),
+		FinishedAt: formatTime(wf.FinishedAt),
+	}
+
+	for _, state := range steps {
+		commandIDs := make([]string, len(state.CommandIDs))
+		for i, commandID := range state.CommandIDs {
+			commandIDs[i] = commandID.String()
+		}
+
+		step := dto.WorkflowStepResponse{
+			StepID:     state.StepID,
+			Status:     state.Status,
+			CommandIDs: commandIDs,
+			ErrorMsg:   state.ErrorMsg,
+			StartedAt:  formatTime(state.StartedAt),
+			FinishedAt: formatTime(state.FinishedAt),
+		}
+		if def := wf.Definition.Step(state.StepID); def != nil {
+			step.NodeID = def.NodeID
+			step.Selector = def.Selector
+			step.CommandType = def.CommandType
+			step.OnSuccess = def.OnSuccess
+			step.OnFailure = def.OnFailure
+		}
+		resp.Steps = append(resp.Steps, step)
+	}
+	return resp
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stateQueryTimeout bounds the database queries made on each scrape
+const stateQueryTimeout = 5 * time.Second
+
+var (
+	commandsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "commands"),
+		"Commands by type and current status, including queue depth as status=\"queued\".",
+		[]string{"command_type", "status"}, nil,
+	)
+	nodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "nodes"),
+		"Registered nodes by health state.",
+		[]string{"state"}, nil,
+	)
+)
+
+// stateCollector reports command and node counts read from storage on each scrape, so every replica
+// reports the same fleet-wide values
+type stateCollector struct {
+	storage clients.StorageAdapter
+}
+
+// NewStateCollector creates a collector of command counts by status and node counts by health state
+func NewStateCollector(storage clients.StorageAdapter) prometheus.Collector {
+	return &stateCollector{storage: storage}
+}
+
+// Describe implements prometheus.Collector
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- commandsDesc
+	ch <- nodesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+
+	counts, err := c.storage.CountCommandsByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(commandsDesc, err)
+	} else {
+		for _, count := range counts {
+			ch <- prometheus.MustNewConstMetric(commandsDesc, prometheus.GaugeValue, float64(count.Count), count.CommandType, count.Status)
+		}
+	}
+
+	nodes, err := c.storage.ListNodes(ctx, "")
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(nodesDesc, err)
+		return
+	}
+	states := map[string]int{domains.NodeHealthy: 0, domains.NodeUnhealthy: 0, domains.NodeDisabled: 0}
+	now := time.Now()
+	for i := range nodes {
+		states[nodes[i].HealthState(now)]++
+	}
+	for state, count := range states {
+		ch <- prometheus.MustNewConstMetric(nodesDesc, prometheus.GaugeValue, float64(count), state)
+	}
+}
+
+// poolDesc builds the description of a pgxpool metric
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+var (
+	poolAcquiredConnsDesc   = poolDesc("acquired_conns", "Connections currently acquired from the pool.")
+	poolIdleConnsDesc       = poolDesc("idle_conns", "Idle connections in the pool.")
+	poolConstructingDesc    = poolDesc("constructing_conns", "Connections being established.")
+	poolTotalConnsDesc      = poolDesc("total_conns", "Connections in the pool.")
+	poolMaxConnsDesc        = poolDesc("max_conns", "Maximum size of the pool.")
+	poolAcquireCountDesc    = poolDesc("acquires_total", "Successful connection acquires.")
+	poolAcquireDurationDesc = poolDesc("acquire_duration_seconds_total", "Total time spent acquiring connections.")
+	poolEmptyAcquireDesc    = poolDesc("empty_acquires_total", "Acquires that had to wait because no idle connection was available.")
+	poolCanceledAcquireDesc = poolDesc("canceled_acquires_total", "Acquires canceled by their context.")
+	poolNewConnsDesc        = poolDesc("new_conns_total", "Connections opened.")
+)
+
+// poolCollector reports pgxpool statistics
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+// NewPoolCollector creates a collector of the statistics returned by stat
+func NewPoolCollector(stat func() *pgxpool.Stat) prometheus.Collector {
+	return &poolCollector{stat: stat}
+}
+
+// Describe implements prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		poolAcquiredConnsDesc, poolIdleConnsDesc, poolConstructingDesc, poolTotalConnsDesc, poolMaxConnsDesc,
+		poolAcquireCountDesc, poolAcquireDurationDesc, poolEmptyAcquireDesc, poolCanceledAcquireDesc, poolNewConnsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolConstructingDesc, prometheus.GaugeValue, float64(s.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCountDesc, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDurationDesc, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquireDesc, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledAcquireDesc, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolNewConnsDesc, prometheus.CounterValue, float64(s.NewConnsCount()))
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "agent_svc"
+
+// Registry holds the metrics served at /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration observes request latency per route template, so path parameters don't
+	// create a series per ID
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// CommandDispatchLatency observes the time a command waited in the queue
+	CommandDispatchLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "commands",
+		Name:      "dispatch_latency_seconds",
+		Help:      "Time from command submission to dispatch to a node.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 16), // 0.25s to ~2h
+	}, []string{"command_type"})
+
+	// CommandRunDuration observes the time from dispatch until the node reported a final status
+	CommandRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "commands",
+		Name:      "run_duration_seconds",
+		Help:      "Time from command dispatch to completion, by final status.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 16), // 0.5s to ~4.5h
+	}, []string{"command_type", "status"})
+
+	// LongPollsOpen tracks node polls currently waiting in GetNextCommand
+	LongPollsOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "commands",
+		Name:      "long_polls_open",
+		Help:      "Command long-polls currently open.",
+	})
+
+	// PolicyDecisions counts submissions evaluated against the command policy, by the action taken and the
+	// rule that decided, "" for the default action
+	PolicyDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "commands",
+		Name:      "policy_decisions_total",
+		Help:      "Command submissions by command policy action and deciding rule.",
+	}, []string{"action", "rule"})
+
+	// LogChunksIngested counts stored log chunks
+	LogChunksIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logs",
+		Name:      "chunks_ingested_total",
+		Help:      "Log chunks ingested, by stream.",
+	}, []string{"stream"})
+
+	// LogBytesIngested counts the data bytes of stored log chunks
+	LogBytesIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logs",
+		Name:      "bytes_ingested_total",
+		Help:      "Log bytes ingested, by stream.",
+	}, []string{"stream"})
+
+	// LogChunksDropped counts chunks acked without being stored because they exceeded the output limit
+	LogChunksDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logs",
+		Name:      "chunks_dropped_total",
+		Help:      "Log chunks dropped for exceeding the command output limit.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		CommandDispatchLatency,
+		CommandRunDuration,
+		LongPollsOpen,
+		PolicyDecisions,
+		LogChunksIngested,
+		LogBytesIngested,
+		LogChunksDropped,
+	)
+}
+
+// Handler serves the metrics in Registry
+// A collector that fails, such as one whose database query timed out, is reported in the log and left out
+// while the other metrics are still served.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+// Middleware observes the latency of every request in HTTPRequestDuration
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,231 @@
+package openapi
+
+import (
+	"net/http"
+
+	"agent-svc/client/dto"
+)
+
+// Authentication of a route
+const (
+	AuthNone     = ""         // public
+	AuthNode     = "node"     // the JWT a node received on registration
+	AuthOperator = "operator" // an operator token
+	AuthAdmin    = "admin"    // an operator who is a tenant admin
+)
+
+// Route describes an endpoint registered in setupRoutes
+type Route struct {
+	Method  string
+	Path    string // gin path, e.g. /v1/commands/:command_id
+	Summary string
+	Tag     string
+	Auth    string
+	Scoped  bool    // resolves the tenant from X-Tenant-ID
+	Params  []Param // query and header parameters; path parameters are taken from Path
+
+	Request      interface{} // dto value the JSON body decodes into; nil for routes without a body
+	BodyTypes    []string    // content types of the body; defaults to application/json
+	OptionalBody bool        // the body may be omitted
+	Responses    []Reply
+}
+
+// Param describes a query or header parameter
+type Param struct {
+	Name        string
+	In          string // query or header
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// Reply describes a successful response of a route
+type Reply struct {
+	Status      int
+	Description string
+	Body        interface{} // dto value of the JSON body; nil for an empty body
+	ContentType string      // defaults to application/json
+}
+
+// query describes a query parameter
+func query(name, typ, description string) Param {
+	return Param{Name: name, In: "query", Type: typ, Description: description}
+}
+
+// ok describes a 200 response
+func ok(body interface{}) []Reply {
+	return []Reply{{Status: http.StatusOK, Body: body}}
+}
+
+// created describes a 201 response
+func created(body interface{}) []Reply {
+	return []Reply{{Status: http.StatusCreated, Body: body}}
+}
+
+// noContent describes a 204 response
+func noContent() []Reply {
+	return []Reply{{Status: http.StatusNoContent}}
+}
+
+var limitParam = query("limit", "integer", "maximum number of results")
+
+// Routes is the route table of the API; every route registered in setupRoutes must have an entry
+var Routes = []Route{
+	{Method: http.MethodGet, Path: "/health", Summary: "Liveness check", Tag: "health",
+		Responses: ok(map[string]string{})},
+	{Method: http.MethodGet, Path: "/ready", Summary: "Readiness of the database, migrations and background workers", Tag: "health",
+		Responses: []Reply{
+			{Status: http.StatusOK, Description: "ready", Body: dto.ReadinessResponse{}},
+			{Status: http.StatusServiceUnavailable, Description: "not ready", Body: dto.ReadinessResponse{}},
+		}},
+	{Method: http.MethodGet, Path: "/metrics", Summary: "Prometheus metrics", Tag: "health",
+		Responses: []Reply{{Status: http.StatusOK, ContentType: "text/plain"}}},
+	{Method: http.MethodGet, Path: "/v1/openapi.json", Summary: "This OpenAPI document", Tag: "health",
+		Responses: []Reply{{Status: http.StatusOK}}},
+
+	{Method: http.MethodPost, Path: "/v1/agents/register", Summary: "Register a node and issue its token", Tag: "agents",
+		Request: dto.RegisterRequest{}, Responses: ok(dto.RegisterResponse{})},
+	{Method: http.MethodPost, Path: "/v1/agents/heartbeat", Summary: "Report that a node is alive", Tag: "agents", Auth: AuthNode,
+		Request: dto.HeartbeatRequest{}, Responses: ok(dto.HeartbeatResponse{})},
+	{Method: http.MethodGet, Path: "/v1/agents", Summary: "List nodes", Tag: "agents", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListNodesResponse{})},
+	{Method: http.MethodGet, Path: "/v1/agents/:node_id", Summary: "Get a node", Tag: "agents", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.NodeResponse{})},
+	{Method: http.MethodPatch, Path: "/v1/agents/:node_id", Summary: "Label, disable or enable a node", Tag: "agents", Auth: AuthOperator, Scoped: true,
+		Request: dto.UpdateNodeRequest{}, Responses: ok(dto.NodeResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/commands/submit", Summary: "Submit a command to a node", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Params:  []Param{{Name: "Idempotency-Key", In: "header", Type: "string", Description: "returns the original command when repeated"}},
+		Request: dto.SubmitCommandRequest{},
+		Responses: []Reply{
+			{Status: http.StatusCreated, Description: "submitted", Body: dto.SubmitCommandResponse{}},
+			{Status: http.StatusOK, Description: "replayed for a repeated idempotency key", Body: dto.SubmitCommandResponse{}},
+		}},
+	{Method: http.MethodGet, Path: "/v1/commands", Summary: "List recent commands", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Params:    []Param{query("node_id", "string", "only commands of this node"), limitParam},
+		Responses: ok(dto.ListCommandsResponse{})},
+	{Method: http.MethodDelete, Path: "/v1/commands/queued", Summary: "Delete queued commands", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Params:    []Param{query("node_id", "string", "only commands of this node")},
+		Responses: ok(dto.DeleteQueuedCommandsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/commands/next", Summary: "Poll the commands queued for the node", Tag: "commands", Auth: AuthNode,
+		Params:    []Param{query("wait", "integer", "seconds to wait for a command")},
+		Responses: ok(dto.CommandsResponse{})},
+	{Method: http.MethodPost, Path: "/v1/commands/logs", Summary: "Push log chunks of a command", Tag: "commands", Auth: AuthNode,
+		Request: dto.PushCommandLogsRequest{}, Responses: created(dto.PushCommandLogsResponse{})},
+	{Method: http.MethodPost, Path: "/v1/commands/status", Summary: "Report the status of a command", Tag: "commands", Auth: AuthNode,
+		Request: dto.CommandStatusRequest{}, Responses: ok(dto.CommandStatusResponse{})},
+	{Method: http.MethodGet, Path: "/v1/commands/:command_id", Summary: "Get a command with its attempts", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.CommandAttemptsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/commands/:command_id/logs", Summary: "Get the log chunks of a command", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Params:    []Param{query("after_chunk_index", "integer", "only chunks at or after this index")},
+		Responses: ok(dto.GetLogsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/commands/:command_id/history", Summary: "Get the status transitions of a command", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.CommandHistoryResponse{})},
+	{Method: http.MethodPost, Path: "/v1/commands/:command_id/cancel", Summary: "Cancel a queued or pending command", Tag: "commands", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.CommandDetailResponse{})},
+	{Method: http.MethodGet, Path: "/v1/commands/:command_id/approval", Summary: "Get the approval request of a command", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.CommandApprovalResponse{})},
+	{Method: http.MethodPost, Path: "/v1/commands/:command_id/approve", Summary: "Approve a command pending approval", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Request: dto.ApprovalDecisionRequest{}, OptionalBody: true, Responses: ok(dto.CommandDetailResponse{})},
+	{Method: http.MethodPost, Path: "/v1/commands/:command_id/reject", Summary: "Reject a command pending approval", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Request: dto.ApprovalDecisionRequest{}, OptionalBody: true, Responses: ok(dto.CommandDetailResponse{})},
+	{Method: http.MethodGet, Path: "/v1/approvals", Summary: "List commands pending approval", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Params: []Param{limitParam}, Responses: ok(dto.ListApprovalsResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/schedules", Summary: "Create a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Request: dto.CreateScheduleRequest{}, Responses: created(dto.ScheduleResponse{})},
+	{Method: http.MethodGet, Path: "/v1/schedules", Summary: "List schedules", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListSchedulesResponse{})},
+	{Method: http.MethodGet, Path: "/v1/schedules/:schedule_id", Summary: "Get a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ScheduleResponse{})},
+	{Method: http.MethodDelete, Path: "/v1/schedules/:schedule_id", Summary: "Delete a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: noContent()},
+	{Method: http.MethodPost, Path: "/v1/schedules/:schedule_id/pause", Summary: "Pause a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ScheduleResponse{})},
+	{Method: http.MethodPost, Path: "/v1/schedules/:schedule_id/resume", Summary: "Resume a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ScheduleResponse{})},
+	{Method: http.MethodPost, Path: "/v1/schedules/:schedule_id/run", Summary: "Fire a schedule now", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Responses: created(dto.ScheduleRunResponse{})},
+	{Method: http.MethodGet, Path: "/v1/schedules/:schedule_id/runs", Summary: "List the runs of a schedule", Tag: "schedules", Auth: AuthOperator, Scoped: true,
+		Params: []Param{limitParam}, Responses: ok(dto.ScheduleRunsResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/workflows", Summary: "Start a workflow", Tag: "workflows", Auth: AuthOperator, Scoped: true,
+		Request: dto.CreateWorkflowRequest{}, BodyTypes: []string{"application/json", "application/yaml"},
+		Responses: created(dto.WorkflowResponse{})},
+	{Method: http.MethodGet, Path: "/v1/workflows", Summary: "List workflows", Tag: "workflows", Auth: AuthOperator, Scoped: true,
+		Params: []Param{query("status", "string", "only workflows in this status"), limitParam}, Responses: ok(dto.ListWorkflowsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/workflows/:workflow_id", Summary: "Get a workflow with its steps", Tag: "workflows", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.WorkflowResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/rollouts", Summary: "Start a rollout", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Request: dto.CreateRolloutRequest{}, Responses: created(dto.RolloutResponse{})},
+	{Method: http.MethodGet, Path: "/v1/rollouts", Summary: "List rollouts", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Params: []Param{query("status", "string", "only rollouts in this status"), limitParam}, Responses: ok(dto.ListRolloutsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/rollouts/:rollout_id", Summary: "Get a rollout with its batches", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.RolloutResponse{})},
+	{Method: http.MethodPost, Path: "/v1/rollouts/:rollout_id/pause", Summary: "Pause a rollout", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.RolloutResponse{})},
+	{Method: http.MethodPost, Path: "/v1/rollouts/:rollout_id/resume", Summary: "Resume a rollout", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.RolloutResponse{})},
+	{Method: http.MethodPost, Path: "/v1/rollouts/:rollout_id/abort", Summary: "Abort a rollout", Tag: "rollouts", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.RolloutResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/templates", Summary: "Create a template or a new version of it", Tag: "templates", Auth: AuthOperator, Scoped: true,
+		Request: dto.CreateTemplateRequest{}, Responses: created(dto.TemplateResponse{})},
+	{Method: http.MethodGet, Path: "/v1/templates", Summary: "List the latest version of each template", Tag: "templates", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListTemplatesResponse{})},
+	{Method: http.MethodGet, Path: "/v1/templates/:name", Summary: "Get a template", Tag: "templates", Auth: AuthOperator, Scoped: true,
+		Params: []Param{query("version", "integer", "version to get; defaults to the latest")}, Responses: ok(dto.TemplateResponse{})},
+	{Method: http.MethodGet, Path: "/v1/templates/:name/versions", Summary: "List the versions of a template", Tag: "templates", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListTemplatesResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/webhooks", Summary: "Create a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Request: dto.CreateWebhookRequest{}, Responses: created(dto.WebhookResponse{})},
+	{Method: http.MethodGet, Path: "/v1/webhooks", Summary: "List webhooks", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListWebhooksResponse{})},
+	{Method: http.MethodGet, Path: "/v1/webhooks/:webhook_id", Summary: "Get a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.WebhookResponse{})},
+	{Method: http.MethodDelete, Path: "/v1/webhooks/:webhook_id", Summary: "Delete a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: noContent()},
+	{Method: http.MethodPost, Path: "/v1/webhooks/:webhook_id/enable", Summary: "Enable a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.WebhookResponse{})},
+	{Method: http.MethodPost, Path: "/v1/webhooks/:webhook_id/disable", Summary: "Disable a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.WebhookResponse{})},
+	{Method: http.MethodGet, Path: "/v1/webhooks/:webhook_id/deliveries", Summary: "List the deliveries of a webhook", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Params: []Param{query("status", "string", "only deliveries in this status"), limitParam}, Responses: ok(dto.WebhookDeliveriesResponse{})},
+	{Method: http.MethodPost, Path: "/v1/webhooks/:webhook_id/deliveries/:delivery_id/retry", Summary: "Retry a failed delivery", Tag: "webhooks", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.WebhookDeliveryResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/approval-policies", Summary: "Create an approval policy", Tag: "approvals", Auth: AuthAdmin,
+		Request: dto.CreateApprovalPolicyRequest{}, Responses: created(dto.ApprovalPolicyResponse{})},
+	{Method: http.MethodGet, Path: "/v1/approval-policies", Summary: "List approval policies", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ListApprovalPoliciesResponse{})},
+	{Method: http.MethodGet, Path: "/v1/approval-policies/:policy_id", Summary: "Get an approval policy", Tag: "approvals", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.ApprovalPolicyResponse{})},
+	{Method: http.MethodDelete, Path: "/v1/approval-policies/:policy_id", Summary: "Delete an approval policy", Tag: "approvals", Auth: AuthAdmin,
+		Responses: noContent()},
+
+	{Method: http.MethodGet, Path: "/v1/policies", Summary: "Get the command policy in effect", Tag: "policies", Auth: AuthOperator, Scoped: true,
+		Responses: ok(dto.CommandPolicyResponse{})},
+	{Method: http.MethodPut, Path: "/v1/policies", Summary: "Replace the command policy", Tag: "policies", Auth: AuthAdmin,
+		Request: dto.CommandPolicyRequest{}, BodyTypes: []string{"application/json", "application/yaml"},
+		Responses: ok(dto.CommandPolicyResponse{})},
+	{Method: http.MethodPost, Path: "/v1/policies/evaluate", Summary: "Explain the command policy's decision on a submission", Tag: "policies", Auth: AuthOperator, Scoped: true,
+		Request: dto.EvaluatePolicyRequest{}, Responses: ok(dto.EvaluatePolicyResponse{})},
+
+	{Method: http.MethodPost, Path: "/v1/tenants", Summary: "Create a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Request: dto.CreateTenantRequest{}, Responses: created(dto.TenantResponse{})},
+	{Method: http.MethodGet, Path: "/v1/tenants", Summary: "List tenants", Tag: "tenants", Auth: AuthAdmin,
+		Responses: ok(dto.ListTenantsResponse{})},
+	{Method: http.MethodGet, Path: "/v1/tenants/:tenant_id", Summary: "Get a tenant with its usage", Tag: "tenants", Auth: AuthAdmin,
+		Responses: ok(dto.TenantResponse{})},
+	{Method: http.MethodPatch, Path: "/v1/tenants/:tenant_id", Summary: "Update a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Request: dto.UpdateTenantRequest{}, Responses: ok(dto.TenantResponse{})},
+	{Method: http.MethodPost, Path: "/v1/tenants/:tenant_id/enrollment-key", Summary: "Rotate the enrollment key of a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Responses: ok(dto.TenantResponse{})},
+	{Method: http.MethodGet, Path: "/v1/tenants/:tenant_id/operators", Summary: "List the operators of a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Responses: ok(dto.TenantOperatorsResponse{})},
+	{Method: http.MethodPost, Path: "/v1/tenants/:tenant_id/operators", Summary: "Add an operator to a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Request: dto.AddTenantOperatorRequest{}, Responses: noContent()},
+	{Method: http.MethodDelete, Path: "/v1/tenants/:tenant_id/operators/:operator_id", Summary: "Remove an operator from a tenant", Tag: "tenants", Auth: AuthAdmin,
+		Responses: noContent()},
+}
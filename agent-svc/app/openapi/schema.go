@@ -0,0 +1,190 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGenerator turns dto types into schemas, collecting named struct types as components
+type schemaGenerator struct {
+	components map[string]*Schema
+}
+
+// newSchemaGenerator creates a generator adding components to components
+func newSchemaGenerator(components map[string]*Schema) *schemaGenerator {
+	return &schemaGenerator{components: components}
+}
+
+// schemaOf returns the schema of a type; named structs are returned as a reference to their component
+func (g *schemaGenerator) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := g.components[t.Name()]; !ok {
+			// Registered before the fields are generated, so self-referencing types terminate
+			g.components[t.Name()] = &Schema{}
+			*g.components[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.structSchema(t)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	default:
+		// interface{}: any JSON value
+		return &Schema{}
+	}
+}
+
+// structSchema returns the object schema of a struct, applying the validate tags of its fields
+// Embedded structs contribute their fields, like encoding/json does.
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := g.structSchema(field.Type)
+			for propName, prop := range embedded.Properties {
+				s.Properties[propName] = prop
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := g.schemaOf(field.Type)
+		if applyRules(prop, field.Type, splitRules(field.Tag.Get("validate"))) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	return s
+}
+
+// splitRules splits a validate tag into its rules, decoding the escapes the validator uses in parameters
+func splitRules(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	rules := strings.Split(tag, ",")
+	unescape := strings.NewReplacer("0x2C", ",", "0x7C", "|")
+	for i, rule := range rules {
+		rules[i] = unescape.Replace(rule)
+	}
+	return rules
+}
+
+// applyRules maps validate rules onto the schema of a field of type t and reports whether the field is required
+// Rules after dive apply to the elements of a slice or the values of a map. Rules without an equivalent,
+// such as required_without, are left to the handlers, which validate every request with the validator too.
+func applyRules(s *Schema, t reflect.Type, rules []string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if s.Ref != "" {
+		// Constraints of a referenced component live on the component
+		s = &Schema{}
+	}
+
+	required := false
+	for i, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "omitempty":
+			s.omitEmpty = true
+		case "min", "max", "len":
+			applyBound(s, t, name, param)
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "url", "uri":
+			s.Format = "uri"
+		case "email":
+			s.Format = "email"
+		case "uuid", "uuid4":
+			s.Format = "uuid"
+		case "regexp":
+			s.Pattern = "^(?:" + param + ")$"
+		case "dive":
+			switch {
+			case s.Items != nil:
+				applyRules(s.Items, t.Elem(), rules[i+1:])
+			case s.AdditionalProperties != nil:
+				applyRules(s.AdditionalProperties, t.Elem(), rules[i+1:])
+			}
+			return required
+		}
+	}
+	return required
+}
+
+// applyBound maps a min, max or len rule onto the bound matching the kind of the field
+func applyBound(s *Schema, t reflect.Type, rule, param string) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	lower := rule == "min" || rule == "len"
+	upper := rule == "max" || rule == "len"
+	count := int(n)
+
+	switch t.Kind() {
+	case reflect.String:
+		setBound(&s.MinLength, &s.MaxLength, count, lower, upper)
+	case reflect.Slice, reflect.Array:
+		setBound(&s.MinItems, &s.MaxItems, count, lower, upper)
+	case reflect.Map:
+		setBound(&s.MinProperties, &s.MaxProperties, count, lower, upper)
+	default:
+		if lower {
+			s.Minimum = &n
+		}
+		if upper {
+			s.Maximum = &n
+		}
+	}
+}
+
+// setBound sets the lower and/or upper count bound
+func setBound(min, max **int, n int, lower, upper bool) {
+	if lower {
+		*min = &n
+	}
+	if upper {
+		*max = &n
+	}
+}
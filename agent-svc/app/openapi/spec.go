@@ -0,0 +1,182 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"agent-svc/client"
+	"agent-svc/client/dto"
+)
+
+var (
+	specOnce sync.Once
+	spec     *Document
+)
+
+// Spec returns the OpenAPI document of the API, built from Routes on first use
+func Spec() *Document {
+	specOnce.Do(func() {
+		spec = Build(Routes)
+	})
+	return spec
+}
+
+// Build builds the OpenAPI document of routes
+func Build(routes []Route) *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:       "agent-svc API",
+			Description: "Command dispatch to node agents. Request and response bodies are the types of the agent-svc/client/dto package.",
+			Version:     client.Version,
+		},
+		Paths: map[string]*PathItem{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]*SecurityScheme{
+				AuthNode: {Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+					Description: "token issued to the node by POST /v1/agents/register"},
+				AuthOperator: {Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+					Description: "operator token issued with cmd/operator-token; it names the operator and their roles"},
+			},
+		},
+	}
+
+	gen := newSchemaGenerator(doc.Components.Schemas)
+	errorSchema := gen.schemaOf(reflect.TypeOf(dto.ErrorResponse{}))
+	for _, route := range routes {
+		path := OpenAPIPath(route.Path)
+		item, exists := doc.Paths[path]
+		if !exists {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(route.Method)] = buildOperation(gen, route, errorSchema)
+	}
+
+	// Payload schemas of the command types, which submissions are validated against by command type
+	for _, commandType := range CommandTypes() {
+		gen.schemaOf(reflect.TypeOf(dto.CommandRegistry[commandType]))
+	}
+	return doc
+}
+
+// buildOperation builds the operation of a route
+func buildOperation(gen *schemaGenerator, route Route, errorSchema *Schema) *Operation {
+	op := &Operation{
+		OperationID: operationID(route),
+		Summary:     route.Summary,
+		Responses:   map[string]*Response{},
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+
+	for _, name := range pathParams(route.Path) {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	if route.Scoped {
+		op.Parameters = append(op.Parameters, Parameter{Name: "X-Tenant-ID", In: "header",
+			Description: "tenant to act in; required for operators of several tenants", Schema: &Schema{Type: "string"}})
+	}
+	for _, p := range route.Params {
+		op.Parameters = append(op.Parameters, Parameter{Name: p.Name, In: p.In, Description: p.Description, Schema: &Schema{Type: p.Type}})
+	}
+
+	switch route.Auth {
+	case AuthNode:
+		op.Security = []map[string][]string{{AuthNode: {}}}
+	case AuthOperator, AuthAdmin:
+		op.Security = []map[string][]string{{AuthOperator: {}}}
+	}
+
+	if route.Request != nil {
+		bodyTypes := route.BodyTypes
+		if len(bodyTypes) == 0 {
+			bodyTypes = []string{"application/json"}
+		}
+		schema := gen.schemaOf(reflect.TypeOf(route.Request))
+		op.RequestBody = &RequestBody{Required: !route.OptionalBody, Content: map[string]MediaType{}}
+		for _, contentType := range bodyTypes {
+			op.RequestBody.Content[contentType] = MediaType{Schema: schema}
+		}
+	}
+
+	for _, reply := range route.Responses {
+		resp := &Response{Description: reply.Description}
+		if resp.Description == "" {
+			resp.Description = strings.ToLower(http.StatusText(reply.Status))
+		}
+		switch {
+		case reply.Body != nil:
+			resp.Content = map[string]MediaType{"application/json": {Schema: gen.schemaOf(reflect.TypeOf(reply.Body))}}
+		case reply.ContentType != "":
+			resp.Content = map[string]MediaType{reply.ContentType: {Schema: &Schema{Type: "string"}}}
+		}
+		op.Responses[strconv.Itoa(reply.Status)] = resp
+	}
+	op.Responses["default"] = &Response{
+		Description: "error",
+		Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+	}
+	return op
+}
+
+// CommandTypes returns the registered command types, sorted
+func CommandTypes() []string {
+	types := make([]string, 0, len(dto.CommandRegistry))
+	for commandType := range dto.CommandRegistry {
+		types = append(types, commandType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// OpenAPIPath converts a gin path to an OpenAPI path: /v1/commands/:command_id becomes /v1/commands/{command_id}
+func OpenAPIPath(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathParams returns the names of the parameters of a gin path
+func pathParams(ginPath string) []string {
+	var names []string
+	for _, segment := range strings.Split(ginPath, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			names = append(names, segment[1:])
+		}
+	}
+	return names
+}
+
+// operationID derives a unique operation ID from the method and path, e.g. post_v1_commands_command_id_cancel
+func operationID(route Route) string {
+	id := strings.ToLower(route.Method)
+	for _, segment := range strings.Split(route.Path, "/") {
+		segment = strings.Trim(segment, ":*")
+		if segment == "" {
+			continue
+		}
+		id += "_" + strings.NewReplacer("-", "_", ".", "_").Replace(segment)
+	}
+	return id
+}
+
+// Operation returns the operation of a route of the document, or nil if it has none
+// method is an HTTP method and ginPath the path as registered with gin.
+func (d *Document) Operation(method, ginPath string) *Operation {
+	item, ok := d.Paths[OpenAPIPath(ginPath)]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
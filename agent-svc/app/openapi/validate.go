@@ -0,0 +1,217 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidateBody validates a decoded JSON request body against the request schema of an operation and
+// returns the violations keyed by field path, such as chunks[0].stream; nil means the body is valid
+func (d *Document) ValidateBody(op *Operation, body interface{}) map[string]string {
+	if op.RequestBody == nil {
+		return nil
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return nil
+	}
+	errs := map[string]string{}
+	d.validate(media.Schema, body, "", errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// resolve follows a reference to a component schema
+func (d *Document) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// validate checks a value against a schema, adding violations to errs
+// A null value is accepted wherever it appears: it decodes to the zero value, which the handlers validate.
+func (d *Document) validate(s *Schema, value interface{}, path string, errs map[string]string) {
+	s = d.resolve(s)
+	if value == nil || s.omitEmpty && isEmpty(value) {
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			errs[fieldPath(path)] = "must be an object"
+			return
+		}
+		d.validateObject(s, obj, path, errs)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			errs[fieldPath(path)] = "must be an array"
+			return
+		}
+		if msg := checkCount(len(items), s.MinItems, s.MaxItems, "items"); msg != "" {
+			errs[fieldPath(path)] = msg
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			errs[fieldPath(path)] = "must be a string"
+			return
+		}
+		if msg := validateString(s, str); msg != "" {
+			errs[fieldPath(path)] = msg
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || s.Type == "integer" && n != math.Trunc(n) {
+			errs[fieldPath(path)] = "must be " + map[string]string{"integer": "an integer", "number": "a number"}[s.Type]
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			errs[fieldPath(path)] = "must be at least " + formatNumber(*s.Minimum)
+		} else if s.Maximum != nil && n > *s.Maximum {
+			errs[fieldPath(path)] = "must be at most " + formatNumber(*s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs[fieldPath(path)] = "must be a boolean"
+		}
+	}
+}
+
+// validateObject checks the properties of an object; properties the schema doesn't know are accepted
+func (d *Document) validateObject(s *Schema, obj map[string]interface{}, path string, errs map[string]string) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			errs[joinPath(path, name)] = "is required"
+		}
+	}
+	if msg := checkCount(len(obj), s.MinProperties, s.MaxProperties, "properties"); msg != "" {
+		errs[fieldPath(path)] = msg
+	}
+	for name, value := range obj {
+		if prop, ok := s.Properties[name]; ok {
+			d.validate(prop, value, joinPath(path, name), errs)
+		} else if s.AdditionalProperties != nil {
+			d.validate(s.AdditionalProperties, value, joinPath(path, name), errs)
+		}
+	}
+}
+
+// validateString checks a string against the string constraints of a schema, returning the violation
+func validateString(s *Schema, str string) string {
+	if msg := checkCount(utf8.RuneCountInString(str), s.MinLength, s.MaxLength, "characters"); msg != "" {
+		return msg
+	}
+	if len(s.Enum) > 0 && !contains(s.Enum, str) {
+		return "must be one of " + strings.Join(s.Enum, ", ")
+	}
+	if s.Pattern != "" {
+		re, err := compilePattern(s.Pattern)
+		if err != nil || !re.MatchString(str) {
+			return "must match " + s.Pattern
+		}
+	}
+	switch s.Format {
+	case "uri":
+		if u, err := url.ParseRequestURI(str); err != nil || u.Scheme == "" {
+			return "must be an absolute URL"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+	}
+	return ""
+}
+
+// checkCount checks a length or count against its bounds, returning the violation
+func checkCount(n int, min, max *int, unit string) string {
+	if min != nil && n < *min {
+		return fmt.Sprintf("must have at least %d %s", *min, unit)
+	}
+	if max != nil && n > *max {
+		return fmt.Sprintf("must have at most %d %s", *max, unit)
+	}
+	return ""
+}
+
+// isEmpty reports whether a decoded JSON value is the empty value the omitempty validate tag skips
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// patterns holds the compiled patterns of schemas, keyed by pattern
+var patterns sync.Map
+
+// compilePattern compiles a schema pattern once
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// joinPath appends a property name to a field path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldPath names the field at path; the body itself is reported as "body"
+func fieldPath(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
+
+// formatNumber formats a bound without a trailing .0
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
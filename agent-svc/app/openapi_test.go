@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"agent-svc/app/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registeredRoutes returns the routes setupRoutes registers, without building any service
+// Handlers are only referenced, never called, so none are needed.
+func registeredRoutes() gin.RoutesInfo {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	setupRoutes(router, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, 0)
+	return router.Routes()
+}
+
+func TestSpecCoversRegisteredRoutes(t *testing.T) {
+	spec := openapi.Spec()
+	for _, r := range registeredRoutes() {
+		if spec.Operation(r.Method, r.Path) == nil {
+			t.Errorf("%s %s is registered but has no entry in openapi.Routes", r.Method, r.Path)
+		}
+	}
+}
+
+func TestSpecRoutesAreRegistered(t *testing.T) {
+	registered := map[string]bool{}
+	for _, r := range registeredRoutes() {
+		registered[r.Method+" "+r.Path] = true
+	}
+
+	spec := openapi.Spec()
+	operationIDs := map[string]string{}
+	for _, route := range openapi.Routes {
+		key := route.Method + " " + route.Path
+		if !registered[key] {
+			t.Errorf("%s is in openapi.Routes but not registered", key)
+		}
+		op := spec.Operation(route.Method, route.Path)
+		if other, ok := operationIDs[op.OperationID]; ok && other != key {
+			t.Errorf("%s and %s share operation ID %s", other, key, op.OperationID)
+		}
+		operationIDs[op.OperationID] = key
+	}
+}
+
+func TestSpecHasNoDanglingRefs(t *testing.T) {
+	spec := openapi.Spec()
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("failed to encode the document: %v", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("failed to decode the document: %v", err)
+	}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, exists := spec.Components.Schemas[name]; !exists {
+					t.Errorf("schema reference %s has no component", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
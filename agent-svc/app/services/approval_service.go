@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+
+	"github.com/google/uuid"
+)
+
+// ApprovalService manages the approval policies CommandService checks submissions against
+type ApprovalService struct {
+	storage clients.StorageAdapter
+}
+
+// NewApprovalService creates a new approval service
+func NewApprovalService(storage clients.StorageAdapter) *ApprovalService {
+	return &ApprovalService{
+		storage: storage,
+	}
+}
+
+// CreatePolicy validates and stores an approval policy
+func (s *ApprovalService) CreatePolicy(ctx context.Context, p *domains.ApprovalPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.CommandType != "" && !utils.IsKnownCommandType(p.CommandType) {
+		return fmt.Errorf("%w: unknown command type: %s", domains.ErrInvalidApprovalPolicy, p.CommandType)
+	}
+
+	if err := s.storage.CreateApprovalPolicy(ctx, p); err != nil {
+		return fmt.Errorf("failed to create approval policy: %w", err)
+	}
+	return nil
+}
+
+// GetPolicy retrieves an approval policy, or nil if it doesn't exist
+func (s *ApprovalService) GetPolicy(ctx context.Context, policyID uuid.UUID) (*domains.ApprovalPolicy, error) {
+	return s.storage.GetApprovalPolicy(ctx, policyID)
+}
+
+// ListPolicies retrieves all approval policies
+func (s *ApprovalService) ListPolicies(ctx context.Context) ([]*domains.ApprovalPolicy, error) {
+	return s.storage.ListApprovalPolicies(ctx)
+}
+
+// DeletePolicy deletes an approval policy, reporting whether it existed
+// Commands already pending approval under the policy still need a decision.
+func (s *ApprovalService) DeletePolicy(ctx context.Context, policyID uuid.UUID) (bool, error) {
+	return s.storage.DeleteApprovalPolicy(ctx, policyID)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrCommandPolicyFromFile is returned when changing the command policy through the API while it is loaded from a file
+var ErrCommandPolicyFromFile = errors.New("the command policy is loaded from a file and can't be changed through the API")
+
+// commandPolicyNamespace derives the IDs of policies loaded from a file from their content
+var commandPolicyNamespace = uuid.MustParse("3b4f1f0e-5d2a-4c8e-9a51-7c0f2e6d8b13")
+
+// CommandPolicyService holds the command policy CommandService evaluates submissions against
+// The policy is loaded from a file when one is configured and from storage otherwise; Reload picks up
+// changes to either, so every replica follows a change made through another one.
+type CommandPolicyService struct {
+	storage clients.StorageAdapter
+	file    string
+
+	mu       sync.RWMutex
+	current  *domains.CommandPolicy
+	fileHash [sha256.Size]byte
+}
+
+// NewCommandPolicyService creates a command policy service that allows every command until Reload loads a policy
+// file is the path of a YAML or JSON policy file; empty keeps the policy in storage, managed through the API.
+func NewCommandPolicyService(storage clients.StorageAdapter, file string) *CommandPolicyService {
+	return &CommandPolicyService{
+		storage: storage,
+		file:    file,
+		current: &domains.CommandPolicy{DefaultAction: domains.PolicyAllow, Source: domains.PolicySourceNone},
+	}
+}
+
+// ParseCommandPolicy parses and validates a policy document in YAML or JSON
+// Unknown fields are rejected, so a misspelt criterion can't turn a rule into one matching every command.
+func ParseCommandPolicy(data []byte) (*domains.CommandPolicy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var p domains.CommandPolicy
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%w: %v", domains.ErrInvalidCommandPolicy, err)
+	}
+	if err := validateCommandPolicy(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// validateCommandPolicy validates a policy and checks that its rules name known command types
+func validateCommandPolicy(p *domains.CommandPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	for _, rule := range p.Rules {
+		for _, commandType := range rule.CommandTypes {
+			if !utils.IsKnownCommandType(commandType) {
+				return fmt.Errorf("%w: rule %q: unknown command type: %s", domains.ErrInvalidCommandPolicy, rule.Name, commandType)
+			}
+		}
+	}
+	return nil
+}
+
+// Policy returns the policy in effect
+func (s *CommandPolicyService) Policy() *domains.CommandPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// ReadOnly reports whether the policy is loaded from a file rather than managed through the API
+func (s *CommandPolicyService) ReadOnly() bool {
+	return s.file != ""
+}
+
+// Evaluate decides on a submission under the policy in effect, returning the decision and the policy
+func (s *CommandPolicyService) Evaluate(in domains.PolicyInput) (domains.PolicyDecision, *domains.CommandPolicy) {
+	p := s.Policy()
+	return p.Evaluate(in), p
+}
+
+// Reload loads the policy again and reports whether it changed
+// A policy that fails to load or validate leaves the one in effect in place.
+func (s *CommandPolicyService) Reload(ctx context.Context) (bool, error) {
+	if s.file != "" {
+		return s.reloadFile()
+	}
+
+	p, err := s.storage.GetCommandPolicy(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get command policy: %w", err)
+	}
+	if p == nil || p.PolicyID == s.Policy().PolicyID {
+		return false, nil
+	}
+	if err := p.Validate(); err != nil {
+		return false, fmt.Errorf("stored command policy version %d: %w", p.Version, err)
+	}
+	p.Source = domains.PolicySourceAPI
+	s.set(p)
+	return true, nil
+}
+
+// reloadFile loads the policy file if its content changed
+func (s *CommandPolicyService) reloadFile() (bool, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read command policy file: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	s.mu.RLock()
+	unchanged := hash == s.fileHash
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	p, err := ParseCommandPolicy(data)
+	if err != nil {
+		return false, fmt.Errorf("command policy file %s: %w", s.file, err)
+	}
+	p.PolicyID = uuid.NewSHA1(commandPolicyNamespace, data)
+	p.Source, p.CreatedBy, p.CreatedAt = domains.PolicySourceFile, s.file, time.Now()
+
+	s.mu.Lock()
+	s.current, s.fileHash = p, hash
+	s.mu.Unlock()
+	return true, nil
+}
+
+// UpdatePolicy validates a policy, stores it as the next version and puts it into effect
+func (s *CommandPolicyService) UpdatePolicy(ctx context.Context, p *domains.CommandPolicy, operatorID string) error {
+	if s.ReadOnly() {
+		return ErrCommandPolicyFromFile
+	}
+	if err := validateCommandPolicy(p); err != nil {
+		return err
+	}
+
+	p.CreatedBy = operatorID
+	if err := s.storage.CreateCommandPolicy(ctx, p); err != nil {
+		return fmt.Errorf("failed to store command policy: %w", err)
+	}
+	p.Source = domains.PolicySourceAPI
+	s.set(p)
+	return nil
+}
+
+// set puts a policy into effect unless a later version already is
+func (s *CommandPolicyService) set(p *domains.CommandPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current.Source == domains.PolicySourceAPI && s.current.Version > p.Version {
+		return
+	}
+	s.current = p
+}
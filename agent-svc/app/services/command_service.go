@@ -2,59 +2,407 @@ package services
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"agent-svc/app/clients"
 	"agent-svc/app/domains"
+	"agent-svc/app/metrics"
+	"agent-svc/app/tracing"
 	"agent-svc/app/utils"
+	"agent-svc/client"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// CommandServiceConfig holds the tunables of CommandService
+type CommandServiceConfig struct {
+	// DefaultMaxOutputBytes and MaxOutputBytes bound RunCommand output; zero disables the respective limit
+	DefaultMaxOutputBytes int64
+	MaxOutputBytes        int64
+	// IdempotencyKeyTTL is how long a submission idempotency key is remembered
+	IdempotencyKeyTTL time.Duration
+	// SigningKey signs dispatched commands, which nodes accept for SignatureTTL; nil leaves them unsigned
+	SigningKey   ed25519.PrivateKey
+	SignatureTTL time.Duration
+}
+
+// CommandFinishedHook is called after an agent reports a terminal status for a command or an operator cancels it
+type CommandFinishedHook func(ctx context.Context, cmd *domains.NodeCommand)
+
 // CommandService handles command operations
 type CommandService struct {
-	storage clients.StorageAdapter
+	storage       clients.StorageAdapter
+	policies      *CommandPolicyService
+	config        CommandServiceConfig
+	finishedHooks []CommandFinishedHook
 }
 
-// NewCommandService creates a new command service
-func NewCommandService(storage clients.StorageAdapter) *CommandService {
-	return &CommandService{storage: storage}
+// NewCommandService creates a new command service that checks submissions against the policy of policies
+func NewCommandService(storage clients.StorageAdapter, policies *CommandPolicyService, config CommandServiceConfig) *CommandService {
+	return &CommandService{
+		storage:  storage,
+		policies: policies,
+		config:   config,
+	}
 }
 
 // SubmitCommand submits a command to a single node (one-to-one)
-func (s *CommandService) SubmitCommand(ctx context.Context, commandType string, nodeID string, payload map[string]interface{}) (uuid.UUID, error) {
+// If opts carries an idempotency key that was already used for the same request, the original
+// command ID is returned with replayed set; reuse with a different request returns
+// domains.ErrIdempotencyKeyMismatch.
+// The submission is traced in a command.submit span whose context is stored on the command, so the
+// dispatch and the node's execution join the same trace.
+// A node outside opts.TenantID is reported as not found. A submission the command policy denies returns
+// domains.ErrCommandDenied; one it requires approval for, or matching an approval policy, is created pending
+// approval, and returns domains.ErrRequesterRequired if opts names no operator.
+func (s *CommandService) SubmitCommand(ctx context.Context, commandType string, nodeID string, payload map[string]interface{}, opts domains.CommandOptions) (commandID uuid.UUID, replayed bool, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "command.submit", trace.WithAttributes(
+		attribute.String("command.type", commandType),
+		attribute.String("node.id", nodeID),
+	))
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		} else {
+			span.SetAttributes(attribute.String("command.id", commandID.String()), attribute.Bool("command.replayed", replayed))
+		}
+		span.End()
+	}()
+
+	if opts.IdempotencyKey != "" {
+		// Hash the request as submitted, before defaults are filled into the payload
+		opts.RequestHash, err = requestHash(commandType, nodeID, payload, opts)
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+		opts.IdempotencyExpiresAt = time.Now().Add(s.config.IdempotencyKeyTTL)
+
+		commandID, found, err := s.lookupIdempotencyKey(ctx, nodeID, opts)
+		if err != nil || found {
+			return commandID, found, err
+		}
+	}
+
 	// Validate payload against command type
 	if err := utils.ValidateCommandPayload(commandType, payload); err != nil {
-		return uuid.Nil, fmt.Errorf("payload validation failed: %w", err)
+		return uuid.Nil, false, fmt.Errorf("payload validation failed: %w", err)
+	}
+
+	if commandType == "RunCommand" {
+		if err := s.applyOutputLimit(payload); err != nil {
+			return uuid.Nil, false, fmt.Errorf("payload validation failed: %w", err)
+		}
+	}
+
+	if err := resolveExpiry(&opts, time.Now()); err != nil {
+		return uuid.Nil, false, err
+	}
+
+	if opts.Priority != nil && (*opts.Priority < domains.PriorityMin || *opts.Priority > domains.PriorityMax) {
+		return uuid.Nil, false, fmt.Errorf("priority must be between %d and %d", domains.PriorityMin, domains.PriorityMax)
+	}
+
+	if opts.RetryPolicy != nil {
+		policy := *opts.RetryPolicy
+		policy.ApplyDefaults()
+		opts.RetryPolicy = &policy
 	}
 
 	// Verify node exists
 	node, err := s.storage.GetNode(ctx, nodeID)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("failed to get node %s: %w", nodeID, err)
+		return uuid.Nil, false, fmt.Errorf("failed to get node %s: %w", nodeID, err)
 	}
-	if node == nil {
-		return uuid.Nil, fmt.Errorf("node %s not found", nodeID)
+	if node == nil || (opts.TenantID != "" && node.TenantID != opts.TenantID) {
+		return uuid.Nil, false, fmt.Errorf("node %s not found", nodeID)
 	}
 	if node.Disabled {
-		return uuid.Nil, fmt.Errorf("node %s is disabled", nodeID)
+		return uuid.Nil, false, fmt.Errorf("node %s is disabled", nodeID)
+	}
+
+	if err := s.checkPolicy(commandType, payload, node, &opts); err != nil {
+		return uuid.Nil, false, err
+	}
+	if opts.Approval == nil {
+		policy, err := s.approvalPolicy(ctx, commandType, payload, node)
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+		if policy != nil {
+			opts.Approval = &domains.ApprovalRequest{
+				PolicyID:   policy.PolicyID,
+				PolicyName: policy.Name,
+				ExpiresAt:  time.Now().Add(time.Duration(policy.TimeoutSec) * time.Second),
+			}
+		}
+	}
+	if opts.Approval != nil && opts.OperatorID == "" {
+		return uuid.Nil, false, domains.ErrRequesterRequired
+	}
+
+	opts.TraceContext = tracing.Inject(ctx)
+	commandID, err = s.storage.CreateCommand(ctx, nodeID, commandType, payload, opts)
+	if errors.Is(err, domains.ErrDuplicateIdempotencyKey) {
+		// A concurrent request with the same key created the command first
+		commandID, found, err := s.lookupIdempotencyKey(ctx, nodeID, opts)
+		if err == nil && !found {
+			err = fmt.Errorf("idempotency key %q is in use, retry the request", opts.IdempotencyKey)
+		}
+		return commandID, found, err
+	}
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to create command for node %s: %w", nodeID, err)
 	}
 
-	commandID, err := s.storage.CreateCommand(ctx, nodeID, commandType, payload)
+	return commandID, false, nil
+}
+
+// checkPolicy evaluates a submission against the command policy, returning domains.ErrCommandDenied if it is
+// denied and setting opts.Approval if it requires approval
+func (s *CommandService) checkPolicy(commandType string, payload map[string]interface{}, node *domains.Node, opts *domains.CommandOptions) error {
+	decision, policy := s.policies.Evaluate(domains.PolicyInput{
+		CommandType:   commandType,
+		Payload:       payload,
+		NodeLabels:    node.Labels,
+		OperatorRoles: opts.OperatorRoles,
+		Time:          time.Now(),
+	})
+	metrics.PolicyDecisions.WithLabelValues(decision.Action, decision.Rule).Inc()
+
+	switch decision.Action {
+	case domains.PolicyDeny:
+		if decision.Rule == "" && decision.Reason != "" {
+			return fmt.Errorf("%w: %s", domains.ErrCommandDenied, decision.Reason)
+		}
+		if decision.Rule == "" {
+			return fmt.Errorf("%w: no rule allows it", domains.ErrCommandDenied)
+		}
+		if decision.Reason != "" {
+			return fmt.Errorf("%w: rule %q: %s", domains.ErrCommandDenied, decision.Rule, decision.Reason)
+		}
+		return fmt.Errorf("%w: rule %q", domains.ErrCommandDenied, decision.Rule)
+	case domains.PolicyRequireApproval:
+		opts.Approval = &domains.ApprovalRequest{
+			PolicyID:   policy.PolicyID,
+			PolicyName: "command policy rule " + decision.Rule,
+			ExpiresAt:  time.Now().Add(policy.Rules[decision.RuleIndex].ApprovalTimeout()),
+		}
+	}
+	return nil
+}
+
+// approvalPolicy returns the oldest approval policy a submission matches, or nil if it needs no approval
+func (s *CommandService) approvalPolicy(ctx context.Context, commandType string, payload map[string]interface{}, node *domains.Node) (*domains.ApprovalPolicy, error) {
+	policies, err := s.storage.ListApprovalPolicies(ctx)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("failed to create command for node %s: %w", nodeID, err)
+		return nil, fmt.Errorf("failed to list approval policies: %w", err)
 	}
+	for _, p := range policies {
+		if p.Matches(commandType, payload, node) {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// ResolveTargets returns the IDs of the nodes of a tenant a target refers to
+// A selector resolves to the enabled nodes of the tenant whose labels or attrs match it; a node ID must name
+// a node of the tenant. It returns domains.ErrTenantRequired if tenantID is empty, so a target never spans
+// tenants.
+func (s *CommandService) ResolveTargets(ctx context.Context, tenantID string, target domains.CommandTarget) ([]string, error) {
+	if tenantID == "" {
+		return nil, domains.ErrTenantRequired
+	}
+
+	if target.NodeID != "" {
+		node, err := s.storage.GetNode(ctx, target.NodeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %w", target.NodeID, err)
+		}
+		if node == nil || node.TenantID != tenantID {
+			return nil, fmt.Errorf("node %s not found", target.NodeID)
+		}
+		return []string{target.NodeID}, nil
+	}
+
+	nodes, err := s.storage.ListNodes(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var nodeIDs []string
+	for _, node := range nodes {
+		if !node.Disabled && target.Selector.MatchesNode(&node) {
+			nodeIDs = append(nodeIDs, node.NodeID)
+		}
+	}
+	return nodeIDs, nil
+}
 
-	return commandID, nil
+// OnCommandFinished registers a hook called after a command reaches a terminal status
+// Hooks must be registered before the service handles requests.
+func (s *CommandService) OnCommandFinished(hook CommandFinishedHook) {
+	s.finishedHooks = append(s.finishedHooks, hook)
 }
 
-// GetNextCommand retrieves up to 5 queued commands for a node
+// lookupIdempotencyKey returns the command created for an unexpired idempotency key
+func (s *CommandService) lookupIdempotencyKey(ctx context.Context, nodeID string, opts domains.CommandOptions) (uuid.UUID, bool, error) {
+	key, err := s.storage.GetIdempotencyKey(ctx, opts.OperatorID, nodeID, opts.IdempotencyKey)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	if key == nil {
+		return uuid.Nil, false, nil
+	}
+	if key.RequestHash != opts.RequestHash {
+		return uuid.Nil, false, domains.ErrIdempotencyKeyMismatch
+	}
+	return key.CommandID, true, nil
+}
+
+// requestHash returns a stable hash of a submission, used to tell retries from key reuse
+func requestHash(commandType, nodeID string, payload map[string]interface{}, opts domains.CommandOptions) (string, error) {
+	// encoding/json sorts map keys, so equal payloads marshal identically
+	data, err := json.Marshal(struct {
+		CommandType      string                 `json:"command_type"`
+		NodeID           string                 `json:"node_id"`
+		Payload          map[string]interface{} `json:"payload"`
+		ExpiresAt        *time.Time             `json:"expires_at"`
+		DeliverWithinSec int                    `json:"deliver_within_sec"`
+		Priority         *int                   `json:"priority"`
+		RetryPolicy      *domains.RetryPolicy   `json:"retry_policy"`
+	}{commandType, nodeID, payload, opts.ExpiresAt, opts.DeliverWithinSec, opts.Priority, opts.RetryPolicy})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash request: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// resolveExpiry turns deliver_within_sec into an absolute expires_at and rejects deadlines in the past
+func resolveExpiry(opts *domains.CommandOptions, now time.Time) error {
+	if opts.DeliverWithinSec > 0 {
+		if opts.ExpiresAt != nil {
+			return fmt.Errorf("only one of expires_at and deliver_within_sec may be set")
+		}
+		expiresAt := now.Add(time.Duration(opts.DeliverWithinSec) * time.Second)
+		opts.ExpiresAt = &expiresAt
+	}
+
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// ExpireQueuedCommands moves queued commands past their deadline to expired
+func (s *CommandService) ExpireQueuedCommands(ctx context.Context) (int, error) {
+	return s.storage.ExpireQueuedCommands(ctx)
+}
+
+// applyOutputLimit fills in the default max_output_bytes and rejects values above the server maximum
+// A max_output_bytes of 0 or less gets the default too: node-agent leaves output unbounded without a positive
+// limit, so it must never be forwarded.
+func (s *CommandService) applyOutputLimit(payload map[string]interface{}) error {
+	requested, ok := payload["max_output_bytes"].(float64)
+	if !ok || requested <= 0 {
+		delete(payload, "max_output_bytes")
+		if s.config.DefaultMaxOutputBytes > 0 {
+			payload["max_output_bytes"] = s.config.DefaultMaxOutputBytes
+		}
+		return nil
+	}
+
+	if s.config.MaxOutputBytes > 0 && int64(requested) > s.config.MaxOutputBytes {
+		return fmt.Errorf("max_output_bytes %d exceeds server maximum of %d", int64(requested), s.config.MaxOutputBytes)
+	}
+	return nil
+}
+
+// GetNextCommand retrieves up to 5 queued commands for a node, signed for it when a signing key is configured
+// The time each traced command waited in the queue is recorded as a command.queued span in its trace.
 func (s *CommandService) GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error) {
-	return s.storage.GetNextCommand(ctx, nodeID)
+	cmds, err := s.storage.GetNextCommand(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range cmds {
+		if cmd.DispatchedAt == nil {
+			continue
+		}
+		metrics.CommandDispatchLatency.WithLabelValues(cmd.CommandType).Observe(cmd.DispatchedAt.Sub(cmd.CreatedAt).Seconds())
+		if cmd.TraceContext != nil {
+			tracing.RecordSpan(tracing.Extract(ctx, cmd.TraceContext), "command.queued", cmd.CreatedAt, *cmd.DispatchedAt,
+				attribute.String("command.id", cmd.CommandID.String()),
+				attribute.String("node.id", nodeID),
+				attribute.Int("command.attempt", cmd.Attempt),
+			)
+		}
+	}
+
+	if s.config.SigningKey != nil {
+		now := time.Now()
+		for _, cmd := range cmds {
+			if err := s.signCommand(cmd, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cmds, nil
+}
+
+// signCommand signs a command dispatched to its node at now
+func (s *CommandService) signCommand(cmd *domains.NodeCommand, now time.Time) error {
+	signed := client.SignedCommand{
+		CommandID:          cmd.CommandID.String(),
+		NodeID:             cmd.NodeID,
+		CommandType:        cmd.CommandType,
+		Payload:            cmd.Payload,
+		ExpiresAt:          cmd.ExpiresAt,
+		SignatureExpiresAt: now.Add(s.config.SignatureTTL).Truncate(time.Second),
+	}
+	signature, err := signed.Sign(s.config.SigningKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign command %s: %w", cmd.CommandID, err)
+	}
+	cmd.Signature, cmd.SignatureExpiresAt = signature, signed.SignatureExpiresAt
+	return nil
+}
+
+// SigningKey returns the public key dispatched commands are signed with, nil if they aren't
+func (s *CommandService) SigningKey() ed25519.PublicKey {
+	if s.config.SigningKey == nil {
+		return nil
+	}
+	return s.config.SigningKey.Public().(ed25519.PublicKey)
+}
+
+// ParseCommandSigningKey parses the base64 Ed25519 seed commands are signed with
+func ParseCommandSigningKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid command signing key: %d bytes, want a %d byte seed", len(seed), ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
 }
 
 // UpdateCommandStatus updates the status of a command
-func (s *CommandService) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, nodeID string, status string, exitCode *int, errorMsg *string) error {
+// output is optional and records the output statistics reported by the node
+func (s *CommandService) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, nodeID string, status string, exitCode *int, errorMsg *string, output *domains.CommandOutput) error {
 	// Verify command belongs to node
 	cmd, err := s.storage.GetCommandByID(ctx, commandID)
 	if err != nil {
@@ -67,10 +415,244 @@ func (s *CommandService) UpdateCommandStatus(ctx context.Context, commandID uuid
 		return fmt.Errorf("command does not belong to node")
 	}
 
-	return s.storage.UpdateCommandStatus(ctx, commandID, status, exitCode, errorMsg)
+	// A node never receives a command pending approval, and only an approver may queue it
+	if cmd.Status == domains.StatusPendingApproval {
+		return fmt.Errorf("%w: command is pending approval", domains.ErrInvalidStatusTransition)
+	}
+
+	// Reject invalid transitions early; the store re-checks under a row lock
+	if err := domains.ValidateStatusTransition(cmd.Status, status); err != nil {
+		return err
+	}
+
+	if err := s.storage.UpdateCommandStatus(ctx, commandID, status, exitCode, errorMsg, domains.SourceAgent); err != nil {
+		return err
+	}
+
+	if output != nil {
+		if err := s.storage.UpdateCommandOutput(ctx, commandID, *output); err != nil {
+			return fmt.Errorf("failed to record command output: %w", err)
+		}
+	}
+
+	if !domains.IsTerminalStatus(status) {
+		return nil
+	}
+	return s.commandFinished(ctx, cmd, status, exitCode)
+}
+
+// commandFinished records the run duration of a dispatched command that reached a terminal status, schedules
+// its retry if its retry policy asks for one, and calls the finished hooks
+func (s *CommandService) commandFinished(ctx context.Context, cmd *domains.NodeCommand, status string, exitCode *int) error {
+	if cmd.DispatchedAt != nil {
+		metrics.CommandRunDuration.WithLabelValues(cmd.CommandType, status).Observe(time.Since(*cmd.DispatchedAt).Seconds())
+	}
+
+	cmd.Status = status
+	if cmd.RetryPolicy != nil && cmd.RetryPolicy.ShouldRetry(cmd.Attempt, status, exitCode) {
+		retryAt := time.Now().Add(cmd.RetryPolicy.Backoff(cmd.Attempt))
+		if err := s.storage.ScheduleCommandRetry(ctx, cmd.CommandID, retryAt); err != nil {
+			return fmt.Errorf("failed to schedule retry: %w", err)
+		}
+		cmd.NextRetryAt = &retryAt
+	}
+
+	for _, hook := range s.finishedHooks {
+		hook(ctx, cmd)
+	}
+
+	return nil
+}
+
+// MarkLostCommands finishes running commands whose node sent no heartbeat for lostAfter as lost
+// A lost command is retried and reported to the finished hooks like one the node reported finished; a node
+// that comes back can no longer report its status.
+func (s *CommandService) MarkLostCommands(ctx context.Context, lostAfter time.Duration) (int, error) {
+	lost, err := s.storage.ListLostCommands(ctx, lostAfter, 100)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list lost commands: %w", err)
+	}
+
+	count := 0
+	errorMsg := fmt.Sprintf("node sent no heartbeat for %s", lostAfter)
+	for _, cmd := range lost {
+		err := s.storage.UpdateCommandStatus(ctx, cmd.CommandID, domains.StatusLost, nil, &errorMsg, domains.SourceSystem)
+		if errors.Is(err, domains.ErrInvalidStatusTransition) {
+			// The node reported the command finished since it was listed
+			continue
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to mark command %s lost: %w", cmd.CommandID, err)
+		}
+		if err := s.commandFinished(ctx, cmd, domains.StatusLost, nil); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CancelCommand cancels a queued or pending command on behalf of an operator, returning the cancelled command
+// or nil if it doesn't exist or its node is outside a non-empty tenantID
+// Commands already dispatched to their node can't be recalled and return domains.ErrCommandNotCancellable.
+func (s *CommandService) CancelCommand(ctx context.Context, tenantID string, commandID uuid.UUID, operatorID string) (*domains.NodeCommand, error) {
+	cmd, err := s.getTenantCommand(ctx, tenantID, commandID)
+	if err != nil || cmd == nil {
+		return nil, err
+	}
+	if cmd.Status != domains.StatusQueued && cmd.Status != domains.StatusPendingApproval {
+		return nil, fmt.Errorf("%w: command is %s", domains.ErrCommandNotCancellable, cmd.Status)
+	}
+
+	errorMsg := "cancelled by operator"
+	if operatorID != "" {
+		errorMsg += " " + operatorID
+	}
+	if err := s.storage.UpdateCommandStatus(ctx, commandID, domains.StatusCancelled, nil, &errorMsg, domains.SourceOperator); err != nil {
+		return nil, err
+	}
+
+	cmd, err = s.storage.GetCommandByID(ctx, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	for _, hook := range s.finishedHooks {
+		hook(ctx, cmd)
+	}
+	return cmd, nil
+}
+
+// DecideApproval approves or rejects a command pending approval on behalf of an operator, returning the
+// command after the decision, or nil if it doesn't exist or its node is outside a non-empty tenantID
+// An approved command is queued for dispatch and a rejected one finishes as rejected. The operator, taken from
+// the caller's operator token, must differ from the one who submitted the command, which every approval
+// request names.
+func (s *CommandService) DecideApproval(ctx context.Context, tenantID string, commandID uuid.UUID, approve bool, operatorID string, comment *string) (*domains.NodeCommand, error) {
+	cmd, err := s.getTenantCommand(ctx, tenantID, commandID)
+	if err != nil || cmd == nil {
+		return nil, err
+	}
+
+	approval, err := s.storage.GetCommandApproval(ctx, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approval request: %w", err)
+	}
+	if approval == nil || cmd.Status != domains.StatusPendingApproval {
+		return nil, fmt.Errorf("%w: command is %s", domains.ErrApprovalNotPending, cmd.Status)
+	}
+	if operatorID == "" {
+		return nil, domains.ErrApproverRequired
+	}
+	if approval.RequestedBy == "" || operatorID == approval.RequestedBy {
+		return nil, domains.ErrSelfApproval
+	}
+
+	decision := domains.ApprovalRejected
+	if approve {
+		decision = domains.ApprovalApproved
+	}
+	decided, err := s.storage.DecideCommandApproval(ctx, commandID, decision, operatorID, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record approval decision: %w", err)
+	}
+	if !decided {
+		// Decided, cancelled or expired since it was read
+		return nil, domains.ErrApprovalNotPending
+	}
+
+	cmd, err = s.storage.GetCommandByID(ctx, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	if !approve {
+		for _, hook := range s.finishedHooks {
+			hook(ctx, cmd)
+		}
+	}
+	return cmd, nil
+}
+
+// GetCommandApproval retrieves the approval request of a command, or nil if it has none or its node is outside
+// a non-empty tenantID
+func (s *CommandService) GetCommandApproval(ctx context.Context, tenantID string, commandID uuid.UUID) (*domains.CommandApproval, error) {
+	cmd, err := s.getTenantCommand(ctx, tenantID, commandID)
+	if err != nil || cmd == nil {
+		return nil, err
+	}
+	return s.storage.GetCommandApproval(ctx, commandID)
+}
+
+// ListPendingApprovals lists the approval requests waiting for a decision, oldest first; a non-empty tenantID
+// limits the list to the nodes of that tenant
+func (s *CommandService) ListPendingApprovals(ctx context.Context, tenantID string, limit int) ([]*domains.CommandApproval, error) {
+	return s.storage.ListPendingApprovals(ctx, tenantID, limit)
+}
+
+// ExpireApprovalRequests moves commands whose approval request nobody decided on in time to expired
+func (s *CommandService) ExpireApprovalRequests(ctx context.Context) (int, error) {
+	return s.storage.ExpireCommandApprovals(ctx)
+}
+
+// CreateRetryAttempts queues the next attempt of commands whose retry is due
+func (s *CommandService) CreateRetryAttempts(ctx context.Context) (int, error) {
+	return s.storage.CreateRetryAttempts(ctx, 100)
+}
+
+// GetCommandAttempts retrieves a command and all attempts of it, ordered by attempt
+// A command whose node is outside a non-empty tenantID is treated as missing.
+func (s *CommandService) GetCommandAttempts(ctx context.Context, tenantID string, commandID uuid.UUID) (*domains.NodeCommand, []*domains.NodeCommand, error) {
+	cmd, err := s.getTenantCommand(ctx, tenantID, commandID)
+	if err != nil || cmd == nil {
+		return nil, nil, err
+	}
+
+	attempts, err := s.storage.GetCommandAttempts(ctx, cmd.FirstAttemptID())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get command attempts: %w", err)
+	}
+	return cmd, attempts, nil
+}
+
+// GetCommandStatusHistory retrieves the recorded status transitions of a command
+// A command whose node is outside a non-empty tenantID has no history.
+func (s *CommandService) GetCommandStatusHistory(ctx context.Context, tenantID string, commandID uuid.UUID) ([]domains.CommandStatusChange, error) {
+	if tenantID != "" {
+		cmd, err := s.getTenantCommand(ctx, tenantID, commandID)
+		if err != nil || cmd == nil {
+			return nil, err
+		}
+	}
+	return s.storage.GetCommandStatusHistory(ctx, commandID)
+}
+
+// getTenantCommand retrieves a command, or nil if it doesn't exist or its node is outside a non-empty tenantID
+func (s *CommandService) getTenantCommand(ctx context.Context, tenantID string, commandID uuid.UUID) (*domains.NodeCommand, error) {
+	cmd, err := s.storage.GetCommandByID(ctx, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	if cmd == nil || tenantID == "" {
+		return cmd, nil
+	}
+
+	node, err := s.storage.GetNode(ctx, cmd.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", cmd.NodeID, err)
+	}
+	if node == nil || node.TenantID != tenantID {
+		return nil, nil
+	}
+	return cmd, nil
+}
+
+// ListCommands lists the most recent commands, newest first, optionally filtered by nodeID; a non-empty
+// tenantID limits the list to the nodes of that tenant
+func (s *CommandService) ListCommands(ctx context.Context, tenantID string, nodeID *string, limit int) ([]domains.NodeCommand, error) {
+	return s.storage.ListCommands(ctx, tenantID, nodeID, limit)
 }
 
-// DeleteQueuedCommands deletes all queued commands, optionally filtered by nodeID
-func (s *CommandService) DeleteQueuedCommands(ctx context.Context, nodeID *string) (int, error) {
-	return s.storage.DeleteQueuedCommands(ctx, nodeID)
+// DeleteQueuedCommands deletes all queued commands, optionally filtered by nodeID; a non-empty tenantID
+// limits the deletion to the nodes of that tenant
+func (s *CommandService) DeleteQueuedCommands(ctx context.Context, tenantID string, nodeID *string) (int, error) {
+	return s.storage.DeleteQueuedCommands(ctx, tenantID, nodeID)
 }
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/client"
+	"agent-svc/storage/memory"
+)
+
+func TestApplyOutputLimit(t *testing.T) {
+	s := NewCommandService(nil, nil, CommandServiceConfig{DefaultMaxOutputBytes: 1024, MaxOutputBytes: 4096})
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "missing gets the default", payload: map[string]interface{}{}, want: int64(1024)},
+		{name: "zero gets the default", payload: map[string]interface{}{"max_output_bytes": 0.0}, want: int64(1024)},
+		{name: "negative gets the default", payload: map[string]interface{}{"max_output_bytes": -1.0}, want: int64(1024)},
+		{name: "within the maximum is kept", payload: map[string]interface{}{"max_output_bytes": 2048.0}, want: 2048.0},
+		{name: "above the maximum is rejected", payload: map[string]interface{}{"max_output_bytes": 8192.0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.applyOutputLimit(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyOutputLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.payload["max_output_bytes"] != tt.want {
+				t.Errorf("max_output_bytes = %#v, want %#v", tt.payload["max_output_bytes"], tt.want)
+			}
+		})
+	}
+
+	// Without a default, a zero limit is dropped rather than forwarded
+	s = NewCommandService(nil, nil, CommandServiceConfig{})
+	payload := map[string]interface{}{"max_output_bytes": 0.0}
+	if err := s.applyOutputLimit(payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := payload["max_output_bytes"]; ok {
+		t.Errorf("max_output_bytes = %v, want it removed", payload["max_output_bytes"])
+	}
+}
+
+func TestApprovalNeedsTwoIdentifiedOperators(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	s := NewCommandService(store, NewCommandPolicyService(store, ""), CommandServiceConfig{MaxOutputBytes: 4096})
+	if err := store.RegisterNode(ctx, domains.DefaultTenantID, "node-1", nil); err != nil {
+		t.Fatalf("RegisterNode: %v", err)
+	}
+	if err := store.CreateApprovalPolicy(ctx, &domains.ApprovalPolicy{Name: "everything", TimeoutSec: 60}); err != nil {
+		t.Fatalf("CreateApprovalPolicy: %v", err)
+	}
+	payload := func() map[string]interface{} { return map[string]interface{}{"cmd": "true"} }
+
+	_, _, err := s.SubmitCommand(ctx, "RunCommand", "node-1", payload(), domains.CommandOptions{TenantID: domains.DefaultTenantID})
+	if !errors.Is(err, domains.ErrRequesterRequired) {
+		t.Fatalf("SubmitCommand without an operator = %v, want ErrRequesterRequired", err)
+	}
+
+	commandID, _, err := s.SubmitCommand(ctx, "RunCommand", "node-1", payload(), domains.CommandOptions{
+		TenantID: domains.DefaultTenantID, OperatorID: "alice",
+	})
+	if err != nil {
+		t.Fatalf("SubmitCommand: %v", err)
+	}
+	if _, err := s.DecideApproval(ctx, domains.DefaultTenantID, commandID, true, "", nil); !errors.Is(err, domains.ErrApproverRequired) {
+		t.Errorf("DecideApproval without an operator = %v, want ErrApproverRequired", err)
+	}
+	if _, err := s.DecideApproval(ctx, domains.DefaultTenantID, commandID, true, "alice", nil); !errors.Is(err, domains.ErrSelfApproval) {
+		t.Errorf("DecideApproval by the submitter = %v, want ErrSelfApproval", err)
+	}
+	cmd, err := s.DecideApproval(ctx, domains.DefaultTenantID, commandID, true, "bob", nil)
+	if err != nil {
+		t.Fatalf("DecideApproval by another operator: %v", err)
+	}
+	if cmd.Status != domains.StatusQueued {
+		t.Errorf("approved command is %s, want %s", cmd.Status, domains.StatusQueued)
+	}
+}
+
+func TestDispatchedCommandsAreSigned(t *testing.T) {
+	ctx := context.Background()
+	_, key, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name string
+		key  ed25519.PrivateKey
+	}{
+		{name: "signing key", key: key},
+		{name: "no signing key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.NewStore()
+			s := NewCommandService(store, NewCommandPolicyService(store, ""), CommandServiceConfig{
+				MaxOutputBytes: 4096, SigningKey: tt.key, SignatureTTL: 5 * time.Minute,
+			})
+			if err := store.RegisterNode(ctx, domains.DefaultTenantID, "node-1", nil); err != nil {
+				t.Fatalf("RegisterNode: %v", err)
+			}
+			if _, _, err := s.SubmitCommand(ctx, "RunCommand", "node-1", map[string]interface{}{"cmd": "uptime"},
+				domains.CommandOptions{TenantID: domains.DefaultTenantID}); err != nil {
+				t.Fatalf("SubmitCommand: %v", err)
+			}
+
+			cmds, err := s.GetNextCommand(ctx, "node-1")
+			if err != nil || len(cmds) != 1 {
+				t.Fatalf("GetNextCommand() = %d commands, %v", len(cmds), err)
+			}
+			cmd := cmds[0]
+			if tt.key == nil {
+				if cmd.Signature != "" || s.SigningKey() != nil {
+					t.Errorf("command signed without a signing key")
+				}
+				return
+			}
+
+			// The node verifies the payload as it decodes it from JSON
+			data, _ := json.Marshal(cmd.Payload)
+			var payload map[string]interface{}
+			if err := json.Unmarshal(data, &payload); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			signed := client.SignedCommand{
+				CommandID:          cmd.CommandID.String(),
+				NodeID:             "node-1",
+				CommandType:        cmd.CommandType,
+				Payload:            payload,
+				ExpiresAt:          cmd.ExpiresAt,
+				SignatureExpiresAt: cmd.SignatureExpiresAt,
+			}
+			if err := signed.Verify(s.SigningKey(), cmd.Signature, time.Now()); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			if err := signed.Verify(s.SigningKey(), cmd.Signature, time.Now().Add(6*time.Minute)); !errors.Is(err, client.ErrCommandSignatureExpired) {
+				t.Errorf("Verify() after the signature TTL = %v, want ErrCommandSignatureExpired", err)
+			}
+			signed.NodeID = "node-2"
+			if err := signed.Verify(s.SigningKey(), cmd.Signature, time.Now()); !errors.Is(err, client.ErrInvalidCommandSignature) {
+				t.Errorf("Verify() for another node = %v, want ErrInvalidCommandSignature", err)
+			}
+		})
+	}
+}
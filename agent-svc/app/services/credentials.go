@@ -0,0 +1,35 @@
+package services
+
+import "strings"
+
+// Names of the headers requests authenticate with; gRPC requests carry them as metadata of the same names
+const (
+	HeaderAuthorization = "Authorization"
+	HeaderTenantID      = "X-Tenant-ID"
+)
+
+// Credentials are what a request to the HTTP or gRPC API authenticates with
+// Both APIs read them with ReadCredentials, so nodes and operators authenticate the same way on either.
+// Who is calling is only known once JWTService has validated the token: see AuthenticateNode and
+// AuthenticateOperator.
+type Credentials struct {
+	Authorization string // "Bearer <token>": the token a node got when it registered, or an operator token
+	TenantID      string // tenant the operator asks to act in; see TenantService.ResolveTenant
+}
+
+// ReadCredentials reads credentials with get, which returns the value of a header or metadata key
+func ReadCredentials(get func(name string) string) Credentials {
+	return Credentials{
+		Authorization: get(HeaderAuthorization),
+		TenantID:      get(HeaderTenantID),
+	}
+}
+
+// BearerToken returns the token of a bearer Authorization, or "" if there is none
+func (c Credentials) BearerToken() string {
+	token, ok := strings.CutPrefix(c.Authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+)
+
+// ReadinessProbe is implemented by storage backends that can be checked for readiness
+type ReadinessProbe interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// HealthServiceConfig holds the tunables of HealthService
+type HealthServiceConfig struct {
+	// ExpectedSchemaVersion is the migration version this build was written against
+	ExpectedSchemaVersion uint
+	// DBTimeout bounds the database checks of a readiness check
+	DBTimeout time.Duration
+}
+
+// HealthService runs readiness checks
+type HealthService struct {
+	probe   ReadinessProbe
+	workers *WorkerTracker
+	config  HealthServiceConfig
+}
+
+// NewHealthService creates a new health service
+func NewHealthService(probe ReadinessProbe, workers *WorkerTracker, config HealthServiceConfig) *HealthService {
+	return &HealthService{
+		probe:   probe,
+		workers: workers,
+		config:  config,
+	}
+}
+
+// Ready checks the database connection and migration version and reports the background workers
+func (s *HealthService) Ready(ctx context.Context) *domains.Readiness {
+	ctx, cancel := context.WithTimeout(ctx, s.config.DBTimeout)
+	defer cancel()
+
+	r := &domains.Readiness{CheckedAt: time.Now()}
+	r.Database = s.checkDatabase(ctx)
+	if r.Database.Status == domains.ComponentOK {
+		r.Migrations = s.checkMigrations(ctx)
+	} else {
+		r.Migrations = domains.MigrationHealth{
+			Status:          domains.ComponentFailed,
+			ExpectedVersion: s.config.ExpectedSchemaVersion,
+			Error:           "database unavailable",
+		}
+	}
+	r.Workers = s.workers.Health(r.CheckedAt)
+
+	r.Ready = r.Database.Status == domains.ComponentOK && r.Migrations.Status == domains.ComponentOK
+	return r
+}
+
+// checkDatabase pings the database
+func (s *HealthService) checkDatabase(ctx context.Context) domains.DatabaseHealth {
+	start := time.Now()
+	err := s.probe.Ping(ctx)
+	h := domains.DatabaseHealth{Status: domains.ComponentOK, Latency: time.Since(start)}
+	if err != nil {
+		h.Status, h.Error = domains.ComponentFailed, err.Error()
+	}
+	return h
+}
+
+// checkMigrations compares the applied migration version with the expected one
+// A database migrated ahead by a newer build fails the check as well as a stale one: this build may not
+// understand the newer schema.
+func (s *HealthService) checkMigrations(ctx context.Context) domains.MigrationHealth {
+	h := domains.MigrationHealth{Status: domains.ComponentOK, ExpectedVersion: s.config.ExpectedSchemaVersion}
+
+	version, dirty, err := s.probe.MigrationVersion(ctx)
+	if err != nil {
+		h.Status, h.Error = domains.ComponentFailed, err.Error()
+		return h
+	}
+	h.Version, h.Dirty = version, dirty
+
+	switch {
+	case dirty:
+		h.Status, h.Error = domains.ComponentFailed, fmt.Sprintf("migration %d is dirty", version)
+	case version != s.config.ExpectedSchemaVersion:
+		h.Status = domains.ComponentFailed
+		h.Error = fmt.Sprintf("schema version %d, expected %d", version, s.config.ExpectedSchemaVersion)
+	}
+	return h
+}
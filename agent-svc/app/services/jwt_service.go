@@ -22,24 +22,39 @@ func NewJWTService(secret string, expirationSec int64) *JWTService {
 }
 
 // Claims represents JWT claims
+// A token is either a node's, with NodeID set, or an operator's, with Operator set, never both.
 type Claims struct {
-	NodeID string `json:"node_id"`
-	Key    string `json:"key"` // Kong JWT plugin uses this to find the credential
+	NodeID   string   `json:"node_id,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"` // tenant the node is enrolled in
+	Operator string   `json:"operator,omitempty"`  // operator the token was issued to
+	Roles    []string `json:"roles,omitempty"`     // roles of the operator, matched by command policy rules
+	Key      string   `json:"key"`                 // Kong JWT plugin uses this to find the credential
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a JWT token for a node
-func (j *JWTService) GenerateToken(nodeID string) (string, error) {
+// GenerateToken generates a JWT token for a node enrolled in a tenant
+func (j *JWTService) GenerateToken(nodeID, tenantID string) (string, error) {
+	return j.sign(&Claims{NodeID: nodeID, TenantID: tenantID}, nodeID, j.expiration)
+}
+
+// GenerateOperatorToken generates a JWT token identifying an operator with their roles, valid for ttl
+// Operator tokens are issued out of band, with cmd/operator-token, by whoever holds the signing secret.
+func (j *JWTService) GenerateOperatorToken(operatorID string, roles []string, ttl time.Duration) (string, error) {
+	if operatorID == "" {
+		return "", fmt.Errorf("operator is required")
+	}
+	return j.sign(&Claims{Operator: operatorID, Roles: roles}, operatorID, ttl)
+}
+
+// sign completes claims for subject, expiring after ttl, and signs them
+func (j *JWTService) sign(claims *Claims, subject string, ttl time.Duration) (string, error) {
 	now := time.Now()
-	claims := &Claims{
-		NodeID: nodeID,
-		Key:    "agent-jwt-key", // Must match the JWT credential key in Kong
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   nodeID,
-			Issuer:    "agent-svc",
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(j.expiration)),
-		},
+	claims.Key = "agent-jwt-key" // Must match the JWT credential key in Kong
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Subject:   subject,
+		Issuer:    "agent-svc",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -53,6 +68,15 @@ func (j *JWTService) GenerateToken(nodeID string) (string, error) {
 
 // ValidateToken validates a JWT token and returns the node ID
 func (j *JWTService) ValidateToken(tokenString string) (string, error) {
+	claims, err := j.ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.NodeID, nil
+}
+
+// ParseToken validates a JWT token and returns its claims
+func (j *JWTService) ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -62,12 +86,46 @@ func (j *JWTService) ValidateToken(tokenString string) (string, error) {
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	return claims.NodeID, nil
+	return claims, nil
+}
+
+// AuthenticateNode validates the bearer token of a node's request and returns its claims
+func (j *JWTService) AuthenticateNode(creds Credentials) (*Claims, error) {
+	token := creds.BearerToken()
+	if token == "" {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	claims, err := j.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.NodeID == "" {
+		return nil, fmt.Errorf("not a node token")
+	}
+	return claims, nil
+}
+
+// AuthenticateOperator validates the bearer token of an operator's request and returns its claims
+// The operator and their roles are only ever taken from a token agent-svc signed, never from headers a
+// client could set.
+func (j *JWTService) AuthenticateOperator(creds Credentials) (*Claims, error) {
+	token := creds.BearerToken()
+	if token == "" {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	claims, err := j.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Operator == "" || claims.NodeID != "" {
+		return nil, fmt.Errorf("not an operator token")
+	}
+	return claims, nil
 }
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticateOperator(t *testing.T) {
+	j := NewJWTService("secret", 3600)
+	operatorToken, err := j.GenerateOperatorToken("alice", []string{"sre", "oncall"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeToken, err := j.GenerateToken("node-1", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredToken, err := j.GenerateOperatorToken("alice", nil, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedToken, err := NewJWTService("other", 3600).GenerateOperatorToken("alice", []string{"admin"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantOperator  bool
+		wantNode      bool
+	}{
+		{name: "operator token", authorization: "Bearer " + operatorToken, wantOperator: true},
+		{name: "node token", authorization: "Bearer " + nodeToken, wantNode: true},
+		{name: "no token", authorization: ""},
+		{name: "not a bearer token", authorization: operatorToken},
+		{name: "expired token", authorization: "Bearer " + expiredToken},
+		{name: "token signed with another secret", authorization: "Bearer " + forgedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := ReadCredentials(func(name string) string {
+				if name == HeaderAuthorization {
+					return tt.authorization
+				}
+				return ""
+			})
+
+			claims, err := j.AuthenticateOperator(creds)
+			if (err == nil) != tt.wantOperator {
+				t.Fatalf("AuthenticateOperator() error = %v, want operator %v", err, tt.wantOperator)
+			}
+			if tt.wantOperator && (claims.Operator != "alice" || len(claims.Roles) != 2 || claims.Roles[0] != "sre") {
+				t.Errorf("AuthenticateOperator() = %+v, want alice with roles sre, oncall", claims)
+			}
+
+			if _, err := j.AuthenticateNode(creds); (err == nil) != tt.wantNode {
+				t.Errorf("AuthenticateNode() error = %v, want node %v", err, tt.wantNode)
+			}
+		})
+	}
+}
+
+func TestGenerateOperatorTokenRequiresOperator(t *testing.T) {
+	if _, err := NewJWTService("secret", 3600).GenerateOperatorToken("", []string{"sre"}, time.Hour); err == nil {
+		t.Error("GenerateOperatorToken() with no operator succeeded")
+	}
+}
@@ -6,18 +6,27 @@ import (
 
 	"agent-svc/app/clients"
 	"agent-svc/app/domains"
+	"agent-svc/app/metrics"
 
 	"github.com/google/uuid"
 )
 
+// truncationSlack leaves room above a command's output limit for the truncation marker the agent appends
+const truncationSlack = 4 << 10
+
 // LogService handles log operations
 type LogService struct {
-	storage clients.StorageAdapter
+	storage        clients.StorageAdapter
+	maxOutputBytes int64
 }
 
 // NewLogService creates a new log service
-func NewLogService(storage clients.StorageAdapter) *LogService {
-	return &LogService{storage: storage}
+// maxOutputBytes caps stored output for commands whose payload doesn't carry max_output_bytes; zero disables it
+func NewLogService(storage clients.StorageAdapter, maxOutputBytes int64) *LogService {
+	return &LogService{
+		storage:        storage,
+		maxOutputBytes: maxOutputBytes,
+	}
 }
 
 // PushCommandLogs pushes command execution log chunks for a command
@@ -34,10 +43,9 @@ func (s *LogService) PushCommandLogs(ctx context.Context, commandID uuid.UUID, n
 		return nil, fmt.Errorf("command does not belong to node")
 	}
 
-	// If command is finished (success, failed, or timeout), mark all chunks as final
+	// If command is finished (any terminal status), mark all chunks as final
 	// This ensures chunks API knows about completion before storing
-	isFinished := cmd.Status == "success" || cmd.Status == "failed" || cmd.Status == "timeout"
-	if isFinished {
+	if domains.IsTerminalStatus(cmd.Status) {
 		for i := range chunks {
 			chunks[i].IsFinal = true
 		}
@@ -59,18 +67,71 @@ func (s *LogService) PushCommandLogs(ctx context.Context, commandID uuid.UUID, n
 		}
 	}
 
+	// Enforce the output cap as a backstop for agents that don't truncate locally.
+	// Chunks past the cap are acked without being stored so the agent stops retrying them.
+	chunks, droppedChunkIndexes, err := s.capChunks(ctx, cmd, chunks)
+	if err != nil {
+		return nil, err
+	}
+
 	// Insert chunks with idempotency
 	ackedChunkIndexes, err := s.storage.InsertLogChunks(ctx, commandID, chunks)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert log chunks: %w", err)
 	}
 
+	for _, chunk := range chunks {
+		metrics.LogChunksIngested.WithLabelValues(chunk.Stream).Inc()
+		metrics.LogBytesIngested.WithLabelValues(chunk.Stream).Add(float64(len(chunk.Data)))
+	}
+	metrics.LogChunksDropped.Add(float64(len(droppedChunkIndexes)))
+
+	if len(droppedChunkIndexes) > 0 {
+		if err := s.storage.MarkCommandOutputTruncated(ctx, commandID); err != nil {
+			return nil, fmt.Errorf("failed to mark output truncated: %w", err)
+		}
+		ackedChunkIndexes = append(ackedChunkIndexes, droppedChunkIndexes...)
+	}
+
 	return ackedChunkIndexes, nil
 }
 
+// capChunks splits chunks into those that fit within the command's remaining output budget and
+// the indexes of those that don't
+func (s *LogService) capChunks(ctx context.Context, cmd *domains.NodeCommand, chunks []domains.CommandLog) ([]domains.CommandLog, []int64, error) {
+	limit := s.maxOutputBytes
+	if requested, ok := cmd.Payload["max_output_bytes"].(float64); ok && requested > 0 {
+		limit = int64(requested)
+	}
+	if limit <= 0 {
+		return chunks, nil, nil
+	}
+
+	stored, err := s.storage.GetCommandLogSize(ctx, cmd.CommandID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get stored log size: %w", err)
+	}
+
+	budget := limit + truncationSlack - stored
+	kept := make([]domains.CommandLog, 0, len(chunks))
+	var dropped []int64
+	for _, chunk := range chunks {
+		size := int64(len(chunk.Data))
+		if size > budget {
+			dropped = append(dropped, chunk.ChunkIndex)
+			continue
+		}
+		budget -= size
+		kept = append(kept, chunk)
+	}
+
+	return kept, dropped, nil
+}
+
 // GetCommandLogs retrieves logs for a command
 // Returns all logs for the command, even if it's not finished
 // If afterChunkIndex is provided, only returns logs with chunk_index >= afterChunkIndex (inclusive)
-func (s *LogService) GetCommandLogs(ctx context.Context, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
-	return s.storage.GetCommandLogs(ctx, commandID, afterChunkIndex)
+// A command whose node is outside a non-empty tenantID has no logs.
+func (s *LogService) GetCommandLogs(ctx context.Context, tenantID string, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
+	return s.storage.GetCommandLogs(ctx, tenantID, commandID, afterChunkIndex)
 }
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+
+	"github.com/google/uuid"
+)
+
+// RolloutService runs commands across many nodes in batches
+//
+// Like workflows, a rollout is advanced whenever one of its commands finishes and by a periodic reconcile,
+// which also starts batches once the pause between batches has passed. Batch starts and finishes are
+// compare-and-set updates, so several agent-svc replicas can drive the same rollout.
+type RolloutService struct {
+	storage        clients.StorageAdapter
+	commandService *CommandService
+}
+
+// NewRolloutService creates a new rollout service and subscribes it to command completions
+func NewRolloutService(storage clients.StorageAdapter, commandService *CommandService) *RolloutService {
+	s := &RolloutService{
+		storage:        storage,
+		commandService: commandService,
+	}
+	commandService.OnCommandFinished(s.onCommandFinished)
+	return s
+}
+
+// CreateRollout plans the batches of a rollout over its target nodes, persists it and starts the first batch
+// The targets are nodeIDs if given, otherwise the enabled nodes matching selector (all of them if it is empty),
+// and must belong to r.TenantID. It returns domains.ErrTenantRequired if the rollout names no tenant.
+func (s *RolloutService) CreateRollout(ctx context.Context, r *domains.Rollout, nodeIDs []string, selector domains.NodeSelector) error {
+	if r.TenantID == "" {
+		return domains.ErrTenantRequired
+	}
+	if err := r.Strategy.Validate(); err != nil {
+		return err
+	}
+	if err := utils.ValidateCommandPayload(r.CommandType, r.Payload); err != nil {
+		return fmt.Errorf("%w: payload validation failed: %v", domains.ErrInvalidRollout, err)
+	}
+	if r.Priority < domains.PriorityMin || r.Priority > domains.PriorityMax {
+		return fmt.Errorf("%w: priority must be between %d and %d", domains.ErrInvalidRollout, domains.PriorityMin, domains.PriorityMax)
+	}
+
+	if len(nodeIDs) == 0 {
+		var err error
+		if nodeIDs, err = s.commandService.ResolveTargets(ctx, r.TenantID, domains.CommandTarget{Selector: selector}); err != nil {
+			return err
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		if _, err := s.commandService.ResolveTargets(ctx, r.TenantID, domains.CommandTarget{NodeID: nodeID}); err != nil {
+			return fmt.Errorf("%w: %v", domains.ErrInvalidRollout, err)
+		}
+	}
+	nodeIDs = uniqueStrings(nodeIDs)
+	if len(nodeIDs) == 0 {
+		return fmt.Errorf("%w: no nodes match the rollout target", domains.ErrInvalidRollout)
+	}
+
+	batches, err := r.Strategy.PlanBatches(nodeIDs)
+	if err != nil {
+		return err
+	}
+
+	r.Status = domains.RolloutRunning
+	if err := s.storage.CreateRollout(ctx, r, batches); err != nil {
+		return fmt.Errorf("failed to create rollout: %w", err)
+	}
+
+	if err := s.advance(ctx, r.RolloutID); err != nil {
+		fmt.Printf("rollout %s: %v\n", r.RolloutID, err)
+	}
+	return nil
+}
+
+// GetRollout retrieves a rollout and its batches, or nil if it doesn't exist or belongs to another tenant
+// than a non-empty tenantID
+func (s *RolloutService) GetRollout(ctx context.Context, tenantID string, rolloutID uuid.UUID) (*domains.Rollout, []domains.RolloutBatch, error) {
+	r, err := s.storage.GetRollout(ctx, rolloutID)
+	if err != nil || r == nil {
+		return nil, nil, err
+	}
+	if tenantID != "" && r.TenantID != tenantID {
+		return nil, nil, nil
+	}
+
+	batches, err := s.storage.ListRolloutBatches(ctx, rolloutID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get rollout batches: %w", err)
+	}
+	return r, batches, nil
+}
+
+// ListRollouts retrieves the most recent rollouts of a tenant, or of every tenant if tenantID is empty,
+// optionally filtered by status
+func (s *RolloutService) ListRollouts(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Rollout, error) {
+	return s.storage.ListRollouts(ctx, tenantID, status, limit)
+}
+
+// PauseRollout stops a running rollout from starting further batches
+// It returns false if the rollout doesn't exist or belongs to another tenant than a non-empty tenantID, and
+// domains.ErrRolloutState if it isn't running.
+func (s *RolloutService) PauseRollout(ctx context.Context, tenantID string, rolloutID uuid.UUID) (bool, error) {
+	return s.transition(ctx, tenantID, rolloutID, []string{domains.RolloutRunning}, domains.RolloutPaused)
+}
+
+// ResumeRollout continues a paused or halted rollout with its next batch
+// It returns false if the rollout doesn't exist or belongs to another tenant than a non-empty tenantID, and
+// domains.ErrRolloutState if it is neither paused nor halted.
+func (s *RolloutService) ResumeRollout(ctx context.Context, tenantID string, rolloutID uuid.UUID) (bool, error) {
+	found, err := s.transition(ctx, tenantID, rolloutID, []string{domains.RolloutPaused, domains.RolloutHalted}, domains.RolloutRunning)
+	if err != nil || !found {
+		return found, err
+	}
+
+	if err := s.advance(ctx, rolloutID); err != nil {
+		fmt.Printf("rollout %s: %v\n", rolloutID, err)
+	}
+	return true, nil
+}
+
+// AbortRollout stops a rollout for good and cancels its commands that have not been dispatched yet
+// It returns false if the rollout doesn't exist or belongs to another tenant than a non-empty tenantID, and
+// domains.ErrRolloutState if it already ended.
+func (s *RolloutService) AbortRollout(ctx context.Context, tenantID string, rolloutID uuid.UUID) (bool, error) {
+	from := []string{domains.RolloutRunning, domains.RolloutPaused, domains.RolloutHalted}
+	found, err := s.transition(ctx, tenantID, rolloutID, from, domains.RolloutAborted)
+	if err != nil || !found {
+		return found, err
+	}
+
+	if _, err := s.storage.CancelRolloutCommands(ctx, rolloutID); err != nil {
+		return true, fmt.Errorf("failed to cancel rollout commands: %w", err)
+	}
+	return true, nil
+}
+
+// transition moves a rollout of a non-empty tenantID, or of any tenant, between statuses on behalf of an operator
+func (s *RolloutService) transition(ctx context.Context, tenantID string, rolloutID uuid.UUID, from []string, to string) (bool, error) {
+	r, err := s.storage.GetRollout(ctx, rolloutID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get rollout: %w", err)
+	}
+	if r == nil || tenantID != "" && r.TenantID != tenantID {
+		return false, nil
+	}
+
+	ok, err := s.storage.TransitionRollout(ctx, rolloutID, from, to, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to update rollout: %w", err)
+	}
+	if ok {
+		return true, nil
+	}
+
+	if r, err = s.storage.GetRollout(ctx, rolloutID); err != nil {
+		return false, fmt.Errorf("failed to get rollout: %w", err)
+	}
+	if r == nil {
+		return false, nil
+	}
+	return true, fmt.Errorf("%w: rollout is %s", domains.ErrRolloutState, r.Status)
+}
+
+// ReconcileRollouts advances every running or paused rollout
+func (s *RolloutService) ReconcileRollouts(ctx context.Context) error {
+	for _, status := range []string{domains.RolloutRunning, domains.RolloutPaused} {
+		status := status
+		rollouts, err := s.storage.ListRollouts(ctx, "", &status, 500)
+		if err != nil {
+			return fmt.Errorf("failed to list %s rollouts: %w", status, err)
+		}
+
+		for _, r := range rollouts {
+			if err := s.advance(ctx, r.RolloutID); err != nil {
+				fmt.Printf("rollout %s: %v\n", r.RolloutID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// onCommandFinished advances the rollout a finished command belongs to
+func (s *RolloutService) onCommandFinished(ctx context.Context, cmd *domains.NodeCommand) {
+	if cmd.RolloutID == nil || cmd.NextRetryAt != nil {
+		return
+	}
+	if err := s.advance(ctx, *cmd.RolloutID); err != nil {
+		fmt.Printf("rollout %s: %v\n", *cmd.RolloutID, err)
+	}
+}
+
+// advance finishes the running batch once its commands are done, halting the rollout if the canary failed
+// or the failure threshold is crossed, and starts the next batch once it is due. It repeats until nothing
+// changes, since a batch whose commands all failed to submit finishes right away.
+func (s *RolloutService) advance(ctx context.Context, rolloutID uuid.UUID) error {
+	for {
+		r, batches, err := s.GetRollout(ctx, "", rolloutID)
+		if err != nil || r == nil {
+			return err
+		}
+
+		var running, next *domains.RolloutBatch
+		for i := range batches {
+			switch batches[i].Status {
+			case domains.BatchRunning:
+				running = &batches[i]
+			case domains.BatchPending:
+				if next == nil {
+					next = &batches[i]
+				}
+			}
+		}
+
+		if running != nil {
+			// A batch still finishes after its rollout was paused, halted or aborted, so its progress is accurate
+			finished, err := s.finishBatchIfDone(ctx, r, running, batches)
+			if err != nil || !finished {
+				return err
+			}
+			continue
+		}
+
+		if r.Status != domains.RolloutRunning {
+			return nil
+		}
+		if next == nil {
+			_, err := s.storage.TransitionRollout(ctx, rolloutID, []string{domains.RolloutRunning}, domains.RolloutCompleted, nil)
+			return err
+		}
+		if r.NextBatchAt != nil && time.Now().Before(*r.NextBatchAt) {
+			return nil
+		}
+
+		started, err := s.storage.StartRolloutBatch(ctx, rolloutID, next.BatchIndex)
+		if err != nil || !started {
+			return err
+		}
+		if err := s.submitBatch(ctx, r, next); err != nil {
+			return err
+		}
+	}
+}
+
+// finishBatchIfDone marks the running batch succeeded or failed once all of its commands have finished,
+// then decides whether the rollout goes on
+func (s *RolloutService) finishBatchIfDone(ctx context.Context, r *domains.Rollout, batch *domains.RolloutBatch, batches []domains.RolloutBatch) (bool, error) {
+	outcome, err := s.storage.GetRolloutBatchOutcome(ctx, r.RolloutID, batch.BatchIndex)
+	if err != nil {
+		return false, fmt.Errorf("failed to get outcome of batch %d: %w", batch.BatchIndex, err)
+	}
+
+	if outcome.Commands == 0 && batch.ErrorMsg == nil {
+		// The engine that started the batch stopped before submitting its commands
+		if batch.StartedAt == nil || time.Since(*batch.StartedAt) < stepSubmitGrace {
+			return false, nil
+		}
+	} else if outcome.Finished < outcome.Commands {
+		return false, nil
+	}
+
+	// Nodes that got no command count as failed
+	status := domains.BatchSucceeded
+	if outcome.Succeeded < len(batch.NodeIDs) {
+		status = domains.BatchFailed
+	}
+	finished, err := s.storage.FinishRolloutBatch(ctx, r.RolloutID, batch.BatchIndex, status, outcome.Succeeded)
+	if err != nil || !finished {
+		return false, err
+	}
+
+	// Only the caller that finished the batch decides, so the rollout is halted or delayed once
+	if r.Status != domains.RolloutRunning && r.Status != domains.RolloutPaused {
+		return true, nil
+	}
+
+	failed, total := len(batch.NodeIDs)-outcome.Succeeded, len(batch.NodeIDs)
+	for _, b := range batches {
+		if domains.IsBatchFinished(b.Status) {
+			failed += len(b.NodeIDs) - b.SucceededCount
+			total += len(b.NodeIDs)
+		}
+	}
+
+	var haltMsg string
+	switch {
+	case batch.Canary && status == domains.BatchFailed:
+		haltMsg = fmt.Sprintf("canary batch failed on %d of %d nodes", len(batch.NodeIDs)-outcome.Succeeded, len(batch.NodeIDs))
+	case r.Strategy.ExceedsFailureThreshold(failed, total):
+		haltMsg = fmt.Sprintf("%d of %d nodes failed, above max_failure_percent %d", failed, total, r.Strategy.MaxFailurePercent)
+	}
+	if haltMsg != "" {
+		from := []string{domains.RolloutRunning, domains.RolloutPaused}
+		if _, err := s.storage.TransitionRollout(ctx, r.RolloutID, from, domains.RolloutHalted, &haltMsg); err != nil {
+			return true, fmt.Errorf("failed to halt rollout: %w", err)
+		}
+		return true, nil
+	}
+
+	nextAt := time.Now().Add(time.Duration(r.Strategy.PauseBetweenBatchesSec) * time.Second)
+	if err := s.storage.SetRolloutNextBatchAt(ctx, r.RolloutID, nextAt); err != nil {
+		return true, fmt.Errorf("failed to schedule next batch: %w", err)
+	}
+	return true, nil
+}
+
+// submitBatch submits the rollout's command to every node of a started batch, within the rollout's tenant
+func (s *RolloutService) submitBatch(ctx context.Context, r *domains.Rollout, batch *domains.RolloutBatch) error {
+	var commandIDs []uuid.UUID
+	var errs []string
+	for _, nodeID := range batch.NodeIDs {
+		// SubmitCommand fills in payload defaults, so each node gets its own copy
+		payload := make(map[string]interface{}, len(r.Payload))
+		for k, v := range r.Payload {
+			payload[k] = v
+		}
+
+		priority := r.Priority
+		commandID, _, err := s.commandService.SubmitCommand(ctx, r.CommandType, nodeID, payload, domains.CommandOptions{
+			Priority:     &priority,
+			RolloutID:    &r.RolloutID,
+			RolloutBatch: batch.BatchIndex,
+			TenantID:     r.TenantID,
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		commandIDs = append(commandIDs, commandID)
+	}
+
+	var errorMsg *string
+	if len(errs) > 0 {
+		msg := strings.Join(errs, "; ")
+		errorMsg = &msg
+	}
+	if err := s.storage.SetRolloutBatchCommands(ctx, r.RolloutID, batch.BatchIndex, commandIDs, errorMsg); err != nil {
+		return fmt.Errorf("failed to record commands of batch %d: %w", batch.BatchIndex, err)
+	}
+	return nil
+}
+
+// uniqueStrings returns values without duplicates, keeping the first occurrence of each
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+
+	"github.com/google/uuid"
+)
+
+// dueSchedulesBatch is the number of due schedules handled per scheduler tick
+const dueSchedulesBatch = 100
+
+// ScheduleService manages recurring commands and fires them on time
+type ScheduleService struct {
+	storage        clients.StorageAdapter
+	commandService *CommandService
+}
+
+// NewScheduleService creates a new schedule service
+func NewScheduleService(storage clients.StorageAdapter, commandService *CommandService) *ScheduleService {
+	return &ScheduleService{
+		storage:        storage,
+		commandService: commandService,
+	}
+}
+
+// CreateSchedule validates and stores a schedule of sched.TenantID, computing its first fire time
+// It returns domains.ErrTenantRequired if the schedule names no tenant.
+func (s *ScheduleService) CreateSchedule(ctx context.Context, sched *domains.Schedule) error {
+	if sched.TenantID == "" {
+		return domains.ErrTenantRequired
+	}
+	if sched.Timezone == "" {
+		sched.Timezone = "UTC"
+	}
+	if sched.MisfirePolicy == "" {
+		sched.MisfirePolicy = domains.MisfireRunOnce
+	}
+
+	if (sched.NodeID == nil) == (len(sched.Selector) == 0) {
+		return fmt.Errorf("%w: exactly one of node_id and selector must be set", domains.ErrInvalidSchedule)
+	}
+	if err := utils.ValidateCommandPayload(sched.CommandType, sched.Payload); err != nil {
+		return fmt.Errorf("%w: payload validation failed: %v", domains.ErrInvalidSchedule, err)
+	}
+
+	next, err := sched.NextRun(time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", domains.ErrInvalidSchedule, err)
+	}
+	if !sched.Paused {
+		sched.NextRunAt = &next
+	}
+
+	if sched.NodeID != nil {
+		node, err := s.storage.GetNode(ctx, *sched.NodeID)
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", *sched.NodeID, err)
+		}
+		if node == nil || node.TenantID != sched.TenantID {
+			return fmt.Errorf("%w: node %s not found", domains.ErrInvalidSchedule, *sched.NodeID)
+		}
+	}
+
+	if err := s.storage.CreateSchedule(ctx, sched); err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return nil
+}
+
+// GetSchedule retrieves a schedule, or nil if it doesn't exist or belongs to another tenant than a
+// non-empty tenantID
+func (s *ScheduleService) GetSchedule(ctx context.Context, tenantID string, scheduleID uuid.UUID) (*domains.Schedule, error) {
+	sched, err := s.storage.GetSchedule(ctx, scheduleID)
+	if err != nil || sched == nil {
+		return nil, err
+	}
+	if tenantID != "" && sched.TenantID != tenantID {
+		return nil, nil
+	}
+	return sched, nil
+}
+
+// ListSchedules retrieves the schedules of a tenant, or of every tenant if tenantID is empty
+func (s *ScheduleService) ListSchedules(ctx context.Context, tenantID string) ([]*domains.Schedule, error) {
+	return s.storage.ListSchedules(ctx, tenantID)
+}
+
+// DeleteSchedule deletes a schedule of a non-empty tenantID, or of any tenant, reporting whether it existed
+func (s *ScheduleService) DeleteSchedule(ctx context.Context, tenantID string, scheduleID uuid.UUID) (bool, error) {
+	sched, err := s.GetSchedule(ctx, tenantID, scheduleID)
+	if err != nil || sched == nil {
+		return false, err
+	}
+	return s.storage.DeleteSchedule(ctx, scheduleID)
+}
+
+// PauseSchedule stops a schedule of a non-empty tenantID, or of any tenant, from firing, reporting whether it exists
+func (s *ScheduleService) PauseSchedule(ctx context.Context, tenantID string, scheduleID uuid.UUID) (bool, error) {
+	sched, err := s.GetSchedule(ctx, tenantID, scheduleID)
+	if err != nil || sched == nil {
+		return false, err
+	}
+	return s.storage.SetSchedulePaused(ctx, scheduleID, true, nil)
+}
+
+// ResumeSchedule lets a paused schedule fire again from its next fire time after now
+// Fire times missed while paused are not run.
+func (s *ScheduleService) ResumeSchedule(ctx context.Context, tenantID string, scheduleID uuid.UUID) (*domains.Schedule, error) {
+	sched, err := s.GetSchedule(ctx, tenantID, scheduleID)
+	if err != nil || sched == nil {
+		return nil, err
+	}
+
+	next, err := sched.NextRun(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.storage.SetSchedulePaused(ctx, scheduleID, false, &next); err != nil {
+		return nil, fmt.Errorf("failed to resume schedule: %w", err)
+	}
+
+	sched.Paused = false
+	sched.NextRunAt = &next
+	return sched, nil
+}
+
+// RunScheduleNow fires a schedule immediately without changing its next fire time
+func (s *ScheduleService) RunScheduleNow(ctx context.Context, tenantID string, scheduleID uuid.UUID) (*domains.ScheduleRun, error) {
+	sched, err := s.GetSchedule(ctx, tenantID, scheduleID)
+	if err != nil || sched == nil {
+		return nil, err
+	}
+	return s.fire(ctx, sched, time.Now(), domains.TriggerManual)
+}
+
+// ListScheduleRuns retrieves the most recent runs of a schedule
+func (s *ScheduleService) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]domains.ScheduleRun, error) {
+	return s.storage.ListScheduleRuns(ctx, scheduleID, limit)
+}
+
+// RunDueSchedules fires every schedule whose next fire time has passed and returns how many fired
+// Each fire time is claimed with a compare-and-set on next_run_at before any command is created, so
+// running the scheduler on several agent-svc replicas fires each schedule once.
+func (s *ScheduleService) RunDueSchedules(ctx context.Context) (int, error) {
+	now := time.Now()
+	due, err := s.storage.ListDueSchedules(ctx, now, dueSchedulesBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due schedules: %w", err)
+	}
+
+	fired := 0
+	for _, sched := range due {
+		scheduledFor := *sched.NextRunAt
+
+		// Compute the next fire time from now, so fire times missed while agent-svc was down collapse into this one
+		next, err := sched.NextRun(now)
+		if err != nil {
+			fmt.Printf("schedule %s: %v\n", sched.ScheduleID, err)
+			continue
+		}
+
+		claimed, err := s.storage.AdvanceSchedule(ctx, sched.ScheduleID, scheduledFor, next)
+		if err != nil {
+			return fired, fmt.Errorf("failed to advance schedule %s: %w", sched.ScheduleID, err)
+		}
+		if !claimed {
+			continue // another replica fired it
+		}
+
+		if sched.Misfired(scheduledFor, now) && sched.MisfirePolicy == domains.MisfireSkip {
+			errorMsg := fmt.Sprintf("fire time missed by %s", now.Sub(scheduledFor).Round(time.Second))
+			run := &domains.ScheduleRun{
+				ScheduleID:   sched.ScheduleID,
+				ScheduledFor: scheduledFor,
+				Trigger:      domains.TriggerSchedule,
+				Status:       domains.ScheduleRunSkipped,
+				ErrorMsg:     &errorMsg,
+			}
+			if err := s.storage.InsertScheduleRun(ctx, run); err != nil {
+				fmt.Printf("schedule %s: failed to record run: %v\n", sched.ScheduleID, err)
+			}
+			continue
+		}
+
+		if _, err := s.fire(ctx, sched, scheduledFor, domains.TriggerSchedule); err != nil {
+			fmt.Printf("schedule %s: %v\n", sched.ScheduleID, err)
+		}
+		fired++
+	}
+
+	return fired, nil
+}
+
+// fire submits the schedule's command to every target node of its tenant and records the run
+func (s *ScheduleService) fire(ctx context.Context, sched *domains.Schedule, scheduledFor time.Time, trigger string) (*domains.ScheduleRun, error) {
+	run := &domains.ScheduleRun{
+		ScheduleID:   sched.ScheduleID,
+		ScheduledFor: scheduledFor,
+		Trigger:      trigger,
+	}
+
+	var errs []string
+	nodeIDs, err := s.commandService.ResolveTargets(ctx, sched.TenantID, sched.Target())
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else if len(nodeIDs) == 0 {
+		errs = append(errs, "no nodes match the schedule target")
+	}
+
+	priority := sched.Priority
+	for _, nodeID := range nodeIDs {
+		// SubmitCommand fills in payload defaults, so each node gets its own copy
+		payload := make(map[string]interface{}, len(sched.Payload))
+		for k, v := range sched.Payload {
+			payload[k] = v
+		}
+
+		commandID, _, err := s.commandService.SubmitCommand(ctx, sched.CommandType, nodeID, payload, domains.CommandOptions{
+			Priority: &priority,
+			TenantID: sched.TenantID,
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		run.CommandIDs = append(run.CommandIDs, commandID)
+	}
+
+	switch {
+	case len(errs) == 0:
+		run.Status = domains.ScheduleRunCreated
+	case len(run.CommandIDs) > 0:
+		run.Status = domains.ScheduleRunPartial
+	default:
+		run.Status = domains.ScheduleRunFailed
+	}
+	if len(errs) > 0 {
+		errorMsg := strings.Join(errs, "; ")
+		run.ErrorMsg = &errorMsg
+	}
+
+	if err := s.storage.InsertScheduleRun(ctx, run); err != nil {
+		return run, fmt.Errorf("failed to record schedule run: %w", err)
+	}
+	return run, nil
+}
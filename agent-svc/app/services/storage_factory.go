@@ -4,7 +4,9 @@ import (
 	"fmt"
 
 	"agent-svc/app/clients"
+	"agent-svc/storage/memory"
 	"agent-svc/storage/postgres"
+	"agent-svc/storage/sqlite"
 )
 
 // StorageFactory creates storage adapters
@@ -23,3 +25,17 @@ func (f *StorageFactory) CreatePostgresStore(connString string) (clients.Storage
 	}
 	return store, nil
 }
+
+// CreateSQLiteStore creates a SQLite store backed by the file at path, applying its migrations
+func (f *StorageFactory) CreateSQLiteStore(path string) (clients.StorageAdapter, error) {
+	store, err := sqlite.NewStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sqlite store: %w", err)
+	}
+	return store, nil
+}
+
+// CreateMemoryStore creates an empty in-memory store
+func (f *StorageFactory) CreateMemoryStore() clients.StorageAdapter {
+	return memory.NewStore()
+}
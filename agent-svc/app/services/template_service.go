@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+)
+
+// TemplateService manages the command template library
+type TemplateService struct {
+	storage clients.StorageAdapter
+}
+
+// NewTemplateService creates a new template service
+func NewTemplateService(storage clients.StorageAdapter) *TemplateService {
+	return &TemplateService{
+		storage: storage,
+	}
+}
+
+// CreateTemplate validates a template and stores it as the next version of its name in t.TenantID
+// It returns domains.ErrTenantRequired if the template names no tenant.
+func (s *TemplateService) CreateTemplate(ctx context.Context, t *domains.CommandTemplate) error {
+	if t.TenantID == "" {
+		return domains.ErrTenantRequired
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	if !utils.IsKnownCommandType(t.CommandType) {
+		return fmt.Errorf("%w: unknown command type: %s", domains.ErrInvalidTemplate, t.CommandType)
+	}
+
+	// Defaults must satisfy their own parameter's schema
+	for _, p := range t.Params {
+		if p.Default == nil {
+			continue
+		}
+		param := p
+		param.Required = false
+		if _, err := utils.ValidateTemplateParams([]domains.TemplateParam{param}, map[string]interface{}{p.Name: p.Default}); err != nil {
+			return fmt.Errorf("%w: default of parameter %q: %v", domains.ErrInvalidTemplate, p.Name, err)
+		}
+	}
+
+	if err := s.storage.CreateCommandTemplate(ctx, t); err != nil {
+		return fmt.Errorf("failed to create template: %w", err)
+	}
+	return nil
+}
+
+// GetTemplate retrieves a version of a tenant's template, or its latest version if version is nil
+// Template names are per tenant, so it returns domains.ErrTenantRequired if tenantID is empty.
+func (s *TemplateService) GetTemplate(ctx context.Context, tenantID, name string, version *int) (*domains.CommandTemplate, error) {
+	if tenantID == "" {
+		return nil, domains.ErrTenantRequired
+	}
+	return s.storage.GetCommandTemplate(ctx, tenantID, name, version)
+}
+
+// ListTemplates retrieves the latest version of every template of a tenant, or of every tenant if tenantID
+// is empty
+func (s *TemplateService) ListTemplates(ctx context.Context, tenantID string) ([]*domains.CommandTemplate, error) {
+	return s.storage.ListCommandTemplates(ctx, tenantID)
+}
+
+// ListTemplateVersions retrieves all versions of a tenant's template, newest first
+// It returns domains.ErrTenantRequired if tenantID is empty.
+func (s *TemplateService) ListTemplateVersions(ctx context.Context, tenantID, name string) ([]*domains.CommandTemplate, error) {
+	if tenantID == "" {
+		return nil, domains.ErrTenantRequired
+	}
+	return s.storage.ListCommandTemplateVersions(ctx, tenantID, name)
+}
+
+// Render validates params against a tenant's template and returns the template together with the rendered
+// payload. version selects a template version; the latest is used if it is nil.
+func (s *TemplateService) Render(ctx context.Context, tenantID, name string, version *int, params map[string]interface{}) (*domains.CommandTemplate, map[string]interface{}, error) {
+	t, err := s.GetTemplate(ctx, tenantID, name, version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	if t == nil {
+		if version != nil {
+			return nil, nil, fmt.Errorf("%w: template %s version %d not found", domains.ErrInvalidTemplateParams, name, *version)
+		}
+		return nil, nil, fmt.Errorf("%w: template %s not found", domains.ErrInvalidTemplateParams, name)
+	}
+
+	// Versions stored before a check was added to Validate are refused rather than rendered unsafely
+	if err := t.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: template %s version %d can't be rendered: %v", domains.ErrInvalidTemplateParams, name, t.Version, err)
+	}
+
+	values, err := utils.ValidateTemplateParams(t.Params, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, t.Render(values), nil
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+)
+
+// enrollmentKeyBytes is the size of a generated node enrollment key
+const enrollmentKeyBytes = 32
+
+// TenantService manages tenants and resolves the tenant operators and nodes act in
+type TenantService struct {
+	storage clients.StorageAdapter
+	admins  map[string]bool
+}
+
+// NewTenantService creates a new tenant service
+// Tenant admins may act in every tenant and manage tenants; with no admins, tenants can't be managed.
+func NewTenantService(storage clients.StorageAdapter, adminOperators []string) *TenantService {
+	admins := make(map[string]bool)
+	for _, operatorID := range adminOperators {
+		admins[operatorID] = true
+	}
+	return &TenantService{
+		storage: storage,
+		admins:  admins,
+	}
+}
+
+// IsAdmin reports whether an operator is a tenant admin
+func (s *TenantService) IsAdmin(operatorID string) bool {
+	return operatorID != "" && s.admins[operatorID]
+}
+
+// ResolveTenant returns the tenant an operator acts in, given the tenant they requested, if any
+// An operator without memberships acts in the default tenant and one with a single membership in that
+// tenant; an operator with several must request one. Admins may request any tenant and act in every
+// tenant, returned as "", when they request none.
+func (s *TenantService) ResolveTenant(ctx context.Context, operatorID, requested string) (string, error) {
+	if s.IsAdmin(operatorID) {
+		if requested == "" {
+			return "", nil
+		}
+		t, err := s.storage.GetTenant(ctx, requested)
+		if err != nil {
+			return "", fmt.Errorf("failed to get tenant: %w", err)
+		}
+		if t == nil {
+			return "", fmt.Errorf("%w: tenant %s not found", domains.ErrInvalidTenant, requested)
+		}
+		return requested, nil
+	}
+
+	tenants, err := s.storage.ListOperatorTenants(ctx, operatorID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list operator tenants: %w", err)
+	}
+	if len(tenants) == 0 {
+		tenants = []string{domains.DefaultTenantID}
+	}
+
+	if requested == "" {
+		if len(tenants) > 1 {
+			return "", fmt.Errorf("%w: operator belongs to several tenants, set X-Tenant-ID", domains.ErrInvalidTenant)
+		}
+		return tenants[0], nil
+	}
+	for _, tenantID := range tenants {
+		if tenantID == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", domains.ErrTenantAccessDenied, requested)
+}
+
+// EnrollNode registers a node in a tenant, the default tenant if tenantID is empty
+// It returns domains.ErrEnrollmentDenied if the tenant doesn't exist or the enrollment key doesn't match.
+func (s *TenantService) EnrollNode(ctx context.Context, tenantID, enrollmentKey, nodeID string, attrs map[string]interface{}) (string, error) {
+	if tenantID == "" {
+		tenantID = domains.DefaultTenantID
+	}
+
+	t, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if t == nil || !t.CheckEnrollmentKey(enrollmentKey) {
+		return "", fmt.Errorf("%w: unknown tenant or wrong enrollment key", domains.ErrEnrollmentDenied)
+	}
+
+	if err := s.storage.RegisterNode(ctx, tenantID, nodeID, attrs); err != nil {
+		return "", err
+	}
+	return tenantID, nil
+}
+
+// CreateTenant validates and stores a tenant, returning the enrollment key its nodes must present
+// Only the hash of the key is stored, so the key can't be retrieved later; RotateEnrollmentKey replaces it.
+func (s *TenantService) CreateTenant(ctx context.Context, t *domains.Tenant) (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+
+	key, err := generateEnrollmentKey()
+	if err != nil {
+		return "", err
+	}
+	t.EnrollmentKeyHash = domains.HashEnrollmentKey(key)
+
+	if err := s.storage.CreateTenant(ctx, t); err != nil {
+		return "", fmt.Errorf("failed to create tenant: %w", err)
+	}
+	return key, nil
+}
+
+// GetTenant retrieves a tenant and its usage, or nil if it doesn't exist
+func (s *TenantService) GetTenant(ctx context.Context, tenantID string) (*domains.Tenant, *domains.TenantUsage, error) {
+	t, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil || t == nil {
+		return nil, nil, err
+	}
+
+	usage, err := s.storage.GetTenantUsage(ctx, tenantID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get tenant usage: %w", err)
+	}
+	return t, &usage, nil
+}
+
+// ListTenants retrieves all tenants
+func (s *TenantService) ListTenants(ctx context.Context) ([]*domains.Tenant, error) {
+	return s.storage.ListTenants(ctx)
+}
+
+// UpdateTenant changes the name and quotas of a tenant, returning it or nil if it doesn't exist
+// Fields left nil keep their value.
+func (s *TenantService) UpdateTenant(ctx context.Context, tenantID string, name *string, maxNodes, maxCommandsPerDay *int) (*domains.Tenant, error) {
+	t, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	if name != nil {
+		t.Name = *name
+	}
+	if maxNodes != nil {
+		t.MaxNodes = *maxNodes
+	}
+	if maxCommandsPerDay != nil {
+		t.MaxCommandsPerDay = *maxCommandsPerDay
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	found, err := s.storage.UpdateTenant(ctx, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tenant: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// RotateEnrollmentKey replaces the enrollment key of a tenant, returning the new key or "" if the tenant
+// doesn't exist. Enrolled nodes keep their tokens; only new enrollments need the new key.
+func (s *TenantService) RotateEnrollmentKey(ctx context.Context, tenantID string) (string, error) {
+	t, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil || t == nil {
+		return "", err
+	}
+
+	key, err := generateEnrollmentKey()
+	if err != nil {
+		return "", err
+	}
+	t.EnrollmentKeyHash = domains.HashEnrollmentKey(key)
+
+	found, err := s.storage.UpdateTenant(ctx, t)
+	if err != nil {
+		return "", fmt.Errorf("failed to update tenant: %w", err)
+	}
+	if !found {
+		return "", nil
+	}
+	return key, nil
+}
+
+// AddOperator makes an operator a member of a tenant, reporting whether the tenant exists
+func (s *TenantService) AddOperator(ctx context.Context, tenantID, operatorID string) (bool, error) {
+	t, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil || t == nil {
+		return false, err
+	}
+	if err := s.storage.AddTenantOperator(ctx, tenantID, operatorID); err != nil {
+		return false, fmt.Errorf("failed to add tenant operator: %w", err)
+	}
+	return true, nil
+}
+
+// RemoveOperator removes an operator from a tenant, reporting whether it was a member
+func (s *TenantService) RemoveOperator(ctx context.Context, tenantID, operatorID string) (bool, error) {
+	return s.storage.RemoveTenantOperator(ctx, tenantID, operatorID)
+}
+
+// ListOperators retrieves the operators of a tenant
+func (s *TenantService) ListOperators(ctx context.Context, tenantID string) ([]string, error) {
+	return s.storage.ListTenantOperators(ctx, tenantID)
+}
+
+// generateEnrollmentKey returns a random node enrollment key
+func generateEnrollmentKey() (string, error) {
+	key := make([]byte, enrollmentKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate enrollment key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
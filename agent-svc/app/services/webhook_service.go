@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// webhookEventBatch is the number of outbox events fanned out per worker tick
+	webhookEventBatch = 100
+	// webhookDeliveryBatch is the number of deliveries claimed per worker tick
+	webhookDeliveryBatch = 50
+	// webhookConcurrency bounds the deliveries sent at once
+	webhookConcurrency = 8
+	// webhookSecretBytes is the size of a generated signing secret
+	webhookSecretBytes = 32
+)
+
+// WebhookServiceConfig holds the tunables of WebhookService
+type WebhookServiceConfig struct {
+	// MaxAttempts is the number of failed attempts after which a delivery is dead-lettered
+	MaxAttempts int
+	// Timeout bounds a single delivery request
+	Timeout time.Duration
+}
+
+// WebhookService manages webhook subscriptions and delivers outbox events to them
+type WebhookService struct {
+	storage clients.StorageAdapter
+	config  WebhookServiceConfig
+	client  *http.Client
+	backoff func(attempt int) time.Duration // delay before the attempt after a failed one
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(storage clients.StorageAdapter, config WebhookServiceConfig) *WebhookService {
+	return &WebhookService{
+		storage: storage,
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		backoff: domains.WebhookBackoff,
+	}
+}
+
+// CreateWebhook validates and stores a subscription to the events of w.TenantID, generating a signing secret
+// if none is given. It returns domains.ErrTenantRequired if the subscription names no tenant.
+func (s *WebhookService) CreateWebhook(ctx context.Context, w *domains.WebhookSubscription) error {
+	if w.TenantID == "" {
+		return domains.ErrTenantRequired
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", domains.ErrInvalidWebhook)
+	}
+	for _, filter := range w.EventTypes {
+		if !domains.IsValidEventFilter(filter) {
+			return fmt.Errorf("%w: unknown event type %q", domains.ErrInvalidWebhook, filter)
+		}
+	}
+
+	if w.Secret == "" {
+		secret := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		w.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := s.storage.CreateWebhook(ctx, w); err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhook retrieves a subscription, or nil if it doesn't exist or belongs to another tenant than a
+// non-empty tenantID
+func (s *WebhookService) GetWebhook(ctx context.Context, tenantID string, webhookID uuid.UUID) (*domains.WebhookSubscription, error) {
+	w, err := s.storage.GetWebhook(ctx, webhookID)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	if tenantID != "" && w.TenantID != tenantID {
+		return nil, nil
+	}
+	return w, nil
+}
+
+// ListWebhooks retrieves the subscriptions of a tenant, or of every tenant if tenantID is empty
+func (s *WebhookService) ListWebhooks(ctx context.Context, tenantID string) ([]*domains.WebhookSubscription, error) {
+	return s.storage.ListWebhooks(ctx, tenantID)
+}
+
+// SetWebhookEnabled enables or disables a subscription, returning it or nil if it doesn't exist or belongs
+// to another tenant than a non-empty tenantID
+func (s *WebhookService) SetWebhookEnabled(ctx context.Context, tenantID string, webhookID uuid.UUID, enabled bool) (*domains.WebhookSubscription, error) {
+	w, err := s.GetWebhook(ctx, tenantID, webhookID)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	found, err := s.storage.SetWebhookEnabled(ctx, webhookID, enabled)
+	if err != nil || !found {
+		return nil, err
+	}
+	return s.storage.GetWebhook(ctx, webhookID)
+}
+
+// DeleteWebhook deletes a subscription and its delivery log, reporting whether it existed in a non-empty
+// tenantID, or in any tenant
+func (s *WebhookService) DeleteWebhook(ctx context.Context, tenantID string, webhookID uuid.UUID) (bool, error) {
+	w, err := s.GetWebhook(ctx, tenantID, webhookID)
+	if err != nil || w == nil {
+		return false, err
+	}
+	return s.storage.DeleteWebhook(ctx, webhookID)
+}
+
+// ListDeliveries retrieves the delivery log of a subscription, newest first
+func (s *WebhookService) ListDeliveries(ctx context.Context, webhookID uuid.UUID, status *string, limit int) ([]*domains.WebhookDelivery, error) {
+	return s.storage.ListWebhookDeliveries(ctx, webhookID, status, limit)
+}
+
+// RetryDelivery makes a dead delivery of a subscription due again, returning it or nil if it doesn't exist or
+// the subscription belongs to another tenant than a non-empty tenantID
+// The delivery keeps its attempt count, so it is dead-lettered again if the next attempt fails.
+func (s *WebhookService) RetryDelivery(ctx context.Context, tenantID string, webhookID, deliveryID uuid.UUID) (*domains.WebhookDelivery, error) {
+	w, err := s.GetWebhook(ctx, tenantID, webhookID)
+	if err != nil || w == nil {
+		return nil, err
+	}
+
+	delivery, err := s.storage.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil || delivery == nil || delivery.WebhookID != webhookID {
+		return nil, err
+	}
+
+	retried, err := s.storage.RetryWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if !retried {
+		return nil, fmt.Errorf("%w: delivery is %s", domains.ErrWebhookDeliveryState, delivery.Status)
+	}
+	return s.storage.GetWebhookDelivery(ctx, deliveryID)
+}
+
+// DeliverWebhooks fans new outbox events out to matching subscriptions and sends the deliveries that are due
+// It returns the number of deliveries attempted.
+func (s *WebhookService) DeliverWebhooks(ctx context.Context) (int, error) {
+	if _, err := s.storage.FanOutWebhookEvents(ctx, webhookEventBatch); err != nil {
+		return 0, fmt.Errorf("failed to fan out webhook events: %w", err)
+	}
+
+	// Leave room past the request timeout for recording the outcome before another replica may claim it
+	lease := 2*s.config.Timeout + 30*time.Second
+	jobs, err := s.storage.ClaimWebhookDeliveries(ctx, webhookDeliveryBatch, lease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job domains.WebhookDeliveryJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := s.deliver(ctx, job); err != nil {
+				fmt.Printf("failed to record webhook delivery %s: %v\n", job.DeliveryID, err)
+			}
+		}(job)
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+// deliver makes one attempt at a delivery and records its outcome: delivered on a 2xx response, otherwise
+// retried with backoff until MaxAttempts is reached and then dead-lettered
+func (s *WebhookService) deliver(ctx context.Context, job domains.WebhookDeliveryJob) error {
+	responseCode, sendErr := s.send(ctx, job)
+	if sendErr == nil {
+		return s.storage.RecordWebhookDeliveryAttempt(ctx, job.DeliveryID, job.Attempt, domains.DeliveryDelivered, responseCode, nil, nil)
+	}
+
+	errorMsg := sendErr.Error()
+	if job.Attempt >= s.config.MaxAttempts {
+		return s.storage.RecordWebhookDeliveryAttempt(ctx, job.DeliveryID, job.Attempt, domains.DeliveryDead, responseCode, &errorMsg, nil)
+	}
+	next := time.Now().Add(s.backoff(job.Attempt))
+	return s.storage.RecordWebhookDeliveryAttempt(ctx, job.DeliveryID, job.Attempt, domains.DeliveryPending, responseCode, &errorMsg, &next)
+}
+
+// send POSTs an event to the subscription URL, signed with the subscription secret
+// The X-Webhook-Signature header is "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>",
+// where timestamp is the X-Webhook-Timestamp header; receivers should also reject stale timestamps.
+func (s *WebhookService) send(ctx context.Context, job domains.WebhookDeliveryJob) (*int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"event_id":   job.Event.EventID.String(),
+		"event_type": job.Event.EventType,
+		"created_at": job.Event.CreatedAt.UTC().Format(time.RFC3339),
+		"data":       job.Event.Payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(job.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "agent-svc-webhooks")
+	req.Header.Set("X-Webhook-Event", job.Event.EventType)
+	req.Header.Set("X-Webhook-Delivery", job.DeliveryID.String())
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	code := resp.StatusCode
+	if code < 200 || code >= 300 {
+		return &code, fmt.Errorf("receiver responded with %d", code)
+	}
+	return &code, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"agent-svc/app/domains"
+	"agent-svc/storage/memory"
+)
+
+const testWebhookSecret = "test-secret"
+
+// webhookReceiver is an httptest receiver that checks signatures and fails the first failures requests
+type webhookReceiver struct {
+	t        *testing.T
+	failures int
+
+	mu       sync.Mutex
+	requests int
+	events   []string
+	delivery map[string]bool
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rcv.t.Errorf("reading webhook body: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(r.Header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)) {
+		rcv.t.Errorf("X-Webhook-Signature = %q, want %q", r.Header.Get("X-Webhook-Signature"), want)
+	}
+	var event struct {
+		EventType string `json:"event_type"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil || event.EventType != r.Header.Get("X-Webhook-Event") {
+		rcv.t.Errorf("event %s with X-Webhook-Event %s: %v", body, r.Header.Get("X-Webhook-Event"), err)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests++
+	rcv.events = append(rcv.events, event.EventType)
+	rcv.delivery[r.Header.Get("X-Webhook-Delivery")] = true
+	if rcv.requests <= rcv.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setupWebhookDelivery subscribes a receiver failing its first failures requests to command.success and
+// finishes a command, so one delivery to it is pending
+func setupWebhookDelivery(t *testing.T, failures, maxAttempts int) (*WebhookService, *memory.Store, *domains.WebhookSubscription, *webhookReceiver) {
+	t.Helper()
+	ctx := context.Background()
+	rcv := &webhookReceiver{t: t, failures: failures, delivery: map[string]bool{}}
+	server := httptest.NewServer(rcv)
+	t.Cleanup(server.Close)
+
+	store := memory.NewStore()
+	svc := NewWebhookService(store, WebhookServiceConfig{MaxAttempts: maxAttempts, Timeout: 5 * time.Second})
+	svc.backoff = func(int) time.Duration { return 0 }
+
+	w := &domains.WebhookSubscription{TenantID: domains.DefaultTenantID, URL: server.URL, EventTypes: []string{"command.success"}, Secret: testWebhookSecret, Enabled: true}
+	if err := svc.CreateWebhook(ctx, w); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	if err := store.RegisterNode(ctx, domains.DefaultTenantID, "node-1", nil); err != nil {
+		t.Fatalf("RegisterNode: %v", err)
+	}
+	commandID, err := store.CreateCommand(ctx, "node-1", "RunCommand", map[string]interface{}{"cmd": "true"}, domains.CommandOptions{})
+	if err != nil {
+		t.Fatalf("CreateCommand: %v", err)
+	}
+	if _, err := store.GetNextCommand(ctx, "node-1"); err != nil {
+		t.Fatalf("GetNextCommand: %v", err)
+	}
+	exitCode := 0
+	if err := store.UpdateCommandStatus(ctx, commandID, domains.StatusSuccess, &exitCode, nil, domains.SourceAgent); err != nil {
+		t.Fatalf("UpdateCommandStatus: %v", err)
+	}
+	return svc, store, w, rcv
+}
+
+// deliverAll runs the webhook worker until it has nothing left to attempt
+func deliverAll(t *testing.T, svc *WebhookService) {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		attempted, err := svc.DeliverWebhooks(context.Background())
+		if err != nil {
+			t.Fatalf("DeliverWebhooks: %v", err)
+		}
+		if attempted == 0 && i > 0 {
+			return
+		}
+	}
+	t.Fatal("webhook worker kept finding deliveries")
+}
+
+// onlyDelivery returns the single delivery of a subscription
+func onlyDelivery(t *testing.T, svc *WebhookService, w *domains.WebhookSubscription) *domains.WebhookDelivery {
+	t.Helper()
+	deliveries, err := svc.ListDeliveries(context.Background(), w.WebhookID, nil, 10)
+	if err != nil {
+		t.Fatalf("ListDeliveries: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestWebhookDeliveryRetriedUntilDelivered(t *testing.T) {
+	svc, _, w, rcv := setupWebhookDelivery(t, 2, 5)
+	deliverAll(t, svc)
+
+	d := onlyDelivery(t, svc, w)
+	if d.Status != domains.DeliveryDelivered || d.Attempts != 3 {
+		t.Errorf("delivery %s after %d attempts, want delivered after 3", d.Status, d.Attempts)
+	}
+	if d.LastResponseCode == nil || *d.LastResponseCode != http.StatusNoContent {
+		t.Errorf("last response code %v, want %d", d.LastResponseCode, http.StatusNoContent)
+	}
+	if rcv.requests != 3 || len(rcv.delivery) != 1 || rcv.events[0] != "command.success" {
+		t.Errorf("receiver got %d requests for deliveries %v, events %v; want 3 for one delivery of command.success",
+			rcv.requests, rcv.delivery, rcv.events)
+	}
+}
+
+func TestWebhookDeliveryDeadLettered(t *testing.T) {
+	svc, _, w, rcv := setupWebhookDelivery(t, 100, 3)
+	deliverAll(t, svc)
+
+	d := onlyDelivery(t, svc, w)
+	if d.Status != domains.DeliveryDead || d.Attempts != 3 || d.LastError == nil {
+		t.Errorf("delivery %s after %d attempts, error %v; want dead after 3 with an error", d.Status, d.Attempts, d.LastError)
+	}
+	if rcv.requests != 3 {
+		t.Errorf("receiver got %d requests, want 3", rcv.requests)
+	}
+
+	// A dead delivery is only attempted again on request, and dead-lettered again when that attempt fails
+	deliverAll(t, svc)
+	if rcv.requests != 3 {
+		t.Errorf("receiver got %d requests after dead-lettering, want 3", rcv.requests)
+	}
+	if _, err := svc.RetryDelivery(context.Background(), domains.DefaultTenantID, w.WebhookID, d.DeliveryID); err != nil {
+		t.Fatalf("RetryDelivery: %v", err)
+	}
+	deliverAll(t, svc)
+	d = onlyDelivery(t, svc, w)
+	if d.Status != domains.DeliveryDead || d.Attempts != 4 || rcv.requests != 4 {
+		t.Errorf("retried delivery %s after %d attempts and %d requests, want dead after 4", d.Status, d.Attempts, rcv.requests)
+	}
+}
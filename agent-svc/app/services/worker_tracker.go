@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"agent-svc/app/domains"
+)
+
+// workerStallGrace is added to twice the interval before a worker without a run is reported stalled
+const workerStallGrace = time.Minute
+
+// WorkerTracker records the runs of the background workers for the readiness check
+type WorkerTracker struct {
+	mu      sync.Mutex
+	workers map[string]*trackedWorker
+}
+
+type trackedWorker struct {
+	interval      time.Duration
+	registeredAt  time.Time
+	lastRunAt     time.Time
+	lastSuccessAt time.Time
+	lastError     string
+}
+
+// NewWorkerTracker creates a new worker tracker
+func NewWorkerTracker() *WorkerTracker {
+	return &WorkerTracker{workers: make(map[string]*trackedWorker)}
+}
+
+// Register adds a worker that runs every interval
+func (t *WorkerTracker) Register(name string, interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.workers[name] = &trackedWorker{interval: interval, registeredAt: time.Now()}
+}
+
+// Report records a finished run of a worker; err is nil if the run succeeded
+func (t *WorkerTracker) Report(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.workers[name]
+	if !ok {
+		return
+	}
+	w.lastRunAt = time.Now()
+	if err != nil {
+		w.lastError = err.Error()
+		return
+	}
+	w.lastSuccessAt, w.lastError = w.lastRunAt, ""
+}
+
+// Health returns the state of every registered worker at now, ordered by name
+func (t *WorkerTracker) Health(now time.Time) []domains.WorkerHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	workers := make([]domains.WorkerHealth, 0, len(t.workers))
+	for name, w := range t.workers {
+		h := domains.WorkerHealth{
+			Name:      name,
+			Status:    domains.WorkerOK,
+			Interval:  w.interval,
+			LastError: w.lastError,
+		}
+		if !w.lastRunAt.IsZero() {
+			lastRunAt := w.lastRunAt
+			h.LastRunAt = &lastRunAt
+		}
+		if !w.lastSuccessAt.IsZero() {
+			lastSuccessAt := w.lastSuccessAt
+			h.LastSuccessAt = &lastSuccessAt
+		}
+
+		since := w.registeredAt
+		if h.LastRunAt != nil {
+			since = *h.LastRunAt
+		}
+		if now.Sub(since) > 2*w.interval+workerStallGrace {
+			h.Status = domains.WorkerStalled
+			if h.LastError == "" {
+				h.LastError = fmt.Sprintf("no run since %s", since.Format(time.RFC3339))
+			}
+		} else if w.lastError != "" {
+			h.Status = domains.WorkerFailing
+		}
+		workers = append(workers, h)
+	}
+
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return workers
+}
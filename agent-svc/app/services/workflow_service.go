@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+	"agent-svc/app/utils"
+
+	"github.com/google/uuid"
+)
+
+// stepSubmitGrace is how long a running step may have no commands before it is considered failed;
+// it covers the window between a step being claimed and its commands being submitted
+const stepSubmitGrace = time.Minute
+
+// WorkflowService runs multi-step workflows
+//
+// A workflow is advanced whenever one of its commands finishes and by a periodic reconcile, which also
+// picks up workflows that were in flight when agent-svc restarted. Every step transition is a
+// compare-and-set on the persisted step status, so concurrent engines on several replicas are safe.
+type WorkflowService struct {
+	storage        clients.StorageAdapter
+	commandService *CommandService
+}
+
+// NewWorkflowService creates a new workflow service and subscribes it to command completions
+func NewWorkflowService(storage clients.StorageAdapter, commandService *CommandService) *WorkflowService {
+	s := &WorkflowService{
+		storage:        storage,
+		commandService: commandService,
+	}
+	commandService.OnCommandFinished(s.onCommandFinished)
+	return s
+}
+
+// StartWorkflow validates a definition, persists the workflow run in a tenant and starts its entry steps
+// Steps target only the tenant's nodes. It returns domains.ErrTenantRequired if tenantID is empty.
+func (s *WorkflowService) StartWorkflow(ctx context.Context, tenantID string, def domains.WorkflowDefinition) (*domains.Workflow, error) {
+	if tenantID == "" {
+		return nil, domains.ErrTenantRequired
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	for _, step := range def.Steps {
+		if err := utils.ValidateCommandPayload(step.CommandType, step.Payload); err != nil {
+			return nil, fmt.Errorf("%w: step %q: payload validation failed: %v", domains.ErrInvalidWorkflow, step.ID, err)
+		}
+	}
+
+	wf := &domains.Workflow{
+		TenantID:   tenantID,
+		Name:       def.Name,
+		Definition: def,
+		Status:     domains.WorkflowRunning,
+	}
+	if err := s.storage.CreateWorkflow(ctx, wf); err != nil {
+		return nil, fmt.Errorf("failed to create workflow: %w", err)
+	}
+
+	if err := s.advance(ctx, wf); err != nil {
+		return wf, err
+	}
+	return wf, nil
+}
+
+// GetWorkflow retrieves a workflow run and its step states, or nil if it doesn't exist or belongs to another
+// tenant than a non-empty tenantID
+func (s *WorkflowService) GetWorkflow(ctx context.Context, tenantID string, workflowID uuid.UUID) (*domains.Workflow, []domains.WorkflowStepState, error) {
+	wf, err := s.storage.GetWorkflow(ctx, workflowID)
+	if err != nil || wf == nil {
+		return nil, nil, err
+	}
+	if tenantID != "" && wf.TenantID != tenantID {
+		return nil, nil, nil
+	}
+
+	steps, err := s.storage.ListWorkflowSteps(ctx, workflowID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get workflow steps: %w", err)
+	}
+	return wf, steps, nil
+}
+
+// ListWorkflows retrieves the most recent workflow runs of a tenant, or of every tenant if tenantID is empty,
+// optionally filtered by status
+func (s *WorkflowService) ListWorkflows(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Workflow, error) {
+	return s.storage.ListWorkflows(ctx, tenantID, status, limit)
+}
+
+// ReconcileWorkflows advances every running workflow
+func (s *WorkflowService) ReconcileWorkflows(ctx context.Context) error {
+	status := domains.WorkflowRunning
+	workflows, err := s.storage.ListWorkflows(ctx, "", &status, 500)
+	if err != nil {
+		return fmt.Errorf("failed to list running workflows: %w", err)
+	}
+
+	for _, wf := range workflows {
+		if err := s.advance(ctx, wf); err != nil {
+			fmt.Printf("workflow %s: %v\n", wf.WorkflowID, err)
+		}
+	}
+	return nil
+}
+
+// onCommandFinished advances the workflow a finished command belongs to
+func (s *WorkflowService) onCommandFinished(ctx context.Context, cmd *domains.NodeCommand) {
+	if cmd.WorkflowID == nil || cmd.NextRetryAt != nil {
+		return
+	}
+
+	wf, err := s.storage.GetWorkflow(ctx, *cmd.WorkflowID)
+	if err != nil || wf == nil || wf.Status != domains.WorkflowRunning {
+		return
+	}
+	if err := s.advance(ctx, wf); err != nil {
+		fmt.Printf("workflow %s: %v\n", wf.WorkflowID, err)
+	}
+}
+
+// advance finishes running steps whose commands are done, starts or skips pending steps whose
+// predecessors are done, and finishes the workflow once every step is done. It repeats until
+// nothing changes, since skipping or failing a step can make further steps ready.
+func (s *WorkflowService) advance(ctx context.Context, wf *domains.Workflow) error {
+	def := &wf.Definition
+	for {
+		states, err := s.storage.ListWorkflowSteps(ctx, wf.WorkflowID)
+		if err != nil {
+			return fmt.Errorf("failed to get workflow steps: %w", err)
+		}
+		statuses := make(map[string]string, len(states))
+		for _, state := range states {
+			statuses[state.StepID] = state.Status
+		}
+
+		changed := false
+		for _, state := range states {
+			var moved bool
+			switch state.Status {
+			case domains.StepRunning:
+				moved, err = s.finishStepIfDone(ctx, wf, state)
+			case domains.StepPending:
+				moved, err = s.startStepIfReady(ctx, wf, def.Step(state.StepID), statuses)
+			}
+			if err != nil {
+				return err
+			}
+			changed = changed || moved
+		}
+		if changed {
+			continue
+		}
+
+		for _, state := range states {
+			if !domains.IsStepFinished(state.Status) {
+				return nil
+			}
+		}
+		_, err = s.storage.FinishWorkflow(ctx, wf.WorkflowID, workflowOutcome(def, statuses))
+		return err
+	}
+}
+
+// finishStepIfDone marks a running step succeeded or failed once all of its commands have finished
+func (s *WorkflowService) finishStepIfDone(ctx context.Context, wf *domains.Workflow, state domains.WorkflowStepState) (bool, error) {
+	outcome, err := s.storage.GetWorkflowStepOutcome(ctx, wf.WorkflowID, state.StepID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get outcome of step %q: %w", state.StepID, err)
+	}
+
+	if outcome.Commands == 0 {
+		// The engine that claimed the step stopped before submitting its commands
+		if state.StartedAt == nil || time.Since(*state.StartedAt) < stepSubmitGrace {
+			return false, nil
+		}
+		errorMsg := "no commands were submitted for the step"
+		return s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, state.StepID, domains.StepRunning, domains.StepFailed, &errorMsg)
+	}
+	if outcome.Finished < outcome.Commands {
+		return false, nil
+	}
+
+	// A step whose command could not be submitted to some targets has its error_msg set and fails
+	if outcome.Succeeded == outcome.Commands && state.ErrorMsg == nil {
+		return s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, state.StepID, domains.StepRunning, domains.StepSucceeded, nil)
+	}
+	var errorMsg *string
+	if outcome.Succeeded < outcome.Commands {
+		msg := fmt.Sprintf("%d of %d commands did not succeed", outcome.Commands-outcome.Succeeded, outcome.Commands)
+		errorMsg = &msg
+	}
+	return s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, state.StepID, domains.StepRunning, domains.StepFailed, errorMsg)
+}
+
+// startStepIfReady starts a pending step once all of its predecessors have finished and one of them took
+// an edge to it, or skips it if none did
+func (s *WorkflowService) startStepIfReady(ctx context.Context, wf *domains.Workflow, step *domains.WorkflowStep, statuses map[string]string) (bool, error) {
+	if step == nil {
+		return false, nil
+	}
+
+	preds := wf.Definition.Predecessors(step.ID)
+	triggered := len(preds) == 0
+	for _, pred := range preds {
+		if !domains.IsStepFinished(statuses[pred]) {
+			return false, nil
+		}
+		if wf.Definition.Triggers(pred, statuses[pred], step.ID) {
+			triggered = true
+		}
+	}
+
+	if !triggered {
+		return s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, step.ID, domains.StepPending, domains.StepSkipped, nil)
+	}
+
+	claimed, err := s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, step.ID, domains.StepPending, domains.StepRunning, nil)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	commandIDs, submitErr := s.submitStep(ctx, wf, step)
+	if err := s.storage.SetWorkflowStepCommands(ctx, wf.WorkflowID, step.ID, commandIDs); err != nil {
+		return true, fmt.Errorf("failed to record commands of step %q: %w", step.ID, err)
+	}
+	if submitErr != nil {
+		// With no commands the step fails now; otherwise it fails once the submitted commands finish
+		to := domains.StepRunning
+		if len(commandIDs) == 0 {
+			to = domains.StepFailed
+		}
+		errorMsg := submitErr.Error()
+		if _, err := s.storage.TransitionWorkflowStep(ctx, wf.WorkflowID, step.ID, domains.StepRunning, to, &errorMsg); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// submitStep submits a step's command to every target node of the workflow's tenant
+// It returns the created command IDs and, if some targets failed, an error describing them.
+func (s *WorkflowService) submitStep(ctx context.Context, wf *domains.Workflow, step *domains.WorkflowStep) ([]uuid.UUID, error) {
+	nodeIDs, err := s.commandService.ResolveTargets(ctx, wf.TenantID, step.Target())
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeIDs) == 0 {
+		return nil, fmt.Errorf("no nodes match the step target")
+	}
+
+	var commandIDs []uuid.UUID
+	var errs []string
+	for _, nodeID := range nodeIDs {
+		// SubmitCommand fills in payload defaults, so each node gets its own copy
+		payload := make(map[string]interface{}, len(step.Payload))
+		for k, v := range step.Payload {
+			payload[k] = v
+		}
+
+		commandID, _, err := s.commandService.SubmitCommand(ctx, step.CommandType, nodeID, payload, domains.CommandOptions{
+			WorkflowID:     &wf.WorkflowID,
+			WorkflowStepID: step.ID,
+			TenantID:       wf.TenantID,
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		commandIDs = append(commandIDs, commandID)
+	}
+
+	if len(errs) > 0 {
+		return commandIDs, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return commandIDs, nil
+}
+
+// workflowOutcome returns failed if a step failed without an on_failure edge to handle it
+func workflowOutcome(def *domains.WorkflowDefinition, statuses map[string]string) string {
+	for _, step := range def.Steps {
+		if statuses[step.ID] == domains.StepFailed && len(step.OnFailure) == 0 {
+			return domains.WorkflowFailed
+		}
+	}
+	return domains.WorkflowSucceeded
+}
@@ -2,8 +2,15 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"agent-svc/app/domains"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -19,6 +26,7 @@ func init() {
 		}
 		return name
 	})
+	validate.RegisterValidation("regexp", validateRegexp)
 }
 
 // ValidateStruct validates a struct using go-playground/validator
@@ -64,9 +72,11 @@ func getCommandRegistry() map[string]interface{} {
 	// For now, we'll define it here to avoid circular imports
 	return map[string]interface{}{
 		"RunCommand": struct {
-			Cmd        string   `json:"cmd" validate:"required"`
-			Args       []string `json:"args,omitempty"`
-			TimeoutSec int      `json:"timeout_sec,omitempty"`
+			Cmd            string   `json:"cmd" validate:"required"`
+			Args           []string `json:"args,omitempty"`
+			TimeoutSec     int      `json:"timeout_sec,omitempty"`
+			MaxOutputBytes int64    `json:"max_output_bytes,omitempty" validate:"omitempty,min=1"`
+			WorkingDir     string   `json:"working_dir,omitempty"`
 		}{},
 		"UpdateAgent": struct {
 			Version string `json:"version" validate:"required"`
@@ -78,3 +88,126 @@ func getCommandRegistry() map[string]interface{} {
 		}{},
 	}
 }
+
+// patternCache holds the compiled patterns of the regexp validation, keyed by pattern
+var patternCache sync.Map
+
+// validateRegexp checks that a string matches the regular expression given as the tag parameter in full
+func validateRegexp(fl validator.FieldLevel) bool {
+	pattern := fl.Param()
+	re, ok := patternCache.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return false
+		}
+		re, _ = patternCache.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(fl.Field().String())
+}
+
+// ValidateTemplateParams validates params against a template's parameter schema and returns them with
+// defaults filled in and values converted to their declared types
+// The schema is turned into a struct with validate tags, so params are checked by ValidateStruct like
+// any request body.
+func ValidateTemplateParams(schema []domains.TemplateParam, params map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(schema))
+	known := make(map[string]bool, len(schema))
+	fields := make([]reflect.StructField, len(schema))
+	for i, p := range schema {
+		known[p.Name] = true
+		if v, ok := params[p.Name]; ok && v != nil {
+			values[p.Name] = v
+		} else if p.Default != nil {
+			values[p.Name] = p.Default
+		}
+
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("P%d", i),
+			Type: reflect.PtrTo(paramGoType(p.Type)),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q validate:%q`, p.Name, paramTag(p))),
+		}
+	}
+	for name := range params {
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown parameter %q", domains.ErrInvalidTemplateParams, name)
+		}
+	}
+
+	instance := reflect.New(reflect.StructOf(fields))
+	jsonBytes, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domains.ErrInvalidTemplateParams, err)
+	}
+	if err := json.Unmarshal(jsonBytes, instance.Interface()); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%w: parameter %q must be of type %s", domains.ErrInvalidTemplateParams, typeErr.Field, paramTypeOf(schema, typeErr.Field))
+		}
+		return nil, fmt.Errorf("%w: %v", domains.ErrInvalidTemplateParams, err)
+	}
+	if err := ValidateStruct(instance.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %v", domains.ErrInvalidTemplateParams, err)
+	}
+
+	typed := make(map[string]interface{}, len(schema))
+	for i, p := range schema {
+		if field := instance.Elem().Field(i); !field.IsNil() {
+			typed[p.Name] = field.Elem().Interface()
+		}
+	}
+	return typed, nil
+}
+
+// paramGoType returns the Go type a template parameter is decoded into
+func paramGoType(paramType string) reflect.Type {
+	switch paramType {
+	case domains.ParamInteger:
+		return reflect.TypeOf(int64(0))
+	case domains.ParamNumber:
+		return reflect.TypeOf(float64(0))
+	case domains.ParamBoolean:
+		return reflect.TypeOf(false)
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+// paramTypeOf returns the declared type of the named parameter
+func paramTypeOf(schema []domains.TemplateParam, name string) string {
+	for _, p := range schema {
+		if p.Name == name {
+			return p.Type
+		}
+	}
+	return ""
+}
+
+// paramTag builds the validate tag of a template parameter
+// Commas and pipes in patterns are escaped the way the validator expects in tag parameters.
+func paramTag(p domains.TemplateParam) string {
+	tags := []string{"omitempty"}
+	if p.Required {
+		tags = []string{"required"}
+	}
+	if p.Min != nil {
+		tags = append(tags, "min="+strconv.FormatFloat(*p.Min, 'f', -1, 64))
+	}
+	if p.Max != nil {
+		tags = append(tags, "max="+strconv.FormatFloat(*p.Max, 'f', -1, 64))
+	}
+	if len(p.Enum) > 0 {
+		tags = append(tags, "oneof="+strings.Join(p.Enum, " "))
+	}
+	if p.Pattern != "" {
+		pattern := strings.NewReplacer(",", "0x2C", "|", "0x7C").Replace(p.Pattern)
+		tags = append(tags, "regexp="+pattern)
+	}
+	return strings.Join(tags, ",")
+}
+
+// IsKnownCommandType reports whether a command type is registered
+func IsKnownCommandType(commandType string) bool {
+	_, exists := getCommandRegistry()[commandType]
+	return exists
+}
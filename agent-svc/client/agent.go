@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"agent-svc/client/dto"
+)
+
+// Register enrolls a node and returns the token it authenticates with
+// Registering an enrolled node again refreshes its attributes and issues a new token.
+func (c *Client) Register(ctx context.Context, req dto.RegisterRequest) (*dto.RegisterResponse, error) {
+	var resp dto.RegisterResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/v1/agents/register", body: req, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat reports that a node is alive, along with the hash of its local policy file, "" if it has none
+// It returns an APIError with status 404 if agent-svc doesn't know the node, which must then register again.
+func (c *Client) Heartbeat(ctx context.Context, nodeID, policyHash string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/v1/agents/heartbeat",
+		body:       dto.HeartbeatRequest{NodeID: nodeID, PolicyHash: policyHash},
+		idempotent: true,
+	}, nil)
+}
+
+// PollCommands waits up to wait for commands queued for the authenticated node, returning none if the
+// wait ends first
+// Returned commands are marked dispatched, so a poll is never retried.
+func (c *Client) PollCommands(ctx context.Context, wait time.Duration) ([]dto.CommandResponse, error) {
+	query := url.Values{}
+	if seconds := int(wait / time.Second); seconds > 0 {
+		query.Set("wait", strconv.Itoa(seconds))
+	}
+
+	var resp dto.CommandsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/commands/next", query: query}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Commands, nil
+}
+
+// PushCommandLogs uploads log chunks of a command and returns the chunk indexes agent-svc stored
+// Chunks already stored are acknowledged again, so pushes are safe to retry.
+func (c *Client) PushCommandLogs(ctx context.Context, req dto.PushCommandLogsRequest) ([]int64, error) {
+	var resp dto.PushCommandLogsResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/v1/commands/logs", body: req, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.AckedOffsets, nil
+}
+
+// UpdateCommandStatus reports the status of a command dispatched to the authenticated node
+// A transition the command's current status doesn't allow returns an APIError with status 409.
+func (c *Client) UpdateCommandStatus(ctx context.Context, req dto.CommandStatusRequest) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/v1/commands/status", body: req}, nil)
+}
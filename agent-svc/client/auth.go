@@ -0,0 +1,70 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Authenticator adds credentials to a request before it is sent
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthFunc adapts a function to an Authenticator
+type AuthFunc func(req *http.Request) error
+
+// Authenticate calls f(req)
+func (f AuthFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// TokenAuth sends a bearer token, such as the JWT a node receives from Register
+// The token may be replaced while requests are in flight, e.g. after the node re-registers.
+type TokenAuth struct {
+	mu    sync.RWMutex
+	token string
+}
+
+// NewTokenAuth creates a TokenAuth sending token; an empty token sends no Authorization header
+func NewTokenAuth(token string) *TokenAuth {
+	return &TokenAuth{token: token}
+}
+
+// SetToken replaces the token sent with subsequent requests
+func (a *TokenAuth) SetToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = token
+}
+
+// Token returns the current token
+func (a *TokenAuth) Token() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.token
+}
+
+// Authenticate sets the Authorization header
+func (a *TokenAuth) Authenticate(req *http.Request) error {
+	if token := a.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// OperatorAuth authenticates operator calls with an operator token
+type OperatorAuth struct {
+	Token    string // operator token, issued with agent-svc's cmd/operator-token; it names the operator
+	TenantID string // sent as X-Tenant-ID; empty leaves the tenant to agent-svc
+}
+
+// Authenticate sets the operator headers
+func (a OperatorAuth) Authenticate(req *http.Request) error {
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	if a.TenantID != "" {
+		req.Header.Set("X-Tenant-ID", a.TenantID)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package client is a typed Go client for the agent-svc API
+// Requests and responses are the dto types agent-svc itself serves, so callers and the service can't drift
+// apart. Operator calls are in operator.go and the calls node agents make are in agent.go.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"agent-svc/client/dto"
+)
+
+// Version is the version of this module, sent in the User-Agent header
+const Version = "0.1.0"
+
+// Client calls the agent-svc API
+// A Client is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	auth       Authenticator
+	retry      RetryPolicy
+	userAgent  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are sent with
+// Its timeout must exceed the wait of PollCommands; the default client allows 90 seconds.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAuth sets how requests are authenticated; without it requests are sent anonymously
+func WithAuth(auth Authenticator) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithRetry sets the retry policy of idempotent requests; without it no request is retried
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithUserAgent sets the User-Agent header, which defaults to agent-svc-client/<Version>
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New creates a client for the agent-svc at baseURL, such as http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 90 * time.Second,
+		},
+		userAgent: "agent-svc-client/" + Version,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is returned when agent-svc answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+	Details    map[string]string
+}
+
+func (e *APIError) Error() string {
+	if detail := e.Details["error"]; detail != "" {
+		return fmt.Sprintf("agent-svc returned %d: %s: %s", e.StatusCode, e.Message, detail)
+	}
+	return fmt.Sprintf("agent-svc returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is an APIError with status 404
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether err is an APIError with status 409
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// hasStatus reports whether err is an APIError with the given status
+func hasStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// request describes a single API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// idempotent requests may be retried after a network error or a retryable status
+	idempotent bool
+}
+
+// do sends a request, retrying it according to the retry policy, and decodes the response into out
+// out may be nil when the response body isn't needed.
+func (c *Client) do(ctx context.Context, r request, out interface{}) error {
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	target := c.baseURL + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.send(ctx, r.method, target, body, out)
+		if err == nil || !r.idempotent || !c.retry.shouldRetry(attempt, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.retry.backoff(attempt)):
+		}
+	}
+}
+
+// send makes one attempt of a request
+func (c *Client) send(ctx context.Context, method, target string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.auth != nil {
+		if err := c.auth.Authenticate(req); err != nil {
+			return fmt.Errorf("failed to authenticate request: %w", err)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// decodeError builds the APIError of a failed response, falling back to the raw body if it isn't JSON
+func decodeError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var body dto.ErrorResponse
+	if err := json.Unmarshal(raw, &body); err != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(raw))
+		if body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: body.Error, Details: body.Details}
+}
@@ -0,0 +1,374 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrAmbiguousCommandLine is returned for a command line the splitter can't be sure it splits as the shell would
+var ErrAmbiguousCommandLine = errors.New("command line can't be split reliably")
+
+// assignmentPattern matches a shell variable assignment preceding a command, as in FOO=bar cmd
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// reservedWords are the shell reserved words that may precede a command, as if does in if rm -rf /; then :; fi
+var reservedWords = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "fi": true, "do": true, "done": true, "while": true,
+	"until": true, "esac": true, "!": true, "{": true, "}": true, "coproc": true,
+}
+
+// clauseHeaders are the reserved words starting a clause whose words up to the next separator are no command,
+// such as the list a for loop iterates over
+var clauseHeaders = map[string]bool{"for": true, "select": true, "case": true}
+
+// shells whose -c script is split into commands of its own
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "ash": true, "zsh": true, "ksh": true}
+
+// wrappers are commands that run another command given as their arguments, with the options of each that take
+// a separate argument and the number of operands preceding the command, such as the duration of timeout
+var wrappers = map[string]struct {
+	argOptions string
+	operands   int
+}{
+	"sudo":    {argOptions: "CDghpRrTtUu"},
+	"doas":    {argOptions: "Cu"},
+	"env":     {argOptions: "CSu"},
+	"nohup":   {},
+	"nice":    {argOptions: "n"},
+	"ionice":  {argOptions: "cnp"},
+	"time":    {argOptions: "fo"},
+	"timeout": {argOptions: "ks", operands: 1},
+	"stdbuf":  {argOptions: "eio"},
+	"exec":    {argOptions: "a"},
+	"command": {},
+	"xargs":   {argOptions: "adEIiLlnPs"},
+	"chroot":  {operands: 1},
+}
+
+// maxCommandLineDepth bounds how deeply nested scripts are split, e.g. bash -c within $( ); deeper ones are
+// ambiguous
+const maxCommandLineDepth = 8
+
+// SplitCommandLine splits a shell command line, as node-agent runs it with sh -c, into its simple commands
+// Commands are separated at ;, &, |, newlines and parentheses, and the words of each are unquoted the way the
+// shell does; the patterns of case items are skipped. Command substitutions, the -c script of a shell, the
+// arguments of eval and the command run by a wrapper such as sudo, env or timeout are split into commands of
+// their own, in addition to appearing as words of the command containing them. Leading reserved words such as
+// if, then and do and leading variable assignments are dropped and the executable is reduced to its base name,
+// so if /bin/rm -rf /; then :; fi becomes [rm -rf /] and [:]. Expansions such as $HOME are kept as written.
+//
+// What the splitter can't model fails with ErrAmbiguousCommandLine rather than being split wrongly: unterminated
+// quotes and substitutions, here-documents, a ) that ends no subshell or case pattern, scripts nested more than
+// 8 levels deep, and quotes, backslashes, comments, case clauses or further substitutions inside a $( ) or
+// backtick substitution, whose end the splitter finds without parsing it.
+func SplitCommandLine(line string) ([][]string, error) {
+	s := splitter{}
+	s.split(line, 0)
+	return s.commands, s.err
+}
+
+// SplitCommandLineAsWritten splits a command line like SplitCommandLine, but keeps the leading variable
+// assignments of each command and its executable as written, so FOO=1 /tmp/rm x stays [FOO=1 /tmp/rm x]
+// An allowlist matched against these commands can't be passed by a command that merely shares the base name
+// of an allowed one, or that sets variables such as LD_PRELOAD for it.
+func SplitCommandLineAsWritten(line string) ([][]string, error) {
+	s := splitter{asWritten: true}
+	s.split(line, 0)
+	return s.commands, s.err
+}
+
+// substitutionSyntax matches what makes the end of a $( ) or backtick substitution uncertain when it is found by
+// counting parentheses or looking for the next backtick
+var substitutionSyntax = regexp.MustCompile("['\"`#\\\\]|\\$\\(|(^|[^A-Za-z0-9_])case([^A-Za-z0-9_]|$)")
+
+// splitter collects the commands of a command line
+type splitter struct {
+	asWritten bool
+	commands  [][]string
+	err       error // the first ambiguity found, wrapping ErrAmbiguousCommandLine
+}
+
+// ambiguous records that the command line can't be split reliably
+func (s *splitter) ambiguous(format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = fmt.Errorf("%w: %s", ErrAmbiguousCommandLine, fmt.Sprintf(format, args...))
+	}
+}
+
+// split appends the commands of line
+func (s *splitter) split(line string, depth int) {
+	if depth > maxCommandLineDepth {
+		s.ambiguous("scripts nested more than %d levels deep", maxCommandLineDepth)
+		return
+	}
+
+	var words []string
+	var word strings.Builder
+	inWord := false
+	runes := []rune(line)
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	// cases counts the case clauses open, whose patterns end with a ) of their own
+	cases := 0
+	endCommand := func() {
+		endWord()
+		switch {
+		case opensCase(words):
+			cases++ // case $1 in, with its first pattern on the next line
+		case len(words) > 0 && words[0] == "esac" && cases > 0:
+			cases--
+		}
+		if cmd := s.normalize(words); len(cmd) > 0 {
+			s.commands = append(s.commands, cmd)
+			s.nested(cmd, depth)
+		}
+		words = nil
+	}
+	substitute := func(inner string, end int) {
+		switch {
+		case end >= len(runes):
+			s.ambiguous("unterminated command substitution")
+		case substitutionSyntax.MatchString(inner):
+			s.ambiguous("quotes, comments, case clauses or substitutions inside a command substitution")
+		default:
+			s.split(inner, depth+1)
+		}
+	}
+
+	// A ) without a ( before it ends the pattern of a case item, whose words are no command
+	parens := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t':
+			endWord()
+		case r == ')' && parens == 0:
+			endWord()
+			if !opensCase(words) && cases == 0 {
+				s.ambiguous(") without a subshell or case clause")
+			} else if opensCase(words) {
+				cases++
+			}
+			words = nil
+		case r == '(' || r == ')':
+			if r == '(' {
+				parens++
+			} else {
+				parens--
+			}
+			endCommand()
+		case r == '\n' || r == ';' || r == '&' || r == '|':
+			endCommand()
+		case r == '<' || r == '>':
+			endWord()
+			op := string(r)
+			for i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '&' || runes[i+1] == '<' || op == "<<" && runes[i+1] == '-') {
+				i++
+				op += string(runes[i])
+			}
+			if op == "<<" || op == "<<-" {
+				s.ambiguous("here-document")
+			}
+			words = append(words, op)
+		case r == '#' && !inWord:
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+					inWord = true
+				}
+			}
+		case r == '\'':
+			inWord = true
+			for i+1 < len(runes) && runes[i+1] != '\'' {
+				i++
+				word.WriteRune(runes[i])
+			}
+			i++
+			if i >= len(runes) {
+				s.ambiguous("unterminated quote")
+			}
+		case r == '"':
+			inWord = true
+			for i+1 < len(runes) && runes[i+1] != '"' {
+				i++
+				switch {
+				case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]):
+					i++
+					if runes[i] != '\n' {
+						word.WriteRune(runes[i])
+					}
+				case runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '(':
+					end := closingParen(runes, i+1)
+					substitute(string(runes[i+2:end]), end)
+					word.WriteString(string(runes[i:min(end+1, len(runes))]))
+					i = end
+				case runes[i] == '`':
+					end := closing(runes, i, '`')
+					substitute(string(runes[i+1:end]), end)
+					word.WriteString(string(runes[i:min(end+1, len(runes))]))
+					i = end
+				default:
+					word.WriteRune(runes[i])
+				}
+			}
+			i++
+			if i >= len(runes) {
+				s.ambiguous("unterminated quote")
+			}
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			inWord = true
+			end := closingParen(runes, i+1)
+			substitute(string(runes[i+2:end]), end)
+			word.WriteString(string(runes[i:min(end+1, len(runes))]))
+			i = end
+		case r == '`':
+			inWord = true
+			end := closing(runes, i, '`')
+			substitute(string(runes[i+1:end]), end)
+			word.WriteString(string(runes[i:min(end+1, len(runes))]))
+			i = end
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endCommand()
+}
+
+// opensCase reports whether words, after any reserved words, start a case clause, as case $1 in start does
+// before the ) ending its first pattern
+func opensCase(words []string) bool {
+	for _, word := range words {
+		if !reservedWords[word] {
+			return word == "case"
+		}
+	}
+	return false
+}
+
+// closingParen returns the index of the parenthesis closing the one at open, or len(runes) if it isn't closed
+func closingParen(runes []rune, open int) int {
+	depth := 0
+	for i := open; i < len(runes); i++ {
+		switch runes[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(runes)
+}
+
+// closing returns the index of the next quote after the one at open, or len(runes) if there is none
+func closing(runes []rune, open int, quote rune) int {
+	for i := open + 1; i < len(runes); i++ {
+		if runes[i] == quote {
+			return i
+		}
+	}
+	return len(runes)
+}
+
+// normalize drops leading reserved words and function names and, unless asWritten, leading variable
+// assignments, reducing the executable to its base name; the header of a for, select or case clause isn't a
+// command at all. As written, a command of assignments only, such as PATH=/tmp, is kept as a command.
+func (s *splitter) normalize(words []string) []string {
+	for len(words) > 0 {
+		if words[0] == "function" && len(words) > 1 {
+			words = words[2:]
+		} else if reservedWords[words[0]] || !s.asWritten && assignmentPattern.MatchString(words[0]) {
+			words = words[1:]
+		} else {
+			break
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	if clauseHeaders[words[0]] {
+		return nil
+	}
+	if !s.asWritten && strings.Contains(words[0], "/") {
+		words[0] = path.Base(words[0])
+	}
+	return words
+}
+
+// executable returns the index of the executable in cmd, after any variable assignments, or -1 if there is none
+func executable(cmd []string) int {
+	for i, word := range cmd {
+		if !assignmentPattern.MatchString(word) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nested appends the commands of the script a command runs, for a shell with -c (or -ec, -lc and the like)
+// and for eval, and the command a wrapper runs
+func (s *splitter) nested(cmd []string, depth int) {
+	i := executable(cmd)
+	if i < 0 {
+		return
+	}
+	cmd = cmd[i:]
+	switch name := path.Base(cmd[0]); {
+	case name == "eval" && len(cmd) > 1:
+		s.split(strings.Join(cmd[1:], " "), depth+1)
+	case shells[name]:
+		for i, arg := range cmd[1:] {
+			if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") && i+2 < len(cmd) {
+				s.split(cmd[i+2], depth+1)
+				return
+			}
+		}
+	default:
+		if wrapped := s.unwrap(cmd); len(wrapped) > 0 {
+			s.commands = append(s.commands, wrapped)
+			s.nested(wrapped, depth+1)
+		}
+	}
+}
+
+// unwrap returns the command a wrapper command runs, normalized, or nil if cmd isn't a wrapper or runs none
+// Options are skipped along with the argument of those taking a separate one, and so are the variable
+// assignments env takes.
+func (s *splitter) unwrap(cmd []string) []string {
+	wrapper, ok := wrappers[path.Base(cmd[0])]
+	if !ok {
+		return nil
+	}
+	args := cmd[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		if len(option) == 2 && strings.Contains(wrapper.argOptions, option[1:]) && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	if len(args) < wrapper.operands {
+		return nil
+	}
+	args = args[wrapper.operands:]
+	return s.normalize(append([]string(nil), args...))
+}
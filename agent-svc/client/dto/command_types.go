@@ -2,9 +2,11 @@ package dto
 
 // RunCommand represents a command execution request
 type RunCommand struct {
-	Cmd        string   `json:"cmd" validate:"required"`
-	Args       []string `json:"args,omitempty"`
-	TimeoutSec int      `json:"timeout_sec,omitempty"`
+	Cmd            string   `json:"cmd" validate:"required"`
+	Args           []string `json:"args,omitempty"`
+	TimeoutSec     int      `json:"timeout_sec,omitempty"`
+	MaxOutputBytes int64    `json:"max_output_bytes,omitempty" validate:"omitempty,min=1"` // 0 for the server default
+	WorkingDir     string   `json:"working_dir,omitempty"`                                 // absolute path; the node agent's working directory if empty
 }
 
 // UpdateAgent represents an agent update request
@@ -20,6 +22,7 @@ type UpdatePackage struct {
 }
 
 // CommandRegistry maps command types to their struct types for validation
+// agent-svc publishes these payload schemas in its OpenAPI document.
 var CommandRegistry = map[string]interface{}{
 	"RunCommand":    RunCommand{},
 	"UpdateAgent":   UpdateAgent{},
@@ -0,0 +1,236 @@
+package dto
+
+import "time"
+
+// RegisterRequest represents node registration request
+type RegisterRequest struct {
+	NodeID        string                 `json:"node_id" validate:"required"`
+	Attrs         map[string]interface{} `json:"attrs,omitempty"`
+	TenantID      string                 `json:"tenant_id,omitempty"`      // defaults to the default tenant
+	EnrollmentKey string                 `json:"enrollment_key,omitempty"` // required by tenants other than default
+}
+
+// HeartbeatRequest represents heartbeat request
+type HeartbeatRequest struct {
+	NodeID     string `json:"node_id" validate:"required"`
+	PolicyHash string `json:"policy_hash,omitempty"` // hash of the node's local policy file; empty if it has none
+}
+
+// UpdateNodeRequest represents a change to a node; omitted fields keep their value
+type UpdateNodeRequest struct {
+	Labels   map[string]*string `json:"labels,omitempty"`   // labels to set; a null value removes the label
+	Disabled *bool              `json:"disabled,omitempty"` // disabled nodes accept no new commands
+}
+
+// SubmitCommandRequest represents command submission request (one-to-one)
+type SubmitCommandRequest struct {
+	CommandType      string                 `json:"command_type" validate:"required_without=Template"`
+	NodeID           string                 `json:"node_id" validate:"required"`
+	Payload          map[string]interface{} `json:"payload" validate:"required_without=Template"`
+	Template         string                 `json:"template,omitempty"`                                      // render command_type and payload from this template
+	TemplateVersion  *int                   `json:"template_version,omitempty" validate:"omitempty,min=1"`   // default: latest version
+	Params           map[string]interface{} `json:"params,omitempty"`                                        // template parameters
+	ExpiresAt        *time.Time             `json:"expires_at,omitempty"`                                    // command expires if not dispatched by then
+	DeliverWithinSec int                    `json:"deliver_within_sec,omitempty" validate:"omitempty,min=1"` // alternative to expires_at
+	IdempotencyKey   string                 `json:"idempotency_key,omitempty" validate:"omitempty,max=255"`  // alternative to the Idempotency-Key header
+	Priority         *int                   `json:"priority,omitempty" validate:"omitempty,min=0,max=9"`     // 0 (lowest) .. 9 (highest), default 5
+	RetryPolicy      *RetryPolicy           `json:"retry_policy,omitempty"`
+}
+
+// RetryPolicy represents the server-side retry policy of a command
+type RetryPolicy struct {
+	MaxAttempts       int      `json:"max_attempts" validate:"required,min=2,max=10"` // total attempts, including the first
+	BackoffSec        int      `json:"backoff_sec,omitempty" validate:"omitempty,min=1,max=3600"`
+	BackoffMultiplier float64  `json:"backoff_multiplier,omitempty" validate:"omitempty,min=1,max=10"`
+	MaxBackoffSec     int      `json:"max_backoff_sec,omitempty" validate:"omitempty,min=1,max=86400"`
+	RetryOnStatuses   []string `json:"retry_on_statuses,omitempty" validate:"omitempty,dive,oneof=failed timeout lost"`
+	RetryOnExitCodes  []int    `json:"retry_on_exit_codes,omitempty"` // restricts retries of failed attempts to these exit codes
+}
+
+// PushCommandLogsRequest represents command execution log chunk push request
+type PushCommandLogsRequest struct {
+	CommandID string            `json:"command_id" validate:"required"`
+	Chunks    []LogChunkRequest `json:"chunks" validate:"required"`
+}
+
+// LogChunkRequest represents a single log chunk
+type LogChunkRequest struct {
+	ChunkIndex int64  `json:"chunk_index" validate:"required,min=0"`
+	Stream     string `json:"stream" validate:"required,oneof=stdout stderr"`
+	Data       string `json:"data" validate:"required"`
+	IsFinal    bool   `json:"is_final,omitempty"` // true if this is the final chunk (work is done)
+}
+
+// CommandStatusRequest represents command status update
+type CommandStatusRequest struct {
+	CommandID       string `json:"command_id" validate:"required"`
+	Status          string `json:"status" validate:"required,oneof=queued running success failed timeout cancelled lost expired rejected"`
+	ExitCode        *int   `json:"exit_code,omitempty"`
+	ErrorMsg        string `json:"error_msg,omitempty"`
+	OutputBytes     *int64 `json:"output_bytes,omitempty" validate:"omitempty,min=0"` // total output produced, including any truncated part
+	OutputTruncated bool   `json:"output_truncated,omitempty"`                        // true if the agent dropped the middle of the output
+}
+
+// PollCommandRequest represents command polling request (query params)
+type PollCommandRequest struct {
+	NodeID string
+	Wait   int // seconds
+}
+
+// CreateScheduleRequest represents a recurring command definition
+type CreateScheduleRequest struct {
+	Name            string                 `json:"name" validate:"required"`
+	Cron            string                 `json:"cron" validate:"required"` // standard 5-field expression or descriptor such as @daily
+	Timezone        string                 `json:"timezone,omitempty"`       // IANA name, default UTC
+	NodeID          *string                `json:"node_id,omitempty"`        // target node, or
+	Selector        map[string]string      `json:"selector,omitempty"`       // labels or attrs every target node must match
+	CommandType     string                 `json:"command_type" validate:"required"`
+	Payload         map[string]interface{} `json:"payload" validate:"required"`
+	Priority        *int                   `json:"priority,omitempty" validate:"omitempty,min=0,max=9"`
+	MisfirePolicy   string                 `json:"misfire_policy,omitempty" validate:"omitempty,oneof=run_once skip"`
+	MisfireGraceSec *int                   `json:"misfire_grace_sec,omitempty" validate:"omitempty,min=0"` // default 300
+	Paused          bool                   `json:"paused,omitempty"`
+}
+
+// CreateWorkflowRequest represents a workflow definition, sent as JSON or YAML
+type CreateWorkflowRequest struct {
+	Name  string                `json:"name" yaml:"name" validate:"required"`
+	Steps []WorkflowStepRequest `json:"steps" yaml:"steps" validate:"required,min=1,dive"`
+}
+
+// WorkflowStepRequest represents one step of a workflow definition
+type WorkflowStepRequest struct {
+	ID          string                 `json:"id" yaml:"id" validate:"required,max=100"`
+	NodeID      string                 `json:"node_id,omitempty" yaml:"node_id,omitempty"`   // target node, or
+	Selector    map[string]string      `json:"selector,omitempty" yaml:"selector,omitempty"` // labels or attrs every target node must match
+	CommandType string                 `json:"command_type" yaml:"command_type" validate:"required"`
+	Payload     map[string]interface{} `json:"payload" yaml:"payload" validate:"required"`
+	OnSuccess   []string               `json:"on_success,omitempty" yaml:"on_success,omitempty"` // steps to run if every command succeeds
+	OnFailure   []string               `json:"on_failure,omitempty" yaml:"on_failure,omitempty"` // steps to run otherwise
+}
+
+// CreateRolloutRequest represents a command rolled out to many nodes in batches
+type CreateRolloutRequest struct {
+	Name        string                 `json:"name" validate:"required"`
+	NodeIDs     []string               `json:"node_ids,omitempty"` // target nodes, or
+	Selector    map[string]string      `json:"selector,omitempty"` // labels or attrs every target node must match; all enabled nodes if both are empty
+	CommandType string                 `json:"command_type" validate:"required"`
+	Payload     map[string]interface{} `json:"payload" validate:"required"`
+	Priority    *int                   `json:"priority,omitempty" validate:"omitempty,min=0,max=9"`
+	Strategy    RolloutStrategy        `json:"strategy"`
+}
+
+// RolloutStrategy controls batching, the canary and the failure threshold of a rollout
+type RolloutStrategy struct {
+	BatchSize              int      `json:"batch_size,omitempty" validate:"omitempty,min=1"`            // nodes per batch, or
+	BatchPercent           int      `json:"batch_percent,omitempty" validate:"omitempty,min=1,max=100"` // percentage of the non-canary nodes per batch
+	PauseBetweenBatchesSec int      `json:"pause_between_batches_sec,omitempty" validate:"omitempty,min=0"`
+	CanaryNodeIDs          []string `json:"canary_node_ids,omitempty"`                        // nodes of the canary batch, or
+	CanarySize             int      `json:"canary_size,omitempty" validate:"omitempty,min=1"` // number of nodes in the canary batch
+	MaxFailurePercent      int      `json:"max_failure_percent" validate:"min=0,max=100"`     // default 0: halt on the first failure
+}
+
+// CreateTemplateRequest represents a command template definition
+type CreateTemplateRequest struct {
+	Name        string                 `json:"name" validate:"required,max=100"`
+	Description string                 `json:"description,omitempty"`
+	CommandType string                 `json:"command_type" validate:"required"`
+	Payload     map[string]interface{} `json:"payload" validate:"required"` // may contain {{param}} placeholders
+	Params      []TemplateParam        `json:"params" validate:"dive"`
+}
+
+// TemplateParam represents a template parameter schema
+type TemplateParam struct {
+	Name        string      `json:"name" validate:"required"`
+	Type        string      `json:"type" validate:"required,oneof=string integer number boolean"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"` // regular expression a string must match in full
+	Enum        []string    `json:"enum,omitempty"`    // allowed values of a string
+	Min         *float64    `json:"min,omitempty"`     // bounds of a number, or of the length of a string
+	Max         *float64    `json:"max,omitempty"`
+}
+
+// CreateWebhookRequest represents a webhook subscription
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=2048"`
+	EventTypes []string `json:"event_types" validate:"required,min=1"`                // e.g. command.failed, node.offline, command.*, *
+	Secret     string   `json:"secret,omitempty" validate:"omitempty,min=16,max=256"` // generated when omitted
+	Enabled    *bool    `json:"enabled,omitempty"`                                    // defaults to true
+}
+
+// CreateApprovalPolicyRequest represents an approval policy; a policy setting no criteria matches every command
+type CreateApprovalPolicyRequest struct {
+	Name           string            `json:"name" validate:"required,max=200"`
+	Description    string            `json:"description,omitempty" validate:"max=1000"`
+	CommandType    string            `json:"command_type,omitempty" validate:"max=50"`          // empty matches every command type
+	PayloadPattern string            `json:"payload_pattern,omitempty" validate:"max=1000"`     // regular expression matched against every string in the payload
+	NodeLabels     map[string]string `json:"node_labels,omitempty"`                             // labels the target node must have, all of them
+	TimeoutSec     int               `json:"timeout_sec,omitempty" validate:"min=0,max=604800"` // defaults to an hour
+}
+
+// ApprovalDecisionRequest represents an operator approving or rejecting a command pending approval
+type ApprovalDecisionRequest struct {
+	Comment *string `json:"comment,omitempty" validate:"omitempty,max=1000"`
+}
+
+// CommandPolicyRequest represents the command policy, sent as JSON or YAML; its rules are evaluated in order and
+// the first one matching a submission decides, default_action deciding submissions no rule matches
+type CommandPolicyRequest struct {
+	DefaultAction string              `json:"default_action,omitempty" yaml:"default_action,omitempty" validate:"omitempty,oneof=allow deny"` // defaults to allow
+	Rules         []PolicyRuleRequest `json:"rules" yaml:"rules" validate:"dive"`
+}
+
+// PolicyRuleRequest represents a command policy rule; it matches submissions matching every criterion it sets
+type PolicyRuleRequest struct {
+	Name               string            `json:"name" yaml:"name" validate:"required,max=200"`
+	Action             string            `json:"action" yaml:"action" validate:"required,oneof=allow deny require_approval"`
+	Reason             string            `json:"reason,omitempty" yaml:"reason,omitempty" validate:"max=1000"`
+	CommandTypes       []string          `json:"command_types,omitempty" yaml:"command_types,omitempty"`                                           // any of them
+	CommandGlob        string            `json:"command_glob,omitempty" yaml:"command_glob,omitempty"`                                             // matched against each command of a RunCommand command line, or
+	CommandRegex       string            `json:"command_regex,omitempty" yaml:"command_regex,omitempty"`                                           // searched in each of them
+	NodeLabels         map[string]string `json:"node_labels,omitempty" yaml:"node_labels,omitempty"`                                               // labels the target node must have, all of them
+	OperatorRoles      []string          `json:"operator_roles,omitempty" yaml:"operator_roles,omitempty"`                                         // any of them
+	TimeWindow         *PolicyTimeWindow `json:"time_window,omitempty" yaml:"time_window,omitempty"`                                               // when the command is submitted
+	ApprovalTimeoutSec int               `json:"approval_timeout_sec,omitempty" yaml:"approval_timeout_sec,omitempty" validate:"min=0,max=604800"` // for require_approval; defaults to an hour
+}
+
+// PolicyTimeWindow represents a time of day range on some days of the week; a window ending before it starts
+// wraps past midnight
+type PolicyTimeWindow struct {
+	Days     []string `json:"days,omitempty" yaml:"days,omitempty"`         // mon, tue, ...; every day if empty
+	Start    string   `json:"start" yaml:"start" validate:"required"`       // HH:MM, inclusive
+	End      string   `json:"end" yaml:"end" validate:"required"`           // HH:MM, exclusive; equal to start for the whole day
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone,omitempty"` // IANA name, UTC if empty
+}
+
+// EvaluatePolicyRequest represents a dry run of a submission against the command policy
+type EvaluatePolicyRequest struct {
+	NodeID        string                 `json:"node_id,omitempty"`     // the node's labels are used, or
+	NodeLabels    map[string]string      `json:"node_labels,omitempty"` // these
+	CommandType   string                 `json:"command_type" validate:"required"`
+	Payload       map[string]interface{} `json:"payload" validate:"required"`
+	OperatorRoles []string               `json:"operator_roles,omitempty"` // defaults to the roles of the caller
+	Time          *time.Time             `json:"time,omitempty"`           // defaults to now
+}
+
+// CreateTenantRequest represents a tenant definition
+type CreateTenantRequest struct {
+	TenantID          string `json:"tenant_id" validate:"required,max=63"` // lowercase letters, digits and dashes
+	Name              string `json:"name" validate:"required,max=200"`
+	MaxNodes          int    `json:"max_nodes,omitempty" validate:"min=0"`            // 0 is unlimited
+	MaxCommandsPerDay int    `json:"max_commands_per_day,omitempty" validate:"min=0"` // 0 is unlimited
+}
+
+// UpdateTenantRequest represents a change to a tenant; omitted fields keep their value
+type UpdateTenantRequest struct {
+	Name              *string `json:"name,omitempty" validate:"omitempty,min=1,max=200"`
+	MaxNodes          *int    `json:"max_nodes,omitempty" validate:"omitempty,min=0"`
+	MaxCommandsPerDay *int    `json:"max_commands_per_day,omitempty" validate:"omitempty,min=0"`
+}
+
+// AddTenantOperatorRequest represents adding an operator to a tenant
+type AddTenantOperatorRequest struct {
+	OperatorID string `json:"operator_id" validate:"required,max=255"`
+}
@@ -0,0 +1,467 @@
+package dto
+
+// RegisterResponse represents registration response
+type RegisterResponse struct {
+	Token     string `json:"token"`
+	NodeID    string `json:"node_id"`
+	TenantID  string `json:"tenant_id"`
+	ExpiresIn int64  `json:"expires_in"`
+
+	// CommandSigningKey is the base64 Ed25519 public key dispatched commands are signed with, for the node to pin;
+	// omitted if agent-svc doesn't sign commands
+	CommandSigningKey string `json:"command_signing_key,omitempty"`
+}
+
+// HeartbeatResponse represents heartbeat response
+type HeartbeatResponse struct {
+	OK bool `json:"ok"`
+}
+
+// SubmitCommandResponse represents command submission response
+type SubmitCommandResponse struct {
+	CommandID string `json:"command_id"`
+	Replayed  bool   `json:"replayed,omitempty"` // true if an earlier submission with the same idempotency key was returned
+}
+
+// PushCommandLogsResponse represents command execution log push response
+type PushCommandLogsResponse struct {
+	AckedOffsets []int64 `json:"acked_offsets"`
+}
+
+// CommandStatusResponse represents status update response
+type CommandStatusResponse struct {
+	OK bool `json:"ok"`
+}
+
+// CommandResponse represents a command for polling
+type CommandResponse struct {
+	CommandID   string                 `json:"command_id"`
+	CommandType string                 `json:"command_type"`
+	Payload     map[string]interface{} `json:"payload"`
+	ExpiresAt   *string                `json:"expires_at,omitempty"` // node must not start the command after this time
+	Priority    int                    `json:"priority"`
+	// W3C trace context (traceparent, tracestate) of the submission; the node continues the trace from it
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+
+	// Signature of the command by agent-svc's command signing key, omitted if it doesn't sign commands; the node
+	// must not accept the command after SignatureExpiresAt (see client.SignedCommand)
+	Signature          string  `json:"signature,omitempty"`
+	SignatureExpiresAt *string `json:"signature_expires_at,omitempty"`
+}
+
+// CommandsResponse represents multiple commands for polling
+type CommandsResponse struct {
+	Commands []CommandResponse `json:"commands"`
+}
+
+// GetLogsResponse represents log retrieval response
+type GetLogsResponse struct {
+	CommandID string             `json:"command_id"`
+	Logs      []LogChunkResponse `json:"logs"`
+}
+
+// LogChunkResponse represents a log chunk in response
+type LogChunkResponse struct {
+	ChunkIndex int64  `json:"chunk_index"`
+	Stream     string `json:"stream"`
+	Data       string `json:"data"`
+	IsFinal    bool   `json:"is_final,omitempty"` // true if this is the final chunk (work is done)
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string            `json:"error"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ListNodesResponse represents list of nodes response
+type ListNodesResponse struct {
+	Nodes []NodeResponse `json:"nodes"`
+}
+
+// NodeResponse represents a node in API response
+type NodeResponse struct {
+	NodeID     string                 `json:"node_id"`
+	TenantID   string                 `json:"tenant_id"`
+	Attrs      map[string]interface{} `json:"attrs"`  // reported by the node when it registers
+	Labels     map[string]string      `json:"labels"` // set by operators
+	LastSeenAt string                 `json:"last_seen_at"`
+	Disabled   bool                   `json:"disabled"`
+	IsHealthy  bool                   `json:"is_healthy"` // true if last_seen_at is within last 2 minutes
+
+	LocalPolicyHash string `json:"local_policy_hash,omitempty"` // hash of the local policy the node last reported
+}
+
+// ListCommandsResponse represents list of commands response
+type ListCommandsResponse struct {
+	Commands []CommandDetailResponse `json:"commands"`
+}
+
+// CommandDetailResponse represents a command detail in API response
+type CommandDetailResponse struct {
+	CommandID       string                 `json:"command_id"`
+	NodeID          string                 `json:"node_id"`
+	CommandType     string                 `json:"command_type"`
+	Payload         map[string]interface{} `json:"payload"`
+	Status          string                 `json:"status"`
+	ExitCode        *int                   `json:"exit_code,omitempty"`
+	ErrorMsg        *string                `json:"error_msg,omitempty"`
+	OutputBytes     *int64                 `json:"output_bytes,omitempty"`
+	OutputTruncated bool                   `json:"output_truncated"` // true if the middle of the output was dropped
+	CreatedAt       string                 `json:"created_at"`
+	UpdatedAt       string                 `json:"updated_at"`
+	DispatchedAt    *string                `json:"dispatched_at,omitempty"`
+	StartedAt       *string                `json:"started_at,omitempty"`
+	FinishedAt      *string                `json:"finished_at,omitempty"`
+	ExpiresAt       *string                `json:"expires_at,omitempty"`
+	Priority        int                    `json:"priority"`
+	ParentCommandID *string                `json:"parent_command_id,omitempty"` // first attempt, for retries
+	Attempt         int                    `json:"attempt"`
+	RetryPolicy     *RetryPolicy           `json:"retry_policy,omitempty"`
+	NextRetryAt     *string                `json:"next_retry_at,omitempty"`
+	Template        *TemplateRef           `json:"template,omitempty"`      // template version the payload was rendered from
+	QueueWaitMs     *int64                 `json:"queue_wait_ms,omitempty"` // created_at -> dispatched_at
+	ExecutionMs     *int64                 `json:"execution_ms,omitempty"`  // started_at (or dispatched_at) -> finished_at
+}
+
+// CommandAttemptsResponse represents a command together with all attempts made for it
+type CommandAttemptsResponse struct {
+	CommandDetailResponse
+	OverallStatus string                  `json:"overall_status"` // status of the latest attempt, or "retrying"
+	Attempts      []CommandDetailResponse `json:"attempts"`
+}
+
+// CommandHistoryResponse represents the status history of a command
+type CommandHistoryResponse struct {
+	CommandID string                 `json:"command_id"`
+	History   []StatusChangeResponse `json:"history"`
+}
+
+// StatusChangeResponse represents a single status transition
+type StatusChangeResponse struct {
+	FromStatus *string `json:"from_status"`
+	ToStatus   string  `json:"to_status"`
+	Source     string  `json:"source"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// DeleteQueuedCommandsResponse represents the response for deleting queued commands
+type DeleteQueuedCommandsResponse struct {
+	DeletedCount int `json:"deleted_count"`
+}
+
+// ScheduleResponse represents a schedule
+type ScheduleResponse struct {
+	ScheduleID      string                 `json:"schedule_id"`
+	TenantID        string                 `json:"tenant_id"`
+	Name            string                 `json:"name"`
+	Cron            string                 `json:"cron"`
+	Timezone        string                 `json:"timezone"`
+	NodeID          *string                `json:"node_id,omitempty"`
+	Selector        map[string]string      `json:"selector,omitempty"`
+	CommandType     string                 `json:"command_type"`
+	Payload         map[string]interface{} `json:"payload"`
+	Priority        int                    `json:"priority"`
+	MisfirePolicy   string                 `json:"misfire_policy"`
+	MisfireGraceSec int                    `json:"misfire_grace_sec"`
+	Paused          bool                   `json:"paused"`
+	NextRunAt       *string                `json:"next_run_at,omitempty"`
+	LastRunAt       *string                `json:"last_run_at,omitempty"`
+	CreatedAt       string                 `json:"created_at"`
+	UpdatedAt       string                 `json:"updated_at"`
+}
+
+// ListSchedulesResponse represents list of schedules response
+type ListSchedulesResponse struct {
+	Schedules []ScheduleResponse `json:"schedules"`
+}
+
+// ScheduleRunResponse represents one firing of a schedule
+type ScheduleRunResponse struct {
+	ScheduledFor string   `json:"scheduled_for"`
+	Trigger      string   `json:"trigger"` // schedule|manual
+	Status       string   `json:"status"`  // created|partial|failed|skipped
+	CommandIDs   []string `json:"command_ids"`
+	ErrorMsg     *string  `json:"error_msg,omitempty"`
+	CreatedAt    string   `json:"created_at"`
+}
+
+// ScheduleRunsResponse represents the run history of a schedule
+type ScheduleRunsResponse struct {
+	ScheduleID string                `json:"schedule_id"`
+	Runs       []ScheduleRunResponse `json:"runs"`
+}
+
+// WorkflowResponse represents a workflow run and the progress of each step
+type WorkflowResponse struct {
+	WorkflowID string                 `json:"workflow_id"`
+	TenantID   string                 `json:"tenant_id"`
+	Name       string                 `json:"name"`
+	Status     string                 `json:"status"` // running|succeeded|failed
+	Steps      []WorkflowStepResponse `json:"steps,omitempty"`
+	CreatedAt  string                 `json:"created_at"`
+	UpdatedAt  string                 `json:"updated_at"`
+	FinishedAt *string                `json:"finished_at,omitempty"`
+}
+
+// WorkflowStepResponse represents the progress of one workflow step
+type WorkflowStepResponse struct {
+	StepID      string            `json:"step_id"`
+	Status      string            `json:"status"` // pending|running|succeeded|failed|skipped
+	NodeID      string            `json:"node_id,omitempty"`
+	Selector    map[string]string `json:"selector,omitempty"`
+	CommandType string            `json:"command_type"`
+	OnSuccess   []string          `json:"on_success,omitempty"`
+	OnFailure   []string          `json:"on_failure,omitempty"`
+	CommandIDs  []string          `json:"command_ids"`
+	ErrorMsg    *string           `json:"error_msg,omitempty"`
+	StartedAt   *string           `json:"started_at,omitempty"`
+	FinishedAt  *string           `json:"finished_at,omitempty"`
+}
+
+// ListWorkflowsResponse represents list of workflows response
+type ListWorkflowsResponse struct {
+	Workflows []WorkflowResponse `json:"workflows"`
+}
+
+// RolloutResponse represents a rollout and the progress of each batch
+type RolloutResponse struct {
+	RolloutID   string                 `json:"rollout_id"`
+	TenantID    string                 `json:"tenant_id"`
+	Name        string                 `json:"name"`
+	CommandType string                 `json:"command_type"`
+	Payload     map[string]interface{} `json:"payload"`
+	Priority    int                    `json:"priority"`
+	Strategy    RolloutStrategy        `json:"strategy"`
+	Status      string                 `json:"status"` // running|paused|halted|aborted|completed
+	ErrorMsg    *string                `json:"error_msg,omitempty"`
+	NextBatchAt *string                `json:"next_batch_at,omitempty"`
+	Batches     []RolloutBatchResponse `json:"batches,omitempty"`
+	CreatedAt   string                 `json:"created_at"`
+	UpdatedAt   string                 `json:"updated_at"`
+	FinishedAt  *string                `json:"finished_at,omitempty"`
+}
+
+// RolloutBatchResponse represents the progress of one rollout batch
+type RolloutBatchResponse struct {
+	BatchIndex     int      `json:"batch_index"`
+	Canary         bool     `json:"canary,omitempty"`
+	Status         string   `json:"status"` // pending|running|succeeded|failed
+	NodeIDs        []string `json:"node_ids"`
+	CommandIDs     []string `json:"command_ids"`
+	SucceededCount int      `json:"succeeded_count"`
+	ErrorMsg       *string  `json:"error_msg,omitempty"`
+	StartedAt      *string  `json:"started_at,omitempty"`
+	FinishedAt     *string  `json:"finished_at,omitempty"`
+}
+
+// ListRolloutsResponse represents list of rollouts response
+type ListRolloutsResponse struct {
+	Rollouts []RolloutResponse `json:"rollouts"`
+}
+
+// TemplateRef identifies a template version
+type TemplateRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// TemplateResponse represents a command template version
+type TemplateResponse struct {
+	TenantID    string                 `json:"tenant_id"`
+	Name        string                 `json:"name"`
+	Version     int                    `json:"version"`
+	Description string                 `json:"description,omitempty"`
+	CommandType string                 `json:"command_type"`
+	Payload     map[string]interface{} `json:"payload"`
+	Params      []TemplateParam        `json:"params"`
+	CreatedAt   string                 `json:"created_at"`
+}
+
+// ListTemplatesResponse represents list of templates response
+type ListTemplatesResponse struct {
+	Templates []TemplateResponse `json:"templates"`
+}
+
+// WebhookResponse represents a webhook subscription
+// The secret is only returned when the subscription is created.
+type WebhookResponse struct {
+	WebhookID  string   `json:"webhook_id"`
+	TenantID   string   `json:"tenant_id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret,omitempty"`
+	Enabled    bool     `json:"enabled"`
+	CreatedAt  string   `json:"created_at"`
+	UpdatedAt  string   `json:"updated_at"`
+}
+
+// ListWebhooksResponse represents list of webhooks response
+type ListWebhooksResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+// WebhookDeliveryResponse represents the delivery of one event to a webhook
+type WebhookDeliveryResponse struct {
+	DeliveryID       string  `json:"delivery_id"`
+	EventID          string  `json:"event_id"`
+	EventType        string  `json:"event_type"`
+	Status           string  `json:"status"` // pending|delivered|dead
+	Attempts         int     `json:"attempts"`
+	NextAttemptAt    *string `json:"next_attempt_at,omitempty"`
+	LastResponseCode *int    `json:"last_response_code,omitempty"`
+	LastError        *string `json:"last_error,omitempty"`
+	DeliveredAt      *string `json:"delivered_at,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+// WebhookDeliveriesResponse represents the delivery log of a webhook
+type WebhookDeliveriesResponse struct {
+	WebhookID  string                    `json:"webhook_id"`
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+}
+
+// ApprovalPolicyResponse represents an approval policy
+type ApprovalPolicyResponse struct {
+	PolicyID       string            `json:"policy_id"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	CommandType    string            `json:"command_type,omitempty"`
+	PayloadPattern string            `json:"payload_pattern,omitempty"`
+	NodeLabels     map[string]string `json:"node_labels,omitempty"`
+	TimeoutSec     int               `json:"timeout_sec"`
+	CreatedAt      string            `json:"created_at"`
+}
+
+// ListApprovalPoliciesResponse represents list of approval policies response
+type ListApprovalPoliciesResponse struct {
+	Policies []ApprovalPolicyResponse `json:"policies"`
+}
+
+// CommandApprovalResponse represents the approval request of a command
+type CommandApprovalResponse struct {
+	CommandID   string  `json:"command_id"`
+	NodeID      string  `json:"node_id"`
+	CommandType string  `json:"command_type"`
+	PolicyID    string  `json:"policy_id"`
+	PolicyName  string  `json:"policy_name"`
+	RequestedBy string  `json:"requested_by,omitempty"`
+	ExpiresAt   string  `json:"expires_at"`
+	Decision    *string `json:"decision,omitempty"` // approved|rejected|expired, omitted while pending
+	DecidedBy   *string `json:"decided_by,omitempty"`
+	Comment     *string `json:"comment,omitempty"`
+	DecidedAt   *string `json:"decided_at,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// ListApprovalsResponse represents list of approval requests response
+type ListApprovalsResponse struct {
+	Approvals []CommandApprovalResponse `json:"approvals"`
+}
+
+// CommandPolicyResponse represents the command policy in effect
+type CommandPolicyResponse struct {
+	PolicyID      string              `json:"policy_id,omitempty"` // omitted until a policy is set
+	Version       int                 `json:"version"`             // 0 for a policy loaded from a file
+	Source        string              `json:"source"`              // none|api|file
+	DefaultAction string              `json:"default_action"`
+	Rules         []PolicyRuleRequest `json:"rules"`
+	CreatedBy     string              `json:"created_by,omitempty"`
+	CreatedAt     *string             `json:"created_at,omitempty"`
+}
+
+// EvaluatePolicyResponse represents the decision of the command policy on a submission and how it was reached
+type EvaluatePolicyResponse struct {
+	Action        string              `json:"action"`         // allow|deny|require_approval
+	Rule          string              `json:"rule,omitempty"` // rule that decided, omitted for the default action
+	RuleIndex     int                 `json:"rule_index"`     // -1 for the default action
+	Reason        string              `json:"reason,omitempty"`
+	Commands      []string            `json:"commands,omitempty"` // the commands the command line was split into
+	Trace         []PolicyRuleOutcome `json:"trace"`              // every rule evaluated, in order
+	PolicyVersion int                 `json:"policy_version"`
+	PolicySource  string              `json:"policy_source"`
+}
+
+// PolicyRuleOutcome represents how a rule was evaluated; mismatch names the first criterion that didn't match
+type PolicyRuleOutcome struct {
+	Rule     string `json:"rule"`
+	Matched  bool   `json:"matched"`
+	Mismatch string `json:"mismatch,omitempty"`
+}
+
+// ReadinessResponse represents the readiness check result
+type ReadinessResponse struct {
+	Status     string              `json:"status"` // ready|not_ready
+	CheckedAt  string              `json:"checked_at"`
+	Components ReadinessComponents `json:"components"`
+}
+
+// ReadinessComponents holds the result of each readiness check
+type ReadinessComponents struct {
+	Database   DatabaseHealthResponse  `json:"database"`
+	Migrations MigrationHealthResponse `json:"migrations"`
+	Workers    WorkersHealthResponse   `json:"workers"`
+}
+
+// DatabaseHealthResponse represents the database connectivity check
+type DatabaseHealthResponse struct {
+	Status    string `json:"status"` // ok|failed
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MigrationHealthResponse represents the migration version check
+type MigrationHealthResponse struct {
+	Status          string `json:"status"` // ok|failed
+	Version         uint   `json:"version"`
+	ExpectedVersion uint   `json:"expected_version"`
+	Dirty           bool   `json:"dirty,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// WorkersHealthResponse represents the background workers
+type WorkersHealthResponse struct {
+	Status  string                 `json:"status"` // ok|degraded
+	Workers []WorkerHealthResponse `json:"workers"`
+}
+
+// WorkerHealthResponse represents the last run of a background worker
+type WorkerHealthResponse struct {
+	Name          string  `json:"name"`
+	Status        string  `json:"status"` // ok|failing|stalled
+	IntervalSec   int64   `json:"interval_sec"`
+	LastRunAt     *string `json:"last_run_at,omitempty"`
+	LastSuccessAt *string `json:"last_success_at,omitempty"`
+	LastError     string  `json:"last_error,omitempty"`
+}
+
+// TenantResponse represents a tenant
+// The enrollment key is only returned when the tenant is created or its key is rotated.
+type TenantResponse struct {
+	TenantID          string               `json:"tenant_id"`
+	Name              string               `json:"name"`
+	MaxNodes          int                  `json:"max_nodes"`
+	MaxCommandsPerDay int                  `json:"max_commands_per_day"`
+	EnrollmentKey     string               `json:"enrollment_key,omitempty"`
+	Usage             *TenantUsageResponse `json:"usage,omitempty"`
+	CreatedAt         string               `json:"created_at"`
+	UpdatedAt         string               `json:"updated_at"`
+}
+
+// TenantUsageResponse represents what a tenant counts against its quotas
+type TenantUsageResponse struct {
+	Nodes          int `json:"nodes"`
+	CommandsPerDay int `json:"commands_per_day"` // first attempts submitted in the last 24 hours
+}
+
+// ListTenantsResponse represents list of tenants response
+type ListTenantsResponse struct {
+	Tenants []TenantResponse `json:"tenants"`
+}
+
+// TenantOperatorsResponse represents the operators of a tenant
+type TenantOperatorsResponse struct {
+	TenantID  string   `json:"tenant_id"`
+	Operators []string `json:"operators"`
+}
@@ -0,0 +1,3 @@
+module agent-svc/client
+
+go 1.21
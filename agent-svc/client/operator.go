@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"agent-svc/client/dto"
+)
+
+// terminalStatuses are the command statuses that can no longer change
+var terminalStatuses = map[string]bool{
+	"success":   true,
+	"failed":    true,
+	"timeout":   true,
+	"cancelled": true,
+	"lost":      true,
+	"expired":   true,
+	"rejected":  true,
+}
+
+// IsTerminalStatus reports whether a command in this status can no longer change
+func IsTerminalStatus(status string) bool {
+	return terminalStatuses[status]
+}
+
+// SubmitCommand submits a command to a node
+// A submission with an IdempotencyKey is retried according to the retry policy, since agent-svc returns the
+// original command for a repeated key.
+func (c *Client) SubmitCommand(ctx context.Context, req dto.SubmitCommandRequest) (*dto.SubmitCommandResponse, error) {
+	var resp dto.SubmitCommandResponse
+	err := c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/v1/commands/submit",
+		body:       req,
+		idempotent: req.IdempotencyKey != "",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListCommandsOptions filters ListCommands
+type ListCommandsOptions struct {
+	NodeID string // only commands of this node; empty lists every node of the tenant
+	Limit  int    // at most this many commands, newest first; 0 uses the server default of 50
+}
+
+// ListCommands lists the most recent commands of the operator's tenant
+func (c *Client) ListCommands(ctx context.Context, opts ListCommandsOptions) ([]dto.CommandDetailResponse, error) {
+	query := url.Values{}
+	if opts.NodeID != "" {
+		query.Set("node_id", opts.NodeID)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var resp dto.ListCommandsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/commands", query: query, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Commands, nil
+}
+
+// GetCommand retrieves a command with all attempts made for it
+func (c *Client) GetCommand(ctx context.Context, commandID string) (*dto.CommandAttemptsResponse, error) {
+	var resp dto.CommandAttemptsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/commands/" + url.PathEscape(commandID), idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetCommandHistory retrieves the status transitions of a command, oldest first
+func (c *Client) GetCommandHistory(ctx context.Context, commandID string) ([]dto.StatusChangeResponse, error) {
+	var resp dto.CommandHistoryResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/commands/" + url.PathEscape(commandID) + "/history", idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.History, nil
+}
+
+// GetCommandLogs retrieves the log chunks of a command, ordered by chunk index and stream
+// If fromChunkIndex is set only chunks at or after it are returned; agent-svc's after_chunk_index is inclusive.
+func (c *Client) GetCommandLogs(ctx context.Context, commandID string, fromChunkIndex *int64) ([]dto.LogChunkResponse, error) {
+	query := url.Values{}
+	if fromChunkIndex != nil {
+		query.Set("after_chunk_index", strconv.FormatInt(*fromChunkIndex, 10))
+	}
+
+	var resp dto.GetLogsResponse
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/v1/commands/" + url.PathEscape(commandID) + "/logs",
+		query:      query,
+		idempotent: true,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Logs, nil
+}
+
+// StreamOptions controls StreamCommandLogs
+type StreamOptions struct {
+	FromChunkIndex *int64        // start at this chunk index; nil streams the output from the beginning
+	PollInterval   time.Duration // wait between polls once the output is caught up; defaults to one second
+}
+
+// StreamCommandLogs calls fn with each log chunk of a command as it arrives, in order
+// It returns nil once the command has reached a terminal status and all of its output was delivered, the
+// context's error if it is cancelled first, and fn's error if fn fails.
+func (c *Client) StreamCommandLogs(ctx context.Context, commandID string, opts StreamOptions, fn func(dto.LogChunkResponse) error) error {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	// Polls start at the highest chunk index delivered so far, since stdout and stderr chunks share indexes;
+	// delivered holds the streams already delivered at that index.
+	from := opts.FromChunkIndex
+	delivered := map[string]bool{}
+	finished := false
+	for {
+		chunks, err := c.GetCommandLogs(ctx, commandID, from)
+		if err != nil {
+			return err
+		}
+		fresh := 0
+		for _, chunk := range chunks {
+			if from != nil && chunk.ChunkIndex < *from || from != nil && chunk.ChunkIndex == *from && delivered[chunk.Stream] {
+				continue
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			fresh++
+			if from == nil || chunk.ChunkIndex > *from {
+				chunkIndex := chunk.ChunkIndex
+				from, delivered = &chunkIndex, map[string]bool{}
+			}
+			delivered[chunk.Stream] = true
+		}
+		if fresh > 0 {
+			continue
+		}
+		if finished {
+			return nil
+		}
+
+		cmd, err := c.GetCommand(ctx, commandID)
+		if err != nil {
+			return err
+		}
+		if IsTerminalStatus(cmd.Status) {
+			// Poll once more for chunks pushed just before the final status
+			finished = true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CancelCommand cancels a command that hasn't been dispatched to its node yet
+// Commands that already left the queue return an APIError with status 409.
+func (c *Client) CancelCommand(ctx context.Context, commandID string) (*dto.CommandDetailResponse, error) {
+	var resp dto.CommandDetailResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/v1/commands/" + url.PathEscape(commandID) + "/cancel"}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteQueuedCommands deletes the queued commands of a node, or of the whole tenant if nodeID is empty,
+// and returns how many were deleted
+func (c *Client) DeleteQueuedCommands(ctx context.Context, nodeID string) (int, error) {
+	query := url.Values{}
+	if nodeID != "" {
+		query.Set("node_id", nodeID)
+	}
+
+	var resp dto.DeleteQueuedCommandsResponse
+	if err := c.do(ctx, request{method: http.MethodDelete, path: "/v1/commands/queued", query: query, idempotent: true}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.DeletedCount, nil
+}
+
+// ListPendingApprovals lists the commands of the operator's tenant waiting for an approval decision, oldest first
+func (c *Client) ListPendingApprovals(ctx context.Context, limit int) ([]dto.CommandApprovalResponse, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var resp dto.ListApprovalsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/approvals", query: query, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Approvals, nil
+}
+
+// ApproveCommand approves a command pending approval, queueing it for dispatch
+// Approving a command you submitted yourself returns an APIError with status 403, and approving one that is
+// no longer pending returns one with status 409.
+func (c *Client) ApproveCommand(ctx context.Context, commandID string, comment string) (*dto.CommandDetailResponse, error) {
+	return c.decideApproval(ctx, commandID, "approve", comment)
+}
+
+// RejectCommand rejects a command pending approval
+func (c *Client) RejectCommand(ctx context.Context, commandID string, comment string) (*dto.CommandDetailResponse, error) {
+	return c.decideApproval(ctx, commandID, "reject", comment)
+}
+
+// decideApproval posts an approval decision, with the comment if it isn't empty
+func (c *Client) decideApproval(ctx context.Context, commandID, decision, comment string) (*dto.CommandDetailResponse, error) {
+	var req dto.ApprovalDecisionRequest
+	if comment != "" {
+		req.Comment = &comment
+	}
+
+	var resp dto.CommandDetailResponse
+	path := "/v1/commands/" + url.PathEscape(commandID) + "/" + decision
+	if err := c.do(ctx, request{method: http.MethodPost, path: path, body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// EvaluatePolicy asks which command policy rule decides on a submission, without submitting it
+// A submission the policy denies makes SubmitCommand return an APIError with status 403.
+func (c *Client) EvaluatePolicy(ctx context.Context, req dto.EvaluatePolicyRequest) (*dto.EvaluatePolicyResponse, error) {
+	var resp dto.EvaluatePolicyResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/v1/policies/evaluate", body: req, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListNodes lists the nodes of the operator's tenant
+func (c *Client) ListNodes(ctx context.Context) ([]dto.NodeResponse, error) {
+	var resp dto.ListNodesResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/agents", idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+// GetNode retrieves a node of the operator's tenant
+func (c *Client) GetNode(ctx context.Context, nodeID string) (*dto.NodeResponse, error) {
+	var resp dto.NodeResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v1/agents/" + url.PathEscape(nodeID), idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateNode sets and removes labels of a node and disables or enables it, returning the updated node
+func (c *Client) UpdateNode(ctx context.Context, nodeID string, req dto.UpdateNodeRequest) (*dto.NodeResponse, error) {
+	var resp dto.NodeResponse
+	err := c.do(ctx, request{
+		method:     http.MethodPatch,
+		path:       "/v1/agents/" + url.PathEscape(nodeID),
+		body:       req,
+		idempotent: true,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls how idempotent requests are retried
+// Requests are retried after network errors and 429, 502, 503 and 504 responses. Submissions are only
+// retried when they carry an idempotency key, and polls, status updates and cancellations never are.
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts, including the first; 0 or 1 disables retries
+	InitialBackoff time.Duration // wait before the second attempt, doubled for each further attempt
+	MaxBackoff     time.Duration // upper bound of the wait; 0 leaves it unbounded
+}
+
+// DefaultRetryPolicy makes up to three attempts, waiting half a second and then a second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// shouldRetry reports whether a request whose attempt failed with err may be tried again
+func (p RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Transport errors come wrapped in a *url.Error; anything else failed before the request was sent
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return wait
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignedCommandVerify(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	now := time.Unix(1700000000, 0)
+	expiresAt := now.Add(time.Hour)
+
+	command := func() SignedCommand {
+		return SignedCommand{
+			CommandID:          "3f0f6f7e-6a51-4a8e-9a5e-1b2c3d4e5f60",
+			NodeID:             "node-1",
+			CommandType:        "RunCommand",
+			Payload:            map[string]interface{}{"cmd": "uptime", "timeout_sec": float64(30)},
+			ExpiresAt:          &expiresAt,
+			SignatureExpiresAt: now.Add(5 * time.Minute),
+		}
+	}
+	sign := func(c SignedCommand, key ed25519.PrivateKey) string {
+		signature, err := c.Sign(key)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return signature
+	}
+	signature := sign(command(), key)
+
+	tests := []struct {
+		name      string
+		modify    func(c *SignedCommand)
+		signature string
+		now       time.Time
+		wantErr   error
+	}{
+		{name: "round trip", signature: signature, now: now},
+		{name: "at expiry", signature: signature, now: now.Add(5 * time.Minute)},
+		{name: "payload with integers", signature: signature, now: now,
+			modify: func(c *SignedCommand) { c.Payload["timeout_sec"] = 30 }},
+
+		{name: "tampered payload", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.Payload["cmd"] = "rm -rf /" }},
+		{name: "added payload field", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.Payload["working_dir"] = "/" }},
+		{name: "other command type", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.CommandType = "UpdateAgent" }},
+		{name: "other command ID", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.CommandID = "00000000-0000-0000-0000-000000000000" }},
+		{name: "replayed to another node", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.NodeID = "node-2" }},
+		{name: "extended deadline", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { later := expiresAt.Add(time.Hour); c.ExpiresAt = &later }},
+		{name: "removed deadline", signature: signature, now: now, wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.ExpiresAt = nil }},
+		{name: "extended signature expiry", signature: signature, now: now.Add(time.Hour), wantErr: ErrInvalidCommandSignature,
+			modify: func(c *SignedCommand) { c.SignatureExpiresAt = now.Add(2 * time.Hour) }},
+		{name: "wrong key", signature: sign(command(), otherKey), now: now, wantErr: ErrInvalidCommandSignature},
+		{name: "replayed after expiry", signature: signature, now: now.Add(5*time.Minute + time.Second), wantErr: ErrCommandSignatureExpired},
+		{name: "missing signature", signature: "", now: now, wantErr: ErrUnsignedCommand},
+		{name: "malformed signature", signature: "not base64!", now: now, wantErr: ErrInvalidCommandSignature},
+		{name: "truncated signature", signature: signature[:20], now: now, wantErr: ErrInvalidCommandSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := command()
+			if tt.modify != nil {
+				tt.modify(&c)
+			}
+			err := c.Verify(key.Public().(ed25519.PublicKey), tt.signature, tt.now)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Verify() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCommandSigningKey(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(nil)
+
+	key, err := ParseCommandSigningKey(EncodeCommandSigningKey(public))
+	if err != nil {
+		t.Fatalf("ParseCommandSigningKey: %v", err)
+	}
+	if !key.Equal(public) {
+		t.Errorf("ParseCommandSigningKey() = %x, want %x", key, public)
+	}
+
+	for _, s := range []string{"", "not base64!", EncodeCommandSigningKey(public[:16])} {
+		if _, err := ParseCommandSigningKey(s); err == nil {
+			t.Errorf("ParseCommandSigningKey(%q) error = nil", s)
+		}
+	}
+}
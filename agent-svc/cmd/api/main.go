@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,7 +11,8 @@ import (
 	"time"
 
 	"agent-svc/app"
-	"agent-svc/storage/postgres"
+
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -18,7 +20,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to bootstrap application: %v", err)
 	}
-	defer app.Storage.(*postgres.Store).Close()
+	defer app.Storage.Close()
 
 	// Start HTTP server
 	server := &http.Server{
@@ -40,6 +42,18 @@ func main() {
 		}
 	}()
 
+	// Start gRPC server
+	listener, err := net.Listen("tcp", ":"+app.Config.GRPCPort)
+	if err != nil {
+		log.Fatalf("failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		log.Printf("gRPC server starting on port %s", app.Config.GRPCPort)
+		if err := app.GRPCServer.Serve(listener); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
 	<-sigChan
 	log.Println("shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -47,4 +61,23 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("server shutdown error: %v", err)
 	}
+	stopGRPC(ctx, app.GRPCServer)
+	if err := app.ShutdownTracing(ctx); err != nil {
+		log.Printf("tracing shutdown error: %v", err)
+	}
+}
+
+// stopGRPC stops the gRPC server gracefully, or forcibly once ctx is done
+// Agent sessions are long-lived streams, so a graceful stop alone could wait for them indefinitely.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
 }
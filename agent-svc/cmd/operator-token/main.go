@@ -0,0 +1,48 @@
+// Command operator-token issues an operator token for the agent-svc operator API
+// It signs the token with JWT_SIGNING_SECRET, as agent-svc does, so it must run where that secret is
+// available. agent-svc takes the operator and their roles from the token alone; anyone holding the secret can
+// issue tokens for any operator.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"agent-svc/app"
+	"agent-svc/app/services"
+)
+
+func main() {
+	operator := flag.String("operator", "", "operator the token identifies (required)")
+	roles := flag.String("roles", "", "comma-separated roles of the operator, matched by operator_roles of command policy rules")
+	ttl := flag.Duration("ttl", 30*24*time.Hour, "how long the token is valid")
+	flag.Parse()
+
+	if *operator == "" {
+		fmt.Fprintln(os.Stderr, "operator-token: -operator is required")
+		os.Exit(2)
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "operator-token: %v\n", err)
+		os.Exit(1)
+	}
+
+	var roleList []string
+	for _, role := range strings.Split(*roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roleList = append(roleList, role)
+		}
+	}
+
+	token, err := services.NewJWTService(cfg.JWTSecret, cfg.JWTExpirationSec).GenerateOperatorToken(*operator, roleList, *ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "operator-token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
@@ -0,0 +1,319 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var approvalCases = []Case{
+	{Name: "approval policies", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		p := &domains.ApprovalPolicy{
+			Name:           uniqueName("conformance-policy"),
+			CommandType:    "shell",
+			PayloadPattern: `rm\s+-rf`,
+			NodeLabels:     map[string]string{"env": "production"},
+			TimeoutSec:     600,
+		}
+		if err := s.CreateApprovalPolicy(ctx, p); err != nil {
+			return fmt.Errorf("CreateApprovalPolicy: %w", err)
+		}
+		if err := check(p.ID != 0 && p.PolicyID != uuid.Nil && recent(p.CreatedAt), "created policy = %+v", p); err != nil {
+			return err
+		}
+
+		got, err := s.GetApprovalPolicy(ctx, p.PolicyID)
+		if err != nil {
+			return fmt.Errorf("GetApprovalPolicy: %w", err)
+		}
+		if err := check(got != nil && got.Name == p.Name && got.PayloadPattern == p.PayloadPattern &&
+			got.NodeLabels["env"] == "production" && got.TimeoutSec == 600, "GetApprovalPolicy = %+v", got); err != nil {
+			return err
+		}
+
+		policies, err := s.ListApprovalPolicies(ctx)
+		if err != nil {
+			return fmt.Errorf("ListApprovalPolicies: %w", err)
+		}
+		listed := false
+		for _, lp := range policies {
+			listed = listed || lp.PolicyID == p.PolicyID
+		}
+		if err := check(listed, "ListApprovalPolicies doesn't list the created policy"); err != nil {
+			return err
+		}
+
+		if deleted, err := s.DeleteApprovalPolicy(ctx, p.PolicyID); err != nil || !deleted {
+			return fmt.Errorf("DeleteApprovalPolicy = %t, %v", deleted, err)
+		}
+		if deleted, err := s.DeleteApprovalPolicy(ctx, p.PolicyID); err != nil || deleted {
+			return fmt.Errorf("DeleteApprovalPolicy of a deleted policy = %t, %v", deleted, err)
+		}
+		got, err = s.GetApprovalPolicy(ctx, p.PolicyID)
+		return check(err == nil && got == nil, "GetApprovalPolicy of a deleted policy = %+v, %v", got, err)
+	}},
+
+	{Name: "commands pending approval", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		approvedID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		rejectedID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+
+		cmd, err := getCommand(ctx, s, approvedID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.Status == domains.StatusPendingApproval, "new command: status %s, want pending_approval", cmd.Status); err != nil {
+			return err
+		}
+		approval, err := s.GetCommandApproval(ctx, approvedID)
+		if err != nil {
+			return fmt.Errorf("GetCommandApproval: %w", err)
+		}
+		if err := check(approval != nil && approval.NodeID == nodeID && approval.CommandType == "conformance.approval" &&
+			approval.RequestedBy == "conformance-submitter" && approval.Decision == nil, "GetCommandApproval = %+v", approval); err != nil {
+			return err
+		}
+
+		claimed, err := s.GetNextCommand(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+		if err := check(len(claimed) == 0, "GetNextCommand handed out %d commands pending approval", len(claimed)); err != nil {
+			return err
+		}
+		err = s.UpdateCommandStatus(ctx, approvedID, domains.StatusRunning, nil, nil, domains.SourceAgent)
+		if err := check(err != nil, "pending_approval -> running succeeded"); err != nil {
+			return err
+		}
+
+		comment := "looks right"
+		if decided, err := s.DecideCommandApproval(ctx, approvedID, domains.ApprovalApproved, "conformance-approver", &comment); err != nil || !decided {
+			return fmt.Errorf("DecideCommandApproval(approved) = %t, %v", decided, err)
+		}
+		if decided, err := s.DecideCommandApproval(ctx, approvedID, domains.ApprovalRejected, "conformance-approver", nil); err != nil || decided {
+			return fmt.Errorf("DecideCommandApproval of a decided command = %t, %v", decided, err)
+		}
+		approval, err = s.GetCommandApproval(ctx, approvedID)
+		if err != nil {
+			return fmt.Errorf("GetCommandApproval: %w", err)
+		}
+		if err := check(approval != nil && approval.Decision != nil && *approval.Decision == domains.ApprovalApproved &&
+			approval.DecidedBy != nil && *approval.DecidedBy == "conformance-approver" && approval.Comment != nil &&
+			*approval.Comment == comment && approval.DecidedAt != nil, "approved request = %+v", approval); err != nil {
+			return err
+		}
+
+		claimed, err = s.GetNextCommand(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+		if err := check(len(claimed) == 1 && claimed[0].CommandID == approvedID, "GetNextCommand returned %d commands, want the approved one",
+			len(claimed)); err != nil {
+			return err
+		}
+
+		if decided, err := s.DecideCommandApproval(ctx, rejectedID, domains.ApprovalRejected, "conformance-approver", nil); err != nil || !decided {
+			return fmt.Errorf("DecideCommandApproval(rejected) = %t, %v", decided, err)
+		}
+		cmd, err = getCommand(ctx, s, rejectedID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.Status == domains.StatusRejected && cmd.ErrorMsg != nil && cmd.FinishedAt != nil,
+			"rejected command: status %s, error %v, finished %v", cmd.Status, cmd.ErrorMsg, cmd.FinishedAt); err != nil {
+			return err
+		}
+
+		history, err := s.GetCommandStatusHistory(ctx, approvedID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		if err := checkHistory(history, approvedID, [][3]string{
+			{"", domains.StatusPendingApproval, domains.SourceSubmit},
+			{domains.StatusPendingApproval, domains.StatusQueued, domains.SourceOperator},
+			{domains.StatusQueued, domains.StatusRunning, domains.SourceDispatch},
+		}); err != nil {
+			return err
+		}
+		history, err = s.GetCommandStatusHistory(ctx, rejectedID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		return checkHistory(history, rejectedID, [][3]string{
+			{"", domains.StatusPendingApproval, domains.SourceSubmit},
+			{domains.StatusPendingApproval, domains.StatusRejected, domains.SourceOperator},
+		})
+	}},
+
+	{Name: "approval requests expire", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		staleID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(-time.Second))
+		if err != nil {
+			return err
+		}
+		freshID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+
+		expired, err := s.ExpireCommandApprovals(ctx)
+		if err != nil {
+			return fmt.Errorf("ExpireCommandApprovals: %w", err)
+		}
+		if err := check(expired >= 1, "ExpireCommandApprovals expired %d commands", expired); err != nil {
+			return err
+		}
+		stale, err := getCommand(ctx, s, staleID)
+		if err != nil {
+			return err
+		}
+		if err := check(stale.Status == domains.StatusExpired && stale.ErrorMsg != nil && stale.FinishedAt != nil,
+			"expired command: status %s, error %v, finished %v", stale.Status, stale.ErrorMsg, stale.FinishedAt); err != nil {
+			return err
+		}
+		approval, err := s.GetCommandApproval(ctx, staleID)
+		if err != nil {
+			return fmt.Errorf("GetCommandApproval: %w", err)
+		}
+		if err := check(approval != nil && approval.Decision != nil && *approval.Decision == domains.ApprovalExpired,
+			"expired request = %+v", approval); err != nil {
+			return err
+		}
+		if decided, err := s.DecideCommandApproval(ctx, staleID, domains.ApprovalApproved, "conformance-approver", nil); err != nil || decided {
+			return fmt.Errorf("DecideCommandApproval of an expired command = %t, %v", decided, err)
+		}
+
+		fresh, err := getCommand(ctx, s, freshID)
+		if err != nil {
+			return err
+		}
+		if err := check(fresh.Status == domains.StatusPendingApproval, "unexpired command: status %s", fresh.Status); err != nil {
+			return err
+		}
+
+		history, err := s.GetCommandStatusHistory(ctx, staleID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		return checkHistory(history, staleID, [][3]string{
+			{"", domains.StatusPendingApproval, domains.SourceSubmit},
+			{domains.StatusPendingApproval, domains.StatusExpired, domains.SourceSystem},
+		})
+	}},
+
+	{Name: "pending approvals are listed per tenant", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		t := &domains.Tenant{TenantID: uniqueName("conformance-tenant"), Name: "Conformance"}
+		if err := s.CreateTenant(ctx, t); err != nil {
+			return fmt.Errorf("CreateTenant: %w", err)
+		}
+		nodeID := uniqueName("conformance-node")
+		if err := s.RegisterNode(ctx, t.TenantID, nodeID, map[string]interface{}{"os": "linux"}); err != nil {
+			return fmt.Errorf("RegisterNode: %w", err)
+		}
+		pendingID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		cancelledID, err := createPendingCommand(ctx, s, nodeID, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		if _, err := createCommand(ctx, s, nodeID, "conformance.approval", domains.CommandOptions{}); err != nil {
+			return err
+		}
+
+		errorMsg := "cancelled by operator"
+		if err := s.UpdateCommandStatus(ctx, cancelledID, domains.StatusCancelled, nil, &errorMsg, domains.SourceOperator); err != nil {
+			return fmt.Errorf("UpdateCommandStatus(cancelled): %w", err)
+		}
+
+		approvals, err := s.ListPendingApprovals(ctx, t.TenantID, 100)
+		if err != nil {
+			return fmt.Errorf("ListPendingApprovals: %w", err)
+		}
+		if err := check(len(approvals) == 1 && approvals[0].CommandID == pendingID,
+			"ListPendingApprovals of the tenant returned %d requests, want the pending one", len(approvals)); err != nil {
+			return err
+		}
+		others, err := s.ListPendingApprovals(ctx, domains.DefaultTenantID, 1000)
+		if err != nil {
+			return fmt.Errorf("ListPendingApprovals: %w", err)
+		}
+		for _, a := range others {
+			if a.CommandID == pendingID {
+				return fmt.Errorf("ListPendingApprovals of another tenant lists %s", pendingID)
+			}
+		}
+		return nil
+	}},
+
+	{Name: "command policy versions", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		first := &domains.CommandPolicy{DefaultAction: domains.PolicyAllow, CreatedBy: "conformance-admin"}
+		if err := s.CreateCommandPolicy(ctx, first); err != nil {
+			return fmt.Errorf("CreateCommandPolicy: %w", err)
+		}
+		if err := check(first.ID != 0 && first.PolicyID != uuid.Nil && first.Version > 0 && recent(first.CreatedAt),
+			"created policy = %+v", first); err != nil {
+			return err
+		}
+
+		second := &domains.CommandPolicy{
+			DefaultAction: domains.PolicyAllow,
+			Rules: []domains.PolicyRule{{
+				Name:          uniqueName("conformance-rule"),
+				Action:        domains.PolicyRequireApproval,
+				CommandTypes:  []string{"RunCommand"},
+				CommandGlob:   "rm -rf *",
+				NodeLabels:    map[string]string{"env": "production"},
+				TimeWindow:    &domains.PolicyTimeWindow{Days: []string{"sat", "sun"}, Start: "22:00", End: "06:00", Timezone: "Europe/Berlin"},
+				OperatorRoles: []string{"oncall"},
+			}},
+			CreatedBy: "conformance-admin",
+		}
+		if err := s.CreateCommandPolicy(ctx, second); err != nil {
+			return fmt.Errorf("CreateCommandPolicy: %w", err)
+		}
+		if err := check(second.Version == first.Version+1 && second.PolicyID != first.PolicyID,
+			"second policy: version %d after %d", second.Version, first.Version); err != nil {
+			return err
+		}
+
+		got, err := s.GetCommandPolicy(ctx)
+		if err != nil {
+			return fmt.Errorf("GetCommandPolicy: %w", err)
+		}
+		if err := check(got != nil && got.PolicyID == second.PolicyID && got.Version == second.Version &&
+			got.CreatedBy == "conformance-admin" && len(got.Rules) == 1, "GetCommandPolicy = %+v", got); err != nil {
+			return err
+		}
+		rule := got.Rules[0]
+		return check(rule.Name == second.Rules[0].Name && rule.Action == domains.PolicyRequireApproval && rule.CommandGlob == "rm -rf *" &&
+			rule.NodeLabels["env"] == "production" && rule.TimeWindow != nil && rule.TimeWindow.Timezone == "Europe/Berlin" &&
+			len(rule.TimeWindow.Days) == 2 && len(rule.OperatorRoles) == 1, "stored rule = %+v", rule)
+	}},
+}
+
+// createPendingCommand creates a command pending approval on a node, submitted by conformance-submitter
+func createPendingCommand(ctx context.Context, s clients.StorageAdapter, nodeID string, expiresAt time.Time) (uuid.UUID, error) {
+	return createCommand(ctx, s, nodeID, "conformance.approval", domains.CommandOptions{
+		OperatorID: "conformance-submitter",
+		Approval:   &domains.ApprovalRequest{PolicyID: uuid.New(), PolicyName: "conformance", ExpiresAt: expiresAt},
+	})
+}
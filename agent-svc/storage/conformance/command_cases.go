@@ -0,0 +1,747 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var nodeCases = []Case{
+	{Name: "readiness", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		if err := s.Ping(ctx); err != nil {
+			return fmt.Errorf("Ping: %w", err)
+		}
+		version, dirty, err := s.MigrationVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("MigrationVersion: %w", err)
+		}
+		return check(version > 0 && !dirty, "MigrationVersion = %d (dirty %t), want a clean version", version, dirty)
+	}},
+
+	{Name: "nodes register, update and list", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID := uniqueName("conformance-node")
+		if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, map[string]interface{}{"os": "linux", "cpu_cores": 4}); err != nil {
+			return fmt.Errorf("RegisterNode: %w", err)
+		}
+		node, err := s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if node == nil {
+			return fmt.Errorf("GetNode: registered node not found")
+		}
+		if err := check(node.Attrs["os"] == "linux" && node.Attrs["cpu_cores"] == float64(4), "attrs = %v", node.Attrs); err != nil {
+			return err
+		}
+		if err := check(!node.Disabled && recent(node.LastSeenAt), "disabled %t, last seen %s", node.Disabled, node.LastSeenAt); err != nil {
+			return err
+		}
+
+		// Registering again replaces the attrs
+		if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, map[string]interface{}{"os": "darwin"}); err != nil {
+			return fmt.Errorf("RegisterNode again: %w", err)
+		}
+		node, err = s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(node.Attrs["os"] == "darwin" && node.Attrs["cpu_cores"] == nil, "attrs after re-register = %v", node.Attrs); err != nil {
+			return err
+		}
+
+		if err := s.UpdateNodeLastSeen(ctx, nodeID); err != nil {
+			return fmt.Errorf("UpdateNodeLastSeen: %w", err)
+		}
+		if err := s.UpdateNodeLastSeen(ctx, uniqueName("conformance-unknown")); err != nil {
+			return fmt.Errorf("UpdateNodeLastSeen of an unknown node: %w", err)
+		}
+
+		hostname, cores := "conformance-host", 8
+		metadata := &domains.AgentMetadata{Hostname: &hostname, CPUCores: &cores}
+		for i := 0; i < 2; i++ {
+			if err := s.UpdateAgentMetadata(ctx, nodeID, metadata); err != nil {
+				return fmt.Errorf("UpdateAgentMetadata: %w", err)
+			}
+		}
+
+		unknown, err := s.GetNode(ctx, uniqueName("conformance-unknown"))
+		if err != nil {
+			return fmt.Errorf("GetNode of an unknown node: %w", err)
+		}
+		if err := check(unknown == nil, "GetNode of an unknown node returned %v", unknown); err != nil {
+			return err
+		}
+
+		nodes, err := s.ListNodes(ctx, "")
+		if err != nil {
+			return fmt.Errorf("ListNodes: %w", err)
+		}
+		for _, n := range nodes {
+			if n.NodeID == nodeID {
+				return nil
+			}
+		}
+		return fmt.Errorf("ListNodes does not include %s", nodeID)
+	}},
+	{Name: "node labels and disabling", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		node, err := s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(len(node.Labels) == 0, "labels of a new node = %v", node.Labels); err != nil {
+			return err
+		}
+
+		if ok, err := s.UpdateNodeLabels(ctx, nodeID, map[string]string{"role": "web", "zone": "a"}, nil); err != nil || !ok {
+			return fmt.Errorf("UpdateNodeLabels = %t, %v", ok, err)
+		}
+		// Removals apply before the labels being set
+		if ok, err := s.UpdateNodeLabels(ctx, nodeID, map[string]string{"zone": "b"}, []string{"role", "zone", "missing"}); err != nil || !ok {
+			return fmt.Errorf("UpdateNodeLabels again = %t, %v", ok, err)
+		}
+		if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, map[string]interface{}{"os": "linux"}); err != nil {
+			return fmt.Errorf("RegisterNode again: %w", err)
+		}
+		node, err = s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(len(node.Labels) == 1 && node.Labels["zone"] == "b", "labels after update and re-register = %v", node.Labels); err != nil {
+			return err
+		}
+		if ok, err := s.UpdateNodeLabels(ctx, uniqueName("conformance-unknown"), map[string]string{"role": "web"}, nil); err != nil || ok {
+			return fmt.Errorf("UpdateNodeLabels of an unknown node = %t, %v", ok, err)
+		}
+
+		if ok, err := s.SetNodeDisabled(ctx, nodeID, true); err != nil || !ok {
+			return fmt.Errorf("SetNodeDisabled = %t, %v", ok, err)
+		}
+		nodes, err := s.ListNodes(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListNodes: %w", err)
+		}
+		disabled := false
+		for _, n := range nodes {
+			if n.NodeID == nodeID {
+				disabled = n.Disabled && n.Labels["zone"] == "b"
+			}
+		}
+		if err := check(disabled, "ListNodes doesn't report %s disabled with its labels", nodeID); err != nil {
+			return err
+		}
+		if ok, err := s.SetNodeDisabled(ctx, nodeID, false); err != nil || !ok {
+			return fmt.Errorf("SetNodeDisabled false = %t, %v", ok, err)
+		}
+		node, err = s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(!node.Disabled, "node still disabled after enabling it"); err != nil {
+			return err
+		}
+		if ok, err := s.SetNodeDisabled(ctx, uniqueName("conformance-unknown"), true); err != nil || ok {
+			return fmt.Errorf("SetNodeDisabled of an unknown node = %t, %v", ok, err)
+		}
+		return nil
+	}},
+	{Name: "node local policy hash", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID := uniqueName("conformance-node")
+		if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, nil); err != nil {
+			return fmt.Errorf("RegisterNode: %w", err)
+		}
+		node, err := s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(node.LocalPolicyHash == "", "local policy hash of a new node = %q", node.LocalPolicyHash); err != nil {
+			return err
+		}
+
+		const hash = "sha256:0123456789abcdef"
+		if ok, err := s.SetNodePolicyHash(ctx, nodeID, hash); err != nil || !ok {
+			return fmt.Errorf("SetNodePolicyHash = %t, %v", ok, err)
+		}
+		if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, nil); err != nil {
+			return fmt.Errorf("RegisterNode again: %w", err)
+		}
+		nodes, err := s.ListNodes(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListNodes: %w", err)
+		}
+		listed := ""
+		for _, n := range nodes {
+			if n.NodeID == nodeID {
+				listed = n.LocalPolicyHash
+			}
+		}
+		if err := check(listed == hash, "ListNodes reports local policy hash %q, want %q", listed, hash); err != nil {
+			return err
+		}
+
+		if ok, err := s.SetNodePolicyHash(ctx, nodeID, ""); err != nil || !ok {
+			return fmt.Errorf("SetNodePolicyHash empty = %t, %v", ok, err)
+		}
+		node, err = s.GetNode(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(node.LocalPolicyHash == "", "local policy hash after clearing it = %q", node.LocalPolicyHash); err != nil {
+			return err
+		}
+		if ok, err := s.SetNodePolicyHash(ctx, uniqueName("conformance-unknown"), hash); err != nil || ok {
+			return fmt.Errorf("SetNodePolicyHash of an unknown node = %t, %v", ok, err)
+		}
+		return nil
+	}},
+}
+
+var commandCases = []Case{
+	{Name: "commands need a registered node", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		_, err := s.CreateCommand(ctx, uniqueName("conformance-unknown"), "conformance.orphan", map[string]interface{}{}, domains.CommandOptions{})
+		return check(err != nil, "CreateCommand for an unknown node succeeded")
+	}},
+
+	{Name: "command lifecycle and status history", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.lifecycle", domains.CommandOptions{
+			TraceContext: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		})
+		if err != nil {
+			return err
+		}
+
+		cmd, err := getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.Status == domains.StatusQueued && cmd.Priority == domains.PriorityDefault && cmd.Attempt == 1,
+			"new command: status %s, priority %d, attempt %d", cmd.Status, cmd.Priority, cmd.Attempt); err != nil {
+			return err
+		}
+		if err := check(cmd.NodeID == nodeID && cmd.Payload["cmd"] == "true" && recent(cmd.CreatedAt),
+			"new command: node %s, payload %v, created %s", cmd.NodeID, cmd.Payload, cmd.CreatedAt); err != nil {
+			return err
+		}
+		if err := check(cmd.TraceContext["traceparent"] != "" && cmd.RetryPolicy == nil && cmd.ParentCommandID == nil,
+			"new command: trace context %v, retry policy %v, parent %v", cmd.TraceContext, cmd.RetryPolicy, cmd.ParentCommandID); err != nil {
+			return err
+		}
+
+		claimed, err := s.GetNextCommand(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+		if err := check(len(claimed) == 1 && claimed[0].CommandID == commandID && claimed[0].Status == domains.StatusRunning &&
+			claimed[0].DispatchedAt != nil, "GetNextCommand returned %d commands, want the queued one running", len(claimed)); err != nil {
+			return err
+		}
+
+		// The agent confirms the start, then reports the result
+		if err := s.UpdateCommandStatus(ctx, commandID, domains.StatusRunning, nil, nil, domains.SourceAgent); err != nil {
+			return fmt.Errorf("UpdateCommandStatus(running): %w", err)
+		}
+		exitCode := 0
+		if err := s.UpdateCommandStatus(ctx, commandID, domains.StatusSuccess, &exitCode, nil, domains.SourceAgent); err != nil {
+			return fmt.Errorf("UpdateCommandStatus(success): %w", err)
+		}
+		cmd, err = getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.Status == domains.StatusSuccess && cmd.ExitCode != nil && *cmd.ExitCode == 0 &&
+			cmd.StartedAt != nil && cmd.FinishedAt != nil, "finished command: status %s, exit code %v, started %v, finished %v",
+			cmd.Status, cmd.ExitCode, cmd.StartedAt, cmd.FinishedAt); err != nil {
+			return err
+		}
+
+		err = s.UpdateCommandStatus(ctx, commandID, domains.StatusRunning, nil, nil, domains.SourceAgent)
+		if err := check(errors.Is(err, domains.ErrInvalidStatusTransition), "success -> running returned %v", err); err != nil {
+			return err
+		}
+		err = s.UpdateCommandStatus(ctx, uuid.New(), domains.StatusRunning, nil, nil, domains.SourceAgent)
+		if err := check(err != nil, "UpdateCommandStatus of an unknown command succeeded"); err != nil {
+			return err
+		}
+
+		history, err := s.GetCommandStatusHistory(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		want := [][3]string{
+			{"", domains.StatusQueued, domains.SourceSubmit},
+			{domains.StatusQueued, domains.StatusRunning, domains.SourceDispatch},
+			{domains.StatusRunning, domains.StatusRunning, domains.SourceAgent},
+			{domains.StatusRunning, domains.StatusSuccess, domains.SourceAgent},
+		}
+		return checkHistory(history, commandID, want)
+	}},
+
+	{Name: "dispatch order and claim limit", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		priorities := []int{1, 9, 5, 5, 9, 1, 5}
+		commandIDs := make([]uuid.UUID, len(priorities))
+		for i, priority := range priorities {
+			priority := priority
+			if commandIDs[i], err = createCommand(ctx, s, nodeID, "conformance.order", domains.CommandOptions{Priority: &priority}); err != nil {
+				return err
+			}
+			// Distinct creation times keep the order among equal priorities defined
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		wantOrder := [][]int{{1, 4, 2, 3, 6}, {0, 5}, nil}
+		for round, want := range wantOrder {
+			claimed, err := s.GetNextCommand(ctx, nodeID)
+			if err != nil {
+				return fmt.Errorf("GetNextCommand: %w", err)
+			}
+			if len(claimed) != len(want) {
+				return fmt.Errorf("claim %d returned %d commands, want %d", round+1, len(claimed), len(want))
+			}
+			for i, idx := range want {
+				if claimed[i].CommandID != commandIDs[idx] {
+					return fmt.Errorf("claim %d position %d is the command with priority %d, want command %d",
+						round+1, i, claimed[i].Priority, idx)
+				}
+			}
+		}
+		return nil
+	}},
+
+	{Name: "queued commands expire", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		expiresAt := time.Now().Add(-time.Second)
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.expiry", domains.CommandOptions{ExpiresAt: &expiresAt})
+		if err != nil {
+			return err
+		}
+
+		claimed, err := s.GetNextCommand(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+		if err := check(len(claimed) == 0, "GetNextCommand handed out an expired command"); err != nil {
+			return err
+		}
+
+		expired, err := s.ExpireQueuedCommands(ctx)
+		if err != nil {
+			return fmt.Errorf("ExpireQueuedCommands: %w", err)
+		}
+		if err := check(expired >= 1, "ExpireQueuedCommands expired %d commands", expired); err != nil {
+			return err
+		}
+		cmd, err := getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.Status == domains.StatusExpired && cmd.ErrorMsg != nil && cmd.FinishedAt != nil,
+			"expired command: status %s, error %v, finished %v", cmd.Status, cmd.ErrorMsg, cmd.FinishedAt); err != nil {
+			return err
+		}
+
+		history, err := s.GetCommandStatusHistory(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		return checkHistory(history, commandID, [][3]string{
+			{"", domains.StatusQueued, domains.SourceSubmit},
+			{domains.StatusQueued, domains.StatusExpired, domains.SourceSystem},
+		})
+	}},
+
+	{Name: "running commands of silent nodes are listed as lost", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		running, err := createCommand(ctx, s, nodeID, "conformance.lost", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		// Dispatching moves the command to running
+		if err := finishCommand(ctx, s, nodeID, running, domains.StatusRunning); err != nil {
+			return err
+		}
+		queued, err := createCommand(ctx, s, nodeID, "conformance.lost", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		contains := func(commands []*domains.NodeCommand, commandID uuid.UUID) bool {
+			for _, cmd := range commands {
+				if cmd.CommandID == commandID {
+					return true
+				}
+			}
+			return false
+		}
+		lost, err := s.ListLostCommands(ctx, 10*time.Millisecond, 1000)
+		if err != nil {
+			return fmt.Errorf("ListLostCommands: %w", err)
+		}
+		if err := check(contains(lost, running) && !contains(lost, queued),
+			"ListLostCommands: running listed %t, queued listed %t", contains(lost, running), contains(lost, queued)); err != nil {
+			return err
+		}
+
+		if err := s.UpdateNodeLastSeen(ctx, nodeID); err != nil {
+			return fmt.Errorf("UpdateNodeLastSeen: %w", err)
+		}
+		lost, err = s.ListLostCommands(ctx, time.Minute, 1000)
+		if err != nil {
+			return fmt.Errorf("ListLostCommands: %w", err)
+		}
+		return check(!contains(lost, running), "ListLostCommands listed a command of a node seen just now")
+	}},
+
+	{Name: "idempotency keys", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		key := uniqueName("key")
+		opts := domains.CommandOptions{
+			OperatorID:           "conformance",
+			IdempotencyKey:       key,
+			RequestHash:          "hash-1",
+			IdempotencyExpiresAt: time.Now().Add(time.Hour),
+		}
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.idempotent", opts)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.CreateCommand(ctx, nodeID, "conformance.idempotent", map[string]interface{}{}, opts)
+		if err := check(errors.Is(err, domains.ErrDuplicateIdempotencyKey), "reusing a live key returned %v", err); err != nil {
+			return err
+		}
+		commands, err := s.ListCommands(ctx, "", &nodeID, 0)
+		if err != nil {
+			return fmt.Errorf("ListCommands: %w", err)
+		}
+		if err := check(len(commands) == 1, "a rejected duplicate left %d commands", len(commands)); err != nil {
+			return err
+		}
+
+		k, err := s.GetIdempotencyKey(ctx, "conformance", nodeID, key)
+		if err != nil {
+			return fmt.Errorf("GetIdempotencyKey: %w", err)
+		}
+		if err := check(k != nil && k.CommandID == commandID && k.RequestHash == "hash-1", "GetIdempotencyKey = %+v", k); err != nil {
+			return err
+		}
+		other, err := s.GetIdempotencyKey(ctx, "someone-else", nodeID, key)
+		if err != nil {
+			return fmt.Errorf("GetIdempotencyKey: %w", err)
+		}
+		if err := check(other == nil, "keys are not scoped to the operator"); err != nil {
+			return err
+		}
+
+		// An expired key is invisible and can be taken over
+		expiredKey := uniqueName("key")
+		opts.IdempotencyKey, opts.IdempotencyExpiresAt = expiredKey, time.Now().Add(-time.Second)
+		if _, err := createCommand(ctx, s, nodeID, "conformance.idempotent", opts); err != nil {
+			return err
+		}
+		if k, err := s.GetIdempotencyKey(ctx, "conformance", nodeID, expiredKey); err != nil || k != nil {
+			return fmt.Errorf("GetIdempotencyKey of an expired key = %+v, %v", k, err)
+		}
+		opts.RequestHash, opts.IdempotencyExpiresAt = "hash-2", time.Now().Add(time.Hour)
+		takenOver, err := createCommand(ctx, s, nodeID, "conformance.idempotent", opts)
+		if err != nil {
+			return fmt.Errorf("taking over an expired key: %w", err)
+		}
+		k, err = s.GetIdempotencyKey(ctx, "conformance", nodeID, expiredKey)
+		if err != nil {
+			return fmt.Errorf("GetIdempotencyKey: %w", err)
+		}
+		if err := check(k != nil && k.CommandID == takenOver && k.RequestHash == "hash-2", "taken over key = %+v", k); err != nil {
+			return err
+		}
+
+		if err := s.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+			return fmt.Errorf("DeleteExpiredIdempotencyKeys: %w", err)
+		}
+		k, err = s.GetIdempotencyKey(ctx, "conformance", nodeID, key)
+		if err != nil {
+			return fmt.Errorf("GetIdempotencyKey: %w", err)
+		}
+		return check(k != nil, "DeleteExpiredIdempotencyKeys deleted a live key")
+	}},
+
+	{Name: "retry attempts", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		policy := &domains.RetryPolicy{MaxAttempts: 3, BackoffSec: 1, BackoffMultiplier: 2, RetryOnStatuses: []string{domains.StatusFailed}}
+		priority := 7
+		expiresAt := time.Now().Add(time.Hour)
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.retry",
+			domains.CommandOptions{RetryPolicy: policy, Priority: &priority, ExpiresAt: &expiresAt})
+		if err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, commandID, domains.StatusFailed); err != nil {
+			return err
+		}
+
+		// Later attempts get their own delivery deadline, as far from their creation as the first one's
+		time.Sleep(20 * time.Millisecond)
+		if err := s.ScheduleCommandRetry(ctx, commandID, time.Now().Add(-time.Second)); err != nil {
+			return fmt.Errorf("ScheduleCommandRetry: %w", err)
+		}
+		cmd, err := getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.NextRetryAt != nil, "ScheduleCommandRetry did not set next_retry_at"); err != nil {
+			return err
+		}
+
+		created, err := s.CreateRetryAttempts(ctx, 1000)
+		if err != nil {
+			return fmt.Errorf("CreateRetryAttempts: %w", err)
+		}
+		if err := check(created >= 1, "CreateRetryAttempts created %d attempts", created); err != nil {
+			return err
+		}
+
+		attempts, err := s.GetCommandAttempts(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandAttempts: %w", err)
+		}
+		if len(attempts) != 2 {
+			return fmt.Errorf("GetCommandAttempts returned %d attempts, want 2", len(attempts))
+		}
+		first, second := attempts[0], attempts[1]
+		if err := check(first.CommandID == commandID && first.NextRetryAt == nil, "first attempt %s, next retry %v",
+			first.CommandID, first.NextRetryAt); err != nil {
+			return err
+		}
+		if err := check(second.Attempt == 2 && second.ParentCommandID != nil && *second.ParentCommandID == commandID &&
+			second.Status == domains.StatusQueued && second.Priority == 7, "second attempt: attempt %d, parent %v, status %s, priority %d",
+			second.Attempt, second.ParentCommandID, second.Status, second.Priority); err != nil {
+			return err
+		}
+		if err := check(second.RetryPolicy != nil && second.RetryPolicy.MaxAttempts == 3 && second.Payload["cmd"] == "true" &&
+			second.FirstAttemptID() == commandID, "second attempt: retry policy %+v, payload %v", second.RetryPolicy, second.Payload); err != nil {
+			return err
+		}
+		if second.ExpiresAt == nil || first.ExpiresAt == nil {
+			return fmt.Errorf("attempt expires_at %v, %v, want both set", first.ExpiresAt, second.ExpiresAt)
+		}
+		ttl, nextTTL := first.ExpiresAt.Sub(first.CreatedAt), second.ExpiresAt.Sub(second.CreatedAt)
+		if err := check(second.ExpiresAt.After(*first.ExpiresAt) && (nextTTL-ttl).Abs() < time.Millisecond,
+			"second attempt expires %s after creation, first %s", nextTTL, ttl); err != nil {
+			return err
+		}
+
+		again, err := s.CreateRetryAttempts(ctx, 1000)
+		if err != nil {
+			return fmt.Errorf("CreateRetryAttempts: %w", err)
+		}
+		attempts, err = s.GetCommandAttempts(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandAttempts: %w", err)
+		}
+		if err := check(len(attempts) == 2, "a retry was created twice (%d more attempts created)", again); err != nil {
+			return err
+		}
+
+		history, err := s.GetCommandStatusHistory(ctx, second.CommandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		return checkHistory(history, second.CommandID, [][3]string{{"", domains.StatusQueued, domains.SourceSystem}})
+	}},
+
+	{Name: "command output statistics", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.output", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+
+		if err := s.UpdateCommandOutput(ctx, commandID, domains.CommandOutput{TotalBytes: 42}); err != nil {
+			return fmt.Errorf("UpdateCommandOutput: %w", err)
+		}
+		cmd, err := getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		if err := check(cmd.OutputBytes != nil && *cmd.OutputBytes == 42 && !cmd.OutputTruncated,
+			"output bytes %v, truncated %t", cmd.OutputBytes, cmd.OutputTruncated); err != nil {
+			return err
+		}
+
+		// Truncation is sticky: a later report without it doesn't clear the flag
+		if err := s.MarkCommandOutputTruncated(ctx, commandID); err != nil {
+			return fmt.Errorf("MarkCommandOutputTruncated: %w", err)
+		}
+		if err := s.UpdateCommandOutput(ctx, commandID, domains.CommandOutput{TotalBytes: 64}); err != nil {
+			return fmt.Errorf("UpdateCommandOutput: %w", err)
+		}
+		cmd, err = getCommand(ctx, s, commandID)
+		if err != nil {
+			return err
+		}
+		return check(*cmd.OutputBytes == 64 && cmd.OutputTruncated, "output bytes %d, truncated %t", *cmd.OutputBytes, cmd.OutputTruncated)
+	}},
+
+	{Name: "delete queued commands", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		running, err := createCommand(ctx, s, nodeID, "conformance.delete", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		if _, err := s.GetNextCommand(ctx, nodeID); err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+		var queued []uuid.UUID
+		for i := 0; i < 2; i++ {
+			commandID, err := createCommand(ctx, s, nodeID, "conformance.delete", domains.CommandOptions{
+				OperatorID: "conformance", IdempotencyKey: uniqueName("key"), RequestHash: "hash",
+				IdempotencyExpiresAt: time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				return err
+			}
+			queued = append(queued, commandID)
+		}
+		if _, err := s.InsertLogChunks(ctx, queued[0], []domains.CommandLog{{ChunkIndex: 0, Stream: "stdout", Data: "x", Encoding: "utf-8"}}); err != nil {
+			return fmt.Errorf("InsertLogChunks: %w", err)
+		}
+
+		deleted, err := s.DeleteQueuedCommands(ctx, "", &nodeID)
+		if err != nil {
+			return fmt.Errorf("DeleteQueuedCommands: %w", err)
+		}
+		if err := check(deleted == 2, "DeleteQueuedCommands deleted %d commands, want 2", deleted); err != nil {
+			return err
+		}
+
+		for _, commandID := range queued {
+			if cmd, err := s.GetCommandByID(ctx, commandID); err != nil || cmd != nil {
+				return fmt.Errorf("deleted command is still there: %v, %v", cmd, err)
+			}
+			history, err := s.GetCommandStatusHistory(ctx, commandID)
+			if err != nil {
+				return fmt.Errorf("GetCommandStatusHistory: %w", err)
+			}
+			if err := check(len(history) == 0, "deleted command kept %d history rows", len(history)); err != nil {
+				return err
+			}
+		}
+		logs, err := s.GetCommandLogs(ctx, "", queued[0], nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		if err := check(len(logs) == 0, "deleted command kept %d log chunks", len(logs)); err != nil {
+			return err
+		}
+
+		cmd, err := getCommand(ctx, s, running)
+		if err != nil {
+			return err
+		}
+		return check(cmd.Status == domains.StatusRunning, "running command has status %s", cmd.Status)
+	}},
+
+	{Name: "list and count commands", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		commandType := uniqueName("conformance.count")
+		var commandIDs []uuid.UUID
+		for i := 0; i < 3; i++ {
+			commandID, err := createCommand(ctx, s, nodeID, commandType, domains.CommandOptions{})
+			if err != nil {
+				return err
+			}
+			commandIDs = append(commandIDs, commandID)
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		commands, err := s.ListCommands(ctx, "", &nodeID, 2)
+		if err != nil {
+			return fmt.Errorf("ListCommands: %w", err)
+		}
+		if err := check(len(commands) == 2 && commands[0].CommandID == commandIDs[2] && commands[1].CommandID == commandIDs[1],
+			"ListCommands with a limit of 2 returned %d commands, want the newest first", len(commands)); err != nil {
+			return err
+		}
+		commands, err = s.ListCommands(ctx, "", &nodeID, 0)
+		if err != nil {
+			return fmt.Errorf("ListCommands: %w", err)
+		}
+		if err := check(len(commands) == 3, "ListCommands without a limit returned %d commands", len(commands)); err != nil {
+			return err
+		}
+		all, err := s.ListCommands(ctx, "", nil, 0)
+		if err != nil {
+			return fmt.Errorf("ListCommands: %w", err)
+		}
+		if err := check(len(all) >= 3, "ListCommands of all nodes returned %d commands", len(all)); err != nil {
+			return err
+		}
+
+		counts, err := s.CountCommandsByStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("CountCommandsByStatus: %w", err)
+		}
+		for _, c := range counts {
+			if c.CommandType == commandType {
+				return check(c.Status == domains.StatusQueued && c.Count == 3, "count = %+v, want 3 queued", c)
+			}
+		}
+		return fmt.Errorf("CountCommandsByStatus has no count for %s", commandType)
+	}},
+}
+
+// checkHistory compares status history with the wanted (from, to, source) transitions
+func checkHistory(history []domains.CommandStatusChange, commandID uuid.UUID, want [][3]string) error {
+	if len(history) != len(want) {
+		return fmt.Errorf("status history has %d transitions, want %d", len(history), len(want))
+	}
+	for i, change := range history {
+		from := ""
+		if change.FromStatus != nil {
+			from = *change.FromStatus
+		}
+		got := [3]string{from, change.ToStatus, change.Source}
+		if got != want[i] || change.CommandID != commandID.String() {
+			return fmt.Errorf("status history %d = %v of %s, want %v", i, got, change.CommandID, want[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Package conformance checks that every clients.StorageAdapter implementation behaves the same way
+// The cases run through the adapter interface only and use unique node IDs and names, so they can share
+// a database with earlier runs. The tests of each store package run them with Run.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// Case is a single behaviour every backend must share
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, s clients.StorageAdapter) error
+}
+
+// Cases returns all conformance cases in the order they run
+func Cases() []Case {
+	var cases []Case
+	cases = append(cases, nodeCases...)
+	cases = append(cases, commandCases...)
+	cases = append(cases, logCases...)
+	cases = append(cases, scheduleCases...)
+	cases = append(cases, workflowCases...)
+	cases = append(cases, rolloutCases...)
+	cases = append(cases, templateCases...)
+	cases = append(cases, webhookCases...)
+	cases = append(cases, approvalCases...)
+	cases = append(cases, tenantCases...)
+	return cases
+}
+
+// Run runs every case against s in order, each as a subtest of t
+// A case that panics fails with the panic instead of stopping the run.
+func Run(t *testing.T, s clients.StorageAdapter) {
+	for _, c := range Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := runCase(context.Background(), s, c); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// runCase runs one case, turning a panic into an error
+func runCase(ctx context.Context, s clients.StorageAdapter, c Case) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return c.Run(ctx, s)
+}
+
+// uniqueName returns prefix with a random suffix, so cases never see rows of earlier runs
+func uniqueName(prefix string) string {
+	return prefix + "-" + uuid.NewString()[:8]
+}
+
+// check returns an error built from format and args unless ok
+func check(ok bool, format string, args ...interface{}) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// registerNode registers a node with a unique ID and returns the ID
+func registerNode(ctx context.Context, s clients.StorageAdapter) (string, error) {
+	nodeID := uniqueName("conformance-node")
+	if err := s.RegisterNode(ctx, domains.DefaultTenantID, nodeID, map[string]interface{}{"os": "linux"}); err != nil {
+		return "", fmt.Errorf("RegisterNode: %w", err)
+	}
+	return nodeID, nil
+}
+
+// createCommand queues a command of commandType on a node
+func createCommand(ctx context.Context, s clients.StorageAdapter, nodeID, commandType string, opts domains.CommandOptions) (uuid.UUID, error) {
+	commandID, err := s.CreateCommand(ctx, nodeID, commandType, map[string]interface{}{"cmd": "true"}, opts)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("CreateCommand: %w", err)
+	}
+	return commandID, nil
+}
+
+// getCommand retrieves a command that must exist
+func getCommand(ctx context.Context, s clients.StorageAdapter, commandID uuid.UUID) (*domains.NodeCommand, error) {
+	cmd, err := s.GetCommandByID(ctx, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("GetCommandByID: %w", err)
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("GetCommandByID: command %s not found", commandID)
+	}
+	return cmd, nil
+}
+
+// finishCommand dispatches the queued commands of a node and moves commandID to status
+// Every other command claimed along the way is left running.
+func finishCommand(ctx context.Context, s clients.StorageAdapter, nodeID string, commandID uuid.UUID, status string) error {
+	cmd, err := getCommand(ctx, s, commandID)
+	if err != nil {
+		return err
+	}
+	if cmd.Status == domains.StatusQueued {
+		if _, err := s.GetNextCommand(ctx, nodeID); err != nil {
+			return fmt.Errorf("GetNextCommand: %w", err)
+		}
+	}
+	exitCode := 0
+	if status != domains.StatusSuccess {
+		exitCode = 1
+	}
+	if err := s.UpdateCommandStatus(ctx, commandID, status, &exitCode, nil, domains.SourceAgent); err != nil {
+		return fmt.Errorf("UpdateCommandStatus(%s): %w", status, err)
+	}
+	return nil
+}
+
+// recent reports whether t is within a minute of now, allowing for stores that round timestamps
+func recent(t time.Time) bool {
+	d := time.Since(t)
+	return d > -time.Minute && d < time.Minute
+}
@@ -0,0 +1,548 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var scheduleCases = []Case{
+	{Name: "schedules need a registered node", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID := uniqueName("conformance-unknown")
+		next := time.Now().Add(time.Hour)
+		sched := &domains.Schedule{
+			TenantID: domains.DefaultTenantID, Name: uniqueName("conformance-schedule"), CronExpr: "@hourly", Timezone: "UTC", NodeID: &nodeID,
+			CommandType: "conformance.schedule", Payload: map[string]interface{}{}, Priority: domains.PriorityDefault,
+			MisfirePolicy: domains.MisfireRunOnce, NextRunAt: &next,
+		}
+		return check(s.CreateSchedule(ctx, sched) != nil, "CreateSchedule for an unknown node succeeded")
+	}},
+
+	{Name: "schedules advance, pause and record runs", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		due := time.Now().Add(-time.Minute)
+		sched := &domains.Schedule{
+			TenantID: domains.DefaultTenantID, Name: uniqueName("conformance-schedule"), CronExpr: "*/5 * * * *", Timezone: "Europe/Berlin",
+			Selector: domains.NodeSelector{"role": "conformance"}, CommandType: "conformance.schedule",
+			Payload: map[string]interface{}{"cmd": "true"}, Priority: 3, MisfirePolicy: domains.MisfireSkip,
+			MisfireGraceSec: 30, NextRunAt: &due,
+		}
+		if err := s.CreateSchedule(ctx, sched); err != nil {
+			return fmt.Errorf("CreateSchedule: %w", err)
+		}
+		if err := check(sched.ID != 0 && sched.ScheduleID != uuid.Nil && recent(sched.CreatedAt),
+			"CreateSchedule filled in id %d, schedule id %s, created %s", sched.ID, sched.ScheduleID, sched.CreatedAt); err != nil {
+			return err
+		}
+
+		got, err := s.GetSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("GetSchedule: %w", err)
+		}
+		if got == nil {
+			return fmt.Errorf("GetSchedule: created schedule not found")
+		}
+		if err := check(got.Name == sched.Name && got.NodeID == nil && got.Selector["role"] == "conformance" &&
+			got.Timezone == "Europe/Berlin" && got.Priority == 3 && got.MisfirePolicy == domains.MisfireSkip &&
+			got.MisfireGraceSec == 30 && got.NextRunAt != nil && !got.Paused,
+			"GetSchedule = %+v", got); err != nil {
+			return err
+		}
+
+		dueSchedules, err := s.ListDueSchedules(ctx, time.Now(), 1000)
+		if err != nil {
+			return fmt.Errorf("ListDueSchedules: %w", err)
+		}
+		if err := check(containsSchedule(dueSchedules, sched.ScheduleID), "ListDueSchedules does not include a due schedule"); err != nil {
+			return err
+		}
+
+		// Advancing is a compare-and-swap on the fire time read back from the store
+		next := time.Now().Add(time.Hour)
+		advanced, err := s.AdvanceSchedule(ctx, sched.ScheduleID, *got.NextRunAt, next)
+		if err != nil {
+			return fmt.Errorf("AdvanceSchedule: %w", err)
+		}
+		again, err := s.AdvanceSchedule(ctx, sched.ScheduleID, *got.NextRunAt, next)
+		if err != nil {
+			return fmt.Errorf("AdvanceSchedule: %w", err)
+		}
+		if err := check(advanced && !again, "AdvanceSchedule twice from the same fire time = %t, %t", advanced, again); err != nil {
+			return err
+		}
+		got, err = s.GetSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("GetSchedule: %w", err)
+		}
+		if err := check(got.LastRunAt != nil && got.NextRunAt != nil && got.NextRunAt.After(time.Now()),
+			"advanced schedule: last run %v, next run %v", got.LastRunAt, got.NextRunAt); err != nil {
+			return err
+		}
+
+		paused, err := s.SetSchedulePaused(ctx, sched.ScheduleID, true, nil)
+		if err != nil {
+			return fmt.Errorf("SetSchedulePaused: %w", err)
+		}
+		got, err = s.GetSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("GetSchedule: %w", err)
+		}
+		if err := check(paused && got.Paused && got.NextRunAt == nil, "paused schedule: paused %t, next run %v",
+			got.Paused, got.NextRunAt); err != nil {
+			return err
+		}
+		if ok, err := s.SetSchedulePaused(ctx, uuid.New(), true, nil); err != nil || ok {
+			return fmt.Errorf("SetSchedulePaused of an unknown schedule = %t, %v", ok, err)
+		}
+		resumed, err := s.SetSchedulePaused(ctx, sched.ScheduleID, false, &due)
+		if err != nil {
+			return fmt.Errorf("SetSchedulePaused: %w", err)
+		}
+		got, err = s.GetSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("GetSchedule: %w", err)
+		}
+		if err := check(resumed && !got.Paused && got.NextRunAt != nil, "resumed schedule: paused %t, next run %v",
+			got.Paused, got.NextRunAt); err != nil {
+			return err
+		}
+
+		for i, status := range []string{domains.ScheduleRunCreated, domains.ScheduleRunSkipped} {
+			run := &domains.ScheduleRun{
+				ScheduleID: sched.ScheduleID, ScheduledFor: due.Add(time.Duration(i) * time.Minute),
+				Trigger: domains.TriggerSchedule, Status: status,
+			}
+			if i == 0 {
+				run.CommandIDs = []uuid.UUID{uuid.New()}
+			}
+			if err := s.InsertScheduleRun(ctx, run); err != nil {
+				return fmt.Errorf("InsertScheduleRun: %w", err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+		runs, err := s.ListScheduleRuns(ctx, sched.ScheduleID, 10)
+		if err != nil {
+			return fmt.Errorf("ListScheduleRuns: %w", err)
+		}
+		if err := check(len(runs) == 2 && runs[0].Status == domains.ScheduleRunSkipped && len(runs[0].CommandIDs) == 0 &&
+			runs[1].Status == domains.ScheduleRunCreated && len(runs[1].CommandIDs) == 1,
+			"ListScheduleRuns returned %d runs, want the newest first", len(runs)); err != nil {
+			return err
+		}
+
+		schedules, err := s.ListSchedules(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListSchedules: %w", err)
+		}
+		if err := check(containsSchedule(schedules, sched.ScheduleID), "ListSchedules does not include the schedule"); err != nil {
+			return err
+		}
+
+		deleted, err := s.DeleteSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("DeleteSchedule: %w", err)
+		}
+		again, err = s.DeleteSchedule(ctx, sched.ScheduleID)
+		if err != nil {
+			return fmt.Errorf("DeleteSchedule: %w", err)
+		}
+		if err := check(deleted && !again, "DeleteSchedule twice = %t, %t", deleted, again); err != nil {
+			return err
+		}
+		runs, err = s.ListScheduleRuns(ctx, sched.ScheduleID, 10)
+		if err != nil {
+			return fmt.Errorf("ListScheduleRuns: %w", err)
+		}
+		return check(len(runs) == 0, "deleting a schedule kept %d runs", len(runs))
+	}},
+}
+
+var workflowCases = []Case{
+	{Name: "workflow steps and outcomes", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		wf := &domains.Workflow{
+			TenantID: domains.DefaultTenantID, Name: uniqueName("conformance-workflow"),
+			Definition: domains.WorkflowDefinition{Steps: []domains.WorkflowStep{
+				{ID: "build", NodeID: nodeID, CommandType: "conformance.build", OnSuccess: []string{"deploy"}},
+				{ID: "deploy", NodeID: nodeID, CommandType: "conformance.deploy"},
+			}},
+			Status: domains.WorkflowRunning,
+		}
+		if err := s.CreateWorkflow(ctx, wf); err != nil {
+			return fmt.Errorf("CreateWorkflow: %w", err)
+		}
+		got, err := s.GetWorkflow(ctx, wf.WorkflowID)
+		if err != nil {
+			return fmt.Errorf("GetWorkflow: %w", err)
+		}
+		if got == nil {
+			return fmt.Errorf("GetWorkflow: created workflow not found")
+		}
+		if err := check(got.Name == wf.Name && len(got.Definition.Steps) == 2 && got.Definition.Steps[0].OnSuccess[0] == "deploy",
+			"GetWorkflow = %+v", got); err != nil {
+			return err
+		}
+
+		steps, err := s.ListWorkflowSteps(ctx, wf.WorkflowID)
+		if err != nil {
+			return fmt.Errorf("ListWorkflowSteps: %w", err)
+		}
+		if len(steps) != 2 {
+			return fmt.Errorf("ListWorkflowSteps returned %d steps, want 2", len(steps))
+		}
+		for _, step := range steps {
+			if step.Status != domains.StepPending || step.StartedAt != nil {
+				return fmt.Errorf("new step %s: status %s, started %v", step.StepID, step.Status, step.StartedAt)
+			}
+		}
+
+		started, err := s.TransitionWorkflowStep(ctx, wf.WorkflowID, "build", domains.StepPending, domains.StepRunning, nil)
+		if err != nil {
+			return fmt.Errorf("TransitionWorkflowStep: %w", err)
+		}
+		again, err := s.TransitionWorkflowStep(ctx, wf.WorkflowID, "build", domains.StepPending, domains.StepRunning, nil)
+		if err != nil {
+			return fmt.Errorf("TransitionWorkflowStep: %w", err)
+		}
+		if err := check(started && !again, "starting a step twice = %t, %t", started, again); err != nil {
+			return err
+		}
+
+		opts := domains.CommandOptions{WorkflowID: &wf.WorkflowID, WorkflowStepID: "build"}
+		var commandIDs []uuid.UUID
+		for i := 0; i < 2; i++ {
+			commandID, err := createCommand(ctx, s, nodeID, "conformance.build", opts)
+			if err != nil {
+				return err
+			}
+			commandIDs = append(commandIDs, commandID)
+		}
+		if err := s.SetWorkflowStepCommands(ctx, wf.WorkflowID, "build", commandIDs); err != nil {
+			return fmt.Errorf("SetWorkflowStepCommands: %w", err)
+		}
+
+		// The first command fails and is retried; the retry decides its outcome
+		if err := finishCommand(ctx, s, nodeID, commandIDs[0], domains.StatusFailed); err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, commandIDs[1], domains.StatusSuccess); err != nil {
+			return err
+		}
+		if err := s.ScheduleCommandRetry(ctx, commandIDs[0], time.Now().Add(time.Hour)); err != nil {
+			return fmt.Errorf("ScheduleCommandRetry: %w", err)
+		}
+		if err := checkOutcome(s.GetWorkflowStepOutcome(ctx, wf.WorkflowID, "build"))(2, 1, 1); err != nil {
+			return err
+		}
+		if err := s.ScheduleCommandRetry(ctx, commandIDs[0], time.Now().Add(-time.Second)); err != nil {
+			return fmt.Errorf("ScheduleCommandRetry: %w", err)
+		}
+		if _, err := s.CreateRetryAttempts(ctx, 1000); err != nil {
+			return fmt.Errorf("CreateRetryAttempts: %w", err)
+		}
+		attempts, err := s.GetCommandAttempts(ctx, commandIDs[0])
+		if err != nil {
+			return fmt.Errorf("GetCommandAttempts: %w", err)
+		}
+		if len(attempts) != 2 {
+			return fmt.Errorf("GetCommandAttempts returned %d attempts, want 2", len(attempts))
+		}
+		if err := checkOutcome(s.GetWorkflowStepOutcome(ctx, wf.WorkflowID, "build"))(2, 1, 1); err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, attempts[1].CommandID, domains.StatusSuccess); err != nil {
+			return err
+		}
+		if err := checkOutcome(s.GetWorkflowStepOutcome(ctx, wf.WorkflowID, "build"))(2, 2, 2); err != nil {
+			return err
+		}
+
+		errorMsg := "conformance"
+		if ok, err := s.TransitionWorkflowStep(ctx, wf.WorkflowID, "build", domains.StepRunning, domains.StepSucceeded, &errorMsg); err != nil || !ok {
+			return fmt.Errorf("TransitionWorkflowStep to succeeded = %t, %v", ok, err)
+		}
+		steps, err = s.ListWorkflowSteps(ctx, wf.WorkflowID)
+		if err != nil {
+			return fmt.Errorf("ListWorkflowSteps: %w", err)
+		}
+		for _, step := range steps {
+			if step.StepID != "build" {
+				continue
+			}
+			if err := check(step.Status == domains.StepSucceeded && len(step.CommandIDs) == 2 && step.StartedAt != nil &&
+				step.FinishedAt != nil && step.ErrorMsg != nil, "finished step = %+v", step); err != nil {
+				return err
+			}
+		}
+
+		finished, err := s.FinishWorkflow(ctx, wf.WorkflowID, domains.WorkflowSucceeded)
+		if err != nil {
+			return fmt.Errorf("FinishWorkflow: %w", err)
+		}
+		again, err = s.FinishWorkflow(ctx, wf.WorkflowID, domains.WorkflowFailed)
+		if err != nil {
+			return fmt.Errorf("FinishWorkflow: %w", err)
+		}
+		if err := check(finished && !again, "finishing a workflow twice = %t, %t", finished, again); err != nil {
+			return err
+		}
+
+		status := domains.WorkflowSucceeded
+		workflows, err := s.ListWorkflows(ctx, domains.DefaultTenantID, &status, 1000)
+		if err != nil {
+			return fmt.Errorf("ListWorkflows: %w", err)
+		}
+		for _, w := range workflows {
+			if w.WorkflowID == wf.WorkflowID {
+				return check(w.FinishedAt != nil, "finished workflow has no finished_at")
+			}
+		}
+		return fmt.Errorf("ListWorkflows of succeeded workflows does not include the workflow")
+	}},
+}
+
+var rolloutCases = []Case{
+	{Name: "rollout batches", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		r := &domains.Rollout{
+			TenantID: domains.DefaultTenantID, Name: uniqueName("conformance-rollout"), CommandType: "conformance.rollout",
+			Payload: map[string]interface{}{"cmd": "true"}, Priority: domains.PriorityDefault,
+			Strategy: domains.RolloutStrategy{BatchSize: 1, CanarySize: 1, MaxFailurePercent: 50},
+			Status:   domains.RolloutRunning,
+		}
+		batches := []domains.RolloutBatch{
+			{BatchIndex: 0, Canary: true, NodeIDs: []string{nodeID}, Status: domains.BatchPending},
+			{BatchIndex: 1, NodeIDs: []string{nodeID}, Status: domains.BatchPending},
+		}
+		if err := s.CreateRollout(ctx, r, batches); err != nil {
+			return fmt.Errorf("CreateRollout: %w", err)
+		}
+		got, err := s.GetRollout(ctx, r.RolloutID)
+		if err != nil {
+			return fmt.Errorf("GetRollout: %w", err)
+		}
+		if got == nil {
+			return fmt.Errorf("GetRollout: created rollout not found")
+		}
+		if err := check(got.Name == r.Name && got.Strategy.MaxFailurePercent == 50 && got.Status == domains.RolloutRunning &&
+			got.NextBatchAt == nil, "GetRollout = %+v", got); err != nil {
+			return err
+		}
+		stored, err := s.ListRolloutBatches(ctx, r.RolloutID)
+		if err != nil {
+			return fmt.Errorf("ListRolloutBatches: %w", err)
+		}
+		if err := check(len(stored) == 2 && stored[0].Canary && !stored[1].Canary && stored[1].NodeIDs[0] == nodeID,
+			"ListRolloutBatches returned %d batches", len(stored)); err != nil {
+			return err
+		}
+
+		startedBatch, err := s.StartRolloutBatch(ctx, r.RolloutID, 0)
+		if err != nil {
+			return fmt.Errorf("StartRolloutBatch: %w", err)
+		}
+		again, err := s.StartRolloutBatch(ctx, r.RolloutID, 0)
+		if err != nil {
+			return fmt.Errorf("StartRolloutBatch: %w", err)
+		}
+		if err := check(startedBatch && !again, "starting a batch twice = %t, %t", startedBatch, again); err != nil {
+			return err
+		}
+
+		opts := domains.CommandOptions{RolloutID: &r.RolloutID, RolloutBatch: 0}
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.rollout", opts)
+		if err != nil {
+			return err
+		}
+		errorMsg := "conformance"
+		if err := s.SetRolloutBatchCommands(ctx, r.RolloutID, 0, []uuid.UUID{commandID}, &errorMsg); err != nil {
+			return fmt.Errorf("SetRolloutBatchCommands: %w", err)
+		}
+		if err := checkOutcome(s.GetRolloutBatchOutcome(ctx, r.RolloutID, 0))(1, 0, 0); err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, commandID, domains.StatusSuccess); err != nil {
+			return err
+		}
+		if err := checkOutcome(s.GetRolloutBatchOutcome(ctx, r.RolloutID, 0))(1, 1, 1); err != nil {
+			return err
+		}
+		finished, err := s.FinishRolloutBatch(ctx, r.RolloutID, 0, domains.BatchSucceeded, 1)
+		if err != nil {
+			return fmt.Errorf("FinishRolloutBatch: %w", err)
+		}
+		if ok, err := s.FinishRolloutBatch(ctx, r.RolloutID, 0, domains.BatchFailed, 0); err != nil || ok {
+			return fmt.Errorf("finishing a batch twice = %t, %v", ok, err)
+		}
+		stored, err = s.ListRolloutBatches(ctx, r.RolloutID)
+		if err != nil {
+			return fmt.Errorf("ListRolloutBatches: %w", err)
+		}
+		if err := check(finished && stored[0].Status == domains.BatchSucceeded && stored[0].SucceededCount == 1 &&
+			len(stored[0].CommandIDs) == 1 && stored[0].ErrorMsg != nil && stored[0].StartedAt != nil && stored[0].FinishedAt != nil,
+			"finished batch = %+v", stored[0]); err != nil {
+			return err
+		}
+
+		// The pause between batches holds back the next one
+		if err := s.SetRolloutNextBatchAt(ctx, r.RolloutID, time.Now().Add(time.Hour)); err != nil {
+			return fmt.Errorf("SetRolloutNextBatchAt: %w", err)
+		}
+		if ok, err := s.StartRolloutBatch(ctx, r.RolloutID, 1); err != nil || ok {
+			return fmt.Errorf("StartRolloutBatch during the pause = %t, %v", ok, err)
+		}
+		if err := s.SetRolloutNextBatchAt(ctx, r.RolloutID, time.Now().Add(-time.Second)); err != nil {
+			return fmt.Errorf("SetRolloutNextBatchAt: %w", err)
+		}
+		paused, err := s.TransitionRollout(ctx, r.RolloutID, []string{domains.RolloutRunning}, domains.RolloutPaused, nil)
+		if err != nil {
+			return fmt.Errorf("TransitionRollout: %w", err)
+		}
+		if ok, err := s.StartRolloutBatch(ctx, r.RolloutID, 1); err != nil || ok {
+			return fmt.Errorf("StartRolloutBatch of a paused rollout = %t, %v", ok, err)
+		}
+		if ok, err := s.TransitionRollout(ctx, r.RolloutID, []string{domains.RolloutRunning}, domains.RolloutHalted, nil); err != nil || ok {
+			return fmt.Errorf("TransitionRollout from the wrong status = %t, %v", ok, err)
+		}
+
+		queued, err := createCommand(ctx, s, nodeID, "conformance.rollout", domains.CommandOptions{RolloutID: &r.RolloutID, RolloutBatch: 1})
+		if err != nil {
+			return err
+		}
+		aborted, err := s.TransitionRollout(ctx, r.RolloutID, []string{domains.RolloutPaused, domains.RolloutHalted}, domains.RolloutAborted, &errorMsg)
+		if err != nil {
+			return fmt.Errorf("TransitionRollout: %w", err)
+		}
+		if err := check(paused && aborted, "pausing and aborting = %t, %t", paused, aborted); err != nil {
+			return err
+		}
+		cancelled, err := s.CancelRolloutCommands(ctx, r.RolloutID)
+		if err != nil {
+			return fmt.Errorf("CancelRolloutCommands: %w", err)
+		}
+		if err := check(cancelled == 1, "CancelRolloutCommands cancelled %d commands, want 1", cancelled); err != nil {
+			return err
+		}
+		history, err := s.GetCommandStatusHistory(ctx, queued)
+		if err != nil {
+			return fmt.Errorf("GetCommandStatusHistory: %w", err)
+		}
+		if err := checkHistory(history, queued, [][3]string{
+			{"", domains.StatusQueued, domains.SourceSubmit},
+			{domains.StatusQueued, domains.StatusCancelled, domains.SourceOperator},
+		}); err != nil {
+			return err
+		}
+
+		status := domains.RolloutAborted
+		rollouts, err := s.ListRollouts(ctx, domains.DefaultTenantID, &status, 1000)
+		if err != nil {
+			return fmt.Errorf("ListRollouts: %w", err)
+		}
+		for _, ro := range rollouts {
+			if ro.RolloutID == r.RolloutID {
+				return check(ro.FinishedAt != nil && ro.ErrorMsg != nil, "aborted rollout: finished %v, error %v", ro.FinishedAt, ro.ErrorMsg)
+			}
+		}
+		return fmt.Errorf("ListRollouts of aborted rollouts does not include the rollout")
+	}},
+}
+
+var templateCases = []Case{
+	{Name: "template versions", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		name := uniqueName("conformance-template")
+		minLen := 1.0
+		for i, cmd := range []string{"echo {{msg}}", "printf {{msg}}"} {
+			t := &domains.CommandTemplate{
+				TenantID: domains.DefaultTenantID, Name: name, Description: "conformance", CommandType: "RunCommand",
+				Payload: map[string]interface{}{"cmd": cmd},
+				Params:  []domains.TemplateParam{{Name: "msg", Type: domains.ParamString, Required: true, Min: &minLen}},
+			}
+			if err := s.CreateCommandTemplate(ctx, t); err != nil {
+				return fmt.Errorf("CreateCommandTemplate: %w", err)
+			}
+			if err := check(t.Version == i+1 && t.ID != 0, "CreateCommandTemplate assigned version %d", t.Version); err != nil {
+				return err
+			}
+		}
+
+		latest, err := s.GetCommandTemplate(ctx, domains.DefaultTenantID, name, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandTemplate: %w", err)
+		}
+		if err := check(latest != nil && latest.Version == 2 && latest.Payload["cmd"] == "printf {{msg}}" &&
+			len(latest.Params) == 1 && latest.Params[0].Min != nil && *latest.Params[0].Min == 1,
+			"latest template = %+v", latest); err != nil {
+			return err
+		}
+		version := 1
+		first, err := s.GetCommandTemplate(ctx, domains.DefaultTenantID, name, &version)
+		if err != nil {
+			return fmt.Errorf("GetCommandTemplate: %w", err)
+		}
+		if err := check(first != nil && first.Version == 1 && first.Payload["cmd"] == "echo {{msg}}", "version 1 = %+v", first); err != nil {
+			return err
+		}
+		version = 3
+		if t, err := s.GetCommandTemplate(ctx, domains.DefaultTenantID, name, &version); err != nil || t != nil {
+			return fmt.Errorf("GetCommandTemplate of a missing version = %v, %v", t, err)
+		}
+		if t, err := s.GetCommandTemplate(ctx, domains.DefaultTenantID, uniqueName("conformance-unknown"), nil); err != nil || t != nil {
+			return fmt.Errorf("GetCommandTemplate of an unknown template = %v, %v", t, err)
+		}
+
+		versions, err := s.ListCommandTemplateVersions(ctx, domains.DefaultTenantID, name)
+		if err != nil {
+			return fmt.Errorf("ListCommandTemplateVersions: %w", err)
+		}
+		if err := check(len(versions) == 2 && versions[0].Version == 2, "ListCommandTemplateVersions returned %d versions, newest first",
+			len(versions)); err != nil {
+			return err
+		}
+		templates, err := s.ListCommandTemplates(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListCommandTemplates: %w", err)
+		}
+		found := 0
+		for _, t := range templates {
+			if t.Name == name {
+				found++
+				if t.Version != 2 {
+					return fmt.Errorf("ListCommandTemplates listed version %d, want the latest", t.Version)
+				}
+			}
+		}
+		return check(found == 1, "ListCommandTemplates listed the template %d times", found)
+	}},
+}
+
+// checkOutcome returns a check of a command outcome against the wanted counts
+func checkOutcome(outcome domains.CommandOutcome, err error) func(commands, finished, succeeded int) error {
+	return func(commands, finished, succeeded int) error {
+		if err != nil {
+			return fmt.Errorf("command outcome: %w", err)
+		}
+		want := domains.CommandOutcome{Commands: commands, Finished: finished, Succeeded: succeeded}
+		return check(outcome == want, "command outcome = %+v, want %+v", outcome, want)
+	}
+}
+
+// containsSchedule reports whether schedules include scheduleID
+func containsSchedule(schedules []*domains.Schedule, scheduleID uuid.UUID) bool {
+	for _, sched := range schedules {
+		if sched.ScheduleID == scheduleID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var logCases = []Case{
+	{Name: "log chunks", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		commandID, err := createCommand(ctx, s, nodeID, "conformance.logs", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+
+		chunks := []domains.CommandLog{
+			{ChunkIndex: 1, Stream: "stdout", Data: "wörld", Encoding: "utf-8"},
+			{ChunkIndex: 0, Stream: "stdout", Data: "hello", Encoding: "utf-8"},
+			{ChunkIndex: 0, Stream: "stderr", Data: "oops", Encoding: "utf-8"},
+		}
+		acked, err := s.InsertLogChunks(ctx, commandID, chunks)
+		if err != nil {
+			return fmt.Errorf("InsertLogChunks: %w", err)
+		}
+		if err := check(fmt.Sprint(acked) == "[1 0 0]", "InsertLogChunks acked %v", acked); err != nil {
+			return err
+		}
+
+		// A resent chunk is acked again without duplicating it, and may mark it final
+		resent := []domains.CommandLog{{ChunkIndex: 1, Stream: "stdout", Data: "wörld", Encoding: "utf-8", IsFinal: true}}
+		acked, err = s.InsertLogChunks(ctx, commandID, resent)
+		if err != nil {
+			return fmt.Errorf("InsertLogChunks: %w", err)
+		}
+		if err := check(fmt.Sprint(acked) == "[1]", "InsertLogChunks of a resent chunk acked %v", acked); err != nil {
+			return err
+		}
+		if acked, err := s.InsertLogChunks(ctx, commandID, nil); err != nil || len(acked) != 0 {
+			return fmt.Errorf("InsertLogChunks without chunks = %v, %v", acked, err)
+		}
+
+		logs, err := s.GetCommandLogs(ctx, "", commandID, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		var got []string
+		for _, l := range logs {
+			if l.CommandID != commandID.String() {
+				return fmt.Errorf("log chunk belongs to %s", l.CommandID)
+			}
+			got = append(got, fmt.Sprintf("%d/%s/%s/%t", l.ChunkIndex, l.Stream, l.Data, l.IsFinal))
+		}
+		want := "[0/stderr/oops/false 0/stdout/hello/false 1/stdout/wörld/true]"
+		if err := check(fmt.Sprint(got) == want, "GetCommandLogs = %v, want %s", got, want); err != nil {
+			return err
+		}
+
+		after := int64(1)
+		logs, err = s.GetCommandLogs(ctx, "", commandID, &after)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		if err := check(len(logs) == 1 && logs[0].ChunkIndex == 1, "GetCommandLogs from chunk 1 returned %d chunks", len(logs)); err != nil {
+			return err
+		}
+
+		// Sizes are in bytes, not characters
+		size, err := s.GetCommandLogSize(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogSize: %w", err)
+		}
+		if err := check(size == 15, "GetCommandLogSize = %d, want 15", size); err != nil {
+			return err
+		}
+
+		if err := s.CleanupOldLogs(ctx, 7); err != nil {
+			return fmt.Errorf("CleanupOldLogs: %w", err)
+		}
+		logs, err = s.GetCommandLogs(ctx, "", commandID, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		return check(len(logs) == 3, "CleanupOldLogs deleted fresh chunks, %d left", len(logs))
+	}},
+
+	{Name: "log chunks need a command", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		chunks := []domains.CommandLog{{ChunkIndex: 0, Stream: "stdout", Data: "x", Encoding: "utf-8"}}
+		_, err := s.InsertLogChunks(ctx, uuid.New(), chunks)
+		return check(err != nil, "InsertLogChunks for an unknown command succeeded")
+	}},
+}
@@ -0,0 +1,348 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var tenantCases = []Case{
+	{Name: "tenants and operators", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		t := &domains.Tenant{TenantID: uniqueName("conformance-tenant"), Name: "Conformance", EnrollmentKeyHash: "hash", MaxNodes: 3}
+		if err := s.CreateTenant(ctx, t); err != nil {
+			return fmt.Errorf("CreateTenant: %w", err)
+		}
+		if err := check(t.ID != 0 && recent(t.CreatedAt), "created tenant = %+v", t); err != nil {
+			return err
+		}
+		duplicate := &domains.Tenant{TenantID: t.TenantID, Name: "Duplicate"}
+		if err := s.CreateTenant(ctx, duplicate); !errors.Is(err, domains.ErrTenantExists) {
+			return fmt.Errorf("CreateTenant of a taken ID = %v, want ErrTenantExists", err)
+		}
+
+		got, err := s.GetTenant(ctx, t.TenantID)
+		if err != nil {
+			return fmt.Errorf("GetTenant: %w", err)
+		}
+		if err := check(got != nil && got.Name == "Conformance" && got.EnrollmentKeyHash == "hash" && got.MaxNodes == 3 &&
+			got.MaxCommandsPerDay == 0, "GetTenant = %+v", got); err != nil {
+			return err
+		}
+		if unknown, err := s.GetTenant(ctx, uniqueName("conformance-unknown")); err != nil || unknown != nil {
+			return fmt.Errorf("GetTenant of an unknown tenant = %+v, %v", unknown, err)
+		}
+
+		got.Name, got.MaxCommandsPerDay = "Renamed", 10
+		if ok, err := s.UpdateTenant(ctx, got); err != nil || !ok {
+			return fmt.Errorf("UpdateTenant = %t, %v", ok, err)
+		}
+		if ok, err := s.UpdateTenant(ctx, &domains.Tenant{TenantID: uniqueName("conformance-unknown"), Name: "x"}); err != nil || ok {
+			return fmt.Errorf("UpdateTenant of an unknown tenant = %t, %v", ok, err)
+		}
+
+		tenants, err := s.ListTenants(ctx)
+		if err != nil {
+			return fmt.Errorf("ListTenants: %w", err)
+		}
+		var listed, hasDefault bool
+		for _, lt := range tenants {
+			listed = listed || (lt.TenantID == t.TenantID && lt.Name == "Renamed" && lt.MaxCommandsPerDay == 10)
+			hasDefault = hasDefault || lt.TenantID == domains.DefaultTenantID
+		}
+		if err := check(listed && hasDefault, "ListTenants lists the updated tenant %t and the default tenant %t", listed, hasDefault); err != nil {
+			return err
+		}
+
+		operatorID := uniqueName("conformance-operator")
+		for i := 0; i < 2; i++ {
+			if err := s.AddTenantOperator(ctx, t.TenantID, operatorID); err != nil {
+				return fmt.Errorf("AddTenantOperator: %w", err)
+			}
+		}
+		if err := s.AddTenantOperator(ctx, domains.DefaultTenantID, operatorID); err != nil {
+			return fmt.Errorf("AddTenantOperator: %w", err)
+		}
+		operators, err := s.ListTenantOperators(ctx, t.TenantID)
+		if err != nil {
+			return fmt.Errorf("ListTenantOperators: %w", err)
+		}
+		if err := check(fmt.Sprint(operators) == fmt.Sprint([]string{operatorID}), "ListTenantOperators = %v", operators); err != nil {
+			return err
+		}
+		memberships, err := s.ListOperatorTenants(ctx, operatorID)
+		if err != nil {
+			return fmt.Errorf("ListOperatorTenants: %w", err)
+		}
+		want := fmt.Sprint([]string{t.TenantID, domains.DefaultTenantID})
+		if err := check(fmt.Sprint(memberships) == want, "ListOperatorTenants = %v, want %s", memberships, want); err != nil {
+			return err
+		}
+
+		removed, err := s.RemoveTenantOperator(ctx, t.TenantID, operatorID)
+		if err != nil {
+			return fmt.Errorf("RemoveTenantOperator: %w", err)
+		}
+		again, err := s.RemoveTenantOperator(ctx, t.TenantID, operatorID)
+		if err != nil {
+			return fmt.Errorf("RemoveTenantOperator: %w", err)
+		}
+		if err := check(removed && !again, "removing an operator twice = %t, %t", removed, again); err != nil {
+			return err
+		}
+		_, err = s.RemoveTenantOperator(ctx, domains.DefaultTenantID, operatorID)
+		return err
+	}},
+
+	{Name: "tenant scoped queries", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		tenantA, nodeA, commandA, err := createTenantWithCommand(ctx, s)
+		if err != nil {
+			return err
+		}
+		tenantB, nodeB, commandB, err := createTenantWithCommand(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		if err := s.RegisterNode(ctx, tenantB, nodeA, map[string]interface{}{}); !errors.Is(err, domains.ErrNodeInOtherTenant) {
+			return fmt.Errorf("RegisterNode into another tenant = %v, want ErrNodeInOtherTenant", err)
+		}
+		if err := s.RegisterNode(ctx, uniqueName("conformance-unknown"), uniqueName("conformance-node"), map[string]interface{}{}); err == nil {
+			return fmt.Errorf("RegisterNode into an unknown tenant succeeded")
+		}
+		node, err := s.GetNode(ctx, nodeA)
+		if err != nil {
+			return fmt.Errorf("GetNode: %w", err)
+		}
+		if err := check(node != nil && node.TenantID == tenantA, "GetNode = %+v, want tenant %s", node, tenantA); err != nil {
+			return err
+		}
+
+		nodes, err := s.ListNodes(ctx, tenantA)
+		if err != nil {
+			return fmt.Errorf("ListNodes: %w", err)
+		}
+		if err := check(len(nodes) == 1 && nodes[0].NodeID == nodeA && nodes[0].TenantID == tenantA,
+			"ListNodes of a tenant = %+v", nodes); err != nil {
+			return err
+		}
+
+		commands, err := s.ListCommands(ctx, tenantA, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListCommands: %w", err)
+		}
+		if err := check(len(commands) == 1 && commands[0].CommandID == commandA, "ListCommands of a tenant returned %d commands", len(commands)); err != nil {
+			return err
+		}
+		if commands, err := s.ListCommands(ctx, tenantA, &nodeB, 10); err != nil || len(commands) != 0 {
+			return fmt.Errorf("ListCommands of another tenant's node = %d commands, %v", len(commands), err)
+		}
+
+		logs, err := s.GetCommandLogs(ctx, tenantB, commandA, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		if err := check(len(logs) == 0, "GetCommandLogs of another tenant's command returned %d chunks", len(logs)); err != nil {
+			return err
+		}
+		logs, err = s.GetCommandLogs(ctx, tenantA, commandA, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandLogs: %w", err)
+		}
+		if err := check(len(logs) == 1, "GetCommandLogs in the command's tenant returned %d chunks", len(logs)); err != nil {
+			return err
+		}
+
+		if deleted, err := s.DeleteQueuedCommands(ctx, tenantB, &nodeA); err != nil || deleted != 0 {
+			return fmt.Errorf("DeleteQueuedCommands of another tenant's node = %d, %v", deleted, err)
+		}
+		deleted, err := s.DeleteQueuedCommands(ctx, tenantB, nil)
+		if err != nil {
+			return fmt.Errorf("DeleteQueuedCommands: %w", err)
+		}
+		if err := check(deleted == 1, "DeleteQueuedCommands of a tenant deleted %d commands", deleted); err != nil {
+			return err
+		}
+		if cmd, err := s.GetCommandByID(ctx, commandB); err != nil || cmd != nil {
+			return fmt.Errorf("DeleteQueuedCommands kept the tenant's command: %+v, %v", cmd, err)
+		}
+		cmd, err := getCommand(ctx, s, commandA)
+		if err != nil {
+			return err
+		}
+		return check(cmd.Status == domains.StatusQueued, "DeleteQueuedCommands of a tenant deleted another tenant's command")
+	}},
+
+	{Name: "tenant quotas", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		t := &domains.Tenant{TenantID: uniqueName("conformance-tenant"), Name: "Quotas", MaxNodes: 1, MaxCommandsPerDay: 2}
+		if err := s.CreateTenant(ctx, t); err != nil {
+			return fmt.Errorf("CreateTenant: %w", err)
+		}
+		nodeID := uniqueName("conformance-node")
+		if err := s.RegisterNode(ctx, t.TenantID, nodeID, map[string]interface{}{}); err != nil {
+			return fmt.Errorf("RegisterNode: %w", err)
+		}
+		// Re-registering an enrolled node doesn't count against the quota
+		if err := s.RegisterNode(ctx, t.TenantID, nodeID, map[string]interface{}{}); err != nil {
+			return fmt.Errorf("RegisterNode again: %w", err)
+		}
+		err := s.RegisterNode(ctx, t.TenantID, uniqueName("conformance-node"), map[string]interface{}{})
+		if !errors.Is(err, domains.ErrTenantQuotaExceeded) {
+			return fmt.Errorf("RegisterNode past the node quota = %v, want ErrTenantQuotaExceeded", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := createCommand(ctx, s, nodeID, "conformance.quota", domains.CommandOptions{}); err != nil {
+				return err
+			}
+		}
+		_, err = s.CreateCommand(ctx, nodeID, "conformance.quota", map[string]interface{}{}, domains.CommandOptions{})
+		if !errors.Is(err, domains.ErrTenantQuotaExceeded) {
+			return fmt.Errorf("CreateCommand past the daily quota = %v, want ErrTenantQuotaExceeded", err)
+		}
+
+		usage, err := s.GetTenantUsage(ctx, t.TenantID)
+		if err != nil {
+			return fmt.Errorf("GetTenantUsage: %w", err)
+		}
+		if err := check(usage.Nodes == 1 && usage.CommandsPerDay == 2, "GetTenantUsage = %+v", usage); err != nil {
+			return err
+		}
+
+		// Raising the quota admits new commands again
+		t.MaxCommandsPerDay = 3
+		if ok, err := s.UpdateTenant(ctx, t); err != nil || !ok {
+			return fmt.Errorf("UpdateTenant = %t, %v", ok, err)
+		}
+		_, err = createCommand(ctx, s, nodeID, "conformance.quota", domains.CommandOptions{})
+		return err
+	}},
+
+	{Name: "automation is scoped to tenants", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		tenantID, nodeID, commandID, err := createTenantWithCommand(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		next := time.Now().Add(time.Hour)
+		sched := &domains.Schedule{
+			TenantID: tenantID, Name: uniqueName("conformance-schedule"), CronExpr: "@hourly", Timezone: "UTC", NodeID: &nodeID,
+			CommandType: "conformance.schedule", Payload: map[string]interface{}{}, Priority: domains.PriorityDefault,
+			MisfirePolicy: domains.MisfireRunOnce, NextRunAt: &next,
+		}
+		if err := s.CreateSchedule(ctx, sched); err != nil {
+			return fmt.Errorf("CreateSchedule: %w", err)
+		}
+		owned, err := s.ListSchedules(ctx, tenantID)
+		if err != nil {
+			return fmt.Errorf("ListSchedules: %w", err)
+		}
+		other, err := s.ListSchedules(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListSchedules: %w", err)
+		}
+		all, err := s.ListSchedules(ctx, "")
+		if err != nil {
+			return fmt.Errorf("ListSchedules: %w", err)
+		}
+		if err := check(len(owned) == 1 && owned[0].TenantID == tenantID && !containsSchedule(other, sched.ScheduleID) &&
+			containsSchedule(all, sched.ScheduleID), "ListSchedules by tenant = %d own, listed to another tenant %t",
+			len(owned), containsSchedule(other, sched.ScheduleID)); err != nil {
+			return err
+		}
+
+		// Template names and versions are per tenant
+		name := uniqueName("conformance-template")
+		for _, owner := range []string{domains.DefaultTenantID, tenantID} {
+			t := &domains.CommandTemplate{
+				TenantID: owner, Name: name, Description: owner, CommandType: "RunCommand",
+				Payload: map[string]interface{}{"cmd": "true"},
+			}
+			if err := s.CreateCommandTemplate(ctx, t); err != nil {
+				return fmt.Errorf("CreateCommandTemplate: %w", err)
+			}
+			if err := check(t.Version == 1, "CreateCommandTemplate in tenant %s assigned version %d", owner, t.Version); err != nil {
+				return err
+			}
+		}
+		tmpl, err := s.GetCommandTemplate(ctx, tenantID, name, nil)
+		if err != nil {
+			return fmt.Errorf("GetCommandTemplate: %w", err)
+		}
+		if err := check(tmpl != nil && tmpl.TenantID == tenantID && tmpl.Description == tenantID, "tenant template = %+v", tmpl); err != nil {
+			return err
+		}
+		templates, err := s.ListCommandTemplates(ctx, tenantID)
+		if err != nil {
+			return fmt.Errorf("ListCommandTemplates: %w", err)
+		}
+		if err := check(len(templates) == 1 && templates[0].TenantID == tenantID, "ListCommandTemplates of the tenant returned %d templates",
+			len(templates)); err != nil {
+			return err
+		}
+
+		// Webhooks get only the events of their tenant's nodes
+		if err := flushOutbox(ctx, s); err != nil {
+			return err
+		}
+		events := []string{domains.CommandEventType(domains.StatusSuccess)}
+		own := &domains.WebhookSubscription{TenantID: tenantID, URL: "https://example.com/own", EventTypes: events, Secret: "conformance", Enabled: true}
+		foreign := &domains.WebhookSubscription{TenantID: domains.DefaultTenantID, URL: "https://example.com/foreign", EventTypes: events,
+			Secret: "conformance", Enabled: true}
+		for _, w := range []*domains.WebhookSubscription{own, foreign} {
+			if err := s.CreateWebhook(ctx, w); err != nil {
+				return fmt.Errorf("CreateWebhook: %w", err)
+			}
+			defer s.DeleteWebhook(ctx, w.WebhookID)
+		}
+		if err := finishCommand(ctx, s, nodeID, commandID, domains.StatusSuccess); err != nil {
+			return err
+		}
+		if _, err := s.FanOutWebhookEvents(ctx, 1000); err != nil {
+			return fmt.Errorf("FanOutWebhookEvents: %w", err)
+		}
+		ownDeliveries, err := s.ListWebhookDeliveries(ctx, own.WebhookID, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		foreignDeliveries, err := s.ListWebhookDeliveries(ctx, foreign.WebhookID, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		if err := check(len(ownDeliveries) == 1 && len(foreignDeliveries) == 0,
+			"the tenant's webhook got %d deliveries and another tenant's %d", len(ownDeliveries), len(foreignDeliveries)); err != nil {
+			return err
+		}
+		webhooks, err := s.ListWebhooks(ctx, tenantID)
+		if err != nil {
+			return fmt.Errorf("ListWebhooks: %w", err)
+		}
+		return check(len(webhooks) == 1 && webhooks[0].WebhookID == own.WebhookID, "ListWebhooks of the tenant returned %d webhooks", len(webhooks))
+	}},
+}
+
+// createTenantWithCommand creates a tenant with one node, which has one queued command with a log chunk
+func createTenantWithCommand(ctx context.Context, s clients.StorageAdapter) (string, string, uuid.UUID, error) {
+	t := &domains.Tenant{TenantID: uniqueName("conformance-tenant"), Name: "Conformance"}
+	if err := s.CreateTenant(ctx, t); err != nil {
+		return "", "", uuid.Nil, fmt.Errorf("CreateTenant: %w", err)
+	}
+	nodeID := uniqueName("conformance-node")
+	if err := s.RegisterNode(ctx, t.TenantID, nodeID, map[string]interface{}{"os": "linux"}); err != nil {
+		return "", "", uuid.Nil, fmt.Errorf("RegisterNode: %w", err)
+	}
+	commandID, err := createCommand(ctx, s, nodeID, "conformance.tenant", domains.CommandOptions{})
+	if err != nil {
+		return "", "", uuid.Nil, err
+	}
+	chunks := []domains.CommandLog{{ChunkIndex: 0, Stream: "stdout", Data: "tenant", Encoding: "utf-8"}}
+	if _, err := s.InsertLogChunks(ctx, commandID, chunks); err != nil {
+		return "", "", uuid.Nil, fmt.Errorf("InsertLogChunks: %w", err)
+	}
+	return t.TenantID, nodeID, commandID, nil
+}
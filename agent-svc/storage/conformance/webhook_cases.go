@@ -0,0 +1,259 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"agent-svc/app/clients"
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+var webhookCases = []Case{
+	{Name: "webhook outbox and deliveries", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		if err := flushOutbox(ctx, s); err != nil {
+			return err
+		}
+		w := &domains.WebhookSubscription{
+			TenantID: domains.DefaultTenantID, URL: "https://example.com/hook", EventTypes: []string{domains.CommandEventType(domains.StatusSuccess)},
+			Secret: "conformance", Enabled: true,
+		}
+		if err := s.CreateWebhook(ctx, w); err != nil {
+			return fmt.Errorf("CreateWebhook: %w", err)
+		}
+		defer s.DeleteWebhook(ctx, w.WebhookID)
+		got, err := s.GetWebhook(ctx, w.WebhookID)
+		if err != nil {
+			return fmt.Errorf("GetWebhook: %w", err)
+		}
+		if err := check(got != nil && got.URL == w.URL && len(got.EventTypes) == 1 && got.Secret == "conformance" && got.Enabled,
+			"GetWebhook = %+v", got); err != nil {
+			return err
+		}
+
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		failed, err := createCommand(ctx, s, nodeID, "conformance.webhook", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		succeeded, err := createCommand(ctx, s, nodeID, "conformance.webhook", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, failed, domains.StatusFailed); err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, succeeded, domains.StatusSuccess); err != nil {
+			return err
+		}
+
+		if _, err := s.FanOutWebhookEvents(ctx, 1000); err != nil {
+			return fmt.Errorf("FanOutWebhookEvents: %w", err)
+		}
+		if again, err := s.FanOutWebhookEvents(ctx, 1000); err != nil || again != 0 {
+			return fmt.Errorf("FanOutWebhookEvents of fanned out events = %d, %v", again, err)
+		}
+		deliveries, err := s.ListWebhookDeliveries(ctx, w.WebhookID, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		if len(deliveries) != 1 {
+			return fmt.Errorf("ListWebhookDeliveries returned %d deliveries, want the command.success one", len(deliveries))
+		}
+		delivery := deliveries[0]
+		if err := check(delivery.EventType == "command.success" && delivery.Status == domains.DeliveryPending && delivery.Attempts == 0 &&
+			delivery.NextAttemptAt != nil, "new delivery = %+v", delivery); err != nil {
+			return err
+		}
+
+		job, err := claimDelivery(ctx, s, delivery.DeliveryID)
+		if err != nil {
+			return err
+		}
+		if err := check(job != nil && job.Attempt == 1 && job.URL == w.URL && job.Secret == "conformance" &&
+			job.Event.EventID == delivery.EventID && job.Event.Payload["command_id"] == succeeded.String() &&
+			job.Event.Payload["node_id"] == nodeID, "first claim = %+v", job); err != nil {
+			return err
+		}
+		if job, err := claimDelivery(ctx, s, delivery.DeliveryID); err != nil || job != nil {
+			return fmt.Errorf("a leased delivery was claimed again: %+v, %v", job, err)
+		}
+
+		responseCode, errorMsg := 500, "server error"
+		err = s.RecordWebhookDeliveryAttempt(ctx, delivery.DeliveryID, 1, domains.DeliveryDead, &responseCode, &errorMsg, nil)
+		if err != nil {
+			return fmt.Errorf("RecordWebhookDeliveryAttempt: %w", err)
+		}
+		dead := domains.DeliveryDead
+		deadDeliveries, err := s.ListWebhookDeliveries(ctx, w.WebhookID, &dead, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		if err := check(len(deadDeliveries) == 1 && deadDeliveries[0].Attempts == 1 && deadDeliveries[0].NextAttemptAt == nil &&
+			*deadDeliveries[0].LastResponseCode == 500, "dead deliveries = %d", len(deadDeliveries)); err != nil {
+			return err
+		}
+
+		retried, err := s.RetryWebhookDelivery(ctx, delivery.DeliveryID)
+		if err != nil {
+			return fmt.Errorf("RetryWebhookDelivery: %w", err)
+		}
+		again, err := s.RetryWebhookDelivery(ctx, delivery.DeliveryID)
+		if err != nil {
+			return fmt.Errorf("RetryWebhookDelivery: %w", err)
+		}
+		if err := check(retried && !again, "retrying a delivery twice = %t, %t", retried, again); err != nil {
+			return err
+		}
+		job, err = claimDelivery(ctx, s, delivery.DeliveryID)
+		if err != nil {
+			return err
+		}
+		if err := check(job != nil && job.Attempt == 2, "claim after a retry = %+v", job); err != nil {
+			return err
+		}
+
+		responseCode = 204
+		err = s.RecordWebhookDeliveryAttempt(ctx, delivery.DeliveryID, 2, domains.DeliveryDelivered, &responseCode, nil, nil)
+		if err != nil {
+			return fmt.Errorf("RecordWebhookDeliveryAttempt: %w", err)
+		}
+		stored, err := s.GetWebhookDelivery(ctx, delivery.DeliveryID)
+		if err != nil {
+			return fmt.Errorf("GetWebhookDelivery: %w", err)
+		}
+		if err := check(stored != nil && stored.Status == domains.DeliveryDelivered && stored.Attempts == 2 && stored.DeliveredAt != nil &&
+			stored.NextAttemptAt == nil && stored.WebhookID == w.WebhookID, "delivered delivery = %+v", stored); err != nil {
+			return err
+		}
+
+		// A disabled subscription gets no deliveries for new events
+		if ok, err := s.SetWebhookEnabled(ctx, w.WebhookID, false); err != nil || !ok {
+			return fmt.Errorf("SetWebhookEnabled = %t, %v", ok, err)
+		}
+		later, err := createCommand(ctx, s, nodeID, "conformance.webhook", domains.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		if err := finishCommand(ctx, s, nodeID, later, domains.StatusSuccess); err != nil {
+			return err
+		}
+		if _, err := s.FanOutWebhookEvents(ctx, 1000); err != nil {
+			return fmt.Errorf("FanOutWebhookEvents: %w", err)
+		}
+		deliveries, err = s.ListWebhookDeliveries(ctx, w.WebhookID, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		if err := check(len(deliveries) == 1, "a disabled webhook got %d deliveries", len(deliveries)); err != nil {
+			return err
+		}
+
+		webhooks, err := s.ListWebhooks(ctx, domains.DefaultTenantID)
+		if err != nil {
+			return fmt.Errorf("ListWebhooks: %w", err)
+		}
+		listed := false
+		for _, wh := range webhooks {
+			listed = listed || (wh.WebhookID == w.WebhookID && !wh.Enabled)
+		}
+		if err := check(listed, "ListWebhooks does not include the disabled webhook"); err != nil {
+			return err
+		}
+
+		deleted, err := s.DeleteWebhook(ctx, w.WebhookID)
+		if err != nil {
+			return fmt.Errorf("DeleteWebhook: %w", err)
+		}
+		if ok, err := s.DeleteWebhook(ctx, w.WebhookID); err != nil || ok {
+			return fmt.Errorf("deleting a webhook twice = %t, %v", ok, err)
+		}
+		stored, err = s.GetWebhookDelivery(ctx, delivery.DeliveryID)
+		if err != nil {
+			return fmt.Errorf("GetWebhookDelivery: %w", err)
+		}
+		if err := check(deleted && stored == nil, "deleting a webhook kept its delivery"); err != nil {
+			return err
+		}
+		return s.DeleteOldWebhookEvents(ctx, 7)
+	}},
+
+	{Name: "node offline and online events", Run: func(ctx context.Context, s clients.StorageAdapter) error {
+		nodeID, err := registerNode(ctx, s)
+		if err != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		marked, err := s.MarkOfflineNodes(ctx, 10*time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("MarkOfflineNodes: %w", err)
+		}
+		again, err := s.MarkOfflineNodes(ctx, 10*time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("MarkOfflineNodes: %w", err)
+		}
+		if err := check(marked >= 1 && again == 0, "MarkOfflineNodes twice = %d, %d", marked, again); err != nil {
+			return err
+		}
+
+		if err := flushOutbox(ctx, s); err != nil {
+			return err
+		}
+		w := &domains.WebhookSubscription{TenantID: domains.DefaultTenantID, URL: "https://example.com/nodes", EventTypes: []string{"node.*"}, Secret: "conformance", Enabled: true}
+		if err := s.CreateWebhook(ctx, w); err != nil {
+			return fmt.Errorf("CreateWebhook: %w", err)
+		}
+		defer s.DeleteWebhook(ctx, w.WebhookID)
+
+		// Only the first heartbeat after going offline brings the node back
+		for i := 0; i < 2; i++ {
+			if err := s.UpdateNodeLastSeen(ctx, nodeID); err != nil {
+				return fmt.Errorf("UpdateNodeLastSeen: %w", err)
+			}
+		}
+		if _, err := s.FanOutWebhookEvents(ctx, 1000); err != nil {
+			return fmt.Errorf("FanOutWebhookEvents: %w", err)
+		}
+		deliveries, err := s.ListWebhookDeliveries(ctx, w.WebhookID, nil, 10)
+		if err != nil {
+			return fmt.Errorf("ListWebhookDeliveries: %w", err)
+		}
+		if err := check(len(deliveries) == 1 && deliveries[0].EventType == domains.EventNodeOnline,
+			"node webhook got %d deliveries, want one node.online", len(deliveries)); err != nil {
+			return err
+		}
+		job, err := claimDelivery(ctx, s, deliveries[0].DeliveryID)
+		if err != nil {
+			return err
+		}
+		return check(job != nil && job.Event.Payload["node_id"] == nodeID, "node.online event = %+v", job)
+	}},
+}
+
+// flushOutbox fans out every pending outbox event, so a case only sees the events it causes
+func flushOutbox(ctx context.Context, s clients.StorageAdapter) error {
+	if _, err := s.FanOutWebhookEvents(ctx, 100000); err != nil {
+		return fmt.Errorf("FanOutWebhookEvents: %w", err)
+	}
+	return nil
+}
+
+// claimDelivery claims due deliveries and returns the job of deliveryID, or nil if it wasn't claimed
+func claimDelivery(ctx context.Context, s clients.StorageAdapter, deliveryID uuid.UUID) (*domains.WebhookDeliveryJob, error) {
+	jobs, err := s.ClaimWebhookDeliveries(ctx, 1000, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("ClaimWebhookDeliveries: %w", err)
+	}
+	for i := range jobs {
+		if jobs[i].DeliveryID == deliveryID {
+			return &jobs[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,169 @@
+package memory
+
+import (
+	"context"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// copyApprovalPolicy returns a copy of a policy that shares no state with the store
+func copyApprovalPolicy(p *domains.ApprovalPolicy) *domains.ApprovalPolicy {
+	copied := *p
+	copied.NodeLabels = make(map[string]string, len(p.NodeLabels))
+	for key, value := range p.NodeLabels {
+		copied.NodeLabels[key] = value
+	}
+	return &copied
+}
+
+// CreateApprovalPolicy inserts an approval policy and fills in its generated ID
+func (s *Store) CreateApprovalPolicy(ctx context.Context, p *domains.ApprovalPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.ID, p.PolicyID, p.CreatedAt = s.id(), uuid.New(), now()
+	s.approvalPolicies = append(s.approvalPolicies, copyApprovalPolicy(p))
+	return nil
+}
+
+// GetApprovalPolicy retrieves an approval policy by ID, or nil if it doesn't exist
+func (s *Store) GetApprovalPolicy(ctx context.Context, policyID uuid.UUID) (*domains.ApprovalPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.approvalPolicies {
+		if p.PolicyID == policyID {
+			return copyApprovalPolicy(p), nil
+		}
+	}
+	return nil, nil
+}
+
+// ListApprovalPolicies retrieves all approval policies, oldest first
+func (s *Store) ListApprovalPolicies(ctx context.Context) ([]*domains.ApprovalPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var policies []*domains.ApprovalPolicy
+	for _, p := range s.approvalPolicies {
+		policies = append(policies, copyApprovalPolicy(p))
+	}
+	return policies, nil
+}
+
+// DeleteApprovalPolicy deletes an approval policy; approval requests made under it are kept
+func (s *Store) DeleteApprovalPolicy(ctx context.Context, policyID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	policies := s.approvalPolicies[:0]
+	for _, p := range s.approvalPolicies {
+		if p.PolicyID == policyID {
+			found = true
+			continue
+		}
+		policies = append(policies, p)
+	}
+	s.approvalPolicies = policies
+	return found, nil
+}
+
+// approval returns a copy of the approval request of a command with the command's node and type
+func (s *Store) approval(a *domains.CommandApproval) *domains.CommandApproval {
+	copied := *a
+	copied.Decision, copied.DecidedBy, copied.Comment = copyString(a.Decision), copyString(a.DecidedBy), copyString(a.Comment)
+	copied.DecidedAt = timestampPtr(a.DecidedAt)
+	if row, ok := s.commandsByID[a.CommandID]; ok {
+		copied.NodeID, copied.CommandType = row.cmd.NodeID, row.cmd.CommandType
+	}
+	return &copied
+}
+
+// GetCommandApproval retrieves the approval request of a command, or nil if it was submitted without one
+func (s *Store) GetCommandApproval(ctx context.Context, commandID uuid.UUID) (*domains.CommandApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.approvals[commandID]
+	if !ok {
+		return nil, nil
+	}
+	return s.approval(a), nil
+}
+
+// ListPendingApprovals retrieves up to limit approval requests still waiting for a decision, oldest first;
+// a non-empty tenantID limits them to the nodes of that tenant
+func (s *Store) ListPendingApprovals(ctx context.Context, tenantID string, limit int) ([]*domains.CommandApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var approvals []*domains.CommandApproval
+	for _, row := range s.commands {
+		if len(approvals) == limit {
+			break
+		}
+		a, ok := s.approvals[row.cmd.CommandID]
+		if !ok || row.cmd.Status != domains.StatusPendingApproval || (tenantID != "" && !s.inTenant(row.cmd.NodeID, tenantID)) {
+			continue
+		}
+		approvals = append(approvals, s.approval(a))
+	}
+	return approvals, nil
+}
+
+// DecideCommandApproval records an operator's decision on a command pending approval, queueing an approved
+// command and rejecting a rejected one. It returns false if the command is not pending approval.
+func (s *Store) DecideCommandApproval(ctx context.Context, commandID uuid.UUID, decision, operatorID string, comment *string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.commandsByID[commandID]
+	a, pending := s.approvals[commandID]
+	if !ok || !pending || row.cmd.Status != domains.StatusPendingApproval {
+		return false, nil
+	}
+
+	decided := now()
+	decidedAt, decidedBy := decided, operatorID
+	a.Decision, a.DecidedBy, a.Comment, a.DecidedAt = &decision, &decidedBy, copyString(comment), &decidedAt
+
+	from := row.cmd.Status
+	row.cmd.UpdatedAt = decided
+	if decision == domains.ApprovalApproved {
+		row.cmd.Status = domains.StatusQueued
+		s.recordStatusChange(commandID, &from, domains.StatusQueued, domains.SourceOperator)
+		return true, nil
+	}
+
+	errorMsg, finishedAt := "approval rejected by "+operatorID, decided
+	row.cmd.Status, row.cmd.ErrorMsg, row.cmd.FinishedAt = domains.StatusRejected, &errorMsg, &finishedAt
+	s.recordStatusChange(commandID, &from, domains.StatusRejected, domains.SourceOperator)
+	ev := commandEvent{
+		CommandID: commandID, NodeID: row.cmd.NodeID, CommandType: row.cmd.CommandType, Status: domains.StatusRejected,
+		Attempt: row.cmd.Attempt, ErrorMsg: row.cmd.ErrorMsg, FinishedAt: decided,
+	}
+	return true, s.insertCommandEvent(ev)
+}
+
+// ExpireCommandApprovals moves commands whose approval request passed its expiry undecided to expired
+func (s *Store) ExpireCommandApprovals(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	expired := func(cmd *domains.NodeCommand) bool {
+		a, ok := s.approvals[cmd.CommandID]
+		return ok && !a.ExpiresAt.After(current)
+	}
+	for _, row := range s.commands {
+		if row.cmd.Status == domains.StatusPendingApproval && expired(&row.cmd) {
+			decision, decidedAt := domains.ApprovalExpired, current
+			a := s.approvals[row.cmd.CommandID]
+			a.Decision, a.DecidedAt = &decision, &decidedAt
+		}
+	}
+	return s.finishCommands(domains.StatusPendingApproval, domains.StatusExpired, "approval request expired", domains.SourceSystem, current, expired)
+}
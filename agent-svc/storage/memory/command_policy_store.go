@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"context"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// commandPolicyRow is a row of the command_policies table; the rules are kept encoded
+type commandPolicyRow struct {
+	p     domains.CommandPolicy
+	rules []byte
+}
+
+// CreateCommandPolicy stores a policy as the next version and fills in its ID and version
+func (s *Store) CreateCommandPolicy(ctx context.Context, p *domains.CommandPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := p.Rules
+	if rules == nil {
+		rules = []domains.PolicyRule{}
+	}
+	rulesJSON, err := encodeJSON(rules, "policy rules")
+	if err != nil {
+		return err
+	}
+
+	version := 1
+	if n := len(s.commandPolicies); n > 0 {
+		version = s.commandPolicies[n-1].p.Version + 1
+	}
+	p.ID, p.PolicyID, p.Version, p.CreatedAt = s.id(), uuid.New(), version, now()
+
+	row := &commandPolicyRow{p: *p, rules: rulesJSON}
+	row.p.Rules = nil
+	s.commandPolicies = append(s.commandPolicies, row)
+	return nil
+}
+
+// GetCommandPolicy retrieves the latest version of the command policy, or nil if none was ever stored
+func (s *Store) GetCommandPolicy(ctx context.Context) (*domains.CommandPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.commandPolicies) == 0 {
+		return nil, nil
+	}
+	row := s.commandPolicies[len(s.commandPolicies)-1]
+	p := row.p
+	if err := decodeJSON(row.rules, &p.Rules, "policy rules"); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
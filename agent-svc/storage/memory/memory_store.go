@@ -0,0 +1,1022 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is reported as the migration version; the in-memory store always has the schema of its build
+const SchemaVersion = 1
+
+// Store represents the in-memory storage implementation
+// Everything is lost when the process exits and nothing is shared between processes, so it suits development,
+// demos and tests of a single agent-svc replica. A single mutex serializes all operations, which gives every
+// method the atomicity of a Postgres transaction.
+type Store struct {
+	mu     sync.Mutex
+	nextID int64
+
+	nodes           map[string]*nodeRow
+	metadata        map[string]*domains.AgentMetadata
+	commands        []*commandRow
+	commandsByID    map[uuid.UUID]*commandRow
+	history         []domains.CommandStatusChange
+	idempotencyKeys map[idempotencyKeyID]*domains.IdempotencyKey
+	logs            map[uuid.UUID][]*logRow
+
+	schedules    []*scheduleRow
+	scheduleRuns []domains.ScheduleRun
+
+	workflows     []*workflowRow
+	workflowSteps []*domains.WorkflowStepState
+
+	rollouts       []*rolloutRow
+	rolloutBatches []*domains.RolloutBatch
+
+	templates []*templateRow
+
+	approvalPolicies []*domains.ApprovalPolicy
+	approvals        map[uuid.UUID]*domains.CommandApproval
+
+	commandPolicies []*commandPolicyRow
+
+	webhooks          []*domains.WebhookSubscription
+	webhookEvents     []*eventRow
+	webhookDeliveries []*domains.WebhookDelivery
+
+	tenants         map[string]*domains.Tenant
+	tenantOperators map[tenantOperatorID]time.Time
+}
+
+// NewStore creates a new, empty in-memory store
+func NewStore() *Store {
+	s := &Store{
+		nodes:           make(map[string]*nodeRow),
+		metadata:        make(map[string]*domains.AgentMetadata),
+		commandsByID:    make(map[uuid.UUID]*commandRow),
+		idempotencyKeys: make(map[idempotencyKeyID]*domains.IdempotencyKey),
+		logs:            make(map[uuid.UUID][]*logRow),
+		approvals:       make(map[uuid.UUID]*domains.CommandApproval),
+		tenants:         make(map[string]*domains.Tenant),
+		tenantOperators: make(map[tenantOperatorID]time.Time),
+	}
+	// The default tenant is created by the Postgres migration that introduces tenants
+	created := now()
+	s.tenants[domains.DefaultTenantID] = &domains.Tenant{
+		ID: s.id(), TenantID: domains.DefaultTenantID, Name: "Default", CreatedAt: created, UpdatedAt: created,
+	}
+	return s
+}
+
+// Close releases the store; the in-memory store holds no external resources
+func (s *Store) Close() {}
+
+// Ping always succeeds; the in-memory store has no connection to lose
+func (s *Store) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// MigrationVersion returns SchemaVersion; there are no migrations to apply
+func (s *Store) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return SchemaVersion, false, nil
+}
+
+// id returns the next row ID; IDs are shared by all tables, which only needs them to be unique and increasing
+func (s *Store) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// now returns the current time at the microsecond precision of a Postgres timestamptz
+func now() time.Time {
+	return timestamp(time.Now())
+}
+
+// timestamp rounds t to the microsecond precision of a Postgres timestamptz
+func timestamp(t time.Time) time.Time {
+	return t.UTC().Round(time.Microsecond)
+}
+
+// timestampPtr is timestamp for an optional time
+func timestampPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	ts := timestamp(*t)
+	return &ts
+}
+
+// encodeJSON marshals a value stored as JSON, as the Postgres store does for JSONB columns
+func encodeJSON(v interface{}, what string) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", what, err)
+	}
+	return data, nil
+}
+
+// decodeJSON unmarshals a stored JSON value into a fresh copy, so callers never share state with the store
+func decodeJSON(data []byte, v interface{}, what string) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", what, err)
+	}
+	return nil
+}
+
+// nodeRow is a row of the nodes table
+type nodeRow struct {
+	id         int64
+	nodeID     string
+	tenantID   string
+	attrs      []byte
+	labels     map[string]string
+	lastSeenAt time.Time
+	disabled   bool
+	offlineAt  *time.Time
+	policyHash string
+}
+
+// node returns the domain form of the row
+func (r *nodeRow) node() (*domains.Node, error) {
+	n := &domains.Node{ID: r.id, NodeID: r.nodeID, TenantID: r.tenantID, LastSeenAt: r.lastSeenAt, Disabled: r.disabled, LocalPolicyHash: r.policyHash}
+	if err := decodeJSON(r.attrs, &n.Attrs, "attrs"); err != nil {
+		return nil, err
+	}
+	n.Labels = make(map[string]string, len(r.labels))
+	for key, value := range r.labels {
+		n.Labels[key] = value
+	}
+	return n, nil
+}
+
+// commandRow is a row of the node_commands table; JSON columns are kept encoded
+type commandRow struct {
+	cmd          domains.NodeCommand
+	payload      []byte
+	retryPolicy  []byte
+	traceContext []byte
+}
+
+// command returns a copy of the command with its JSON columns decoded
+func (r *commandRow) command() (*domains.NodeCommand, error) {
+	cmd := r.cmd
+	if err := decodeJSON(r.payload, &cmd.Payload, "payload"); err != nil {
+		return nil, err
+	}
+	if r.retryPolicy != nil {
+		cmd.RetryPolicy = &domains.RetryPolicy{}
+		if err := decodeJSON(r.retryPolicy, cmd.RetryPolicy, "retry policy"); err != nil {
+			return nil, err
+		}
+	}
+	if r.traceContext != nil {
+		if err := decodeJSON(r.traceContext, &cmd.TraceContext, "trace context"); err != nil {
+			return nil, err
+		}
+	}
+	return &cmd, nil
+}
+
+// idempotencyKeyID is the unique key of the idempotency_keys table
+type idempotencyKeyID struct {
+	operatorID, nodeID, key string
+}
+
+// logRow is a row of the command_logs table
+type logRow struct {
+	log       domains.CommandLog
+	createdAt time.Time
+}
+
+// RegisterNode enrolls a new node into a tenant, or updates the attrs of a node already enrolled in it
+// It returns domains.ErrNodeInOtherTenant if the node ID belongs to another tenant and
+// domains.ErrTenantQuotaExceeded if a new node would exceed the tenant's node quota.
+func (s *Store) RegisterNode(ctx context.Context, tenantID, nodeID string, attrs map[string]interface{}) error {
+	attrsJSON, err := encodeJSON(attrs, "attrs")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tenants[tenantID]
+	if !ok {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	if n, ok := s.nodes[nodeID]; ok {
+		if n.tenantID != tenantID {
+			return fmt.Errorf("%w: %s", domains.ErrNodeInOtherTenant, nodeID)
+		}
+		n.attrs, n.lastSeenAt = attrsJSON, now()
+		return nil
+	}
+	if t.MaxNodes > 0 && s.countTenantNodes(tenantID) >= t.MaxNodes {
+		return domains.NodeQuotaError(tenantID, t.MaxNodes)
+	}
+	s.nodes[nodeID] = &nodeRow{id: s.id(), nodeID: nodeID, tenantID: tenantID, attrs: attrsJSON, labels: map[string]string{}, lastSeenAt: now()}
+	return nil
+}
+
+// UpdateNodeLastSeen updates the last_seen_at timestamp
+// A node the node monitor reported offline is brought back with a node.online event.
+func (s *Store) UpdateNodeLastSeen(ctx context.Context, nodeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return nil
+	}
+	offlineAt := n.offlineAt
+	n.lastSeenAt, n.offlineAt = now(), nil
+
+	if offlineAt != nil {
+		return s.insertWebhookEvent(domains.EventNodeOnline, map[string]interface{}{
+			"node_id":    nodeID,
+			"offline_at": offlineAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return nil
+}
+
+// GetNode retrieves a node by ID
+func (s *Store) GetNode(ctx context.Context, nodeID string) (*domains.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, nil
+	}
+	return n.node()
+}
+
+// UpdateNodeLabels removes and then sets labels of a node, keeping its other labels
+// It returns false if the node doesn't exist.
+func (s *Store) UpdateNodeLabels(ctx context.Context, nodeID string, set map[string]string, remove []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return false, nil
+	}
+	for _, key := range remove {
+		delete(n.labels, key)
+	}
+	for key, value := range set {
+		n.labels[key] = value
+	}
+	return true, nil
+}
+
+// SetNodeDisabled disables or re-enables a node; it returns false if the node doesn't exist
+func (s *Store) SetNodeDisabled(ctx context.Context, nodeID string, disabled bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return false, nil
+	}
+	n.disabled = disabled
+	return true, nil
+}
+
+// SetNodePolicyHash records the hash of the local policy a node reported; it returns false if the node doesn't exist
+func (s *Store) SetNodePolicyHash(ctx context.Context, nodeID, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return false, nil
+	}
+	n.policyHash = hash
+	return true, nil
+}
+
+// CreateCommand creates a new command in the queue
+// It returns domains.ErrTenantQuotaExceeded if the node's tenant has used up its daily command quota.
+func (s *Store) CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}, opts domains.CommandOptions) (uuid.UUID, error) {
+	payloadJSON, err := encodeJSON(payload, "payload")
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	row := &commandRow{payload: payloadJSON}
+	if opts.RetryPolicy != nil {
+		if row.retryPolicy, err = encodeJSON(opts.RetryPolicy, "retry policy"); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if len(opts.TraceContext) > 0 {
+		if row.traceContext, err = encodeJSON(opts.TraceContext, "trace context"); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	priority := domains.PriorityDefault
+	if opts.Priority != nil {
+		priority = *opts.Priority
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return uuid.Nil, fmt.Errorf("node %s is not registered", nodeID)
+	}
+	if t := s.tenants[n.tenantID]; t.MaxCommandsPerDay > 0 && s.countTenantCommandsLastDay(t.TenantID) >= t.MaxCommandsPerDay {
+		return uuid.Nil, domains.CommandQuotaError(t.TenantID, t.MaxCommandsPerDay)
+	}
+
+	status := domains.StatusQueued
+	if opts.Approval != nil {
+		status = domains.StatusPendingApproval
+	}
+
+	created := now()
+	row.cmd = domains.NodeCommand{
+		CommandID:   uuid.New(),
+		NodeID:      nodeID,
+		CommandType: commandType,
+		Status:      status,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+		ExpiresAt:   timestampPtr(opts.ExpiresAt),
+		Priority:    priority,
+		Attempt:     1,
+		WorkflowID:  opts.WorkflowID,
+		RolloutID:   opts.RolloutID,
+	}
+	if opts.WorkflowStepID != "" {
+		stepID := opts.WorkflowStepID
+		row.cmd.WorkflowStepID = &stepID
+	}
+	if opts.RolloutID != nil {
+		batch := opts.RolloutBatch
+		row.cmd.RolloutBatch = &batch
+	}
+	if opts.TemplateName != "" {
+		name, version := opts.TemplateName, opts.TemplateVersion
+		row.cmd.TemplateName, row.cmd.TemplateVersion = &name, &version
+	}
+
+	if opts.IdempotencyKey != "" {
+		// An expired key is taken over; a live one aborts the submission so no duplicate command is created
+		keyID := idempotencyKeyID{operatorID: opts.OperatorID, nodeID: nodeID, key: opts.IdempotencyKey}
+		k, ok := s.idempotencyKeys[keyID]
+		if ok && k.ExpiresAt.After(created) {
+			return uuid.Nil, domains.ErrDuplicateIdempotencyKey
+		}
+		if !ok {
+			k = &domains.IdempotencyKey{ID: s.id(), OperatorID: opts.OperatorID, NodeID: nodeID, Key: opts.IdempotencyKey}
+			s.idempotencyKeys[keyID] = k
+		}
+		k.RequestHash, k.CommandID = opts.RequestHash, row.cmd.CommandID
+		k.CreatedAt, k.ExpiresAt = created, timestamp(opts.IdempotencyExpiresAt)
+	}
+
+	s.insertCommand(row)
+	s.recordStatusChange(row.cmd.CommandID, nil, status, domains.SourceSubmit)
+	if a := opts.Approval; a != nil {
+		s.approvals[row.cmd.CommandID] = &domains.CommandApproval{
+			CommandID: row.cmd.CommandID, PolicyID: a.PolicyID, PolicyName: a.PolicyName, RequestedBy: opts.OperatorID,
+			ExpiresAt: timestamp(a.ExpiresAt), CreatedAt: created,
+		}
+	}
+	return row.cmd.CommandID, nil
+}
+
+// insertCommand adds a command row, assigning its row ID
+func (s *Store) insertCommand(row *commandRow) {
+	row.cmd.ID = s.id()
+	s.commands = append(s.commands, row)
+	s.commandsByID[row.cmd.CommandID] = row
+}
+
+// GetIdempotencyKey retrieves an unexpired idempotency key, or nil if there is none
+func (s *Store) GetIdempotencyKey(ctx context.Context, operatorID, nodeID, key string) (*domains.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.idempotencyKeys[idempotencyKeyID{operatorID: operatorID, nodeID: nodeID, key: key}]
+	if !ok || !k.ExpiresAt.After(now()) {
+		return nil, nil
+	}
+	copied := *k
+	return &copied, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes idempotency keys past their expiry
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	for keyID, k := range s.idempotencyKeys {
+		if !k.ExpiresAt.After(current) {
+			delete(s.idempotencyKeys, keyID)
+		}
+	}
+	return nil
+}
+
+// GetNextCommand claims up to 5 queued commands for a node and marks them as running
+// Commands past their expires_at are never handed out even if the sweeper hasn't run yet.
+func (s *Store) GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	var due []*commandRow
+	for _, row := range s.commands {
+		if row.cmd.NodeID == nodeID && row.cmd.Status == domains.StatusQueued &&
+			(row.cmd.ExpiresAt == nil || row.cmd.ExpiresAt.After(current)) {
+			due = append(due, row)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if due[i].cmd.Priority != due[j].cmd.Priority {
+			return due[i].cmd.Priority > due[j].cmd.Priority
+		}
+		return due[i].cmd.CreatedAt.Before(due[j].cmd.CreatedAt)
+	})
+	if len(due) > 5 {
+		due = due[:5]
+	}
+
+	var commands []*domains.NodeCommand
+	for _, row := range due {
+		cmd, err := row.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+
+	queued := domains.StatusQueued
+	for i, row := range due {
+		dispatchedAt := current
+		row.cmd.Status, row.cmd.DispatchedAt, row.cmd.UpdatedAt = domains.StatusRunning, &dispatchedAt, current
+		commands[i].Status, commands[i].DispatchedAt, commands[i].UpdatedAt = row.cmd.Status, row.cmd.DispatchedAt, current
+		s.recordStatusChange(row.cmd.CommandID, &queued, domains.StatusRunning, domains.SourceDispatch)
+	}
+	return commands, nil
+}
+
+// UpdateCommandStatus moves a command to a new status
+// The transition is validated against the current status and recorded in the status history;
+// domains.ErrInvalidStatusTransition is returned if it isn't allowed
+func (s *Store) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string, source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.commandsByID[commandID]
+	if !ok {
+		return fmt.Errorf("command not found")
+	}
+
+	current := row.cmd.Status
+	if err := domains.ValidateStatusTransition(current, status); err != nil {
+		return err
+	}
+
+	isTerminal := domains.IsTerminalStatus(status)
+	updated := now()
+	row.cmd.Status, row.cmd.ExitCode, row.cmd.ErrorMsg, row.cmd.UpdatedAt = status, copyInt(exitCode), copyString(errorMsg), updated
+	if status == domains.StatusRunning && row.cmd.StartedAt == nil {
+		startedAt := updated
+		row.cmd.StartedAt = &startedAt
+	}
+	if isTerminal {
+		finishedAt := updated
+		row.cmd.FinishedAt = &finishedAt
+	}
+
+	s.recordStatusChange(commandID, &current, status, source)
+
+	if isTerminal {
+		ev := commandEvent{
+			CommandID: commandID, NodeID: row.cmd.NodeID, CommandType: row.cmd.CommandType, Status: status,
+			Attempt: row.cmd.Attempt, ExitCode: exitCode, ErrorMsg: errorMsg, FinishedAt: updated,
+		}
+		if err := s.insertCommandEvent(ev); err != nil {
+			return err
+		}
+		for _, l := range s.logs[commandID] {
+			l.log.IsFinal = true
+		}
+	}
+	return nil
+}
+
+// ExpireQueuedCommands moves queued commands past their expires_at to expired
+func (s *Store) ExpireQueuedCommands(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	return s.finishCommands(domains.StatusQueued, domains.StatusExpired, "delivery deadline passed before dispatch", domains.SourceSystem, current,
+		func(cmd *domains.NodeCommand) bool {
+			return cmd.ExpiresAt != nil && !cmd.ExpiresAt.After(current)
+		})
+}
+
+// ListLostCommands retrieves up to limit running commands, oldest first, whose node hasn't been seen for lostAfter
+func (s *Store) ListLostCommands(ctx context.Context, lostAfter time.Duration, limit int) ([]*domains.NodeCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now().Add(-lostAfter)
+	lost, err := s.selectCommands(func(cmd *domains.NodeCommand) bool {
+		n, ok := s.nodes[cmd.NodeID]
+		return cmd.Status == domains.StatusRunning && ok && n.lastSeenAt.Before(cutoff)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(lost, func(i, j int) bool { return lost[i].UpdatedAt.Before(lost[j].UpdatedAt) })
+	if len(lost) > limit {
+		lost = lost[:limit]
+	}
+	return lost, nil
+}
+
+// finishCommands moves the commands in status from matching match to a terminal status, recording the
+// transition and adding a webhook event for each command
+func (s *Store) finishCommands(from, status, errorMsg, source string, finishedAt time.Time, match func(*domains.NodeCommand) bool) (int, error) {
+	count := 0
+	for _, row := range s.commands {
+		if row.cmd.Status != from || !match(&row.cmd) {
+			continue
+		}
+		msg, finished := errorMsg, finishedAt
+		row.cmd.Status, row.cmd.ErrorMsg, row.cmd.UpdatedAt, row.cmd.FinishedAt = status, &msg, finishedAt, &finished
+
+		ev := commandEvent{
+			CommandID: row.cmd.CommandID, NodeID: row.cmd.NodeID, CommandType: row.cmd.CommandType, Status: status,
+			Attempt: row.cmd.Attempt, ErrorMsg: row.cmd.ErrorMsg, FinishedAt: finishedAt,
+		}
+		if err := s.insertCommandEvent(ev); err != nil {
+			return 0, err
+		}
+		s.recordStatusChange(row.cmd.CommandID, &from, status, source)
+		count++
+	}
+	return count, nil
+}
+
+// ScheduleCommandRetry marks a finished attempt to be retried at the given time
+func (s *Store) ScheduleCommandRetry(ctx context.Context, commandID uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.commandsByID[commandID]; ok {
+		row.cmd.NextRetryAt, row.cmd.UpdatedAt = timestampPtr(&at), now()
+	}
+	return nil
+}
+
+// CreateRetryAttempts queues the next attempt of up to limit commands whose retry is due
+// An attempt gets as long to be delivered as the previous one had, counted from its creation.
+func (s *Store) CreateRetryAttempts(ctx context.Context, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	var due []*commandRow
+	for _, row := range s.commands {
+		if row.cmd.NextRetryAt != nil && !row.cmd.NextRetryAt.After(current) {
+			due = append(due, row)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].cmd.NextRetryAt.Before(*due[j].cmd.NextRetryAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, prev := range due {
+		prev.cmd.NextRetryAt, prev.cmd.UpdatedAt = nil, current
+
+		parentID := prev.cmd.FirstAttemptID()
+		var expiresAt *time.Time
+		if prev.cmd.ExpiresAt != nil {
+			t := timestamp(current.Add(prev.cmd.ExpiresAt.Sub(prev.cmd.CreatedAt)))
+			expiresAt = &t
+		}
+		next := &commandRow{payload: prev.payload, retryPolicy: prev.retryPolicy, traceContext: prev.traceContext}
+		next.cmd = domains.NodeCommand{
+			CommandID:       uuid.New(),
+			NodeID:          prev.cmd.NodeID,
+			CommandType:     prev.cmd.CommandType,
+			Status:          domains.StatusQueued,
+			CreatedAt:       current,
+			UpdatedAt:       current,
+			ExpiresAt:       expiresAt,
+			Priority:        prev.cmd.Priority,
+			ParentCommandID: &parentID,
+			Attempt:         prev.cmd.Attempt + 1,
+			WorkflowID:      prev.cmd.WorkflowID,
+			WorkflowStepID:  prev.cmd.WorkflowStepID,
+			RolloutID:       prev.cmd.RolloutID,
+			RolloutBatch:    prev.cmd.RolloutBatch,
+			TemplateName:    prev.cmd.TemplateName,
+			TemplateVersion: prev.cmd.TemplateVersion,
+		}
+		s.insertCommand(next)
+		s.recordStatusChange(next.cmd.CommandID, nil, domains.StatusQueued, domains.SourceSystem)
+	}
+	return len(due), nil
+}
+
+// GetCommandAttempts retrieves all attempts of a command, given its first attempt, ordered by attempt
+func (s *Store) GetCommandAttempts(ctx context.Context, firstCommandID uuid.UUID) ([]*domains.NodeCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, err := s.selectCommands(func(cmd *domains.NodeCommand) bool {
+		return cmd.CommandID == firstCommandID || (cmd.ParentCommandID != nil && *cmd.ParentCommandID == firstCommandID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].Attempt < attempts[j].Attempt })
+	return attempts, nil
+}
+
+// selectCommands returns copies of the commands matching match in insertion order
+func (s *Store) selectCommands(match func(*domains.NodeCommand) bool) ([]*domains.NodeCommand, error) {
+	var commands []*domains.NodeCommand
+	for _, row := range s.commands {
+		if !match(&row.cmd) {
+			continue
+		}
+		cmd, err := row.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// recordStatusChange appends a transition to the status history
+func (s *Store) recordStatusChange(commandID uuid.UUID, fromStatus *string, toStatus, source string) {
+	s.history = append(s.history, domains.CommandStatusChange{
+		ID:         s.id(),
+		CommandID:  commandID.String(),
+		FromStatus: copyString(fromStatus),
+		ToStatus:   toStatus,
+		Source:     source,
+		CreatedAt:  now(),
+	})
+}
+
+// GetCommandStatusHistory retrieves the status transitions of a command in order
+func (s *Store) GetCommandStatusHistory(ctx context.Context, commandID uuid.UUID) ([]domains.CommandStatusChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := commandID.String()
+	var history []domains.CommandStatusChange
+	for _, change := range s.history {
+		if change.CommandID == id {
+			change.FromStatus = copyString(change.FromStatus)
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+// UpdateCommandOutput records the output statistics reported for a command
+func (s *Store) UpdateCommandOutput(ctx context.Context, commandID uuid.UUID, output domains.CommandOutput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.commandsByID[commandID]; ok {
+		totalBytes := output.TotalBytes
+		row.cmd.OutputBytes = &totalBytes
+		row.cmd.OutputTruncated = row.cmd.OutputTruncated || output.Truncated
+		row.cmd.UpdatedAt = now()
+	}
+	return nil
+}
+
+// MarkCommandOutputTruncated flags a command whose log chunks were dropped by the server-side cap
+func (s *Store) MarkCommandOutputTruncated(ctx context.Context, commandID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.commandsByID[commandID]; ok {
+		row.cmd.OutputTruncated = true
+	}
+	return nil
+}
+
+// GetCommandLogSize returns the number of log bytes stored for a command
+func (s *Store) GetCommandLogSize(ctx context.Context, commandID uuid.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var size int64
+	for _, l := range s.logs[commandID] {
+		size += int64(len(l.log.Data))
+	}
+	return size, nil
+}
+
+// GetCommandByID retrieves a command by ID
+func (s *Store) GetCommandByID(ctx context.Context, commandID uuid.UUID) (*domains.NodeCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.commandsByID[commandID]
+	if !ok {
+		return nil, nil
+	}
+	return row.command()
+}
+
+// InsertLogChunks stores log chunks idempotently; a chunk sent again only updates its is_final flag
+// It returns the indexes of the acknowledged chunks.
+func (s *Store) InsertLogChunks(ctx context.Context, commandID uuid.UUID, chunks []domains.CommandLog) ([]int64, error) {
+	if len(chunks) == 0 {
+		return []int64{}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.commandsByID[commandID]; !ok {
+		return nil, fmt.Errorf("command %s not found", commandID)
+	}
+
+	ackedChunkIndexes := make([]int64, 0, len(chunks))
+	for _, chunk := range chunks {
+		ackedChunkIndexes = append(ackedChunkIndexes, chunk.ChunkIndex)
+
+		if existing := s.findLogChunk(commandID, chunk.ChunkIndex, chunk.Stream); existing != nil {
+			existing.log.IsFinal = chunk.IsFinal
+			continue
+		}
+		s.logs[commandID] = append(s.logs[commandID], &logRow{
+			log: domains.CommandLog{
+				ID:         s.id(),
+				CommandID:  commandID.String(),
+				ChunkIndex: chunk.ChunkIndex,
+				Stream:     chunk.Stream,
+				Data:       chunk.Data,
+				Encoding:   chunk.Encoding,
+				IsFinal:    chunk.IsFinal,
+			},
+			createdAt: now(),
+		})
+	}
+	return ackedChunkIndexes, nil
+}
+
+// findLogChunk returns the stored chunk with the given index and stream, or nil
+func (s *Store) findLogChunk(commandID uuid.UUID, chunkIndex int64, stream string) *logRow {
+	for _, l := range s.logs[commandID] {
+		if l.log.ChunkIndex == chunkIndex && l.log.Stream == stream {
+			return l
+		}
+	}
+	return nil
+}
+
+// GetCommandLogs retrieves logs for a command ordered by chunk_index
+// Returns all logs for the command, even if it's not finished
+// If afterChunkIndex is provided, only returns logs with chunk_index >= afterChunkIndex (inclusive)
+// A non-empty tenantID returns no logs unless the command's node belongs to that tenant.
+func (s *Store) GetCommandLogs(ctx context.Context, tenantID string, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.commandsByID[commandID]; tenantID != "" && (!ok || !s.inTenant(row.cmd.NodeID, tenantID)) {
+		return nil, nil
+	}
+
+	var logs []domains.CommandLog
+	for _, l := range s.logs[commandID] {
+		if afterChunkIndex == nil || l.log.ChunkIndex >= *afterChunkIndex {
+			logs = append(logs, l.log)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].ChunkIndex != logs[j].ChunkIndex {
+			return logs[i].ChunkIndex < logs[j].ChunkIndex
+		}
+		return logs[i].Stream < logs[j].Stream
+	})
+	return logs, nil
+}
+
+// UpdateAgentMetadata updates or inserts agent metadata
+func (s *Store) UpdateAgentMetadata(ctx context.Context, nodeID string, metadata *domains.AgentMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := *metadata
+	m.NodeID, m.LastUpdated = nodeID, now()
+	if existing, ok := s.metadata[nodeID]; ok {
+		m.ID = existing.ID
+	} else {
+		m.ID = s.id()
+	}
+	s.metadata[nodeID] = &m
+	return nil
+}
+
+// CleanupOldLogs deletes logs older than retention days
+func (s *Store) CleanupOldLogs(ctx context.Context, retentionDays int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	for commandID, logs := range s.logs {
+		kept := logs[:0]
+		for _, l := range logs {
+			if !l.createdAt.Before(cutoff) {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.logs, commandID)
+		} else {
+			s.logs[commandID] = kept
+		}
+	}
+	return nil
+}
+
+// ListNodes retrieves the nodes of a tenant, or of every tenant if tenantID is empty, most recently seen first
+func (s *Store) ListNodes(ctx context.Context, tenantID string) ([]domains.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nodes []domains.Node
+	for _, row := range s.nodes {
+		if tenantID != "" && row.tenantID != tenantID {
+			continue
+		}
+		n, err := row.node()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, *n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if !nodes[i].LastSeenAt.Equal(nodes[j].LastSeenAt) {
+			return nodes[i].LastSeenAt.After(nodes[j].LastSeenAt)
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes, nil
+}
+
+// DeleteQueuedCommands deletes queued commands and their associated log chunks, optionally only those of a
+// node; a non-empty tenantID limits the deletion to the nodes of that tenant
+// As in Postgres, the status history, idempotency keys and approval requests of the deleted commands go with them.
+func (s *Store) DeleteQueuedCommands(ctx context.Context, tenantID string, nodeID *string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[uuid.UUID]bool)
+	kept := s.commands[:0]
+	for _, row := range s.commands {
+		if row.cmd.Status == domains.StatusQueued && (nodeID == nil || row.cmd.NodeID == *nodeID) &&
+			(tenantID == "" || s.inTenant(row.cmd.NodeID, tenantID)) {
+			deleted[row.cmd.CommandID] = true
+			delete(s.commandsByID, row.cmd.CommandID)
+			delete(s.logs, row.cmd.CommandID)
+			delete(s.approvals, row.cmd.CommandID)
+			continue
+		}
+		kept = append(kept, row)
+	}
+	s.commands = kept
+
+	history := s.history[:0]
+	for _, change := range s.history {
+		if commandID, err := uuid.Parse(change.CommandID); err != nil || !deleted[commandID] {
+			history = append(history, change)
+		}
+	}
+	s.history = history
+
+	for keyID, k := range s.idempotencyKeys {
+		if deleted[k.CommandID] {
+			delete(s.idempotencyKeys, keyID)
+		}
+	}
+	return len(deleted), nil
+}
+
+// CountCommandsByStatus counts commands per command type and status
+func (s *Store) CountCommandsByStatus(ctx context.Context) ([]domains.CommandCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type countKey struct{ commandType, status string }
+	counts := make(map[countKey]int64)
+	var order []countKey
+	for _, row := range s.commands {
+		key := countKey{row.cmd.CommandType, row.cmd.Status}
+		if _, ok := counts[key]; !ok {
+			order = append(order, key)
+		}
+		counts[key]++
+	}
+
+	var result []domains.CommandCount
+	for _, key := range order {
+		result = append(result, domains.CommandCount{CommandType: key.commandType, Status: key.status, Count: counts[key]})
+	}
+	return result, nil
+}
+
+// ListCommands retrieves commands newest first, optionally filtered by nodeID; a non-empty tenantID limits
+// them to the nodes of that tenant
+func (s *Store) ListCommands(ctx context.Context, tenantID string, nodeID *string, limit int) ([]domains.NodeCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched, err := s.selectCommands(func(cmd *domains.NodeCommand) bool {
+		return (nodeID == nil || cmd.NodeID == *nodeID) && (tenantID == "" || s.inTenant(cmd.NodeID, tenantID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	var commands []domains.NodeCommand
+	for _, cmd := range matched {
+		commands = append(commands, *cmd)
+	}
+	return commands, nil
+}
+
+// commandOutcome summarizes the commands matching match, using the latest attempt of each command
+func (s *Store) commandOutcome(match func(*domains.NodeCommand) bool) domains.CommandOutcome {
+	latest := make(map[uuid.UUID]*domains.NodeCommand)
+	for _, row := range s.commands {
+		if !match(&row.cmd) {
+			continue
+		}
+		firstID := row.cmd.FirstAttemptID()
+		if prev, ok := latest[firstID]; !ok || row.cmd.Attempt > prev.Attempt {
+			latest[firstID] = &row.cmd
+		}
+	}
+
+	var outcome domains.CommandOutcome
+	for _, cmd := range latest {
+		outcome.Commands++
+		if domains.IsTerminalStatus(cmd.Status) && cmd.NextRetryAt == nil {
+			outcome.Finished++
+		}
+		if cmd.Status == domains.StatusSuccess {
+			outcome.Succeeded++
+		}
+	}
+	return outcome
+}
+
+// copyString returns a pointer to a copy of *p, or nil
+func copyString(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// copyInt returns a pointer to a copy of *p, or nil
+func copyInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
@@ -0,0 +1,12 @@
+package memory_test
+
+import (
+	"testing"
+
+	"agent-svc/storage/conformance"
+	"agent-svc/storage/memory"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, memory.NewStore())
+}
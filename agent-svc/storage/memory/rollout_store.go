@@ -0,0 +1,253 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// rolloutRow is a row of the rollouts table; JSON columns are kept encoded
+type rolloutRow struct {
+	r        domains.Rollout
+	payload  []byte
+	strategy []byte
+}
+
+// rollout returns a copy of the rollout with its JSON columns decoded
+func (row *rolloutRow) rollout() (*domains.Rollout, error) {
+	r := row.r
+	if err := decodeJSON(row.payload, &r.Payload, "payload"); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(row.strategy, &r.Strategy, "rollout strategy"); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateRollout inserts a rollout with its planned batches and fills in its generated ID
+func (s *Store) CreateRollout(ctx context.Context, r *domains.Rollout, batches []domains.RolloutBatch) error {
+	payloadJSON, err := encodeJSON(r.Payload, "payload")
+	if err != nil {
+		return err
+	}
+	strategyJSON, err := encodeJSON(r.Strategy, "rollout strategy")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := now()
+	r.ID, r.RolloutID, r.CreatedAt, r.UpdatedAt = s.id(), uuid.New(), created, created
+
+	row := &rolloutRow{r: *r, payload: payloadJSON, strategy: strategyJSON}
+	row.r.Payload, row.r.Strategy = nil, domains.RolloutStrategy{}
+	row.r.NextBatchAt, row.r.ErrorMsg, row.r.FinishedAt = nil, nil, nil
+	s.rollouts = append(s.rollouts, row)
+
+	for _, batch := range batches {
+		s.rolloutBatches = append(s.rolloutBatches, &domains.RolloutBatch{
+			ID:         s.id(),
+			RolloutID:  r.RolloutID,
+			BatchIndex: batch.BatchIndex,
+			Canary:     batch.Canary,
+			NodeIDs:    append([]string{}, batch.NodeIDs...),
+			CommandIDs: []uuid.UUID{},
+			Status:     batch.Status,
+		})
+	}
+	return nil
+}
+
+// findRollout returns the rollout row with the given ID, or nil
+func (s *Store) findRollout(rolloutID uuid.UUID) *rolloutRow {
+	for _, row := range s.rollouts {
+		if row.r.RolloutID == rolloutID {
+			return row
+		}
+	}
+	return nil
+}
+
+// GetRollout retrieves a rollout by ID, or nil if it doesn't exist
+func (s *Store) GetRollout(ctx context.Context, rolloutID uuid.UUID) (*domains.Rollout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findRollout(rolloutID)
+	if row == nil {
+		return nil, nil
+	}
+	return row.rollout()
+}
+
+// ListRollouts retrieves the most recent rollouts of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListRollouts(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Rollout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rollouts []*domains.Rollout
+	for i := len(s.rollouts) - 1; i >= 0 && len(rollouts) < limit; i-- {
+		row := s.rollouts[i]
+		if tenantID != "" && row.r.TenantID != tenantID || status != nil && row.r.Status != *status {
+			continue
+		}
+		r, err := row.rollout()
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, r)
+	}
+	return rollouts, nil
+}
+
+// TransitionRollout moves a rollout to status to if it is currently in one of the from statuses
+// errorMsg, if not nil, replaces the recorded reason. It reports whether the transition was made.
+func (s *Store) TransitionRollout(ctx context.Context, rolloutID uuid.UUID, from []string, to string, errorMsg *string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findRollout(rolloutID)
+	if row == nil || !containsString(from, row.r.Status) {
+		return false, nil
+	}
+
+	changed := now()
+	row.r.Status, row.r.UpdatedAt = to, changed
+	if errorMsg != nil {
+		row.r.ErrorMsg = copyString(errorMsg)
+	}
+	if to == domains.RolloutAborted || to == domains.RolloutCompleted {
+		finishedAt := changed
+		row.r.FinishedAt = &finishedAt
+	}
+	return true, nil
+}
+
+// SetRolloutNextBatchAt sets the earliest time the next batch of a rollout may start
+func (s *Store) SetRolloutNextBatchAt(ctx context.Context, rolloutID uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row := s.findRollout(rolloutID); row != nil {
+		row.r.NextBatchAt, row.r.UpdatedAt = timestampPtr(&at), now()
+	}
+	return nil
+}
+
+// ListRolloutBatches retrieves the batches of a rollout in order
+func (s *Store) ListRolloutBatches(ctx context.Context, rolloutID uuid.UUID) ([]domains.RolloutBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batches []domains.RolloutBatch
+	for _, batch := range s.rolloutBatches {
+		if batch.RolloutID == rolloutID {
+			copied := *batch
+			copied.NodeIDs = append([]string{}, batch.NodeIDs...)
+			copied.CommandIDs = append([]uuid.UUID{}, batch.CommandIDs...)
+			copied.ErrorMsg = copyString(batch.ErrorMsg)
+			batches = append(batches, copied)
+		}
+	}
+	sort.SliceStable(batches, func(i, j int) bool { return batches[i].BatchIndex < batches[j].BatchIndex })
+	return batches, nil
+}
+
+// findRolloutBatch returns a batch of a rollout, or nil
+func (s *Store) findRolloutBatch(rolloutID uuid.UUID, batchIndex int) *domains.RolloutBatch {
+	for _, batch := range s.rolloutBatches {
+		if batch.RolloutID == rolloutID && batch.BatchIndex == batchIndex {
+			return batch
+		}
+	}
+	return nil
+}
+
+// StartRolloutBatch moves a pending batch to running
+// It only succeeds while the rollout is running and its next batch is due, and only for one caller.
+// It reports whether the batch was started.
+func (s *Store) StartRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	row := s.findRollout(rolloutID)
+	if row == nil || row.r.Status != domains.RolloutRunning || (row.r.NextBatchAt != nil && row.r.NextBatchAt.After(current)) {
+		return false, nil
+	}
+	batch := s.findRolloutBatch(rolloutID, batchIndex)
+	if batch == nil || batch.Status != domains.BatchPending {
+		return false, nil
+	}
+	batch.Status, batch.StartedAt = domains.BatchRunning, &current
+	return true, nil
+}
+
+// SetRolloutBatchCommands records the commands submitted for a batch, and why some nodes got none
+func (s *Store) SetRolloutBatchCommands(ctx context.Context, rolloutID uuid.UUID, batchIndex int, commandIDs []uuid.UUID, errorMsg *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if batch := s.findRolloutBatch(rolloutID, batchIndex); batch != nil {
+		batch.CommandIDs, batch.ErrorMsg = append([]uuid.UUID{}, commandIDs...), copyString(errorMsg)
+	}
+	return nil
+}
+
+// FinishRolloutBatch moves a running batch to its final status, reporting whether it was still running
+func (s *Store) FinishRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int, status string, succeededCount int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := s.findRolloutBatch(rolloutID, batchIndex)
+	if batch == nil || batch.Status != domains.BatchRunning {
+		return false, nil
+	}
+	finishedAt := now()
+	batch.Status, batch.SucceededCount, batch.FinishedAt = status, succeededCount, &finishedAt
+	return true, nil
+}
+
+// GetRolloutBatchOutcome summarizes the commands of a batch, using the latest attempt of each command
+func (s *Store) GetRolloutBatchOutcome(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (domains.CommandOutcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commandOutcome(func(cmd *domains.NodeCommand) bool {
+		return cmd.RolloutID != nil && *cmd.RolloutID == rolloutID &&
+			cmd.RolloutBatch != nil && *cmd.RolloutBatch == batchIndex
+	}), nil
+}
+
+// CancelRolloutCommands cancels the queued commands of a rollout and drops its scheduled retries
+func (s *Store) CancelRolloutCommands(ctx context.Context, rolloutID uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inRollout := func(cmd *domains.NodeCommand) bool {
+		return cmd.RolloutID != nil && *cmd.RolloutID == rolloutID
+	}
+	for _, row := range s.commands {
+		if inRollout(&row.cmd) {
+			row.cmd.NextRetryAt = nil
+		}
+	}
+	return s.finishCommands(domains.StatusQueued, domains.StatusCancelled, "rollout aborted", domains.SourceOperator, now(), inRollout)
+}
+
+// containsString reports whether values contains v
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
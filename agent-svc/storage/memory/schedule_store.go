@@ -0,0 +1,231 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// scheduleRow is a row of the schedules table; JSON columns are kept encoded
+type scheduleRow struct {
+	sched    domains.Schedule
+	selector []byte
+	payload  []byte
+}
+
+// schedule returns a copy of the schedule with its JSON columns decoded
+func (r *scheduleRow) schedule() (*domains.Schedule, error) {
+	sched := r.sched
+	if r.selector != nil {
+		if err := decodeJSON(r.selector, &sched.Selector, "selector"); err != nil {
+			return nil, err
+		}
+	}
+	if err := decodeJSON(r.payload, &sched.Payload, "payload"); err != nil {
+		return nil, err
+	}
+	return &sched, nil
+}
+
+// CreateSchedule inserts a schedule and fills in its generated ID and timestamps
+func (s *Store) CreateSchedule(ctx context.Context, sched *domains.Schedule) error {
+	payloadJSON, err := encodeJSON(sched.Payload, "payload")
+	if err != nil {
+		return err
+	}
+	var selectorJSON []byte
+	if sched.Selector != nil {
+		if selectorJSON, err = encodeJSON(sched.Selector, "selector"); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sched.NodeID != nil {
+		if _, ok := s.nodes[*sched.NodeID]; !ok {
+			return fmt.Errorf("node %s is not registered", *sched.NodeID)
+		}
+	}
+
+	created := now()
+	sched.ID, sched.ScheduleID, sched.CreatedAt, sched.UpdatedAt = s.id(), uuid.New(), created, created
+
+	row := &scheduleRow{sched: *sched, selector: selectorJSON, payload: payloadJSON}
+	row.sched.NodeID = copyString(sched.NodeID)
+	row.sched.NextRunAt = timestampPtr(sched.NextRunAt)
+	row.sched.LastRunAt = nil
+	s.schedules = append(s.schedules, row)
+	return nil
+}
+
+// findSchedule returns the schedule row with the given ID, or nil
+func (s *Store) findSchedule(scheduleID uuid.UUID) *scheduleRow {
+	for _, row := range s.schedules {
+		if row.sched.ScheduleID == scheduleID {
+			return row
+		}
+	}
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID, or nil if it doesn't exist
+func (s *Store) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*domains.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findSchedule(scheduleID)
+	if row == nil {
+		return nil, nil
+	}
+	return row.schedule()
+}
+
+// ListSchedules retrieves the schedules of a tenant, or of every tenant, ordered by name
+func (s *Store) ListSchedules(ctx context.Context, tenantID string) ([]*domains.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.selectSchedules(func(sched *domains.Schedule) bool {
+		return tenantID == "" || sched.TenantID == tenantID
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(schedules, func(i, j int) bool {
+		if schedules[i].Name != schedules[j].Name {
+			return schedules[i].Name < schedules[j].Name
+		}
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	return schedules, nil
+}
+
+// ListDueSchedules retrieves up to limit unpaused schedules whose next run is at or before now
+func (s *Store) ListDueSchedules(ctx context.Context, now time.Time, limit int) ([]*domains.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.selectSchedules(func(sched *domains.Schedule) bool {
+		return !sched.Paused && sched.NextRunAt != nil && !sched.NextRunAt.After(now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(schedules, func(i, j int) bool { return schedules[i].NextRunAt.Before(*schedules[j].NextRunAt) })
+	if len(schedules) > limit {
+		schedules = schedules[:limit]
+	}
+	return schedules, nil
+}
+
+// selectSchedules returns copies of the schedules matching match in insertion order
+func (s *Store) selectSchedules(match func(*domains.Schedule) bool) ([]*domains.Schedule, error) {
+	var schedules []*domains.Schedule
+	for _, row := range s.schedules {
+		if !match(&row.sched) {
+			continue
+		}
+		sched, err := row.schedule()
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves a schedule's next run from expected to next
+// It only succeeds if the next run still equals expected and the schedule isn't paused, so a fire time is
+// claimed once. It reports whether the claim succeeded.
+func (s *Store) AdvanceSchedule(ctx context.Context, scheduleID uuid.UUID, expected, next time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findSchedule(scheduleID)
+	if row == nil || row.sched.Paused || row.sched.NextRunAt == nil || !row.sched.NextRunAt.Equal(expected) {
+		return false, nil
+	}
+	updated := now()
+	row.sched.NextRunAt, row.sched.LastRunAt, row.sched.UpdatedAt = timestampPtr(&next), &updated, updated
+	return true, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule; nextRunAt is the next fire time after resuming, nil when pausing
+// It reports whether the schedule exists.
+func (s *Store) SetSchedulePaused(ctx context.Context, scheduleID uuid.UUID, paused bool, nextRunAt *time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findSchedule(scheduleID)
+	if row == nil {
+		return false, nil
+	}
+	row.sched.Paused, row.sched.NextRunAt, row.sched.UpdatedAt = paused, timestampPtr(nextRunAt), now()
+	return true, nil
+}
+
+// DeleteSchedule deletes a schedule and its run history, reporting whether it existed
+func (s *Store) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findSchedule(scheduleID) == nil {
+		return false, nil
+	}
+
+	schedules := s.schedules[:0]
+	for _, row := range s.schedules {
+		if row.sched.ScheduleID != scheduleID {
+			schedules = append(schedules, row)
+		}
+	}
+	s.schedules = schedules
+
+	runs := s.scheduleRuns[:0]
+	for _, run := range s.scheduleRuns {
+		if run.ScheduleID != scheduleID {
+			runs = append(runs, run)
+		}
+	}
+	s.scheduleRuns = runs
+	return true, nil
+}
+
+// InsertScheduleRun records a firing of a schedule
+func (s *Store) InsertScheduleRun(ctx context.Context, run *domains.ScheduleRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.ID, run.CreatedAt = s.id(), now()
+
+	stored := *run
+	stored.ScheduledFor = timestamp(run.ScheduledFor)
+	stored.CommandIDs = append([]uuid.UUID{}, run.CommandIDs...)
+	stored.ErrorMsg = copyString(run.ErrorMsg)
+	s.scheduleRuns = append(s.scheduleRuns, stored)
+	return nil
+}
+
+// ListScheduleRuns retrieves the most recent runs of a schedule, newest first
+func (s *Store) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]domains.ScheduleRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var runs []domains.ScheduleRun
+	for i := len(s.scheduleRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		run := s.scheduleRuns[i]
+		if run.ScheduleID == scheduleID {
+			run.CommandIDs = append([]uuid.UUID{}, run.CommandIDs...)
+			run.ErrorMsg = copyString(run.ErrorMsg)
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
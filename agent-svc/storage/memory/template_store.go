@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"agent-svc/app/domains"
+)
+
+// templateRow is a row of the command_templates table; JSON columns are kept encoded
+type templateRow struct {
+	t       domains.CommandTemplate
+	payload []byte
+	params  []byte
+}
+
+// template returns a copy of the template with its JSON columns decoded
+func (row *templateRow) template() (*domains.CommandTemplate, error) {
+	t := row.t
+	if err := decodeJSON(row.payload, &t.Payload, "payload"); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(row.params, &t.Params, "template params"); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateCommandTemplate stores a template as the next version of its name in its tenant and fills in the version
+func (s *Store) CreateCommandTemplate(ctx context.Context, t *domains.CommandTemplate) error {
+	payloadJSON, err := encodeJSON(t.Payload, "payload")
+	if err != nil {
+		return err
+	}
+	params := t.Params
+	if params == nil {
+		params = []domains.TemplateParam{}
+	}
+	paramsJSON, err := encodeJSON(params, "template params")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := 0
+	for _, row := range s.templates {
+		if row.t.TenantID == t.TenantID && row.t.Name == t.Name && row.t.Version > version {
+			version = row.t.Version
+		}
+	}
+	t.ID, t.Version, t.CreatedAt = s.id(), version+1, now()
+
+	row := &templateRow{t: *t, payload: payloadJSON, params: paramsJSON}
+	row.t.Payload, row.t.Params = nil, nil
+	s.templates = append(s.templates, row)
+	return nil
+}
+
+// GetCommandTemplate retrieves a version of a tenant's template, or its latest version if version is nil
+// It returns nil if no such template exists.
+func (s *Store) GetCommandTemplate(ctx context.Context, tenantID, name string, version *int) (*domains.CommandTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *templateRow
+	for _, row := range s.templates {
+		if row.t.TenantID != tenantID || row.t.Name != name || (version != nil && row.t.Version != *version) {
+			continue
+		}
+		if found == nil || row.t.Version > found.t.Version {
+			found = row
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return found.template()
+}
+
+// ListCommandTemplates retrieves the latest version of every template of a tenant, or of every tenant, ordered by name
+func (s *Store) ListCommandTemplates(ctx context.Context, tenantID string) ([]*domains.CommandTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type templateKey struct{ tenantID, name string }
+	latest := make(map[templateKey]*templateRow)
+	for _, row := range s.templates {
+		if tenantID != "" && row.t.TenantID != tenantID {
+			continue
+		}
+		key := templateKey{row.t.TenantID, row.t.Name}
+		if prev, ok := latest[key]; !ok || row.t.Version > prev.t.Version {
+			latest[key] = row
+		}
+	}
+
+	var templates []*domains.CommandTemplate
+	for _, row := range latest {
+		t, err := row.template()
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].TenantID < templates[j].TenantID
+	})
+	return templates, nil
+}
+
+// ListCommandTemplateVersions retrieves all versions of a tenant's template, newest first
+func (s *Store) ListCommandTemplateVersions(ctx context.Context, tenantID, name string) ([]*domains.CommandTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var templates []*domains.CommandTemplate
+	for _, row := range s.templates {
+		if row.t.TenantID != tenantID || row.t.Name != name {
+			continue
+		}
+		t, err := row.template()
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Version > templates[j].Version })
+	return templates, nil
+}
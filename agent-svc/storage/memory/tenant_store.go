@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"agent-svc/app/domains"
+)
+
+// tenantOperatorID is the primary key of the tenant_operators table
+type tenantOperatorID struct {
+	tenantID, operatorID string
+}
+
+// inTenant reports whether a node belongs to a tenant
+func (s *Store) inTenant(nodeID, tenantID string) bool {
+	n, ok := s.nodes[nodeID]
+	return ok && n.tenantID == tenantID
+}
+
+// countTenantNodes counts the nodes enrolled in a tenant
+func (s *Store) countTenantNodes(tenantID string) int {
+	count := 0
+	for _, n := range s.nodes {
+		if n.tenantID == tenantID {
+			count++
+		}
+	}
+	return count
+}
+
+// countTenantCommandsLastDay counts the first attempts submitted to a tenant's nodes in the last 24 hours
+func (s *Store) countTenantCommandsLastDay(tenantID string) int {
+	since := time.Now().Add(-24 * time.Hour)
+	count := 0
+	for _, row := range s.commands {
+		if row.cmd.Attempt == 1 && row.cmd.CreatedAt.After(since) && s.inTenant(row.cmd.NodeID, tenantID) {
+			count++
+		}
+	}
+	return count
+}
+
+// CreateTenant inserts a tenant and fills in its generated ID and timestamps
+// It returns domains.ErrTenantExists if the tenant ID is taken.
+func (s *Store) CreateTenant(ctx context.Context, t *domains.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[t.TenantID]; ok {
+		return domains.ErrTenantExists
+	}
+	created := now()
+	t.ID, t.CreatedAt, t.UpdatedAt = s.id(), created, created
+	stored := *t
+	s.tenants[t.TenantID] = &stored
+	return nil
+}
+
+// GetTenant retrieves a tenant by ID, or nil if it doesn't exist
+func (s *Store) GetTenant(ctx context.Context, tenantID string) (*domains.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tenants[tenantID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *t
+	return &copied, nil
+}
+
+// ListTenants retrieves all tenants ordered by ID
+func (s *Store) ListTenants(ctx context.Context) ([]*domains.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tenants []*domains.Tenant
+	for _, t := range s.tenants {
+		copied := *t
+		tenants = append(tenants, &copied)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].TenantID < tenants[j].TenantID })
+	return tenants, nil
+}
+
+// UpdateTenant stores the name, enrollment key and quotas of a tenant, reporting whether it exists
+func (s *Store) UpdateTenant(ctx context.Context, t *domains.Tenant) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.tenants[t.TenantID]
+	if !ok {
+		return false, nil
+	}
+	stored.Name, stored.EnrollmentKeyHash = t.Name, t.EnrollmentKeyHash
+	stored.MaxNodes, stored.MaxCommandsPerDay = t.MaxNodes, t.MaxCommandsPerDay
+	stored.UpdatedAt = now()
+	t.UpdatedAt = stored.UpdatedAt
+	return true, nil
+}
+
+// GetTenantUsage counts the nodes of a tenant and the commands submitted to them in the last 24 hours
+func (s *Store) GetTenantUsage(ctx context.Context, tenantID string) (domains.TenantUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return domains.TenantUsage{
+		Nodes:          s.countTenantNodes(tenantID),
+		CommandsPerDay: s.countTenantCommandsLastDay(tenantID),
+	}, nil
+}
+
+// AddTenantOperator makes an operator a member of a tenant; adding an existing member is a no-op
+// As with the Postgres foreign key, the tenant must exist.
+func (s *Store) AddTenantOperator(ctx context.Context, tenantID, operatorID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[tenantID]; !ok {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	key := tenantOperatorID{tenantID: tenantID, operatorID: operatorID}
+	if _, ok := s.tenantOperators[key]; !ok {
+		s.tenantOperators[key] = now()
+	}
+	return nil
+}
+
+// RemoveTenantOperator removes an operator from a tenant, reporting whether it was a member
+func (s *Store) RemoveTenantOperator(ctx context.Context, tenantID, operatorID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := tenantOperatorID{tenantID: tenantID, operatorID: operatorID}
+	if _, ok := s.tenantOperators[key]; !ok {
+		return false, nil
+	}
+	delete(s.tenantOperators, key)
+	return true, nil
+}
+
+// ListTenantOperators retrieves the operators of a tenant ordered by ID
+func (s *Store) ListTenantOperators(ctx context.Context, tenantID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var operators []string
+	for key := range s.tenantOperators {
+		if key.tenantID == tenantID {
+			operators = append(operators, key.operatorID)
+		}
+	}
+	sort.Strings(operators)
+	return operators, nil
+}
+
+// ListOperatorTenants retrieves the IDs of the tenants an operator belongs to, ordered by ID
+func (s *Store) ListOperatorTenants(ctx context.Context, operatorID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tenants []string
+	for key := range s.tenantOperators {
+		if key.operatorID == operatorID {
+			tenants = append(tenants, key.tenantID)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
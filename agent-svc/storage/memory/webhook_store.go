@@ -0,0 +1,420 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// eventRow is a row of the webhook_events outbox; the payload is kept encoded
+type eventRow struct {
+	ev          domains.WebhookEvent
+	payload     []byte
+	fannedOutAt *time.Time
+}
+
+// event returns a copy of the event with its payload decoded
+func (r *eventRow) event() (domains.WebhookEvent, error) {
+	ev := r.ev
+	if err := decodeJSON(r.payload, &ev.Payload, "event payload"); err != nil {
+		return domains.WebhookEvent{}, err
+	}
+	return ev, nil
+}
+
+// insertWebhookEvent adds an event to the webhook outbox; callers hold the lock while making the change
+// the event describes, so it is published together with the change. The event belongs to the tenant of
+// the node named by its node_id.
+func (s *Store) insertWebhookEvent(eventType string, payload map[string]interface{}) error {
+	payloadJSON, err := encodeJSON(payload, "event payload")
+	if err != nil {
+		return err
+	}
+	var tenantID string
+	if nodeID, ok := payload["node_id"].(string); ok {
+		if n, ok := s.nodes[nodeID]; ok {
+			tenantID = n.tenantID
+		}
+	}
+	s.webhookEvents = append(s.webhookEvents, &eventRow{
+		ev:      domains.WebhookEvent{ID: s.id(), EventID: uuid.New(), TenantID: tenantID, EventType: eventType, CreatedAt: now()},
+		payload: payloadJSON,
+	})
+	return nil
+}
+
+// commandEvent is the part of a command published when it reaches a terminal status
+type commandEvent struct {
+	CommandID   uuid.UUID
+	NodeID      string
+	CommandType string
+	Status      string
+	Attempt     int
+	ExitCode    *int
+	ErrorMsg    *string
+	FinishedAt  time.Time
+}
+
+// insertCommandEvent adds the command.<status> event for a command that reached a terminal status
+func (s *Store) insertCommandEvent(ev commandEvent) error {
+	return s.insertWebhookEvent(domains.CommandEventType(ev.Status), map[string]interface{}{
+		"command_id":   ev.CommandID.String(),
+		"node_id":      ev.NodeID,
+		"command_type": ev.CommandType,
+		"status":       ev.Status,
+		"attempt":      ev.Attempt,
+		"exit_code":    ev.ExitCode,
+		"error_msg":    ev.ErrorMsg,
+		"finished_at":  ev.FinishedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// copyWebhook returns a copy of a subscription that shares no state with the store
+func copyWebhook(w *domains.WebhookSubscription) *domains.WebhookSubscription {
+	copied := *w
+	copied.EventTypes = append([]string{}, w.EventTypes...)
+	return &copied
+}
+
+// CreateWebhook inserts a webhook subscription and fills in its generated ID
+func (s *Store) CreateWebhook(ctx context.Context, w *domains.WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := now()
+	w.ID, w.WebhookID, w.CreatedAt, w.UpdatedAt = s.id(), uuid.New(), created, created
+	s.webhooks = append(s.webhooks, copyWebhook(w))
+	return nil
+}
+
+// findWebhook returns the subscription with the given ID, or nil
+func (s *Store) findWebhook(webhookID uuid.UUID) *domains.WebhookSubscription {
+	for _, w := range s.webhooks {
+		if w.WebhookID == webhookID {
+			return w
+		}
+	}
+	return nil
+}
+
+// GetWebhook retrieves a webhook subscription by ID, or nil if it doesn't exist
+func (s *Store) GetWebhook(ctx context.Context, webhookID uuid.UUID) (*domains.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.findWebhook(webhookID)
+	if w == nil {
+		return nil, nil
+	}
+	return copyWebhook(w), nil
+}
+
+// ListWebhooks retrieves the webhook subscriptions of a tenant, or of every tenant, oldest first
+func (s *Store) ListWebhooks(ctx context.Context, tenantID string) ([]*domains.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var webhooks []*domains.WebhookSubscription
+	for _, w := range s.webhooks {
+		if tenantID != "" && w.TenantID != tenantID {
+			continue
+		}
+		webhooks = append(webhooks, copyWebhook(w))
+	}
+	return webhooks, nil
+}
+
+// SetWebhookEnabled enables or disables a webhook subscription, reporting whether it exists
+// Events are not fanned out to a disabled subscription and its pending deliveries wait until it is enabled.
+func (s *Store) SetWebhookEnabled(ctx context.Context, webhookID uuid.UUID, enabled bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.findWebhook(webhookID)
+	if w == nil {
+		return false, nil
+	}
+	w.Enabled, w.UpdatedAt = enabled, now()
+	return true, nil
+}
+
+// DeleteWebhook deletes a webhook subscription and its delivery log, reporting whether it existed
+func (s *Store) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWebhook(webhookID) == nil {
+		return false, nil
+	}
+
+	webhooks := s.webhooks[:0]
+	for _, w := range s.webhooks {
+		if w.WebhookID != webhookID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	s.webhooks = webhooks
+
+	s.deleteDeliveries(func(d *domains.WebhookDelivery) bool { return d.WebhookID == webhookID })
+	return true, nil
+}
+
+// deleteDeliveries removes the deliveries matching match
+func (s *Store) deleteDeliveries(match func(*domains.WebhookDelivery) bool) {
+	deliveries := s.webhookDeliveries[:0]
+	for _, d := range s.webhookDeliveries {
+		if !match(d) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.webhookDeliveries = deliveries
+}
+
+// FanOutWebhookEvents creates a pending delivery of each new outbox event for every enabled subscription
+// of the event's tenant whose filters match it, handling at most limit events. It returns the number of deliveries created.
+func (s *Store) FanOutWebhookEvents(ctx context.Context, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	created, handled := 0, 0
+	for _, e := range s.webhookEvents {
+		if handled >= limit {
+			break
+		}
+		if e.fannedOutAt != nil {
+			continue
+		}
+		handled++
+
+		for _, w := range s.webhooks {
+			if !w.Enabled || w.TenantID != e.ev.TenantID || !domains.MatchesEventType(w.EventTypes, e.ev.EventType) || s.hasDelivery(w.WebhookID, e.ev.EventID) {
+				continue
+			}
+			nextAttemptAt := current
+			s.webhookDeliveries = append(s.webhookDeliveries, &domains.WebhookDelivery{
+				ID:            s.id(),
+				DeliveryID:    uuid.New(),
+				WebhookID:     w.WebhookID,
+				EventID:       e.ev.EventID,
+				EventType:     e.ev.EventType,
+				Status:        domains.DeliveryPending,
+				NextAttemptAt: &nextAttemptAt,
+				CreatedAt:     current,
+				UpdatedAt:     current,
+			})
+			created++
+		}
+		fannedOutAt := current
+		e.fannedOutAt = &fannedOutAt
+	}
+	return created, nil
+}
+
+// hasDelivery reports whether an event already has a delivery to a subscription
+func (s *Store) hasDelivery(webhookID, eventID uuid.UUID) bool {
+	for _, d := range s.webhookDeliveries {
+		if d.WebhookID == webhookID && d.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// findEvent returns the outbox event with the given ID, or nil
+func (s *Store) findEvent(eventID uuid.UUID) *eventRow {
+	for _, e := range s.webhookEvents {
+		if e.ev.EventID == eventID {
+			return e
+		}
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries that are due, pushing their next attempt
+// back by lease so they aren't sent again meanwhile. A delivery whose sender dies before recording the
+// attempt becomes due again once the lease runs out.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domains.WebhookDeliveryJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	var due []*domains.WebhookDelivery
+	for _, d := range s.webhookDeliveries {
+		w := s.findWebhook(d.WebhookID)
+		if d.Status == domains.DeliveryPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(current) && w != nil && w.Enabled {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	leaseEnd := timestamp(current.Add(lease))
+	var jobs []domains.WebhookDeliveryJob
+	for _, d := range due {
+		w := s.findWebhook(d.WebhookID)
+		e := s.findEvent(d.EventID)
+		if e == nil {
+			continue
+		}
+		ev, err := e.event()
+		if err != nil {
+			return nil, err
+		}
+
+		nextAttemptAt := leaseEnd
+		d.NextAttemptAt, d.UpdatedAt = &nextAttemptAt, current
+		jobs = append(jobs, domains.WebhookDeliveryJob{
+			DeliveryID: d.DeliveryID,
+			Attempt:    d.Attempts + 1,
+			URL:        w.URL,
+			Secret:     w.Secret,
+			Event:      ev,
+		})
+	}
+	return jobs, nil
+}
+
+// findDelivery returns the delivery with the given ID, or nil
+func (s *Store) findDelivery(deliveryID uuid.UUID) *domains.WebhookDelivery {
+	for _, d := range s.webhookDeliveries {
+		if d.DeliveryID == deliveryID {
+			return d
+		}
+	}
+	return nil
+}
+
+// copyDelivery returns a copy of a delivery that shares no state with the store
+func copyDelivery(d *domains.WebhookDelivery) *domains.WebhookDelivery {
+	copied := *d
+	copied.LastResponseCode = copyInt(d.LastResponseCode)
+	copied.LastError = copyString(d.LastError)
+	return &copied
+}
+
+// RecordWebhookDeliveryAttempt records the outcome of a delivery attempt
+// status is delivered, dead, or pending with nextAttemptAt set for a retry.
+func (s *Store) RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID uuid.UUID, attempt int, status string, responseCode *int, errorMsg *string, nextAttemptAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.findDelivery(deliveryID)
+	if d == nil {
+		return nil
+	}
+	updated := now()
+	d.Attempts, d.Status, d.LastResponseCode, d.LastError = attempt, status, copyInt(responseCode), copyString(errorMsg)
+	d.NextAttemptAt, d.UpdatedAt = timestampPtr(nextAttemptAt), updated
+	if status == domains.DeliveryDelivered {
+		d.DeliveredAt = &updated
+	}
+	return nil
+}
+
+// GetWebhookDelivery retrieves a delivery by ID, or nil if it doesn't exist
+func (s *Store) GetWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (*domains.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.findDelivery(deliveryID)
+	if d == nil {
+		return nil, nil
+	}
+	return copyDelivery(d), nil
+}
+
+// ListWebhookDeliveries retrieves the most recent deliveries of a subscription, optionally filtered by status
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status *string, limit int) ([]*domains.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []*domains.WebhookDelivery
+	for i := len(s.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := s.webhookDeliveries[i]
+		if d.WebhookID == webhookID && (status == nil || d.Status == *status) {
+			deliveries = append(deliveries, copyDelivery(d))
+		}
+	}
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery makes a dead delivery due again, reporting whether it was dead
+func (s *Store) RetryWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.findDelivery(deliveryID)
+	if d == nil || d.Status != domains.DeliveryDead {
+		return false, nil
+	}
+	updated := now()
+	nextAttemptAt := updated
+	d.Status, d.NextAttemptAt, d.UpdatedAt = domains.DeliveryPending, &nextAttemptAt, updated
+	return true, nil
+}
+
+// DeleteOldWebhookEvents deletes fanned-out events older than retention days along with their deliveries,
+// keeping events that still have a pending delivery
+func (s *Store) DeleteOldWebhookEvents(ctx context.Context, retentionDays int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	deleted := make(map[uuid.UUID]bool)
+	events := s.webhookEvents[:0]
+	for _, e := range s.webhookEvents {
+		if e.fannedOutAt != nil && e.ev.CreatedAt.Before(cutoff) && !s.hasPendingDelivery(e.ev.EventID) {
+			deleted[e.ev.EventID] = true
+			continue
+		}
+		events = append(events, e)
+	}
+	s.webhookEvents = events
+
+	s.deleteDeliveries(func(d *domains.WebhookDelivery) bool { return deleted[d.EventID] })
+	return nil
+}
+
+// hasPendingDelivery reports whether an event still has a pending delivery
+func (s *Store) hasPendingDelivery(eventID uuid.UUID) bool {
+	for _, d := range s.webhookDeliveries {
+		if d.EventID == eventID && d.Status == domains.DeliveryPending {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkOfflineNodes marks enabled nodes not seen for offlineAfter as offline, emitting a node.offline event
+// for each. A node is only reported once until a heartbeat brings it back.
+func (s *Store) MarkOfflineNodes(ctx context.Context, offlineAfter time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	cutoff := time.Now().Add(-offlineAfter)
+	count := 0
+	for _, n := range s.nodes {
+		if n.offlineAt != nil || n.disabled || !n.lastSeenAt.Before(cutoff) {
+			continue
+		}
+		offlineAt := current
+		n.offlineAt = &offlineAt
+		err := s.insertWebhookEvent(domains.EventNodeOffline, map[string]interface{}{
+			"node_id":      n.nodeID,
+			"last_seen_at": n.lastSeenAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
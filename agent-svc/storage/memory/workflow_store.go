@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// workflowRow is a row of the workflows table; the definition is kept encoded
+type workflowRow struct {
+	wf         domains.Workflow
+	definition []byte
+}
+
+// workflow returns a copy of the workflow run with its definition decoded
+func (r *workflowRow) workflow() (*domains.Workflow, error) {
+	wf := r.wf
+	if err := decodeJSON(r.definition, &wf.Definition, "workflow definition"); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// CreateWorkflow inserts a workflow run with all of its steps pending and fills in its generated ID
+func (s *Store) CreateWorkflow(ctx context.Context, wf *domains.Workflow) error {
+	definitionJSON, err := encodeJSON(wf.Definition, "workflow definition")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := now()
+	wf.ID, wf.WorkflowID, wf.CreatedAt, wf.UpdatedAt = s.id(), uuid.New(), created, created
+
+	row := &workflowRow{wf: *wf, definition: definitionJSON}
+	row.wf.Definition = domains.WorkflowDefinition{}
+	row.wf.FinishedAt = nil
+	s.workflows = append(s.workflows, row)
+
+	for _, step := range wf.Definition.Steps {
+		s.workflowSteps = append(s.workflowSteps, &domains.WorkflowStepState{
+			ID:         s.id(),
+			WorkflowID: wf.WorkflowID,
+			StepID:     step.ID,
+			Status:     domains.StepPending,
+			CommandIDs: []uuid.UUID{},
+		})
+	}
+	return nil
+}
+
+// findWorkflow returns the workflow row with the given ID, or nil
+func (s *Store) findWorkflow(workflowID uuid.UUID) *workflowRow {
+	for _, row := range s.workflows {
+		if row.wf.WorkflowID == workflowID {
+			return row
+		}
+	}
+	return nil
+}
+
+// GetWorkflow retrieves a workflow run by ID, or nil if it doesn't exist
+func (s *Store) GetWorkflow(ctx context.Context, workflowID uuid.UUID) (*domains.Workflow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findWorkflow(workflowID)
+	if row == nil {
+		return nil, nil
+	}
+	return row.workflow()
+}
+
+// ListWorkflows retrieves the most recent workflow runs of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListWorkflows(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Workflow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var workflows []*domains.Workflow
+	for i := len(s.workflows) - 1; i >= 0 && len(workflows) < limit; i-- {
+		row := s.workflows[i]
+		if tenantID != "" && row.wf.TenantID != tenantID || status != nil && row.wf.Status != *status {
+			continue
+		}
+		wf, err := row.workflow()
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, wf)
+	}
+	return workflows, nil
+}
+
+// FinishWorkflow moves a running workflow to its final status, reporting whether it was still running
+func (s *Store) FinishWorkflow(ctx context.Context, workflowID uuid.UUID, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := s.findWorkflow(workflowID)
+	if row == nil || row.wf.Status != domains.WorkflowRunning {
+		return false, nil
+	}
+	finished := now()
+	row.wf.Status, row.wf.FinishedAt, row.wf.UpdatedAt = status, &finished, finished
+	return true, nil
+}
+
+// ListWorkflowSteps retrieves the step states of a workflow run
+func (s *Store) ListWorkflowSteps(ctx context.Context, workflowID uuid.UUID) ([]domains.WorkflowStepState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var steps []domains.WorkflowStepState
+	for _, step := range s.workflowSteps {
+		if step.WorkflowID == workflowID {
+			copied := *step
+			copied.CommandIDs = append([]uuid.UUID{}, step.CommandIDs...)
+			copied.ErrorMsg = copyString(step.ErrorMsg)
+			steps = append(steps, copied)
+		}
+	}
+	return steps, nil
+}
+
+// findWorkflowStep returns the state of a step of a workflow run, or nil
+func (s *Store) findWorkflowStep(workflowID uuid.UUID, stepID string) *domains.WorkflowStepState {
+	for _, step := range s.workflowSteps {
+		if step.WorkflowID == workflowID && step.StepID == stepID {
+			return step
+		}
+	}
+	return nil
+}
+
+// TransitionWorkflowStep moves a step from one status to another
+// It only succeeds if the step is still in the from status, so concurrent engines move a step at most once.
+// It reports whether the transition was made.
+func (s *Store) TransitionWorkflowStep(ctx context.Context, workflowID uuid.UUID, stepID, from, to string, errorMsg *string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	step := s.findWorkflowStep(workflowID, stepID)
+	if step == nil || step.Status != from {
+		return false, nil
+	}
+
+	changed := now()
+	step.Status = to
+	if errorMsg != nil {
+		step.ErrorMsg = copyString(errorMsg)
+	}
+	switch to {
+	case domains.StepRunning:
+		startedAt := changed
+		step.StartedAt = &startedAt
+	case domains.StepSucceeded, domains.StepFailed, domains.StepSkipped:
+		finishedAt := changed
+		step.FinishedAt = &finishedAt
+	}
+	return true, nil
+}
+
+// SetWorkflowStepCommands records the commands submitted for a step
+func (s *Store) SetWorkflowStepCommands(ctx context.Context, workflowID uuid.UUID, stepID string, commandIDs []uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if step := s.findWorkflowStep(workflowID, stepID); step != nil {
+		step.CommandIDs = append([]uuid.UUID{}, commandIDs...)
+	}
+	return nil
+}
+
+// GetWorkflowStepOutcome summarizes the commands of a step, using the latest attempt of each command
+func (s *Store) GetWorkflowStepOutcome(ctx context.Context, workflowID uuid.UUID, stepID string) (domains.CommandOutcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commandOutcome(func(cmd *domains.NodeCommand) bool {
+		return cmd.WorkflowID != nil && *cmd.WorkflowID == workflowID &&
+			cmd.WorkflowStepID != nil && *cmd.WorkflowStepID == stepID
+	}), nil
+}
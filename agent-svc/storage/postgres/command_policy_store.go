@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateCommandPolicy stores a policy as the next version and fills in its ID and version
+// Concurrent changes race for the same version; the loser fails on its unique constraint.
+func (s *Store) CreateCommandPolicy(ctx context.Context, p *domains.CommandPolicy) error {
+	rules := p.Rules
+	if rules == nil {
+		rules = []domains.PolicyRule{}
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy rules: %w", err)
+	}
+
+	query := `
+		INSERT INTO command_policies (version, default_action, rules, created_by)
+		SELECT COALESCE(MAX(version), 0) + 1, $1, $2::jsonb, $3 FROM command_policies
+		RETURNING id, policy_id, version, created_at
+	`
+	return s.pool.QueryRow(ctx, query, p.DefaultAction, string(rulesJSON), p.CreatedBy).
+		Scan(&p.ID, &p.PolicyID, &p.Version, &p.CreatedAt)
+}
+
+// GetCommandPolicy retrieves the latest version of the command policy, or nil if none was ever stored
+func (s *Store) GetCommandPolicy(ctx context.Context) (*domains.CommandPolicy, error) {
+	query := `
+		SELECT id, policy_id, version, default_action, rules, created_by, created_at
+		FROM command_policies
+		ORDER BY version DESC
+		LIMIT 1
+	`
+	var p domains.CommandPolicy
+	err := s.pool.QueryRow(ctx, query).Scan(&p.ID, &p.PolicyID, &p.Version, &p.DefaultAction, &p.Rules, &p.CreatedBy, &p.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
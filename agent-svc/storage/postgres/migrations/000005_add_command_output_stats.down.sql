@@ -0,0 +1,2 @@
+ALTER TABLE node_commands DROP COLUMN IF EXISTS output_truncated;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS output_bytes;
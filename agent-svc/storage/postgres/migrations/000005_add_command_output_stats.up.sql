@@ -0,0 +1,2 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS output_bytes BIGINT;                        -- total bytes produced by the command
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS output_truncated BOOLEAN NOT NULL DEFAULT FALSE; -- true if the middle of the output was dropped
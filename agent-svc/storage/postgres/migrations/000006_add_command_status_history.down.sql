@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_command_status_history_commandid;
+DROP TABLE IF EXISTS command_status_history;
+
+ALTER TABLE node_commands DROP COLUMN IF EXISTS finished_at;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS started_at;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS dispatched_at;
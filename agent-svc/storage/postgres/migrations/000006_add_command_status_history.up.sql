@@ -0,0 +1,13 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS dispatched_at TIMESTAMPTZ; -- handed to the node by GetNextCommand
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;    -- node reported the command running
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;   -- command reached a terminal status
+
+CREATE TABLE IF NOT EXISTS command_status_history (
+  id BIGSERIAL PRIMARY KEY,
+  command_id UUID NOT NULL REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  from_status TEXT,                           -- NULL for the initial queued entry
+  to_status TEXT NOT NULL,
+  source TEXT NOT NULL,                       -- submit|dispatch|agent|operator|system
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_command_status_history_commandid ON command_status_history(command_id);
@@ -0,0 +1,2 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ; -- deliver-by deadline, NULL for no deadline
+CREATE INDEX IF NOT EXISTS idx_node_commands_queued_expiry ON node_commands(expires_at) WHERE status = 'queued' AND expires_at IS NOT NULL;
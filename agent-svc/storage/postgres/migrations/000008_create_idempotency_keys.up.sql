@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  id BIGSERIAL PRIMARY KEY,
+  operator_id TEXT NOT NULL DEFAULT '',       -- submitting operator, '' when unauthenticated
+  node_id TEXT NOT NULL,
+  idem_key TEXT NOT NULL,                     -- client supplied Idempotency-Key
+  request_hash TEXT NOT NULL,                 -- sha256 of the canonical request, to detect reuse with a different payload
+  command_id UUID NOT NULL REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  UNIQUE (operator_id, node_id, idem_key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
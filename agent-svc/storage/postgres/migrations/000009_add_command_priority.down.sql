@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_node_commands_queued_priority;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,2 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 5; -- 0 (lowest) .. 9 (highest)
+CREATE INDEX IF NOT EXISTS idx_node_commands_queued_priority ON node_commands(node_id, priority DESC, created_at) WHERE status = 'queued';
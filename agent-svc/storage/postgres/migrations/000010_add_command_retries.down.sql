@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_node_commands_next_retry;
+DROP INDEX IF EXISTS idx_node_commands_parent;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS next_retry_at;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS retry_policy;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS attempt;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS parent_command_id;
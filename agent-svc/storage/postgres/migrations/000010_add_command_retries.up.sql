@@ -0,0 +1,6 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS parent_command_id UUID REFERENCES node_commands(command_id) ON DELETE CASCADE; -- first attempt, NULL on the first attempt itself
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS attempt INT NOT NULL DEFAULT 1;
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS retry_policy JSONB;
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMPTZ; -- set on a finished attempt until its retry is created
+CREATE INDEX IF NOT EXISTS idx_node_commands_parent ON node_commands(parent_command_id) WHERE parent_command_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_node_commands_next_retry ON node_commands(next_retry_at) WHERE next_retry_at IS NOT NULL;
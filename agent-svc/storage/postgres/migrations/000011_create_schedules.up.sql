@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS schedules (
+  id BIGSERIAL PRIMARY KEY,
+  schedule_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  cron_expr TEXT NOT NULL,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  node_id TEXT REFERENCES nodes(node_id) ON DELETE CASCADE,  -- target node, or NULL when selector is set
+  selector JSONB,                                             -- attrs key/value pairs the target nodes must match
+  command_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  priority SMALLINT NOT NULL DEFAULT 5,
+  misfire_policy TEXT NOT NULL DEFAULT 'run_once',           -- run_once|skip
+  misfire_grace_sec INT NOT NULL DEFAULT 300,
+  paused BOOLEAN NOT NULL DEFAULT FALSE,
+  next_run_at TIMESTAMPTZ,                                    -- NULL while paused
+  last_run_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  CONSTRAINT chk_schedule_target CHECK ((node_id IS NULL) <> (selector IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run ON schedules(next_run_at) WHERE NOT paused;
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+  id BIGSERIAL PRIMARY KEY,
+  schedule_id UUID NOT NULL REFERENCES schedules(schedule_id) ON DELETE CASCADE,
+  scheduled_for TIMESTAMPTZ NOT NULL,
+  trigger TEXT NOT NULL,                          -- schedule|manual
+  status TEXT NOT NULL,                           -- created|partial|failed|skipped
+  command_ids UUID[] NOT NULL DEFAULT '{}',
+  error_msg TEXT,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule ON schedule_runs(schedule_id, created_at DESC);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_node_commands_workflow;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS workflow_step_id;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS workflow_id;
+DROP TABLE IF EXISTS workflow_steps;
+DROP TABLE IF EXISTS workflows;
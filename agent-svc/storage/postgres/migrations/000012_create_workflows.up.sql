@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS workflows (
+  id BIGSERIAL PRIMARY KEY,
+  workflow_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  definition JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'running',  -- running|succeeded|failed
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_workflows_running ON workflows(created_at) WHERE status = 'running';
+
+CREATE TABLE IF NOT EXISTS workflow_steps (
+  id BIGSERIAL PRIMARY KEY,
+  workflow_id UUID NOT NULL REFERENCES workflows(workflow_id) ON DELETE CASCADE,
+  step_id TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',  -- pending|running|succeeded|failed|skipped
+  command_ids UUID[] NOT NULL DEFAULT '{}',
+  error_msg TEXT,
+  started_at TIMESTAMPTZ,
+  finished_at TIMESTAMPTZ,
+  UNIQUE(workflow_id, step_id)
+);
+
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS workflow_id UUID REFERENCES workflows(workflow_id) ON DELETE SET NULL;
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS workflow_step_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_node_commands_workflow ON node_commands(workflow_id, workflow_step_id) WHERE workflow_id IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_node_commands_rollout;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS rollout_batch;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS rollout_id;
+DROP TABLE IF EXISTS rollout_batches;
+DROP TABLE IF EXISTS rollouts;
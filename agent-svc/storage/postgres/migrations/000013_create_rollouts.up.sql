@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS rollouts (
+  id BIGSERIAL PRIMARY KEY,
+  rollout_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  command_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  priority SMALLINT NOT NULL DEFAULT 5,
+  strategy JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'running',  -- running|paused|halted|aborted|completed
+  next_batch_at TIMESTAMPTZ,
+  error_msg TEXT,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_rollouts_active ON rollouts(created_at) WHERE status IN ('running', 'paused');
+
+CREATE TABLE IF NOT EXISTS rollout_batches (
+  id BIGSERIAL PRIMARY KEY,
+  rollout_id UUID NOT NULL REFERENCES rollouts(rollout_id) ON DELETE CASCADE,
+  batch_index INT NOT NULL,
+  canary BOOLEAN NOT NULL DEFAULT false,
+  node_ids TEXT[] NOT NULL,
+  command_ids UUID[] NOT NULL DEFAULT '{}',
+  status TEXT NOT NULL DEFAULT 'pending',  -- pending|running|succeeded|failed
+  succeeded_count INT NOT NULL DEFAULT 0,
+  error_msg TEXT,
+  started_at TIMESTAMPTZ,
+  finished_at TIMESTAMPTZ,
+  UNIQUE(rollout_id, batch_index)
+);
+
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS rollout_id UUID REFERENCES rollouts(rollout_id) ON DELETE SET NULL;
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS rollout_batch INT;
+CREATE INDEX IF NOT EXISTS idx_node_commands_rollout ON node_commands(rollout_id, rollout_batch) WHERE rollout_id IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE node_commands DROP COLUMN IF EXISTS template_version;
+ALTER TABLE node_commands DROP COLUMN IF EXISTS template_name;
+DROP TABLE IF EXISTS command_templates;
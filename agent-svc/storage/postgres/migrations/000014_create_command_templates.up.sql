@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS command_templates (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  version INT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL,
+  payload JSONB NOT NULL,   -- may contain {{param}} placeholders
+  params JSONB NOT NULL,    -- parameter schema
+  created_at TIMESTAMPTZ DEFAULT now(),
+  UNIQUE(name, version)
+);
+
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS template_name TEXT;
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS template_version INT;
@@ -0,0 +1,4 @@
+ALTER TABLE nodes DROP COLUMN IF EXISTS offline_at;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS webhook_subscriptions;
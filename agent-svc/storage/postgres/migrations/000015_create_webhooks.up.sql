@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+  id BIGSERIAL PRIMARY KEY,
+  webhook_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  url TEXT NOT NULL,
+  event_types TEXT[] NOT NULL,   -- exact types, family wildcards such as command.* or *
+  secret TEXT NOT NULL,          -- HMAC-SHA256 signing key
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+-- Outbox: events are inserted in the same transaction as the change they describe and fanned out to
+-- matching subscriptions by the delivery worker
+CREATE TABLE IF NOT EXISTS webhook_events (
+  id BIGSERIAL PRIMARY KEY,
+  event_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  event_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  fanned_out_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_pending ON webhook_events(id) WHERE fanned_out_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  delivery_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  webhook_id UUID NOT NULL REFERENCES webhook_subscriptions(webhook_id) ON DELETE CASCADE,
+  event_id UUID NOT NULL REFERENCES webhook_events(event_id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending',   -- pending|delivered|dead
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ,
+  last_response_code INT,
+  last_error TEXT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now(),
+  UNIQUE(webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at DESC);
+
+-- Set when the node monitor reports a node offline, cleared by its next heartbeat
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS offline_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE node_commands DROP COLUMN IF EXISTS trace_context;
@@ -0,0 +1,2 @@
+-- W3C trace context (traceparent, tracestate) of the submission; retries keep the trace of the first attempt
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS trace_context JSONB;
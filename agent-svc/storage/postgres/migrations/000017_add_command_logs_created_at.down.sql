@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_command_logs_created_at;
+ALTER TABLE command_logs DROP COLUMN IF EXISTS created_at;
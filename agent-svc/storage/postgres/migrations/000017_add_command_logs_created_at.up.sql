@@ -0,0 +1,3 @@
+-- CleanupOldLogs deletes chunks by age; chunks stored before this migration count from when it ran
+ALTER TABLE command_logs ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT now();
+CREATE INDEX IF NOT EXISTS idx_command_logs_created_at ON command_logs(created_at);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS tenants (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  enrollment_key_hash TEXT NOT NULL DEFAULT '',   -- SHA-256 of the node enrollment key; empty needs none
+  max_nodes INT NOT NULL DEFAULT 0,               -- 0 is unlimited
+  max_commands_per_day INT NOT NULL DEFAULT 0,    -- first attempts in the last 24 hours; 0 is unlimited
+  created_at TIMESTAMPTZ DEFAULT now(),
+  updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+-- Existing nodes and operators without a membership belong to the default tenant
+INSERT INTO tenants (tenant_id, name) VALUES ('default', 'Default') ON CONFLICT (tenant_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS tenant_operators (
+  tenant_id TEXT NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  operator_id TEXT NOT NULL,
+  created_at TIMESTAMPTZ DEFAULT now(),
+  PRIMARY KEY (tenant_id, operator_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_operators_operator ON tenant_operators(operator_id);
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_nodes_tenant_id ON nodes(tenant_id);
+
+-- Counts submissions against the daily command quota
+CREATE INDEX IF NOT EXISTS idx_node_commands_node_created ON node_commands(node_id, created_at) WHERE attempt = 1;
@@ -0,0 +1,2 @@
+-- Operator-set labels; unlike attrs they are kept when the node registers again
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS command_approvals;
+DROP TABLE IF EXISTS approval_policies;
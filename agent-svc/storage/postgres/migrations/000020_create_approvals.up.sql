@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS approval_policies (
+  id BIGSERIAL PRIMARY KEY,
+  policy_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL DEFAULT '',      -- empty matches every command type
+  payload_pattern TEXT NOT NULL DEFAULT '',   -- regular expression matched against the strings of the payload
+  node_labels JSONB NOT NULL DEFAULT '{}',    -- labels the target node must have
+  timeout_sec INT NOT NULL,                   -- approval requests expire after this long
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+-- One approval request per command submitted under a policy; the command waits in pending_approval until
+-- a decision is recorded here
+CREATE TABLE IF NOT EXISTS command_approvals (
+  command_id UUID PRIMARY KEY REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  policy_id UUID NOT NULL,                    -- no foreign key: requests outlive the policy
+  policy_name TEXT NOT NULL,
+  requested_by TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMPTZ NOT NULL,
+  decision TEXT,                              -- approved|rejected|expired, NULL while pending
+  decided_by TEXT,
+  comment TEXT,
+  decided_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_approvals_pending ON command_approvals(expires_at) WHERE decision IS NULL;
@@ -0,0 +1,10 @@
+-- Every version of the command policy set through the API; the highest version is in effect
+CREATE TABLE IF NOT EXISTS command_policies (
+  id BIGSERIAL PRIMARY KEY,
+  policy_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  version INT UNIQUE NOT NULL,
+  default_action TEXT NOT NULL,               -- allow|deny, for submissions no rule matches
+  rules JSONB NOT NULL DEFAULT '[]',          -- evaluated in order, the first match decides
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ DEFAULT now()
+);
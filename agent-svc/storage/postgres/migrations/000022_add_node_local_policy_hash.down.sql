@@ -0,0 +1 @@
+ALTER TABLE nodes DROP COLUMN IF EXISTS local_policy_hash;
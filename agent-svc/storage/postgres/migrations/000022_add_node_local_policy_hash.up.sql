@@ -0,0 +1,2 @@
+-- Hash of the local policy file the node reported with its last heartbeat; empty if it has none
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS local_policy_hash TEXT NOT NULL DEFAULT '';
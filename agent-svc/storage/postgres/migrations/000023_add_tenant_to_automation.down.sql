@@ -0,0 +1,11 @@
+ALTER TABLE webhook_events DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE webhook_subscriptions DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE command_templates DROP CONSTRAINT IF EXISTS command_templates_tenant_name_version_key;
+ALTER TABLE command_templates DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE command_templates ADD CONSTRAINT command_templates_name_version_key UNIQUE (name, version);
+DROP INDEX IF EXISTS idx_rollouts_tenant_id;
+ALTER TABLE rollouts DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS idx_workflows_tenant_id;
+ALTER TABLE workflows DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS idx_schedules_tenant_id;
+ALTER TABLE schedules DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,23 @@
+-- Schedules, workflows, rollouts, templates and webhooks belong to a tenant; existing ones to the default tenant
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_schedules_tenant_id ON schedules(tenant_id);
+
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_workflows_tenant_id ON workflows(tenant_id);
+
+ALTER TABLE rollouts ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_rollouts_tenant_id ON rollouts(tenant_id);
+
+-- Template names are unique within a tenant
+ALTER TABLE command_templates ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+ALTER TABLE command_templates DROP CONSTRAINT IF EXISTS command_templates_name_version_key;
+ALTER TABLE command_templates ADD CONSTRAINT command_templates_tenant_name_version_key UNIQUE (tenant_id, name, version);
+
+-- A subscription receives only the events of its tenant's nodes
+ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(tenant_id);
+
+-- Tenant of the node an event is about, recorded when the event is inserted
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+UPDATE webhook_events e SET tenant_id = n.tenant_id
+FROM nodes n
+WHERE n.node_id = e.payload->>'node_id' AND e.fanned_out_at IS NULL;
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sort"
 	"time"
 
 	"agent-svc/app/domains"
@@ -14,6 +14,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// SchemaVersion is the migration version this build expects; bump it with every new migration
+const SchemaVersion = 23
+
 // Store represents the Postgres storage implementation
 type Store struct {
 	pool *pgxpool.Pool
@@ -39,39 +42,186 @@ func (s *Store) Close() {
 	s.pool.Close()
 }
 
-// RegisterNode registers a new node
-func (s *Store) RegisterNode(ctx context.Context, nodeID string, attrs map[string]interface{}) error {
+// Ping checks that a pooled connection to the database is usable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// MigrationVersion returns the applied migration version recorded by golang-migrate
+func (s *Store) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := s.pool.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return uint(version), dirty, nil
+}
+
+// PoolStat returns a snapshot of the connection pool statistics
+func (s *Store) PoolStat() *pgxpool.Stat {
+	return s.pool.Stat()
+}
+
+// commandColumns is the column list scanned by scanCommand
+const commandColumns = `id, command_id, node_id, command_type, payload, status, created_at, updated_at, exit_code, error_msg,
+		output_bytes, output_truncated, dispatched_at, started_at, finished_at, expires_at, priority,
+		parent_command_id, attempt, retry_policy, next_retry_at, workflow_id, workflow_step_id,
+		rollout_id, rollout_batch, template_name, template_version, trace_context`
+
+// scanCommand scans a node_commands row selected with commandColumns
+func scanCommand(row pgx.Row) (*domains.NodeCommand, error) {
+	var cmd domains.NodeCommand
+	var payloadJSON, retryPolicyJSON, traceContextJSON []byte
+	err := row.Scan(
+		&cmd.ID, &cmd.CommandID, &cmd.NodeID, &cmd.CommandType, &payloadJSON, &cmd.Status,
+		&cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
+		&cmd.OutputBytes, &cmd.OutputTruncated, &cmd.DispatchedAt, &cmd.StartedAt, &cmd.FinishedAt,
+		&cmd.ExpiresAt, &cmd.Priority,
+		&cmd.ParentCommandID, &cmd.Attempt, &retryPolicyJSON, &cmd.NextRetryAt, &cmd.WorkflowID, &cmd.WorkflowStepID,
+		&cmd.RolloutID, &cmd.RolloutBatch, &cmd.TemplateName, &cmd.TemplateVersion, &traceContextJSON,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	if retryPolicyJSON != nil {
+		cmd.RetryPolicy = &domains.RetryPolicy{}
+		if err := json.Unmarshal(retryPolicyJSON, cmd.RetryPolicy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal retry policy: %w", err)
+		}
+	}
+	if traceContextJSON != nil {
+		if err := json.Unmarshal(traceContextJSON, &cmd.TraceContext); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trace context: %w", err)
+		}
+	}
+	return &cmd, nil
+}
+
+// RegisterNode enrolls a new node into a tenant, or updates the attrs of a node already enrolled in it
+// It returns domains.ErrNodeInOtherTenant if the node ID belongs to another tenant and
+// domains.ErrTenantQuotaExceeded if a new node would exceed the tenant's node quota.
+func (s *Store) RegisterNode(ctx context.Context, tenantID, nodeID string, attrs map[string]interface{}) error {
 	attrsJSON, err := json.Marshal(attrs)
 	if err != nil {
 		return fmt.Errorf("failed to marshal attrs: %w", err)
 	}
 
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the tenant so concurrent enrollments can't both take its last node slot
+	var maxNodes int
+	err = tx.QueryRow(ctx, `SELECT max_nodes FROM tenants WHERE tenant_id = $1 FOR UPDATE`, tenantID).Scan(&maxNodes)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	if err != nil {
+		return err
+	}
+
+	var currentTenantID string
+	err = tx.QueryRow(ctx, `SELECT tenant_id FROM nodes WHERE node_id = $1`, nodeID).Scan(&currentTenantID)
+	switch {
+	case err == pgx.ErrNoRows:
+		if maxNodes > 0 {
+			var nodes int
+			if err := tx.QueryRow(ctx, `SELECT count(*) FROM nodes WHERE tenant_id = $1`, tenantID).Scan(&nodes); err != nil {
+				return err
+			}
+			if nodes >= maxNodes {
+				return domains.NodeQuotaError(tenantID, maxNodes)
+			}
+		}
+	case err != nil:
+		return err
+	case currentTenantID != tenantID:
+		return fmt.Errorf("%w: %s", domains.ErrNodeInOtherTenant, nodeID)
+	}
+
+	// The WHERE clause keeps a concurrent enrollment of the same ID into another tenant from taking the node over
 	query := `
-		INSERT INTO nodes (node_id, attrs, last_seen_at)
-		VALUES ($1, $2::jsonb, $3)
-		ON CONFLICT (node_id) 
-		DO UPDATE SET 
+		INSERT INTO nodes (node_id, tenant_id, attrs, last_seen_at)
+		VALUES ($1, $2, $3::jsonb, $4)
+		ON CONFLICT (node_id)
+		DO UPDATE SET
 			attrs = EXCLUDED.attrs,
 			last_seen_at = EXCLUDED.last_seen_at
+		WHERE nodes.tenant_id = EXCLUDED.tenant_id
 	`
-	_, err = s.pool.Exec(ctx, query, nodeID, string(attrsJSON), time.Now())
-	return err
+	result, err := tx.Exec(ctx, query, nodeID, tenantID, string(attrsJSON), time.Now())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", domains.ErrNodeInOtherTenant, nodeID)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // UpdateNodeLastSeen updates the last_seen_at timestamp
+// A node the node monitor reported offline is brought back with a node.online event.
 func (s *Store) UpdateNodeLastSeen(ctx context.Context, nodeID string) error {
-	query := `UPDATE nodes SET last_seen_at = $1 WHERE node_id = $2`
-	_, err := s.pool.Exec(ctx, query, time.Now(), nodeID)
-	return err
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		WITH prev AS (SELECT node_id, offline_at FROM nodes WHERE node_id = $2 FOR UPDATE)
+		UPDATE nodes n
+		SET last_seen_at = $1, offline_at = NULL
+		FROM prev
+		WHERE n.node_id = prev.node_id
+		RETURNING prev.offline_at
+	`
+	var offlineAt *time.Time
+	err = tx.QueryRow(ctx, query, time.Now(), nodeID).Scan(&offlineAt)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if offlineAt != nil {
+		err := insertWebhookEvent(ctx, tx, domains.EventNodeOnline, map[string]interface{}{
+			"node_id":    nodeID,
+			"offline_at": offlineAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // GetNode retrieves a node by ID
 func (s *Store) GetNode(ctx context.Context, nodeID string) (*domains.Node, error) {
 	var node domains.Node
-	query := `SELECT id, node_id, attrs, last_seen_at, disabled FROM nodes WHERE node_id = $1`
+	query := `SELECT id, node_id, tenant_id, attrs, labels, last_seen_at, disabled, local_policy_hash FROM nodes WHERE node_id = $1`
 
 	err := s.pool.QueryRow(ctx, query, nodeID).Scan(
-		&node.ID, &node.NodeID, &node.Attrs, &node.LastSeenAt, &node.Disabled,
+		&node.ID, &node.NodeID, &node.TenantID, &node.Attrs, &node.Labels, &node.LastSeenAt, &node.Disabled, &node.LocalPolicyHash,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -82,122 +232,558 @@ func (s *Store) GetNode(ctx context.Context, nodeID string) (*domains.Node, erro
 	return &node, nil
 }
 
+// UpdateNodeLabels removes and then sets labels of a node, keeping its other labels
+// It returns false if the node doesn't exist.
+func (s *Store) UpdateNodeLabels(ctx context.Context, nodeID string, set map[string]string, remove []string) (bool, error) {
+	if set == nil {
+		set = map[string]string{}
+	}
+	if remove == nil {
+		remove = []string{}
+	}
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal labels: %w", err)
+	}
+
+	result, err := s.pool.Exec(ctx, `UPDATE nodes SET labels = (labels - $2::text[]) || $3::jsonb WHERE node_id = $1`,
+		nodeID, remove, string(setJSON))
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// SetNodeDisabled disables or re-enables a node; it returns false if the node doesn't exist
+func (s *Store) SetNodeDisabled(ctx context.Context, nodeID string, disabled bool) (bool, error) {
+	result, err := s.pool.Exec(ctx, `UPDATE nodes SET disabled = $2 WHERE node_id = $1`, nodeID, disabled)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// SetNodePolicyHash records the hash of the local policy a node reported; it returns false if the node doesn't exist
+func (s *Store) SetNodePolicyHash(ctx context.Context, nodeID, hash string) (bool, error) {
+	result, err := s.pool.Exec(ctx, `UPDATE nodes SET local_policy_hash = $2 WHERE node_id = $1`, nodeID, hash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
 // CreateCommand creates a new command in the queue
-func (s *Store) CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}) (uuid.UUID, error) {
+// It returns domains.ErrTenantQuotaExceeded if the node's tenant has used up its daily command quota.
+func (s *Store) CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}, opts domains.CommandOptions) (uuid.UUID, error) {
 	commandID := uuid.New()
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCommandQuota(ctx, tx, nodeID); err != nil {
+		return uuid.Nil, err
+	}
+
+	priority := domains.PriorityDefault
+	if opts.Priority != nil {
+		priority = *opts.Priority
+	}
+
+	var retryPolicyJSON []byte
+	if opts.RetryPolicy != nil {
+		if retryPolicyJSON, err = json.Marshal(opts.RetryPolicy); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to marshal retry policy: %w", err)
+		}
+	}
+
+	var workflowStepID *string
+	if opts.WorkflowStepID != "" {
+		workflowStepID = &opts.WorkflowStepID
+	}
+
+	var rolloutBatch *int
+	if opts.RolloutID != nil {
+		rolloutBatch = &opts.RolloutBatch
+	}
+
+	var templateName *string
+	var templateVersion *int
+	if opts.TemplateName != "" {
+		templateName, templateVersion = &opts.TemplateName, &opts.TemplateVersion
+	}
+
+	var traceContextJSON []byte
+	if len(opts.TraceContext) > 0 {
+		if traceContextJSON, err = json.Marshal(opts.TraceContext); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to marshal trace context: %w", err)
+		}
+	}
+
+	status := domains.StatusQueued
+	if opts.Approval != nil {
+		status = domains.StatusPendingApproval
+	}
+
 	query := `
-		INSERT INTO node_commands (command_id, node_id, command_type, payload, status)
-		VALUES ($1, $2, $3, $4::jsonb, 'queued')
+		INSERT INTO node_commands (command_id, node_id, command_type, payload, status, expires_at, priority, retry_policy,
+			workflow_id, workflow_step_id, rollout_id, rollout_batch, template_name, template_version, trace_context)
+		VALUES ($1, $2, $3, $4::jsonb, $15, $5, $6, $7::jsonb, $8, $9, $10, $11, $12, $13, $14::jsonb)
 	`
-	_, err = s.pool.Exec(ctx, query, commandID, nodeID, commandType, string(payloadJSON))
+	_, err = tx.Exec(ctx, query, commandID, nodeID, commandType, string(payloadJSON), opts.ExpiresAt, priority, retryPolicyJSON,
+		opts.WorkflowID, workflowStepID, opts.RolloutID, rolloutBatch, templateName, templateVersion, traceContextJSON, status)
 	if err != nil {
 		return uuid.Nil, err
 	}
+
+	if err := recordStatusChange(ctx, tx, commandID, nil, status, domains.SourceSubmit); err != nil {
+		return uuid.Nil, err
+	}
+
+	if a := opts.Approval; a != nil {
+		approvalQuery := `
+			INSERT INTO command_approvals (command_id, policy_id, policy_name, requested_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+		if _, err := tx.Exec(ctx, approvalQuery, commandID, a.PolicyID, a.PolicyName, opts.OperatorID, a.ExpiresAt); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to store approval request: %w", err)
+		}
+	}
+
+	if opts.IdempotencyKey != "" {
+		// An expired key is taken over; a live one aborts the transaction so no duplicate command is created
+		keyQuery := `
+			INSERT INTO idempotency_keys (operator_id, node_id, idem_key, request_hash, command_id, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (operator_id, node_id, idem_key) DO UPDATE SET
+				request_hash = EXCLUDED.request_hash,
+				command_id = EXCLUDED.command_id,
+				created_at = now(),
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= now()
+		`
+		result, err := tx.Exec(ctx, keyQuery, opts.OperatorID, nodeID, opts.IdempotencyKey, opts.RequestHash, commandID, opts.IdempotencyExpiresAt)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return uuid.Nil, domains.ErrDuplicateIdempotencyKey
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return commandID, nil
 }
 
-// GetNextCommand retrieves up to 5 queued commands for a node
-func (s *Store) GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error) {
+// GetIdempotencyKey retrieves an unexpired idempotency key, or nil if there is none
+func (s *Store) GetIdempotencyKey(ctx context.Context, operatorID, nodeID, key string) (*domains.IdempotencyKey, error) {
+	var k domains.IdempotencyKey
 	query := `
-		SELECT id, command_id, node_id, command_type, payload, status, created_at, updated_at, exit_code, error_msg
-		FROM node_commands
-		WHERE node_id = $1 AND status = 'queued'
-		ORDER BY created_at ASC
-		LIMIT 5
+		SELECT id, operator_id, node_id, idem_key, request_hash, command_id, created_at, expires_at
+		FROM idempotency_keys
+		WHERE operator_id = $1 AND node_id = $2 AND idem_key = $3 AND expires_at > now()
 	`
-
-	rows, err := s.pool.Query(ctx, query, nodeID)
+	err := s.pool.QueryRow(ctx, query, operatorID, nodeID, key).Scan(
+		&k.ID, &k.OperatorID, &k.NodeID, &k.Key, &k.RequestHash, &k.CommandID, &k.CreatedAt, &k.ExpiresAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return &k, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes idempotency keys past their expiry
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	return err
+}
+
+// GetNextCommand claims up to 5 queued commands for a node and marks them as running
+// Rows locked by a concurrent poll are skipped, so each command is dispatched once, and
+// commands past their expires_at are never handed out even if the sweeper hasn't run yet
+func (s *Store) GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE node_commands
+		SET status = 'running', dispatched_at = $2, updated_at = $2
+		WHERE id IN (
+			SELECT id FROM node_commands
+			WHERE node_id = $1 AND status = 'queued' AND (expires_at IS NULL OR expires_at > $2)
+			ORDER BY priority DESC, created_at ASC
+			LIMIT 5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + commandColumns
+
+	rows, err := tx.Query(ctx, query, nodeID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim commands: %w", err)
+	}
 
 	var commands []*domains.NodeCommand
 	var commandIDs []uuid.UUID
-
 	for rows.Next() {
-		var cmd domains.NodeCommand
-		var payloadJSON []byte
-		err := rows.Scan(
-			&cmd.ID, &cmd.CommandID, &cmd.NodeID, &cmd.CommandType, &payloadJSON, &cmd.Status,
-			&cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
-		)
+		cmd, err := scanCommand(rows)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-
-		if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
-		}
-
-		commands = append(commands, &cmd)
+		commands = append(commands, cmd)
 		commandIDs = append(commandIDs, cmd.CommandID)
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(commands) == 0 {
 		return nil, nil
 	}
 
+	historyQuery := `
+		INSERT INTO command_status_history (command_id, from_status, to_status, source)
+		SELECT unnest($1::uuid[]), 'queued', 'running', $2
+	`
+	if _, err := tx.Exec(ctx, historyQuery, commandIDs, domains.SourceDispatch); err != nil {
+		return nil, fmt.Errorf("failed to record status history: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// RETURNING doesn't preserve the subquery order
+	sort.Slice(commands, func(i, j int) bool {
+		if commands[i].Priority != commands[j].Priority {
+			return commands[i].Priority > commands[j].Priority
+		}
+		return commands[i].CreatedAt.Before(commands[j].CreatedAt)
+	})
+
+	return commands, nil
+}
+
+// UpdateCommandStatus moves a command to a new status
+// The transition is validated against the current status under a row lock and recorded in
+// command_status_history; domains.ErrInvalidStatusTransition is returned if it isn't allowed
+func (s *Store) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string, source string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, `SELECT status FROM node_commands WHERE command_id = $1 FOR UPDATE`, commandID).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("command not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := domains.ValidateStatusTransition(current, status); err != nil {
+		return err
+	}
+
+	isTerminal := domains.IsTerminalStatus(status)
+	now := time.Now()
+	query := `
+		UPDATE node_commands
+		SET status = $1, exit_code = $2, error_msg = $3, updated_at = $4,
+			started_at = CASE WHEN $1 = 'running' THEN COALESCE(started_at, $4) ELSE started_at END,
+			finished_at = CASE WHEN $5 THEN $4 ELSE finished_at END
+		WHERE command_id = $6
+		RETURNING node_id, command_type, attempt
+	`
+	ev := commandEvent{CommandID: commandID, Status: status, ExitCode: exitCode, ErrorMsg: errorMsg, FinishedAt: now}
+	err = tx.QueryRow(ctx, query, status, exitCode, errorMsg, now, isTerminal, commandID).
+		Scan(&ev.NodeID, &ev.CommandType, &ev.Attempt)
+	if err != nil {
+		return err
+	}
+
+	if err := recordStatusChange(ctx, tx, commandID, &current, status, source); err != nil {
+		return err
+	}
+
+	if isTerminal {
+		if err := insertCommandEvent(ctx, tx, ev); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if isTerminal {
+		if err := s.MarkAllChunksAsFinal(ctx, commandID); err != nil {
+		}
+	}
+
+	return nil
+}
+
+// ExpireQueuedCommands moves queued commands past their expires_at to expired
+func (s *Store) ExpireQueuedCommands(ctx context.Context) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	query := `
+		UPDATE node_commands
+		SET status = 'expired', error_msg = 'delivery deadline passed before dispatch', updated_at = $1, finished_at = $1
+		WHERE status = 'queued' AND expires_at <= $1
+		RETURNING command_id, node_id, command_type, attempt, error_msg
+	`
+	commandIDs, err := finishCommands(ctx, tx, domains.StatusExpired, now, query, now)
+	if err != nil {
+		return 0, err
+	}
+
 	if len(commandIDs) == 0 {
-		return nil, nil
+		return 0, nil
 	}
 
-	placeholders := make([]string, len(commandIDs))
-	args := make([]interface{}, len(commandIDs)+1)
-	args[0] = time.Now()
-	for i, cmdID := range commandIDs {
-		placeholders[i] = fmt.Sprintf("$%d", i+2)
-		args[i+1] = cmdID
+	historyQuery := `
+		INSERT INTO command_status_history (command_id, from_status, to_status, source)
+		SELECT unnest($1::uuid[]), 'queued', 'expired', $2
+	`
+	if _, err := tx.Exec(ctx, historyQuery, commandIDs, domains.SourceSystem); err != nil {
+		return 0, fmt.Errorf("failed to record status history: %w", err)
 	}
 
-	updateQuery := fmt.Sprintf(`
-		UPDATE node_commands 
-		SET status = 'running', updated_at = $1
-		WHERE command_id IN (%s) AND status = 'queued'
-	`, strings.Join(placeholders, ","))
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(commandIDs), nil
+}
 
-	result, err := s.pool.Exec(ctx, updateQuery, args...)
+// ListLostCommands retrieves up to limit running commands, oldest first, whose node hasn't been seen for lostAfter
+func (s *Store) ListLostCommands(ctx context.Context, lostAfter time.Duration, limit int) ([]*domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE status = 'running'
+			AND node_id IN (SELECT node_id FROM nodes WHERE last_seen_at < $1)
+		ORDER BY updated_at
+		LIMIT $2
+	`
+	rows, err := s.pool.Query(ctx, query, time.Now().Add(-lostAfter), limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update command status: %w", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return nil, nil
+	var commands []*domains.NodeCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
 	}
+	return commands, rows.Err()
+}
 
-	for _, cmd := range commands {
-		cmd.Status = "running"
+// finishCommands runs an UPDATE moving queued or pending commands to a terminal status, which must return
+// command_id, node_id, command_type, attempt and error_msg, and adds a webhook event for each command
+func finishCommands(ctx context.Context, tx pgx.Tx, status string, finishedAt time.Time, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
 
-	return commands, nil
+	var events []commandEvent
+	for rows.Next() {
+		ev := commandEvent{Status: status, FinishedAt: finishedAt}
+		if err := rows.Scan(&ev.CommandID, &ev.NodeID, &ev.CommandType, &ev.Attempt, &ev.ErrorMsg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	commandIDs := make([]uuid.UUID, len(events))
+	for i, ev := range events {
+		if err := insertCommandEvent(ctx, tx, ev); err != nil {
+			return nil, err
+		}
+		commandIDs[i] = ev.CommandID
+	}
+	return commandIDs, nil
 }
 
-// UpdateCommandStatus updates command status
-func (s *Store) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string) error {
+// ScheduleCommandRetry marks a finished attempt to be retried at the given time
+func (s *Store) ScheduleCommandRetry(ctx context.Context, commandID uuid.UUID, at time.Time) error {
 	query := `
-		UPDATE node_commands 
-		SET status = $1, exit_code = $2, error_msg = $3, updated_at = $4
-		WHERE command_id = $5
+		UPDATE node_commands
+		SET next_retry_at = $2, updated_at = now()
+		WHERE command_id = $1
 	`
-	_, err := s.pool.Exec(ctx, query, status, exitCode, errorMsg, time.Now(), commandID)
+	_, err := s.pool.Exec(ctx, query, commandID, at)
+	return err
+}
+
+// CreateRetryAttempts queues the next attempt of up to limit commands whose retry is due
+// Due rows are locked with SKIP LOCKED, so concurrent schedulers never create the same attempt twice. An
+// attempt gets as long to be delivered as the previous one had, counted from its creation.
+func (s *Store) CreateRetryAttempts(ctx context.Context, limit int) (int, error) {
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
-	if status == "success" || status == "failed" || status == "timeout" {
-		if err := s.MarkAllChunksAsFinal(ctx, commandID); err != nil {
+	query := `
+		WITH due AS (
+			SELECT command_id FROM node_commands
+			WHERE next_retry_at <= $1
+			ORDER BY next_retry_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		), cleared AS (
+			UPDATE node_commands c
+			SET next_retry_at = NULL, updated_at = $1
+			FROM due
+			WHERE c.command_id = due.command_id
+			RETURNING c.*
+		)
+		INSERT INTO node_commands (node_id, command_type, payload, status, expires_at, priority,
+			parent_command_id, attempt, retry_policy, workflow_id, workflow_step_id, rollout_id, rollout_batch,
+			template_name, template_version, trace_context)
+		SELECT node_id, command_type, payload, 'queued', $1 + (expires_at - created_at), priority,
+			COALESCE(parent_command_id, command_id), attempt + 1, retry_policy, workflow_id, workflow_step_id,
+			rollout_id, rollout_batch, template_name, template_version, trace_context
+		FROM cleared
+		RETURNING command_id
+	`
+	rows, err := tx.Query(ctx, query, time.Now(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create retry attempts: %w", err)
+	}
+
+	var commandIDs []uuid.UUID
+	for rows.Next() {
+		var commandID uuid.UUID
+		if err := rows.Scan(&commandID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		commandIDs = append(commandIDs, commandID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(commandIDs) == 0 {
+		return 0, nil
+	}
+
+	historyQuery := `
+		INSERT INTO command_status_history (command_id, from_status, to_status, source)
+		SELECT unnest($1::uuid[]), NULL, 'queued', $2
+	`
+	if _, err := tx.Exec(ctx, historyQuery, commandIDs, domains.SourceSystem); err != nil {
+		return 0, fmt.Errorf("failed to record status history: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(commandIDs), nil
+}
+
+// GetCommandAttempts retrieves all attempts of a command, given its first attempt, ordered by attempt
+func (s *Store) GetCommandAttempts(ctx context.Context, firstCommandID uuid.UUID) ([]*domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE command_id = $1 OR parent_command_id = $1
+		ORDER BY attempt ASC
+	`
+	rows, err := s.pool.Query(ctx, query, firstCommandID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*domains.NodeCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
 		}
+		attempts = append(attempts, cmd)
 	}
+	return attempts, rows.Err()
+}
 
+// recordStatusChange appends a transition to command_status_history
+func recordStatusChange(ctx context.Context, tx pgx.Tx, commandID uuid.UUID, fromStatus *string, toStatus, source string) error {
+	query := `
+		INSERT INTO command_status_history (command_id, from_status, to_status, source)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := tx.Exec(ctx, query, commandID, fromStatus, toStatus, source); err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
 	return nil
 }
 
+// GetCommandStatusHistory retrieves the status transitions of a command in order
+func (s *Store) GetCommandStatusHistory(ctx context.Context, commandID uuid.UUID) ([]domains.CommandStatusChange, error) {
+	query := `
+		SELECT id, command_id, from_status, to_status, source, created_at
+		FROM command_status_history
+		WHERE command_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := s.pool.Query(ctx, query, commandID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []domains.CommandStatusChange
+	for rows.Next() {
+		var change domains.CommandStatusChange
+		var id uuid.UUID
+		err := rows.Scan(&change.ID, &id, &change.FromStatus, &change.ToStatus, &change.Source, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		change.CommandID = id.String()
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
 // MarkAllChunksAsFinal marks all non-final chunks for a command as final
 func (s *Store) MarkAllChunksAsFinal(ctx context.Context, commandID uuid.UUID) error {
 	query := `
@@ -209,32 +795,48 @@ func (s *Store) MarkAllChunksAsFinal(ctx context.Context, commandID uuid.UUID) e
 	return err
 }
 
+// UpdateCommandOutput records the output statistics reported for a command
+func (s *Store) UpdateCommandOutput(ctx context.Context, commandID uuid.UUID, output domains.CommandOutput) error {
+	query := `
+		UPDATE node_commands
+		SET output_bytes = $1, output_truncated = output_truncated OR $2, updated_at = $3
+		WHERE command_id = $4
+	`
+	_, err := s.pool.Exec(ctx, query, output.TotalBytes, output.Truncated, time.Now(), commandID)
+	return err
+}
+
+// MarkCommandOutputTruncated flags a command whose log chunks were dropped by the server-side cap
+func (s *Store) MarkCommandOutputTruncated(ctx context.Context, commandID uuid.UUID) error {
+	query := `UPDATE node_commands SET output_truncated = TRUE WHERE command_id = $1`
+	_, err := s.pool.Exec(ctx, query, commandID)
+	return err
+}
+
+// GetCommandLogSize returns the number of log bytes stored for a command
+func (s *Store) GetCommandLogSize(ctx context.Context, commandID uuid.UUID) (int64, error) {
+	var size int64
+	query := `SELECT COALESCE(SUM(octet_length(data)), 0) FROM command_logs WHERE command_id = $1`
+	err := s.pool.QueryRow(ctx, query, commandID).Scan(&size)
+	return size, err
+}
+
 // GetCommandByID retrieves a command by ID
 func (s *Store) GetCommandByID(ctx context.Context, commandID uuid.UUID) (*domains.NodeCommand, error) {
-	var cmd domains.NodeCommand
 	query := `
-		SELECT id, command_id, node_id, command_type, payload, status, created_at, updated_at, exit_code, error_msg
+		SELECT ` + commandColumns + `
 		FROM node_commands
 		WHERE command_id = $1
 	`
 
-	var payloadJSON []byte
-	err := s.pool.QueryRow(ctx, query, commandID).Scan(
-		&cmd.ID, &cmd.CommandID, &cmd.NodeID, &cmd.CommandType, &payloadJSON, &cmd.Status,
-		&cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
-	)
+	cmd, err := scanCommand(s.pool.QueryRow(ctx, query, commandID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-
-	if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	return &cmd, nil
+	return cmd, nil
 }
 
 // InsertLogChunks inserts log chunks with idempotency (ON CONFLICT DO NOTHING)
@@ -270,7 +872,8 @@ func (s *Store) InsertLogChunks(ctx context.Context, commandID uuid.UUID, chunks
 // GetCommandLogs retrieves logs for a command ordered by chunk_index
 // Returns all logs for the command, even if it's not finished
 // If afterChunkIndex is provided, only returns logs with chunk_index >= afterChunkIndex (inclusive)
-func (s *Store) GetCommandLogs(ctx context.Context, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
+// A non-empty tenantID returns no logs unless the command's node belongs to that tenant.
+func (s *Store) GetCommandLogs(ctx context.Context, tenantID string, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
 	query := `
 		SELECT id, command_id, chunk_index, stream, data, encoding, is_final
 		FROM command_logs
@@ -278,9 +881,17 @@ func (s *Store) GetCommandLogs(ctx context.Context, commandID uuid.UUID, afterCh
 	`
 	args := []interface{}{commandID}
 
+	if tenantID != "" {
+		args = append(args, tenantID)
+		query += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM node_commands c JOIN nodes n ON n.node_id = c.node_id
+			WHERE c.command_id = $1 AND n.tenant_id = $%d
+		)`, len(args))
+	}
+
 	if afterChunkIndex != nil {
-		query += ` AND chunk_index >= $2`
 		args = append(args, *afterChunkIndex)
+		query += fmt.Sprintf(` AND chunk_index >= $%d`, len(args))
 	}
 
 	query += ` ORDER BY chunk_index ASC, stream ASC`
@@ -346,10 +957,15 @@ func (s *Store) CleanupOldLogs(ctx context.Context, retentionDays int) error {
 	return err
 }
 
-// ListNodes retrieves all registered nodes
-func (s *Store) ListNodes(ctx context.Context) ([]domains.Node, error) {
-	query := `SELECT id, node_id, attrs, last_seen_at, disabled FROM nodes ORDER BY last_seen_at DESC`
-	rows, err := s.pool.Query(ctx, query)
+// ListNodes retrieves the nodes of a tenant, or of every tenant if tenantID is empty
+func (s *Store) ListNodes(ctx context.Context, tenantID string) ([]domains.Node, error) {
+	query := `
+		SELECT id, node_id, tenant_id, attrs, labels, last_seen_at, disabled, local_policy_hash
+		FROM nodes
+		WHERE $1 = '' OR tenant_id = $1
+		ORDER BY last_seen_at DESC
+	`
+	rows, err := s.pool.Query(ctx, query, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -359,7 +975,7 @@ func (s *Store) ListNodes(ctx context.Context) ([]domains.Node, error) {
 	for rows.Next() {
 		var node domains.Node
 		err := rows.Scan(
-			&node.ID, &node.NodeID, &node.Attrs, &node.LastSeenAt, &node.Disabled,
+			&node.ID, &node.NodeID, &node.TenantID, &node.Attrs, &node.Labels, &node.LastSeenAt, &node.Disabled, &node.LocalPolicyHash,
 		)
 		if err != nil {
 			return nil, err
@@ -369,63 +985,73 @@ func (s *Store) ListNodes(ctx context.Context) ([]domains.Node, error) {
 	return nodes, rows.Err()
 }
 
-// DeleteQueuedCommands deletes all queued commands and their associated log chunks
-func (s *Store) DeleteQueuedCommands(ctx context.Context, nodeID *string) (int, error) {
+// DeleteQueuedCommands deletes queued commands and their associated log chunks, optionally only those of a
+// node; a non-empty tenantID limits the deletion to the nodes of that tenant
+func (s *Store) DeleteQueuedCommands(ctx context.Context, tenantID string, nodeID *string) (int, error) {
+	filter := `status = 'queued' AND ($1::text IS NULL OR node_id = $1)
+		AND ($2 = '' OR node_id IN (SELECT node_id FROM nodes WHERE tenant_id = $2))`
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
 	// Delete associated log chunks using subquery
-	if nodeID != nil {
-		_, err := s.pool.Exec(ctx, `
-			DELETE FROM command_logs 
-			WHERE command_id IN (
-				SELECT command_id FROM node_commands 
-				WHERE status = 'queued' AND node_id = $1
-			)
-		`, *nodeID)
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		_, err := s.pool.Exec(ctx, `
-			DELETE FROM command_logs 
-			WHERE command_id IN (
-				SELECT command_id FROM node_commands 
-				WHERE status = 'queued'
-			)
-		`)
-		if err != nil {
-			return 0, err
-		}
+	_, err = tx.Exec(ctx, `DELETE FROM command_logs WHERE command_id IN (SELECT command_id FROM node_commands WHERE `+filter+`)`, nodeID, tenantID)
+	if err != nil {
+		return 0, err
 	}
 
-	// Delete commands and get count
-	var deleteQuery string
-	if nodeID != nil {
-		deleteQuery = `DELETE FROM node_commands WHERE status = 'queued' AND node_id = $1`
-		result, err := s.pool.Exec(ctx, deleteQuery, *nodeID)
-		if err != nil {
-			return 0, err
-		}
-		return int(result.RowsAffected()), nil
-	} else {
-		deleteQuery = `DELETE FROM node_commands WHERE status = 'queued'`
-		result, err := s.pool.Exec(ctx, deleteQuery)
-		if err != nil {
-			return 0, err
+	result, err := tx.Exec(ctx, `DELETE FROM node_commands WHERE `+filter, nodeID, tenantID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// CountCommandsByStatus counts commands per command type and status
+func (s *Store) CountCommandsByStatus(ctx context.Context) ([]domains.CommandCount, error) {
+	rows, err := s.pool.Query(ctx, `SELECT command_type, status, count(*) FROM node_commands GROUP BY command_type, status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []domains.CommandCount
+	for rows.Next() {
+		var c domains.CommandCount
+		if err := rows.Scan(&c.CommandType, &c.Status, &c.Count); err != nil {
+			return nil, err
 		}
-		return int(result.RowsAffected()), nil
+		counts = append(counts, c)
 	}
+	return counts, rows.Err()
 }
 
-// ListCommands retrieves commands, optionally filtered by nodeID
-func (s *Store) ListCommands(ctx context.Context, nodeID *string, limit int) ([]domains.NodeCommand, error) {
+// ListCommands retrieves commands, optionally filtered by nodeID; a non-empty tenantID limits them to the
+// nodes of that tenant
+func (s *Store) ListCommands(ctx context.Context, tenantID string, nodeID *string, limit int) ([]domains.NodeCommand, error) {
 	query := `
-		SELECT id, command_id, node_id, command_type, payload, status, created_at, updated_at, exit_code, error_msg
+		SELECT ` + commandColumns + `
 		FROM node_commands
+		WHERE TRUE
 	`
 	args := []interface{}{}
 	argIdx := 1
 
+	if tenantID != "" {
+		query += fmt.Sprintf(` AND node_id IN (SELECT node_id FROM nodes WHERE tenant_id = $%d)`, argIdx)
+		args = append(args, tenantID)
+		argIdx++
+	}
+
 	if nodeID != nil {
-		query += ` WHERE node_id = $1`
+		query += fmt.Sprintf(` AND node_id = $%d`, argIdx)
 		args = append(args, *nodeID)
 		argIdx++
 	}
@@ -445,19 +1071,32 @@ func (s *Store) ListCommands(ctx context.Context, nodeID *string, limit int) ([]
 
 	var commands []domains.NodeCommand
 	for rows.Next() {
-		var cmd domains.NodeCommand
-		var payloadJSON []byte
-		err := rows.Scan(
-			&cmd.ID, &cmd.CommandID, &cmd.NodeID, &cmd.CommandType, &payloadJSON, &cmd.Status,
-			&cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
-		)
+		cmd, err := scanCommand(rows)
 		if err != nil {
 			return nil, err
 		}
-		if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
-		}
-		commands = append(commands, cmd)
+		commands = append(commands, *cmd)
 	}
 	return commands, rows.Err()
 }
+
+// queryCommandOutcome summarizes the commands matching a node_commands filter, using the latest attempt
+// of each command
+func (s *Store) queryCommandOutcome(ctx context.Context, filter string, args ...interface{}) (domains.CommandOutcome, error) {
+	query := `
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE status IN ('success', 'failed', 'timeout', 'cancelled', 'lost', 'expired', 'rejected')
+				AND next_retry_at IS NULL),
+			count(*) FILTER (WHERE status = 'success')
+		FROM (
+			SELECT DISTINCT ON (COALESCE(parent_command_id, command_id)) status, next_retry_at
+			FROM node_commands
+			WHERE ` + filter + `
+			ORDER BY COALESCE(parent_command_id, command_id), attempt DESC
+		) latest
+	`
+	var outcome domains.CommandOutcome
+	err := s.pool.QueryRow(ctx, query, args...).Scan(&outcome.Commands, &outcome.Finished, &outcome.Succeeded)
+	return outcome, err
+}
@@ -0,0 +1,40 @@
+//go:build postgres
+
+package postgres_test
+
+import (
+	"os"
+	"testing"
+
+	"agent-svc/app"
+	"agent-svc/storage/conformance"
+)
+
+// TestConformance needs a database, so it is only built with -tags postgres
+// It connects with the DB_* settings of the service and applies the migrations. Cases create uniquely named
+// rows and leave them behind; point DB_NAME at a scratch database.
+func TestConformance(t *testing.T) {
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.StorageBackend = app.StoragePostgres
+
+	// The migrations are found relative to agent-svc, as when the service runs
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	store, _, err := app.OpenStorage(cfg)
+	if chdirErr := os.Chdir(wd); chdirErr != nil {
+		t.Fatal(chdirErr)
+	}
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	conformance.Run(t, store)
+}
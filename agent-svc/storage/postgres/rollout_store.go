@@ -0,0 +1,270 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// rolloutColumns is the column list scanned by scanRollout
+const rolloutColumns = `id, rollout_id, tenant_id, name, command_type, payload, priority, strategy, status, next_batch_at, error_msg,
+		created_at, updated_at, finished_at`
+
+// scanRollout scans a rollouts row selected with rolloutColumns
+func scanRollout(row pgx.Row) (*domains.Rollout, error) {
+	var r domains.Rollout
+	var payloadJSON, strategyJSON []byte
+	err := row.Scan(
+		&r.ID, &r.RolloutID, &r.TenantID, &r.Name, &r.CommandType, &payloadJSON, &r.Priority, &strategyJSON, &r.Status,
+		&r.NextBatchAt, &r.ErrorMsg, &r.CreatedAt, &r.UpdatedAt, &r.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payloadJSON, &r.Payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	if err := json.Unmarshal(strategyJSON, &r.Strategy); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rollout strategy: %w", err)
+	}
+	return &r, nil
+}
+
+// CreateRollout inserts a rollout with its planned batches and fills in its generated ID
+func (s *Store) CreateRollout(ctx context.Context, r *domains.Rollout, batches []domains.RolloutBatch) error {
+	payloadJSON, err := json.Marshal(r.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	strategyJSON, err := json.Marshal(r.Strategy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollout strategy: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO rollouts (tenant_id, name, command_type, payload, priority, strategy, status)
+		VALUES ($1, $2, $3, $4::jsonb, $5, $6::jsonb, $7)
+		RETURNING id, rollout_id, created_at, updated_at
+	`
+	err = tx.QueryRow(ctx, query, r.TenantID, r.Name, r.CommandType, string(payloadJSON), r.Priority, string(strategyJSON), r.Status).
+		Scan(&r.ID, &r.RolloutID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	batchQuery := `
+		INSERT INTO rollout_batches (rollout_id, batch_index, canary, node_ids, status)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	for _, batch := range batches {
+		if _, err := tx.Exec(ctx, batchQuery, r.RolloutID, batch.BatchIndex, batch.Canary, batch.NodeIDs, batch.Status); err != nil {
+			return fmt.Errorf("failed to create rollout batch: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetRollout retrieves a rollout by ID, or nil if it doesn't exist
+func (s *Store) GetRollout(ctx context.Context, rolloutID uuid.UUID) (*domains.Rollout, error) {
+	query := `SELECT ` + rolloutColumns + ` FROM rollouts WHERE rollout_id = $1`
+
+	r, err := scanRollout(s.pool.QueryRow(ctx, query, rolloutID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRollouts retrieves the most recent rollouts of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListRollouts(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Rollout, error) {
+	query := `
+		SELECT ` + rolloutColumns + `
+		FROM rollouts
+		WHERE ($1 = '' OR tenant_id = $1) AND ($2::text IS NULL OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	rows, err := s.pool.Query(ctx, query, tenantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollouts []*domains.Rollout
+	for rows.Next() {
+		r, err := scanRollout(rows)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, r)
+	}
+	return rollouts, rows.Err()
+}
+
+// TransitionRollout moves a rollout to status to if it is currently in one of the from statuses
+// errorMsg, if not nil, replaces the recorded reason. It reports whether the transition was made.
+func (s *Store) TransitionRollout(ctx context.Context, rolloutID uuid.UUID, from []string, to string, errorMsg *string) (bool, error) {
+	query := `
+		UPDATE rollouts
+		SET status = $3,
+			error_msg = COALESCE($4, error_msg),
+			finished_at = CASE WHEN $3 IN ('aborted', 'completed') THEN now() ELSE finished_at END,
+			updated_at = now()
+		WHERE rollout_id = $1 AND status = ANY($2)
+	`
+	result, err := s.pool.Exec(ctx, query, rolloutID, from, to, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// SetRolloutNextBatchAt sets the earliest time the next batch of a rollout may start
+func (s *Store) SetRolloutNextBatchAt(ctx context.Context, rolloutID uuid.UUID, at time.Time) error {
+	query := `UPDATE rollouts SET next_batch_at = $2, updated_at = now() WHERE rollout_id = $1`
+	_, err := s.pool.Exec(ctx, query, rolloutID, at)
+	return err
+}
+
+// ListRolloutBatches retrieves the batches of a rollout in order
+func (s *Store) ListRolloutBatches(ctx context.Context, rolloutID uuid.UUID) ([]domains.RolloutBatch, error) {
+	query := `
+		SELECT id, rollout_id, batch_index, canary, node_ids, command_ids, status, succeeded_count, error_msg,
+			started_at, finished_at
+		FROM rollout_batches
+		WHERE rollout_id = $1
+		ORDER BY batch_index
+	`
+	rows, err := s.pool.Query(ctx, query, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []domains.RolloutBatch
+	for rows.Next() {
+		var batch domains.RolloutBatch
+		err := rows.Scan(
+			&batch.ID, &batch.RolloutID, &batch.BatchIndex, &batch.Canary, &batch.NodeIDs, &batch.CommandIDs,
+			&batch.Status, &batch.SucceededCount, &batch.ErrorMsg, &batch.StartedAt, &batch.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// StartRolloutBatch moves a pending batch to running
+// It only succeeds while the rollout is running and its next batch is due, and only for one caller, so
+// several agent-svc replicas never start the same batch twice. It reports whether the batch was started.
+func (s *Store) StartRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (bool, error) {
+	query := `
+		UPDATE rollout_batches
+		SET status = 'running', started_at = now()
+		WHERE rollout_id = $1 AND batch_index = $2 AND status = 'pending'
+			AND EXISTS (
+				SELECT 1 FROM rollouts
+				WHERE rollout_id = $1 AND status = 'running' AND (next_batch_at IS NULL OR next_batch_at <= now())
+			)
+	`
+	result, err := s.pool.Exec(ctx, query, rolloutID, batchIndex)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// SetRolloutBatchCommands records the commands submitted for a batch, and why some nodes got none
+func (s *Store) SetRolloutBatchCommands(ctx context.Context, rolloutID uuid.UUID, batchIndex int, commandIDs []uuid.UUID, errorMsg *string) error {
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+	query := `
+		UPDATE rollout_batches
+		SET command_ids = $3, error_msg = $4
+		WHERE rollout_id = $1 AND batch_index = $2
+	`
+	_, err := s.pool.Exec(ctx, query, rolloutID, batchIndex, commandIDs, errorMsg)
+	return err
+}
+
+// FinishRolloutBatch moves a running batch to its final status, reporting whether it was still running
+func (s *Store) FinishRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int, status string, succeededCount int) (bool, error) {
+	query := `
+		UPDATE rollout_batches
+		SET status = $3, succeeded_count = $4, finished_at = now()
+		WHERE rollout_id = $1 AND batch_index = $2 AND status = 'running'
+	`
+	result, err := s.pool.Exec(ctx, query, rolloutID, batchIndex, status, succeededCount)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// GetRolloutBatchOutcome summarizes the commands of a batch, using the latest attempt of each command
+func (s *Store) GetRolloutBatchOutcome(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (domains.CommandOutcome, error) {
+	return s.queryCommandOutcome(ctx, `rollout_id = $1 AND rollout_batch = $2`, rolloutID, batchIndex)
+}
+
+// CancelRolloutCommands cancels the queued commands of a rollout and drops its scheduled retries
+func (s *Store) CancelRolloutCommands(ctx context.Context, rolloutID uuid.UUID) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	if _, err := tx.Exec(ctx, `UPDATE node_commands SET next_retry_at = NULL WHERE rollout_id = $1 AND next_retry_at IS NOT NULL`, rolloutID); err != nil {
+		return 0, fmt.Errorf("failed to drop scheduled retries: %w", err)
+	}
+
+	query := `
+		UPDATE node_commands
+		SET status = 'cancelled', error_msg = 'rollout aborted', updated_at = $2, finished_at = $2
+		WHERE rollout_id = $1 AND status = 'queued'
+		RETURNING command_id, node_id, command_type, attempt, error_msg
+	`
+	commandIDs, err := finishCommands(ctx, tx, domains.StatusCancelled, now, query, rolloutID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(commandIDs) > 0 {
+		historyQuery := `
+			INSERT INTO command_status_history (command_id, from_status, to_status, source)
+			SELECT unnest($1::uuid[]), 'queued', 'cancelled', $2
+		`
+		if _, err := tx.Exec(ctx, historyQuery, commandIDs, domains.SourceOperator); err != nil {
+			return 0, fmt.Errorf("failed to record status history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(commandIDs), nil
+}
@@ -0,0 +1,206 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// scheduleColumns is the column list scanned by scanSchedule
+const scheduleColumns = `id, schedule_id, tenant_id, name, cron_expr, timezone, node_id, selector, command_type, payload, priority,
+		misfire_policy, misfire_grace_sec, paused, next_run_at, last_run_at, created_at, updated_at`
+
+// scanSchedule scans a schedules row selected with scheduleColumns
+func scanSchedule(row pgx.Row) (*domains.Schedule, error) {
+	var sched domains.Schedule
+	var selectorJSON, payloadJSON []byte
+	err := row.Scan(
+		&sched.ID, &sched.ScheduleID, &sched.TenantID, &sched.Name, &sched.CronExpr, &sched.Timezone, &sched.NodeID, &selectorJSON,
+		&sched.CommandType, &payloadJSON, &sched.Priority,
+		&sched.MisfirePolicy, &sched.MisfireGraceSec, &sched.Paused, &sched.NextRunAt, &sched.LastRunAt,
+		&sched.CreatedAt, &sched.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if selectorJSON != nil {
+		if err := json.Unmarshal(selectorJSON, &sched.Selector); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal selector: %w", err)
+		}
+	}
+	if err := json.Unmarshal(payloadJSON, &sched.Payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	return &sched, nil
+}
+
+// CreateSchedule inserts a schedule and fills in its generated ID and timestamps
+func (s *Store) CreateSchedule(ctx context.Context, sched *domains.Schedule) error {
+	payloadJSON, err := json.Marshal(sched.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var selectorJSON []byte
+	if sched.Selector != nil {
+		if selectorJSON, err = json.Marshal(sched.Selector); err != nil {
+			return fmt.Errorf("failed to marshal selector: %w", err)
+		}
+	}
+
+	query := `
+		INSERT INTO schedules (tenant_id, name, cron_expr, timezone, node_id, selector, command_type, payload, priority,
+			misfire_policy, misfire_grace_sec, paused, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8::jsonb, $9, $10, $11, $12, $13)
+		RETURNING id, schedule_id, created_at, updated_at
+	`
+	return s.pool.QueryRow(ctx, query,
+		sched.TenantID, sched.Name, sched.CronExpr, sched.Timezone, sched.NodeID, selectorJSON, sched.CommandType, string(payloadJSON),
+		sched.Priority, sched.MisfirePolicy, sched.MisfireGraceSec, sched.Paused, sched.NextRunAt,
+	).Scan(&sched.ID, &sched.ScheduleID, &sched.CreatedAt, &sched.UpdatedAt)
+}
+
+// GetSchedule retrieves a schedule by ID, or nil if it doesn't exist
+func (s *Store) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*domains.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE schedule_id = $1`
+
+	sched, err := scanSchedule(s.pool.QueryRow(ctx, query, scheduleID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+// ListSchedules retrieves the schedules of a tenant, or of every tenant, ordered by name
+func (s *Store) ListSchedules(ctx context.Context, tenantID string) ([]*domains.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE $1 = '' OR tenant_id = $1 ORDER BY name, created_at`
+	return s.querySchedules(ctx, query, tenantID)
+}
+
+// ListDueSchedules retrieves up to limit unpaused schedules whose next run is at or before now
+func (s *Store) ListDueSchedules(ctx context.Context, now time.Time, limit int) ([]*domains.Schedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM schedules
+		WHERE NOT paused AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2
+	`
+	return s.querySchedules(ctx, query, now, limit)
+}
+
+// querySchedules runs a query selecting scheduleColumns
+func (s *Store) querySchedules(ctx context.Context, query string, args ...interface{}) ([]*domains.Schedule, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*domains.Schedule
+	for rows.Next() {
+		sched, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, rows.Err()
+}
+
+// AdvanceSchedule moves a schedule's next run from expected to next
+// It only succeeds if next_run_at still equals expected, so when several agent-svc replicas see the
+// same due schedule exactly one of them claims the fire time. It reports whether the claim succeeded.
+func (s *Store) AdvanceSchedule(ctx context.Context, scheduleID uuid.UUID, expected, next time.Time) (bool, error) {
+	query := `
+		UPDATE schedules
+		SET next_run_at = $3, last_run_at = now(), updated_at = now()
+		WHERE schedule_id = $1 AND next_run_at = $2 AND NOT paused
+	`
+	result, err := s.pool.Exec(ctx, query, scheduleID, expected, next)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule; nextRunAt is the next fire time after resuming, nil when pausing
+// It reports whether the schedule exists.
+func (s *Store) SetSchedulePaused(ctx context.Context, scheduleID uuid.UUID, paused bool, nextRunAt *time.Time) (bool, error) {
+	query := `
+		UPDATE schedules
+		SET paused = $2, next_run_at = $3, updated_at = now()
+		WHERE schedule_id = $1
+	`
+	result, err := s.pool.Exec(ctx, query, scheduleID, paused, nextRunAt)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// DeleteSchedule deletes a schedule and its run history, reporting whether it existed
+func (s *Store) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) (bool, error) {
+	result, err := s.pool.Exec(ctx, `DELETE FROM schedules WHERE schedule_id = $1`, scheduleID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// InsertScheduleRun records a firing of a schedule
+func (s *Store) InsertScheduleRun(ctx context.Context, run *domains.ScheduleRun) error {
+	commandIDs := run.CommandIDs
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+
+	query := `
+		INSERT INTO schedule_runs (schedule_id, scheduled_for, trigger, status, command_ids, error_msg)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	return s.pool.QueryRow(ctx, query,
+		run.ScheduleID, run.ScheduledFor, run.Trigger, run.Status, commandIDs, run.ErrorMsg,
+	).Scan(&run.ID, &run.CreatedAt)
+}
+
+// ListScheduleRuns retrieves the most recent runs of a schedule, newest first
+func (s *Store) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]domains.ScheduleRun, error) {
+	query := `
+		SELECT id, schedule_id, scheduled_for, trigger, status, command_ids, error_msg, created_at
+		FROM schedule_runs
+		WHERE schedule_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := s.pool.Query(ctx, query, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []domains.ScheduleRun
+	for rows.Next() {
+		var run domains.ScheduleRun
+		err := rows.Scan(
+			&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Trigger, &run.Status, &run.CommandIDs, &run.ErrorMsg,
+			&run.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
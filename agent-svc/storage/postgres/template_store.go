@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// templateColumns is the column list scanned by scanTemplate
+const templateColumns = `id, tenant_id, name, version, description, command_type, payload, params, created_at`
+
+// scanTemplate scans a command_templates row selected with templateColumns
+func scanTemplate(row pgx.Row) (*domains.CommandTemplate, error) {
+	var t domains.CommandTemplate
+	var payloadJSON, paramsJSON []byte
+	err := row.Scan(&t.ID, &t.TenantID, &t.Name, &t.Version, &t.Description, &t.CommandType, &payloadJSON, &paramsJSON, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payloadJSON, &t.Payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	if err := json.Unmarshal(paramsJSON, &t.Params); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template params: %w", err)
+	}
+	return &t, nil
+}
+
+// CreateCommandTemplate stores a template as the next version of its name in its tenant and fills in the version
+func (s *Store) CreateCommandTemplate(ctx context.Context, t *domains.CommandTemplate) error {
+	payloadJSON, err := json.Marshal(t.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	params := t.Params
+	if params == nil {
+		params = []domains.TemplateParam{}
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template params: %w", err)
+	}
+
+	query := `
+		INSERT INTO command_templates (tenant_id, name, version, description, command_type, payload, params)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5::jsonb, $6::jsonb
+		FROM command_templates
+		WHERE tenant_id = $1 AND name = $2
+		ON CONFLICT (tenant_id, name, version) DO NOTHING
+		RETURNING id, version, created_at
+	`
+	// A concurrent create of the same name can take the version first; try the next one
+	for attempt := 0; attempt < 3; attempt++ {
+		err = s.pool.QueryRow(ctx, query, t.TenantID, t.Name, t.Description, t.CommandType, string(payloadJSON), string(paramsJSON)).
+			Scan(&t.ID, &t.Version, &t.CreatedAt)
+		if err != pgx.ErrNoRows {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to allocate a version for template %s", t.Name)
+}
+
+// GetCommandTemplate retrieves a version of a tenant's template, or its latest version if version is nil
+// It returns nil if no such template exists.
+func (s *Store) GetCommandTemplate(ctx context.Context, tenantID, name string, version *int) (*domains.CommandTemplate, error) {
+	query := `
+		SELECT ` + templateColumns + `
+		FROM command_templates
+		WHERE tenant_id = $1 AND name = $2 AND ($3::int IS NULL OR version = $3)
+		ORDER BY version DESC
+		LIMIT 1
+	`
+	t, err := scanTemplate(s.pool.QueryRow(ctx, query, tenantID, name, version))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListCommandTemplates retrieves the latest version of every template of a tenant, or of every tenant, ordered by name
+func (s *Store) ListCommandTemplates(ctx context.Context, tenantID string) ([]*domains.CommandTemplate, error) {
+	query := `
+		SELECT DISTINCT ON (name, tenant_id) ` + templateColumns + `
+		FROM command_templates
+		WHERE $1 = '' OR tenant_id = $1
+		ORDER BY name, tenant_id, version DESC
+	`
+	return s.queryTemplates(ctx, query, tenantID)
+}
+
+// ListCommandTemplateVersions retrieves all versions of a tenant's template, newest first
+func (s *Store) ListCommandTemplateVersions(ctx context.Context, tenantID, name string) ([]*domains.CommandTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM command_templates WHERE tenant_id = $1 AND name = $2 ORDER BY version DESC`
+	return s.queryTemplates(ctx, query, tenantID, name)
+}
+
+// queryTemplates runs a query selecting templateColumns
+func (s *Store) queryTemplates(ctx context.Context, query string, args ...interface{}) ([]*domains.CommandTemplate, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []*domains.CommandTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// tenantColumns is the column list scanned by scanTenant
+const tenantColumns = `id, tenant_id, name, enrollment_key_hash, max_nodes, max_commands_per_day, created_at, updated_at`
+
+// commandsLastDayQuery counts the first attempts submitted to a tenant's nodes in the last 24 hours
+const commandsLastDayQuery = `
+	SELECT count(*)
+	FROM node_commands c
+	JOIN nodes n ON n.node_id = c.node_id
+	WHERE n.tenant_id = $1 AND c.attempt = 1 AND c.created_at > now() - interval '24 hours'
+`
+
+// scanTenant scans a tenants row selected with tenantColumns
+func scanTenant(row pgx.Row) (*domains.Tenant, error) {
+	var t domains.Tenant
+	err := row.Scan(&t.ID, &t.TenantID, &t.Name, &t.EnrollmentKeyHash, &t.MaxNodes, &t.MaxCommandsPerDay, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// checkCommandQuota returns domains.ErrTenantQuotaExceeded if the tenant of a node has used up its daily
+// command quota. Tenants with a quota are locked until tx ends so concurrent submissions are counted one
+// at a time; unlimited tenants aren't locked.
+func checkCommandQuota(ctx context.Context, tx pgx.Tx, nodeID string) error {
+	var tenantID string
+	var maxCommands int
+	query := `
+		SELECT t.tenant_id, t.max_commands_per_day
+		FROM nodes n
+		JOIN tenants t ON t.tenant_id = n.tenant_id
+		WHERE n.node_id = $1
+	`
+	err := tx.QueryRow(ctx, query, nodeID).Scan(&tenantID, &maxCommands)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+	if err != nil || maxCommands == 0 {
+		return err
+	}
+
+	// Re-read the quota under the lock, in case it changed meanwhile
+	err = tx.QueryRow(ctx, `SELECT max_commands_per_day FROM tenants WHERE tenant_id = $1 FOR UPDATE`, tenantID).Scan(&maxCommands)
+	if err != nil || maxCommands == 0 {
+		return err
+	}
+
+	var commands int
+	if err := tx.QueryRow(ctx, commandsLastDayQuery, tenantID).Scan(&commands); err != nil {
+		return err
+	}
+	if commands >= maxCommands {
+		return domains.CommandQuotaError(tenantID, maxCommands)
+	}
+	return nil
+}
+
+// CreateTenant inserts a tenant and fills in its generated ID and timestamps
+// It returns domains.ErrTenantExists if the tenant ID is taken.
+func (s *Store) CreateTenant(ctx context.Context, t *domains.Tenant) error {
+	query := `
+		INSERT INTO tenants (tenant_id, name, enrollment_key_hash, max_nodes, max_commands_per_day)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id) DO NOTHING
+		RETURNING id, created_at, updated_at
+	`
+	err := s.pool.QueryRow(ctx, query, t.TenantID, t.Name, t.EnrollmentKeyHash, t.MaxNodes, t.MaxCommandsPerDay).
+		Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return domains.ErrTenantExists
+	}
+	return err
+}
+
+// GetTenant retrieves a tenant by ID, or nil if it doesn't exist
+func (s *Store) GetTenant(ctx context.Context, tenantID string) (*domains.Tenant, error) {
+	t, err := scanTenant(s.pool.QueryRow(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE tenant_id = $1`, tenantID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTenants retrieves all tenants ordered by ID
+func (s *Store) ListTenants(ctx context.Context) ([]*domains.Tenant, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []*domains.Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// UpdateTenant stores the name, enrollment key and quotas of a tenant, reporting whether it exists
+// Lowering a quota below the current usage doesn't remove nodes or commands; it only blocks new ones.
+func (s *Store) UpdateTenant(ctx context.Context, t *domains.Tenant) (bool, error) {
+	query := `
+		UPDATE tenants
+		SET name = $2, enrollment_key_hash = $3, max_nodes = $4, max_commands_per_day = $5, updated_at = now()
+		WHERE tenant_id = $1
+		RETURNING updated_at
+	`
+	err := s.pool.QueryRow(ctx, query, t.TenantID, t.Name, t.EnrollmentKeyHash, t.MaxNodes, t.MaxCommandsPerDay).Scan(&t.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetTenantUsage counts the nodes of a tenant and the commands submitted to them in the last 24 hours
+func (s *Store) GetTenantUsage(ctx context.Context, tenantID string) (domains.TenantUsage, error) {
+	var usage domains.TenantUsage
+	if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM nodes WHERE tenant_id = $1`, tenantID).Scan(&usage.Nodes); err != nil {
+		return usage, err
+	}
+	err := s.pool.QueryRow(ctx, commandsLastDayQuery, tenantID).Scan(&usage.CommandsPerDay)
+	return usage, err
+}
+
+// AddTenantOperator makes an operator a member of a tenant; adding an existing member is a no-op
+func (s *Store) AddTenantOperator(ctx context.Context, tenantID, operatorID string) error {
+	query := `
+		INSERT INTO tenant_operators (tenant_id, operator_id)
+		VALUES ($1, $2)
+		ON CONFLICT (tenant_id, operator_id) DO NOTHING
+	`
+	_, err := s.pool.Exec(ctx, query, tenantID, operatorID)
+	return err
+}
+
+// RemoveTenantOperator removes an operator from a tenant, reporting whether it was a member
+func (s *Store) RemoveTenantOperator(ctx context.Context, tenantID, operatorID string) (bool, error) {
+	result, err := s.pool.Exec(ctx, `DELETE FROM tenant_operators WHERE tenant_id = $1 AND operator_id = $2`, tenantID, operatorID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ListTenantOperators retrieves the operators of a tenant ordered by ID
+func (s *Store) ListTenantOperators(ctx context.Context, tenantID string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT operator_id FROM tenant_operators WHERE tenant_id = $1 ORDER BY operator_id`, tenantID)
+}
+
+// ListOperatorTenants retrieves the IDs of the tenants an operator belongs to, ordered by ID
+func (s *Store) ListOperatorTenants(ctx context.Context, operatorID string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT tenant_id FROM tenant_operators WHERE operator_id = $1 ORDER BY tenant_id`, operatorID)
+}
+
+// queryStrings runs a query selecting a single text column
+func (s *Store) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,443 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// webhookColumns is the column list scanned by scanWebhook
+const webhookColumns = `id, webhook_id, tenant_id, url, event_types, secret, enabled, created_at, updated_at`
+
+// scanWebhook scans a webhook_subscriptions row selected with webhookColumns
+func scanWebhook(row pgx.Row) (*domains.WebhookSubscription, error) {
+	var w domains.WebhookSubscription
+	err := row.Scan(&w.ID, &w.WebhookID, &w.TenantID, &w.URL, &w.EventTypes, &w.Secret, &w.Enabled, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// deliveryColumns is the column list scanned by scanDelivery, selected from webhook_deliveries d
+// joined with webhook_events e
+const deliveryColumns = `d.id, d.delivery_id, d.webhook_id, d.event_id, e.event_type, d.status, d.attempts,
+		d.next_attempt_at, d.last_response_code, d.last_error, d.delivered_at, d.created_at, d.updated_at`
+
+// scanDelivery scans a row selected with deliveryColumns
+func scanDelivery(row pgx.Row) (*domains.WebhookDelivery, error) {
+	var d domains.WebhookDelivery
+	err := row.Scan(
+		&d.ID, &d.DeliveryID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastResponseCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// insertWebhookEvent adds an event to the webhook outbox as part of tx, so it is only published if the
+// change it describes is committed. The event belongs to the tenant of the node named by its node_id.
+func insertWebhookEvent(ctx context.Context, tx pgx.Tx, eventType string, payload map[string]interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+	nodeID, _ := payload["node_id"].(string)
+	query := `
+		INSERT INTO webhook_events (tenant_id, event_type, payload)
+		VALUES (COALESCE((SELECT tenant_id FROM nodes WHERE node_id = $1), ''), $2, $3::jsonb)
+	`
+	if _, err := tx.Exec(ctx, query, nodeID, eventType, string(payloadJSON)); err != nil {
+		return fmt.Errorf("failed to record webhook event: %w", err)
+	}
+	return nil
+}
+
+// commandEvent is the part of a command published when it reaches a terminal status
+type commandEvent struct {
+	CommandID   uuid.UUID
+	NodeID      string
+	CommandType string
+	Status      string
+	Attempt     int
+	ExitCode    *int
+	ErrorMsg    *string
+	FinishedAt  time.Time
+}
+
+// insertCommandEvent adds the command.<status> event for a command that reached a terminal status
+func insertCommandEvent(ctx context.Context, tx pgx.Tx, ev commandEvent) error {
+	return insertWebhookEvent(ctx, tx, domains.CommandEventType(ev.Status), map[string]interface{}{
+		"command_id":   ev.CommandID.String(),
+		"node_id":      ev.NodeID,
+		"command_type": ev.CommandType,
+		"status":       ev.Status,
+		"attempt":      ev.Attempt,
+		"exit_code":    ev.ExitCode,
+		"error_msg":    ev.ErrorMsg,
+		"finished_at":  ev.FinishedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// CreateWebhook inserts a webhook subscription and fills in its generated ID
+func (s *Store) CreateWebhook(ctx context.Context, w *domains.WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (tenant_id, url, event_types, secret, enabled)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, webhook_id, created_at, updated_at
+	`
+	return s.pool.QueryRow(ctx, query, w.TenantID, w.URL, w.EventTypes, w.Secret, w.Enabled).
+		Scan(&w.ID, &w.WebhookID, &w.CreatedAt, &w.UpdatedAt)
+}
+
+// GetWebhook retrieves a webhook subscription by ID, or nil if it doesn't exist
+func (s *Store) GetWebhook(ctx context.Context, webhookID uuid.UUID) (*domains.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE webhook_id = $1`
+
+	w, err := scanWebhook(s.pool.QueryRow(ctx, query, webhookID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListWebhooks retrieves the webhook subscriptions of a tenant, or of every tenant, oldest first
+func (s *Store) ListWebhooks(ctx context.Context, tenantID string) ([]*domains.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE $1 = '' OR tenant_id = $1 ORDER BY created_at`
+	rows, err := s.pool.Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*domains.WebhookSubscription
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// SetWebhookEnabled enables or disables a webhook subscription, reporting whether it exists
+// Events are not fanned out to a disabled subscription and its pending deliveries wait until it is enabled.
+func (s *Store) SetWebhookEnabled(ctx context.Context, webhookID uuid.UUID, enabled bool) (bool, error) {
+	query := `UPDATE webhook_subscriptions SET enabled = $2, updated_at = now() WHERE webhook_id = $1`
+	result, err := s.pool.Exec(ctx, query, webhookID, enabled)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// DeleteWebhook deletes a webhook subscription and its delivery log, reporting whether it existed
+func (s *Store) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (bool, error) {
+	result, err := s.pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE webhook_id = $1`, webhookID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// FanOutWebhookEvents creates a pending delivery of each new outbox event for every enabled subscription
+// of the event's tenant whose filters match it, handling at most limit events. Events are locked with SKIP LOCKED so replicas
+// share the work. It returns the number of deliveries created.
+func (s *Store) FanOutWebhookEvents(ctx context.Context, limit int) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT event_id, tenant_id, event_type
+		FROM webhook_events
+		WHERE fanned_out_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var eventIDs []uuid.UUID
+	var eventTenants, eventTypes []string
+	for rows.Next() {
+		var eventID uuid.UUID
+		var tenantID, eventType string
+		if err := rows.Scan(&eventID, &tenantID, &eventType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		eventIDs = append(eventIDs, eventID)
+		eventTenants = append(eventTenants, tenantID)
+		eventTypes = append(eventTypes, eventType)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(eventIDs) == 0 {
+		return 0, nil
+	}
+
+	rows, err = tx.Query(ctx, `SELECT webhook_id, tenant_id, event_types FROM webhook_subscriptions WHERE enabled`)
+	if err != nil {
+		return 0, err
+	}
+	type subscription struct {
+		webhookID  uuid.UUID
+		tenantID   string
+		eventTypes []string
+	}
+	var subscriptions []subscription
+	for rows.Next() {
+		var sub subscription
+		if err := rows.Scan(&sub.webhookID, &sub.tenantID, &sub.eventTypes); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var deliveryWebhooks, deliveryEvents []uuid.UUID
+	for i, eventID := range eventIDs {
+		for _, sub := range subscriptions {
+			if sub.tenantID == eventTenants[i] && domains.MatchesEventType(sub.eventTypes, eventTypes[i]) {
+				deliveryWebhooks = append(deliveryWebhooks, sub.webhookID)
+				deliveryEvents = append(deliveryEvents, eventID)
+			}
+		}
+	}
+
+	created := 0
+	if len(deliveryEvents) > 0 {
+		deliveryQuery := `
+			INSERT INTO webhook_deliveries (webhook_id, event_id, status, next_attempt_at)
+			SELECT unnest($1::uuid[]), unnest($2::uuid[]), 'pending', now()
+			ON CONFLICT (webhook_id, event_id) DO NOTHING
+		`
+		result, err := tx.Exec(ctx, deliveryQuery, deliveryWebhooks, deliveryEvents)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create webhook deliveries: %w", err)
+		}
+		created = int(result.RowsAffected())
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE webhook_events SET fanned_out_at = now() WHERE event_id = ANY($1)`, eventIDs); err != nil {
+		return 0, fmt.Errorf("failed to mark webhook events fanned out: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries that are due, pushing their next attempt
+// back by lease so no other replica sends them meanwhile. A delivery whose sender dies before recording the
+// attempt becomes due again once the lease runs out.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domains.WebhookDeliveryJob, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries
+			SET next_attempt_at = $2, updated_at = now()
+			WHERE id IN (
+				SELECT d.id
+				FROM webhook_deliveries d
+				JOIN webhook_subscriptions s ON s.webhook_id = d.webhook_id
+				WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND s.enabled
+				ORDER BY d.next_attempt_at
+				LIMIT $1
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING delivery_id, attempts, webhook_id, event_id
+		)
+		SELECT c.delivery_id, c.attempts + 1, s.url, s.secret, e.id, e.event_id, e.event_type, e.payload, e.created_at
+		FROM claimed c
+		JOIN webhook_subscriptions s ON s.webhook_id = c.webhook_id
+		JOIN webhook_events e ON e.event_id = c.event_id
+	`
+	rows, err := s.pool.Query(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []domains.WebhookDeliveryJob
+	for rows.Next() {
+		var job domains.WebhookDeliveryJob
+		var payloadJSON []byte
+		err := rows.Scan(
+			&job.DeliveryID, &job.Attempt, &job.URL, &job.Secret,
+			&job.Event.ID, &job.Event.EventID, &job.Event.EventType, &payloadJSON, &job.Event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payloadJSON, &job.Event.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event payload: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RecordWebhookDeliveryAttempt records the outcome of a delivery attempt
+// status is delivered, dead, or pending with nextAttemptAt set for a retry.
+func (s *Store) RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID uuid.UUID, attempt int, status string, responseCode *int, errorMsg *string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = $2, status = $3, last_response_code = $4, last_error = $5, next_attempt_at = $6,
+			delivered_at = CASE WHEN $3 = 'delivered' THEN now() ELSE delivered_at END,
+			updated_at = now()
+		WHERE delivery_id = $1
+	`
+	_, err := s.pool.Exec(ctx, query, deliveryID, attempt, status, responseCode, errorMsg, nextAttemptAt)
+	return err
+}
+
+// GetWebhookDelivery retrieves a delivery by ID, or nil if it doesn't exist
+func (s *Store) GetWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (*domains.WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.delivery_id = $1
+	`
+	d, err := scanDelivery(s.pool.QueryRow(ctx, query, deliveryID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries retrieves the most recent deliveries of a subscription, optionally filtered by status
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status *string, limit int) ([]*domains.WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.webhook_id = $1 AND ($2::text IS NULL OR d.status = $2)
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $3
+	`
+	rows, err := s.pool.Query(ctx, query, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*domains.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RetryWebhookDelivery makes a dead delivery due again, reporting whether it was dead
+func (s *Store) RetryWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', next_attempt_at = now(), updated_at = now()
+		WHERE delivery_id = $1 AND status = 'dead'
+	`
+	result, err := s.pool.Exec(ctx, query, deliveryID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// DeleteOldWebhookEvents deletes fanned-out events older than retention days along with their deliveries,
+// keeping events that still have a pending delivery
+func (s *Store) DeleteOldWebhookEvents(ctx context.Context, retentionDays int) error {
+	query := `
+		DELETE FROM webhook_events e
+		WHERE e.fanned_out_at IS NOT NULL AND e.created_at < $1
+			AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.event_id AND d.status = 'pending')
+	`
+	_, err := s.pool.Exec(ctx, query, time.Now().AddDate(0, 0, -retentionDays))
+	return err
+}
+
+// MarkOfflineNodes marks enabled nodes not seen for offlineAfter as offline, emitting a node.offline event
+// for each in the same transaction. A node is only reported once until a heartbeat brings it back.
+func (s *Store) MarkOfflineNodes(ctx context.Context, offlineAfter time.Duration) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE nodes
+		SET offline_at = now()
+		WHERE offline_at IS NULL AND NOT COALESCE(disabled, FALSE) AND last_seen_at < $1
+		RETURNING node_id, last_seen_at
+	`
+	rows, err := tx.Query(ctx, query, time.Now().Add(-offlineAfter))
+	if err != nil {
+		return 0, err
+	}
+
+	type offlineNode struct {
+		nodeID     string
+		lastSeenAt time.Time
+	}
+	var nodes []offlineNode
+	for rows.Next() {
+		var n offlineNode
+		if err := rows.Scan(&n.nodeID, &n.lastSeenAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, n := range nodes {
+		err := insertWebhookEvent(ctx, tx, domains.EventNodeOffline, map[string]interface{}{
+			"node_id":      n.nodeID,
+			"last_seen_at": n.lastSeenAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(nodes), nil
+}
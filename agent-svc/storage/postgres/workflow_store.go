@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// workflowColumns is the column list scanned by scanWorkflow
+const workflowColumns = `id, workflow_id, tenant_id, name, definition, status, created_at, updated_at, finished_at`
+
+// scanWorkflow scans a workflows row selected with workflowColumns
+func scanWorkflow(row pgx.Row) (*domains.Workflow, error) {
+	var wf domains.Workflow
+	var definitionJSON []byte
+	err := row.Scan(&wf.ID, &wf.WorkflowID, &wf.TenantID, &wf.Name, &definitionJSON, &wf.Status, &wf.CreatedAt, &wf.UpdatedAt, &wf.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(definitionJSON, &wf.Definition); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workflow definition: %w", err)
+	}
+	return &wf, nil
+}
+
+// CreateWorkflow inserts a workflow run with all of its steps pending and fills in its generated ID
+func (s *Store) CreateWorkflow(ctx context.Context, wf *domains.Workflow) error {
+	definitionJSON, err := json.Marshal(wf.Definition)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow definition: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO workflows (tenant_id, name, definition, status)
+		VALUES ($1, $2, $3::jsonb, $4)
+		RETURNING id, workflow_id, created_at, updated_at
+	`
+	err = tx.QueryRow(ctx, query, wf.TenantID, wf.Name, string(definitionJSON), wf.Status).
+		Scan(&wf.ID, &wf.WorkflowID, &wf.CreatedAt, &wf.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	stepIDs := make([]string, len(wf.Definition.Steps))
+	for i, step := range wf.Definition.Steps {
+		stepIDs[i] = step.ID
+	}
+	stepsQuery := `
+		INSERT INTO workflow_steps (workflow_id, step_id, status)
+		SELECT $1, unnest($2::text[]), $3
+	`
+	if _, err := tx.Exec(ctx, stepsQuery, wf.WorkflowID, stepIDs, domains.StepPending); err != nil {
+		return fmt.Errorf("failed to create workflow steps: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetWorkflow retrieves a workflow run by ID, or nil if it doesn't exist
+func (s *Store) GetWorkflow(ctx context.Context, workflowID uuid.UUID) (*domains.Workflow, error) {
+	query := `SELECT ` + workflowColumns + ` FROM workflows WHERE workflow_id = $1`
+
+	wf, err := scanWorkflow(s.pool.QueryRow(ctx, query, workflowID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// ListWorkflows retrieves the most recent workflow runs of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListWorkflows(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Workflow, error) {
+	query := `
+		SELECT ` + workflowColumns + `
+		FROM workflows
+		WHERE ($1 = '' OR tenant_id = $1) AND ($2::text IS NULL OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	rows, err := s.pool.Query(ctx, query, tenantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workflows []*domains.Workflow
+	for rows.Next() {
+		wf, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, wf)
+	}
+	return workflows, rows.Err()
+}
+
+// FinishWorkflow moves a running workflow to its final status, reporting whether it was still running
+func (s *Store) FinishWorkflow(ctx context.Context, workflowID uuid.UUID, status string) (bool, error) {
+	query := `
+		UPDATE workflows
+		SET status = $2, finished_at = now(), updated_at = now()
+		WHERE workflow_id = $1 AND status = 'running'
+	`
+	result, err := s.pool.Exec(ctx, query, workflowID, status)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ListWorkflowSteps retrieves the step states of a workflow run
+func (s *Store) ListWorkflowSteps(ctx context.Context, workflowID uuid.UUID) ([]domains.WorkflowStepState, error) {
+	query := `
+		SELECT id, workflow_id, step_id, status, command_ids, error_msg, started_at, finished_at
+		FROM workflow_steps
+		WHERE workflow_id = $1
+		ORDER BY id
+	`
+	rows, err := s.pool.Query(ctx, query, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []domains.WorkflowStepState
+	for rows.Next() {
+		var step domains.WorkflowStepState
+		err := rows.Scan(
+			&step.ID, &step.WorkflowID, &step.StepID, &step.Status, &step.CommandIDs, &step.ErrorMsg,
+			&step.StartedAt, &step.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// TransitionWorkflowStep moves a step from one status to another
+// It only succeeds if the step is still in the from status, so concurrent engines move a step at most once.
+// It reports whether the transition was made.
+func (s *Store) TransitionWorkflowStep(ctx context.Context, workflowID uuid.UUID, stepID, from, to string, errorMsg *string) (bool, error) {
+	query := `
+		UPDATE workflow_steps
+		SET status = $4,
+			error_msg = COALESCE($5, error_msg),
+			started_at = CASE WHEN $4 = 'running' THEN now() ELSE started_at END,
+			finished_at = CASE WHEN $4 IN ('succeeded', 'failed', 'skipped') THEN now() ELSE finished_at END
+		WHERE workflow_id = $1 AND step_id = $2 AND status = $3
+	`
+	result, err := s.pool.Exec(ctx, query, workflowID, stepID, from, to, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// SetWorkflowStepCommands records the commands submitted for a step
+func (s *Store) SetWorkflowStepCommands(ctx context.Context, workflowID uuid.UUID, stepID string, commandIDs []uuid.UUID) error {
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+	query := `UPDATE workflow_steps SET command_ids = $3 WHERE workflow_id = $1 AND step_id = $2`
+	_, err := s.pool.Exec(ctx, query, workflowID, stepID, commandIDs)
+	return err
+}
+
+// GetWorkflowStepOutcome summarizes the commands of a step, using the latest attempt of each command
+func (s *Store) GetWorkflowStepOutcome(ctx context.Context, workflowID uuid.UUID, stepID string) (domains.CommandOutcome, error) {
+	return s.queryCommandOutcome(ctx, `workflow_id = $1 AND workflow_step_id = $2`, workflowID, stepID)
+}
@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// approvalPolicyColumns is the column list scanned by scanApprovalPolicy
+const approvalPolicyColumns = `id, policy_id, name, description, command_type, payload_pattern, node_labels, timeout_sec, created_at`
+
+// scanApprovalPolicy scans an approval_policies row selected with approvalPolicyColumns
+func scanApprovalPolicy(row scanner) (*domains.ApprovalPolicy, error) {
+	var p domains.ApprovalPolicy
+	err := row.Scan(&p.ID, &p.PolicyID, &p.Name, &p.Description, &p.CommandType, &p.PayloadPattern,
+		jsonColumn{&p.NodeLabels, "node labels"}, &p.TimeoutSec, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// approvalColumns is the column list scanned by scanApproval, selected from command_approvals a joined with
+// node_commands c
+const approvalColumns = `a.command_id, c.node_id, c.command_type, a.policy_id, a.policy_name, a.requested_by, a.expires_at,
+		a.decision, a.decided_by, a.comment, a.decided_at, a.created_at`
+
+// scanApproval scans a row selected with approvalColumns
+func scanApproval(row scanner) (*domains.CommandApproval, error) {
+	var a domains.CommandApproval
+	err := row.Scan(&a.CommandID, &a.NodeID, &a.CommandType, &a.PolicyID, &a.PolicyName, &a.RequestedBy, &a.ExpiresAt,
+		&a.Decision, &a.DecidedBy, &a.Comment, &a.DecidedAt, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// CreateApprovalPolicy inserts an approval policy and fills in its generated ID
+func (s *Store) CreateApprovalPolicy(ctx context.Context, p *domains.ApprovalPolicy) error {
+	labels := p.NodeLabels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labelsJSON, err := encodeJSON(labels, "node labels")
+	if err != nil {
+		return err
+	}
+
+	policyID, created := uuid.New(), now()
+	query := `
+		INSERT INTO approval_policies (policy_id, name, description, command_type, payload_pattern, node_labels, timeout_sec, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.ExecContext(ctx, query, policyID, p.Name, p.Description, p.CommandType, p.PayloadPattern, labelsJSON,
+		p.TimeoutSec, created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	p.ID, p.PolicyID, p.CreatedAt = id, policyID, created
+	return nil
+}
+
+// GetApprovalPolicy retrieves an approval policy by ID, or nil if it doesn't exist
+func (s *Store) GetApprovalPolicy(ctx context.Context, policyID uuid.UUID) (*domains.ApprovalPolicy, error) {
+	query := `SELECT ` + approvalPolicyColumns + ` FROM approval_policies WHERE policy_id = ?`
+
+	p, err := scanApprovalPolicy(s.db.QueryRowContext(ctx, query, policyID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListApprovalPolicies retrieves all approval policies, oldest first
+func (s *Store) ListApprovalPolicies(ctx context.Context) ([]*domains.ApprovalPolicy, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+approvalPolicyColumns+` FROM approval_policies ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*domains.ApprovalPolicy
+	for rows.Next() {
+		p, err := scanApprovalPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// DeleteApprovalPolicy deletes an approval policy; approval requests made under it are kept
+func (s *Store) DeleteApprovalPolicy(ctx context.Context, policyID uuid.UUID) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM approval_policies WHERE policy_id = ?`, policyID)
+	return affectedOne(result, err)
+}
+
+// GetCommandApproval retrieves the approval request of a command, or nil if it was submitted without one
+func (s *Store) GetCommandApproval(ctx context.Context, commandID uuid.UUID) (*domains.CommandApproval, error) {
+	query := `
+		SELECT ` + approvalColumns + `
+		FROM command_approvals a
+		JOIN node_commands c ON c.command_id = a.command_id
+		WHERE a.command_id = ?
+	`
+	a, err := scanApproval(s.db.QueryRowContext(ctx, query, commandID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListPendingApprovals retrieves up to limit approval requests still waiting for a decision, oldest first;
+// a non-empty tenantID limits them to the nodes of that tenant
+func (s *Store) ListPendingApprovals(ctx context.Context, tenantID string, limit int) ([]*domains.CommandApproval, error) {
+	query := `
+		SELECT ` + approvalColumns + `
+		FROM command_approvals a
+		JOIN node_commands c ON c.command_id = a.command_id
+		WHERE c.status = 'pending_approval'
+			AND (?1 = '' OR c.node_id IN (SELECT node_id FROM nodes WHERE tenant_id = ?1))
+		ORDER BY c.created_at, c.id
+		LIMIT ?2
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []*domains.CommandApproval
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
+
+// DecideCommandApproval records an operator's decision on a command pending approval, queueing an approved
+// command and rejecting a rejected one. It returns false if the command is not pending approval.
+func (s *Store) DecideCommandApproval(ctx context.Context, commandID uuid.UUID, decision, operatorID string, comment *string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	status := domains.StatusQueued
+	var errorMsg *string
+	if decision != domains.ApprovalApproved {
+		msg := "approval rejected by " + operatorID
+		status, errorMsg = domains.StatusRejected, &msg
+	}
+
+	decided := now()
+	commandQuery := `
+		UPDATE node_commands
+		SET status = ?2, error_msg = ?3, updated_at = ?4, finished_at = CASE WHEN ?2 = 'rejected' THEN ?4 END
+		WHERE command_id = ?1 AND status = 'pending_approval'
+			AND command_id IN (SELECT command_id FROM command_approvals WHERE decision IS NULL)
+		RETURNING node_id, command_type, attempt
+	`
+	ev := commandEvent{CommandID: commandID, Status: status, ErrorMsg: errorMsg, FinishedAt: decided}
+	err = tx.QueryRowContext(ctx, commandQuery, commandID, status, errorMsg, decided).Scan(&ev.NodeID, &ev.CommandType, &ev.Attempt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	approvalQuery := `UPDATE command_approvals SET decision = ?, decided_by = ?, comment = ?, decided_at = ? WHERE command_id = ?`
+	if _, err := tx.ExecContext(ctx, approvalQuery, decision, operatorID, comment, decided, commandID); err != nil {
+		return false, err
+	}
+
+	pending := domains.StatusPendingApproval
+	if err := recordStatusChange(ctx, tx, commandID, &pending, status, domains.SourceOperator); err != nil {
+		return false, err
+	}
+	if status == domains.StatusRejected {
+		if err := insertCommandEvent(ctx, tx, ev); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// ExpireCommandApprovals moves commands whose approval request passed its expiry undecided to expired
+func (s *Store) ExpireCommandApprovals(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	expired := now()
+	approvalQuery := `
+		UPDATE command_approvals
+		SET decision = 'expired', decided_at = ?1
+		WHERE decision IS NULL AND expires_at <= ?1
+			AND command_id IN (SELECT command_id FROM node_commands WHERE status = 'pending_approval')
+	`
+	if _, err := tx.ExecContext(ctx, approvalQuery, expired); err != nil {
+		return 0, fmt.Errorf("failed to expire approval requests: %w", err)
+	}
+
+	query := `
+		UPDATE node_commands
+		SET status = 'expired', error_msg = 'approval request expired', updated_at = ?1, finished_at = ?1
+		WHERE status = 'pending_approval'
+			AND command_id IN (SELECT command_id FROM command_approvals WHERE decision = 'expired' AND decided_at = ?1)
+		RETURNING command_id, node_id, command_type, attempt, error_msg
+	`
+	count, err := finishCommands(ctx, tx, domains.StatusPendingApproval, domains.StatusExpired, domains.SourceSystem, expired, query, expired)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// CreateCommandPolicy stores a policy as the next version and fills in its ID and version
+func (s *Store) CreateCommandPolicy(ctx context.Context, p *domains.CommandPolicy) error {
+	rules := p.Rules
+	if rules == nil {
+		rules = []domains.PolicyRule{}
+	}
+	rulesJSON, err := encodeJSON(rules, "policy rules")
+	if err != nil {
+		return err
+	}
+
+	policyID, created := uuid.New(), now()
+	query := `
+		INSERT INTO command_policies (policy_id, version, default_action, rules, created_by, created_at)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ? FROM command_policies
+		RETURNING id, version
+	`
+	err = s.db.QueryRowContext(ctx, query, policyID, p.DefaultAction, rulesJSON, p.CreatedBy, created).Scan(&p.ID, &p.Version)
+	if err != nil {
+		return err
+	}
+
+	p.PolicyID, p.CreatedAt = policyID, created
+	return nil
+}
+
+// GetCommandPolicy retrieves the latest version of the command policy, or nil if none was ever stored
+func (s *Store) GetCommandPolicy(ctx context.Context) (*domains.CommandPolicy, error) {
+	query := `
+		SELECT id, policy_id, version, default_action, rules, created_by, created_at
+		FROM command_policies
+		ORDER BY version DESC
+		LIMIT 1
+	`
+	var p domains.CommandPolicy
+	err := s.db.QueryRowContext(ctx, query).Scan(&p.ID, &p.PolicyID, &p.Version, &p.DefaultAction,
+		jsonColumn{&p.Rules, "policy rules"}, &p.CreatedBy, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS command_templates;
+DROP TABLE IF EXISTS schedule_runs;
+DROP TABLE IF EXISTS schedules;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS command_status_history;
+DROP TABLE IF EXISTS agent_metadata;
+DROP TABLE IF EXISTS command_logs;
+DROP TABLE IF EXISTS node_commands;
+DROP TABLE IF EXISTS rollout_batches;
+DROP TABLE IF EXISTS rollouts;
+DROP TABLE IF EXISTS workflow_steps;
+DROP TABLE IF EXISTS workflows;
+DROP TABLE IF EXISTS nodes;
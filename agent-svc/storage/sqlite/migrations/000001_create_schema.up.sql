@@ -0,0 +1,231 @@
+-- The Postgres schema as of its migration 17. UUIDs are TEXT, JSONB and arrays are JSON TEXT and timestamps
+-- are written by the store in UTC, so they compare correctly as text.
+CREATE TABLE IF NOT EXISTS nodes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  node_id TEXT UNIQUE NOT NULL,
+  attrs TEXT NOT NULL DEFAULT '{}',
+  last_seen_at TIMESTAMP,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  offline_at TIMESTAMP  -- set when the node monitor reports a node offline, cleared by its next heartbeat
+);
+
+CREATE TABLE IF NOT EXISTS workflows (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  workflow_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'running',  -- running|succeeded|failed
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workflow_steps (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  workflow_id TEXT NOT NULL REFERENCES workflows(workflow_id) ON DELETE CASCADE,
+  step_id TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',  -- pending|running|succeeded|failed|skipped
+  command_ids TEXT NOT NULL DEFAULT '[]',
+  error_msg TEXT,
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  UNIQUE(workflow_id, step_id)
+);
+
+CREATE TABLE IF NOT EXISTS rollouts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rollout_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 5,
+  strategy TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'running',  -- running|paused|halted|aborted|completed
+  next_batch_at TIMESTAMP,
+  error_msg TEXT,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rollout_batches (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rollout_id TEXT NOT NULL REFERENCES rollouts(rollout_id) ON DELETE CASCADE,
+  batch_index INTEGER NOT NULL,
+  canary BOOLEAN NOT NULL DEFAULT FALSE,
+  node_ids TEXT NOT NULL,
+  command_ids TEXT NOT NULL DEFAULT '[]',
+  status TEXT NOT NULL DEFAULT 'pending',  -- pending|running|succeeded|failed
+  succeeded_count INTEGER NOT NULL DEFAULT 0,
+  error_msg TEXT,
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  UNIQUE(rollout_id, batch_index)
+);
+
+CREATE TABLE IF NOT EXISTS node_commands (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  command_id TEXT UNIQUE NOT NULL,
+  node_id TEXT NOT NULL REFERENCES nodes(node_id),
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'queued',
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  exit_code INTEGER,
+  error_msg TEXT,
+  output_bytes INTEGER,
+  output_truncated BOOLEAN NOT NULL DEFAULT FALSE,
+  dispatched_at TIMESTAMP,
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  expires_at TIMESTAMP,
+  priority INTEGER NOT NULL DEFAULT 5,
+  parent_command_id TEXT REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  attempt INTEGER NOT NULL DEFAULT 1,
+  retry_policy TEXT,
+  next_retry_at TIMESTAMP,
+  workflow_id TEXT REFERENCES workflows(workflow_id) ON DELETE SET NULL,
+  workflow_step_id TEXT,
+  rollout_id TEXT REFERENCES rollouts(rollout_id) ON DELETE SET NULL,
+  rollout_batch INTEGER,
+  template_name TEXT,
+  template_version INTEGER,
+  trace_context TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_node_commands_queued_priority ON node_commands(node_id, priority DESC, created_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_node_commands_queued_expiry ON node_commands(expires_at) WHERE status = 'queued' AND expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_node_commands_parent ON node_commands(parent_command_id) WHERE parent_command_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_node_commands_next_retry ON node_commands(next_retry_at) WHERE next_retry_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_node_commands_workflow ON node_commands(workflow_id, workflow_step_id) WHERE workflow_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_node_commands_rollout ON node_commands(rollout_id, rollout_batch) WHERE rollout_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS command_logs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  command_id TEXT NOT NULL REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  chunk_index INTEGER NOT NULL,
+  stream TEXT CHECK (stream IN ('stdout','stderr')) NOT NULL DEFAULT 'stdout',
+  data TEXT NOT NULL,
+  encoding TEXT NOT NULL DEFAULT 'utf-8',
+  is_final BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (command_id, chunk_index, stream)
+);
+CREATE INDEX IF NOT EXISTS idx_command_logs_created_at ON command_logs(created_at);
+
+CREATE TABLE IF NOT EXISTS agent_metadata (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  node_id TEXT NOT NULL REFERENCES nodes(node_id) ON DELETE CASCADE,
+  os_name TEXT, os_version TEXT, arch TEXT, kernel_version TEXT,
+  hostname TEXT, ip_address TEXT, cpu_cores INTEGER, memory_mb INTEGER, disk_gb INTEGER,
+  last_updated TIMESTAMP,
+  UNIQUE (node_id)
+);
+
+CREATE TABLE IF NOT EXISTS command_status_history (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  command_id TEXT NOT NULL REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  from_status TEXT,
+  to_status TEXT NOT NULL,
+  source TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_command_status_history_commandid ON command_status_history(command_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  operator_id TEXT NOT NULL DEFAULT '',
+  node_id TEXT NOT NULL,
+  idem_key TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  command_id TEXT NOT NULL REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  UNIQUE (operator_id, node_id, idem_key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+CREATE TABLE IF NOT EXISTS schedules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  schedule_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  cron_expr TEXT NOT NULL,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  node_id TEXT REFERENCES nodes(node_id) ON DELETE CASCADE,
+  selector TEXT,
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 5,
+  misfire_policy TEXT NOT NULL DEFAULT 'run_once',
+  misfire_grace_sec INTEGER NOT NULL DEFAULT 300,
+  paused BOOLEAN NOT NULL DEFAULT FALSE,
+  next_run_at TIMESTAMP,
+  last_run_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  CONSTRAINT chk_schedule_target CHECK ((node_id IS NULL) <> (selector IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run ON schedules(next_run_at) WHERE NOT paused;
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  schedule_id TEXT NOT NULL REFERENCES schedules(schedule_id) ON DELETE CASCADE,
+  scheduled_for TIMESTAMP NOT NULL,
+  trigger TEXT NOT NULL,
+  status TEXT NOT NULL,
+  command_ids TEXT NOT NULL DEFAULT '[]',
+  error_msg TEXT,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule ON schedule_runs(schedule_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS command_templates (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  params TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE(name, version)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook_id TEXT UNIQUE NOT NULL,
+  url TEXT NOT NULL,
+  event_types TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  event_id TEXT UNIQUE NOT NULL,
+  event_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  fanned_out_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_events_pending ON webhook_events(id) WHERE fanned_out_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  delivery_id TEXT UNIQUE NOT NULL,
+  webhook_id TEXT NOT NULL REFERENCES webhook_subscriptions(webhook_id) ON DELETE CASCADE,
+  event_id TEXT NOT NULL REFERENCES webhook_events(event_id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP,
+  last_response_code INTEGER,
+  last_error TEXT,
+  delivered_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  UNIQUE(webhook_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at DESC);
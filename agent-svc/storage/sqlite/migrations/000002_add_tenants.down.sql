@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_node_commands_node_created;
+DROP INDEX IF EXISTS idx_nodes_tenant_id;
+ALTER TABLE nodes DROP COLUMN tenant_id;
+DROP TABLE IF EXISTS tenant_operators;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,29 @@
+-- Postgres migration 18. nodes.tenant_id has no REFERENCES clause because SQLite can only add a foreign key
+-- column with a NULL default; the store checks the tenant exists when it enrolls a node.
+CREATE TABLE IF NOT EXISTS tenants (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tenant_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  enrollment_key_hash TEXT NOT NULL DEFAULT '',
+  max_nodes INTEGER NOT NULL DEFAULT 0,
+  max_commands_per_day INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO tenants (tenant_id, name, created_at, updated_at)
+VALUES ('default', 'Default', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+ON CONFLICT (tenant_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS tenant_operators (
+  tenant_id TEXT NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  operator_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (tenant_id, operator_id)
+);
+CREATE INDEX IF NOT EXISTS idx_tenant_operators_operator ON tenant_operators(operator_id);
+
+ALTER TABLE nodes ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_nodes_tenant_id ON nodes(tenant_id);
+
+CREATE INDEX IF NOT EXISTS idx_node_commands_node_created ON node_commands(node_id, created_at) WHERE attempt = 1;
@@ -0,0 +1,2 @@
+-- Postgres migration 19
+ALTER TABLE nodes ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,26 @@
+-- Postgres migration 20
+CREATE TABLE IF NOT EXISTS approval_policies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  policy_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL DEFAULT '',
+  payload_pattern TEXT NOT NULL DEFAULT '',
+  node_labels TEXT NOT NULL DEFAULT '{}',
+  timeout_sec INTEGER NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS command_approvals (
+  command_id TEXT PRIMARY KEY REFERENCES node_commands(command_id) ON DELETE CASCADE,
+  policy_id TEXT NOT NULL,
+  policy_name TEXT NOT NULL,
+  requested_by TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMP NOT NULL,
+  decision TEXT,
+  decided_by TEXT,
+  comment TEXT,
+  decided_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_command_approvals_pending ON command_approvals(expires_at) WHERE decision IS NULL;
@@ -0,0 +1,10 @@
+-- Postgres migration 21
+CREATE TABLE IF NOT EXISTS command_policies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  policy_id TEXT UNIQUE NOT NULL,
+  version INTEGER UNIQUE NOT NULL,
+  default_action TEXT NOT NULL,
+  rules TEXT NOT NULL DEFAULT '[]',
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Postgres migration 22
+ALTER TABLE nodes ADD COLUMN local_policy_hash TEXT NOT NULL DEFAULT '';
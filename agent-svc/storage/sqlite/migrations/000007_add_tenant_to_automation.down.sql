@@ -0,0 +1,25 @@
+ALTER TABLE webhook_events DROP COLUMN tenant_id;
+ALTER TABLE webhook_subscriptions DROP COLUMN tenant_id;
+
+CREATE TABLE command_templates_old (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  params TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE(name, version)
+);
+INSERT OR IGNORE INTO command_templates_old (id, name, version, description, command_type, payload, params, created_at)
+SELECT id, name, version, description, command_type, payload, params, created_at FROM command_templates;
+DROP TABLE command_templates;
+ALTER TABLE command_templates_old RENAME TO command_templates;
+
+DROP INDEX IF EXISTS idx_rollouts_tenant_id;
+ALTER TABLE rollouts DROP COLUMN tenant_id;
+DROP INDEX IF EXISTS idx_workflows_tenant_id;
+ALTER TABLE workflows DROP COLUMN tenant_id;
+DROP INDEX IF EXISTS idx_schedules_tenant_id;
+ALTER TABLE schedules DROP COLUMN tenant_id;
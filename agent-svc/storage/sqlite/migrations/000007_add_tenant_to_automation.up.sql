@@ -0,0 +1,35 @@
+-- Postgres migration 23. SQLite can't drop the UNIQUE(name, version) constraint of command_templates, so the
+-- table is rebuilt with templates named within a tenant.
+ALTER TABLE schedules ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_schedules_tenant_id ON schedules(tenant_id);
+
+ALTER TABLE workflows ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_workflows_tenant_id ON workflows(tenant_id);
+
+ALTER TABLE rollouts ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_rollouts_tenant_id ON rollouts(tenant_id);
+
+CREATE TABLE command_templates_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tenant_id TEXT NOT NULL DEFAULT 'default',
+  name TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  command_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  params TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE(tenant_id, name, version)
+);
+INSERT INTO command_templates_new (id, name, version, description, command_type, payload, params, created_at)
+SELECT id, name, version, description, command_type, payload, params, created_at FROM command_templates;
+DROP TABLE command_templates;
+ALTER TABLE command_templates_new RENAME TO command_templates;
+
+ALTER TABLE webhook_subscriptions ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE webhook_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+UPDATE webhook_events
+SET tenant_id = (SELECT tenant_id FROM nodes WHERE node_id = json_extract(webhook_events.payload, '$.node_id'))
+WHERE fanned_out_at IS NULL
+  AND EXISTS (SELECT 1 FROM nodes WHERE node_id = json_extract(webhook_events.payload, '$.node_id'));
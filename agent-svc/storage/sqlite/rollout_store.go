@@ -0,0 +1,271 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// rolloutColumns is the column list scanned by scanRollout
+const rolloutColumns = `id, rollout_id, tenant_id, name, command_type, payload, priority, strategy, status, next_batch_at, error_msg,
+		created_at, updated_at, finished_at`
+
+// scanRollout scans a rollouts row selected with rolloutColumns
+func scanRollout(row scanner) (*domains.Rollout, error) {
+	var r domains.Rollout
+	err := row.Scan(
+		&r.ID, &r.RolloutID, &r.TenantID, &r.Name, &r.CommandType, jsonColumn{&r.Payload, "payload"}, &r.Priority,
+		jsonColumn{&r.Strategy, "rollout strategy"}, &r.Status,
+		&r.NextBatchAt, &r.ErrorMsg, &r.CreatedAt, &r.UpdatedAt, &r.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateRollout inserts a rollout with its planned batches and fills in its generated ID
+func (s *Store) CreateRollout(ctx context.Context, r *domains.Rollout, batches []domains.RolloutBatch) error {
+	payloadJSON, err := encodeJSON(r.Payload, "payload")
+	if err != nil {
+		return err
+	}
+	strategyJSON, err := encodeJSON(r.Strategy, "rollout strategy")
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rolloutID, created := uuid.New(), now()
+	query := `
+		INSERT INTO rollouts (rollout_id, tenant_id, name, command_type, payload, priority, strategy, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := tx.ExecContext(ctx, query, rolloutID, r.TenantID, r.Name, r.CommandType, payloadJSON, r.Priority, strategyJSON, r.Status,
+		created, created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	batchQuery := `
+		INSERT INTO rollout_batches (rollout_id, batch_index, canary, node_ids, status)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	for _, batch := range batches {
+		nodeIDs := batch.NodeIDs
+		if nodeIDs == nil {
+			nodeIDs = []string{}
+		}
+		nodeIDsJSON, err := encodeJSON(nodeIDs, "node IDs")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, batchQuery, rolloutID, batch.BatchIndex, batch.Canary, nodeIDsJSON, batch.Status); err != nil {
+			return fmt.Errorf("failed to create rollout batch: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	r.ID, r.RolloutID, r.CreatedAt, r.UpdatedAt = id, rolloutID, created, created
+	return nil
+}
+
+// GetRollout retrieves a rollout by ID, or nil if it doesn't exist
+func (s *Store) GetRollout(ctx context.Context, rolloutID uuid.UUID) (*domains.Rollout, error) {
+	query := `SELECT ` + rolloutColumns + ` FROM rollouts WHERE rollout_id = ?`
+
+	r, err := scanRollout(s.db.QueryRowContext(ctx, query, rolloutID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRollouts retrieves the most recent rollouts of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListRollouts(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Rollout, error) {
+	query := `
+		SELECT ` + rolloutColumns + `
+		FROM rollouts
+		WHERE (?1 = '' OR tenant_id = ?1) AND (?2 IS NULL OR status = ?2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?3
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollouts []*domains.Rollout
+	for rows.Next() {
+		r, err := scanRollout(rows)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, r)
+	}
+	return rollouts, rows.Err()
+}
+
+// TransitionRollout moves a rollout to status to if it is currently in one of the from statuses
+// errorMsg, if not nil, replaces the recorded reason. It reports whether the transition was made.
+func (s *Store) TransitionRollout(ctx context.Context, rolloutID uuid.UUID, from []string, to string, errorMsg *string) (bool, error) {
+	if len(from) == 0 {
+		return false, nil
+	}
+
+	query := `
+		UPDATE rollouts
+		SET status = ?,
+			error_msg = COALESCE(?, error_msg),
+			finished_at = CASE WHEN ? IN ('aborted', 'completed') THEN ? ELSE finished_at END,
+			updated_at = ?
+		WHERE rollout_id = ? AND status IN (` + placeholders(len(from)) + `)
+	`
+	changed := now()
+	args := []interface{}{to, errorMsg, to, changed, changed, rolloutID}
+	for _, status := range from {
+		args = append(args, status)
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	return affectedOne(result, err)
+}
+
+// SetRolloutNextBatchAt sets the earliest time the next batch of a rollout may start
+func (s *Store) SetRolloutNextBatchAt(ctx context.Context, rolloutID uuid.UUID, at time.Time) error {
+	query := `UPDATE rollouts SET next_batch_at = ?, updated_at = ? WHERE rollout_id = ?`
+	_, err := s.db.ExecContext(ctx, query, timestamp(at), now(), rolloutID)
+	return err
+}
+
+// ListRolloutBatches retrieves the batches of a rollout in order
+func (s *Store) ListRolloutBatches(ctx context.Context, rolloutID uuid.UUID) ([]domains.RolloutBatch, error) {
+	query := `
+		SELECT id, rollout_id, batch_index, canary, node_ids, command_ids, status, succeeded_count, error_msg,
+			started_at, finished_at
+		FROM rollout_batches
+		WHERE rollout_id = ?
+		ORDER BY batch_index
+	`
+	rows, err := s.db.QueryContext(ctx, query, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []domains.RolloutBatch
+	for rows.Next() {
+		var batch domains.RolloutBatch
+		err := rows.Scan(
+			&batch.ID, &batch.RolloutID, &batch.BatchIndex, &batch.Canary, jsonColumn{&batch.NodeIDs, "node IDs"},
+			jsonColumn{&batch.CommandIDs, "command IDs"},
+			&batch.Status, &batch.SucceededCount, &batch.ErrorMsg, &batch.StartedAt, &batch.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// StartRolloutBatch moves a pending batch to running
+// It only succeeds while the rollout is running and its next batch is due, and only for one caller.
+// It reports whether the batch was started.
+func (s *Store) StartRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (bool, error) {
+	query := `
+		UPDATE rollout_batches
+		SET status = 'running', started_at = ?3
+		WHERE rollout_id = ?1 AND batch_index = ?2 AND status = 'pending'
+			AND EXISTS (
+				SELECT 1 FROM rollouts
+				WHERE rollout_id = ?1 AND status = 'running' AND (next_batch_at IS NULL OR next_batch_at <= ?3)
+			)
+	`
+	result, err := s.db.ExecContext(ctx, query, rolloutID, batchIndex, now())
+	return affectedOne(result, err)
+}
+
+// SetRolloutBatchCommands records the commands submitted for a batch, and why some nodes got none
+func (s *Store) SetRolloutBatchCommands(ctx context.Context, rolloutID uuid.UUID, batchIndex int, commandIDs []uuid.UUID, errorMsg *string) error {
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+	commandIDsJSON, err := encodeJSON(commandIDs, "command IDs")
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE rollout_batches
+		SET command_ids = ?, error_msg = ?
+		WHERE rollout_id = ? AND batch_index = ?
+	`
+	_, err = s.db.ExecContext(ctx, query, commandIDsJSON, errorMsg, rolloutID, batchIndex)
+	return err
+}
+
+// FinishRolloutBatch moves a running batch to its final status, reporting whether it was still running
+func (s *Store) FinishRolloutBatch(ctx context.Context, rolloutID uuid.UUID, batchIndex int, status string, succeededCount int) (bool, error) {
+	query := `
+		UPDATE rollout_batches
+		SET status = ?, succeeded_count = ?, finished_at = ?
+		WHERE rollout_id = ? AND batch_index = ? AND status = 'running'
+	`
+	result, err := s.db.ExecContext(ctx, query, status, succeededCount, now(), rolloutID, batchIndex)
+	return affectedOne(result, err)
+}
+
+// GetRolloutBatchOutcome summarizes the commands of a batch, using the latest attempt of each command
+func (s *Store) GetRolloutBatchOutcome(ctx context.Context, rolloutID uuid.UUID, batchIndex int) (domains.CommandOutcome, error) {
+	return s.queryCommandOutcome(ctx, `rollout_id = ? AND rollout_batch = ?`, rolloutID, batchIndex)
+}
+
+// CancelRolloutCommands cancels the queued commands of a rollout and drops its scheduled retries
+func (s *Store) CancelRolloutCommands(ctx context.Context, rolloutID uuid.UUID) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cancelled := now()
+	if _, err := tx.ExecContext(ctx, `UPDATE node_commands SET next_retry_at = NULL WHERE rollout_id = ? AND next_retry_at IS NOT NULL`, rolloutID); err != nil {
+		return 0, fmt.Errorf("failed to drop scheduled retries: %w", err)
+	}
+
+	query := `
+		UPDATE node_commands
+		SET status = 'cancelled', error_msg = 'rollout aborted', updated_at = ?2, finished_at = ?2
+		WHERE rollout_id = ?1 AND status = 'queued'
+		RETURNING command_id, node_id, command_type, attempt, error_msg
+	`
+	count, err := finishCommands(ctx, tx, domains.StatusQueued, domains.StatusCancelled, domains.SourceOperator, cancelled, query, rolloutID, cancelled)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,212 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// scheduleColumns is the column list scanned by scanSchedule
+const scheduleColumns = `id, schedule_id, tenant_id, name, cron_expr, timezone, node_id, selector, command_type, payload, priority,
+		misfire_policy, misfire_grace_sec, paused, next_run_at, last_run_at, created_at, updated_at`
+
+// scanSchedule scans a schedules row selected with scheduleColumns
+func scanSchedule(row scanner) (*domains.Schedule, error) {
+	var sched domains.Schedule
+	err := row.Scan(
+		&sched.ID, &sched.ScheduleID, &sched.TenantID, &sched.Name, &sched.CronExpr, &sched.Timezone, &sched.NodeID,
+		jsonColumn{&sched.Selector, "selector"}, &sched.CommandType, jsonColumn{&sched.Payload, "payload"}, &sched.Priority,
+		&sched.MisfirePolicy, &sched.MisfireGraceSec, &sched.Paused, &sched.NextRunAt, &sched.LastRunAt,
+		&sched.CreatedAt, &sched.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &sched, nil
+}
+
+// CreateSchedule inserts a schedule and fills in its generated ID and timestamps
+func (s *Store) CreateSchedule(ctx context.Context, sched *domains.Schedule) error {
+	payloadJSON, err := encodeJSON(sched.Payload, "payload")
+	if err != nil {
+		return err
+	}
+
+	var selectorJSON *string
+	if sched.Selector != nil {
+		encoded, err := encodeJSON(sched.Selector, "selector")
+		if err != nil {
+			return err
+		}
+		selectorJSON = &encoded
+	}
+
+	scheduleID, created := uuid.New(), now()
+	query := `
+		INSERT INTO schedules (schedule_id, tenant_id, name, cron_expr, timezone, node_id, selector, command_type, payload,
+			priority, misfire_policy, misfire_grace_sec, paused, next_run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.ExecContext(ctx, query,
+		scheduleID, sched.TenantID, sched.Name, sched.CronExpr, sched.Timezone, sched.NodeID, selectorJSON, sched.CommandType, payloadJSON,
+		sched.Priority, sched.MisfirePolicy, sched.MisfireGraceSec, sched.Paused, timestampPtr(sched.NextRunAt),
+		created, created,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	sched.ID, sched.ScheduleID, sched.CreatedAt, sched.UpdatedAt = id, scheduleID, created, created
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID, or nil if it doesn't exist
+func (s *Store) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*domains.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE schedule_id = ?`
+
+	sched, err := scanSchedule(s.db.QueryRowContext(ctx, query, scheduleID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+// ListSchedules retrieves the schedules of a tenant, or of every tenant, ordered by name
+func (s *Store) ListSchedules(ctx context.Context, tenantID string) ([]*domains.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE ?1 = '' OR tenant_id = ?1 ORDER BY name, created_at`
+	return s.querySchedules(ctx, query, tenantID)
+}
+
+// ListDueSchedules retrieves up to limit unpaused schedules whose next run is at or before now
+func (s *Store) ListDueSchedules(ctx context.Context, now time.Time, limit int) ([]*domains.Schedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM schedules
+		WHERE NOT paused AND next_run_at <= ?
+		ORDER BY next_run_at
+		LIMIT ?
+	`
+	return s.querySchedules(ctx, query, timestamp(now), limit)
+}
+
+// querySchedules runs a query selecting scheduleColumns
+func (s *Store) querySchedules(ctx context.Context, query string, args ...interface{}) ([]*domains.Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*domains.Schedule
+	for rows.Next() {
+		sched, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, rows.Err()
+}
+
+// AdvanceSchedule moves a schedule's next run from expected to next
+// It only succeeds if next_run_at still equals expected and the schedule isn't paused, so a fire time is
+// claimed once. It reports whether the claim succeeded.
+func (s *Store) AdvanceSchedule(ctx context.Context, scheduleID uuid.UUID, expected, next time.Time) (bool, error) {
+	query := `
+		UPDATE schedules
+		SET next_run_at = ?3, last_run_at = ?4, updated_at = ?4
+		WHERE schedule_id = ?1 AND next_run_at = ?2 AND NOT paused
+	`
+	result, err := s.db.ExecContext(ctx, query, scheduleID, timestamp(expected), timestamp(next), now())
+	return affectedOne(result, err)
+}
+
+// SetSchedulePaused pauses or resumes a schedule; nextRunAt is the next fire time after resuming, nil when pausing
+// It reports whether the schedule exists.
+func (s *Store) SetSchedulePaused(ctx context.Context, scheduleID uuid.UUID, paused bool, nextRunAt *time.Time) (bool, error) {
+	query := `
+		UPDATE schedules
+		SET paused = ?, next_run_at = ?, updated_at = ?
+		WHERE schedule_id = ?
+	`
+	result, err := s.db.ExecContext(ctx, query, paused, timestampPtr(nextRunAt), now(), scheduleID)
+	return affectedOne(result, err)
+}
+
+// DeleteSchedule deletes a schedule and its run history, reporting whether it existed
+func (s *Store) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM schedules WHERE schedule_id = ?`, scheduleID)
+	return affectedOne(result, err)
+}
+
+// InsertScheduleRun records a firing of a schedule
+func (s *Store) InsertScheduleRun(ctx context.Context, run *domains.ScheduleRun) error {
+	commandIDs := run.CommandIDs
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+	commandIDsJSON, err := encodeJSON(commandIDs, "command IDs")
+	if err != nil {
+		return err
+	}
+
+	created := now()
+	query := `
+		INSERT INTO schedule_runs (schedule_id, scheduled_for, trigger, status, command_ids, error_msg, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.ExecContext(ctx, query,
+		run.ScheduleID, timestamp(run.ScheduledFor), run.Trigger, run.Status, commandIDsJSON, run.ErrorMsg, created,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	run.ID, run.CreatedAt = id, created
+	return nil
+}
+
+// ListScheduleRuns retrieves the most recent runs of a schedule, newest first
+func (s *Store) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]domains.ScheduleRun, error) {
+	query := `
+		SELECT id, schedule_id, scheduled_for, trigger, status, command_ids, error_msg, created_at
+		FROM schedule_runs
+		WHERE schedule_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := s.db.QueryContext(ctx, query, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []domains.ScheduleRun
+	for rows.Next() {
+		var run domains.ScheduleRun
+		err := rows.Scan(
+			&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Trigger, &run.Status,
+			jsonColumn{&run.CommandIDs, "command IDs"}, &run.ErrorMsg, &run.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
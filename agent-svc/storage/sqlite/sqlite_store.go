@@ -0,0 +1,1164 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SchemaVersion is the migration version this build expects; bump it with every new migration
+const SchemaVersion = 7
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Store represents the embedded SQLite storage implementation
+// The database is a single file owned by one agent-svc process; it suits single-node installs and development.
+// Connections are limited to one, so transactions are serialized and take the place of the row locks
+// (FOR UPDATE SKIP LOCKED) the Postgres store relies on.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the SQLite database at path, creating it if needed, and applies the embedded migrations
+func NewStore(path string) (*Store, error) {
+	dsn := path + "?_journal_mode=WAL&_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"
+
+	if err := runMigrations(dsn); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// runMigrations applies the embedded migrations on a connection of its own, which the driver closes
+func runMigrations(dsn string) error {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	driver, err := migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create migration driver: %w", err)
+	}
+
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		driver.Close()
+		return fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite3", driver)
+	if err != nil {
+		driver.Close()
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+// Ping checks that the database is usable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// MigrationVersion returns the applied migration version recorded by golang-migrate
+func (s *Store) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := s.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return uint(version), dirty, nil
+}
+
+// now returns the current time as it is stored
+func now() time.Time {
+	return timestamp(time.Now())
+}
+
+// timestamp converts t to the form every timestamp is stored in: UTC at the microsecond precision of a
+// Postgres timestamptz. Stored in one zone, timestamps compare correctly as text.
+func timestamp(t time.Time) time.Time {
+	return t.UTC().Round(time.Microsecond)
+}
+
+// timestampPtr is timestamp for an optional time
+func timestampPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	ts := timestamp(*t)
+	return &ts
+}
+
+// encodeJSON marshals a value stored in a JSON text column
+func encodeJSON(v interface{}, what string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s: %w", what, err)
+	}
+	return string(data), nil
+}
+
+// jsonColumn scans a JSON text column into the value dest points to, leaving it untouched on NULL
+// It stands in for the Postgres JSONB and array columns, which pgx scans directly.
+type jsonColumn struct {
+	dest interface{}
+	what string
+}
+
+// Scan implements sql.Scanner
+func (c jsonColumn) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("failed to unmarshal %s: unexpected type %T", c.what, src)
+	}
+	if err := json.Unmarshal(data, c.dest); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", c.what, err)
+	}
+	return nil
+}
+
+// placeholders returns n comma-separated bind parameters for an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// affectedOne reports whether a statement changed exactly one row, the sqlite form of RowsAffected() == 1
+func affectedOne(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// commandColumns is the column list scanned by scanCommand
+const commandColumns = `id, command_id, node_id, command_type, payload, status, created_at, updated_at, exit_code, error_msg,
+		output_bytes, output_truncated, dispatched_at, started_at, finished_at, expires_at, priority,
+		parent_command_id, attempt, retry_policy, next_retry_at, workflow_id, workflow_step_id,
+		rollout_id, rollout_batch, template_name, template_version, trace_context`
+
+// scanCommand scans a node_commands row selected with commandColumns
+func scanCommand(row scanner) (*domains.NodeCommand, error) {
+	var cmd domains.NodeCommand
+	var retryPolicy *domains.RetryPolicy
+	err := row.Scan(
+		&cmd.ID, &cmd.CommandID, &cmd.NodeID, &cmd.CommandType, jsonColumn{&cmd.Payload, "payload"}, &cmd.Status,
+		&cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
+		&cmd.OutputBytes, &cmd.OutputTruncated, &cmd.DispatchedAt, &cmd.StartedAt, &cmd.FinishedAt,
+		&cmd.ExpiresAt, &cmd.Priority,
+		&cmd.ParentCommandID, &cmd.Attempt, jsonColumn{&retryPolicy, "retry policy"}, &cmd.NextRetryAt,
+		&cmd.WorkflowID, &cmd.WorkflowStepID,
+		&cmd.RolloutID, &cmd.RolloutBatch, &cmd.TemplateName, &cmd.TemplateVersion,
+		jsonColumn{&cmd.TraceContext, "trace context"},
+	)
+	if err != nil {
+		return nil, err
+	}
+	cmd.RetryPolicy = retryPolicy
+	return &cmd, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryCommands runs a query selecting commandColumns
+func queryCommands(ctx context.Context, q querier, query string, args ...interface{}) ([]*domains.NodeCommand, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []*domains.NodeCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, rows.Err()
+}
+
+// nodeColumns is the column list scanned by scanNode
+const nodeColumns = `id, node_id, tenant_id, attrs, labels, last_seen_at, disabled, local_policy_hash`
+
+// scanNode scans a nodes row selected with nodeColumns
+func scanNode(row scanner) (*domains.Node, error) {
+	var node domains.Node
+	err := row.Scan(&node.ID, &node.NodeID, &node.TenantID, jsonColumn{&node.Attrs, "attrs"}, jsonColumn{&node.Labels, "labels"},
+		&node.LastSeenAt, &node.Disabled, &node.LocalPolicyHash)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// RegisterNode enrolls a new node into a tenant, or updates the attrs of a node already enrolled in it
+// It returns domains.ErrNodeInOtherTenant if the node ID belongs to another tenant and
+// domains.ErrTenantQuotaExceeded if a new node would exceed the tenant's node quota.
+func (s *Store) RegisterNode(ctx context.Context, tenantID, nodeID string, attrs map[string]interface{}) error {
+	attrsJSON, err := encodeJSON(attrs, "attrs")
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var maxNodes int
+	err = tx.QueryRowContext(ctx, `SELECT max_nodes FROM tenants WHERE tenant_id = ?`, tenantID).Scan(&maxNodes)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	if err != nil {
+		return err
+	}
+
+	var currentTenantID string
+	err = tx.QueryRowContext(ctx, `SELECT tenant_id FROM nodes WHERE node_id = ?`, nodeID).Scan(&currentTenantID)
+	switch {
+	case err == sql.ErrNoRows:
+		if maxNodes > 0 {
+			var nodes int
+			if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM nodes WHERE tenant_id = ?`, tenantID).Scan(&nodes); err != nil {
+				return err
+			}
+			if nodes >= maxNodes {
+				return domains.NodeQuotaError(tenantID, maxNodes)
+			}
+		}
+	case err != nil:
+		return err
+	case currentTenantID != tenantID:
+		return fmt.Errorf("%w: %s", domains.ErrNodeInOtherTenant, nodeID)
+	}
+
+	query := `
+		INSERT INTO nodes (node_id, tenant_id, attrs, last_seen_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (node_id)
+		DO UPDATE SET
+			attrs = excluded.attrs,
+			last_seen_at = excluded.last_seen_at
+	`
+	if _, err := tx.ExecContext(ctx, query, nodeID, tenantID, attrsJSON, now()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateNodeLastSeen updates the last_seen_at timestamp
+// A node the node monitor reported offline is brought back with a node.online event.
+func (s *Store) UpdateNodeLastSeen(ctx context.Context, nodeID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var offlineAt *time.Time
+	err = tx.QueryRowContext(ctx, `SELECT offline_at FROM nodes WHERE node_id = ?`, nodeID).Scan(&offlineAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE nodes SET last_seen_at = ?, offline_at = NULL WHERE node_id = ?`, now(), nodeID); err != nil {
+		return err
+	}
+
+	if offlineAt != nil {
+		err := insertWebhookEvent(ctx, tx, domains.EventNodeOnline, map[string]interface{}{
+			"node_id":    nodeID,
+			"offline_at": offlineAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetNode retrieves a node by ID
+func (s *Store) GetNode(ctx context.Context, nodeID string) (*domains.Node, error) {
+	query := `SELECT ` + nodeColumns + ` FROM nodes WHERE node_id = ?`
+
+	node, err := scanNode(s.db.QueryRowContext(ctx, query, nodeID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// UpdateNodeLabels removes and then sets labels of a node, keeping its other labels
+// It returns false if the node doesn't exist.
+func (s *Store) UpdateNodeLabels(ctx context.Context, nodeID string, set map[string]string, remove []string) (bool, error) {
+	// A JSON merge patch removes the keys it sets to null
+	patch := make(map[string]interface{}, len(set)+len(remove))
+	for _, key := range remove {
+		patch[key] = nil
+	}
+	for key, value := range set {
+		patch[key] = value
+	}
+	patchJSON, err := encodeJSON(patch, "labels")
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE nodes SET labels = json_patch(labels, ?) WHERE node_id = ?`, patchJSON, nodeID))
+}
+
+// SetNodeDisabled disables or re-enables a node; it returns false if the node doesn't exist
+func (s *Store) SetNodeDisabled(ctx context.Context, nodeID string, disabled bool) (bool, error) {
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE nodes SET disabled = ? WHERE node_id = ?`, disabled, nodeID))
+}
+
+// SetNodePolicyHash records the hash of the local policy a node reported; it returns false if the node doesn't exist
+func (s *Store) SetNodePolicyHash(ctx context.Context, nodeID, hash string) (bool, error) {
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE nodes SET local_policy_hash = ? WHERE node_id = ?`, hash, nodeID))
+}
+
+// CreateCommand creates a new command in the queue
+// It returns domains.ErrTenantQuotaExceeded if the node's tenant has used up its daily command quota.
+func (s *Store) CreateCommand(ctx context.Context, nodeID, commandType string, payload map[string]interface{}, opts domains.CommandOptions) (uuid.UUID, error) {
+	commandID := uuid.New()
+	payloadJSON, err := encodeJSON(payload, "payload")
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	priority := domains.PriorityDefault
+	if opts.Priority != nil {
+		priority = *opts.Priority
+	}
+
+	var retryPolicyJSON *string
+	if opts.RetryPolicy != nil {
+		encoded, err := encodeJSON(opts.RetryPolicy, "retry policy")
+		if err != nil {
+			return uuid.Nil, err
+		}
+		retryPolicyJSON = &encoded
+	}
+
+	var workflowStepID *string
+	if opts.WorkflowStepID != "" {
+		workflowStepID = &opts.WorkflowStepID
+	}
+
+	var rolloutBatch *int
+	if opts.RolloutID != nil {
+		rolloutBatch = &opts.RolloutBatch
+	}
+
+	var templateName *string
+	var templateVersion *int
+	if opts.TemplateName != "" {
+		templateName, templateVersion = &opts.TemplateName, &opts.TemplateVersion
+	}
+
+	var traceContextJSON *string
+	if len(opts.TraceContext) > 0 {
+		encoded, err := encodeJSON(opts.TraceContext, "trace context")
+		if err != nil {
+			return uuid.Nil, err
+		}
+		traceContextJSON = &encoded
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkCommandQuota(ctx, tx, nodeID); err != nil {
+		return uuid.Nil, err
+	}
+
+	status := domains.StatusQueued
+	if opts.Approval != nil {
+		status = domains.StatusPendingApproval
+	}
+
+	created := now()
+	query := `
+		INSERT INTO node_commands (command_id, node_id, command_type, payload, status, created_at, updated_at, expires_at,
+			priority, retry_policy, workflow_id, workflow_step_id, rollout_id, rollout_batch, template_name, template_version,
+			trace_context)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query, commandID, nodeID, commandType, payloadJSON, status, created, created,
+		timestampPtr(opts.ExpiresAt), priority, retryPolicyJSON, opts.WorkflowID, workflowStepID, opts.RolloutID,
+		rolloutBatch, templateName, templateVersion, traceContextJSON)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := recordStatusChange(ctx, tx, commandID, nil, status, domains.SourceSubmit); err != nil {
+		return uuid.Nil, err
+	}
+
+	if a := opts.Approval; a != nil {
+		approvalQuery := `
+			INSERT INTO command_approvals (command_id, policy_id, policy_name, requested_by, expires_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`
+		_, err := tx.ExecContext(ctx, approvalQuery, commandID, a.PolicyID, a.PolicyName, opts.OperatorID, timestamp(a.ExpiresAt), created)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("failed to store approval request: %w", err)
+		}
+	}
+
+	if opts.IdempotencyKey != "" {
+		// An expired key is taken over; a live one aborts the transaction so no duplicate command is created
+		keyQuery := `
+			INSERT INTO idempotency_keys (operator_id, node_id, idem_key, request_hash, command_id, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (operator_id, node_id, idem_key) DO UPDATE SET
+				request_hash = excluded.request_hash,
+				command_id = excluded.command_id,
+				created_at = excluded.created_at,
+				expires_at = excluded.expires_at
+			WHERE idempotency_keys.expires_at <= excluded.created_at
+		`
+		result, err := tx.ExecContext(ctx, keyQuery, opts.OperatorID, nodeID, opts.IdempotencyKey, opts.RequestHash, commandID,
+			created, timestamp(opts.IdempotencyExpiresAt))
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return uuid.Nil, domains.ErrDuplicateIdempotencyKey
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return commandID, nil
+}
+
+// GetIdempotencyKey retrieves an unexpired idempotency key, or nil if there is none
+func (s *Store) GetIdempotencyKey(ctx context.Context, operatorID, nodeID, key string) (*domains.IdempotencyKey, error) {
+	var k domains.IdempotencyKey
+	query := `
+		SELECT id, operator_id, node_id, idem_key, request_hash, command_id, created_at, expires_at
+		FROM idempotency_keys
+		WHERE operator_id = ? AND node_id = ? AND idem_key = ? AND expires_at > ?
+	`
+	err := s.db.QueryRowContext(ctx, query, operatorID, nodeID, key, now()).Scan(
+		&k.ID, &k.OperatorID, &k.NodeID, &k.Key, &k.RequestHash, &k.CommandID, &k.CreatedAt, &k.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes idempotency keys past their expiry
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?`, now())
+	return err
+}
+
+// GetNextCommand claims up to 5 queued commands for a node and marks them as running
+// The claim runs in one transaction, so each command is dispatched once, and commands past their
+// expires_at are never handed out even if the sweeper hasn't run yet
+func (s *Store) GetNextCommand(ctx context.Context, nodeID string) ([]*domains.NodeCommand, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	dispatched := now()
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE node_commands
+		SET status = 'running', dispatched_at = ?1, updated_at = ?1
+		WHERE id IN (
+			SELECT id FROM node_commands
+			WHERE node_id = ?2 AND status = 'queued' AND (expires_at IS NULL OR expires_at > ?1)
+			ORDER BY priority DESC, created_at ASC
+			LIMIT 5
+		)
+		RETURNING id
+	`, dispatched, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim commands: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// RETURNING columns carry no declared type, so timestamps are read back with a plain SELECT
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE id IN (` + placeholders(len(ids)) + `)
+		ORDER BY priority DESC, created_at ASC
+	`
+	commands, err := queryCommands(ctx, tx, query, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := domains.StatusQueued
+	for _, cmd := range commands {
+		if err := recordStatusChange(ctx, tx, cmd.CommandID, &queued, domains.StatusRunning, domains.SourceDispatch); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return commands, nil
+}
+
+// UpdateCommandStatus moves a command to a new status
+// The transition is validated against the current status inside the transaction and recorded in
+// command_status_history; domains.ErrInvalidStatusTransition is returned if it isn't allowed
+func (s *Store) UpdateCommandStatus(ctx context.Context, commandID uuid.UUID, status string, exitCode *int, errorMsg *string, source string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM node_commands WHERE command_id = ?`, commandID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("command not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := domains.ValidateStatusTransition(current, status); err != nil {
+		return err
+	}
+
+	isTerminal := domains.IsTerminalStatus(status)
+	changed := now()
+	query := `
+		UPDATE node_commands
+		SET status = ?1, exit_code = ?2, error_msg = ?3, updated_at = ?4,
+			started_at = CASE WHEN ?1 = 'running' THEN COALESCE(started_at, ?4) ELSE started_at END,
+			finished_at = CASE WHEN ?5 THEN ?4 ELSE finished_at END
+		WHERE command_id = ?6
+		RETURNING node_id, command_type, attempt
+	`
+	ev := commandEvent{CommandID: commandID, Status: status, ExitCode: exitCode, ErrorMsg: errorMsg, FinishedAt: changed}
+	err = tx.QueryRowContext(ctx, query, status, exitCode, errorMsg, changed, isTerminal, commandID).
+		Scan(&ev.NodeID, &ev.CommandType, &ev.Attempt)
+	if err != nil {
+		return err
+	}
+
+	if err := recordStatusChange(ctx, tx, commandID, &current, status, source); err != nil {
+		return err
+	}
+
+	if isTerminal {
+		if err := insertCommandEvent(ctx, tx, ev); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if isTerminal {
+		if err := s.MarkAllChunksAsFinal(ctx, commandID); err != nil {
+		}
+	}
+
+	return nil
+}
+
+// ExpireQueuedCommands moves queued commands past their expires_at to expired
+func (s *Store) ExpireQueuedCommands(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	expired := now()
+	query := `
+		UPDATE node_commands
+		SET status = 'expired', error_msg = 'delivery deadline passed before dispatch', updated_at = ?1, finished_at = ?1
+		WHERE status = 'queued' AND expires_at <= ?1
+		RETURNING command_id, node_id, command_type, attempt, error_msg
+	`
+	count, err := finishCommands(ctx, tx, domains.StatusQueued, domains.StatusExpired, domains.SourceSystem, expired, query, expired)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
+
+// ListLostCommands retrieves up to limit running commands, oldest first, whose node hasn't been seen for lostAfter
+func (s *Store) ListLostCommands(ctx context.Context, lostAfter time.Duration, limit int) ([]*domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE status = 'running'
+			AND node_id IN (SELECT node_id FROM nodes WHERE last_seen_at < ?)
+		ORDER BY updated_at, id
+		LIMIT ?
+	`
+	return queryCommands(ctx, s.db, query, now().Add(-lostAfter), limit)
+}
+
+// finishCommands runs an UPDATE moving commands in status from to a terminal status, which must return
+// command_id, node_id, command_type, attempt and error_msg, and records the transition and a webhook event
+// for each command. It returns the number of commands moved.
+func finishCommands(ctx context.Context, tx *sql.Tx, from, status, source string, finishedAt time.Time, query string, args ...interface{}) (int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []commandEvent
+	for rows.Next() {
+		ev := commandEvent{Status: status, FinishedAt: finishedAt}
+		if err := rows.Scan(&ev.CommandID, &ev.NodeID, &ev.CommandType, &ev.Attempt, &ev.ErrorMsg); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, ev := range events {
+		if err := recordStatusChange(ctx, tx, ev.CommandID, &from, status, source); err != nil {
+			return 0, err
+		}
+		if err := insertCommandEvent(ctx, tx, ev); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), nil
+}
+
+// ScheduleCommandRetry marks a finished attempt to be retried at the given time
+func (s *Store) ScheduleCommandRetry(ctx context.Context, commandID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE node_commands
+		SET next_retry_at = ?, updated_at = ?
+		WHERE command_id = ?
+	`
+	_, err := s.db.ExecContext(ctx, query, timestamp(at), now(), commandID)
+	return err
+}
+
+// CreateRetryAttempts queues the next attempt of up to limit commands whose retry is due
+// Due rows are cleared in the same transaction, so no attempt is ever created twice. An attempt gets as long
+// to be delivered as the previous one had, counted from its creation.
+func (s *Store) CreateRetryAttempts(ctx context.Context, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	created := now()
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE node_commands
+		SET next_retry_at = NULL, updated_at = ?1
+		WHERE id IN (
+			SELECT id FROM node_commands
+			WHERE next_retry_at <= ?1
+			ORDER BY next_retry_at
+			LIMIT ?2
+		)
+		RETURNING command_id, created_at, expires_at
+	`, created, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create retry attempts: %w", err)
+	}
+
+	type dueCommand struct {
+		commandID uuid.UUID
+		createdAt time.Time
+		expiresAt *time.Time
+	}
+	var due []dueCommand
+	for rows.Next() {
+		var d dueCommand
+		if err := rows.Scan(&d.commandID, &d.createdAt, &d.expiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO node_commands (command_id, node_id, command_type, payload, status, created_at, updated_at, expires_at,
+			priority, parent_command_id, attempt, retry_policy, workflow_id, workflow_step_id, rollout_id, rollout_batch,
+			template_name, template_version, trace_context)
+		SELECT ?1, node_id, command_type, payload, 'queued', ?2, ?2, ?4, priority,
+			COALESCE(parent_command_id, command_id), attempt + 1, retry_policy, workflow_id, workflow_step_id,
+			rollout_id, rollout_batch, template_name, template_version, trace_context
+		FROM node_commands
+		WHERE command_id = ?3
+	`
+	for _, d := range due {
+		commandID := uuid.New()
+		var expiresAt *time.Time
+		if d.expiresAt != nil {
+			t := timestamp(created.Add(d.expiresAt.Sub(d.createdAt)))
+			expiresAt = &t
+		}
+		if _, err := tx.ExecContext(ctx, query, commandID, created, d.commandID, expiresAt); err != nil {
+			return 0, fmt.Errorf("failed to create retry attempts: %w", err)
+		}
+		if err := recordStatusChange(ctx, tx, commandID, nil, domains.StatusQueued, domains.SourceSystem); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(due), nil
+}
+
+// GetCommandAttempts retrieves all attempts of a command, given its first attempt, ordered by attempt
+func (s *Store) GetCommandAttempts(ctx context.Context, firstCommandID uuid.UUID) ([]*domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE command_id = ?1 OR parent_command_id = ?1
+		ORDER BY attempt ASC
+	`
+	return queryCommands(ctx, s.db, query, firstCommandID)
+}
+
+// recordStatusChange appends a transition to command_status_history
+func recordStatusChange(ctx context.Context, tx *sql.Tx, commandID uuid.UUID, fromStatus *string, toStatus, source string) error {
+	query := `
+		INSERT INTO command_status_history (command_id, from_status, to_status, source, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	if _, err := tx.ExecContext(ctx, query, commandID, fromStatus, toStatus, source, now()); err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	return nil
+}
+
+// GetCommandStatusHistory retrieves the status transitions of a command in order
+func (s *Store) GetCommandStatusHistory(ctx context.Context, commandID uuid.UUID) ([]domains.CommandStatusChange, error) {
+	query := `
+		SELECT id, command_id, from_status, to_status, source, created_at
+		FROM command_status_history
+		WHERE command_id = ?
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := s.db.QueryContext(ctx, query, commandID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []domains.CommandStatusChange
+	for rows.Next() {
+		var change domains.CommandStatusChange
+		err := rows.Scan(&change.ID, &change.CommandID, &change.FromStatus, &change.ToStatus, &change.Source, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// MarkAllChunksAsFinal marks all non-final chunks for a command as final
+func (s *Store) MarkAllChunksAsFinal(ctx context.Context, commandID uuid.UUID) error {
+	query := `
+		UPDATE command_logs
+		SET is_final = TRUE
+		WHERE command_id = ? AND is_final = FALSE
+	`
+	_, err := s.db.ExecContext(ctx, query, commandID)
+	return err
+}
+
+// UpdateCommandOutput records the output statistics reported for a command
+func (s *Store) UpdateCommandOutput(ctx context.Context, commandID uuid.UUID, output domains.CommandOutput) error {
+	query := `
+		UPDATE node_commands
+		SET output_bytes = ?, output_truncated = output_truncated OR ?, updated_at = ?
+		WHERE command_id = ?
+	`
+	_, err := s.db.ExecContext(ctx, query, output.TotalBytes, output.Truncated, now(), commandID)
+	return err
+}
+
+// MarkCommandOutputTruncated flags a command whose log chunks were dropped by the server-side cap
+func (s *Store) MarkCommandOutputTruncated(ctx context.Context, commandID uuid.UUID) error {
+	query := `UPDATE node_commands SET output_truncated = TRUE WHERE command_id = ?`
+	_, err := s.db.ExecContext(ctx, query, commandID)
+	return err
+}
+
+// GetCommandLogSize returns the number of log bytes stored for a command
+func (s *Store) GetCommandLogSize(ctx context.Context, commandID uuid.UUID) (int64, error) {
+	var size int64
+	query := `SELECT COALESCE(SUM(length(CAST(data AS BLOB))), 0) FROM command_logs WHERE command_id = ?`
+	err := s.db.QueryRowContext(ctx, query, commandID).Scan(&size)
+	return size, err
+}
+
+// GetCommandByID retrieves a command by ID
+func (s *Store) GetCommandByID(ctx context.Context, commandID uuid.UUID) (*domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE command_id = ?
+	`
+
+	cmd, err := scanCommand(s.db.QueryRowContext(ctx, query, commandID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// InsertLogChunks inserts log chunks idempotently; a chunk sent again only updates is_final
+func (s *Store) InsertLogChunks(ctx context.Context, commandID uuid.UUID, chunks []domains.CommandLog) ([]int64, error) {
+	if len(chunks) == 0 {
+		return []int64{}, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO command_logs (command_id, chunk_index, stream, data, encoding, is_final, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (command_id, chunk_index, stream) DO UPDATE SET is_final = excluded.is_final
+		RETURNING chunk_index
+	`
+
+	ackedChunkIndexes := make([]int64, 0, len(chunks))
+	created := now()
+	for _, chunk := range chunks {
+		var chunkIndex int64
+		err := tx.QueryRowContext(ctx, query, commandID, chunk.ChunkIndex, chunk.Stream, chunk.Data, chunk.Encoding, chunk.IsFinal, created).
+			Scan(&chunkIndex)
+		if err != nil {
+			return nil, err
+		}
+		ackedChunkIndexes = append(ackedChunkIndexes, chunkIndex)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return ackedChunkIndexes, nil
+}
+
+// GetCommandLogs retrieves logs for a command ordered by chunk_index
+// Returns all logs for the command, even if it's not finished
+// If afterChunkIndex is provided, only returns logs with chunk_index >= afterChunkIndex (inclusive)
+// A non-empty tenantID returns no logs unless the command's node belongs to that tenant.
+func (s *Store) GetCommandLogs(ctx context.Context, tenantID string, commandID uuid.UUID, afterChunkIndex *int64) ([]domains.CommandLog, error) {
+	query := `
+		SELECT id, command_id, chunk_index, stream, data, encoding, is_final
+		FROM command_logs
+		WHERE command_id = ?
+	`
+	args := []interface{}{commandID}
+
+	if tenantID != "" {
+		query += ` AND EXISTS (
+			SELECT 1 FROM node_commands c JOIN nodes n ON n.node_id = c.node_id
+			WHERE c.command_id = command_logs.command_id AND n.tenant_id = ?
+		)`
+		args = append(args, tenantID)
+	}
+
+	if afterChunkIndex != nil {
+		query += ` AND chunk_index >= ?`
+		args = append(args, *afterChunkIndex)
+	}
+
+	query += ` ORDER BY chunk_index ASC, stream ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []domains.CommandLog
+	for rows.Next() {
+		var log domains.CommandLog
+		err := rows.Scan(
+			&log.ID, &log.CommandID, &log.ChunkIndex, &log.Stream, &log.Data,
+			&log.Encoding, &log.IsFinal,
+		)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	return logs, rows.Err()
+}
+
+// UpdateAgentMetadata updates or inserts agent metadata
+func (s *Store) UpdateAgentMetadata(ctx context.Context, nodeID string, metadata *domains.AgentMetadata) error {
+	query := `
+		INSERT INTO agent_metadata (
+			node_id, os_name, os_version, arch, kernel_version,
+			hostname, ip_address, cpu_cores, memory_mb, disk_gb, last_updated
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (node_id)
+		DO UPDATE SET
+			os_name = excluded.os_name,
+			os_version = excluded.os_version,
+			arch = excluded.arch,
+			kernel_version = excluded.kernel_version,
+			hostname = excluded.hostname,
+			ip_address = excluded.ip_address,
+			cpu_cores = excluded.cpu_cores,
+			memory_mb = excluded.memory_mb,
+			disk_gb = excluded.disk_gb,
+			last_updated = excluded.last_updated
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		nodeID, metadata.OSName, metadata.OSVersion, metadata.Arch, metadata.KernelVersion,
+		metadata.Hostname, metadata.IPAddress, metadata.CPUCores, metadata.MemoryMB, metadata.DiskGB, now(),
+	)
+	return err
+}
+
+// CleanupOldLogs deletes logs older than retention days
+func (s *Store) CleanupOldLogs(ctx context.Context, retentionDays int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM command_logs WHERE created_at < ?`, now().AddDate(0, 0, -retentionDays))
+	return err
+}
+
+// ListNodes retrieves the nodes of a tenant, or of every tenant if tenantID is empty
+func (s *Store) ListNodes(ctx context.Context, tenantID string) ([]domains.Node, error) {
+	query := `SELECT ` + nodeColumns + ` FROM nodes WHERE ?1 = '' OR tenant_id = ?1 ORDER BY last_seen_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []domains.Node
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, rows.Err()
+}
+
+// DeleteQueuedCommands deletes queued commands, optionally only those of a node; a non-empty tenantID limits
+// the deletion to the nodes of that tenant. Their log chunks, history and idempotency keys go with them.
+func (s *Store) DeleteQueuedCommands(ctx context.Context, tenantID string, nodeID *string) (int, error) {
+	query := `
+		DELETE FROM node_commands
+		WHERE status = 'queued' AND (?1 IS NULL OR node_id = ?1)
+			AND (?2 = '' OR node_id IN (SELECT node_id FROM nodes WHERE tenant_id = ?2))
+	`
+	result, err := s.db.ExecContext(ctx, query, nodeID, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// CountCommandsByStatus counts commands per command type and status
+func (s *Store) CountCommandsByStatus(ctx context.Context) ([]domains.CommandCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT command_type, status, count(*) FROM node_commands GROUP BY command_type, status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []domains.CommandCount
+	for rows.Next() {
+		var c domains.CommandCount
+		if err := rows.Scan(&c.CommandType, &c.Status, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// ListCommands retrieves commands, optionally filtered by nodeID; a non-empty tenantID limits them to the
+// nodes of that tenant
+func (s *Store) ListCommands(ctx context.Context, tenantID string, nodeID *string, limit int) ([]domains.NodeCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM node_commands
+		WHERE (?1 IS NULL OR node_id = ?1)
+			AND (?2 = '' OR node_id IN (SELECT node_id FROM nodes WHERE tenant_id = ?2))
+		ORDER BY created_at DESC, id DESC
+	`
+	args := []interface{}{nodeID, tenantID}
+
+	if limit > 0 {
+		query += ` LIMIT ?3`
+		args = append(args, limit)
+	}
+
+	found, err := queryCommands(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []domains.NodeCommand
+	for _, cmd := range found {
+		commands = append(commands, *cmd)
+	}
+	return commands, nil
+}
+
+// queryCommandOutcome summarizes the commands matching a node_commands filter, using the latest attempt
+// of each command
+func (s *Store) queryCommandOutcome(ctx context.Context, filter string, args ...interface{}) (domains.CommandOutcome, error) {
+	query := `
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE status IN ('success', 'failed', 'timeout', 'cancelled', 'lost', 'expired', 'rejected')
+				AND next_retry_at IS NULL),
+			count(*) FILTER (WHERE status = 'success')
+		FROM (
+			SELECT status, next_retry_at,
+				row_number() OVER (PARTITION BY COALESCE(parent_command_id, command_id) ORDER BY attempt DESC) AS latest
+			FROM node_commands
+			WHERE ` + filter + `
+		)
+		WHERE latest = 1
+	`
+	var outcome domains.CommandOutcome
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&outcome.Commands, &outcome.Finished, &outcome.Succeeded)
+	return outcome, err
+}
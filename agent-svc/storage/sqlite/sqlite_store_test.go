@@ -0,0 +1,18 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"agent-svc/storage/conformance"
+	"agent-svc/storage/sqlite"
+)
+
+func TestConformance(t *testing.T) {
+	store, err := sqlite.NewStore(filepath.Join(t.TempDir(), "conformance.db"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	conformance.Run(t, store)
+}
@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"agent-svc/app/domains"
+)
+
+// templateColumns is the column list scanned by scanTemplate
+const templateColumns = `id, tenant_id, name, version, description, command_type, payload, params, created_at`
+
+// scanTemplate scans a command_templates row selected with templateColumns
+func scanTemplate(row scanner) (*domains.CommandTemplate, error) {
+	var t domains.CommandTemplate
+	err := row.Scan(
+		&t.ID, &t.TenantID, &t.Name, &t.Version, &t.Description, &t.CommandType, jsonColumn{&t.Payload, "payload"},
+		jsonColumn{&t.Params, "template params"}, &t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateCommandTemplate stores a template as the next version of its name in its tenant and fills in the version
+func (s *Store) CreateCommandTemplate(ctx context.Context, t *domains.CommandTemplate) error {
+	payloadJSON, err := encodeJSON(t.Payload, "payload")
+	if err != nil {
+		return err
+	}
+	params := t.Params
+	if params == nil {
+		params = []domains.TemplateParam{}
+	}
+	paramsJSON, err := encodeJSON(params, "template params")
+	if err != nil {
+		return err
+	}
+
+	// Writes are serialized, so the version read by the INSERT ... SELECT can't be taken concurrently
+	created := now()
+	query := `
+		INSERT INTO command_templates (tenant_id, name, version, description, command_type, payload, params, created_at)
+		SELECT ?1, ?2, COALESCE(MAX(version), 0) + 1, ?3, ?4, ?5, ?6, ?7
+		FROM command_templates
+		WHERE tenant_id = ?1 AND name = ?2
+		RETURNING id, version
+	`
+	err = s.db.QueryRowContext(ctx, query, t.TenantID, t.Name, t.Description, t.CommandType, payloadJSON, paramsJSON, created).
+		Scan(&t.ID, &t.Version)
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = created
+	return nil
+}
+
+// GetCommandTemplate retrieves a version of a tenant's template, or its latest version if version is nil
+// It returns nil if no such template exists.
+func (s *Store) GetCommandTemplate(ctx context.Context, tenantID, name string, version *int) (*domains.CommandTemplate, error) {
+	query := `
+		SELECT ` + templateColumns + `
+		FROM command_templates
+		WHERE tenant_id = ?1 AND name = ?2 AND (?3 IS NULL OR version = ?3)
+		ORDER BY version DESC
+		LIMIT 1
+	`
+	t, err := scanTemplate(s.db.QueryRowContext(ctx, query, tenantID, name, version))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListCommandTemplates retrieves the latest version of every template of a tenant, or of every tenant, ordered by name
+func (s *Store) ListCommandTemplates(ctx context.Context, tenantID string) ([]*domains.CommandTemplate, error) {
+	query := `
+		SELECT ` + templateColumns + `
+		FROM command_templates t
+		WHERE (?1 = '' OR tenant_id = ?1)
+			AND version = (SELECT MAX(version) FROM command_templates WHERE tenant_id = t.tenant_id AND name = t.name)
+		ORDER BY name, tenant_id
+	`
+	return s.queryTemplates(ctx, query, tenantID)
+}
+
+// ListCommandTemplateVersions retrieves all versions of a tenant's template, newest first
+func (s *Store) ListCommandTemplateVersions(ctx context.Context, tenantID, name string) ([]*domains.CommandTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM command_templates WHERE tenant_id = ? AND name = ? ORDER BY version DESC`
+	return s.queryTemplates(ctx, query, tenantID, name)
+}
+
+// queryTemplates runs a query selecting templateColumns
+func (s *Store) queryTemplates(ctx context.Context, query string, args ...interface{}) ([]*domains.CommandTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []*domains.CommandTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
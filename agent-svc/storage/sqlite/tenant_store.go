@@ -0,0 +1,188 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+)
+
+// tenantColumns is the column list scanned by scanTenant
+const tenantColumns = `id, tenant_id, name, enrollment_key_hash, max_nodes, max_commands_per_day, created_at, updated_at`
+
+// commandsLastDayQuery counts the first attempts submitted to a tenant's nodes since a time
+const commandsLastDayQuery = `
+	SELECT count(*)
+	FROM node_commands c
+	JOIN nodes n ON n.node_id = c.node_id
+	WHERE n.tenant_id = ? AND c.attempt = 1 AND c.created_at > ?
+`
+
+// scanTenant scans a tenants row selected with tenantColumns
+func scanTenant(row scanner) (*domains.Tenant, error) {
+	var t domains.Tenant
+	err := row.Scan(&t.ID, &t.TenantID, &t.Name, &t.EnrollmentKeyHash, &t.MaxNodes, &t.MaxCommandsPerDay, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// lastDay returns the start of the 24 hours the daily command quota counts
+func lastDay() time.Time {
+	return now().Add(-24 * time.Hour)
+}
+
+// checkCommandQuota returns domains.ErrTenantQuotaExceeded if the tenant of a node has used up its daily
+// command quota; transactions are serialized, so submissions are counted one at a time
+func checkCommandQuota(ctx context.Context, tx *sql.Tx, nodeID string) error {
+	var tenantID string
+	var maxCommands int
+	query := `
+		SELECT t.tenant_id, t.max_commands_per_day
+		FROM nodes n
+		JOIN tenants t ON t.tenant_id = n.tenant_id
+		WHERE n.node_id = ?
+	`
+	err := tx.QueryRowContext(ctx, query, nodeID).Scan(&tenantID, &maxCommands)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+	if err != nil || maxCommands == 0 {
+		return err
+	}
+
+	var commands int
+	if err := tx.QueryRowContext(ctx, commandsLastDayQuery, tenantID, lastDay()).Scan(&commands); err != nil {
+		return err
+	}
+	if commands >= maxCommands {
+		return domains.CommandQuotaError(tenantID, maxCommands)
+	}
+	return nil
+}
+
+// CreateTenant inserts a tenant and fills in its generated ID and timestamps
+// It returns domains.ErrTenantExists if the tenant ID is taken.
+func (s *Store) CreateTenant(ctx context.Context, t *domains.Tenant) error {
+	created := now()
+	query := `
+		INSERT INTO tenants (tenant_id, name, enrollment_key_hash, max_nodes, max_commands_per_day, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?6)
+		ON CONFLICT (tenant_id) DO NOTHING
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query, t.TenantID, t.Name, t.EnrollmentKeyHash, t.MaxNodes, t.MaxCommandsPerDay, created).
+		Scan(&t.ID)
+	if err == sql.ErrNoRows {
+		return domains.ErrTenantExists
+	}
+	if err != nil {
+		return err
+	}
+	t.CreatedAt, t.UpdatedAt = created, created
+	return nil
+}
+
+// GetTenant retrieves a tenant by ID, or nil if it doesn't exist
+func (s *Store) GetTenant(ctx context.Context, tenantID string) (*domains.Tenant, error) {
+	t, err := scanTenant(s.db.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE tenant_id = ?`, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTenants retrieves all tenants ordered by ID
+func (s *Store) ListTenants(ctx context.Context) ([]*domains.Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []*domains.Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// UpdateTenant stores the name, enrollment key and quotas of a tenant, reporting whether it exists
+func (s *Store) UpdateTenant(ctx context.Context, t *domains.Tenant) (bool, error) {
+	updated := now()
+	query := `
+		UPDATE tenants
+		SET name = ?, enrollment_key_hash = ?, max_nodes = ?, max_commands_per_day = ?, updated_at = ?
+		WHERE tenant_id = ?
+	`
+	ok, err := affectedOne(s.db.ExecContext(ctx, query, t.Name, t.EnrollmentKeyHash, t.MaxNodes, t.MaxCommandsPerDay, updated, t.TenantID))
+	if ok {
+		t.UpdatedAt = updated
+	}
+	return ok, err
+}
+
+// GetTenantUsage counts the nodes of a tenant and the commands submitted to them in the last 24 hours
+func (s *Store) GetTenantUsage(ctx context.Context, tenantID string) (domains.TenantUsage, error) {
+	var usage domains.TenantUsage
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM nodes WHERE tenant_id = ?`, tenantID).Scan(&usage.Nodes); err != nil {
+		return usage, err
+	}
+	err := s.db.QueryRowContext(ctx, commandsLastDayQuery, tenantID, lastDay()).Scan(&usage.CommandsPerDay)
+	return usage, err
+}
+
+// AddTenantOperator makes an operator a member of a tenant; adding an existing member is a no-op
+func (s *Store) AddTenantOperator(ctx context.Context, tenantID, operatorID string) error {
+	query := `
+		INSERT INTO tenant_operators (tenant_id, operator_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (tenant_id, operator_id) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query, tenantID, operatorID, now())
+	return err
+}
+
+// RemoveTenantOperator removes an operator from a tenant, reporting whether it was a member
+func (s *Store) RemoveTenantOperator(ctx context.Context, tenantID, operatorID string) (bool, error) {
+	return affectedOne(s.db.ExecContext(ctx, `DELETE FROM tenant_operators WHERE tenant_id = ? AND operator_id = ?`, tenantID, operatorID))
+}
+
+// ListTenantOperators retrieves the operators of a tenant ordered by ID
+func (s *Store) ListTenantOperators(ctx context.Context, tenantID string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT operator_id FROM tenant_operators WHERE tenant_id = ? ORDER BY operator_id`, tenantID)
+}
+
+// ListOperatorTenants retrieves the IDs of the tenants an operator belongs to, ordered by ID
+func (s *Store) ListOperatorTenants(ctx context.Context, operatorID string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT tenant_id FROM tenant_operators WHERE operator_id = ? ORDER BY tenant_id`, operatorID)
+}
+
+// queryStrings runs a query selecting a single text column
+func (s *Store) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,469 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// webhookColumns is the column list scanned by scanWebhook
+const webhookColumns = `id, webhook_id, tenant_id, url, event_types, secret, enabled, created_at, updated_at`
+
+// scanWebhook scans a webhook_subscriptions row selected with webhookColumns
+func scanWebhook(row scanner) (*domains.WebhookSubscription, error) {
+	var w domains.WebhookSubscription
+	err := row.Scan(
+		&w.ID, &w.WebhookID, &w.TenantID, &w.URL, jsonColumn{&w.EventTypes, "event types"}, &w.Secret, &w.Enabled,
+		&w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// deliveryColumns is the column list scanned by scanDelivery, selected from webhook_deliveries d
+// joined with webhook_events e
+const deliveryColumns = `d.id, d.delivery_id, d.webhook_id, d.event_id, e.event_type, d.status, d.attempts,
+		d.next_attempt_at, d.last_response_code, d.last_error, d.delivered_at, d.created_at, d.updated_at`
+
+// scanDelivery scans a row selected with deliveryColumns
+func scanDelivery(row scanner) (*domains.WebhookDelivery, error) {
+	var d domains.WebhookDelivery
+	err := row.Scan(
+		&d.ID, &d.DeliveryID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastResponseCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// insertWebhookEvent adds an event to the webhook outbox as part of tx, so it is only published if the
+// change it describes is committed. The event belongs to the tenant of the node named by its node_id.
+func insertWebhookEvent(ctx context.Context, tx *sql.Tx, eventType string, payload map[string]interface{}) error {
+	payloadJSON, err := encodeJSON(payload, "event payload")
+	if err != nil {
+		return err
+	}
+	nodeID, _ := payload["node_id"].(string)
+	query := `
+		INSERT INTO webhook_events (event_id, tenant_id, event_type, payload, created_at)
+		VALUES (?, COALESCE((SELECT tenant_id FROM nodes WHERE node_id = ?), ''), ?, ?, ?)
+	`
+	if _, err := tx.ExecContext(ctx, query, uuid.New(), nodeID, eventType, payloadJSON, now()); err != nil {
+		return fmt.Errorf("failed to record webhook event: %w", err)
+	}
+	return nil
+}
+
+// commandEvent is the part of a command published when it reaches a terminal status
+type commandEvent struct {
+	CommandID   uuid.UUID
+	NodeID      string
+	CommandType string
+	Status      string
+	Attempt     int
+	ExitCode    *int
+	ErrorMsg    *string
+	FinishedAt  time.Time
+}
+
+// insertCommandEvent adds the command.<status> event for a command that reached a terminal status
+func insertCommandEvent(ctx context.Context, tx *sql.Tx, ev commandEvent) error {
+	return insertWebhookEvent(ctx, tx, domains.CommandEventType(ev.Status), map[string]interface{}{
+		"command_id":   ev.CommandID.String(),
+		"node_id":      ev.NodeID,
+		"command_type": ev.CommandType,
+		"status":       ev.Status,
+		"attempt":      ev.Attempt,
+		"exit_code":    ev.ExitCode,
+		"error_msg":    ev.ErrorMsg,
+		"finished_at":  ev.FinishedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// CreateWebhook inserts a webhook subscription and fills in its generated ID
+func (s *Store) CreateWebhook(ctx context.Context, w *domains.WebhookSubscription) error {
+	eventTypes := w.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	eventTypesJSON, err := encodeJSON(eventTypes, "event types")
+	if err != nil {
+		return err
+	}
+
+	webhookID, created := uuid.New(), now()
+	query := `
+		INSERT INTO webhook_subscriptions (webhook_id, tenant_id, url, event_types, secret, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.ExecContext(ctx, query, webhookID, w.TenantID, w.URL, eventTypesJSON, w.Secret, w.Enabled, created, created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	w.ID, w.WebhookID, w.CreatedAt, w.UpdatedAt = id, webhookID, created, created
+	return nil
+}
+
+// GetWebhook retrieves a webhook subscription by ID, or nil if it doesn't exist
+func (s *Store) GetWebhook(ctx context.Context, webhookID uuid.UUID) (*domains.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE webhook_id = ?`
+
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, query, webhookID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListWebhooks retrieves the webhook subscriptions of a tenant, or of every tenant, oldest first
+func (s *Store) ListWebhooks(ctx context.Context, tenantID string) ([]*domains.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE ?1 = '' OR tenant_id = ?1 ORDER BY created_at, id`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*domains.WebhookSubscription
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// SetWebhookEnabled enables or disables a webhook subscription, reporting whether it exists
+// Events are not fanned out to a disabled subscription and its pending deliveries wait until it is enabled.
+func (s *Store) SetWebhookEnabled(ctx context.Context, webhookID uuid.UUID, enabled bool) (bool, error) {
+	query := `UPDATE webhook_subscriptions SET enabled = ?, updated_at = ? WHERE webhook_id = ?`
+	result, err := s.db.ExecContext(ctx, query, enabled, now(), webhookID)
+	return affectedOne(result, err)
+}
+
+// DeleteWebhook deletes a webhook subscription and its delivery log, reporting whether it existed
+func (s *Store) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE webhook_id = ?`, webhookID)
+	return affectedOne(result, err)
+}
+
+// FanOutWebhookEvents creates a pending delivery of each new outbox event for every enabled subscription
+// of the event's tenant whose filters match it, handling at most limit events. It returns the number of deliveries created.
+func (s *Store) FanOutWebhookEvents(ctx context.Context, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT event_id, tenant_id, event_type
+		FROM webhook_events
+		WHERE fanned_out_at IS NULL
+		ORDER BY id
+		LIMIT ?
+	`
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var eventIDs []uuid.UUID
+	var eventTenants, eventTypes []string
+	for rows.Next() {
+		var eventID uuid.UUID
+		var tenantID, eventType string
+		if err := rows.Scan(&eventID, &tenantID, &eventType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		eventIDs = append(eventIDs, eventID)
+		eventTenants = append(eventTenants, tenantID)
+		eventTypes = append(eventTypes, eventType)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(eventIDs) == 0 {
+		return 0, nil
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT webhook_id, tenant_id, event_types FROM webhook_subscriptions WHERE enabled`)
+	if err != nil {
+		return 0, err
+	}
+	type subscription struct {
+		webhookID  uuid.UUID
+		tenantID   string
+		eventTypes []string
+	}
+	var subscriptions []subscription
+	for rows.Next() {
+		var sub subscription
+		if err := rows.Scan(&sub.webhookID, &sub.tenantID, jsonColumn{&sub.eventTypes, "event types"}); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	fannedOut := now()
+	deliveryQuery := `
+		INSERT INTO webhook_deliveries (delivery_id, webhook_id, event_id, status, next_attempt_at, created_at, updated_at)
+		VALUES (?1, ?2, ?3, 'pending', ?4, ?4, ?4)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+	`
+	created := 0
+	for i, eventID := range eventIDs {
+		for _, sub := range subscriptions {
+			if sub.tenantID != eventTenants[i] || !domains.MatchesEventType(sub.eventTypes, eventTypes[i]) {
+				continue
+			}
+			result, err := tx.ExecContext(ctx, deliveryQuery, uuid.New(), sub.webhookID, eventID, fannedOut)
+			if err != nil {
+				return 0, fmt.Errorf("failed to create webhook deliveries: %w", err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			created += int(affected)
+		}
+	}
+
+	markQuery := `UPDATE webhook_events SET fanned_out_at = ? WHERE event_id = ?`
+	for _, eventID := range eventIDs {
+		if _, err := tx.ExecContext(ctx, markQuery, fannedOut, eventID); err != nil {
+			return 0, fmt.Errorf("failed to mark webhook events fanned out: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries that are due, pushing their next attempt
+// back by lease so they aren't sent again meanwhile. A delivery whose sender dies before recording the
+// attempt becomes due again once the lease runs out.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domains.WebhookDeliveryJob, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	claimed := now()
+	query := `
+		SELECT d.delivery_id, d.attempts + 1, s.url, s.secret, e.id, e.event_id, e.event_type, e.payload, e.created_at
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.webhook_id = d.webhook_id
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? AND s.enabled
+		ORDER BY d.next_attempt_at
+		LIMIT ?
+	`
+	rows, err := tx.QueryContext(ctx, query, claimed, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []domains.WebhookDeliveryJob
+	for rows.Next() {
+		var job domains.WebhookDeliveryJob
+		err := rows.Scan(
+			&job.DeliveryID, &job.Attempt, &job.URL, &job.Secret,
+			&job.Event.ID, &job.Event.EventID, &job.Event.EventType, jsonColumn{&job.Event.Payload, "event payload"},
+			&job.Event.CreatedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	leaseQuery := `UPDATE webhook_deliveries SET next_attempt_at = ?, updated_at = ? WHERE delivery_id = ?`
+	for _, job := range jobs {
+		if _, err := tx.ExecContext(ctx, leaseQuery, timestamp(claimed.Add(lease)), claimed, job.DeliveryID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return jobs, nil
+}
+
+// RecordWebhookDeliveryAttempt records the outcome of a delivery attempt
+// status is delivered, dead, or pending with nextAttemptAt set for a retry.
+func (s *Store) RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID uuid.UUID, attempt int, status string, responseCode *int, errorMsg *string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = ?2, status = ?3, last_response_code = ?4, last_error = ?5, next_attempt_at = ?6,
+			delivered_at = CASE WHEN ?3 = 'delivered' THEN ?7 ELSE delivered_at END,
+			updated_at = ?7
+		WHERE delivery_id = ?1
+	`
+	_, err := s.db.ExecContext(ctx, query, deliveryID, attempt, status, responseCode, errorMsg, timestampPtr(nextAttemptAt), now())
+	return err
+}
+
+// GetWebhookDelivery retrieves a delivery by ID, or nil if it doesn't exist
+func (s *Store) GetWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (*domains.WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.delivery_id = ?
+	`
+	d, err := scanDelivery(s.db.QueryRowContext(ctx, query, deliveryID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries retrieves the most recent deliveries of a subscription, optionally filtered by status
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status *string, limit int) ([]*domains.WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.webhook_id = ?1 AND (?2 IS NULL OR d.status = ?2)
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT ?3
+	`
+	rows, err := s.db.QueryContext(ctx, query, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*domains.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RetryWebhookDelivery makes a dead delivery due again, reporting whether it was dead
+func (s *Store) RetryWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', next_attempt_at = ?2, updated_at = ?2
+		WHERE delivery_id = ?1 AND status = 'dead'
+	`
+	result, err := s.db.ExecContext(ctx, query, deliveryID, now())
+	return affectedOne(result, err)
+}
+
+// DeleteOldWebhookEvents deletes fanned-out events older than retention days along with their deliveries,
+// keeping events that still have a pending delivery
+func (s *Store) DeleteOldWebhookEvents(ctx context.Context, retentionDays int) error {
+	query := `
+		DELETE FROM webhook_events
+		WHERE fanned_out_at IS NOT NULL AND created_at < ?
+			AND NOT EXISTS (
+				SELECT 1 FROM webhook_deliveries d
+				WHERE d.event_id = webhook_events.event_id AND d.status = 'pending'
+			)
+	`
+	_, err := s.db.ExecContext(ctx, query, now().AddDate(0, 0, -retentionDays))
+	return err
+}
+
+// MarkOfflineNodes marks enabled nodes not seen for offlineAfter as offline, emitting a node.offline event
+// for each in the same transaction. A node is only reported once until a heartbeat brings it back.
+func (s *Store) MarkOfflineNodes(ctx context.Context, offlineAfter time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	marked := now()
+	query := `
+		SELECT node_id, last_seen_at
+		FROM nodes
+		WHERE offline_at IS NULL AND NOT disabled AND last_seen_at < ?
+	`
+	rows, err := tx.QueryContext(ctx, query, marked.Add(-offlineAfter))
+	if err != nil {
+		return 0, err
+	}
+
+	type offlineNode struct {
+		nodeID     string
+		lastSeenAt time.Time
+	}
+	var nodes []offlineNode
+	for rows.Next() {
+		var n offlineNode
+		if err := rows.Scan(&n.nodeID, &n.lastSeenAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, n := range nodes {
+		if _, err := tx.ExecContext(ctx, `UPDATE nodes SET offline_at = ? WHERE node_id = ?`, marked, n.nodeID); err != nil {
+			return 0, err
+		}
+		err := insertWebhookEvent(ctx, tx, domains.EventNodeOffline, map[string]interface{}{
+			"node_id":      n.nodeID,
+			"last_seen_at": n.lastSeenAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(nodes), nil
+}
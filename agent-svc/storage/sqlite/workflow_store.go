@@ -0,0 +1,184 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"agent-svc/app/domains"
+
+	"github.com/google/uuid"
+)
+
+// workflowColumns is the column list scanned by scanWorkflow
+const workflowColumns = `id, workflow_id, tenant_id, name, definition, status, created_at, updated_at, finished_at`
+
+// scanWorkflow scans a workflows row selected with workflowColumns
+func scanWorkflow(row scanner) (*domains.Workflow, error) {
+	var wf domains.Workflow
+	err := row.Scan(
+		&wf.ID, &wf.WorkflowID, &wf.TenantID, &wf.Name, jsonColumn{&wf.Definition, "workflow definition"}, &wf.Status,
+		&wf.CreatedAt, &wf.UpdatedAt, &wf.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// CreateWorkflow inserts a workflow run with all of its steps pending and fills in its generated ID
+func (s *Store) CreateWorkflow(ctx context.Context, wf *domains.Workflow) error {
+	definitionJSON, err := encodeJSON(wf.Definition, "workflow definition")
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	workflowID, created := uuid.New(), now()
+	query := `
+		INSERT INTO workflows (workflow_id, tenant_id, name, definition, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := tx.ExecContext(ctx, query, workflowID, wf.TenantID, wf.Name, definitionJSON, wf.Status, created, created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stepQuery := `INSERT INTO workflow_steps (workflow_id, step_id, status) VALUES (?, ?, ?)`
+	for _, step := range wf.Definition.Steps {
+		if _, err := tx.ExecContext(ctx, stepQuery, workflowID, step.ID, domains.StepPending); err != nil {
+			return fmt.Errorf("failed to create workflow steps: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	wf.ID, wf.WorkflowID, wf.CreatedAt, wf.UpdatedAt = id, workflowID, created, created
+	return nil
+}
+
+// GetWorkflow retrieves a workflow run by ID, or nil if it doesn't exist
+func (s *Store) GetWorkflow(ctx context.Context, workflowID uuid.UUID) (*domains.Workflow, error) {
+	query := `SELECT ` + workflowColumns + ` FROM workflows WHERE workflow_id = ?`
+
+	wf, err := scanWorkflow(s.db.QueryRowContext(ctx, query, workflowID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// ListWorkflows retrieves the most recent workflow runs of a tenant, or of every tenant, optionally filtered by status
+func (s *Store) ListWorkflows(ctx context.Context, tenantID string, status *string, limit int) ([]*domains.Workflow, error) {
+	query := `
+		SELECT ` + workflowColumns + `
+		FROM workflows
+		WHERE (?1 = '' OR tenant_id = ?1) AND (?2 IS NULL OR status = ?2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?3
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workflows []*domains.Workflow
+	for rows.Next() {
+		wf, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, wf)
+	}
+	return workflows, rows.Err()
+}
+
+// FinishWorkflow moves a running workflow to its final status, reporting whether it was still running
+func (s *Store) FinishWorkflow(ctx context.Context, workflowID uuid.UUID, status string) (bool, error) {
+	query := `
+		UPDATE workflows
+		SET status = ?2, finished_at = ?3, updated_at = ?3
+		WHERE workflow_id = ?1 AND status = 'running'
+	`
+	result, err := s.db.ExecContext(ctx, query, workflowID, status, now())
+	return affectedOne(result, err)
+}
+
+// ListWorkflowSteps retrieves the step states of a workflow run
+func (s *Store) ListWorkflowSteps(ctx context.Context, workflowID uuid.UUID) ([]domains.WorkflowStepState, error) {
+	query := `
+		SELECT id, workflow_id, step_id, status, command_ids, error_msg, started_at, finished_at
+		FROM workflow_steps
+		WHERE workflow_id = ?
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []domains.WorkflowStepState
+	for rows.Next() {
+		var step domains.WorkflowStepState
+		err := rows.Scan(
+			&step.ID, &step.WorkflowID, &step.StepID, &step.Status, jsonColumn{&step.CommandIDs, "command IDs"},
+			&step.ErrorMsg, &step.StartedAt, &step.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// TransitionWorkflowStep moves a step from one status to another
+// It only succeeds if the step is still in the from status, so concurrent engines move a step at most once.
+// It reports whether the transition was made.
+func (s *Store) TransitionWorkflowStep(ctx context.Context, workflowID uuid.UUID, stepID, from, to string, errorMsg *string) (bool, error) {
+	query := `
+		UPDATE workflow_steps
+		SET status = ?4,
+			error_msg = COALESCE(?5, error_msg),
+			started_at = CASE WHEN ?4 = 'running' THEN ?6 ELSE started_at END,
+			finished_at = CASE WHEN ?4 IN ('succeeded', 'failed', 'skipped') THEN ?6 ELSE finished_at END
+		WHERE workflow_id = ?1 AND step_id = ?2 AND status = ?3
+	`
+	result, err := s.db.ExecContext(ctx, query, workflowID, stepID, from, to, errorMsg, now())
+	return affectedOne(result, err)
+}
+
+// SetWorkflowStepCommands records the commands submitted for a step
+func (s *Store) SetWorkflowStepCommands(ctx context.Context, workflowID uuid.UUID, stepID string, commandIDs []uuid.UUID) error {
+	if commandIDs == nil {
+		commandIDs = []uuid.UUID{}
+	}
+	commandIDsJSON, err := encodeJSON(commandIDs, "command IDs")
+	if err != nil {
+		return err
+	}
+	query := `UPDATE workflow_steps SET command_ids = ? WHERE workflow_id = ? AND step_id = ?`
+	_, err = s.db.ExecContext(ctx, query, commandIDsJSON, workflowID, stepID)
+	return err
+}
+
+// GetWorkflowStepOutcome summarizes the commands of a step, using the latest attempt of each command
+func (s *Store) GetWorkflowStepOutcome(ctx context.Context, workflowID uuid.UUID, stepID string) (domains.CommandOutcome, error) {
+	return s.queryCommandOutcome(ctx, `workflow_id = ? AND workflow_step_id = ?`, workflowID, stepID)
+}
@@ -2,7 +2,7 @@ import { useEffect, useRef, useState, useCallback } from 'react'
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
 import { Button } from '@/components/ui/button'
 import { Badge } from '@/components/ui/badge'
-import { api, type Command, type LogChunk } from '@/lib/api'
+import { api, authHeaders, type Command, type LogChunk } from '@/lib/api'
 import { X, RefreshCw, Square } from 'lucide-react'
 
 interface ConsoleViewProps {
@@ -80,7 +80,7 @@ export function ConsoleView({ command, onClose }: ConsoleViewProps) {
         ? `http://localhost:8080/v1/commands/${command.command_id}/logs?after_chunk_index=${currentIndex}`
         : `http://localhost:8080/v1/commands/${command.command_id}/logs`
       
-      const response = await fetch(url)
+      const response = await fetch(url, { headers: authHeaders() })
       if (!response.ok) return
       
       const data = await response.json()
@@ -1,5 +1,12 @@
 const API_BASE_URL = 'http://localhost:8080/v1';
 
+// Operator token sent with every request, issued with agent-svc's cmd/operator-token
+const OPERATOR_TOKEN: string = import.meta.env.VITE_OPERATOR_TOKEN ?? '';
+
+export function authHeaders(): Record<string, string> {
+  return OPERATOR_TOKEN ? { Authorization: `Bearer ${OPERATOR_TOKEN}` } : {};
+}
+
 export interface Node {
   node_id: string;
   attrs: Record<string, any>;
@@ -36,6 +43,7 @@ export const api = {
   async listNodes(): Promise<Node[]> {
     const response = await fetch(`${API_BASE_URL}/agents`, {
       cache: 'no-cache',
+      headers: authHeaders(),
     });
     if (!response.ok) throw new Error('Failed to fetch nodes');
     const data = await response.json();
@@ -48,6 +56,7 @@ export const api = {
     params.append('limit', limit.toString());
     const response = await fetch(`${API_BASE_URL}/commands?${params}`, {
       cache: 'no-cache',
+      headers: authHeaders(),
     });
     if (!response.ok) throw new Error('Failed to fetch commands');
     const data = await response.json();
@@ -57,7 +66,7 @@ export const api = {
   async submitCommand(nodeId: string, command: string, timeoutSec = 30): Promise<{ command_id: string }> {
     const response = await fetch(`${API_BASE_URL}/commands/submit`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...authHeaders() },
       body: JSON.stringify({
         command_type: 'RunCommand',
         node_id: nodeId,
@@ -80,6 +89,7 @@ export const api = {
       : `${API_BASE_URL}/commands/${commandId}/logs`;
     const response = await fetch(url, {
       cache: 'no-cache',
+      headers: authHeaders(),
     });
     if (!response.ok) throw new Error('Failed to fetch logs');
     return response.json();
@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
@@ -9,10 +10,13 @@ import (
 	"syscall"
 	"time"
 
+	"agent-svc/client"
 	"node-agent/app/clients"
 	"node-agent/app/identity"
+	"node-agent/app/policy"
 	"node-agent/app/services"
 	"node-agent/app/storage"
+	"node-agent/app/tracing"
 )
 
 // Bootstrap initializes and starts the node agent
@@ -22,6 +26,16 @@ func Bootstrap() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// A node with a local policy must never run a command without it, so a policy that fails to load is fatal
+	var localPolicy *policy.Policy
+	if cfg.LocalPolicyFile != "" {
+		localPolicy, err = policy.Load(cfg.LocalPolicyFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("local policy %s loaded (%s)", cfg.LocalPolicyFile, localPolicy.Hash())
+	}
+
 	store, err := storage.NewStore(cfg.DBPath)
 	if err != nil {
 		return fmt.Errorf("failed to initialize storage: %w", err)
@@ -29,7 +43,10 @@ func Bootstrap() error {
 	defer store.Close()
 
 	identityMgr := identity.NewManager(cfg.IdentityPath)
-	registrationService := services.NewRegistrationService(cfg.AgentSvcURL, identityMgr)
+	registrationService := services.NewRegistrationService(cfg.AgentSvcURL, services.Enrollment{
+		TenantID: cfg.TenantID,
+		Key:      cfg.EnrollmentKey,
+	}, identityMgr, cfg.CommandSigningKey)
 
 	ident, err := identityMgr.Load()
 	if err != nil {
@@ -54,10 +71,60 @@ func Bootstrap() error {
 		if err != nil {
 			return fmt.Errorf("failed to register after retries: %w", lastErr)
 		}
+	} else if ident.CommandSigningKey == "" && cfg.CommandSigningKey == "" {
+		// A node enrolled before agent-svc signed commands re-registers to pin its key
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		reIdent, _, err := registrationService.ReRegister(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("failed to re-register to pin a command signing key: %v", err)
+		} else {
+			ident = reIdent
+		}
 	}
 
-	httpClient := clients.NewHTTPClient(cfg.AgentSvcURL, ident.JWTToken)
-	agentClient := services.NewAgentClient(httpClient)
+	// Commands must be signed with the configured key, or else with the key pinned when the node enrolled
+	var commandKey ed25519.PublicKey
+	if signingKey := cfg.CommandSigningKey; signingKey != "" || ident.CommandSigningKey != "" {
+		if signingKey == "" {
+			signingKey = ident.CommandSigningKey
+		}
+		commandKey, err = client.ParseCommandSigningKey(signingKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("no command signing key pinned: commands are accepted without verifying their origin")
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.TraceExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+		NodeID:       ident.NodeID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("tracing shutdown error: %v", err)
+		}
+	}()
+
+	auth := client.NewTokenAuth(ident.JWTToken)
+	api := clients.NewAgentSvcClient(cfg.AgentSvcURL, auth)
+	agentClient := services.NewAgentClient(api)
+	if cfg.Transport == TransportGRPC {
+		session, err := clients.NewAgentSession(cfg.AgentSvcGRPCAddr, auth)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		agentClient = services.NewSessionAgentClient(api, session)
+		log.Printf("using gRPC agent session with %s", cfg.AgentSvcGRPCAddr)
+	}
 	chunkStorageRetry := services.NewChunkStorageRetryService(store, agentClient, 2)
 
 	runtimeService := services.NewRuntimeService(
@@ -70,14 +137,21 @@ func Bootstrap() error {
 		5,
 		cfg.WorkerCount,
 		cfg.ChannelSize,
+		cfg.ExpressWorkerCount,
+		cfg.ExpressMinPriority,
+		localPolicy,
+		commandKey,
 	)
 
+	registrationService.OnKeyPinned(runtimeService.SetCommandKey)
+
 	heartbeatService := services.NewHeartbeatService(
 		agentClient,
 		ident.NodeID,
 		cfg.HeartbeatIntervalSec,
 		registrationService,
-		httpClient,
+		auth,
+		localPolicy.Hash(),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -88,6 +162,15 @@ func Bootstrap() error {
 	go runtimeService.Start(ctx)
 	go startCleanupJob(ctx, store)
 
+	if cfg.DebugListen != "" {
+		debugServer := services.NewDebugServer(cfg.DebugListen, ident.NodeID, store, runtimeService, heartbeatService)
+		go func() {
+			if err := debugServer.Start(ctx); err != nil {
+				log.Printf("debug endpoint stopped: %v", err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -0,0 +1,172 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"agent-svc/client"
+	"agent-svc/rpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AgentSession makes node calls over an AgentSession stream of the agent-svc gRPC API
+// The stream is opened on the first call and reopened after it broke or the token changed, e.g. because the
+// node re-registered. Calls may be made concurrently; each waits for the reply to its own request.
+type AgentSession struct {
+	conn *grpc.ClientConn
+	auth *client.TokenAuth
+
+	mu     sync.Mutex
+	stream *sessionStream
+	nextID uint64
+}
+
+// sessionStream is one AgentSession stream and the calls waiting for a reply on it
+type sessionStream struct {
+	stream rpc.AgentService_AgentSessionClient
+	cancel context.CancelFunc
+	token  string
+
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]chan *rpc.ServerMessage
+	err     error         // why the stream broke
+	done    chan struct{} // closed once the stream broke
+}
+
+// NewAgentSession creates a session with the agent-svc gRPC API at addr, authenticating with auth
+func NewAgentSession(addr string, auth *client.TokenAuth) (*AgentSession, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client for %s: %w", addr, err)
+	}
+	return &AgentSession{conn: conn, auth: auth}, nil
+}
+
+// Close ends the session and closes its connection
+func (s *AgentSession) Close() error {
+	s.mu.Lock()
+	if s.stream != nil {
+		s.stream.cancel()
+		s.stream = nil
+	}
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+// Call sends a request and returns the reply to it
+// An Error reply is returned as a gRPC status error, so status.Code tells what went wrong.
+func (s *AgentSession) Call(ctx context.Context, msg *rpc.AgentMessage) (*rpc.ServerMessage, error) {
+	st, requestID, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	msg.RequestId = requestID
+
+	reply := make(chan *rpc.ServerMessage, 1)
+	st.mu.Lock()
+	if st.err != nil {
+		st.mu.Unlock()
+		return nil, st.err
+	}
+	st.pending[requestID] = reply
+	st.mu.Unlock()
+	defer func() {
+		st.mu.Lock()
+		delete(st.pending, requestID)
+		st.mu.Unlock()
+	}()
+
+	st.sendMu.Lock()
+	err = st.stream.Send(msg)
+	st.sendMu.Unlock()
+	if err != nil {
+		// Send reports io.EOF when the stream broke; the receiver has the actual error
+		<-st.done
+		return nil, st.err
+	}
+
+	select {
+	case resp := <-reply:
+		if e := resp.GetError(); e != nil {
+			return nil, status.Error(codes.Code(e.Code), e.Message)
+		}
+		return resp, nil
+	case <-st.done:
+		return nil, st.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// open returns the current stream, opening a new one if there is none usable, and the next request ID
+func (s *AgentSession) open() (*sessionStream, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	token := s.auth.Token()
+	if st := s.stream; st != nil {
+		select {
+		case <-st.done:
+		default:
+			if st.token == token {
+				return st, s.nextID, nil
+			}
+			// The node re-registered; calls in flight finish on the old stream
+			go func() {
+				st.sendMu.Lock()
+				st.stream.CloseSend()
+				st.sendMu.Unlock()
+			}()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	stream, err := rpc.NewAgentServiceClient(s.conn).AgentSession(ctx)
+	if err != nil {
+		cancel()
+		return nil, 0, err
+	}
+
+	st := &sessionStream{
+		stream:  stream,
+		cancel:  cancel,
+		token:   token,
+		pending: map[uint64]chan *rpc.ServerMessage{},
+		done:    make(chan struct{}),
+	}
+	go st.receive()
+	s.stream = st
+	return st, s.nextID, nil
+}
+
+// receive hands replies to the calls waiting for them until the stream breaks
+func (st *sessionStream) receive() {
+	defer st.cancel()
+	for {
+		resp, err := st.stream.Recv()
+		if err != nil {
+			st.mu.Lock()
+			st.err = fmt.Errorf("agent session closed: %w", err)
+			st.mu.Unlock()
+			close(st.done)
+			return
+		}
+
+		st.mu.Lock()
+		reply, ok := st.pending[resp.RequestId]
+		st.mu.Unlock()
+		if ok {
+			reply <- resp
+		}
+	}
+}
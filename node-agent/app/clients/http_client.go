@@ -1,95 +1,60 @@
 package clients
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"time"
-)
 
-// HTTPClient is a basic HTTP client wrapper
-type HTTPClient struct {
-	baseURL    string
-	jwtToken   string
-	httpClient *http.Client
-}
+	"agent-svc/client"
+	"node-agent/app/tracing"
 
-// UpdateToken updates the JWT token
-func (c *HTTPClient) UpdateToken(token string) {
-	c.jwtToken = token
-}
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
 
-// NewHTTPClient creates a new HTTP client
-func NewHTTPClient(baseURL string, jwtToken string) *HTTPClient {
-	return &HTTPClient{
-		baseURL:  baseURL,
-		jwtToken: jwtToken,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+// NewAgentSvcClient creates the agent-svc API client, authenticating with auth if it is set
+func NewAgentSvcClient(baseURL string, auth client.Authenticator) *client.Client {
+	opts := []client.Option{
+		client.WithHTTPClient(&http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &tracingTransport{next: http.DefaultTransport},
+		}),
+		client.WithUserAgent("node-agent (agent-svc-client/" + client.Version + ")"),
+	}
+	if auth != nil {
+		opts = append(opts, client.WithAuth(auth))
 	}
+	return client.New(baseURL, opts...)
 }
 
-// DoRequest performs an HTTP request and handles the response
-func (c *HTTPClient) DoRequest(ctx context.Context, method, path string, payload interface{}, handler func(*http.Response) (interface{}, error)) (interface{}, error) {
-	var body io.Reader
-	if payload != nil {
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %w", err)
-		}
-		body = bytes.NewBuffer(jsonData)
-	}
+// tracingTransport gives requests made on behalf of a traced command a client span and passes its
+// trace context on to agent-svc
+type tracingTransport struct {
+	next http.RoundTripper
+}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// RoundTrip sends a request, tracing it if its context carries a span
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return t.next.RoundTrip(req)
 	}
 
-	if payload != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	if c.jwtToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.jwtToken)
-	}
+	ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", req.Method), attribute.String("url.path", req.URL.Path)))
+	defer span.End()
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
+	req = req.Clone(ctx)
+	tracing.InjectHeaders(ctx, req.Header)
 
-	// Check status code
-	expectedStatus := http.StatusOK
-	if method == "POST" && path == "/v1/commands/logs" {
-		expectedStatus = http.StatusCreated
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
 	}
-
-	if resp.StatusCode != expectedStatus {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, &HTTPError{
-			Code:    resp.StatusCode,
-			Message: string(bodyBytes),
-		}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		tracing.RecordError(span, fmt.Errorf("server returned %d", resp.StatusCode))
 	}
-
-	return handler(resp)
-}
-
-// HTTPError represents an HTTP error with status code
-type HTTPError struct {
-	Code    int
-	Message string
-}
-
-func (e *HTTPError) Error() string {
-	return fmt.Sprintf("server returned %d: %s", e.Code, e.Message)
-}
-
-// GetStatusCode returns the HTTP status code
-func (e *HTTPError) GetStatusCode() int {
-	return e.Code
+	return resp, nil
 }
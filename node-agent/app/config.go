@@ -6,6 +6,10 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"agent-svc/client"
+	"node-agent/app/services"
+	"node-agent/app/tracing"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -13,8 +17,14 @@ import (
 type YAMLConfig struct {
 	Agent struct {
 		SvcURL       string `yaml:"svc_url"`
+		Transport    string `yaml:"transport"`
+		GRPCAddr     string `yaml:"grpc_addr"`
 		IdentityPath string `yaml:"identity_path"`
-		Chunk        struct {
+		Tenant       struct {
+			ID            string `yaml:"id"`
+			EnrollmentKey string `yaml:"enrollment_key"`
+		} `yaml:"tenant"`
+		Chunk struct {
 			Size        int `yaml:"size"`
 			IntervalSec int `yaml:"interval_sec"`
 		} `yaml:"chunk"`
@@ -28,20 +38,71 @@ type YAMLConfig struct {
 			DefaultTimeoutSec int `yaml:"default_timeout_sec"`
 			WorkerCount       int `yaml:"worker_count"`
 			ChannelSize       int `yaml:"channel_size"`
+
+			ExpressWorkerCount int `yaml:"express_worker_count"`
+			ExpressMinPriority int `yaml:"express_min_priority"`
 		} `yaml:"execution"`
+		Policy struct {
+			File string `yaml:"file"`
+		} `yaml:"policy"`
+		CommandSigning struct {
+			PublicKey string `yaml:"public_key"`
+		} `yaml:"command_signing"`
+		Debug struct {
+			Listen string `yaml:"listen"`
+		} `yaml:"debug"`
+		Tracing struct {
+			Exporter     string `yaml:"exporter"`
+			OTLPEndpoint string `yaml:"otlp_endpoint"`
+		} `yaml:"tracing"`
 	} `yaml:"agent"`
 }
 
+// Node call transports selectable with TRANSPORT
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
 // Config holds node agent configuration
 type Config struct {
-	AgentSvcURL          string
-	IdentityPath         string
+	AgentSvcURL  string
+	IdentityPath string
+
+	// Transport is http or grpc; with grpc, heartbeats, polls, log pushes and status updates use an
+	// AgentSession with the gRPC API at AgentSvcGRPCAddr, and only registration uses AgentSvcURL
+	Transport        string
+	AgentSvcGRPCAddr string
+
+	// Tenant the node enrolls into and its enrollment key; empty enrolls into the default tenant
+	TenantID      string
+	EnrollmentKey string
+
 	ChunkSize            int
 	ChunkIntervalSec     int
 	HeartbeatIntervalSec int
 	DBPath               string
 	WorkerCount          int
 	ChannelSize          int
+
+	// Express lane for high priority commands; disabled when ExpressWorkerCount is 0
+	ExpressWorkerCount int
+	ExpressMinPriority int
+
+	// LocalPolicyFile is the path of the local policy restricting the commands the node runs; empty runs
+	// every command agent-svc sends
+	LocalPolicyFile string
+
+	// CommandSigningKey is the base64 public key commands must be signed with, pinned out of band; empty uses
+	// the key pinned when the node enrolled
+	CommandSigningKey string
+
+	// Local metrics and debug state endpoint; disabled when DebugListen is empty
+	DebugListen string
+
+	// TraceExporter is none, stdout or otlp; OTLPEndpoint is the OTLP/HTTP collector URL
+	TraceExporter string
+	OTLPEndpoint  string
 }
 
 // LoadConfig loads configuration from YAML file with environment variable overrides
@@ -72,11 +133,26 @@ func LoadConfig() (*Config, error) {
 	// Build config with YAML values, allowing env var overrides
 	cfg := &Config{
 		AgentSvcURL:          getEnv("AGENT_SVC_URL", yamlCfg.Agent.SvcURL),
+		Transport:            getEnv("TRANSPORT", yamlCfg.Agent.Transport),
+		AgentSvcGRPCAddr:     getEnv("AGENT_SVC_GRPC_ADDR", yamlCfg.Agent.GRPCAddr),
+		TenantID:             getEnv("TENANT_ID", yamlCfg.Agent.Tenant.ID),
+		EnrollmentKey:        getEnv("ENROLLMENT_KEY", yamlCfg.Agent.Tenant.EnrollmentKey),
 		ChunkSize:            getEnvInt("CHUNK_SIZE", yamlCfg.Agent.Chunk.Size),
 		ChunkIntervalSec:     getEnvInt("CHUNK_INTERVAL_SEC", yamlCfg.Agent.Chunk.IntervalSec),
 		HeartbeatIntervalSec: getEnvInt("HEARTBEAT_INTERVAL_SEC", yamlCfg.Agent.Heartbeat.IntervalSec),
 		WorkerCount:          getEnvInt("WORKER_COUNT", yamlCfg.Agent.Execution.WorkerCount),
 		ChannelSize:          getEnvInt("CHANNEL_SIZE", yamlCfg.Agent.Execution.ChannelSize),
+
+		ExpressWorkerCount: getEnvInt("EXPRESS_WORKER_COUNT", yamlCfg.Agent.Execution.ExpressWorkerCount),
+		ExpressMinPriority: getEnvInt("EXPRESS_MIN_PRIORITY", yamlCfg.Agent.Execution.ExpressMinPriority),
+
+		LocalPolicyFile:   getEnv("LOCAL_POLICY_FILE", yamlCfg.Agent.Policy.File),
+		CommandSigningKey: getEnv("COMMAND_SIGNING_PUBLIC_KEY", yamlCfg.Agent.CommandSigning.PublicKey),
+
+		DebugListen: getEnv("DEBUG_LISTEN", yamlCfg.Agent.Debug.Listen),
+
+		TraceExporter: getEnv("TRACE_EXPORTER", yamlCfg.Agent.Tracing.Exporter),
+		OTLPEndpoint:  getEnv("OTLP_ENDPOINT", yamlCfg.Agent.Tracing.OTLPEndpoint),
 	}
 
 	// Handle identity path: env var > YAML > hostname-based default
@@ -102,6 +178,18 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("AGENT_SVC_URL must be set (Kong HTTP endpoint)")
 	}
 
+	switch cfg.Transport {
+	case "":
+		cfg.Transport = TransportHTTP
+	case TransportHTTP:
+	case TransportGRPC:
+		if cfg.AgentSvcGRPCAddr == "" {
+			return nil, fmt.Errorf("AGENT_SVC_GRPC_ADDR must be set with TRANSPORT=grpc (agent-svc gRPC host:port)")
+		}
+	default:
+		return nil, fmt.Errorf("unknown TRANSPORT %q: must be http or grpc", cfg.Transport)
+	}
+
 	if cfg.WorkerCount <= 0 {
 		cfg.WorkerCount = 2
 	}
@@ -110,6 +198,37 @@ func LoadConfig() (*Config, error) {
 		cfg.ChannelSize = 100
 	}
 
+	if cfg.ExpressWorkerCount < 0 {
+		cfg.ExpressWorkerCount = 0
+	}
+
+	if cfg.ExpressMinPriority <= 0 {
+		cfg.ExpressMinPriority = 8
+	}
+
+	if cfg.CommandSigningKey != "" {
+		if _, err := client.ParseCommandSigningKey(cfg.CommandSigningKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.DebugListen != "" {
+		if err := services.ValidateDebugListen(cfg.DebugListen); err != nil {
+			return nil, fmt.Errorf("invalid debug listen address: %w", err)
+		}
+	}
+
+	if cfg.TraceExporter == "" {
+		cfg.TraceExporter = tracing.ExporterNone
+	}
+	if err := tracing.ValidateExporter(cfg.TraceExporter); err != nil {
+		return nil, err
+	}
+
+	if cfg.OTLPEndpoint == "" {
+		cfg.OTLPEndpoint = "http://localhost:4318"
+	}
+
 	return cfg, nil
 }
 
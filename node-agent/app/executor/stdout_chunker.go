@@ -3,8 +3,11 @@ package executor
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // Chunk represents a log chunk
@@ -15,8 +18,19 @@ type Chunk struct {
 	IsFinal    bool // true if this is the final chunk (work is done)
 }
 
+// tailSegment holds output withheld for the tail of a size-limited command
+type tailSegment struct {
+	stream string
+	data   []byte
+}
+
 // Chunker handles chunking of stdout/stderr streams
+//
+// When maxOutputBytes is set, the first half of the budget is streamed as it is produced and the
+// last half is held back until FinalFlush, so that only the head and tail of the output are kept.
+// If the middle was dropped, a truncation marker is emitted between the head and the tail.
 type Chunker struct {
+	mu                sync.Mutex
 	chunkSize         int
 	chunkInterval     time.Duration
 	chunkChan         chan Chunk
@@ -24,15 +38,25 @@ type Chunker struct {
 	stdoutBuffer      []byte
 	stderrBuffer      []byte
 	lastFlush         time.Time
+	closed            bool
+
+	maxOutputBytes int64
+	totalBytes     int64
+	headBytes      int64
+	headDone       bool
+	tailBytes      int64
+	tail           []tailSegment
 }
 
 // NewChunker creates a new chunker
-func NewChunker(chunkSize int, chunkIntervalSec int) *Chunker {
+// maxOutputBytes limits the output kept for the command; zero or less keeps everything
+func NewChunker(chunkSize int, chunkIntervalSec int, maxOutputBytes int64) *Chunker {
 	return &Chunker{
-		chunkSize:     chunkSize,
-		chunkInterval: time.Duration(chunkIntervalSec) * time.Second,
-		chunkChan:     make(chan Chunk, 100),
-		lastFlush:     time.Now(),
+		chunkSize:      chunkSize,
+		chunkInterval:  time.Duration(chunkIntervalSec) * time.Second,
+		chunkChan:      make(chan Chunk, 100),
+		lastFlush:      time.Now(),
+		maxOutputBytes: maxOutputBytes,
 	}
 }
 
@@ -72,11 +96,16 @@ func (c *Chunker) readStream(ctx context.Context, reader io.Reader, stream strin
 	}
 
 	// Flush remaining on EOF
+	c.mu.Lock()
 	c.flushStream(stream)
+	c.mu.Unlock()
 }
 
 // appendToBuffer appends data to the appropriate buffer
 func (c *Chunker) appendToBuffer(stream string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if stream == "stdout" {
 		c.stdoutBuffer = append(c.stdoutBuffer, data...)
 		c.stdoutBuffer = append(c.stdoutBuffer, '\n')
@@ -96,6 +125,9 @@ func (c *Chunker) appendToBuffer(stream string, data []byte) {
 
 // flushBuffers flushes all buffers if interval has passed
 func (c *Chunker) flushBuffers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	now := time.Now()
 	if now.Sub(c.lastFlush) >= c.chunkInterval {
 		c.flushStream("stdout")
@@ -104,81 +136,184 @@ func (c *Chunker) flushBuffers() {
 	}
 }
 
-// flushStream flushes a specific stream buffer
-func (c *Chunker) flushStream(stream string) {
+// takeBuffer returns and clears the buffer for a stream
+func (c *Chunker) takeBuffer(stream string) []byte {
 	var buffer []byte
 	if stream == "stdout" {
-		if len(c.stdoutBuffer) == 0 {
-			return
-		}
 		buffer = c.stdoutBuffer
 		c.stdoutBuffer = nil
 	} else {
-		if len(c.stderrBuffer) == 0 {
-			return
-		}
 		buffer = c.stderrBuffer
 		c.stderrBuffer = nil
 	}
+	return buffer
+}
 
-	if len(buffer) > 0 {
-		chunk := Chunk{
-			ChunkIndex: c.currentChunkIndex,
-			Stream:     stream,
-			Data:       string(buffer),
-		}
-		c.currentChunkIndex++
-
-		select {
-		case c.chunkChan <- chunk:
-		default:
-			// Channel full, drop chunk (shouldn't happen with buffered channel)
-		}
+// flushStream flushes a specific stream buffer
+func (c *Chunker) flushStream(stream string) {
+	if buffer := c.takeBuffer(stream); len(buffer) > 0 {
+		c.emit(stream, buffer, false)
 	}
 }
 
 // FinalFlush flushes all remaining buffers and marks them as final
 func (c *Chunker) FinalFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	// Flush stdout and stderr as final chunks
 	c.flushStreamFinal("stdout")
 	c.flushStreamFinal("stderr")
+
+	// Release the withheld tail, preceded by the truncation marker if the middle was dropped.
+	// These sends block, since the tail can exceed the channel buffer.
+	if omitted := c.omittedBytes(); omitted > 0 {
+		marker := fmt.Sprintf("\n[output truncated: %d of %d bytes omitted]\n", omitted, c.totalBytes)
+		c.sendWait("stdout", []byte(marker))
+	}
+	for _, segment := range c.tail {
+		data := segment.data
+		for len(data) > 0 {
+			n := len(data)
+			if n > c.chunkSize {
+				if n = runeBoundary(data, c.chunkSize); n == 0 {
+					n = c.chunkSize
+				}
+			}
+			c.sendWait(segment.stream, data[:n])
+			data = data[n:]
+		}
+	}
+	c.tail = nil
+
+	c.closed = true
 	close(c.chunkChan)
 }
 
 // flushStreamFinal flushes a specific stream buffer and marks it as final
 func (c *Chunker) flushStreamFinal(stream string) {
-	var buffer []byte
-	if stream == "stdout" {
-		if len(c.stdoutBuffer) == 0 {
+	if buffer := c.takeBuffer(stream); len(buffer) > 0 {
+		c.emit(stream, buffer, true) // Mark as final chunk
+	}
+}
+
+// emit sends data within the head budget and withholds the rest for the tail
+func (c *Chunker) emit(stream string, data []byte, isFinal bool) {
+	c.totalBytes += int64(len(data))
+	if c.maxOutputBytes <= 0 {
+		c.send(stream, data, isFinal)
+		return
+	}
+
+	if !c.headDone {
+		remaining := c.maxOutputBytes/2 - c.headBytes
+		if int64(len(data)) <= remaining {
+			c.headBytes += int64(len(data))
+			c.send(stream, data, isFinal)
 			return
 		}
-		buffer = c.stdoutBuffer
-		c.stdoutBuffer = nil
+
+		cut := runeBoundary(data, int(remaining))
+		c.send(stream, data[:cut], false)
+		c.headBytes += int64(cut)
+		c.headDone = true
+		data = data[cut:]
+	}
+
+	c.appendTail(stream, data)
+}
+
+// appendTail adds data to the withheld tail, dropping its oldest bytes beyond the tail budget
+func (c *Chunker) appendTail(stream string, data []byte) {
+	if n := len(c.tail); n > 0 && c.tail[n-1].stream == stream {
+		c.tail[n-1].data = append(c.tail[n-1].data, data...)
 	} else {
-		if len(c.stderrBuffer) == 0 {
-			return
-		}
-		buffer = c.stderrBuffer
-		c.stderrBuffer = nil
+		c.tail = append(c.tail, tailSegment{stream: stream, data: append([]byte(nil), data...)})
 	}
+	c.tailBytes += int64(len(data))
 
-	if len(buffer) > 0 {
-		chunk := Chunk{
-			ChunkIndex: c.currentChunkIndex,
-			Stream:     stream,
-			Data:       string(buffer),
-			IsFinal:    true, // Mark as final chunk
+	tailLimit := c.maxOutputBytes - c.maxOutputBytes/2
+	for c.tailBytes > tailLimit && len(c.tail) > 0 {
+		excess := c.tailBytes - tailLimit
+		first := c.tail[0].data
+		if int64(len(first)) <= excess {
+			c.tail = c.tail[1:]
+			c.tailBytes -= int64(len(first))
+			continue
 		}
-		c.currentChunkIndex++
 
-		select {
-		case c.chunkChan <- chunk:
-		default:
-			// Channel full, drop chunk (shouldn't happen with buffered channel)
+		cut := int(excess)
+		for cut < len(first) && !utf8.RuneStart(first[cut]) {
+			cut++
 		}
+		c.tail[0].data = first[cut:]
+		c.tailBytes -= int64(cut)
+	}
+}
+
+// send delivers a chunk to the chunk channel without blocking
+func (c *Chunker) send(stream string, data []byte, isFinal bool) {
+	if c.closed || len(data) == 0 {
+		return
+	}
+
+	select {
+	case c.chunkChan <- c.nextChunk(stream, data, isFinal):
+	default:
+		// Channel full, drop chunk (shouldn't happen with buffered channel)
 	}
 }
 
+// sendWait delivers a final chunk to the chunk channel, waiting for room if needed
+func (c *Chunker) sendWait(stream string, data []byte) {
+	if c.closed || len(data) == 0 {
+		return
+	}
+	c.chunkChan <- c.nextChunk(stream, data, true)
+}
+
+// nextChunk builds a chunk with the next chunk index
+func (c *Chunker) nextChunk(stream string, data []byte, isFinal bool) Chunk {
+	chunk := Chunk{
+		ChunkIndex: c.currentChunkIndex,
+		Stream:     stream,
+		Data:       string(data),
+		IsFinal:    isFinal,
+	}
+	c.currentChunkIndex++
+	return chunk
+}
+
+// runeBoundary returns the largest index <= n that doesn't split a UTF-8 sequence
+func runeBoundary(data []byte, n int) int {
+	for n > 0 && n < len(data) && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return n
+}
+
+// omittedBytes returns the number of output bytes dropped between the head and the tail
+func (c *Chunker) omittedBytes() int64 {
+	if c.maxOutputBytes <= 0 {
+		return 0
+	}
+	return c.totalBytes - c.headBytes - c.tailBytes
+}
+
+// TotalBytes returns the number of output bytes produced so far, including truncated output
+func (c *Chunker) TotalBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totalBytes
+}
+
+// Truncated reports whether part of the output was dropped by the output limit
+func (c *Chunker) Truncated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.omittedBytes() > 0
+}
+
 // ChunkSize returns the chunk size
 func (c *Chunker) ChunkSize() int {
 	return c.chunkSize
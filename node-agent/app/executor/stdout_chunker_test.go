@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"reflect"
+	"testing"
+)
+
+// drain collects the chunks left in the channel once FinalFlush closed it
+func drain(t *testing.T, c *Chunker) []Chunk {
+	t.Helper()
+	var chunks []Chunk
+	for chunk := range c.chunkChan {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestChunkerHeadAndTail(t *testing.T) {
+	c := NewChunker(8, 1, 20)
+
+	// The head streams as soon as a chunk fills
+	c.appendToBuffer("stdout", []byte("aaaaaaa"))
+	select {
+	case chunk := <-c.chunkChan:
+		if want := (Chunk{ChunkIndex: 0, Stream: "stdout", Data: "aaaaaaa\n"}); chunk != want {
+			t.Fatalf("first chunk = %+v, want %+v", chunk, want)
+		}
+	default:
+		t.Fatal("head chunk not streamed before FinalFlush")
+	}
+
+	// The rest of the head budget is streamed, everything after it is withheld
+	c.appendToBuffer("stdout", []byte("bbbbbbb"))
+	c.appendToBuffer("stdout", []byte("ccccccc"))
+	c.appendToBuffer("stdout", []byte("ddddddd"))
+	select {
+	case chunk := <-c.chunkChan:
+		if want := (Chunk{ChunkIndex: 1, Stream: "stdout", Data: "bb"}); chunk != want {
+			t.Fatalf("second chunk = %+v, want %+v", chunk, want)
+		}
+	default:
+		t.Fatal("end of the head not streamed before FinalFlush")
+	}
+	select {
+	case chunk := <-c.chunkChan:
+		t.Fatalf("tail chunk %+v streamed before FinalFlush", chunk)
+	default:
+	}
+
+	c.FinalFlush()
+	want := []Chunk{
+		{ChunkIndex: 2, Stream: "stdout", Data: "\n[output truncated: 12 of 32 bytes omitted]\n", IsFinal: true},
+		{ChunkIndex: 3, Stream: "stdout", Data: "c\ndddddd", IsFinal: true},
+		{ChunkIndex: 4, Stream: "stdout", Data: "d\n", IsFinal: true},
+	}
+	if got := drain(t, c); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks after FinalFlush = %+v, want %+v", got, want)
+	}
+	if got := c.TotalBytes(); got != 32 {
+		t.Errorf("TotalBytes() = %d, want 32", got)
+	}
+	if !c.Truncated() {
+		t.Error("Truncated() = false, want true")
+	}
+}
+
+func TestChunkerWithinLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxOutputBytes int64
+	}{
+		{name: "no limit"},
+		{name: "output fits the limit", maxOutputBytes: 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChunker(8, 1, tt.maxOutputBytes)
+			c.appendToBuffer("stdout", []byte("aaaaaaa"))
+			c.appendToBuffer("stderr", []byte("err"))
+			c.appendToBuffer("stdout", []byte("bbbbbbb"))
+			c.FinalFlush()
+
+			want := []Chunk{
+				{ChunkIndex: 0, Stream: "stdout", Data: "aaaaaaa\n"},
+				{ChunkIndex: 1, Stream: "stdout", Data: "bbbbbbb\n"},
+				{ChunkIndex: 2, Stream: "stderr", Data: "err\n", IsFinal: true},
+			}
+			if got := drain(t, c); !reflect.DeepEqual(got, want) {
+				t.Errorf("chunks = %+v, want %+v", got, want)
+			}
+			if got := c.TotalBytes(); got != 20 {
+				t.Errorf("TotalBytes() = %d, want 20", got)
+			}
+			if c.Truncated() {
+				t.Error("Truncated() = true, want false")
+			}
+		})
+	}
+}
@@ -12,6 +12,10 @@ type Identity struct {
 	NodeID   string                 `json:"node_id"`
 	JWTToken string                 `json:"jwt_token"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// CommandSigningKey is agent-svc's command signing public key, pinned when the node enrolled; empty if
+	// agent-svc didn't sign commands then
+	CommandSigningKey string `json:"command_signing_key,omitempty"`
 }
 
 // Manager handles identity file operations
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// namespace prefixes every metric name
+const namespace = "node_agent"
+
+// Registry holds the metrics served by the debug endpoint
+var Registry = prometheus.NewRegistry()
+
+var (
+	// PollsTotal counts command polls to agent-svc by result: success or failure
+	PollsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "polls_total",
+		Help:      "Command polls to agent-svc, by result.",
+	}, []string{"result"})
+
+	// CommandsReceivedTotal counts commands received from polls and saved locally
+	CommandsReceivedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_received_total",
+		Help:      "Commands received from agent-svc.",
+	})
+
+	// CommandsFinishedTotal counts commands run to a final status
+	CommandsFinishedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_finished_total",
+		Help:      "Commands that reached a final status, by status.",
+	}, []string{"status"})
+
+	// ChunkUploadRetriesTotal counts log chunk uploads made again after a failed attempt
+	ChunkUploadRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunk_upload_retries_total",
+		Help:      "Log chunk upload attempts retried after a failure.",
+	})
+
+	// ChunkUploadFailuresTotal counts log chunk uploads that failed every attempt
+	ChunkUploadFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunk_upload_failures_total",
+		Help:      "Log chunk uploads that failed after all retries; the chunks stay pending locally.",
+	})
+
+	// CommandSignatureFailuresTotal counts commands refused because their signature didn't verify, by reason:
+	// unsigned, invalid or expired
+	CommandSignatureFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_signature_failures_total",
+		Help:      "Commands refused because their signature didn't verify, by reason.",
+	}, []string{"reason"})
+
+	// HeartbeatFailuresTotal counts failed heartbeats
+	HeartbeatFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "heartbeat_failures_total",
+		Help:      "Heartbeats agent-svc did not accept.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		PollsTotal,
+		CommandsReceivedTotal,
+		CommandsFinishedTotal,
+		ChunkUploadRetriesTotal,
+		ChunkUploadFailuresTotal,
+		CommandSignatureFailuresTotal,
+		HeartbeatFailuresTotal,
+	)
+}
@@ -0,0 +1,337 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"agent-svc/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrRejected is returned for a command the local policy doesn't allow
+var ErrRejected = errors.New("rejected by local policy")
+
+// Policy restricts the commands this node runs, whatever agent-svc sends
+// It is read from a file on the node, so neither agent-svc nor an operator account can loosen it. Every
+// restriction left empty allows everything it covers. A nil *Policy allows every command.
+type Policy struct {
+	// CommandTypes lists the command types the node accepts
+	CommandTypes []string `yaml:"command_types"`
+
+	// Every simple command of a RunCommand, as client.SplitCommandLineAsWritten splits it, must match one of
+	// CommandPatterns in full, or the cmd must be a single command of one of Executables. Commands allowed by
+	// Executables only are run without a shell, so shell syntax is rejected in them.
+	Executables     []string `yaml:"executables"`      // absolute paths
+	CommandPatterns []string `yaml:"command_patterns"` // regular expressions matched against each simple command
+
+	// AllowRedirections lets commands allowed by CommandPatterns redirect input and output; without it a
+	// command with a redirection is rejected whatever the patterns, so df -h.* doesn't allow df -h > /etc/x
+	AllowRedirections bool `yaml:"allow_redirections"`
+
+	// MaxTimeoutSec caps timeout_sec; commands asking for more are rejected
+	MaxTimeoutSec int `yaml:"max_timeout_sec"`
+
+	// WorkingDirs lists the directories, with their subdirectories, commands may run in
+	WorkingDirs []string `yaml:"working_dirs"`
+
+	hash     string
+	patterns []*regexp.Regexp
+}
+
+// Load reads and validates a policy file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local policy: %w", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("local policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates a policy document in YAML
+// Unknown fields are rejected, so a misspelt restriction can't silently allow everything.
+func Parse(data []byte) (*Policy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var p Policy
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	for _, executable := range p.Executables {
+		if !filepath.IsAbs(executable) {
+			return nil, fmt.Errorf("executable %q must be an absolute path", executable)
+		}
+	}
+	for _, pattern := range p.CommandPatterns {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command pattern %q: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	if p.MaxTimeoutSec < 0 {
+		return nil, fmt.Errorf("max_timeout_sec must not be negative")
+	}
+	for i, dir := range p.WorkingDirs {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("working dir %q must be an absolute path", dir)
+		}
+		p.WorkingDirs[i] = filepath.Clean(dir)
+	}
+
+	sum := sha256.Sum256(data)
+	p.hash = "sha256:" + hex.EncodeToString(sum[:])
+	return &p, nil
+}
+
+// Hash identifies the policy file by its content; "" for no policy
+func (p *Policy) Hash() string {
+	if p == nil {
+		return ""
+	}
+	return p.hash
+}
+
+// Check returns an error wrapping ErrRejected if the policy doesn't allow a command
+func (p *Policy) Check(commandType string, payload map[string]interface{}) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.CommandTypes) > 0 && !contains(p.CommandTypes, commandType) {
+		return fmt.Errorf("%w: command type %s is not allowed", ErrRejected, commandType)
+	}
+	if commandType != "RunCommand" {
+		return nil
+	}
+
+	cmdStr, _ := payload["cmd"].(string)
+	if _, err := p.Argv(cmdStr); err != nil {
+		return err
+	}
+
+	if ts, ok := payload["timeout_sec"].(float64); ok && p.MaxTimeoutSec > 0 && int(ts) > p.MaxTimeoutSec {
+		return fmt.Errorf("%w: timeout_sec %d exceeds the maximum of %d", ErrRejected, int(ts), p.MaxTimeoutSec)
+	}
+
+	if len(p.WorkingDirs) > 0 {
+		dir, _ := payload["working_dir"].(string)
+		if dir == "" {
+			// The command runs in the agent's own working directory
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("%w: no working_dir and the agent's working directory is unknown", ErrRejected)
+			}
+			dir = wd
+		}
+		if !p.workingDirAllowed(dir) {
+			return fmt.Errorf("%w: working directory %s is not allowed", ErrRejected, dir)
+		}
+	}
+	return nil
+}
+
+// Argv returns how to run a RunCommand cmd the policy allows: through sh -c, or, for a command allowed by
+// Executables only, as the executable and its arguments without a shell
+func (p *Policy) Argv(cmdStr string) ([]string, error) {
+	shell := []string{"sh", "-c", cmdStr}
+	if p == nil || len(p.Executables) == 0 && len(p.patterns) == 0 {
+		return shell, nil
+	}
+	if len(p.patterns) > 0 {
+		err := p.matchPatterns(cmdStr)
+		if err == nil {
+			return shell, nil
+		}
+		if len(p.Executables) == 0 {
+			return nil, err
+		}
+	}
+
+	argv, err := splitWords(cmdStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("%w: empty command", ErrRejected)
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: executable %s not found", ErrRejected, argv[0])
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	allowed := false
+	for _, executable := range p.Executables {
+		allowed = allowed || resolveDir(executable) == resolveDir(path)
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: executable %s is not allowed", ErrRejected, path)
+	}
+	argv[0] = path
+	return argv, nil
+}
+
+// matchPatterns returns an error wrapping ErrRejected unless cmdStr has simple commands and each matches one of
+// the patterns
+// A command substitution, the script of sh -c or a command run by a wrapper such as sudo is a command of its
+// own, so a pattern allowing df -h.* doesn't allow df -h; rm -rf / or df -h $(rm -rf /). A command line the
+// splitter can't split reliably is rejected, whatever the patterns.
+func (p *Policy) matchPatterns(cmdStr string) error {
+	commands, err := client.SplitCommandLineAsWritten(cmdStr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if len(commands) == 0 {
+		return fmt.Errorf("%w: empty command", ErrRejected)
+	}
+	for _, words := range commands {
+		command := strings.Join(words, " ")
+		if !p.AllowRedirections && hasRedirection(words) {
+			return fmt.Errorf("%w: command %q redirects input or output", ErrRejected, command)
+		}
+		matched := false
+		for _, re := range p.patterns {
+			matched = matched || re.MatchString(command)
+		}
+		if !matched {
+			return fmt.Errorf("%w: command %q matches no allowed pattern", ErrRejected, command)
+		}
+	}
+	return nil
+}
+
+// hasRedirection reports whether the words of a command include a redirection operator such as >, >> or 2>&
+// A quoted argument that looks like one counts too.
+func hasRedirection(words []string) bool {
+	for _, word := range words {
+		if strings.ContainsAny(word, "<>") && strings.Trim(word, "<>&") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDir resolves symbolic links in the directory of an executable's path, so /bin/ls and /usr/bin/ls
+// are the same where /bin links to /usr/bin. The executable itself is left alone: resolving it would make
+// every applet linked to a multi-call binary such as busybox the same executable.
+func resolveDir(path string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, filepath.Base(path))
+}
+
+// TimeoutSec returns the timeout of a command that didn't ask for one, capped at MaxTimeoutSec
+func (p *Policy) TimeoutSec(defaultSec int) int {
+	if p != nil && p.MaxTimeoutSec > 0 && defaultSec > p.MaxTimeoutSec {
+		return p.MaxTimeoutSec
+	}
+	return defaultSec
+}
+
+// workingDirAllowed reports whether dir is one of WorkingDirs or below one
+func (p *Policy) workingDirAllowed(dir string) bool {
+	if !filepath.IsAbs(dir) {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	// Resolve symlinks so a link inside an allowed directory can't lead out of it
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	for _, allowed := range p.WorkingDirs {
+		if resolved, err := filepath.EvalSymlinks(allowed); err == nil {
+			allowed = resolved
+		}
+		if dir == allowed || strings.HasPrefix(dir, allowed+string(filepath.Separator)) || allowed == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords splits a command line into words the way sh does for a single simple command, removing quotes
+// and backslashes; shell syntax that would do more than pass arguments is an error
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unterminated quote")
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '$' || line[i] == '`' {
+					return nil, fmt.Errorf("shell expansion %q is not allowed", line[i])
+				}
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\", line[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+		case c == '\\':
+			if i+1 >= len(line) || line[i+1] == '\n' {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case strings.IndexByte(";&|<>()$`\n*?[~", c) >= 0, c == '#' && !inWord:
+			return nil, fmt.Errorf("shell syntax %q is not allowed", c)
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// contains reports whether values contains s
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package policy
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mustParse(t *testing.T, doc string) *Policy {
+	t.Helper()
+	p, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return p
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{name: "empty policy", doc: "{}"},
+		{name: "every restriction", doc: "command_types: [RunCommand]\nexecutables: [/usr/bin/ls]\n" +
+			"command_patterns: ['df -h']\nmax_timeout_sec: 60\nworking_dirs: [/srv/app/]"},
+		{name: "misspelt field", doc: "command_pattern: ['.*']", wantErr: true},
+		{name: "relative executable", doc: "executables: [ls]", wantErr: true},
+		{name: "invalid pattern", doc: "command_patterns: ['df (']", wantErr: true},
+		{name: "negative timeout", doc: "max_timeout_sec: -1", wantErr: true},
+		{name: "relative working dir", doc: "working_dirs: [srv]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.Hash() == "" {
+				t.Error("Hash() is empty")
+			}
+		})
+	}
+}
+
+func TestCommandPatterns(t *testing.T) {
+	p := mustParse(t, `command_patterns: ['df -h( /[a-z/]*)?', 'systemctl (status|restart) nginx', 'sudo systemctl status nginx']`)
+
+	tests := []struct {
+		name  string
+		cmd   string
+		allow bool
+	}{
+		{name: "matching command", cmd: "df -h /var", allow: true},
+		{name: "quoted words", cmd: `df -h '/var'`, allow: true},
+		{name: "every command matches", cmd: "df -h; systemctl restart nginx", allow: true},
+		{name: "wrapped command matches", cmd: "sudo systemctl status nginx", allow: true},
+		{name: "if of matching commands", cmd: "if df -h; then systemctl restart nginx; fi", allow: true},
+
+		{name: "no matching pattern", cmd: "rm -rf /"},
+		{name: "empty command", cmd: ""},
+		{name: "blank command", cmd: "  "},
+		{name: "command after a separator", cmd: "df -h; rm -rf /"},
+		{name: "command after &&", cmd: "df -h && rm -rf /"},
+		{name: "pipe", cmd: "df -h | sh"},
+		{name: "background command", cmd: "df -h & rm -rf /"},
+		{name: "second line", cmd: "df -h\nrm -rf /"},
+		{name: "command substitution", cmd: "df -h $(rm -rf /)"},
+		{name: "substitution in double quotes", cmd: `df -h "/$(rm -rf /)"`},
+		{name: "backticks", cmd: "df -h `rm -rf /`"},
+		{name: "sh -c", cmd: `sh -c "df -h; rm -rf /"`},
+		{name: "wrapped command", cmd: "sudo rm -rf /"},
+		{name: "if wrapping another command", cmd: "if rm -rf /; then df -h; fi"},
+		{name: "subshell", cmd: "(df -h; rm -rf /)"},
+		{name: "redirection", cmd: "df -h > /etc/passwd"},
+		{name: "other executable of the same name", cmd: "/tmp/df -h"},
+		{name: "assignment", cmd: "LD_PRELOAD=/tmp/x.so df -h"},
+		{name: "assignment only", cmd: "PATH=/tmp; df -h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := p.Argv(tt.cmd)
+			if tt.allow {
+				if err != nil {
+					t.Fatalf("Argv(%q) error = %v, want allowed", tt.cmd, err)
+				}
+				if want := []string{"sh", "-c", tt.cmd}; !reflect.DeepEqual(argv, want) {
+					t.Errorf("Argv(%q) = %q, want %q", tt.cmd, argv, want)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRejected) {
+				t.Errorf("Argv(%q) error = %v, want ErrRejected", tt.cmd, err)
+			}
+		})
+	}
+}
+
+func TestCommandPatternsFailClosed(t *testing.T) {
+	policies := map[string]*Policy{
+		"df -h.*": mustParse(t, `command_patterns: ['df -h.*']`),
+		"any":     mustParse(t, `command_patterns: ['.*']`),
+	}
+	cmds := []string{
+		"df -h $(df -h ')'); touch /tmp/pwned",
+		`df -h $(df -h ")"); touch /tmp/pwned`,
+		`df -h "$(df -h ')')"; touch /tmp/pwned`,
+		`df -h $(df -h \)); touch /tmp/pwned`,
+		"df -h $(df -h '#'); touch /tmp/pwned",
+		"df -h `df -h '`'`; touch /tmp/pwned",
+		"df -h $(df -h $(df -h ')')); touch /tmp/pwned",
+		"df -h $(df -h `df -h`)",
+		"df -h $(case x in x) df -h;; esac); touch /tmp/pwned",
+		"df -h <<EOF\ntouch /tmp/pwned\nEOF",
+		"df -h <<-EOF\n\ttouch /tmp/pwned\n\tEOF",
+		"df -h 'x; touch /tmp/pwned",
+		"df -h $(touch /tmp/pwned",
+		"df -h ) touch /tmp/pwned",
+		"df -h > /etc/cron.d/x",
+		"df -h >> /etc/cron.d/x",
+		"df -h 2>&1 >/etc/cron.d/x",
+		"df -h < /etc/shadow",
+		"df -h <<< x",
+		"df -h | tee /etc/cron.d/x > /dev/null",
+	}
+	for name, p := range policies {
+		for _, cmd := range cmds {
+			t.Run(name+"/"+cmd, func(t *testing.T) {
+				if argv, err := p.Argv(cmd); !errors.Is(err, ErrRejected) {
+					t.Errorf("Argv(%q) = %q, %v, want ErrRejected", cmd, argv, err)
+				}
+			})
+		}
+	}
+
+	// Redirections are fine once the policy allows them
+	p := mustParse(t, "command_patterns: ['df -h.*']\nallow_redirections: true")
+	if _, err := p.Argv("df -h > /tmp/df.txt 2>&1"); err != nil {
+		t.Errorf("Argv() with allow_redirections error = %v", err)
+	}
+	if _, err := p.Argv("df -h <<EOF\nx\nEOF"); !errors.Is(err, ErrRejected) {
+		t.Errorf("Argv() of a here-document with allow_redirections error = %v, want ErrRejected", err)
+	}
+}
+
+func TestExecutables(t *testing.T) {
+	ls, err := exec.LookPath("ls")
+	if err != nil {
+		t.Skip("ls not found")
+	}
+	ls, _ = filepath.Abs(ls)
+	p := mustParse(t, "executables: ["+ls+"]\ncommand_patterns: ['df -h']")
+
+	tests := []struct {
+		name string
+		cmd  string
+		want []string
+	}{
+		{name: "allowed executable", cmd: "ls -l /tmp", want: []string{ls, "-l", "/tmp"}},
+		{name: "quoted argument", cmd: `ls "a b" 'c;d'`, want: []string{ls, "a b", "c;d"}},
+		{name: "pattern runs in a shell", cmd: "df -h", want: []string{"sh", "-c", "df -h"}},
+
+		{name: "other executable", cmd: "cat /etc/passwd"},
+		{name: "separator", cmd: "ls; rm -rf /"},
+		{name: "expansion", cmd: `ls "$HOME"`},
+		{name: "substitution", cmd: "ls $(rm -rf /)"},
+		{name: "glob", cmd: "ls *"},
+		{name: "redirection", cmd: "ls > /etc/passwd"},
+		{name: "unterminated quote", cmd: "ls 'a"},
+		{name: "empty command", cmd: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := p.Argv(tt.cmd)
+			if tt.want == nil {
+				if !errors.Is(err, ErrRejected) {
+					t.Errorf("Argv(%q) = %q, %v, want ErrRejected", tt.cmd, argv, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Argv(%q) error = %v", tt.cmd, err)
+			}
+			if !reflect.DeepEqual(argv, tt.want) {
+				t.Errorf("Argv(%q) = %q, want %q", tt.cmd, argv, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	p := mustParse(t, "command_types: [RunCommand]\ncommand_patterns: ['uptime']\nmax_timeout_sec: 60\n"+
+		"working_dirs: ["+dir+"]")
+
+	tests := []struct {
+		name        string
+		commandType string
+		payload     map[string]interface{}
+		allow       bool
+	}{
+		{name: "allowed", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "timeout_sec": float64(60), "working_dir": dir}, allow: true},
+		{name: "subdirectory", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "working_dir": filepath.Join(dir, "sub")}, allow: true},
+		{name: "command type", commandType: "UpdateAgent", payload: map[string]interface{}{}},
+		{name: "command", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime; reboot", "working_dir": dir}},
+		{name: "timeout", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "timeout_sec": float64(61), "working_dir": dir}},
+		{name: "working dir", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "working_dir": "/etc"}},
+		{name: "working dir prefix", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "working_dir": dir + "x"}},
+		{name: "relative working dir", commandType: "RunCommand",
+			payload: map[string]interface{}{"cmd": "uptime", "working_dir": "."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.commandType, tt.payload)
+			if tt.allow && err != nil {
+				t.Errorf("Check() error = %v, want allowed", err)
+			}
+			if !tt.allow && !errors.Is(err, ErrRejected) {
+				t.Errorf("Check() error = %v, want ErrRejected", err)
+			}
+		})
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *Policy
+	if err := p.Check("RunCommand", map[string]interface{}{"cmd": "rm -rf /"}); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	if argv, err := p.Argv("rm -rf /"); err != nil || !reflect.DeepEqual(argv, []string{"sh", "-c", "rm -rf /"}) {
+		t.Errorf("Argv() = %q, %v", argv, err)
+	}
+	if p.Hash() != "" {
+		t.Errorf("Hash() = %q, want empty", p.Hash())
+	}
+	if got := p.TimeoutSec(300); got != 300 {
+		t.Errorf("TimeoutSec(300) = %d, want 300", got)
+	}
+}
+
+func TestTimeoutSec(t *testing.T) {
+	p := mustParse(t, "max_timeout_sec: 60")
+	if got := p.TimeoutSec(300); got != 60 {
+		t.Errorf("TimeoutSec(300) = %d, want 60", got)
+	}
+	if got := p.TimeoutSec(30); got != 30 {
+		t.Errorf("TimeoutSec(30) = %d, want 30", got)
+	}
+}
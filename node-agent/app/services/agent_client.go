@@ -2,137 +2,169 @@ package services
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"time"
 
+	"agent-svc/client"
+	"agent-svc/client/dto"
+	"agent-svc/rpc"
 	"node-agent/app/clients"
 )
 
-// AgentClient provides high-level API methods for agent-svc
+// AgentClient provides the agent-svc calls node-agent makes, on top of the agent-svc client
+// With a session, heartbeats, polls, log pushes and status updates go over the gRPC AgentSession stream
+// instead; registration always uses the HTTP API.
 type AgentClient struct {
-	httpClient *clients.HTTPClient
-}
-
-// GetHTTPClient returns the underlying HTTP client (for token updates)
-func (c *AgentClient) GetHTTPClient() *clients.HTTPClient {
-	return c.httpClient
+	api     *client.Client
+	session *clients.AgentSession
 }
 
 // NewAgentClient creates a new agent client
-func NewAgentClient(httpClient *clients.HTTPClient) *AgentClient {
+func NewAgentClient(api *client.Client) *AgentClient {
 	return &AgentClient{
-		httpClient: httpClient,
+		api: api,
 	}
 }
 
-// RegisterAgent registers the node via HTTP
-func (c *AgentClient) RegisterAgent(ctx context.Context, nodeID string, attrs map[string]interface{}) (string, error) {
-	result, err := c.httpClient.DoRequest(ctx, "POST", "/v1/agents/register", map[string]interface{}{
-		"node_id": nodeID,
-		"attrs":   attrs,
-	}, func(resp *http.Response) (interface{}, error) {
-		var result struct {
-			Token string `json:"token"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
-		return result.Token, nil
-	})
-	if err != nil {
-		return "", err
+// NewSessionAgentClient creates an agent client making node calls over session
+func NewSessionAgentClient(api *client.Client, session *clients.AgentSession) *AgentClient {
+	return &AgentClient{
+		api:     api,
+		session: session,
 	}
-	return result.(string), nil
 }
 
-// Heartbeat sends a heartbeat via HTTP
-func (c *AgentClient) Heartbeat(ctx context.Context, nodeID string) error {
-	_, err := c.httpClient.DoRequest(ctx, "POST", "/v1/agents/heartbeat", map[string]interface{}{
-		"node_id": nodeID,
-	}, func(resp *http.Response) (interface{}, error) {
-		return nil, nil
+// RegisterAgent registers the node, enrolling it into the tenant of enrollment, and returns its token along
+// with agent-svc's command signing key
+func (c *AgentClient) RegisterAgent(ctx context.Context, nodeID string, attrs map[string]interface{}, enrollment Enrollment) (*dto.RegisterResponse, error) {
+	return c.api.Register(ctx, dto.RegisterRequest{
+		NodeID:        nodeID,
+		Attrs:         attrs,
+		TenantID:      enrollment.TenantID,
+		EnrollmentKey: enrollment.Key,
 	})
-	return err
 }
 
-// PollCommands polls for commands
-func (c *AgentClient) PollCommands(ctx context.Context, nodeID string, maxWaitSeconds int) ([]map[string]interface{}, error) {
-	path := fmt.Sprintf("/v1/commands/next?node_id=%s&wait=%d", nodeID, maxWaitSeconds)
-	result, err := c.httpClient.DoRequest(ctx, "GET", path, nil, func(resp *http.Response) (interface{}, error) {
-		var cmdResp map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&cmdResp); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
+// Heartbeat sends a heartbeat, reporting the hash of the local policy
+func (c *AgentClient) Heartbeat(ctx context.Context, nodeID, policyHash string) error {
+	if c.session != nil {
+		_, err := c.session.Call(ctx, &rpc.AgentMessage{Body: &rpc.AgentMessage_Heartbeat{Heartbeat: &rpc.Heartbeat{PolicyHash: policyHash}}})
+		return err
+	}
+	return c.api.Heartbeat(ctx, nodeID, policyHash)
+}
 
-		if commands, ok := cmdResp["commands"].([]interface{}); ok {
-			if len(commands) == 0 {
-				return nil, nil
-			}
-			cmdMaps := make([]map[string]interface{}, len(commands))
-			for i, cmd := range commands {
-				if cmdMap, ok := cmd.(map[string]interface{}); ok {
-					cmdMaps[i] = cmdMap
-				} else {
-					return nil, fmt.Errorf("invalid command format")
-				}
-			}
-			return cmdMaps, nil
-		}
+// PollCommands polls for commands
+func (c *AgentClient) PollCommands(ctx context.Context, maxWaitSeconds int) ([]dto.CommandResponse, error) {
+	if c.session != nil {
+		return c.pollSession(ctx, maxWaitSeconds)
+	}
+	return c.api.PollCommands(ctx, time.Duration(maxWaitSeconds)*time.Second)
+}
 
-		if cmdResp["command_id"] == nil || cmdResp["command_id"] == "" {
-			return nil, nil
-		}
-		return []map[string]interface{}{cmdResp}, nil
-	})
+// pollSession polls for commands over the session, returning them as the HTTP API would
+func (c *AgentClient) pollSession(ctx context.Context, maxWaitSeconds int) ([]dto.CommandResponse, error) {
+	resp, err := c.session.Call(ctx, &rpc.AgentMessage{Body: &rpc.AgentMessage_PollCommands{
+		PollCommands: &rpc.PollCommands{WaitSec: int32(maxWaitSeconds)},
+	}})
 	if err != nil {
 		return nil, err
 	}
-	if result == nil {
-		return nil, nil
+
+	cmds := make([]dto.CommandResponse, len(resp.GetCommands().GetCommands()))
+	for i, cmd := range resp.GetCommands().GetCommands() {
+		cmds[i] = dto.CommandResponse{
+			CommandID:    cmd.CommandId,
+			CommandType:  cmd.CommandType,
+			Payload:      cmd.Payload.AsMap(),
+			Priority:     int(cmd.Priority),
+			TraceContext: cmd.TraceContext,
+		}
+		if cmd.ExpiresAt != nil {
+			expiresAt := cmd.ExpiresAt.AsTime().Format(time.RFC3339Nano)
+			cmds[i].ExpiresAt = &expiresAt
+		}
+		if cmd.Signature != "" {
+			cmds[i].Signature = cmd.Signature
+			signatureExpiresAt := cmd.SignatureExpiresAt.AsTime().Format(time.RFC3339Nano)
+			cmds[i].SignatureExpiresAt = &signatureExpiresAt
+		}
 	}
-	return result.([]map[string]interface{}), nil
+	return cmds, nil
 }
 
-// PushCommandLogs pushes command execution log chunks via HTTP
-func (c *AgentClient) PushCommandLogs(ctx context.Context, commandID string, chunks []map[string]interface{}) ([]int64, error) {
+// PushCommandLogs pushes command execution log chunks
+func (c *AgentClient) PushCommandLogs(ctx context.Context, commandID string, chunks []dto.LogChunkRequest) ([]int64, error) {
 	if len(chunks) == 0 {
 		return []int64{}, nil
 	}
 
-	result, err := c.httpClient.DoRequest(ctx, "POST", "/v1/commands/logs", map[string]interface{}{
-		"command_id": commandID,
-		"chunks":     chunks,
-	}, func(resp *http.Response) (interface{}, error) {
-		var result struct {
-			AckedOffsets []int64 `json:"acked_offsets"`
+	if c.session != nil {
+		push := &rpc.PushLogs{CommandId: commandID, Chunks: make([]*rpc.LogChunk, len(chunks))}
+		for i, chunk := range chunks {
+			push.Chunks[i] = &rpc.LogChunk{
+				ChunkIndex: chunk.ChunkIndex,
+				Stream:     chunk.Stream,
+				Data:       chunk.Data,
+				IsFinal:    chunk.IsFinal,
+			}
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+		resp, err := c.session.Call(ctx, &rpc.AgentMessage{Body: &rpc.AgentMessage_PushLogs{PushLogs: push}})
+		if err != nil {
+			return nil, err
 		}
-		return result.AckedOffsets, nil
-	})
-	if err != nil {
-		return nil, err
+		return resp.GetLogAck().GetAckedChunkIndexes(), nil
 	}
-	return result.([]int64), nil
+
+	return c.api.PushCommandLogs(ctx, dto.PushCommandLogsRequest{
+		CommandID: commandID,
+		Chunks:    chunks,
+	})
 }
 
-// UpdateCommandStatus updates command status via HTTP
+// UpdateCommandStatus updates command status
 func (c *AgentClient) UpdateCommandStatus(ctx context.Context, commandID, status string, exitCode int32, errorMsg string) error {
-	payload := map[string]interface{}{
-		"command_id": commandID,
-		"status":     status,
+	return c.updateStatus(ctx, commandStatusRequest(commandID, status, exitCode, errorMsg))
+}
+
+// UpdateCommandResult reports the final status of a command along with its output statistics
+func (c *AgentClient) UpdateCommandResult(ctx context.Context, commandID, status string, exitCode int32, errorMsg string, outputBytes int64, outputTruncated bool) error {
+	req := commandStatusRequest(commandID, status, exitCode, errorMsg)
+	req.OutputBytes = &outputBytes
+	req.OutputTruncated = outputTruncated
+	return c.updateStatus(ctx, req)
+}
+
+// updateStatus sends a status update over the session, or the HTTP API without one
+func (c *AgentClient) updateStatus(ctx context.Context, req dto.CommandStatusRequest) error {
+	if c.session == nil {
+		return c.api.UpdateCommandStatus(ctx, req)
 	}
-	if exitCode != 0 {
-		payload["exit_code"] = exitCode
+
+	update := &rpc.UpdateStatus{
+		CommandId:       req.CommandID,
+		Status:          req.Status,
+		ErrorMsg:        req.ErrorMsg,
+		OutputBytes:     req.OutputBytes,
+		OutputTruncated: req.OutputTruncated,
 	}
-	if errorMsg != "" {
-		payload["error_msg"] = errorMsg
+	if req.ExitCode != nil {
+		exitCode := int32(*req.ExitCode)
+		update.ExitCode = &exitCode
 	}
-
-	_, err := c.httpClient.DoRequest(ctx, "POST", "/v1/commands/status", payload, func(resp *http.Response) (interface{}, error) {
-		return nil, nil
-	})
+	_, err := c.session.Call(ctx, &rpc.AgentMessage{Body: &rpc.AgentMessage_UpdateStatus{UpdateStatus: update}})
 	return err
 }
+
+// commandStatusRequest builds the request body for a status update; a zero exit code is left out
+func commandStatusRequest(commandID, status string, exitCode int32, errorMsg string) dto.CommandStatusRequest {
+	req := dto.CommandStatusRequest{
+		CommandID: commandID,
+		Status:    status,
+		ErrorMsg:  errorMsg,
+	}
+	if exitCode != 0 {
+		code := int(exitCode)
+		req.ExitCode = &code
+	}
+	return req
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"agent-svc/client/dto"
+	"node-agent/app/metrics"
 	"node-agent/app/storage"
 	"node-agent/app/utils"
 )
@@ -63,20 +65,25 @@ func (c *ChunkStorageRetryService) UploadChunksForCommand(ctx context.Context, c
 		return nil
 	}
 
-	// Convert to map format for AgentClient
-	chunkMaps := make([]map[string]interface{}, len(chunks))
+	logChunks := make([]dto.LogChunkRequest, len(chunks))
 	for i, chunk := range chunks {
-		chunkMaps[i] = map[string]interface{}{
-			"chunk_index": chunk.ChunkIndex,
-			"stream":      chunk.Stream,
-			"data":        chunk.Data,
-			"is_final":    isFinal,
+		logChunks[i] = dto.LogChunkRequest{
+			ChunkIndex: chunk.ChunkIndex,
+			Stream:     chunk.Stream,
+			Data:       chunk.Data,
+			IsFinal:    isFinal,
 		}
 	}
 
 	// Retry with backoff
+	attempt := 0
 	err = utils.RetryWithBackoff(5, 1*time.Second, 30*time.Second, func() error {
-		ackedChunkIndexes, err := c.agentClient.PushCommandLogs(ctx, commandID, chunkMaps)
+		attempt++
+		if attempt > 1 {
+			metrics.ChunkUploadRetriesTotal.Inc()
+		}
+
+		ackedChunkIndexes, err := c.agentClient.PushCommandLogs(ctx, commandID, logChunks)
 		if err != nil {
 			return err
 		}
@@ -111,6 +118,9 @@ func (c *ChunkStorageRetryService) UploadChunksForCommand(ctx context.Context, c
 
 		return nil
 	})
+	if err != nil {
+		metrics.ChunkUploadFailuresTotal.Inc()
+	}
 
 	return err
 }
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"node-agent/app/metrics"
+	"node-agent/app/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// debugQueryTimeout bounds the SQLite queries made for a metrics scrape or state dump
+const debugQueryTimeout = 5 * time.Second
+
+// DebugServer serves Prometheus metrics at /metrics and a JSON state dump at /debug/state on a
+// loopback address or unix socket. It has no authentication, so it never listens on other interfaces.
+type DebugServer struct {
+	listen    string
+	nodeID    string
+	storage   *storage.Store
+	runtime   *RuntimeService
+	heartbeat *HeartbeatService
+}
+
+// NewDebugServer creates a debug server
+// listen is "host:port" with a loopback host, or "unix:" followed by a socket path.
+func NewDebugServer(listen, nodeID string, store *storage.Store, runtime *RuntimeService, heartbeat *HeartbeatService) *DebugServer {
+	return &DebugServer{
+		listen:    listen,
+		nodeID:    nodeID,
+		storage:   store,
+		runtime:   runtime,
+		heartbeat: heartbeat,
+	}
+}
+
+// ValidateDebugListen checks that a debug listen address is a unix socket or a loopback TCP address
+func ValidateDebugListen(listen string) error {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("unix socket path is required")
+		}
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("debug endpoint must listen on a loopback address, got %q", host)
+	}
+	return nil
+}
+
+// Start serves until ctx is cancelled
+func (d *DebugServer) Start(ctx context.Context) error {
+	listener, err := d.createListener()
+	if err != nil {
+		return err
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&stateCollector{debug: d})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{metrics.Registry, registry}, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+	mux.HandleFunc("/debug/state", d.handleState)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("debug endpoint listening on %s", d.listen)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// createListener listens on the configured unix socket or loopback address
+func (d *DebugServer) createListener() (net.Listener, error) {
+	if err := ValidateDebugListen(d.listen); err != nil {
+		return nil, err
+	}
+
+	path, ok := strings.CutPrefix(d.listen, "unix:")
+	if !ok {
+		return net.Listen("tcp", d.listen)
+	}
+
+	// A socket left behind by a previous run would make Listen fail
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// debugState is the JSON document served at /debug/state
+type debugState struct {
+	NodeID          string                      `json:"node_id"`
+	Now             time.Time                   `json:"now"`
+	LastHeartbeatAt *time.Time                  `json:"last_heartbeat_at,omitempty"`
+	HeartbeatAgeSec *float64                    `json:"heartbeat_age_sec,omitempty"`
+	Runtime         RuntimeState                `json:"runtime"`
+	SQLiteRows      map[string]map[string]int64 `json:"sqlite_rows,omitempty"`
+	SQLiteRowsError string                      `json:"sqlite_rows_error,omitempty"`
+}
+
+// handleState serves a JSON dump of the runtime state and in-flight commands
+func (d *DebugServer) handleState(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), debugQueryTimeout)
+	defer cancel()
+
+	now := time.Now()
+	state := debugState{
+		NodeID:  d.nodeID,
+		Now:     now,
+		Runtime: d.runtime.State(),
+	}
+	if last := d.heartbeat.LastHeartbeat(); !last.IsZero() {
+		age := now.Sub(last).Seconds()
+		state.LastHeartbeatAt = &last
+		state.HeartbeatAgeSec = &age
+	}
+
+	rows, err := d.storage.CountRowsByStatus(ctx)
+	if err != nil {
+		state.SQLiteRowsError = err.Error()
+	} else {
+		state.SQLiteRows = rows
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(state)
+}
+
+var (
+	workersDesc = prometheus.NewDesc("node_agent_workers", "Command workers, by lane.", []string{"lane"}, nil)
+	busyDesc    = prometheus.NewDesc("node_agent_workers_busy", "Command workers running a command, by lane.", []string{"lane"}, nil)
+	depthDesc   = prometheus.NewDesc("node_agent_command_queue_depth", "Commands waiting in the worker channel, by lane.", []string{"lane"}, nil)
+	capDesc     = prometheus.NewDesc("node_agent_command_queue_capacity", "Size of the worker channel, by lane.", []string{"lane"}, nil)
+	sqliteDesc  = prometheus.NewDesc("node_agent_sqlite_rows", "Rows in the local SQLite tables, by table and status.", []string{"table", "status"}, nil)
+	ageDesc     = prometheus.NewDesc("node_agent_heartbeat_age_seconds", "Time since agent-svc last accepted a heartbeat.", nil, nil)
+)
+
+// stateCollector reports the runtime state, SQLite row counts and heartbeat age at scrape time
+type stateCollector struct {
+	debug *DebugServer
+}
+
+// Describe implements prometheus.Collector
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{workersDesc, busyDesc, depthDesc, capDesc, sqliteDesc, ageDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	state := c.debug.runtime.State()
+	for lane, l := range state.Lanes {
+		ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(l.Workers), lane)
+		ch <- prometheus.MustNewConstMetric(busyDesc, prometheus.GaugeValue, float64(l.BusyWorkers), lane)
+		ch <- prometheus.MustNewConstMetric(depthDesc, prometheus.GaugeValue, float64(l.QueueDepth), lane)
+		ch <- prometheus.MustNewConstMetric(capDesc, prometheus.GaugeValue, float64(l.QueueCapacity), lane)
+	}
+
+	// Reported only once a heartbeat succeeded, so a never-connected agent doesn't look freshly seen
+	if last := c.debug.heartbeat.LastHeartbeat(); !last.IsZero() {
+		ch <- prometheus.MustNewConstMetric(ageDesc, prometheus.GaugeValue, time.Since(last).Seconds())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), debugQueryTimeout)
+	defer cancel()
+	rows, err := c.debug.storage.CountRowsByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(sqliteDesc, err)
+		return
+	}
+	for table, statuses := range rows {
+		for status, count := range statuses {
+			ch <- prometheus.MustNewConstMetric(sqliteDesc, prometheus.GaugeValue, float64(count), table, status)
+		}
+	}
+}
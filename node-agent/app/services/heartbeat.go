@@ -2,12 +2,18 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"node-agent/app/clients"
+	"agent-svc/client"
+	"node-agent/app/metrics"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // HeartbeatService handles heartbeat via HTTP
@@ -16,17 +22,24 @@ type HeartbeatService struct {
 	nodeID              string
 	interval            time.Duration
 	registrationService *RegistrationService
-	httpClient          *clients.HTTPClient
+	auth                *client.TokenAuth
+	policyHash          string
+
+	// lastSuccess is the Unix nanosecond time of the last accepted heartbeat, 0 if none yet
+	lastSuccess atomic.Int64
 }
 
 // NewHeartbeatService creates a new HTTP heartbeat service
-func NewHeartbeatService(agentClient *AgentClient, nodeID string, intervalSec int, registrationService *RegistrationService, httpClient *clients.HTTPClient) *HeartbeatService {
+// auth is the token authentication of agentClient, which is given the new token when the node re-registers.
+// policyHash is the hash of the local policy reported with every heartbeat, "" without one.
+func NewHeartbeatService(agentClient *AgentClient, nodeID string, intervalSec int, registrationService *RegistrationService, auth *client.TokenAuth, policyHash string) *HeartbeatService {
 	return &HeartbeatService{
 		agentClient:         agentClient,
 		nodeID:              nodeID,
 		interval:            time.Duration(intervalSec) * time.Second,
 		registrationService: registrationService,
-		httpClient:          httpClient,
+		auth:                auth,
+		policyHash:          policyHash,
 	}
 }
 
@@ -50,29 +63,50 @@ func (h *HeartbeatService) Start(ctx context.Context) {
 
 // sendHeartbeat sends a heartbeat request via HTTP
 func (h *HeartbeatService) sendHeartbeat(ctx context.Context) {
-	if err := h.agentClient.Heartbeat(ctx, h.nodeID); err != nil {
-		// Check if error indicates node not found (404 status code)
-		if isNodeNotFoundError(err) {
-			log.Printf("heartbeat failed: node not registered. Re-registering...")
-			if err := h.reRegister(ctx); err != nil {
-				log.Printf("re-registration failed: %v", err)
-			}
-		} else {
-			// Log other errors but don't fail - heartbeat is best effort
-			fmt.Printf("heartbeat failed: %v\n", err)
+	err := h.agentClient.Heartbeat(ctx, h.nodeID, h.policyHash)
+	if err == nil {
+		h.lastSuccess.Store(time.Now().UnixNano())
+		return
+	}
+
+	metrics.HeartbeatFailuresTotal.Inc()
+	// Check if error indicates node not found (404 status code)
+	if isNodeNotFoundError(err) {
+		log.Printf("heartbeat failed: node not registered. Re-registering...")
+		if err := h.reRegister(ctx); err != nil {
+			log.Printf("re-registration failed: %v", err)
 		}
+	} else {
+		// Log other errors but don't fail - heartbeat is best effort
+		fmt.Printf("heartbeat failed: %v\n", err)
 	}
 }
 
-// isNodeNotFoundError checks if the error indicates the node is not registered (404 status code)
+// LastHeartbeat returns the time of the last heartbeat agent-svc accepted, or the zero time if none was
+func (h *HeartbeatService) LastHeartbeat() time.Time {
+	nanos := h.lastSuccess.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// isNodeNotFoundError checks if the error indicates the node is not registered (404 status code, or
+// NOT_FOUND over an agent session)
 func isNodeNotFoundError(err error) bool {
 	if err == nil {
 		return false
 	}
 
-	// Check if it's an HTTPError with 404 status code
-	if httpErr, ok := err.(*clients.HTTPError); ok {
-		return httpErr.GetStatusCode() == 404
+	// Check if it's an APIError with 404 status code
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == 404
+	}
+
+	// Over an agent session, agent-svc answers with NOT_FOUND
+	if status.Code(err) == codes.NotFound {
+		return true
 	}
 
 	// Fallback: check error string for backward compatibility
@@ -88,11 +122,8 @@ func (h *HeartbeatService) reRegister(ctx context.Context) error {
 		return err
 	}
 
-	// Update HTTP client token
-	h.httpClient.UpdateToken(token)
-
-	// Update agent client's HTTP client token
-	h.agentClient.GetHTTPClient().UpdateToken(token)
+	// Authenticate subsequent requests with the new token
+	h.auth.SetToken(token)
 
 	// Update nodeID if it changed (shouldn't happen, but be safe)
 	h.nodeID = ident.NodeID
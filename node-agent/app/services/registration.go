@@ -2,28 +2,57 @@ package services
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"log"
 
+	"agent-svc/client"
 	"node-agent/app/clients"
 	"node-agent/app/identity"
 	"node-agent/app/utils"
 )
 
+// Enrollment names the tenant a node enrolls into and the tenant's enrollment key
+// An empty TenantID enrolls into agent-svc's default tenant, which needs no key.
+type Enrollment struct {
+	TenantID string
+	Key      string
+}
+
+// ErrCommandSigningKeyChanged is returned when agent-svc offers another command signing key than the pinned one
+var ErrCommandSigningKeyChanged = errors.New("agent-svc offered a command signing key other than the pinned one")
+
 // RegistrationService handles node registration and re-registration
 type RegistrationService struct {
 	agentSvcURL string
+	enrollment  Enrollment
 	identityMgr *identity.Manager
+
+	// signingKey is the command signing key an operator configured, "" if none; a re-registration offering it
+	// replaces the pinned key
+	signingKey string
+
+	// onKeyPinned is called with a key pinned when re-registering, nil if nothing needs to know
+	onKeyPinned func(ed25519.PublicKey)
 }
 
 // NewRegistrationService creates a new registration service
-func NewRegistrationService(agentSvcURL string, identityMgr *identity.Manager) *RegistrationService {
+// signingKey is the command signing key configured with COMMAND_SIGNING_PUBLIC_KEY, "" if none.
+func NewRegistrationService(agentSvcURL string, enrollment Enrollment, identityMgr *identity.Manager, signingKey string) *RegistrationService {
 	return &RegistrationService{
 		agentSvcURL: agentSvcURL,
+		enrollment:  enrollment,
 		identityMgr: identityMgr,
+		signingKey:  signingKey,
 	}
 }
 
+// OnKeyPinned sets a function called with the command signing key when re-registering pins one
+func (r *RegistrationService) OnKeyPinned(fn func(ed25519.PublicKey)) {
+	r.onKeyPinned = fn
+}
+
 // Register registers a new node (generates new node_id)
 func (r *RegistrationService) Register(ctx context.Context) (*identity.Identity, string, error) {
 	// Collect metadata
@@ -50,19 +79,27 @@ func (r *RegistrationService) Register(ctx context.Context) (*identity.Identity,
 	}
 
 	// Register with agent-svc
-	httpClient := clients.NewHTTPClient(r.agentSvcURL, "")
-	agentClient := NewAgentClient(httpClient)
+	agentClient := NewAgentClient(clients.NewAgentSvcClient(r.agentSvcURL, nil))
 
-	token, err := agentClient.RegisterAgent(ctx, nodeID, attrs)
+	resp, err := agentClient.RegisterAgent(ctx, nodeID, attrs, r.enrollment)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to register: %w", err)
 	}
 
+	// Pin the command signing key; commands are only accepted with a signature made with it from now on
+	if resp.CommandSigningKey != "" {
+		if _, err := client.ParseCommandSigningKey(resp.CommandSigningKey); err != nil {
+			return nil, "", err
+		}
+		log.Printf("pinned command signing key %s", resp.CommandSigningKey)
+	}
+
 	// Create identity
 	ident := &identity.Identity{
-		NodeID:   nodeID,
-		JWTToken: token,
-		Metadata: attrs,
+		NodeID:            nodeID,
+		JWTToken:          resp.Token,
+		Metadata:          attrs,
+		CommandSigningKey: resp.CommandSigningKey,
 	}
 
 	// Save identity
@@ -71,7 +108,7 @@ func (r *RegistrationService) Register(ctx context.Context) (*identity.Identity,
 	}
 
 	log.Printf("registered node: %s", nodeID)
-	return ident, token, nil
+	return ident, resp.Token, nil
 }
 
 // ReRegister re-registers an existing node using identity from file
@@ -94,20 +131,55 @@ func (r *RegistrationService) ReRegister(ctx context.Context) (*identity.Identit
 	}
 
 	// Register with agent-svc
-	httpClient := clients.NewHTTPClient(r.agentSvcURL, "")
-	agentClient := NewAgentClient(httpClient)
+	agentClient := NewAgentClient(clients.NewAgentSvcClient(r.agentSvcURL, nil))
 
-	token, err := agentClient.RegisterAgent(ctx, ident.NodeID, attrs)
+	resp, err := agentClient.RegisterAgent(ctx, ident.NodeID, attrs, r.enrollment)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to re-register: %w", err)
 	}
 
+	if err := r.pinCommandSigningKey(ident, resp.CommandSigningKey); err != nil {
+		return nil, "", err
+	}
+
 	// Update token in identity
-	ident.JWTToken = token
+	ident.JWTToken = resp.Token
 	if err := r.identityMgr.Save(ident); err != nil {
 		return nil, "", fmt.Errorf("failed to save identity: %w", err)
 	}
 
 	log.Printf("re-registered node: %s", ident.NodeID)
-	return ident, token, nil
+	return ident, resp.Token, nil
+}
+
+// pinCommandSigningKey pins the command signing key agent-svc offered on re-registration in ident if no key is
+// pinned yet, as for a node enrolled before agent-svc signed commands, or if an operator rotated to it by
+// configuring it
+// Whoever answers a re-registration must not be able to replace the pinned key, so any other key is refused
+// with ErrCommandSigningKeyChanged. A node whose pinned key is kept when agent-svc offers none still refuses
+// unsigned commands.
+func (r *RegistrationService) pinCommandSigningKey(ident *identity.Identity, offered string) error {
+	switch {
+	case offered == "" || offered == ident.CommandSigningKey:
+		if offered == "" && ident.CommandSigningKey != "" {
+			log.Printf("agent-svc offered no command signing key, keeping the pinned key %s", ident.CommandSigningKey)
+		}
+		return nil
+	case r.signingKey != "" && offered != r.signingKey:
+		return fmt.Errorf("%w: offered %s, configured %s", ErrCommandSigningKeyChanged, offered, r.signingKey)
+	case r.signingKey == "" && ident.CommandSigningKey != "":
+		return fmt.Errorf("%w: offered %s, pinned %s; set COMMAND_SIGNING_PUBLIC_KEY to the new key to rotate it",
+			ErrCommandSigningKeyChanged, offered, ident.CommandSigningKey)
+	}
+
+	key, err := client.ParseCommandSigningKey(offered)
+	if err != nil {
+		return err
+	}
+	ident.CommandSigningKey = offered
+	log.Printf("pinned command signing key %s", offered)
+	if r.onKeyPinned != nil && r.signingKey == "" {
+		r.onKeyPinned(key)
+	}
+	return nil
 }
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"agent-svc/client"
+	"node-agent/app/identity"
+)
+
+func TestPinCommandSigningKey(t *testing.T) {
+	newKey := func() string {
+		public, _, _ := ed25519.GenerateKey(nil)
+		return client.EncodeCommandSigningKey(public)
+	}
+	pinned, offered := newKey(), newKey()
+
+	tests := []struct {
+		name       string
+		configured string
+		pinned     string
+		offered    string
+		wantPinned string
+		wantErr    error
+		wantNotify bool
+	}{
+		{name: "same key", pinned: pinned, offered: pinned, wantPinned: pinned},
+		{name: "no key offered", pinned: pinned, offered: "", wantPinned: pinned},
+		{name: "no key offered or pinned"},
+		{name: "first sight", offered: offered, wantPinned: offered, wantNotify: true},
+		{name: "changed key", pinned: pinned, offered: offered, wantPinned: pinned, wantErr: ErrCommandSigningKeyChanged},
+		{name: "rotated by the operator", configured: offered, pinned: pinned, offered: offered, wantPinned: offered},
+		{name: "other than the configured key", configured: newKey(), pinned: pinned, offered: offered, wantPinned: pinned,
+			wantErr: ErrCommandSigningKeyChanged},
+		{name: "first sight, other than the configured key", configured: newKey(), offered: offered,
+			wantErr: ErrCommandSigningKeyChanged},
+		{name: "invalid key", offered: "not base64!", wantErr: errors.New("invalid command signing key")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistrationService("", Enrollment{}, nil, tt.configured)
+			notified := false
+			r.OnKeyPinned(func(ed25519.PublicKey) { notified = true })
+			ident := &identity.Identity{NodeID: "node-1", CommandSigningKey: tt.pinned}
+
+			err := r.pinCommandSigningKey(ident, tt.offered)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("pinCommandSigningKey() error = %v, want nil", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("pinCommandSigningKey() error = nil, want %v", tt.wantErr)
+			case errors.Is(tt.wantErr, ErrCommandSigningKeyChanged) && !errors.Is(err, ErrCommandSigningKeyChanged):
+				t.Fatalf("pinCommandSigningKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if ident.CommandSigningKey != tt.wantPinned {
+				t.Errorf("pinned key = %q, want %q", ident.CommandSigningKey, tt.wantPinned)
+			}
+			if notified != tt.wantNotify {
+				t.Errorf("OnKeyPinned called = %v, want %v", notified, tt.wantNotify)
+			}
+		})
+	}
+}
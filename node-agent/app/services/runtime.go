@@ -2,15 +2,61 @@ package services
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
+	"sync"
 	"time"
 
+	"agent-svc/client"
+	"agent-svc/client/dto"
 	"node-agent/app/executor"
+	"node-agent/app/metrics"
+	"node-agent/app/policy"
 	"node-agent/app/storage"
+	"node-agent/app/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Worker lanes
+const (
+	laneRegular = "regular"
+	laneExpress = "express"
 )
 
+// InFlightCommand describes a command a worker is running
+type InFlightCommand struct {
+	CommandID   string    `json:"command_id"`
+	CommandType string    `json:"command_type"`
+	Priority    int       `json:"priority"`
+	Lane        string    `json:"lane"`
+	WorkerID    int       `json:"worker_id"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// LaneState describes the workers and channel of one worker lane
+type LaneState struct {
+	Workers       int `json:"workers"`
+	BusyWorkers   int `json:"busy_workers"`
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+}
+
+// RuntimeState is a snapshot of the runtime loop
+type RuntimeState struct {
+	Lanes         map[string]LaneState `json:"lanes"`
+	InFlight      []InFlightCommand    `json:"in_flight"`
+	LastPollAt    *time.Time           `json:"last_poll_at,omitempty"`
+	LastPollError string               `json:"last_poll_error,omitempty"`
+	PolicyHash    string               `json:"local_policy_hash,omitempty"`
+}
+
 // RuntimeService is the main runtime loop for command execution
 type RuntimeService struct {
 	storage           *storage.Store
@@ -22,6 +68,24 @@ type RuntimeService struct {
 	checkInterval     time.Duration
 	commandChan       chan *storage.LocalCommand
 	workerCount       int
+
+	// Express lane: dedicated workers that only run commands with at least expressMinPriority,
+	// so urgent commands don't wait behind a full queue. Running commands are never preempted.
+	expressChan        chan *storage.LocalCommand
+	expressWorkerCount int
+	expressMinPriority int
+
+	// Local policy every command is checked against before it runs; nil runs every command
+	policy *policy.Policy
+
+	// Guarded by mu; reported by State
+	mu          sync.Mutex
+	inFlight    map[string]InFlightCommand
+	lastPollAt  time.Time
+	lastPollErr error
+
+	// commandKey verifies the signature of every command received; nil accepts commands unsigned. Guarded by mu.
+	commandKey ed25519.PublicKey
 }
 
 // NewRuntimeService creates a new runtime service
@@ -34,8 +98,12 @@ func NewRuntimeService(
 	checkIntervalSec int,
 	workerCount int,
 	channelSize int,
+	expressWorkerCount int,
+	expressMinPriority int,
+	localPolicy *policy.Policy,
+	commandKey ed25519.PublicKey,
 ) *RuntimeService {
-	return &RuntimeService{
+	r := &RuntimeService{
 		storage:           store,
 		chunkSize:         chunkSize,
 		chunkInterval:     chunkInterval,
@@ -45,7 +113,19 @@ func NewRuntimeService(
 		checkInterval:     time.Duration(checkIntervalSec) * time.Second,
 		commandChan:       make(chan *storage.LocalCommand, channelSize),
 		workerCount:       workerCount,
+
+		expressWorkerCount: expressWorkerCount,
+		expressMinPriority: expressMinPriority,
+
+		policy:     localPolicy,
+		commandKey: commandKey,
+
+		inFlight: make(map[string]InFlightCommand),
 	}
+	if expressWorkerCount > 0 {
+		r.expressChan = make(chan *storage.LocalCommand, channelSize)
+	}
+	return r
 }
 
 // Start starts the main runtime loop
@@ -53,6 +133,9 @@ func (r *RuntimeService) Start(ctx context.Context) {
 	for i := 0; i < r.workerCount; i++ {
 		go r.worker(ctx, i)
 	}
+	for i := 0; i < r.expressWorkerCount; i++ {
+		go r.expressWorker(ctx, i)
+	}
 
 	ticker := time.NewTicker(r.checkInterval)
 	defer ticker.Stop()
@@ -63,6 +146,9 @@ func (r *RuntimeService) Start(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			close(r.commandChan)
+			if r.expressChan != nil {
+				close(r.expressChan)
+			}
 			return
 		case <-ticker.C:
 			r.requestCommands(ctx)
@@ -71,20 +157,114 @@ func (r *RuntimeService) Start(ctx context.Context) {
 	}
 }
 
-// worker processes commands from the channel
+// worker processes commands from the channel, taking waiting express commands first
 func (r *RuntimeService) worker(ctx context.Context, workerID int) {
-	for cmd := range r.commandChan {
-		r.agentClient.UpdateCommandStatus(ctx, cmd.CommandID, "running", 0, "")
-		r.executeCommand(ctx, cmd)
+	commands, express := r.commandChan, r.expressChan
+	for commands != nil || express != nil {
+		select {
+		case cmd, ok := <-express:
+			if !ok {
+				express = nil
+				continue
+			}
+			r.runCommand(ctx, cmd, laneRegular, workerID)
+			continue
+		default:
+		}
+
+		select {
+		case cmd, ok := <-express:
+			if !ok {
+				express = nil
+				continue
+			}
+			r.runCommand(ctx, cmd, laneRegular, workerID)
+		case cmd, ok := <-commands:
+			if !ok {
+				commands = nil
+				continue
+			}
+			r.runCommand(ctx, cmd, laneRegular, workerID)
+		}
+	}
+}
+
+// expressWorker processes high priority commands from the express channel only
+func (r *RuntimeService) expressWorker(ctx context.Context, workerID int) {
+	for cmd := range r.expressChan {
+		r.runCommand(ctx, cmd, laneExpress, workerID)
+	}
+}
+
+// runCommand executes a command on the given worker
+// Execution is traced in a command.execute span that continues the trace started by the submission.
+func (r *RuntimeService) runCommand(ctx context.Context, cmd *storage.LocalCommand, lane string, workerID int) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, cmd.TraceContext), "command.execute", trace.WithAttributes(
+		attribute.String("command.id", cmd.CommandID),
+		attribute.String("command.type", cmd.CommandType),
+		attribute.Int("command.priority", cmd.Priority),
+		attribute.String("worker.lane", lane),
+		attribute.Int("worker.id", workerID),
+	))
+	defer span.End()
+
+	r.mu.Lock()
+	r.inFlight[cmd.CommandID] = InFlightCommand{
+		CommandID:   cmd.CommandID,
+		CommandType: cmd.CommandType,
+		Priority:    cmd.Priority,
+		Lane:        lane,
+		WorkerID:    workerID,
+		StartedAt:   time.Now(),
+	}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.inFlight, cmd.CommandID)
+		r.mu.Unlock()
+	}()
+
+	// Refuse commands whose delivery deadline passed while they waited in the local queue
+	if cmd.ExpiresAt != nil && time.Now().After(*cmd.ExpiresAt) {
+		r.expireCommand(ctx, cmd)
+		return
+	}
+
+	r.executeCommand(ctx, cmd)
+}
+
+// dispatch hands a command to a worker without blocking
+// High priority commands go to the express lane when it is enabled and has room.
+// It returns false if the command could not be queued.
+func (r *RuntimeService) dispatch(cmd *storage.LocalCommand) bool {
+	if r.expressChan != nil && cmd.Priority >= r.expressMinPriority {
+		select {
+		case r.expressChan <- cmd:
+			return true
+		default:
+		}
+	}
+
+	select {
+	case r.commandChan <- cmd:
+		return true
+	default:
+		return false
 	}
 }
 
 // requestCommands requests commands from agent-svc
 func (r *RuntimeService) requestCommands(ctx context.Context) {
-	cmdResps, err := r.agentClient.PollCommands(ctx, r.nodeID, 5)
+	pollStart := time.Now()
+	cmdResps, err := r.agentClient.PollCommands(ctx, 5)
+	r.mu.Lock()
+	r.lastPollAt, r.lastPollErr = time.Now(), err
+	r.mu.Unlock()
 	if err != nil {
+		metrics.PollsTotal.WithLabelValues("failure").Inc()
 		return
 	}
+	metrics.PollsTotal.WithLabelValues("success").Inc()
 
 	if len(cmdResps) == 0 {
 		return
@@ -92,12 +272,14 @@ func (r *RuntimeService) requestCommands(ctx context.Context) {
 
 	// Process all returned commands
 	for _, cmdResp := range cmdResps {
-		if cmdResp == nil || cmdResp["command_id"] == nil {
+		commandID := cmdResp.CommandID
+		if commandID == "" || cmdResp.CommandType == "" || cmdResp.Payload == nil {
 			continue
 		}
 
-		commandID, ok := cmdResp["command_id"].(string)
-		if !ok || commandID == "" {
+		// Nothing from a command that doesn't verify is saved or run
+		if err := r.verifyCommand(cmdResp, time.Now()); err != nil {
+			r.refuseCommand(commandID, err)
 			continue
 		}
 
@@ -111,43 +293,111 @@ func (r *RuntimeService) requestCommands(ctx context.Context) {
 			continue
 		}
 
-		commandType, ok := cmdResp["command_type"].(string)
-		if !ok {
+		commandType := cmdResp.CommandType
+		jsonBytes, err := json.Marshal(cmdResp.Payload)
+		if err != nil {
 			continue
 		}
+		payloadJSON := string(jsonBytes)
 
-		payloadJSON, ok := cmdResp["payload_json"].(string)
-		if !ok {
-			if payload, ok := cmdResp["payload"].(map[string]interface{}); ok {
-				jsonBytes, _ := json.Marshal(payload)
-				payloadJSON = string(jsonBytes)
-			} else {
-				continue
+		var expiresAt *time.Time
+		if cmdResp.ExpiresAt != nil {
+			if t, err := time.Parse(time.RFC3339, *cmdResp.ExpiresAt); err == nil {
+				expiresAt = &t
 			}
 		}
 
-		if err := r.storage.SaveCommandWithStatus(ctx, commandID, commandType, payloadJSON, "running"); err != nil {
+		priority := cmdResp.Priority
+		traceContext := cmdResp.TraceContext
+		if len(traceContext) == 0 {
+			traceContext = nil
+		}
+		_, span := tracing.Tracer().Start(tracing.Extract(ctx, traceContext), "command.receive",
+			trace.WithTimestamp(pollStart), trace.WithAttributes(attribute.String("command.id", commandID)))
+
+		if err := r.storage.SaveCommandWithStatus(ctx, commandID, commandType, payloadJSON, "running", expiresAt, priority, traceContext); err != nil {
 			fmt.Printf("failed to save command: %v\n", err)
+			tracing.RecordError(span, err)
+			span.End()
 			continue
 		}
+		span.End()
+		metrics.CommandsReceivedTotal.Inc()
 
 		cmd := &storage.LocalCommand{
-			CommandID:   commandID,
-			CommandType: commandType,
-			Payload:     payloadJSON,
-			Status:      "running",
+			CommandID:    commandID,
+			CommandType:  commandType,
+			Payload:      payloadJSON,
+			Status:       "running",
+			ExpiresAt:    expiresAt,
+			Priority:     priority,
+			TraceContext: traceContext,
 		}
 
-		select {
-		case r.commandChan <- cmd:
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		default:
+		}
+		if !r.dispatch(cmd) {
 			r.storage.UpdateCommandStatus(ctx, commandID, "queued", nil, nil)
 		}
 	}
 }
 
+// SetCommandKey sets the key the signature of every command received from now on is verified with
+func (r *RuntimeService) SetCommandKey(key ed25519.PublicKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commandKey = key
+}
+
+// verifyCommand checks that a command was signed for this node with the command signing key and that the
+// signature hadn't expired at now; without a key every command is accepted
+func (r *RuntimeService) verifyCommand(cmdResp dto.CommandResponse, now time.Time) error {
+	r.mu.Lock()
+	commandKey := r.commandKey
+	r.mu.Unlock()
+	if commandKey == nil {
+		return nil
+	}
+
+	signed := client.SignedCommand{
+		CommandID:   cmdResp.CommandID,
+		NodeID:      r.nodeID,
+		CommandType: cmdResp.CommandType,
+		Payload:     cmdResp.Payload,
+	}
+	if cmdResp.ExpiresAt != nil {
+		expiresAt, err := time.Parse(time.RFC3339, *cmdResp.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("%w: invalid expires_at", client.ErrInvalidCommandSignature)
+		}
+		signed.ExpiresAt = &expiresAt
+	}
+	if cmdResp.SignatureExpiresAt != nil {
+		signatureExpiresAt, err := time.Parse(time.RFC3339, *cmdResp.SignatureExpiresAt)
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature_expires_at", client.ErrInvalidCommandSignature)
+		}
+		signed.SignatureExpiresAt = signatureExpiresAt
+	}
+	return signed.Verify(commandKey, cmdResp.Signature, now)
+}
+
+// refuseCommand drops a command whose signature didn't verify, without saving or running it
+// No status is reported for it: the command ID of a forged command may be that of a real one, which a rejected
+// status would finalize before the real command arrives.
+func (r *RuntimeService) refuseCommand(commandID string, err error) {
+	reason := "invalid"
+	switch {
+	case errors.Is(err, client.ErrUnsignedCommand):
+		reason = "unsigned"
+	case errors.Is(err, client.ErrCommandSignatureExpired):
+		reason = "expired"
+	}
+	metrics.CommandSignatureFailuresTotal.WithLabelValues(reason).Inc()
+	fmt.Printf("refusing command %s: %v\n", commandID, err)
+}
+
 // enqueueQueuedCommands enqueues queued commands from local storage
 func (r *RuntimeService) enqueueQueuedCommands(ctx context.Context) {
 	for {
@@ -160,18 +410,17 @@ func (r *RuntimeService) enqueueQueuedCommands(ctx context.Context) {
 			return
 		}
 
-		select {
-		case r.commandChan <- cmd:
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		default:
+		}
+		if !r.dispatch(cmd) {
 			r.storage.UpdateCommandStatus(ctx, cmd.CommandID, "queued", nil, nil)
 			return
 		}
 	}
 }
 
-// executeCommand executes a command
+// executeCommand reports a command as running and executes it, unless the local policy rejects it
 func (r *RuntimeService) executeCommand(ctx context.Context, cmd *storage.LocalCommand) {
 	var payload map[string]interface{}
 	if err := json.Unmarshal([]byte(cmd.Payload), &payload); err != nil {
@@ -179,6 +428,13 @@ func (r *RuntimeService) executeCommand(ctx context.Context, cmd *storage.LocalC
 		return
 	}
 
+	if err := r.policy.Check(cmd.CommandType, payload); err != nil {
+		r.rejectCommand(ctx, cmd.CommandID, err.Error())
+		return
+	}
+
+	r.agentClient.UpdateCommandStatus(ctx, cmd.CommandID, "running", 0, "")
+
 	// Handle different command types
 	switch cmd.CommandType {
 	case "RunCommand":
@@ -198,15 +454,29 @@ func (r *RuntimeService) executeRunCommand(ctx context.Context, commandID string
 		return
 	}
 
-	timeoutSec := 300
+	timeoutSec := r.policy.TimeoutSec(300)
 	if ts, ok := payload["timeout_sec"].(float64); ok && ts > 0 {
 		timeoutSec = int(ts)
 	}
 
+	// Without a shell for a command the local policy allows by its executable only
+	argv, err := r.policy.Argv(cmdStr)
+	if err != nil {
+		r.rejectCommand(ctx, commandID, err.Error())
+		return
+	}
+
+	// max_output_bytes is filled in by agent-svc; zero keeps the full output
+	var maxOutputBytes int64
+	if mb, ok := payload["max_output_bytes"].(float64); ok && mb > 0 {
+		maxOutputBytes = int64(mb)
+	}
+
 	execCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
 	defer cancel()
 
-	command := exec.CommandContext(execCtx, "sh", "-c", cmdStr)
+	command := exec.CommandContext(execCtx, argv[0], argv[1:]...)
+	command.Dir, _ = payload["working_dir"].(string)
 	stdout, err := command.StdoutPipe()
 	if err != nil {
 		r.handleCommandError(ctx, commandID, fmt.Sprintf("failed to create stdout pipe: %v", err))
@@ -224,28 +494,34 @@ func (r *RuntimeService) executeRunCommand(ctx context.Context, commandID string
 		return
 	}
 
-	chunker := executor.NewChunker(r.chunkSize, r.chunkInterval)
+	chunker := executor.NewChunker(r.chunkSize, r.chunkInterval, maxOutputBytes)
 	chunkChan := chunker.StartChunking(execCtx, stdout, stderr)
 
+	// The tail and the truncation marker only arrive with FinalFlush, after execCtx may have expired,
+	// so the chunks are saved and pushed with a context that outlives the command
+	logCtx := context.WithoutCancel(ctx)
+	logsDone := make(chan struct{})
 	go func() {
+		defer close(logsDone)
 		for chunk := range chunkChan {
-			r.storage.SaveLogChunk(execCtx, commandID, chunk.ChunkIndex, chunk.Stream, chunk.Data)
-
-			chunkMap := map[string]interface{}{
-				"chunk_index": chunk.ChunkIndex,
-				"stream":      chunk.Stream,
-				"data":        chunk.Data,
-				"is_final":    chunk.IsFinal,
-			}
-			ackedChunkIndexes, err := r.agentClient.PushCommandLogs(execCtx, commandID, []map[string]interface{}{chunkMap})
+			r.storage.SaveLogChunk(logCtx, commandID, chunk.ChunkIndex, chunk.Stream, chunk.Data)
+
+			ackedChunkIndexes, err := r.agentClient.PushCommandLogs(logCtx, commandID, []dto.LogChunkRequest{{
+				ChunkIndex: chunk.ChunkIndex,
+				Stream:     chunk.Stream,
+				Data:       chunk.Data,
+				IsFinal:    chunk.IsFinal,
+			}})
 			if err == nil && len(ackedChunkIndexes) > 0 {
-				r.storage.MarkChunksAcked(execCtx, commandID, ackedChunkIndexes)
+				r.storage.MarkChunksAcked(logCtx, commandID, ackedChunkIndexes)
 			}
 		}
 	}()
 
 	err = command.Wait()
 	chunker.FinalFlush()
+	// Report the result only once every chunk, the tail included, has been saved and pushed
+	<-logsDone
 
 	exitCode := 0
 	status := "success"
@@ -267,16 +543,82 @@ func (r *RuntimeService) executeRunCommand(ctx context.Context, commandID string
 		}
 	}
 
-	r.chunkStorageRetry.UploadChunksForCommand(context.Background(), commandID, true)
+	// Upload the final chunks even if the agent is shutting down, keeping the trace of the command
+	r.chunkStorageRetry.UploadChunksForCommand(logCtx, commandID, true)
 	r.storage.UpdateCommandStatus(ctx, commandID, status, &exitCode, &errorMsg)
+	metrics.CommandsFinishedTotal.WithLabelValues(status).Inc()
+	recordCommandResult(ctx, status, errorMsg)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("process.exit_code", exitCode))
 
 	exitCodeInt32 := int32(exitCode)
-	r.agentClient.UpdateCommandStatus(ctx, commandID, status, exitCodeInt32, errorMsg)
+	r.agentClient.UpdateCommandResult(ctx, commandID, status, exitCodeInt32, errorMsg, chunker.TotalBytes(), chunker.Truncated())
+}
+
+// expireCommand marks a command whose delivery deadline has passed as expired without running it
+func (r *RuntimeService) expireCommand(ctx context.Context, cmd *storage.LocalCommand) {
+	errorMsg := fmt.Sprintf("delivery deadline %s passed before execution", cmd.ExpiresAt.Format(time.RFC3339))
+	r.storage.UpdateCommandStatus(ctx, cmd.CommandID, "expired", nil, &errorMsg)
+	metrics.CommandsFinishedTotal.WithLabelValues("expired").Inc()
+	recordCommandResult(ctx, "expired", errorMsg)
+	r.agentClient.UpdateCommandStatus(ctx, cmd.CommandID, "expired", 0, errorMsg)
+}
+
+// rejectCommand marks a command the local policy doesn't allow as rejected without running it
+func (r *RuntimeService) rejectCommand(ctx context.Context, commandID, errorMsg string) {
+	r.storage.UpdateCommandStatus(ctx, commandID, "rejected", nil, &errorMsg)
+	metrics.CommandsFinishedTotal.WithLabelValues("rejected").Inc()
+	recordCommandResult(ctx, "rejected", errorMsg)
+	r.agentClient.UpdateCommandStatus(ctx, commandID, "rejected", 0, errorMsg)
 }
 
 // handleCommandError handles command execution errors
 func (r *RuntimeService) handleCommandError(ctx context.Context, commandID, errorMsg string) {
 	exitCode := -1
 	r.storage.UpdateCommandStatus(ctx, commandID, "failed", &exitCode, &errorMsg)
+	metrics.CommandsFinishedTotal.WithLabelValues("failed").Inc()
+	recordCommandResult(ctx, "failed", errorMsg)
 	r.agentClient.UpdateCommandStatus(ctx, commandID, "failed", int32(exitCode), errorMsg)
 }
+
+// recordCommandResult sets the final status of a command on its command.execute span
+func recordCommandResult(ctx context.Context, status, errorMsg string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("command.status", status))
+	if status != "success" {
+		span.SetStatus(codes.Error, errorMsg)
+	}
+}
+
+// State returns a snapshot of the worker lanes, the commands being run and the last poll
+func (r *RuntimeService) State() RuntimeState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := RuntimeState{
+		Lanes: map[string]LaneState{
+			laneRegular: {Workers: r.workerCount, QueueDepth: len(r.commandChan), QueueCapacity: cap(r.commandChan)},
+		},
+		InFlight:   make([]InFlightCommand, 0, len(r.inFlight)),
+		PolicyHash: r.policy.Hash(),
+	}
+	if r.expressChan != nil {
+		state.Lanes[laneExpress] = LaneState{Workers: r.expressWorkerCount, QueueDepth: len(r.expressChan), QueueCapacity: cap(r.expressChan)}
+	}
+
+	for _, cmd := range r.inFlight {
+		lane := state.Lanes[cmd.Lane]
+		lane.BusyWorkers++
+		state.Lanes[cmd.Lane] = lane
+		state.InFlight = append(state.InFlight, cmd)
+	}
+	sort.Slice(state.InFlight, func(i, j int) bool { return state.InFlight[i].StartedAt.Before(state.InFlight[j].StartedAt) })
+
+	if !r.lastPollAt.IsZero() {
+		lastPollAt := r.lastPollAt
+		state.LastPollAt = &lastPollAt
+	}
+	if r.lastPollErr != nil {
+		state.LastPollError = r.lastPollErr.Error()
+	}
+	return state
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"agent-svc/client"
+	"agent-svc/client/dto"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	public, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	now := time.Now().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour).UTC().Format(time.RFC3339)
+
+	// command returns a command for nodeID as agent-svc sends it, signed with key unless it is nil
+	command := func(nodeID string, key ed25519.PrivateKey) dto.CommandResponse {
+		cmdResp := dto.CommandResponse{
+			CommandID:   "3f0f6f7e-6a51-4a8e-9a5e-1b2c3d4e5f60",
+			CommandType: "RunCommand",
+			Payload:     map[string]interface{}{"cmd": "uptime", "timeout_sec": float64(30)},
+			ExpiresAt:   &expiresAt,
+		}
+		if key == nil {
+			return cmdResp
+		}
+		deadline := now.Add(time.Hour)
+		signed := client.SignedCommand{
+			CommandID:          cmdResp.CommandID,
+			NodeID:             nodeID,
+			CommandType:        cmdResp.CommandType,
+			Payload:            cmdResp.Payload,
+			ExpiresAt:          &deadline,
+			SignatureExpiresAt: now.Add(5 * time.Minute),
+		}
+		signature, err := signed.Sign(key)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		signatureExpiresAt := signed.SignatureExpiresAt.UTC().Format(time.RFC3339)
+		cmdResp.Signature, cmdResp.SignatureExpiresAt = signature, &signatureExpiresAt
+		return cmdResp
+	}
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		cmdResp dto.CommandResponse
+		modify  func(cmdResp *dto.CommandResponse)
+		now     time.Time
+		wantErr error
+	}{
+		{name: "signed", key: public, cmdResp: command("node-1", key), now: now},
+		{name: "no key, unsigned", cmdResp: command("node-1", nil), now: now},
+		{name: "no key, signed", cmdResp: command("node-1", otherKey), now: now},
+
+		{name: "tampered payload", key: public, cmdResp: command("node-1", key), now: now, wantErr: client.ErrInvalidCommandSignature,
+			modify: func(cmdResp *dto.CommandResponse) { cmdResp.Payload["cmd"] = "rm -rf /" }},
+		{name: "extended signature expiry", key: public, cmdResp: command("node-1", key), now: now, wantErr: client.ErrInvalidCommandSignature,
+			modify: func(cmdResp *dto.CommandResponse) {
+				later := now.Add(time.Hour).UTC().Format(time.RFC3339)
+				cmdResp.SignatureExpiresAt = &later
+			}},
+		{name: "invalid signature expiry", key: public, cmdResp: command("node-1", key), now: now, wantErr: client.ErrInvalidCommandSignature,
+			modify: func(cmdResp *dto.CommandResponse) {
+				invalid := "tomorrow"
+				cmdResp.SignatureExpiresAt = &invalid
+			}},
+		{name: "wrong key", key: public, cmdResp: command("node-1", otherKey), now: now, wantErr: client.ErrInvalidCommandSignature},
+		{name: "signed for another node", key: public, cmdResp: command("node-2", key), now: now, wantErr: client.ErrInvalidCommandSignature},
+		{name: "replayed after expiry", key: public, cmdResp: command("node-1", key), now: now.Add(10 * time.Minute), wantErr: client.ErrCommandSignatureExpired},
+		{name: "missing signature", key: public, cmdResp: command("node-1", nil), now: now, wantErr: client.ErrUnsignedCommand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RuntimeService{nodeID: "node-1"}
+			r.SetCommandKey(tt.key)
+			if tt.modify != nil {
+				tt.modify(&tt.cmdResp)
+			}
+			err := r.verifyCommand(tt.cmdResp, tt.now)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("verifyCommand() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyCommand() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
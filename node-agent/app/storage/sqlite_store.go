@@ -3,9 +3,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -84,9 +86,50 @@ func (s *Store) runMigrations() error {
 		}
 	}
 
+	// Columns added after the initial schema
+	columns := []struct{ table, column, definition string }{
+		{"node_commands_local", "expires_at", "TEXT"},                     // RFC3339 deliver-by deadline
+		{"node_commands_local", "priority", "INTEGER NOT NULL DEFAULT 5"}, // 0 (lowest) .. 9 (highest)
+		{"node_commands_local", "trace_context", "TEXT"},                  // JSON W3C trace context from agent-svc
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table unless it is already present
+func (s *Store) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // LocalCommand represents a command in local storage
 type LocalCommand struct {
 	ID          int64
@@ -99,6 +142,10 @@ type LocalCommand struct {
 	UpdatedAt   string
 	ExitCode    *int
 	ErrorMsg    *string
+	ExpiresAt   *time.Time // command must not be started after this time
+	Priority    int        // higher priority commands are started first
+
+	TraceContext map[string]string // W3C trace context of the submission, nil if untraced
 }
 
 // SaveCommand saves a command locally
@@ -113,33 +160,48 @@ func (s *Store) SaveCommand(ctx context.Context, commandID, commandType, payload
 }
 
 // SaveCommandWithStatus saves a command locally with a specific status
-func (s *Store) SaveCommandWithStatus(ctx context.Context, commandID, commandType, payload, status string) error {
+func (s *Store) SaveCommandWithStatus(ctx context.Context, commandID, commandType, payload, status string, expiresAt *time.Time, priority int, traceContext map[string]string) error {
+	var traceContextJSON interface{}
+	if len(traceContext) > 0 {
+		data, err := json.Marshal(traceContext)
+		if err != nil {
+			return fmt.Errorf("failed to marshal trace context: %w", err)
+		}
+		traceContextJSON = string(data)
+	}
+
 	query := `
-		INSERT INTO node_commands_local (command_id, command_type, payload, status)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO node_commands_local (command_id, command_type, payload, status, expires_at, priority, trace_context)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(command_id) DO UPDATE SET 
 			status = excluded.status,
 			payload = excluded.payload,
+			expires_at = excluded.expires_at,
+			priority = excluded.priority,
+			trace_context = excluded.trace_context,
 			updated_at = CURRENT_TIMESTAMP
 	`
-	_, err := s.db.ExecContext(ctx, query, commandID, commandType, payload, status)
+	_, err := s.db.ExecContext(ctx, query, commandID, commandType, payload, status, formatTime(expiresAt), priority, traceContextJSON)
 	return err
 }
 
-// GetNextQueuedCommand retrieves the next queued command and marks it as "running"
+// GetNextQueuedCommand retrieves the highest priority, oldest queued command and marks it as "running"
 func (s *Store) GetNextQueuedCommand(ctx context.Context) (*LocalCommand, error) {
 	query := `
-		SELECT id, command_id, command_type, payload, status, retries, created_at, updated_at, exit_code, error_msg
+		SELECT id, command_id, command_type, payload, status, retries, created_at, updated_at, exit_code, error_msg, expires_at, priority,
+			trace_context
 		FROM node_commands_local
 		WHERE status = 'queued'
-		ORDER BY created_at ASC
+		ORDER BY priority DESC, created_at ASC, id ASC
 		LIMIT 1
 	`
 
 	var cmd LocalCommand
+	var expiresAt, traceContext sql.NullString
 	err := s.db.QueryRowContext(ctx, query).Scan(
 		&cmd.ID, &cmd.CommandID, &cmd.CommandType, &cmd.Payload, &cmd.Status,
-		&cmd.Retries, &cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg,
+		&cmd.Retries, &cmd.CreatedAt, &cmd.UpdatedAt, &cmd.ExitCode, &cmd.ErrorMsg, &expiresAt, &cmd.Priority,
+		&traceContext,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -147,6 +209,11 @@ func (s *Store) GetNextQueuedCommand(ctx context.Context) (*LocalCommand, error)
 	if err != nil {
 		return nil, err
 	}
+	cmd.ExpiresAt = parseTime(expiresAt)
+	if traceContext.Valid {
+		// A malformed trace context only loses the trace, the command still runs
+		json.Unmarshal([]byte(traceContext.String), &cmd.TraceContext)
+	}
 
 	// Update status to "running"
 	updateQuery := `
@@ -199,8 +266,11 @@ func (s *Store) IsCommandFinished(ctx context.Context, commandID string) (bool,
 	}
 
 	// Check if status is finished
-	isFinished := status == "success" || status == "failed" || status == "timeout"
-	return isFinished, nil
+	switch status {
+	case "success", "failed", "timeout", "cancelled", "lost", "expired", "rejected":
+		return true, nil
+	}
+	return false, nil
 }
 
 // LogChunk represents a log chunk in local storage
@@ -353,7 +423,7 @@ func (s *Store) CleanupAckedChunks(ctx context.Context, olderThanMinutes int) er
 func (s *Store) CleanupCompletedCommands(ctx context.Context, olderThanHours int) error {
 	query := `
 		DELETE FROM node_commands_local
-		WHERE status IN ('success', 'failed') AND datetime(created_at, '+' || ? || ' hours') < datetime('now')
+		WHERE status IN ('success', 'failed', 'expired') AND datetime(created_at, '+' || ? || ' hours') < datetime('now')
 	`
 	_, err := s.db.ExecContext(ctx, query, olderThanHours)
 	return err
@@ -382,3 +452,51 @@ func (s *Store) DeleteQueuedCommands(ctx context.Context) (int, error) {
 	rowsAffected, _ := result.RowsAffected()
 	return int(rowsAffected), nil
 }
+
+// CountRowsByStatus counts the rows of node_commands_local and command_logs_local per status,
+// keyed by table name and then status
+func (s *Store) CountRowsByStatus(ctx context.Context) (map[string]map[string]int64, error) {
+	counts := make(map[string]map[string]int64)
+	for _, table := range []string{"node_commands_local", "command_logs_local"} {
+		rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT status, COUNT(*) FROM %s GROUP BY status", table))
+		if err != nil {
+			return nil, err
+		}
+
+		counts[table] = make(map[string]int64)
+		for rows.Next() {
+			var status string
+			var count int64
+			if err := rows.Scan(&status, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[table][status] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// formatTime formats an optional timestamp for storage as RFC3339 text
+func formatTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime parses an optional RFC3339 timestamp stored by formatTime
+func parseTime(value sql.NullString) *time.Time {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName is reported as service.name on every span
+const serviceName = "node-agent"
+
+// Span exporters
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config selects where spans are exported
+type Config struct {
+	Exporter     string // ExporterNone, ExporterStdout or ExporterOTLP
+	OTLPEndpoint string // OTLP/HTTP collector URL, such as http://otel-collector:4318
+	NodeID       string // reported as node.id on every span
+}
+
+// propagator carries the W3C trace context received from and sent to agent-svc
+var propagator = propagation.TraceContext{}
+
+// ValidateExporter checks that exporter names a supported span exporter
+func ValidateExporter(exporter string) error {
+	switch exporter {
+	case ExporterNone, ExporterStdout, ExporterOTLP, "":
+		return nil
+	}
+	return fmt.Errorf("unknown trace exporter %q", exporter)
+}
+
+// Setup installs the global tracer provider and returns a function that flushes and stops it
+// With ExporterNone spans are not recorded, but the trace context of commands is still passed on to agent-svc.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	if err := ValidateExporter(cfg.Exporter); err != nil {
+		return nil, err
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("node.id", cfg.NodeID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used for node-agent spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(serviceName)
+}
+
+// Extract returns ctx with the remote span context stored in carrier as its parent
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// InjectHeaders adds the trace context of the span in ctx to outgoing request headers
+func InjectHeaders(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// RecordError marks a span as failed
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,230 @@
+// Package app implements rcectl, the operator CLI for agent-svc
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"agent-svc/client"
+)
+
+// Exit codes of rcectl; single-node runs exit with the command's own exit code instead
+const (
+	exitOK          = 0
+	exitFailure     = 1   // the API call or a command failed
+	exitUsage       = 2   // bad arguments
+	exitTimeout     = 124 // a single-node run timed out on the node, as with timeout(1)
+	exitInterrupted = 130 // interrupted by Ctrl-C
+)
+
+// exitError ends rcectl with a specific exit code, after its message (if any) is printed
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// usageErrorf returns an error ending rcectl with the usage exit code
+func usageErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitUsage, msg: fmt.Sprintf(format, args...)}
+}
+
+// options holds the flags every subcommand accepts
+type options struct {
+	configPath string
+	server     string
+	output     string
+}
+
+// env is what a subcommand runs with
+type env struct {
+	client *client.Client
+	print  *printer
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is an rcectl subcommand
+type command struct {
+	usage   string
+	summary string
+	// flags registers the subcommand's own flags; run is called with the arguments left after them
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+// commands returns the subcommands by name; node subcommands are named by two words
+func commands() map[string]*command {
+	return map[string]*command{
+		"nodes list":    nodesListCommand(),
+		"nodes show":    nodesShowCommand(),
+		"nodes label":   nodesLabelCommand(),
+		"nodes disable": nodesDisableCommand(true),
+		"nodes enable":  nodesDisableCommand(false),
+		"run":           runCommand(),
+		"logs":          logsCommand(),
+		"cancel":        cancelCommand(),
+		"jobs":          jobsCommand(),
+	}
+}
+
+// Run runs rcectl with the given arguments and returns its exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	cmds := commands()
+	opts := &options{output: FormatText}
+
+	global := newFlagSet("rcectl", opts, stderr)
+	global.Usage = func() { printUsage(stderr, cmds) }
+	if err := global.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if global.NArg() == 0 {
+		printUsage(stderr, cmds)
+		return exitUsage
+	}
+
+	rest := global.Args()
+	name := rest[0]
+	if len(rest) > 1 && cmds[name+" "+rest[1]] != nil {
+		name, rest = name+" "+rest[1], rest[2:]
+	} else {
+		rest = rest[1:]
+	}
+	cmd, ok := cmds[name]
+	if !ok {
+		fmt.Fprintf(stderr, "rcectl: unknown command %q\n\n", name)
+		printUsage(stderr, cmds)
+		return exitUsage
+	}
+
+	fs := newFlagSet("rcectl "+name, opts, stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: rcectl %s\n\n%s\n\nFlags:\n", cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	cmdArgs, err := parseArgs(fs, rest)
+	if err != nil {
+		return flagExitCode(err)
+	}
+	if !validFormat(opts.output) {
+		fmt.Fprintf(stderr, "rcectl: unknown output format %q, use text, json or yaml\n", opts.output)
+		return exitUsage
+	}
+
+	cfg, err := LoadConfig(opts.configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "rcectl: %v\n", err)
+		return exitFailure
+	}
+	if opts.server != "" {
+		cfg.Server = opts.server
+	}
+	c, err := cfg.Client()
+	if err != nil {
+		fmt.Fprintf(stderr, "rcectl: %v\n", err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	e := &env{
+		client: c,
+		print:  &printer{format: opts.output, out: stdout},
+		stdout: stdout,
+		stderr: stderr,
+	}
+	err = cmd.run(ctx, e, cmdArgs)
+
+	var exit *exitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &exit):
+		if exit.msg != "" {
+			fmt.Fprintf(stderr, "rcectl %s: %s\n", name, exit.msg)
+		}
+		if exit.code == exitUsage {
+			fs.Usage()
+		}
+		return exit.code
+	case ctx.Err() != nil:
+		fmt.Fprintln(stderr, "rcectl: interrupted")
+		return exitInterrupted
+	default:
+		fmt.Fprintf(stderr, "rcectl %s: %v\n", name, err)
+		return exitFailure
+	}
+}
+
+// newFlagSet creates a flag set with the flags common to every subcommand
+func newFlagSet(name string, opts *options, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.configPath, "config", opts.configPath, "config file (default $RCECTL_CONFIG or "+defaultConfigPath()+")")
+	fs.StringVar(&opts.server, "server", opts.server, "agent-svc URL, overriding the config file")
+	fs.StringVar(&opts.output, "o", opts.output, "output format: text, json or yaml")
+	return fs
+}
+
+// parseArgs parses flags wherever they appear among the arguments, as in rcectl logs <id> -f, and returns
+// the other arguments; everything from a "--" on is returned as is, including the "--"
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional, verbatim []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, verbatim = args[:i], args[i:]
+			break
+		}
+	}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(positional, verbatim...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// flagExitCode returns the exit code for a flag parsing error; -h is not an error
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+// printUsage prints the list of subcommands
+func printUsage(w io.Writer, cmds map[string]*command) {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: rcectl [--config file] [--server url] [-o text|json|yaml] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-45s %s\n", cmds[name].usage, strings.SplitN(cmds[name].summary, "\n", 2)[0])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run rcectl <command> -h for the flags of a command.")
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"agent-svc/client"
+	"agent-svc/client/dto"
+
+	"gopkg.in/yaml.v3"
+)
+
+func logsCommand() *command {
+	var follow bool
+	return &command{
+		usage:   "logs <command-id> [-f]",
+		summary: "Print the output of a command.\nWith -f, keep printing it as it arrives until the command finished; json and yaml then print one chunk per line or document.",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&follow, "f", false, "follow the output until the command finished")
+		},
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected one command ID")
+			}
+			commandID := args[0]
+
+			if !follow {
+				chunks, err := e.client.GetCommandLogs(ctx, commandID, nil)
+				if err != nil {
+					return err
+				}
+				if chunks == nil {
+					chunks = []dto.LogChunkResponse{}
+				}
+				return e.print.print(chunks, func(io.Writer) {
+					for _, chunk := range chunks {
+						writeChunk(e, chunk)
+					}
+				})
+			}
+
+			return e.client.StreamCommandLogs(ctx, commandID, client.StreamOptions{}, func(chunk dto.LogChunkResponse) error {
+				switch e.print.format {
+				case FormatJSON:
+					data, err := json.Marshal(chunk)
+					if err != nil {
+						return err
+					}
+					_, err = fmt.Fprintf(e.stdout, "%s\n", data)
+					return err
+				case FormatYAML:
+					data, err := yaml.Marshal(map[string]interface{}{
+						"chunk_index": chunk.ChunkIndex,
+						"stream":      chunk.Stream,
+						"data":        chunk.Data,
+					})
+					if err != nil {
+						return err
+					}
+					_, err = fmt.Fprintf(e.stdout, "---\n%s", data)
+					return err
+				default:
+					writeChunk(e, chunk)
+					return nil
+				}
+			})
+		},
+	}
+}
+
+// writeChunk writes a log chunk to stdout or stderr, whichever the command wrote it to
+func writeChunk(e *env, chunk dto.LogChunkResponse) {
+	if chunk.Stream == "stderr" {
+		io.WriteString(e.stderr, chunk.Data)
+		return
+	}
+	io.WriteString(e.stdout, chunk.Data)
+}
+
+func cancelCommand() *command {
+	return &command{
+		usage:   "cancel <command-id>...",
+		summary: "Cancel commands that haven't been dispatched to their node yet.",
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected at least one command ID")
+			}
+			cancelled := make([]*dto.CommandDetailResponse, 0, len(args))
+			failed := 0
+			for _, commandID := range args {
+				cmd, err := e.client.CancelCommand(ctx, commandID)
+				if err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					if client.IsConflict(err) {
+						err = fmt.Errorf("already dispatched or finished")
+					}
+					fmt.Fprintf(e.stderr, "%s: %v\n", commandID, err)
+					failed++
+					continue
+				}
+				cancelled = append(cancelled, cmd)
+			}
+
+			err := e.print.print(cancelled, func(w io.Writer) {
+				for _, cmd := range cancelled {
+					fmt.Fprintf(w, "%s cancelled\n", cmd.CommandID)
+				}
+			})
+			if err == nil && failed > 0 {
+				err = &exitError{code: exitFailure}
+			}
+			return err
+		},
+	}
+}
+
+func jobsCommand() *command {
+	var nodeID string
+	var limit int
+	return &command{
+		usage:   "jobs [--node node-id] [--limit n]",
+		summary: "List the most recent commands of the tenant, newest first.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&nodeID, "node", "", "only commands of this node")
+			fs.IntVar(&limit, "limit", 20, "list at most this many commands")
+		},
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) > 0 {
+				return usageErrorf("unexpected arguments %s", strings.Join(args, " "))
+			}
+			if limit < 1 {
+				return usageErrorf("limit must be at least 1")
+			}
+			cmds, err := e.client.ListCommands(ctx, client.ListCommandsOptions{NodeID: nodeID, Limit: limit})
+			if err != nil {
+				return err
+			}
+			if cmds == nil {
+				cmds = []dto.CommandDetailResponse{}
+			}
+
+			return e.print.print(cmds, func(w io.Writer) {
+				rows := make([][]string, 0, len(cmds))
+				for _, cmd := range cmds {
+					exitCode := "-"
+					if cmd.ExitCode != nil {
+						exitCode = strconv.Itoa(*cmd.ExitCode)
+					}
+					rows = append(rows, []string{cmd.CommandID, cmd.NodeID, cmd.Status, exitCode, formatAge(cmd.CreatedAt), summarizeCommand(&cmd)})
+				}
+				table(w, []string{"COMMAND ID", "NODE", "STATUS", "EXIT", "AGE", "COMMAND"}, rows)
+			})
+		},
+	}
+}
+
+// summarizeCommand returns the command line of a RunCommand, shortened to fit a table, or the command type
+func summarizeCommand(cmd *dto.CommandDetailResponse) string {
+	line, ok := cmd.Payload["cmd"].(string)
+	if cmd.CommandType != "RunCommand" || !ok {
+		return cmd.CommandType
+	}
+	line = strings.Join(strings.Fields(line), " ")
+	if runes := []rune(line); len(runes) > 50 {
+		line = string(runes[:49]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"agent-svc/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the API endpoint and the credentials rcectl calls it with
+type Config struct {
+	Server   string `yaml:"server"`    // agent-svc, usually behind Kong, e.g. https://rce.example.com
+	TenantID string `yaml:"tenant_id"` // tenant to act in; required for operators of several tenants
+	Token    string `yaml:"token"`     // operator token, which identifies the operator to agent-svc
+}
+
+// defaultConfigPath returns ~/.config/rcectl/config.yaml, or the platform's equivalent
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "rcectl.yaml"
+	}
+	return filepath.Join(dir, "rcectl", "config.yaml")
+}
+
+// LoadConfig reads the config file at path, falling back to RCECTL_CONFIG and then the default path
+// A missing file at the default path is not an error, so everything can come from environment variables:
+// RCECTL_SERVER, RCECTL_TENANT_ID and RCECTL_TOKEN override the file.
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("RCECTL_CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if cfg.Token != "" {
+			if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+				fmt.Fprintf(os.Stderr, "warning: %s holds a token but is readable by other users; chmod 600 it\n", path)
+			}
+		}
+	case explicit || !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	overrides := map[string]*string{
+		"RCECTL_SERVER":    &cfg.Server,
+		"RCECTL_TENANT_ID": &cfg.TenantID,
+		"RCECTL_TOKEN":     &cfg.Token,
+	}
+	for env, field := range overrides {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+	return cfg, nil
+}
+
+// Client creates the agent-svc client for the configured server and credentials
+func (c *Config) Client() (*client.Client, error) {
+	if c.Server == "" {
+		return nil, fmt.Errorf("no server configured: set server in %s or RCECTL_SERVER", defaultConfigPath())
+	}
+	return client.New(c.Server,
+		client.WithAuth(client.OperatorAuth{Token: c.Token, TenantID: c.TenantID}),
+		client.WithRetry(client.DefaultRetryPolicy),
+		client.WithUserAgent("rcectl (agent-svc-client/"+client.Version+")"),
+	), nil
+}
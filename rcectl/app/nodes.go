@@ -0,0 +1,271 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-svc/client/dto"
+)
+
+// selector picks nodes: a single node ID, or key=value pairs matched against node labels and attrs
+type selector struct {
+	nodeID string
+	match  map[string]string
+}
+
+// parseSelector parses a node ID or a comma-separated list of key=value pairs, such as env=prod,region=eu
+func parseSelector(s string) (*selector, error) {
+	if s == "" {
+		return nil, errors.New("empty node selector")
+	}
+	if !strings.Contains(s, "=") {
+		return &selector{nodeID: s}, nil
+	}
+	match := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid selector %q: expected key=value[,key=value]", s)
+		}
+		match[key] = value
+	}
+	return &selector{match: match}, nil
+}
+
+// matches reports whether a node satisfies the selector, the way agent-svc matches command selectors:
+// a label takes precedence over an attr of the same key, and attrs are compared in their string form
+func (s *selector) matches(n *dto.NodeResponse) bool {
+	if s.nodeID != "" {
+		return n.NodeID == s.nodeID
+	}
+	for key, want := range s.match {
+		if label, ok := n.Labels[key]; ok {
+			if label != want {
+				return false
+			}
+			continue
+		}
+		if got, ok := n.Attrs[key]; !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// selectNodes returns the nodes matching a selector, sorted by node ID
+// A selector naming a single node fails if the node doesn't exist; key=value selectors may match none.
+func selectNodes(ctx context.Context, e *env, sel *selector) ([]dto.NodeResponse, error) {
+	if sel.nodeID != "" {
+		node, err := e.client.GetNode(ctx, sel.nodeID)
+		if err != nil {
+			return nil, err
+		}
+		return []dto.NodeResponse{*node}, nil
+	}
+
+	nodes, err := e.client.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var selected []dto.NodeResponse
+	for i := range nodes {
+		if sel.matches(&nodes[i]) {
+			selected = append(selected, nodes[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].NodeID < selected[j].NodeID })
+	return selected, nil
+}
+
+// nodeState summarizes whether a node takes commands
+func nodeState(n *dto.NodeResponse) string {
+	switch {
+	case n.Disabled:
+		return "disabled"
+	case n.IsHealthy:
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
+}
+
+func nodesListCommand() *command {
+	var sel string
+	return &command{
+		usage:   "nodes list [-l selector]",
+		summary: "List the nodes of the tenant.\nWith -l, only nodes matching key=value[,key=value] on their labels or attrs.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&sel, "l", "", "only nodes matching this selector")
+		},
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) > 0 {
+				return usageErrorf("unexpected arguments %s", strings.Join(args, " "))
+			}
+			var nodes []dto.NodeResponse
+			var err error
+			if sel == "" {
+				nodes, err = e.client.ListNodes(ctx)
+				sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+			} else {
+				var s *selector
+				if s, err = parseSelector(sel); err != nil {
+					return usageErrorf("%v", err)
+				}
+				if s.nodeID != "" {
+					return usageErrorf("-l takes key=value pairs; use nodes show for a single node")
+				}
+				nodes, err = selectNodes(ctx, e, s)
+			}
+			if err != nil {
+				return err
+			}
+			if nodes == nil {
+				nodes = []dto.NodeResponse{}
+			}
+
+			return e.print.print(nodes, func(w io.Writer) {
+				rows := make([][]string, 0, len(nodes))
+				for i := range nodes {
+					n := &nodes[i]
+					rows = append(rows, []string{n.NodeID, nodeState(n), formatAge(n.LastSeenAt), formatPairs(n.Labels)})
+				}
+				table(w, []string{"NODE", "STATE", "LAST SEEN", "LABELS"}, rows)
+			})
+		},
+	}
+}
+
+func nodesShowCommand() *command {
+	return &command{
+		usage:   "nodes show <node-id>",
+		summary: "Show a node with its labels and attrs.",
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected one node ID")
+			}
+			node, err := e.client.GetNode(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return e.print.print(node, func(w io.Writer) {
+				printNode(w, node)
+			})
+		},
+	}
+}
+
+// printNode prints a node for humans
+func printNode(w io.Writer, n *dto.NodeResponse) {
+	fmt.Fprintf(w, "Node:       %s\n", n.NodeID)
+	fmt.Fprintf(w, "Tenant:     %s\n", n.TenantID)
+	fmt.Fprintf(w, "State:      %s\n", nodeState(n))
+	lastSeen := n.LastSeenAt
+	if _, err := time.Parse(time.RFC3339, n.LastSeenAt); err == nil {
+		lastSeen = fmt.Sprintf("%s (%s ago)", n.LastSeenAt, formatAge(n.LastSeenAt))
+	}
+	fmt.Fprintf(w, "Last seen:  %s\n", lastSeen)
+	fmt.Fprintln(w, "Labels:")
+	printPairs(w, n.Labels)
+	attrs := make(map[string]string, len(n.Attrs))
+	for key, value := range n.Attrs {
+		attrs[key] = fmt.Sprint(value)
+	}
+	fmt.Fprintln(w, "Attrs:")
+	printPairs(w, attrs)
+}
+
+// printPairs prints a map one sorted key=value pair per line
+func printPairs(w io.Writer, m map[string]string) {
+	if len(m) == 0 {
+		fmt.Fprintln(w, "  (none)")
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s=%s\n", key, m[key])
+	}
+}
+
+func nodesLabelCommand() *command {
+	return &command{
+		usage:   "nodes label <node-id> key=value... key-...",
+		summary: "Set or remove node labels.\nkey=value sets a label, key- removes it. Labels can be used in selectors of run and commands.",
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) < 2 {
+				return usageErrorf("expected a node ID and at least one label")
+			}
+			labels := map[string]*string{}
+			for _, arg := range args[1:] {
+				if key, value, ok := strings.Cut(arg, "="); ok {
+					if key == "" {
+						return usageErrorf("invalid label %q", arg)
+					}
+					labels[key] = &value
+				} else if key := strings.TrimSuffix(arg, "-"); key != arg && key != "" {
+					labels[key] = nil
+				} else {
+					return usageErrorf("invalid label %q: use key=value to set or key- to remove", arg)
+				}
+			}
+
+			node, err := e.client.UpdateNode(ctx, args[0], dto.UpdateNodeRequest{Labels: labels})
+			if err != nil {
+				return err
+			}
+			return e.print.print(node, func(w io.Writer) {
+				fmt.Fprintf(w, "%s labels: %s\n", node.NodeID, formatPairs(node.Labels))
+			})
+		},
+	}
+}
+
+// nodesDisableCommand returns nodes disable, or nodes enable if disabled is false
+func nodesDisableCommand(disabled bool) *command {
+	verb, summary := "disable", "Disable nodes: agent-svc rejects new commands for them and selectors skip them."
+	if !disabled {
+		verb, summary = "enable", "Enable disabled nodes again."
+	}
+	return &command{
+		usage:   "nodes " + verb + " <node-id>...",
+		summary: summary,
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected at least one node ID")
+			}
+			nodes := make([]*dto.NodeResponse, 0, len(args))
+			failed := 0
+			for _, nodeID := range args {
+				node, err := e.client.UpdateNode(ctx, nodeID, dto.UpdateNodeRequest{Disabled: &disabled})
+				if err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					fmt.Fprintf(e.stderr, "%s: %v\n", nodeID, err)
+					failed++
+					continue
+				}
+				nodes = append(nodes, node)
+			}
+
+			err := e.print.print(nodes, func(w io.Writer) {
+				for _, n := range nodes {
+					fmt.Fprintf(w, "%s %sd\n", n.NodeID, verb)
+				}
+			})
+			if err == nil && failed > 0 {
+				err = &exitError{code: exitFailure}
+			}
+			return err
+		},
+	}
+}
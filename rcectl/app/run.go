@@ -0,0 +1,288 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-svc/client"
+	"agent-svc/client/dto"
+)
+
+// runResult is the outcome of a run on one node
+type runResult struct {
+	NodeID    string `json:"node_id"`
+	CommandID string `json:"command_id,omitempty"`
+	Status    string `json:"status"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+}
+
+func runCommand() *command {
+	var timeout time.Duration
+	var priority int
+	var noPrefix bool
+	return &command{
+		usage: "run [flags] <selector> -- <command>...",
+		summary: "Run a shell command on a node, or on every enabled node matching key=value[,key=value], and stream its output.\n" +
+			"Text output prefixes every line with [node]; json and yaml print the results once all nodes are done.\n" +
+			"A run on a single node exits with the command's exit code (124 if it timed out); a run on several nodes exits 1 if any failed.",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&timeout, "timeout", 0, "time the command may run on the node (default: node-agent's 5m)")
+			fs.IntVar(&priority, "priority", 5, "priority from 0 (lowest) to 9 (highest)")
+			fs.BoolVar(&noPrefix, "no-prefix", false, "don't prefix output lines with the node ID")
+		},
+		run: func(ctx context.Context, e *env, args []string) error {
+			if len(args) < 3 || args[1] != "--" {
+				return usageErrorf("expected <selector> -- <command>")
+			}
+			sel, err := parseSelector(args[0])
+			if err != nil {
+				return usageErrorf("%v", err)
+			}
+			if priority < 0 || priority > 9 {
+				return usageErrorf("priority must be between 0 and 9")
+			}
+
+			nodes, err := selectNodes(ctx, e, sel)
+			if err != nil {
+				return err
+			}
+			var targets []string
+			for i := range nodes {
+				// A node named explicitly is submitted to even if disabled, so agent-svc reports why it can't run
+				if sel.nodeID != "" || !nodes[i].Disabled {
+					targets = append(targets, nodes[i].NodeID)
+				}
+			}
+			if len(targets) == 0 {
+				return &exitError{code: exitFailure, msg: fmt.Sprintf("no enabled nodes match %s", args[0])}
+			}
+
+			// Like ssh, the words after -- form one shell command line
+			payload := map[string]interface{}{"cmd": strings.Join(args[2:], " ")}
+			if timeout > 0 {
+				payload["timeout_sec"] = int((timeout + time.Second - 1) / time.Second)
+			}
+			req := dto.SubmitCommandRequest{CommandType: "RunCommand", Payload: payload, Priority: &priority}
+
+			r := &runner{env: e, text: e.print.format == FormatText, prefix: !noPrefix}
+			results := r.run(ctx, req, targets)
+			if ctx.Err() != nil {
+				r.cancelUnfinished(results)
+				return ctx.Err()
+			}
+
+			if !r.text {
+				if err := e.print.print(results, nil); err != nil {
+					return err
+				}
+			}
+			return runExit(results)
+		},
+	}
+}
+
+// runner submits a command to nodes and follows its output
+type runner struct {
+	env    *env
+	text   bool // stream output as it arrives, instead of collecting it into the results
+	prefix bool // prefix streamed lines with [node]
+	mu     sync.Mutex
+}
+
+// run submits req to every node and waits until each command finished, returning the results in node order
+func (r *runner) run(ctx context.Context, req dto.SubmitCommandRequest, nodeIDs []string) []*runResult {
+	runID := newRunID()
+	results := make([]*runResult, len(nodeIDs))
+	var wg sync.WaitGroup
+	for i, nodeID := range nodeIDs {
+		result := &runResult{NodeID: nodeID}
+		results[i] = result
+
+		// The idempotency key makes the submission safe to retry on connection errors
+		nodeReq := req
+		nodeReq.NodeID = nodeID
+		nodeReq.IdempotencyKey = "rcectl-" + runID + "-" + nodeID
+		resp, err := r.env.client.SubmitCommand(ctx, nodeReq)
+		if err != nil {
+			result.Status, result.Error = "not_submitted", err.Error()
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		result.CommandID = resp.CommandID
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.follow(ctx, result)
+		}()
+	}
+	wg.Wait()
+
+	if r.text && ctx.Err() == nil {
+		for _, result := range results {
+			if result.Status != "success" {
+				fmt.Fprintln(r.env.stderr, describeResult(result))
+			}
+		}
+	}
+	return results
+}
+
+// follow streams the output of a submitted command and records how it finished
+func (r *runner) follow(ctx context.Context, result *runResult) {
+	var stdout, stderr strings.Builder
+	out := map[string]*lineWriter{}
+	if r.text {
+		prefix := ""
+		if r.prefix {
+			prefix = "[" + result.NodeID + "] "
+		}
+		out["stdout"] = &lineWriter{mu: &r.mu, out: r.env.stdout, prefix: prefix}
+		out["stderr"] = &lineWriter{mu: &r.mu, out: r.env.stderr, prefix: prefix}
+	}
+
+	err := r.env.client.StreamCommandLogs(ctx, result.CommandID, client.StreamOptions{PollInterval: 500 * time.Millisecond},
+		func(chunk dto.LogChunkResponse) error {
+			switch {
+			case out[chunk.Stream] != nil:
+				out[chunk.Stream].write(chunk.Data)
+			case chunk.Stream == "stderr":
+				stderr.WriteString(chunk.Data)
+			default:
+				stdout.WriteString(chunk.Data)
+			}
+			return nil
+		})
+	for _, w := range out {
+		w.flush()
+	}
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	if err != nil {
+		result.Status, result.Error = "unknown", err.Error()
+		return
+	}
+
+	cmd, err := r.env.client.GetCommand(ctx, result.CommandID)
+	if err != nil {
+		result.Status, result.Error = "unknown", err.Error()
+		return
+	}
+	result.Status, result.ExitCode = cmd.Status, cmd.ExitCode
+	if cmd.ErrorMsg != nil {
+		result.Error = *cmd.ErrorMsg
+	}
+}
+
+// cancelUnfinished cancels the commands of an interrupted run that haven't been dispatched yet
+// Commands already running on their node can't be stopped, so their IDs are printed for rcectl logs -f.
+func (r *runner) cancelUnfinished(results []*runResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, result := range results {
+		if result.CommandID == "" || client.IsTerminalStatus(result.Status) {
+			continue
+		}
+		if _, err := r.env.client.CancelCommand(ctx, result.CommandID); err == nil {
+			fmt.Fprintf(r.env.stderr, "%s: cancelled %s before it was dispatched\n", result.NodeID, result.CommandID)
+		} else {
+			fmt.Fprintf(r.env.stderr, "%s: %s keeps running; follow it with rcectl logs %s -f\n", result.NodeID, result.CommandID, result.CommandID)
+		}
+	}
+}
+
+// runExit returns the error rcectl run ends with: nil if every command succeeded, the command's exit code
+// for a failed run on a single node, and exit code 1 for a failed run on several nodes
+func runExit(results []*runResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Status != "success" {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	if len(results) > 1 {
+		return &exitError{code: exitFailure, msg: fmt.Sprintf("failed on %d of %d nodes", failed, len(results))}
+	}
+
+	result := results[0]
+	switch {
+	case result.Status == "timeout":
+		return &exitError{code: exitTimeout}
+	case result.ExitCode != nil && *result.ExitCode > 0 && *result.ExitCode < 256:
+		return &exitError{code: *result.ExitCode}
+	default:
+		return &exitError{code: exitFailure}
+	}
+}
+
+// describeResult describes an unsuccessful result in one line
+func describeResult(result *runResult) string {
+	s := result.NodeID + ": " + result.Status
+	if result.ExitCode != nil {
+		s += fmt.Sprintf(" (exit %d)", *result.ExitCode)
+	}
+	if result.Error != "" {
+		s += ": " + result.Error
+	}
+	return s
+}
+
+// newRunID returns a random ID for the idempotency keys of one run
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// lineWriter writes a stream of output line by line, prefixing each line, so lines of concurrent streams
+// sharing mu don't interleave
+type lineWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+// write writes the complete lines of data and keeps the rest for the next call
+func (w *lineWriter) write(data string) {
+	w.buf = append(w.buf, data...)
+	end := strings.LastIndexByte(string(w.buf), '\n')
+	if end < 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, line := range strings.SplitAfter(string(w.buf[:end+1]), "\n") {
+		if line != "" {
+			io.WriteString(w.out, w.prefix+line)
+		}
+	}
+	w.buf = w.buf[end+1:]
+}
+
+// flush writes a final line that doesn't end in a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	io.WriteString(w.out, w.prefix+string(w.buf)+"\n")
+	w.buf = nil
+}
@@ -0,0 +1,10 @@
+module rcectl
+
+go 1.21
+
+require (
+	agent-svc/client v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace agent-svc/client => ../agent-svc/client